@@ -0,0 +1,240 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/shyngys9219/greenlight/internal/data"
+	"github.com/shyngys9219/greenlight/internal/validator"
+)
+
+// Besides the rate limiter, which slows down requests of every kind, users' writes are
+// counted by kind over -abuse-window. An account which writes more than the limit of a
+// kind is restricted, as -abuse-action says: held, so that it can't write at all, or
+// shadow-limited, so that its reviews are only shown to itself. The restriction stays
+// until an admin lifts it, and accounts an admin exempts are never restricted.
+
+// The kinds of writes which are counted.
+const (
+	writeReview = "review"
+	writeMovie  = "movie"
+)
+
+// The values of -abuse-action.
+const (
+	abuseHold   = "hold"
+	abuseShadow = "shadow"
+)
+
+// abuseEventRetention is how long the recorded writes are kept, which is the longest
+// -abuse-window can be.
+const abuseEventRetention = 24 * time.Hour
+
+// The checkWriteVelocity() method counts a write of the kind by the user making the
+// request, and returns the restriction of their account, such as data.RestrictionHeld,
+// or "" if it isn't restricted. Going over the limit restricts it there and then.
+// Errors are logged rather than returned, so that a problem counting doesn't stop
+// anyone from writing.
+func (app *application) checkWriteVelocity(r *http.Request, kind string) string {
+	user := app.contextGetUser(r)
+	if user.IsAnonymous() {
+		return ""
+	}
+	models := app.modelsFor(r)
+
+	status, err := models.Abuse.GetRestriction(user.ID)
+	switch {
+	case err == nil:
+		if status == data.RestrictionExempt {
+			return ""
+		}
+		return status
+	case !errors.Is(err, data.ErrRecordNotFound):
+		app.logError(r, err)
+		return ""
+	}
+
+	limit := app.config.abuse.reviewLimit
+	if kind == writeMovie {
+		limit = app.config.abuse.movieLimit
+	}
+	if limit == 0 {
+		return ""
+	}
+	count, err := models.Abuse.RecordWrite(user.ID, kind, time.Now().Add(-app.config.abuse.window))
+	if err != nil {
+		app.logError(r, err)
+		return ""
+	}
+	if count <= limit {
+		return ""
+	}
+
+	status = data.RestrictionHeld
+	if app.config.abuse.action == abuseShadow {
+		status = data.RestrictionShadowLimited
+	}
+	reason := fmt.Sprintf("%d %s writes within %s", count, kind, app.config.abuse.window)
+	restricted, err := models.Abuse.Restrict(user.ID, status, reason)
+	if err != nil {
+		app.logError(r, err)
+		return ""
+	}
+	if restricted {
+		app.requestLogger(r).PrintInfo("account restricted", map[string]string{
+			"user_id": strconv.FormatInt(user.ID, 10),
+			"status":  status,
+			"reason":  reason,
+		})
+	}
+	return status
+}
+
+// The deleteOldWriteEvents() job deletes the recorded writes once they're too old to
+// count towards any limit.
+func (app *application) deleteOldWriteEvents() error {
+	return app.models.Abuse.DeleteEventsBefore(time.Now().Add(-abuseEventRetention))
+}
+
+// The listAccountRestrictionsHandler for the "GET /v1/admin/abuse/restrictions"
+// endpoint shows a page of the restricted accounts, the most recently changed first,
+// with the numbers of writes each one made within -abuse-window. They can be filtered
+// with ?status=.
+func (app *application) listAccountRestrictionsHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	qs := r.URL.Query()
+	status := app.readString(qs, "status", "")
+	filters := data.Filters{
+		Page:         app.readInt(qs, "page", 1, v),
+		PageSize:     app.readInt(qs, "page_size", 20, v),
+		Sort:         app.readString(qs, "sort", "-updated_at"),
+		SortSafelist: data.AccountRestrictionsSortSafelist,
+	}
+	v.Check(status == "" || validator.PermittedValue(status, data.RestrictionHeld, data.RestrictionShadowLimited, data.RestrictionExempt), "status", "must be held, shadow_limited or exempt")
+	if data.ValidateListFilters(v, filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	since := time.Now().Add(-app.config.abuse.window)
+	restrictions, metadata, err := app.modelsFor(r).Abuse.GetAll(status, since, filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"restrictions": restrictions, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The setAccountRestrictionHandler for the "PUT /v1/admin/users/:id/restriction"
+// endpoint sets the restriction of a user's account, replacing the one it has: held,
+// shadow_limited, or exempt from the automatic restrictions. Unless the account is
+// shadow-limited its hidden reviews are shown again.
+func (app *application) setAccountRestrictionHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		Status string `json:"status"`
+		Reason string `json:"reason"`
+	}
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	user, err := app.modelsFor(r).Users.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	admin := app.contextGetUser(r)
+	restriction := &data.AccountRestriction{
+		UserID:       user.ID,
+		UserPublicID: user.PublicID,
+		UserEmail:    user.Email,
+		Status:       input.Status,
+		Reason:       input.Reason,
+		CreatedBy:    &admin.ID,
+	}
+
+	v := validator.New()
+	if data.ValidateAccountRestriction(v, restriction); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	previous, err := app.modelsFor(r).Abuse.Set(restriction)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	app.auditAccountRestriction(r, "account restriction set", user.ID, previous, restriction.Status, restriction.Reason)
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"restriction": restriction}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The deleteAccountRestrictionHandler for the "DELETE /v1/admin/users/:id/restriction"
+// endpoint lifts the restriction of a user's account and shows its hidden reviews
+// again. The account can be restricted automatically again afterwards.
+func (app *application) deleteAccountRestrictionHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	previous, err := app.modelsFor(r).Abuse.Delete(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	app.auditAccountRestriction(r, "account restriction deleted", id, previous, "", "")
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "account restriction successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The auditAccountRestriction() helper records a change of a restriction, with who made
+// it and the status before and after, in the application log.
+func (app *application) auditAccountRestriction(r *http.Request, message string, userID int64, before, after, reason string) {
+	describe := func(status string) string {
+		if status == "" {
+			return "none"
+		}
+		return status
+	}
+	app.requestLogger(r).PrintInfo(message, map[string]string{
+		"admin_id": strconv.FormatInt(app.contextGetUser(r).ID, 10),
+		"user_id":  strconv.FormatInt(userID, 10),
+		"before":   describe(before),
+		"after":    describe(after),
+		"reason":   reason,
+	})
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// The logRequests() middleware writes an entry to the log for every request once its
+// response has been sent, with the request ID, the method and path, the status, the
+// size of the body, how long it took, the client's IP address and the user's ID if
+// they're authenticated. The query string isn't logged, as it can hold search terms
+// and email addresses.
+//
+// -access-log-sample sets the fraction of requests logged, so that busy or development
+// servers can log fewer, or none at all with 0. Responses with a 5xx status are always
+// logged unless the access log is off.
+func (app *application) logRequests(next http.Handler) http.Handler {
+	sample := app.config.accessLog.sample
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if sample <= 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		start := time.Now()
+		mw := &metricsResponseWriter{ResponseWriter: w}
+		next.ServeHTTP(mw, r)
+
+		status := mw.status()
+		if status < 500 && sample < 1 && rand.Float64() >= sample {
+			return
+		}
+
+		ip, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			ip = r.RemoteAddr
+		}
+		properties := map[string]string{
+			"method":      r.Method,
+			"path":        r.URL.Path,
+			"status":      strconv.Itoa(status),
+			"bytes":       strconv.FormatInt(mw.bytes, 10),
+			"duration_ms": strconv.FormatFloat(float64(time.Since(start).Microseconds())/1000, 'f', 3, 64),
+			"client_ip":   ip,
+		}
+		if meta := contextGetResponseMetadata(r.Context()); meta != nil && meta.userID != 0 {
+			properties["user_id"] = strconv.FormatInt(meta.userID, 10)
+		}
+		app.requestLogger(r).PrintInfo("request", properties)
+	})
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"expvar"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/shyngys9219/greenlight/internal/data"
+)
+
+// activationResendInterval is the minimum time between two activation emails for the
+// same user, so failed logins can't be used to flood someone's inbox.
+const activationResendInterval = 15 * time.Minute
+
+// activationMetrics counts what happens to new accounts: how many registered or were
+// imported, were activated, got a reminder, or were deleted because their activation
+// window lapsed.
+var activationMetrics = expvar.NewMap("activation")
+
+func init() {
+	// activation_conversion is the share of accounts registered since the application
+	// started which have been activated.
+	expvar.Publish("activation_conversion", expvar.Func(func() any {
+		registered := activationCount("registered")
+		if registered == 0 {
+			return 0.0
+		}
+		return float64(activationCount("activated")) / float64(registered)
+	}))
+}
+
+func activationCount(key string) int64 {
+	if v, ok := activationMetrics.Get(key).(*expvar.Int); ok {
+		return v.Value()
+	}
+	return 0
+}
+
+// The sendActivationEmail() helper generates a new activation token for the user and
+// sends it in the background with a notification of the given type. The requestID is
+// that of the request which asked for it, or "" for a job.
+func (app *application) sendActivationEmail(user *data.User, notificationType, requestID string) error {
+	// token generation to activate account
+	token, err := app.models.Tokens.New(user.ID, app.config.activation.ttl, data.ScopeActivation)
+	if err != nil {
+		return err
+	}
+	app.mailActivationToken(user, token, notificationType, requestID)
+	return nil
+}
+
+// The activationURL() helper returns the link which activates an account with the
+// given token in a browser, or "" if -public-url isn't set.
+func (app *application) activationURL(token string) string {
+	if app.config.publicURL == "" {
+		return ""
+	}
+	return strings.TrimRight(app.config.publicURL, "/") + "/v1/users/activate?token=" + url.QueryEscape(token)
+}
+
+// The mailActivationToken() helper emails an activation token in the background.
+func (app *application) mailActivationToken(user *data.User, token *data.Token, notificationType, requestID string) {
+	// Send the notification with the notifier, passing in the user, the type of the
+	// notification, and the data of the new user's token.
+	app.background(backgroundTask{
+		name:      "activation_email",
+		requestID: requestID,
+		attempts:  emailTaskAttempts,
+		backoff:   emailTaskBackoff,
+		fn: func() error {
+			//
+			data := map[string]any{
+				"activationToken":  token.Plaintext,
+				"activationURL":    app.activationURL(token.Plaintext),
+				"activationExpiry": token.Expiry.UTC().Format("Mon, 02 Jan 2006 15:04 MST"),
+				"userID":           user.ID,
+				"name":             user.Name,
+			}
+
+			// the notifier renders the email with the context data and queues it; the
+			// email workers send it and retry it if need be. If there is an error
+			// queueing the email, background() logs it for us instead of the
+			// app.serverErrorResponse() helper like before.
+			return app.notifier.Send(userRecipient(user), notificationType, data)
+		},
+	})
+}
+
+// The resendActivationEmail() helper sends a fresh activation email to a user who
+// hasn't activated their account, unless one was already sent within the last
+// activationResendInterval. It reports whether an email was sent.
+func (app *application) resendActivationEmail(r *http.Request, user *data.User) (bool, error) {
+	last, err := app.models.Tokens.LastIssuedAt(data.ScopeActivation, user.ID)
+	if err != nil {
+		return false, err
+	}
+	if time.Since(last) < activationResendInterval {
+		return false, nil
+	}
+	err = app.sendActivationEmail(user, notifyActivation, contextGetRequestID(r.Context()))
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// The sendActivationReminders() job reminds users whose activation token is about to
+// expire. Only the hash of a token is stored, so each reminder carries a new token;
+// it expires at the same time as the old one, so reminders never extend the
+// activation window.
+func (app *application) sendActivationReminders() error {
+	pending, err := app.models.Users.ClaimActivationReminders(time.Now().Add(app.config.activation.reminderBefore))
+	if err != nil {
+		return err
+	}
+	for _, p := range pending {
+		token, err := app.models.Tokens.NewWithExpiry(p.User.ID, p.Expiry, data.ScopeActivation)
+		if err != nil {
+			return err
+		}
+		app.mailActivationToken(p.User, token, notifyActivationReminder, "")
+		activationMetrics.Add("reminded", 1)
+	}
+	return nil
+}
+
+// The sendQueuedActivations() job sends the activation emails of imported users, a batch
+// a minute, so a large import doesn't flood the mail server. Imported users get the
+// welcome email, with a token valid for the usual activation window from when it's sent.
+func (app *application) sendQueuedActivations() error {
+	users, err := app.models.Users.ClaimQueuedActivations(app.config.activation.importBatch)
+	if err != nil {
+		return err
+	}
+	for _, user := range users {
+		err = app.sendActivationEmail(user, notifyWelcome, "")
+		if err != nil {
+			return err
+		}
+		activationMetrics.Add("import_emails_sent", 1)
+	}
+	return nil
+}
+
+// The deleteLapsedAccounts() job deletes accounts which were never activated and whose
+// activation window has closed.
+func (app *application) deleteLapsedAccounts() error {
+	deleted, err := app.models.Users.DeleteLapsedUnactivated(time.Now().Add(-app.config.activation.ttl))
+	if err != nil {
+		return err
+	}
+	if deleted > 0 {
+		activationMetrics.Add("lapsed_deleted", deleted)
+		app.logger.PrintInfo("deleted lapsed unactivated accounts", map[string]string{
+			"count": strconv.FormatInt(deleted, 10),
+		})
+	}
+	return nil
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/shyngys9219/greenlight/internal/data"
+	"github.com/shyngys9219/greenlight/internal/events"
+	"github.com/shyngys9219/greenlight/internal/validator"
+)
+
+// Curators record where each movie is available, and when it's released there, region
+// by region. Every change is published as an events.AvailabilityChanged event, which
+// partners receive through their webhooks (see webhooks.go).
+
+// An availabilityChange is the payload of an events.AvailabilityChanged event. Before
+// and After are nil when the movie had, or has, no availability in the region.
+type availabilityChange struct {
+	Movie  *data.Movie
+	Region string
+	Before *data.MovieAvailability
+	After  *data.MovieAvailability
+}
+
+// The readRegionParam() helper reads the "region" URL parameter, which is a country
+// code in either case.
+func (app *application) readRegionParam(r *http.Request) string {
+	return strings.ToUpper(httprouter.ParamsFromContext(r.Context()).ByName("region"))
+}
+
+// The readMovieParam() helper returns the movie named by the "id" URL
+// parameter, sending the error response and returning nil if there's none.
+func (app *application) readMovieParam(w http.ResponseWriter, r *http.Request) *data.Movie {
+	id, err := app.readMovieIDParam(r)
+	if err == nil {
+		var movie *data.Movie
+		movie, err = app.modelsFor(r).Movies.Get(id)
+		if err == nil {
+			return movie
+		}
+	}
+	switch {
+	case errors.Is(err, data.ErrRecordNotFound):
+		app.notFoundResponse(w, r)
+	default:
+		app.serverErrorResponse(w, r, err)
+	}
+	return nil
+}
+
+// The listAvailabilityHandler for the "GET /v1/movies/:id/availability" endpoint
+// returns the regions a movie has an availability in.
+func (app *application) listAvailabilityHandler(w http.ResponseWriter, r *http.Request) {
+	movie := app.readMovieParam(w, r)
+	if movie == nil {
+		return
+	}
+
+	availability, err := app.modelsFor(r).Availability.GetAllForMovie(movie.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"availability": availability}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The setAvailabilityHandler for the "PUT /v1/movies/:id/availability/:region" endpoint
+// sets whether a movie is available in a region, and its release date there. A
+// release_date of null means the date isn't known.
+func (app *application) setAvailabilityHandler(w http.ResponseWriter, r *http.Request) {
+	movie := app.readMovieParam(w, r)
+	if movie == nil {
+		return
+	}
+
+	var input struct {
+		Available   bool    `json:"available"`
+		ReleaseDate *string `json:"release_date"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	availability := &data.MovieAvailability{
+		MovieID:     movie.ID,
+		Region:      app.readRegionParam(r),
+		Available:   input.Available,
+		ReleaseDate: input.ReleaseDate,
+	}
+
+	v := validator.New()
+	if data.ValidateMovieAvailability(v, availability); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	previous, err := app.modelsFor(r).Availability.Set(availability)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	app.publish(events.AvailabilityChanged, &availabilityChange{
+		Movie:  movie,
+		Region: availability.Region,
+		Before: previous,
+		After:  availability,
+	})
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"availability": availability}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The deleteAvailabilityHandler for the "DELETE /v1/movies/:id/availability/:region"
+// endpoint removes a movie's availability in a region, so that it's no longer
+// available there and has no release date.
+func (app *application) deleteAvailabilityHandler(w http.ResponseWriter, r *http.Request) {
+	movie := app.readMovieParam(w, r)
+	if movie == nil {
+		return
+	}
+
+	region := app.readRegionParam(r)
+	previous, err := app.modelsFor(r).Availability.Delete(movie.ID, region)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	app.publish(events.AvailabilityChanged, &availabilityChange{
+		Movie:  movie,
+		Region: region,
+		Before: previous,
+	})
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "availability successfully removed"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/shyngys9219/greenlight/internal/billing"
+	"github.com/shyngys9219/greenlight/internal/data"
+)
+
+// billingGracePeriod is how long an account keeps its paid plan after a payment fails,
+// while the billing provider retries the payment.
+const billingGracePeriod = 3 * 24 * time.Hour
+
+// The createCheckoutHandler for the "POST /v1/billing/checkout" endpoint starts a
+// checkout for the pro plan, for the current user or, if an organization's slug is
+// given, for an organization the user owns. The client sends the user to the returned
+// URL to pay; the plan changes once the billing provider's webhook reports it.
+func (app *application) createCheckoutHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Organization string `json:"organization"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	user := app.contextGetUser(r)
+	account := data.Account{Kind: data.AccountUser, ID: user.ID}
+	email := user.Email
+
+	if input.Organization != "" {
+		org, err := app.modelsFor(r).Organizations.GetBySlug(input.Organization)
+		if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		role := ""
+		if org != nil {
+			role, err = app.modelsFor(r).Organizations.GetRole(org.ID, user.ID)
+			if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+				app.serverErrorResponse(w, r, err)
+				return
+			}
+		}
+		switch {
+		case role == "":
+			app.notFoundResponse(w, r)
+			return
+		case role != data.RoleOwner:
+			app.notPermittedResponse(w, r)
+			return
+		}
+		account = data.Account{Kind: data.AccountOrganization, ID: org.ID}
+		email = ""
+	}
+
+	customerID, err := app.modelsFor(r).Billing.GetCustomerID(account)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	session, err := app.stripe.CreateCheckoutSession(billing.CheckoutParams{
+		PriceID:    app.config.stripe.proPrice,
+		Reference:  account.String(),
+		CustomerID: customerID,
+		Email:      email,
+		SuccessURL: app.config.stripe.successURL,
+		CancelURL:  app.config.stripe.cancelURL,
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, billing.ErrNotConfigured):
+			app.billingNotConfiguredResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"checkout": session}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The billingWebhookHandler for the "POST /v1/billing/webhook" endpoint receives
+// events from Stripe. The endpoint is public, so every event's signature is checked
+// before anything else. Events are applied at most once (Stripe may deliver an event
+// more than once), and events which don't concern any account are acknowledged and
+// dropped, since Stripe would otherwise keep retrying them.
+func (app *application) billingWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	payload, err := io.ReadAll(http.MaxBytesReader(w, r.Body, 65_536))
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	event, err := app.stripe.ParseEvent(payload, r.Header.Get("Stripe-Signature"), time.Now())
+	if err != nil {
+		switch {
+		case errors.Is(err, billing.ErrNotConfigured):
+			app.billingNotConfiguredResponse(w, r)
+		default:
+			app.badRequestResponse(w, r, err)
+		}
+		return
+	}
+
+	be, err := app.billingEvent(r, event)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	applied := false
+	if be != nil {
+		applied, err = app.modelsFor(r).Billing.Apply(be)
+		if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+	app.requestLogger(r).PrintInfo("billing event received", map[string]string{
+		"event_id": event.ID,
+		"type":     event.Type,
+		"applied":  strconv.FormatBool(applied),
+	})
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"received": true}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The billingEvent() helper translates a Stripe event into the changes it makes to an
+// account. It returns nil for events which don't change anything, or whose account
+// can't be found.
+func (app *application) billingEvent(r *http.Request, event *billing.Event) (*data.BillingEvent, error) {
+	obj := event.Data.Object
+	be := &data.BillingEvent{ID: event.ID, Type: event.Type, CustomerID: obj.Customer}
+
+	ref := obj.ClientReferenceID
+	if ref == "" {
+		ref = obj.Metadata["account"]
+	}
+	var err error
+	if ref != "" {
+		be.Account, err = data.ParseAccount(ref)
+		if err != nil {
+			return nil, nil
+		}
+	} else {
+		be.Account, err = app.modelsFor(r).Billing.GetAccountForCustomer(obj.Customer)
+		if err != nil {
+			if errors.Is(err, data.ErrRecordNotFound) {
+				return nil, nil
+			}
+			return nil, err
+		}
+	}
+
+	pro := &data.Subscription{Plan: data.PlanPro, Source: billing.Source}
+	free := &data.Subscription{Plan: data.PlanFree, Source: billing.Source}
+
+	switch event.Type {
+	case "checkout.session.completed":
+		// The subscription's own events follow; this one only links the customer.
+	case "customer.subscription.created", "customer.subscription.updated":
+		if app.config.stripe.proPrice != "" && !obj.HasPrice(app.config.stripe.proPrice) {
+			return nil, nil
+		}
+		switch obj.Status {
+		case "active", "trialing":
+			be.Subscription = pro
+		case "past_due":
+			expiresAt := time.Now().Add(billingGracePeriod)
+			pro.ExpiresAt = &expiresAt
+			be.Subscription = pro
+		default:
+			be.Subscription = free
+		}
+	case "customer.subscription.deleted":
+		be.Subscription = free
+	case "invoice.payment_failed":
+		expiresAt := time.Now().Add(billingGracePeriod)
+		pro.ExpiresAt = &expiresAt
+		be.Subscription = pro
+	default:
+		return nil, nil
+	}
+	return be, nil
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/shyngys9219/greenlight/internal/events"
+	"github.com/shyngys9219/greenlight/internal/metrics"
+	"github.com/shyngys9219/greenlight/internal/validator"
+)
+
+// The business metrics count what users do, rather than how the server is doing. They
+// are counted in-process from the domain events, and exported two ways: to Prometheus
+// at GET /metrics, and to the business_metrics_daily table, which every replica adds
+// its counts to every businessRollupInterval. GET /v1/admin/metrics/daily reads the
+// table back, so the numbers can be had without access to the database.
+
+// businessRollupInterval is how often each replica adds its counts to the daily totals.
+// Counts taken just before midnight (UTC) may land on the next day.
+const businessRollupInterval = time.Minute
+
+// businessGaugesInterval is how often the catalog and user base are counted.
+const businessGaugesInterval = 5 * time.Minute
+
+var (
+	businessMetrics = metrics.NewRegistry()
+
+	signups         = businessMetrics.Counter("greenlight_signups_total", "Users who signed up.")
+	activations     = businessMetrics.Counter("greenlight_activations_total", "Users who activated their account.")
+	moviesCreated   = businessMetrics.Counter("greenlight_movies_created_total", "Movies added to the catalog.")
+	reviewsPosted   = businessMetrics.Counter("greenlight_reviews_posted_total", "Reviews posted.")
+	usersGauge      = businessMetrics.Gauge("greenlight_users", "Users, activated or not.")
+	activatedGauge  = businessMetrics.Gauge("greenlight_activated_users", "Users who have activated their account.")
+	moviesGauge     = businessMetrics.Gauge("greenlight_movies", "Movies in the catalog.")
+	reviewsGauge    = businessMetrics.Gauge("greenlight_reviews", "Reviews of movies.")
+	businessCounter = map[string]*metrics.Counter{
+		events.UserRegistered: signups,
+		events.UserActivated:  activations,
+		events.MovieCreated:   moviesCreated,
+		events.ReviewPosted:   reviewsPosted,
+	}
+)
+
+// The registerBusinessMetrics() method counts the domain events. Counting is cheap, so
+// the counters are subscribed directly rather than through subscribe().
+func (app *application) registerBusinessMetrics() {
+	for eventType, counter := range businessCounter {
+		counter := counter
+		app.events.Subscribe(eventType, func(events.Event) { counter.Inc() })
+	}
+}
+
+// The rollupBusinessMetrics() job adds what this replica counted since its last run to
+// today's totals. If they can't be stored the counts are kept for the next run. It's
+// also called once on shutdown, after the background tasks are done.
+func (app *application) rollupBusinessMetrics() error {
+	counts := businessMetrics.Take()
+	if len(counts) == 0 {
+		return nil
+	}
+	err := app.models.BusinessMetrics.AddDaily(time.Now(), counts)
+	if err != nil {
+		businessMetrics.Restore(counts)
+		return err
+	}
+	return nil
+}
+
+// The refreshBusinessGauges() job sets the gauges to the current size of the catalog
+// and the user base.
+func (app *application) refreshBusinessGauges() error {
+	totals, err := app.models.BusinessMetrics.CatalogTotals()
+	if err != nil {
+		return err
+	}
+	usersGauge.Set(totals.Users)
+	activatedGauge.Set(totals.ActivatedUsers)
+	moviesGauge.Set(totals.Movies)
+	reviewsGauge.Set(totals.Reviews)
+	return nil
+}
+
+// The prometheusHandler for the "GET /metrics" endpoint sends the business metrics in
+// the Prometheus text format. Like GET /debug/vars, it's only exposed when the -metrics
+// flag is set.
+func (app *application) prometheusHandler(w http.ResponseWriter, r *http.Request) {
+	if !app.config.metrics {
+		app.notFoundResponse(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	err := businessMetrics.WritePrometheus(w)
+	if err != nil {
+		app.requestLogger(r).PrintError(err, nil)
+	}
+}
+
+// The dailyMetricsHandler for the "GET /v1/admin/metrics/daily" endpoint returns the
+// daily totals of the business metrics from the from day to the to day (both in the
+// form 2006-01-02, the last 30 days by default), optionally only those of one metric.
+func (app *application) dailyMetricsHandler(w http.ResponseWriter, r *http.Request) {
+	qs := r.URL.Query()
+	v := validator.New()
+
+	to := time.Now().UTC()
+	from := to.AddDate(0, 0, -29)
+	for key, day := range map[string]*time.Time{"from": &from, "to": &to} {
+		if s := qs.Get(key); s != "" {
+			t, err := time.Parse("2006-01-02", s)
+			if err != nil {
+				v.AddError(key, "must be a date in the form 2006-01-02")
+				continue
+			}
+			*day = t
+		}
+	}
+	v.Check(!to.Before(from), "to", "must not be before from")
+	v.Check(to.Sub(from) <= 366*24*time.Hour, "from", "must be at most 366 days before to")
+	metric := qs.Get("metric")
+	if metric != "" {
+		_, ok := businessMetricNames()[metric]
+		v.Check(ok, "metric", "must be the name of a business metric")
+	}
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	daily, err := app.modelsFor(r).BusinessMetrics.GetDaily(from, to, metric)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	env := envelope{
+		"from":    from.Format("2006-01-02"),
+		"to":      to.Format("2006-01-02"),
+		"metrics": daily,
+	}
+	err = app.writeJSON(w, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// businessMetricNames returns the names of the counters rolled up in the daily totals.
+func businessMetricNames() map[string]struct{} {
+	names := make(map[string]struct{}, len(businessCounter))
+	for _, c := range businessCounter {
+		names[c.Name()] = struct{}{}
+	}
+	return names
+}
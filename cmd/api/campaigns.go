@@ -0,0 +1,286 @@
+package main
+
+import (
+	"errors"
+	"expvar"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/shyngys9219/greenlight/internal/data"
+	"github.com/shyngys9219/greenlight/internal/validator"
+)
+
+// campaignEmails counts the campaign emails sent, keyed by "<campaign>.<step>".
+var campaignEmails = expvar.NewMap("campaign_emails")
+
+// campaignUnsubscribeTTL is how long the unsubscribe token in a campaign email lasts,
+// long enough for it to work from an old email. Unactivated users can't log in, so the
+// token is how they opt out.
+const campaignUnsubscribeTTL = 90 * 24 * time.Hour
+
+// campaignNotifications maps each campaign to the type of notification of its emails.
+// Every step of a campaign uses the same template; the step is passed to it, so it can
+// vary its wording.
+var campaignNotifications = map[string]string{
+	data.CampaignActivation:   notifyCampaignActivation,
+	data.CampaignReengagement: notifyCampaignReengagement,
+}
+
+// A sequence is the delays of the steps of a campaign, counted from the start of the
+// sequence (signing up for the activation campaign, the last login for re-engagement).
+// It's set from a comma-separated list of durations, such as "24h,48h"; an empty list
+// turns the campaign off.
+type sequence []time.Duration
+
+func (s *sequence) String() string {
+	if s == nil {
+		return ""
+	}
+	steps := make([]string, len(*s))
+	for i, d := range *s {
+		steps[i] = d.String()
+	}
+	return strings.Join(steps, ",")
+}
+
+func (s *sequence) Set(value string) error {
+	var steps sequence
+	for _, step := range strings.Split(value, ",") {
+		step = strings.TrimSpace(step)
+		if step == "" {
+			continue
+		}
+		d, err := time.ParseDuration(step)
+		if err != nil {
+			return err
+		}
+		if d <= 0 {
+			return fmt.Errorf("step %s must be positive", step)
+		}
+		steps = append(steps, d)
+	}
+	if !sort.SliceIsSorted(steps, func(i, j int) bool { return steps[i] < steps[j] }) {
+		return fmt.Errorf("steps must be in increasing order")
+	}
+	*s = steps
+	return nil
+}
+
+// The sequence() method returns the configured sequence of a campaign.
+func (app *application) sequence(campaign string) sequence {
+	switch campaign {
+	case data.CampaignActivation:
+		return app.config.campaigns.activation
+	case data.CampaignReengagement:
+		return app.config.campaigns.reengagement
+	}
+	return nil
+}
+
+// The runCampaigns() job sends the campaign emails which have become due, at most
+// batch emails per step per run. Activation emails carry a new activation token which
+// expires with the user's current one, so reminders never extend the activation window.
+func (app *application) runCampaigns() error {
+	for _, campaign := range data.Campaigns {
+		for step, delay := range app.sequence(campaign) {
+			recipients, err := app.models.Campaigns.ClaimDue(campaign, step, delay, app.config.campaigns.batch, app.consentDefault(data.ConsentMarketingEmail))
+			if err != nil {
+				return err
+			}
+			// A recipient whose email can't be queued is logged, and doesn't hold up
+			// the others. They were claimed, so they don't get this step again.
+			for _, recipient := range recipients {
+				err = app.sendCampaignEmail(campaign, step, recipient)
+				if err != nil {
+					app.logger.PrintError(err, map[string]string{
+						"campaign": campaign,
+						"step":     fmt.Sprint(step),
+						"user_id":  fmt.Sprint(recipient.User.ID),
+					})
+					continue
+				}
+				campaignEmails.Add(fmt.Sprintf("%s.%d", campaign, step), 1)
+			}
+		}
+	}
+	return nil
+}
+
+// The sendCampaignEmail() helper queues the email of one step of a campaign to a user,
+// with a token to unsubscribe from it.
+func (app *application) sendCampaignEmail(campaign string, step int, recipient *data.CampaignRecipient) error {
+	user := recipient.User
+	unsubscribe, err := app.models.Tokens.New(user.ID, campaignUnsubscribeTTL, data.ScopeUnsubscribe)
+	if err != nil {
+		return err
+	}
+	templateData := map[string]any{
+		"name":             user.Name,
+		"step":             step + 1,
+		"campaign":         campaign,
+		"unsubscribeToken": unsubscribe.Plaintext,
+	}
+	if campaign == data.CampaignActivation {
+		token, err := app.models.Tokens.NewWithExpiry(user.ID, recipient.ActivationExpiry, data.ScopeActivation)
+		if err != nil {
+			return err
+		}
+		templateData["activationToken"] = token.Plaintext
+		templateData["activationURL"] = app.activationURL(token.Plaintext)
+		templateData["activationExpiry"] = token.Expiry.UTC().Format("Mon, 02 Jan 2006 15:04 MST")
+	}
+	return app.notifier.Send(userRecipient(user), campaignNotifications[campaign], templateData)
+}
+
+// The listCampaignSubscriptionsHandler for the "GET /v1/users/me/campaigns" endpoint
+// returns which campaigns the current user gets emails from.
+func (app *application) listCampaignSubscriptionsHandler(w http.ResponseWriter, r *http.Request) {
+	app.writeCampaignSubscriptions(w, r, app.contextGetUser(r).ID)
+}
+
+// The updateCampaignSubscriptionHandler for the "PUT /v1/users/me/campaigns" endpoint
+// unsubscribes the current user from a campaign's emails, or from all of them with the
+// campaign "all", or subscribes them again.
+func (app *application) updateCampaignSubscriptionHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Campaign   string `json:"campaign"`
+		Subscribed *bool  `json:"subscribed"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	v.Check(validator.PermittedValue(input.Campaign, data.CampaignActivation, data.CampaignReengagement, data.CampaignAll), "campaign", "must be activation, reengagement or all")
+	v.Check(input.Subscribed != nil, "subscribed", "must be provided")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user := app.contextGetUser(r)
+	if *input.Subscribed {
+		err = app.modelsFor(r).Campaigns.Unsuppress(user.ID, input.Campaign)
+	} else {
+		err = app.modelsFor(r).Campaigns.Suppress(user.ID, input.Campaign, data.SuppressionUnsubscribed)
+	}
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeCampaignSubscriptions(w, r, user.ID)
+}
+
+// The unsubscribeCampaignHandler for the "PUT /v1/campaigns/unsubscribed" endpoint
+// unsubscribes a user from a campaign's emails, or from all of them with the campaign
+// "all", with the token from one of the emails. It needs no login, so users who never
+// activated their account can opt out of the reminders to do so.
+func (app *application) unsubscribeCampaignHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		TokenPlaintext string `json:"token"`
+		Campaign       string `json:"campaign"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	data.ValidateTokenPlaintext(v, input.TokenPlaintext)
+	v.Check(validator.PermittedValue(input.Campaign, data.CampaignActivation, data.CampaignReengagement, data.CampaignAll), "campaign", "must be activation, reengagement or all")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user, err := app.modelsFor(r).Users.GetForToken(data.ScopeUnsubscribe, input.TokenPlaintext)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("token", "invalid or expired unsubscribe token")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.modelsFor(r).Campaigns.Suppress(user.ID, input.Campaign, data.SuppressionUnsubscribed)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeCampaignSubscriptions(w, r, user.ID)
+}
+
+// The writeCampaignSubscriptions() helper sends a user's campaign subscriptions. A
+// campaign is unsubscribed if the user is suppressed from it or from all campaigns.
+func (app *application) writeCampaignSubscriptions(w http.ResponseWriter, r *http.Request, userID int64) {
+	suppressions, err := app.modelsFor(r).Campaigns.GetSuppressions(userID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	type subscription struct {
+		Campaign   string `json:"campaign"`
+		Subscribed bool   `json:"subscribed"`
+	}
+	subscriptions := []subscription{}
+	for _, campaign := range data.Campaigns {
+		_, suppressed := suppressions[campaign]
+		_, all := suppressions[data.CampaignAll]
+		subscriptions = append(subscriptions, subscription{Campaign: campaign, Subscribed: !suppressed && !all})
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"campaigns": subscriptions}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The campaignStatsHandler for the "GET /v1/admin/campaigns" endpoint reports, for the
+// last days (30 by default), how many emails each step of each campaign sent and how
+// many of them converted, together with the campaigns' current sequences and how many
+// users are suppressed from them.
+func (app *application) campaignStatsHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	days := app.readInt(r.URL.Query(), "days", 30, v)
+	v.Check(days > 0 && days <= 365, "days", "must be between 1 and 365")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	since := time.Now().AddDate(0, 0, -days)
+	stats, suppressed, err := app.modelsFor(r).Campaigns.Stats(since)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	sequences := make(map[string]string)
+	for _, campaign := range data.Campaigns {
+		s := app.sequence(campaign)
+		sequences[campaign] = s.String()
+	}
+
+	env := envelope{
+		"since":      since.UTC(),
+		"sequences":  sequences,
+		"steps":      stats,
+		"suppressed": suppressed,
+	}
+	err = app.writeJSON(w, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
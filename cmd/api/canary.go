@@ -0,0 +1,128 @@
+package main
+
+import (
+	"expvar"
+	"fmt"
+	"hash/fnv"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Names of the two variants a request can be routed to. "control" is the current
+// implementation and "canary" is the alternative one which is being rolled out.
+const (
+	variantControl = "control"
+	variantCanary  = "canary"
+)
+
+// canaryRequests holds per-feature, per-variant request counters, so that the
+// behaviour of the canary can be compared against the control group. The keys look
+// like "<feature>.<variant>", for example "show-movie.canary".
+var canaryRequests = expvar.NewMap("canary_requests")
+
+// The canaryFlags type holds the rollout settings for every feature which has been
+// configured through the -canary-weights and -canary-cohorts command-line flags. This
+// is the control plane for canary releases: changing the flags and restarting the
+// application is all that's needed to adjust how much traffic a variant receives.
+type canaryFlags struct {
+	weights map[string]int            // percentage of traffic (0-100) routed to the canary
+	cohorts map[string]map[int64]bool // user IDs which always get the canary
+}
+
+// parseWeights parses a space-separated list of "feature=percent" pairs, for example
+// "show-movie=10 list-movies=50".
+func (c *canaryFlags) parseWeights(val string) error {
+	c.weights = make(map[string]int)
+	for _, pair := range strings.Fields(val) {
+		name, value, ok := strings.Cut(pair, "=")
+		if !ok || name == "" {
+			return fmt.Errorf("invalid canary weight %q", pair)
+		}
+		percent, err := strconv.Atoi(value)
+		if err != nil || percent < 0 || percent > 100 {
+			return fmt.Errorf("canary weight for %q must be between 0 and 100", name)
+		}
+		c.weights[name] = percent
+	}
+	return nil
+}
+
+// parseCohorts parses a space-separated list of "feature=id,id,..." pairs, for example
+// "show-movie=1,2,3". Users in a cohort are routed to the canary regardless of the
+// configured weight.
+func (c *canaryFlags) parseCohorts(val string) error {
+	c.cohorts = make(map[string]map[int64]bool)
+	for _, pair := range strings.Fields(val) {
+		name, value, ok := strings.Cut(pair, "=")
+		if !ok || name == "" {
+			return fmt.Errorf("invalid canary cohort %q", pair)
+		}
+		ids := make(map[int64]bool)
+		for _, s := range strings.Split(value, ",") {
+			id, err := strconv.ParseInt(s, 10, 64)
+			if err != nil || id < 1 {
+				return fmt.Errorf("invalid user id %q in canary cohort for %q", s, name)
+			}
+			ids[id] = true
+		}
+		c.cohorts[name] = ids
+	}
+	return nil
+}
+
+// The variant() method decides which variant of a feature should serve the request.
+// Authenticated users are bucketed by their user ID and anonymous clients by their IP
+// address, so the same client consistently sees the same variant between requests.
+func (app *application) variant(r *http.Request, feature string) string {
+	user := app.contextGetUser(r)
+
+	if !user.IsAnonymous() && app.config.canary.cohorts[feature][user.ID] {
+		return variantCanary
+	}
+
+	percent := app.config.canary.weights[feature]
+	if percent <= 0 {
+		return variantControl
+	}
+
+	key := strconv.FormatInt(user.ID, 10)
+	if user.IsAnonymous() {
+		ip, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			ip = r.RemoteAddr
+		}
+		key = ip
+	}
+
+	h := fnv.New32a()
+	h.Write([]byte(feature + ":" + key))
+	if int(h.Sum32()%100) < percent {
+		return variantCanary
+	}
+	return variantControl
+}
+
+// The canary() method returns a handler which routes each request to either the
+// control or the canary handler for a feature, recording the chosen variant in the
+// canary_requests metrics and in the X-Variant response header. The "show-movie"
+// feature routes GET /v1/movies/:id, whose canary sends the movie's credits with it.
+//
+// Both variants have the same URL and ETag, and which one a client gets depends on its
+// user or IP address, which a Vary header can't express. The responses are marked
+// private, so a shared cache never hands one variant to a client of the other.
+func (app *application) canary(feature string, control, canary http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		v := app.variant(r, feature)
+		canaryRequests.Add(feature+"."+v, 1)
+		w.Header().Set("X-Variant", v)
+		w.Header().Set("Cache-Control", "private")
+
+		if v == variantCanary {
+			canary(w, r)
+			return
+		}
+		control(w, r)
+	}
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/shyngys9219/greenlight/internal/data"
+)
+
+// Both variants share a URL and ETag, so neither may be stored by a shared cache.
+func TestCanaryResponsesArePrivate(t *testing.T) {
+	for _, percent := range []int{0, 100} {
+		app := &application{}
+		app.config.canary.weights = map[string]int{"show-movie": percent}
+		handler := app.canary("show-movie", func(w http.ResponseWriter, r *http.Request) {}, func(w http.ResponseWriter, r *http.Request) {})
+
+		r := httptest.NewRequest(http.MethodGet, "/v1/movies/1", nil)
+		r = app.contextSetUser(r, data.AnonymousUser)
+		rr := httptest.NewRecorder()
+		handler(rr, r)
+
+		if got := rr.Header().Get("Cache-Control"); got != "private" {
+			t.Errorf("%s: Cache-Control = %q, want %q", rr.Header().Get("X-Variant"), got, "private")
+		}
+	}
+}
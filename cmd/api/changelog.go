@@ -0,0 +1,332 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/shyngys9219/greenlight/internal/validator"
+)
+
+// The kinds of change recorded in the changelog.
+const (
+	changeAdded      = "added"
+	changeChanged    = "changed"
+	changeDeprecated = "deprecated"
+	changeRemoved    = "removed"
+)
+
+// A change is one entry of a release in the changelog. Endpoint is "METHOD /path" as in
+// the route table, and is empty for changes which aren't about a single endpoint.
+type change struct {
+	Kind        string `json:"kind"`
+	Endpoint    string `json:"endpoint,omitempty"`
+	Description string `json:"description"`
+}
+
+// A release is a version of the API and what changed in it.
+type release struct {
+	Version string   `json:"version"`
+	Date    string   `json:"date,omitempty"` // empty for releases from before the changelog
+	Changes []change `json:"changes"`
+}
+
+// changelog lists the releases of the API, newest first, for integrators' tooling to
+// find out what the server can do. It's maintained alongside the route table:
+// checkChangelog() makes sure every route was announced as added, and that no endpoint
+// the changelog mentions has gone missing from the table. The first entry is the
+// current version.
+var changelog = []release{
+	{
+		Version: "1.1.0",
+		Date:    "2026-10-17",
+		Changes: []change{
+			{Kind: changeAdded, Endpoint: "GET /v1/healthcheck/live", Description: "liveness probe, which only checks that the server is up"},
+			{Kind: changeAdded, Endpoint: "GET /v1/healthcheck/ready", Description: "readiness probe, which checks the database, the schema version and optionally the mail provider"},
+			{Kind: changeAdded, Endpoint: "GET /debug/vars", Description: "expvar metrics, if enabled"},
+			{Kind: changeAdded, Endpoint: "GET /v1/events/poll", Description: "long poll for new movies and reviews, and changes to your account"},
+			{Kind: changeAdded, Endpoint: "GET /metrics", Description: "business metrics in the Prometheus format, if enabled"},
+			{Kind: changeAdded, Endpoint: "GET /v1/debug/echo", Description: "echo of the request as the API sees it"},
+			{Kind: changeAdded, Endpoint: "GET /v1/status", Description: "public status page with uptimes and incident notes"},
+			{Kind: changeAdded, Endpoint: "GET /v1/changelog", Description: "this changelog"},
+			{Kind: changeAdded, Endpoint: "GET /sitemap.xml", Description: "sitemap index of the public catalog"},
+			{Kind: changeAdded, Endpoint: "GET /sitemaps/:file", Description: "sitemaps of the public catalog"},
+
+			{Kind: changeAdded, Endpoint: "GET /v1/movies", Description: "movie listing with filters, sorting and pagination"},
+			{Kind: changeAdded, Endpoint: "GET /v1/movies/compare", Description: "side by side comparison of movies and their credits"},
+			{Kind: changeAdded, Endpoint: "GET /v1/movies/random", Description: "random movie discovery"},
+			{Kind: changeAdded, Endpoint: "GET /v1/movies/:id/translations", Description: "translations of a movie"},
+			{Kind: changeAdded, Endpoint: "PUT /v1/movies/:id/translations/:locale", Description: "save a translation of a movie"},
+			{Kind: changeAdded, Endpoint: "DELETE /v1/movies/:id/translations/:locale", Description: "delete a translation of a movie"},
+			{Kind: changeAdded, Endpoint: "GET /v1/movies/:id/facts", Description: "ratings, popularity and box office of a movie, with their sources"},
+			{Kind: changeAdded, Endpoint: "PUT /v1/movies/:id/facts/:field", Description: "set a fact about a movie by hand"},
+			{Kind: changeAdded, Endpoint: "DELETE /v1/movies/:id/facts/:field", Description: "delete a fact about a movie"},
+			{Kind: changeAdded, Endpoint: "GET /v1/movies/:id/reviews", Description: "reviews of a movie"},
+			{Kind: changeAdded, Endpoint: "POST /v1/movies/:id/reviews", Description: "review and rate a movie"},
+			{Kind: changeAdded, Endpoint: "GET /v1/movies/:id/reviews/:review_id", Description: "a review"},
+			{Kind: changeAdded, Endpoint: "DELETE /v1/movies/:id/reviews/:review_id", Description: "delete a review"},
+			{Kind: changeAdded, Endpoint: "PUT /v1/movies/:id/reviews/:review_id/vote", Description: "vote a review helpful or unhelpful"},
+			{Kind: changeAdded, Endpoint: "DELETE /v1/movies/:id/reviews/:review_id/vote", Description: "withdraw a vote on a review"},
+			{Kind: changeAdded, Endpoint: "GET /v1/movies/:id/availability", Description: "where a movie is available, and its release dates"},
+			{Kind: changeAdded, Endpoint: "PUT /v1/movies/:id/availability/:region", Description: "set a movie's availability and release date in a region"},
+			{Kind: changeAdded, Endpoint: "DELETE /v1/movies/:id/availability/:region", Description: "remove a movie's availability in a region"},
+
+			{Kind: changeAdded, Endpoint: "GET /v1/users/activate", Description: "activation link for emails, which redirects to the frontend"},
+			{Kind: changeAdded, Endpoint: "PUT /v1/users/password", Description: "reset a password with an emailed token"},
+			{Kind: changeAdded, Endpoint: "GET /v1/users/me", Description: "the current user"},
+			{Kind: changeAdded, Endpoint: "PATCH /v1/users/me", Description: "update the current user"},
+			{Kind: changeAdded, Endpoint: "DELETE /v1/users/me", Description: "delete the current user's account, with a confirmation"},
+			{Kind: changeAdded, Endpoint: "PUT /v1/users/me/password", Description: "change the current user's password"},
+			{Kind: changeAdded, Endpoint: "POST /v1/users/me/2fa/enable", Description: "start setting up two-factor authentication"},
+			{Kind: changeAdded, Endpoint: "POST /v1/users/me/2fa/verify", Description: "confirm two-factor authentication with a code"},
+			{Kind: changeAdded, Endpoint: "GET /v1/users/me/plan", Description: "the current user's plan and usage"},
+			{Kind: changeAdded, Endpoint: "POST /v1/users/me/merges", Description: "request merging another account into the current one"},
+			{Kind: changeAdded, Endpoint: "POST /v1/users/me/merges/confirm", Description: "confirm an account merge"},
+			{Kind: changeAdded, Endpoint: "GET /v1/users/me/campaigns", Description: "email campaign subscriptions"},
+			{Kind: changeAdded, Endpoint: "PUT /v1/users/me/campaigns", Description: "opt in or out of email campaigns"},
+			{Kind: changeAdded, Endpoint: "GET /v1/users/me/consents", Description: "consents to analytics, marketing emails and personalization"},
+			{Kind: changeAdded, Endpoint: "PATCH /v1/users/me/consents", Description: "give or withdraw consents"},
+			{Kind: changeAdded, Endpoint: "GET /v1/users/me/follows", Description: "followed genres and people"},
+			{Kind: changeAdded, Endpoint: "POST /v1/users/me/follows", Description: "follow a genre or person"},
+			{Kind: changeAdded, Endpoint: "DELETE /v1/users/me/follows/:kind/:value", Description: "unfollow a genre or person"},
+			{Kind: changeAdded, Endpoint: "GET /v1/users/me/watchlist", Description: "the current user's watchlist"},
+			{Kind: changeAdded, Endpoint: "POST /v1/users/me/watchlist", Description: "save a movie to the watchlist"},
+			{Kind: changeAdded, Endpoint: "PATCH /v1/users/me/watchlist/:movie_id", Description: "mark a watchlist movie as watched"},
+			{Kind: changeAdded, Endpoint: "DELETE /v1/users/me/watchlist/:movie_id", Description: "remove a movie from the watchlist"},
+
+			{Kind: changeAdded, Endpoint: "POST /v1/visitors", Description: "opt an anonymous visitor in to personalization"},
+			{Kind: changeAdded, Endpoint: "GET /v1/visitors/history", Description: "an anonymous visitor's history"},
+			{Kind: changeAdded, Endpoint: "DELETE /v1/visitors", Description: "forget an anonymous visitor"},
+
+			{Kind: changeAdded, Endpoint: "POST /v1/tokens/refresh", Description: "exchange a refresh token for new tokens"},
+			{Kind: changeAdded, Endpoint: "POST /v1/tokens/password-reset", Description: "email a password reset token"},
+
+			{Kind: changeAdded, Endpoint: "GET /v1/admin/export", Description: "export the dataset"},
+			{Kind: changeAdded, Endpoint: "POST /v1/admin/import", Description: "import a dataset"},
+			{Kind: changeAdded, Endpoint: "GET /v1/admin/health/history", Description: "history of the dependency health probes"},
+			{Kind: changeAdded, Endpoint: "GET /v1/admin/campaigns", Description: "email campaign analytics"},
+			{Kind: changeAdded, Endpoint: "GET /v1/admin/metrics/daily", Description: "daily totals of signups, activations, new movies and reviews"},
+			{Kind: changeAdded, Endpoint: "GET /v1/admin/search/queries", Description: "search query analytics"},
+			{Kind: changeAdded, Endpoint: "POST /v1/admin/status/incidents", Description: "post an incident note on the status page"},
+			{Kind: changeAdded, Endpoint: "PATCH /v1/admin/status/incidents/:id", Description: "update an incident note"},
+			{Kind: changeAdded, Endpoint: "DELETE /v1/admin/status/incidents/:id", Description: "delete an incident note"},
+			{Kind: changeAdded, Endpoint: "POST /v1/admin/incident", Description: "turn incident mode on"},
+			{Kind: changeAdded, Endpoint: "DELETE /v1/admin/incident", Description: "turn incident mode off"},
+			{Kind: changeAdded, Endpoint: "GET /v1/admin/rate-limits", Description: "rate limit overrides"},
+			{Kind: changeAdded, Endpoint: "PUT /v1/admin/rate-limits", Description: "save a rate limit override"},
+			{Kind: changeAdded, Endpoint: "DELETE /v1/admin/rate-limits/:id", Description: "delete a rate limit override"},
+			{Kind: changeAdded, Endpoint: "POST /v1/admin/movies/:id/merge", Description: "merge duplicate movies"},
+			{Kind: changeAdded, Endpoint: "GET /v1/movie-fields", Description: "definitions of the custom fields of movies"},
+			{Kind: changeAdded, Endpoint: "PUT /v1/admin/movie-fields/:name", Description: "define a custom field of movies"},
+			{Kind: changeAdded, Endpoint: "DELETE /v1/admin/movie-fields/:name", Description: "delete a custom field of movies, with its values"},
+			{Kind: changeAdded, Endpoint: "POST /v1/admin/users/import", Description: "bulk import users"},
+			{Kind: changeAdded, Endpoint: "POST /v1/admin/users/:id/merge", Description: "merge duplicate users"},
+			{Kind: changeAdded, Endpoint: "GET /v1/admin/users/:id/permissions", Description: "a user's permissions"},
+			{Kind: changeAdded, Endpoint: "POST /v1/admin/users/:id/permissions", Description: "grant a user permissions"},
+			{Kind: changeAdded, Endpoint: "DELETE /v1/admin/users/:id/permissions/:code", Description: "revoke a user's permission"},
+			{Kind: changeAdded, Endpoint: "PUT /v1/admin/users/:id/plan", Description: "set a user's plan"},
+			{Kind: changeAdded, Endpoint: "PUT /v1/admin/orgs/:org/plan", Description: "set an organization's plan"},
+
+			{Kind: changeAdded, Endpoint: "GET /scim/v2/Users", Description: "SCIM user provisioning"},
+			{Kind: changeAdded, Endpoint: "POST /scim/v2/Users", Description: "SCIM user provisioning"},
+			{Kind: changeAdded, Endpoint: "GET /scim/v2/Users/:id", Description: "SCIM user provisioning"},
+			{Kind: changeAdded, Endpoint: "PUT /scim/v2/Users/:id", Description: "SCIM user provisioning"},
+			{Kind: changeAdded, Endpoint: "PATCH /scim/v2/Users/:id", Description: "SCIM user provisioning"},
+			{Kind: changeAdded, Endpoint: "DELETE /scim/v2/Users/:id", Description: "SCIM user provisioning"},
+
+			{Kind: changeAdded, Endpoint: "POST /v1/billing/checkout", Description: "start paying for a plan"},
+			{Kind: changeAdded, Endpoint: "POST /v1/billing/webhook", Description: "billing provider webhook"},
+
+			{Kind: changeAdded, Endpoint: "POST /v1/orgs", Description: "create an organization"},
+			{Kind: changeAdded, Endpoint: "GET /v1/orgs", Description: "the current user's organizations"},
+			{Kind: changeAdded, Endpoint: "GET /v1/orgs/:org", Description: "an organization"},
+			{Kind: changeAdded, Endpoint: "GET /v1/orgs/:org/plan", Description: "an organization's plan and usage"},
+			{Kind: changeAdded, Endpoint: "GET /v1/orgs/:org/members", Description: "members of an organization"},
+			{Kind: changeAdded, Endpoint: "PUT /v1/orgs/:org/members", Description: "add or update a member"},
+			{Kind: changeAdded, Endpoint: "DELETE /v1/orgs/:org/members/:user_id", Description: "remove a member"},
+			{Kind: changeAdded, Endpoint: "GET /v1/orgs/:org/keys", Description: "API keys of an organization"},
+			{Kind: changeAdded, Endpoint: "POST /v1/orgs/:org/keys", Description: "create an API key"},
+			{Kind: changeAdded, Endpoint: "DELETE /v1/orgs/:org/keys/:key_id", Description: "revoke an API key"},
+			{Kind: changeAdded, Endpoint: "GET /v1/orgs/:org/watchlist", Description: "an organization's shared watchlist"},
+			{Kind: changeAdded, Endpoint: "PUT /v1/orgs/:org/watchlist", Description: "add a movie to the shared watchlist"},
+			{Kind: changeAdded, Endpoint: "DELETE /v1/orgs/:org/watchlist/:movie_id", Description: "remove a movie from the shared watchlist"},
+			{Kind: changeAdded, Endpoint: "GET /v1/orgs/:org/webhooks", Description: "webhook subscriptions of an organization"},
+			{Kind: changeAdded, Endpoint: "POST /v1/orgs/:org/webhooks", Description: "subscribe to availability and release date changes, filtered by genre and region"},
+			{Kind: changeAdded, Endpoint: "DELETE /v1/orgs/:org/webhooks/:webhook_id", Description: "delete a webhook subscription"},
+			{Kind: changeAdded, Endpoint: "GET /v1/orgs/:org/webhooks/:webhook_id/deliveries", Description: "recent deliveries of a webhook subscription"},
+
+			{Kind: changeAdded, Endpoint: "POST /v1/screenings", Description: "schedule a screening"},
+			{Kind: changeAdded, Endpoint: "GET /v1/screenings/:id", Description: "a screening"},
+			{Kind: changeAdded, Endpoint: "POST /v1/screenings/:id/invites", Description: "invite users to a screening"},
+			{Kind: changeAdded, Endpoint: "PUT /v1/screenings/:id/rsvp", Description: "answer a screening invite"},
+
+			{Kind: changeChanged, Endpoint: "POST /v1/tokens/authentication", Description: "accepts code or recovery_code for users with two-factor authentication, and also returns a refresh token"},
+			{Kind: changeChanged, Endpoint: "POST /v1/movies", Description: "supports dry runs with dry_run=true or X-Dry-Run"},
+			{Kind: changeChanged, Endpoint: "PUT /v1/movies/:id", Description: "supports dry runs with dry_run=true or X-Dry-Run"},
+			{Kind: changeChanged, Endpoint: "POST /v1/users", Description: "supports dry runs with dry_run=true or X-Dry-Run"},
+			{Kind: changeChanged, Description: "responses are localized with the locale query parameter, the user's locale or Accept-Language"},
+			{Kind: changeChanged, Description: "movies have public IDs, which are accepted wherever a movie ID is"},
+			{Kind: changeChanged, Endpoint: "POST /v1/admin/incident", Description: "needs a confirmation token in X-Confirmation-Token from a first call"},
+			{Kind: changeChanged, Endpoint: "POST /v1/admin/movies/:id/merge", Description: "needs a confirmation token in X-Confirmation-Token from a first call"},
+			{Kind: changeChanged, Endpoint: "POST /v1/admin/users/:id/merge", Description: `needs a confirmation token in X-Confirmation-Token from a first call, instead of "confirm": true`},
+			{Kind: changeChanged, Endpoint: "GET /v1/movies", Description: "shortens overviews to the length given by truncate"},
+			{Kind: changeChanged, Endpoint: "GET /v1/movies/:id/reviews", Description: "shortens reviews to the length given by truncate"},
+			{Kind: changeChanged, Description: "authenticated requests are rate limited per user or API key rather than per IP address, and every response reports the limit in X-RateLimit-Limit, X-RateLimit-Remaining and X-RateLimit-Reset"},
+			{Kind: changeChanged, Endpoint: "DELETE /v1/movies/:id", Description: "reports what was removed with the movie, and can archive it first with policy=archive"},
+			{Kind: changeChanged, Description: "error responses include the request_id to quote in support requests, and a request which comes with an X-Request-ID header keeps it"},
+			{Kind: changeChanged, Description: "emails are queued and retried with backoff when sending fails, rather than being lost, and the email_queue health check fails when they back up"},
+			{Kind: changeChanged, Endpoint: "GET /v1/movies/:id/reviews", Description: "reviews have helpful_count and unhelpful_count, and can be sorted by helpfulness"},
+			{Kind: changeChanged, Endpoint: "GET /v1/movies", Description: "movies have custom_fields, which can be filtered on with cf.name[op]=value"},
+			{Kind: changeChanged, Endpoint: "POST /v1/movies", Description: "accepts custom_fields, checked against their definitions"},
+			{Kind: changeChanged, Endpoint: "PUT /v1/movies/:id", Description: "accepts custom_fields; fields set to null are removed"},
+			{Kind: changeChanged, Description: "browser applications on the server's trusted origins can call the API, with CORS preflight requests answered and X-Request-ID and the rate limit headers readable"},
+			{Kind: changeChanged, Description: "requests which time out get the same JSON error envelope as other errors, with the request_id"},
+			{Kind: changeChanged, Description: "while the database can't be written, reads are still served and writes get 503 Service Unavailable with the read_only code; GET /v1/healthcheck reports the read_only status"},
+			{Kind: changeChanged, Endpoint: "GET /v1/movies/:id", Description: "sends an ETag with the movie's version, and 304 Not Modified for a matching If-None-Match"},
+			{Kind: changeChanged, Endpoint: "PUT /v1/movies/:id", Description: "needs the movie's ETag in If-Match, and reports conflicting updates with 412 Precondition Failed instead of 409 Conflict"},
+			{Kind: changeChanged, Endpoint: "DELETE /v1/movies/:id", Description: "needs the movie's ETag in If-Match"},
+			{Kind: changeAdded, Endpoint: "PATCH /v1/movies/:id", Description: "update some of a movie's fields, also as a JSON Merge Patch with application/merge-patch+json; PUT is still served the same way"},
+			{Kind: changeAdded, Endpoint: "GET /v1/genres", Description: "the genres of movies, with their ids and the number of movies which have each"},
+			{Kind: changeChanged, Endpoint: "GET /v1/movies", Description: "can be filtered on genres by id with genre_ids"},
+			{Kind: changeAdded, Endpoint: "GET /v1/admin/abuse/restrictions", Description: "the accounts restricted for writing too fast, or by an admin, with their recent writes"},
+			{Kind: changeAdded, Endpoint: "PUT /v1/admin/users/:id/restriction", Description: "hold, shadow-limit or exempt a user's account"},
+			{Kind: changeAdded, Endpoint: "DELETE /v1/admin/users/:id/restriction", Description: "lift the restriction of a user's account"},
+			{Kind: changeChanged, Endpoint: "POST /v1/movies/:id/reviews", Description: "refused with code account_held for accounts held for moderation"},
+			{Kind: changeChanged, Endpoint: "POST /v1/movies", Description: "refused with code account_held for restricted accounts"},
+			{Kind: changeAdded, Endpoint: "GET /v1/movies/:id/credits", Description: "the cast and crew of a movie, in billing order"},
+			{Kind: changeAdded, Endpoint: "POST /v1/movies/:id/credits", Description: "credit a person on a movie with a role, and a character for actors"},
+			{Kind: changeAdded, Endpoint: "DELETE /v1/movies/:id/credits/:credit_id", Description: "remove a credit from a movie"},
+			{Kind: changeAdded, Endpoint: "GET /v1/people", Description: "the people who make movies, searchable by name"},
+			{Kind: changeAdded, Endpoint: "POST /v1/people", Description: "add a person who can be credited on movies"},
+			{Kind: changeAdded, Endpoint: "GET /v1/people/:id", Description: "a person"},
+			{Kind: changeAdded, Endpoint: "DELETE /v1/people/:id", Description: "remove a person and their credits"},
+			{Kind: changeAdded, Endpoint: "GET /v1/people/:id/movies", Description: "a person's filmography with their roles and characters, paginated and filterable by role"},
+			{Kind: changeChanged, Endpoint: "POST /v1/admin/movies/:id/merge", Description: "moves the duplicate's credits too, reported as credits_reassigned"},
+			{Kind: changeAdded, Endpoint: "POST /v1/movies/:id/poster", Description: "upload a movie's poster as multipart/form-data; movies have a poster_url once they have one"},
+			{Kind: changeAdded, Endpoint: "GET /uploads/*path", Description: "uploaded files, when they're kept on the API's local disk"},
+			{Kind: changeAdded, Endpoint: "GET /v1/users/me/notifications", Description: "in-app notifications, and how many are unread"},
+			{Kind: changeAdded, Endpoint: "POST /v1/users/me/notifications/read", Description: "mark in-app notifications read"},
+			{Kind: changeAdded, Endpoint: "GET /v1/users/me/notification-preferences", Description: "channels each type of notification is delivered over"},
+			{Kind: changeAdded, Endpoint: "PATCH /v1/users/me/notification-preferences", Description: "turn notification channels on or off"},
+			{Kind: changeAdded, Endpoint: "POST /v1/movies/import", Description: "import movies from a CSV or NDJSON file, with a report of the rows which failed"},
+			{Kind: changeChanged, Endpoint: "GET /v1/movies", Description: "returns the titles matched by a search marked up with highlight=true, and counts of the matching movies by genre, decade and rating band with facets=true; movies can be filtered by rating"},
+			{Kind: changeChanged, Endpoint: "GET /v1/movies", Description: "exports every movie matching the filters as CSV or NDJSON, with Accept: text/csv or application/x-ndjson, or format=csv or ndjson"},
+			{Kind: changeChanged, Endpoint: "POST /v1/movies/:id/poster", Description: "movies with a JPEG or PNG poster have a poster_preview, its dominant_color, palette and blurhash, for clients to show while the poster loads"},
+			{Kind: changeAdded, Endpoint: "PUT /v1/campaigns/unsubscribed", Description: "unsubscribe from email campaigns with the token in a campaign email, without logging in"},
+			{Kind: changeDeprecated, Description: legacyRuntimeNotice},
+		},
+	},
+	{
+		Version: "1.0.0",
+		Changes: []change{
+			{Kind: changeAdded, Endpoint: "GET /v1/healthcheck", Description: "health and version of the API"},
+			{Kind: changeAdded, Endpoint: "POST /v1/movies", Description: "create a movie"},
+			{Kind: changeAdded, Endpoint: "GET /v1/movies/:id", Description: "a movie"},
+			{Kind: changeAdded, Endpoint: "PUT /v1/movies/:id", Description: "update a movie"},
+			{Kind: changeAdded, Endpoint: "DELETE /v1/movies/:id", Description: "delete a movie"},
+			{Kind: changeAdded, Endpoint: "POST /v1/users", Description: "register a user"},
+			{Kind: changeAdded, Endpoint: "PUT /v1/users/activated", Description: "activate a user with an emailed token"},
+			{Kind: changeAdded, Endpoint: "POST /v1/tokens/authentication", Description: "log in for an authentication token"},
+		},
+	},
+}
+
+// checkChangelog() makes sure the changelog agrees with the route table: every route
+// must have been added in some release, and every endpoint the changelog mentions must
+// be served unless it was since removed. routes() panics if they disagree, so a route
+// can't be added without its changelog entry.
+func checkChangelog(routes []route) error {
+	served := make(map[string]bool)
+	for _, rt := range routes {
+		served[rt.method+" "+rt.path] = true
+	}
+
+	added := make(map[string]bool)
+	removed := make(map[string]bool)
+	// Releases are listed newest first, so an endpoint's latest change is seen first.
+	for _, rel := range changelog {
+		for _, c := range rel.Changes {
+			if c.Endpoint == "" {
+				continue
+			}
+			switch c.Kind {
+			case changeAdded:
+				added[c.Endpoint] = true
+			case changeRemoved:
+				if served[c.Endpoint] {
+					return fmt.Errorf("changelog: %s was removed in %s but is still served", c.Endpoint, rel.Version)
+				}
+				removed[c.Endpoint] = true
+			}
+			if !served[c.Endpoint] && !removed[c.Endpoint] {
+				return fmt.Errorf("changelog: %s isn't in the route table", c.Endpoint)
+			}
+		}
+	}
+
+	for endpoint := range served {
+		if !added[endpoint] {
+			return fmt.Errorf("changelog: %s is missing an %q entry", endpoint, changeAdded)
+		}
+	}
+	return nil
+}
+
+// The showChangelogHandler for the "GET /v1/changelog" endpoint returns the changelog.
+// With since=<version> in the query string only the releases after that version are
+// listed, so tooling can ask what changed since the version it was built against.
+func (app *application) showChangelogHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	releases := changelog
+	if since := r.URL.Query().Get("since"); since != "" {
+		base, ok := parseVersion(since)
+		if v.Check(ok, "since", "must be a version like 1.0.0"); !v.Valid() {
+			app.failedValidationResponse(w, r, v.Errors)
+			return
+		}
+		releases = []release{}
+		for _, rel := range changelog {
+			version, _ := parseVersion(rel.Version)
+			if compareVersions(version, base) <= 0 {
+				break
+			}
+			releases = append(releases, rel)
+		}
+	}
+
+	err := app.writeJSON(w, http.StatusOK, envelope{"version": version, "releases": releases}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// parseVersion() parses a version like 1.2.3 into its major, minor and patch numbers.
+func parseVersion(s string) ([3]int, bool) {
+	var v [3]int
+	parts := strings.Split(s, ".")
+	if len(parts) != 3 {
+		return v, false
+	}
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return v, false
+		}
+		v[i] = n
+	}
+	return v, true
+}
+
+// compareVersions() returns -1, 0 or 1 as a is older than, the same as or newer than b.
+func compareVersions(a, b [3]int) int {
+	for i := range a {
+		switch {
+		case a[i] < b[i]:
+			return -1
+		case a[i] > b[i]:
+			return 1
+		}
+	}
+	return 0
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"time"
+
+	"github.com/shyngys9219/greenlight/internal/health"
+	"github.com/shyngys9219/greenlight/internal/jsonlog"
+)
+
+// checkTimeout is how long each of the checks of the check command may take.
+const checkTimeout = 10 * time.Second
+
+// The runChecks() function implements the check command, which is run as
+//
+//	api check [flags]
+//
+// with the same flags as the server. Rather than starting the server it checks the
+// configuration and every dependency the flags point at, writes a report to stdout,
+// and returns the exit status: 1 if any of the checks failed. Deploy pipelines run it
+// against the new configuration before rolling it out.
+func runChecks(cfg config) int {
+	probes := map[string]health.Probe{
+		"config": func(ctx context.Context) error {
+			return checkConfig(cfg)
+		},
+		"database": func(ctx context.Context) error {
+			db, err := openDB(cfg, cfg.db.dsn)
+			if err != nil {
+				return err
+			}
+			return db.Close()
+		},
+		"schema": func(ctx context.Context) error {
+			db, err := openDB(cfg, cfg.db.dsn)
+			if err != nil {
+				return err
+			}
+			defer db.Close()
+			return checkSchema(db, jsonlog.New(io.Discard, jsonlog.LevelOff), false)
+		},
+		"smtp": func(ctx context.Context) error {
+			mail, err := newMailer(cfg)
+			if err != nil {
+				return err
+			}
+			return mail.Ping()
+		},
+		"templates": func(ctx context.Context) error {
+			mail, err := newMailer(cfg)
+			if err != nil {
+				return err
+			}
+			return mail.CheckTemplates()
+		},
+	}
+	if cfg.db.replicaDSN != "" {
+		probes["replica"] = func(ctx context.Context) error {
+			db, err := openDB(cfg, cfg.db.replicaDSN)
+			if err != nil {
+				return err
+			}
+			return db.Close()
+		}
+	}
+	res := health.Run(probes, checkTimeout)
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "\t")
+	err := enc.Encode(res)
+	if err != nil || res.Status != health.StatusUp {
+		return 1
+	}
+	return 0
+}
+
+// The checkConfig() function checks the settings which main() would otherwise only
+// reject once the server is starting: first the values themselves, with
+// validateConfig(), then whatever the constructors load from them, such as key files.
+func checkConfig(cfg config) error {
+	err := configError(validateConfig(cfg))
+	if err != nil {
+		return err
+	}
+	_, err = newJWTSigner(cfg)
+	if err != nil {
+		return err
+	}
+	_, err = newMailer(cfg)
+	if err != nil {
+		return err
+	}
+	_, err = newRedisClient(cfg)
+	if err != nil {
+		return err
+	}
+	_, err = newTOTPCipher(cfg)
+	if err != nil {
+		return err
+	}
+	_, _, err = newTLSConfig(cfg)
+	if err != nil {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// The settings of the application come from four places, in increasing order of
+// precedence: the defaults of the flags, a YAML configuration file named by -config,
+// the command-line flags, and the environment variables in flagEnv. The file uses the
+// names of the flags as keys, and may group them by their prefix, so
+//
+//	port: 4000
+//	smtp:
+//	  host: smtp.example.com
+//	  port: 587
+//	anonymous-permissions: [movies:read]
+//
+// sets -port, -smtp-host, -smtp-port and -anonymous-permissions. Lists are joined with
+// commas.
+
+// flagEnv holds the environment variables which set flags. They're for secrets, which
+// shouldn't be seen in the process list or committed in a configuration file.
+var flagEnv = map[string]string{
+	"db-dsn":                "DSN",
+	"db-replica-dsn":        "DB_REPLICA_DSN",
+	"search-analytics-key":  "SEARCH_ANALYTICS_KEY",
+	"jwt-secret":            "JWT_SECRET",
+	"totp-key":              "TOTP_KEY",
+	"stripe-secret-key":     "STRIPE_SECRET_KEY",
+	"stripe-webhook-secret": "STRIPE_WEBHOOK_SECRET",
+	"redis-url":             "REDIS_URL",
+	"sendgrid-api-key":      "SENDGRID_API_KEY",
+	"mailgun-api-key":       "MAILGUN_API_KEY",
+	"ses-access-key-id":     "AWS_ACCESS_KEY_ID",
+	"ses-secret-access-key": "AWS_SECRET_ACCESS_KEY",
+	"ses-session-token":     "AWS_SESSION_TOKEN",
+	"omdb-api-key":          "OMDB_API_KEY",
+	"s3-access-key-id":      "S3_ACCESS_KEY_ID",
+	"s3-secret-access-key":  "S3_SECRET_ACCESS_KEY",
+}
+
+// secretFlags are the flags whose values -print-config doesn't show.
+var secretFlags = map[string]bool{
+	"db-dsn":                true,
+	"db-replica-dsn":        true,
+	"smtp-password":         true,
+	"search-analytics-key":  true,
+	"jwt-secret":            true,
+	"totp-key":              true,
+	"stripe-secret-key":     true,
+	"stripe-webhook-secret": true,
+	"redis-url":             true,
+	"sendgrid-api-key":      true,
+	"mailgun-api-key":       true,
+	"ses-secret-access-key": true,
+	"ses-session-token":     true,
+	"omdb-api-key":          true,
+	"s3-secret-access-key":  true,
+}
+
+// configMetaFlags are the flags about the configuration itself, which can't be set from
+// a file and aren't printed.
+var configMetaFlags = map[string]bool{
+	"config":       true,
+	"print-config": true,
+}
+
+// The applyConfigSources() function layers the configuration file and the environment
+// over the flags once they've been parsed: settings from the file only apply to flags
+// which weren't given on the command line, and the environment variables in flagEnv
+// override both.
+func applyConfigSources(fs *flag.FlagSet, configFile string) error {
+	given := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		given[f.Name] = true
+	})
+
+	if configFile != "" {
+		settings, err := readConfigFile(configFile)
+		if err != nil {
+			return err
+		}
+		for name, value := range settings {
+			if fs.Lookup(name) == nil || configMetaFlags[name] {
+				return fmt.Errorf("%s: unknown setting %q", configFile, name)
+			}
+			if given[name] {
+				continue
+			}
+			err := fs.Set(name, value)
+			if err != nil {
+				return fmt.Errorf("%s: invalid value for %q: %w", configFile, name, err)
+			}
+		}
+	}
+
+	for name, env := range flagEnv {
+		value := os.Getenv(env)
+		if value == "" {
+			continue
+		}
+		err := fs.Set(name, value)
+		if err != nil {
+			return fmt.Errorf("invalid value of $%s: %w", env, err)
+		}
+	}
+	return nil
+}
+
+// The readConfigFile() function reads a YAML configuration file into the values of the
+// flags it sets, keyed by the names of the flags.
+func readConfigFile(path string) (map[string]string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var doc map[string]any
+	err = yaml.Unmarshal(b, &doc)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	settings := make(map[string]string)
+	err = flattenConfig(settings, "", doc)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return settings, nil
+}
+
+// flattenConfig() turns nested groups of settings into flag names joined with dashes,
+// and the values into the strings the flags parse.
+func flattenConfig(settings map[string]string, prefix string, doc map[string]any) error {
+	for key, value := range doc {
+		name := key
+		if prefix != "" {
+			name = prefix + "-" + key
+		}
+		switch value := value.(type) {
+		case map[string]any:
+			err := flattenConfig(settings, name, value)
+			if err != nil {
+				return err
+			}
+		case []any:
+			items := make([]string, len(value))
+			for i, item := range value {
+				items[i] = fmt.Sprint(item)
+			}
+			settings[name] = strings.Join(items, ",")
+		case nil:
+			settings[name] = ""
+		default:
+			settings[name] = fmt.Sprint(value)
+		}
+	}
+	return nil
+}
+
+// The printConfig() function writes the effective configuration as a YAML file which
+// -config would accept, with the secrets redacted. Settings made with a function, such
+// as -canary-weights, are printed empty since their values can't be read back.
+func printConfig(w io.Writer, fs *flag.FlagSet) error {
+	var names []string
+	values := make(map[string]string)
+	fs.VisitAll(func(f *flag.Flag) {
+		if configMetaFlags[f.Name] {
+			return
+		}
+		value := f.Value.String()
+		if secretFlags[f.Name] && value != "" {
+			value = "<redacted>"
+		}
+		names = append(names, f.Name)
+		values[f.Name] = value
+	})
+	sort.Strings(names)
+
+	doc := &yaml.Node{Kind: yaml.MappingNode}
+	for _, name := range names {
+		doc.Content = append(doc.Content,
+			&yaml.Node{Kind: yaml.ScalarNode, Value: name},
+			&yaml.Node{Kind: yaml.ScalarNode, Value: values[name]},
+		)
+	}
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	err := enc.Encode(doc)
+	if err != nil {
+		return err
+	}
+	return enc.Close()
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/shyngys9219/greenlight/internal/data"
+	"github.com/shyngys9219/greenlight/internal/validator"
+)
+
+// Destructive operations, such as deleting an account or merging movies, take two
+// calls. The first one changes nothing and returns a confirmation token; the operation
+// is only carried out when the same request is sent again with the token in the
+// confirmationHeader header. A script which calls a destructive endpoint by mistake
+// therefore can't do any harm, and both calls are recorded in the audit trail.
+const confirmationHeader = "X-Confirmation-Token"
+
+// confirmationTTL is how long a confirmation token is valid for.
+const confirmationTTL = 5 * time.Minute
+
+// The confirmed() helper implements the two calls of a destructive operation. The
+// action names the operation and params are the parameters it was called with. If the
+// request carries a valid confirmation token, issued for the same action, parameters
+// and user, the token is used up and confirmed() returns true, and the handler carries
+// on. Otherwise a response has been sent and it returns false: either a 202 Accepted
+// response with a new confirmation token, and the preview if there is one, or a failed
+// validation response if the token was no good.
+func (app *application) confirmed(w http.ResponseWriter, r *http.Request, action string, params any, preview envelope) bool {
+	user := app.contextGetUser(r)
+	fingerprint, err := confirmationFingerprint(r, params)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return false
+	}
+
+	plaintext := r.Header.Get(confirmationHeader)
+	if plaintext == "" {
+		token, err := app.modelsFor(r).Confirmations.New(user.ID, action, fingerprint, confirmationTTL)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return false
+		}
+		app.requestLogger(r).PrintInfo("confirmation requested", map[string]string{
+			"action":  action,
+			"user_id": fmt.Sprint(user.ID),
+			"path":    r.URL.Path,
+			"expiry":  token.Expiry.UTC().Format(time.RFC3339),
+		})
+
+		env := envelope{}
+		for k, v := range preview {
+			env[k] = v
+		}
+		env["message"] = fmt.Sprintf("nothing has been changed yet; send the same request again with the %s header to confirm, which can't be undone", confirmationHeader)
+		env["confirmation"] = token
+		err = app.writeJSON(w, http.StatusAccepted, env, nil)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
+		return false
+	}
+
+	v := validator.New()
+	if data.ValidateTokenPlaintext(v, plaintext); !v.Valid() {
+		app.failedValidationResponse(w, r, map[string]string{"confirmation_token": v.Errors["token"]})
+		return false
+	}
+	err = app.modelsFor(r).Confirmations.Consume(plaintext, user.ID, action, fingerprint)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("confirmation_token", "invalid or expired, or issued for a different request")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return false
+	}
+	app.requestLogger(r).PrintInfo("confirmation used", map[string]string{
+		"action":  action,
+		"user_id": fmt.Sprint(user.ID),
+		"path":    r.URL.Path,
+	})
+	return true
+}
+
+// confirmationFingerprint() identifies a request by its method, path and parameters,
+// so that a confirmation token can't be used for a different request.
+func confirmationFingerprint(r *http.Request, params any) ([]byte, error) {
+	js, err := json.Marshal(params)
+	if err != nil {
+		return nil, err
+	}
+	h := sha256.New()
+	fmt.Fprintf(h, "%s %s\n", r.Method, r.URL.Path)
+	h.Write(js)
+	return h.Sum(nil), nil
+}
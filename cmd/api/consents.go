@@ -0,0 +1,100 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/shyngys9219/greenlight/internal/data"
+	"github.com/shyngys9219/greenlight/internal/validator"
+)
+
+// Users decide whether their data may be processed for each of the optional purposes
+// in data.ConsentPurposes. Every change is recorded with the privacy policy version of
+// -consent-policy-version, and the features which process the data check the consent
+// first: the search analytics (recordSearch()), the campaign emails (runCampaigns()),
+// and the merging of visitor history into new accounts (mergeVisitorHistory()).
+
+// parseConsentDefaults() returns the purposes listed in the -consent-defaults flag.
+func parseConsentDefaults(list string) map[string]bool {
+	defaults := make(map[string]bool)
+	for _, purpose := range strings.Split(list, ",") {
+		if purpose = strings.TrimSpace(purpose); purpose != "" {
+			defaults[purpose] = true
+		}
+	}
+	return defaults
+}
+
+// The consentDefault() method reports whether users who haven't decided on a purpose
+// consent to it.
+func (app *application) consentDefault(purpose string) bool {
+	return parseConsentDefaults(app.config.consent.defaults)[purpose]
+}
+
+// The showConsentsHandler for the "GET /v1/users/me/consents" endpoint returns the
+// current user's consent to each purpose.
+func (app *application) showConsentsHandler(w http.ResponseWriter, r *http.Request) {
+	app.writeConsents(w, r, app.contextGetUser(r).ID)
+}
+
+// The updateConsentsHandler for the "PATCH /v1/users/me/consents" endpoint records the
+// current user's consents, given as an object such as {"analytics": false}, and
+// returns them all. Purposes which aren't given are left as they are. Withdrawing a
+// consent also deletes what was collected under it: the user's recorded searches for
+// analytics, and their viewing history for personalization.
+func (app *application) updateConsentsHandler(w http.ResponseWriter, r *http.Request) {
+	var input map[string]bool
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	v.Check(len(input) > 0, "consents", "must contain at least 1 purpose")
+	for purpose := range input {
+		v.Check(validator.PermittedValue(purpose, data.ConsentPurposes...), purpose, "must be one of "+strings.Join(data.ConsentPurposes, ", "))
+	}
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user := app.contextGetUser(r)
+	models := app.modelsFor(r)
+	err = models.Consents.Record(user.ID, input, app.config.consent.policyVersion)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if granted, ok := input[data.ConsentAnalytics]; ok && !granted {
+		err = models.Searches.DeleteForUser(app.hashUserID(user.ID))
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+	if granted, ok := input[data.ConsentPersonalization]; ok && !granted {
+		err = models.Visitors.DeleteUserViews(user.ID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	app.writeConsents(w, r, user.ID)
+}
+
+// The writeConsents() helper sends a user's consents.
+func (app *application) writeConsents(w http.ResponseWriter, r *http.Request, userID int64) {
+	consents, err := app.modelsFor(r).Consents.GetForUser(userID, parseConsentDefaults(app.config.consent.defaults))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"consents": consents}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
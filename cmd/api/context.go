@@ -16,8 +16,12 @@ const userContextKey = contextKey("user")
 
 // The contextSetUser() method returns a new copy of the request with the provided
 // User struct added to the context. Note that we use our userContextKey constant as the
-// key.
+// key. The user's ID is noted in the request's metadata too, for the access log, which
+// is written by middleware that runs before the user is known.
 func (app *application) contextSetUser(r *http.Request, user *data.User) *http.Request {
+	if meta := contextGetResponseMetadata(r.Context()); meta != nil {
+		meta.userID = user.ID
+	}
 	ctx := context.WithValue(r.Context(), userContextKey, user)
 	return r.WithContext(ctx)
 }
@@ -33,3 +37,106 @@ func (app *application) contextGetUser(r *http.Request) *data.User {
 	}
 	return user
 }
+
+// Requests authenticated with an organization's API key carry the key in their context,
+// under the apiKeyContextKey. Their user is the AnonymousUser.
+const apiKeyContextKey = contextKey("apiKey")
+
+func (app *application) contextSetAPIKey(r *http.Request, key *data.APIKey) *http.Request {
+	ctx := context.WithValue(r.Context(), apiKeyContextKey, key)
+	return r.WithContext(ctx)
+}
+
+// The contextGetAPIKey() helper returns the request's API key, or nil if the request
+// wasn't authenticated with one.
+func (app *application) contextGetAPIKey(r *http.Request) *data.APIKey {
+	key, _ := r.Context().Value(apiKeyContextKey).(*data.APIKey)
+	return key
+}
+
+// An orgAccess is the organization named by an organization route, together with the
+// role the caller has in it. It's added to the context by the requireOrgRole()
+// middleware.
+type orgAccess struct {
+	org  *data.Organization
+	role string
+}
+
+const orgContextKey = contextKey("org")
+
+func (app *application) contextSetOrgAccess(r *http.Request, access *orgAccess) *http.Request {
+	ctx := context.WithValue(r.Context(), orgContextKey, access)
+	return r.WithContext(ctx)
+}
+
+// Like contextGetUser(), contextGetOrgAccess() is only called by handlers behind the
+// requireOrgRole() middleware, so a missing value is an unexpected error.
+func (app *application) contextGetOrgAccess(r *http.Request) *orgAccess {
+	access, ok := r.Context().Value(orgContextKey).(*orgAccess)
+	if !ok {
+		panic("missing organization value in request context")
+	}
+	return access
+}
+
+// Requests authenticated with a JWT are marked under the jwtUserContextKey, since their
+// user only has the details carried by the token.
+const jwtUserContextKey = contextKey("jwtUser")
+
+func (app *application) contextSetJWTUser(r *http.Request) *http.Request {
+	ctx := context.WithValue(r.Context(), jwtUserContextKey, true)
+	return r.WithContext(ctx)
+}
+
+func (app *application) contextIsJWTUser(r *http.Request) bool {
+	is, _ := r.Context().Value(jwtUserContextKey).(bool)
+	return is
+}
+
+// A rateLimitState is where the client of a request stood with the global rate limiter
+// once the request was let through. It's added to the context by rateLimitWith() for
+// the global policy, and shown by the echo endpoint.
+type rateLimitState struct {
+	Key       string  `json:"key"`
+	RPS       float64 `json:"rps,omitempty"`
+	Burst     int     `json:"burst,omitempty"`
+	Remaining float64 `json:"tokens_remaining"`
+	Override  string  `json:"override,omitempty"`
+}
+
+const rateLimitContextKey = contextKey("rateLimit")
+
+// The contextSetRateLimit() helper also records the state in the response metadata.
+func (app *application) contextSetRateLimit(r *http.Request, state *rateLimitState) *http.Request {
+	if meta := contextGetResponseMetadata(r.Context()); meta != nil {
+		meta.rateLimit = state
+	}
+	ctx := context.WithValue(r.Context(), rateLimitContextKey, state)
+	return r.WithContext(ctx)
+}
+
+// The contextGetRateLimit() helper returns the request's rate limit state, or nil if the
+// request wasn't rate limited.
+func (app *application) contextGetRateLimit(r *http.Request) *rateLimitState {
+	state, _ := r.Context().Value(rateLimitContextKey).(*rateLimitState)
+	return state
+}
+
+// The locales a request is served in, most preferred first, are added to its context
+// by the resolveLocale() middleware, under the localesContextKey.
+const localesContextKey = contextKey("locales")
+
+func (app *application) contextSetLocales(r *http.Request, locales []string) *http.Request {
+	ctx := context.WithValue(r.Context(), localesContextKey, locales)
+	return r.WithContext(ctx)
+}
+
+// The contextGetLocales() helper returns the locales of the request. A request which
+// didn't go through resolveLocale() gets the locales of its Accept-Language header.
+func (app *application) contextGetLocales(r *http.Request) []string {
+	locales, ok := r.Context().Value(localesContextKey).([]string)
+	if !ok {
+		return app.acceptedLocales(r)
+	}
+	return locales
+}
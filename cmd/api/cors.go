@@ -0,0 +1,64 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+)
+
+// The request headers browsers may send to the API beyond the CORS-safelisted ones,
+// and the response headers scripts may read beyond the safelisted ones.
+var (
+	corsAllowedHeaders = strings.Join([]string{
+		"Authorization", "Content-Type", "If-Match", "If-Modified-Since", "If-None-Match", "Prefer",
+		"X-Dry-Run", "X-Request-ID", confirmationHeader,
+	}, ", ")
+	corsExposedHeaders = strings.Join([]string{
+		"ETag", "Location", "Retry-After", "X-Request-ID",
+		"X-RateLimit-Limit", "X-RateLimit-Remaining", "X-RateLimit-Reset", "X-RateLimit-Warning",
+		"X-Quota-Limit", "X-Quota-Remaining", "X-Quota-Reset",
+	}, ", ")
+)
+
+// The enableCORS() middleware lets browser applications served from the origins in
+// -cors-trusted-origins call the API. A request from one of them gets an
+// Access-Control-Allow-Origin header naming its origin, so the browser hands the
+// response to the script, and a preflight request, which the browser sends before a
+// request that isn't "simple" (such as one with an Authorization header or a PUT), is
+// answered straight away with the methods and headers it may use. Requests from any
+// other origin are served as usual, without the headers, so browsers keep the response
+// from their scripts.
+//
+// The response depends on the Origin header, so it always says so with Vary: Origin,
+// or a cache could serve one origin's response to another.
+func (app *application) enableCORS(next http.Handler) http.Handler {
+	trusted := make(map[string]bool, len(app.config.cors.trustedOrigins))
+	for _, origin := range app.config.cors.trustedOrigins {
+		trusted[origin] = true
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Origin")
+		w.Header().Add("Vary", "Access-Control-Request-Method")
+
+		origin := r.Header.Get("Origin")
+		if origin == "" || !trusted[origin] {
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+
+		// A preflight request is an OPTIONS request with an
+		// Access-Control-Request-Method header.
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			w.Header().Set("Access-Control-Allow-Methods", "OPTIONS, PUT, PATCH, DELETE")
+			w.Header().Set("Access-Control-Allow-Headers", corsAllowedHeaders)
+			// Browsers may cache the answer for this long (some cap it lower), rather
+			// than sending a preflight before every request.
+			w.Header().Set("Access-Control-Max-Age", "600")
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		w.Header().Set("Access-Control-Expose-Headers", corsExposedHeaders)
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/shyngys9219/greenlight/internal/data"
+	"github.com/shyngys9219/greenlight/internal/validator"
+)
+
+// Admins can define custom fields of movies for their deployment, such as the catalog
+// numbers and notes of a film archive. Movies are created and updated with their values
+// in custom_fields, which are checked against the definitions, and the movie listing
+// can be filtered on them with cf.name[op]=value.
+
+// The listCustomFieldsHandler for the "GET /v1/movie-fields" endpoint returns the
+// definitions of the custom fields, so that clients can build their forms from them.
+func (app *application) listCustomFieldsHandler(w http.ResponseWriter, r *http.Request) {
+	defs, err := app.modelsFor(r).CustomFields.GetAll()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"fields": defs}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The saveCustomFieldHandler for the "PUT /v1/admin/movie-fields/:name" endpoint
+// defines a custom field, or changes its definition. The type of a field can't be
+// changed once it's defined; the field has to be deleted and defined again.
+func (app *application) saveCustomFieldHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Type        string   `json:"type"`
+		Description string   `json:"description"`
+		Required    bool     `json:"required"`
+		MaxLength   *int     `json:"max_length"`
+		Pattern     string   `json:"pattern"`
+		Minimum     *float64 `json:"minimum"`
+		Maximum     *float64 `json:"maximum"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	def := &data.CustomFieldDefinition{
+		Name:        httprouter.ParamsFromContext(r.Context()).ByName("name"),
+		Type:        input.Type,
+		Description: input.Description,
+		Required:    input.Required,
+		MaxLength:   input.MaxLength,
+		Pattern:     input.Pattern,
+		Minimum:     input.Minimum,
+		Maximum:     input.Maximum,
+	}
+
+	v := validator.New()
+	if data.ValidateCustomFieldDefinition(v, def); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.modelsFor(r).CustomFields.Save(def)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrCustomFieldType):
+			v.AddError("type", "can't be changed; delete the field and define it again")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"field": def}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The deleteCustomFieldHandler for the "DELETE /v1/admin/movie-fields/:name" endpoint
+// removes a custom field, along with its values on every movie.
+func (app *application) deleteCustomFieldHandler(w http.ResponseWriter, r *http.Request) {
+	err := app.modelsFor(r).CustomFields.Delete(httprouter.ParamsFromContext(r.Context()).ByName("name"))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	// The cached movies may have had values of the field.
+	app.movieCache.Clear()
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "custom field successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/shyngys9219/greenlight/internal/data"
+	"github.com/shyngys9219/greenlight/internal/validator"
+)
+
+// The exportDatasetHandler for the "GET /v1/admin/export" endpoint returns the catalog,
+// and the data of the user given by the optional user_id query parameter, in the
+// portable dataset format. The response is sent as a download.
+func (app *application) exportDatasetHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	userID := app.readInt(r.URL.Query(), "user_id", 0, v)
+	v.Check(userID >= 0, "user_id", "must be a positive integer")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	ds, err := app.modelsFor(r).Datasets.Export(int64(userID))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Exports are limited by the plan of whoever asks for them, not of the exported
+	// user.
+	ent, err := app.entitlements(r, data.Account{Kind: data.AccountUser, ID: app.contextGetUser(r).ID})
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if ent.ExportMovies > 0 && len(ds.Movies) > ent.ExportMovies {
+		app.planLimitResponse(w, r, fmt.Sprintf("the export has %d movies, more than the %d your plan allows", len(ds.Movies), ent.ExportMovies))
+		return
+	}
+
+	headers := make(http.Header)
+	filename := fmt.Sprintf("greenlight-export-%s.json", ds.ExportedAt.Format("20060102T150405Z"))
+	headers.Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+
+	err = app.writeJSON(w, http.StatusOK, ds, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The importDatasetHandler for the "POST /v1/admin/import" endpoint loads a dataset
+// exported by another deployment. The conflict query parameter decides what happens
+// to movies which already exist here (skip by default).
+func (app *application) importDatasetHandler(w http.ResponseWriter, r *http.Request) {
+	var ds data.Dataset
+	err := app.readJSON(w, r, &ds)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	conflict := app.readString(r.URL.Query(), "conflict", data.ConflictSkip)
+
+	v := validator.New()
+	if data.ValidateDataset(v, &ds, conflict); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	start := time.Now()
+	report, err := app.modelsFor(r).Datasets.Import(&ds, conflict)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	// The import may have changed any movie, and the ratings of those it has reviews of,
+	// so none of the cached ones can be trusted.
+	app.movieCache.Clear()
+
+	app.requestLogger(r).PrintInfo("dataset imported", map[string]string{
+		"admin_id":       fmt.Sprint(app.contextGetUser(r).ID),
+		"movies_created": fmt.Sprint(report.MoviesCreated),
+		"movies_updated": fmt.Sprint(report.MoviesUpdated),
+		"movies_skipped": fmt.Sprint(report.MoviesSkipped),
+		"reviews":        fmt.Sprint(report.ReviewsImported),
+		"watchlist":      fmt.Sprint(report.WatchlistImported),
+		"errors":         fmt.Sprint(len(report.Errors)),
+		"duration":       time.Since(start).String(),
+	})
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"report": report}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
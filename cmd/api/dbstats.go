@@ -0,0 +1,74 @@
+package main
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+
+	"github.com/lib/pq"
+	"github.com/shyngys9219/greenlight/internal/data"
+	"github.com/shyngys9219/greenlight/internal/dbstats"
+)
+
+// In development the database is opened through an instrumented copy of the pq driver,
+// registered under its own name, which counts the queries each request makes.
+const instrumentedDriver = "postgres-dbstats"
+
+func init() {
+	sql.Register(instrumentedDriver, dbstats.Wrap(&pq.Driver{}))
+}
+
+// The modelsFor() method returns the models to use while handling a request, so that
+// their queries are attributed to it. In read-only mode the requests which only read
+// use the replica, if there is one (see readonly.go).
+func (app *application) modelsFor(r *http.Request) data.Models {
+	if app.replicaModels != nil && isReadMethod(r.Method) && app.readOnly.active() {
+		return app.replicaModels.WithContext(r.Context())
+	}
+	return app.models.WithContext(r.Context())
+}
+
+// The collectDBStats() middleware reports how many queries the request executed, how
+// many rows they returned and how long they took in the X-DB-Queries, X-DB-Rows and
+// X-DB-Time response headers. A handler which makes a query per item of a list stands
+// out straight away. It only does anything in the development environment.
+func (app *application) collectDBStats(next http.Handler) http.Handler {
+	if app.config.env != "development" {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		stats := &dbstats.Stats{}
+		r = r.WithContext(dbstats.NewContext(r.Context(), stats))
+		next.ServeHTTP(&dbStatsWriter{ResponseWriter: w, stats: stats}, r)
+	})
+}
+
+// dbStatsWriter adds the statistics headers just before the response headers are
+// written, which is after the handler has run its queries.
+type dbStatsWriter struct {
+	http.ResponseWriter
+	stats       *dbstats.Stats
+	wroteHeader bool
+}
+
+func (sw *dbStatsWriter) WriteHeader(status int) {
+	if !sw.wroteHeader {
+		sw.wroteHeader = true
+		h := sw.Header()
+		h.Set("X-DB-Queries", strconv.FormatInt(sw.stats.Queries(), 10))
+		h.Set("X-DB-Rows", strconv.FormatInt(sw.stats.Rows(), 10))
+		h.Set("X-DB-Time", sw.stats.Duration().String())
+	}
+	sw.ResponseWriter.WriteHeader(status)
+}
+
+func (sw *dbStatsWriter) Write(b []byte) (int, error) {
+	if !sw.wroteHeader {
+		sw.WriteHeader(http.StatusOK)
+	}
+	return sw.ResponseWriter.Write(b)
+}
+
+func (sw *dbStatsWriter) Unwrap() http.ResponseWriter {
+	return sw.ResponseWriter
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/shyngys9219/greenlight/internal/data"
+)
+
+// redactedHeaders are the request headers whose values the echo endpoint never sends
+// back, since they carry credentials.
+var redactedHeaders = []string{"Authorization", "Cookie", "Proxy-Authorization"}
+
+// The echoHandler for the "GET /v1/debug/echo" endpoint sends back what the API made of
+// the request: its headers, the negotiated formats and locales, who it was
+// authenticated as, with what permissions, and where the client stands with the rate
+// limiter. It helps client developers to find out why a request doesn't do what they
+// expect. Outside the development environment only admins with the admin:security
+// permission can call it.
+func (app *application) echoHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+	key := app.contextGetAPIKey(r)
+
+	if app.config.env != "development" {
+		if user.IsAnonymous() {
+			app.authenticationRequiredResponse(w, r)
+			return
+		}
+		permissions, err := app.userPermissions(r, user.ID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		if !permissions.Include("admin:security") {
+			app.notPermittedResponse(w, r)
+			return
+		}
+	}
+
+	headers := r.Header.Clone()
+	for _, name := range redactedHeaders {
+		if values := headers.Values(name); len(values) > 0 {
+			headers[name] = []string{"[redacted]"}
+		}
+	}
+
+	f := appliedFormats(w.Header())
+	negotiation := envelope{
+		"content_type":   "application/json",
+		"accept":         r.Header.Get("Accept"),
+		"runtime_format": f.runtime,
+		"time_format":    f.time,
+		"locales":        app.contextGetLocales(r),
+	}
+
+	principal := envelope{"kind": "anonymous"}
+	permissions := data.Permissions{}
+	switch {
+	case key != nil:
+		principal = envelope{
+			"kind":            "api_key",
+			"api_key_id":      key.ID,
+			"organization_id": key.OrganizationID,
+			"role":            key.Role,
+		}
+	case !user.IsAnonymous():
+		principal = envelope{
+			"kind":      "user",
+			"user_id":   user.ID,
+			"activated": user.Activated,
+			"jwt":       app.contextIsJWTUser(r),
+		}
+		var err error
+		permissions, err = app.userPermissions(r, user.ID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+	// Anonymous users and API keys have the permissions anonymous users are given.
+	if user.IsAnonymous() {
+		permissions = append(permissions, app.config.anonymousPermissions...)
+	}
+
+	echo := envelope{
+		"method":      r.Method,
+		"url":         r.URL.String(),
+		"proto":       r.Proto,
+		"remote_addr": r.RemoteAddr,
+		"headers":     headers,
+		"negotiation": negotiation,
+		"principal":   principal,
+		"permissions": permissions,
+		"rate_limit":  app.contextGetRateLimit(r),
+	}
+
+	err := app.writeJSON(w, http.StatusOK, envelope{"echo": echo}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/json"
+	"expvar"
+	"reflect"
+	"strings"
+)
+
+// deprecatedFieldsServed counts, per field, the responses which included a deprecated
+// field, so we can tell when a field is no longer used and can be removed.
+var deprecatedFieldsServed = expvar.NewMap("deprecated_fields_served")
+
+// A deprecation is a notice, sent in the metadata of a response, that a field in the
+// response is going away.
+type deprecation struct {
+	Field  string `json:"field"`
+	Notice string `json:"notice"`
+}
+
+// Fields are marked as deprecated with a struct tag holding the notice, for example:
+//
+//	Runtime int32 `json:"runtime" deprecated:"use runtime_minutes instead"`
+//
+// Representations can be deprecated too: legacyRuntimeNotice is sent with runtimes in
+// the text format ("102 mins"), which is only kept for older clients.
+const legacyRuntimeNotice = "the text runtime format is deprecated, use runtime_format=minutes or iso8601 instead"
+
+// The deprecations() method finds the deprecated fields in a response, with their
+// paths from the top of the response (array elements are written as "[]"). Each field
+// is reported, and counted in the metrics, once per response.
+func (f formats) deprecations(data any) []deprecation {
+	var notices []deprecation
+	seen := make(map[string]bool)
+	walkFields(reflect.ValueOf(data), "", func(path string, field reflect.StructField, name string) {
+		notice, ok := field.Tag.Lookup("deprecated")
+		if !ok && f.runtime == runtimeText && (name == "runtime" || name == "runtimes") {
+			notice, ok = legacyRuntimeNotice, true
+		}
+		if !ok || seen[path] {
+			return
+		}
+		seen[path] = true
+		notices = append(notices, deprecation{Field: path, Notice: notice})
+		deprecatedFieldsServed.Add(path, 1)
+	})
+	return notices
+}
+
+// walkFields calls visit for every exported struct field reachable from v, following
+// pointers, interfaces, maps and slices, with the field's path and JSON name. Fields
+// left out of the JSON (tagged "-", or empty with omitempty) aren't visited.
+func walkFields(v reflect.Value, path string, visit func(path string, field reflect.StructField, name string)) {
+	join := func(name string) string {
+		if path == "" {
+			return name
+		}
+		return path + "." + name
+	}
+
+	switch v.Kind() {
+	case reflect.Pointer, reflect.Interface:
+		if !v.IsNil() {
+			walkFields(v.Elem(), path, visit)
+		}
+	case reflect.Map:
+		if v.Type().Key().Kind() != reflect.String {
+			return
+		}
+		iter := v.MapRange()
+		for iter.Next() {
+			walkFields(iter.Value(), join(iter.Key().String()), visit)
+		}
+	case reflect.Slice, reflect.Array:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			return
+		}
+		for i := 0; i < v.Len(); i++ {
+			walkFields(v.Index(i), path+"[]", visit)
+		}
+	case reflect.Struct:
+		// Values which marshal themselves, such as time.Time, are leaves.
+		if v.Type().Implements(marshalerType) || reflect.PtrTo(v.Type()).Implements(marshalerType) {
+			return
+		}
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			if !field.IsExported() {
+				continue
+			}
+			name, opts, _ := strings.Cut(field.Tag.Get("json"), ",")
+			if name == "-" {
+				continue
+			}
+			if name == "" {
+				name = field.Name
+			}
+			fv := v.Field(i)
+			if strings.Contains(opts, "omitempty") && fv.IsZero() {
+				continue
+			}
+			visit(join(name), field, name)
+			walkFields(fv, join(name), visit)
+		}
+	}
+}
+
+var marshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
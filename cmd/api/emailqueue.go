@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"expvar"
+	"fmt"
+	"time"
+
+	"github.com/shyngys9219/greenlight/internal/data"
+	"github.com/shyngys9219/greenlight/internal/mailer"
+	"github.com/shyngys9219/greenlight/internal/notify"
+)
+
+// Emails aren't sent by the code which wants them sent. They're rendered by the
+// notifier, put in the email_queue table by enqueueEmail() (see notifications.go), and
+// sent by a pool of -email-workers workers on every replica. A failed attempt is retried
+// with exponential backoff, starting at -email-retry-backoff; an email which fails
+// -email-max-attempts times, or is rejected outright by the mail provider, is
+// dead-lettered: kept in the table with its last error, and logged. A restart or an outage of the provider therefore delays emails,
+// but doesn't lose them.
+
+const (
+	// emailQueuePollInterval is how often idle workers look for emails which are due,
+	// such as ones queued on another replica or due to be retried.
+	emailQueuePollInterval = 5 * time.Second
+	// emailQueueLease is how long a worker has to send an email it claimed, before
+	// another one may claim it again.
+	emailQueueLease = 2 * time.Minute
+	// emailMaxBackoff caps the time between two attempts to send an email.
+	emailMaxBackoff = 6 * time.Hour
+	// emailBacklogThreshold is how long an email may wait before the email_queue health
+	// probe reports the queue as failing.
+	emailBacklogThreshold = 15 * time.Minute
+)
+
+// emailMetrics counts what happens to queued emails: how many were queued, sent, failed
+// an attempt, and were dead-lettered.
+var emailMetrics = expvar.NewMap("emails")
+
+// The enqueueEmail() helper queues a notification rendered by the notifier to be
+// emailed to the recipient. Idle workers on this replica are woken up straight away.
+func (app *application) enqueueEmail(recipient string, msg *notify.Message) error {
+	err := app.models.EmailQueue.Insert(&data.QueuedEmail{
+		Recipient: recipient,
+		Template:  msg.Template,
+		Subject:   msg.Subject,
+		PlainBody: msg.PlainBody,
+		HTMLBody:  msg.HTMLBody,
+	})
+	if err != nil {
+		return err
+	}
+	emailMetrics.Add("queued", 1)
+
+	select {
+	case app.emailKick <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// The startEmailWorkers() method starts the workers which send the queued emails. They
+// run as background tasks, so the shutdown waits for the emails they're sending, and
+// stop once the scheduled jobs are stopped.
+func (app *application) startEmailWorkers() {
+	for i := 0; i < app.config.emailQueue.workers; i++ {
+		app.background(backgroundTask{name: "email_worker", fn: app.runEmailWorker})
+	}
+}
+
+// The runEmailWorker() method sends queued emails one at a time until none are due,
+// then waits to be woken up by a new email or the poll interval.
+func (app *application) runEmailWorker() error {
+	ticker := time.NewTicker(emailQueuePollInterval)
+	defer ticker.Stop()
+	for {
+		for {
+			emails, err := app.models.EmailQueue.Claim(1, emailQueueLease)
+			if err != nil {
+				app.logger.PrintError(err, map[string]string{"task": "email_worker"})
+				break
+			}
+			if len(emails) == 0 {
+				break
+			}
+			app.sendQueuedEmail(emails[0])
+
+			select {
+			case <-app.stopJobs:
+				return nil
+			default:
+			}
+		}
+
+		select {
+		case <-ticker.C:
+		case <-app.emailKick:
+		case <-app.stopJobs:
+			return nil
+		}
+	}
+}
+
+// The sendQueuedEmail() method makes an attempt to send a claimed email, and records
+// the outcome.
+func (app *application) sendQueuedEmail(email *data.QueuedEmail) {
+	err := app.mailer.SendMessage(email.Recipient, &mailer.Message{
+		Subject:   email.Subject,
+		PlainBody: email.PlainBody,
+		HTMLBody:  email.HTMLBody,
+	})
+	if err == nil {
+		emailMetrics.Add("sent", 1)
+		err = app.models.EmailQueue.MarkSent(email.ID)
+		if err != nil {
+			app.logger.PrintError(err, map[string]string{"email_id": fmt.Sprint(email.ID)})
+		}
+		return
+	}
+
+	emailMetrics.Add("failed_attempts", 1)
+	properties := map[string]string{
+		"email_id": fmt.Sprint(email.ID),
+		"template": email.Template,
+		"attempts": fmt.Sprint(email.Attempts),
+	}
+	if mailer.IsPermanent(err) || email.Attempts >= app.config.emailQueue.maxAttempts {
+		emailMetrics.Add("dead_lettered", 1)
+		properties["dead_lettered"] = "true"
+		app.logger.PrintError(err, properties)
+		err = app.models.EmailQueue.DeadLetter(email.ID, err.Error())
+	} else {
+		next := time.Now().Add(app.emailBackoff(email.Attempts))
+		properties["next_attempt_at"] = next.UTC().Format(time.RFC3339)
+		app.logger.PrintInfo(fmt.Sprintf("sending email failed: %s", err), properties)
+		err = app.models.EmailQueue.Retry(email.ID, next, err.Error())
+	}
+	if err != nil {
+		app.logger.PrintError(err, map[string]string{"email_id": fmt.Sprint(email.ID)})
+	}
+}
+
+// The emailBackoff() method returns how long to wait before the next attempt to send
+// an email which has failed the given number of attempts: -email-retry-backoff, doubled
+// for every attempt after the first, up to emailMaxBackoff.
+func (app *application) emailBackoff(attempts int) time.Duration {
+	backoff := app.config.emailQueue.backoff
+	for i := 1; i < attempts && backoff < emailMaxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > emailMaxBackoff {
+		backoff = emailMaxBackoff
+	}
+	return backoff
+}
+
+// The checkEmailQueue() probe fails when an email has been waiting to be sent for
+// longer than emailBacklogThreshold, which means the workers are stuck or every attempt
+// is failing. Dead letters don't fail it; they're reported by the email_queue metric.
+func (app *application) checkEmailQueue(ctx context.Context) error {
+	stats, err := app.models.WithContext(ctx).EmailQueue.Stats()
+	if err != nil {
+		return err
+	}
+	oldest := time.Duration(stats.OldestPendingSeconds * float64(time.Second))
+	if oldest > emailBacklogThreshold {
+		return fmt.Errorf("%d emails pending, the oldest for %s", stats.Pending, oldest.Round(time.Second))
+	}
+	return nil
+}
+
+// The deleteOldEmails() job deletes sent emails after a week, and dead letters after a
+// month.
+func (app *application) deleteOldEmails() error {
+	now := time.Now()
+	return app.models.EmailQueue.DeleteBefore(now.AddDate(0, 0, -7), now.AddDate(0, -1, 0))
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/shyngys9219/greenlight/internal/data"
+	"github.com/shyngys9219/greenlight/internal/enrich"
+	"github.com/shyngys9219/greenlight/internal/validator"
+)
+
+// Movies are enriched with facts the catalog doesn't keep itself, such as ratings and
+// box office takings, from the metadata provider of -enrich-provider. Each fact records
+// its source, the provider or "manual", and curators can set facts by hand through the
+// /v1/movies/:id/facts endpoints. Manual facts always win: the enrichment job leaves
+// them alone until they're deleted.
+
+const (
+	// enrichmentBatch is the number of movies read from the database at a time.
+	enrichmentBatch = 100
+	// enrichmentMaxFailures is the number of lookups in a row which may fail before
+	// the run gives up, as the provider is probably down or the key rejected. The
+	// movies which weren't reached are tried again the next night.
+	enrichmentMaxFailures = 10
+	// enrichmentAge is how long after its last lookup a movie is looked up again. It's
+	// a bit less than a day, so that every movie is refreshed every night.
+	enrichmentAge = 20 * time.Hour
+)
+
+// The enrichMovies() job looks up the movies which are due at the metadata provider
+// and stores what it knows about them. It's run every hour, but only does anything in
+// the -enrich-hour, so that the provider is called at night. It goes on until every
+// movie is done, or the server shuts down.
+func (app *application) enrichMovies() error {
+	if time.Now().UTC().Hour() != app.config.enrich.hour {
+		return nil
+	}
+
+	var checked, stored, notFound, failures int
+	for {
+		movies, err := app.models.MovieFacts.DueForEnrichment(time.Now().Add(-enrichmentAge), enrichmentBatch)
+		if err != nil {
+			return err
+		}
+		if len(movies) == 0 {
+			break
+		}
+		for _, movie := range movies {
+			select {
+			case <-app.stopJobs:
+				return nil
+			default:
+			}
+
+			values, lookupErr := app.lookupMovieFacts(movie)
+			switch {
+			case errors.Is(lookupErr, enrich.ErrNotFound):
+				notFound++
+			case lookupErr != nil:
+				failures++
+				if failures >= enrichmentMaxFailures {
+					return fmt.Errorf("enrichment stopped after %d failed lookups: %w", failures, lookupErr)
+				}
+			default:
+				failures = 0
+			}
+
+			var errMessage string
+			if lookupErr != nil {
+				errMessage = lookupErr.Error()
+			}
+			// The movie is marked as looked up even if the lookup failed, so that the
+			// run moves on to the next ones rather than trying it again.
+			n, err := app.models.MovieFacts.SaveEnrichment(movie.ID, app.enricher.Name(), values, errMessage)
+			if err != nil {
+				return err
+			}
+			checked++
+			stored += n
+		}
+	}
+
+	if checked > 0 {
+		app.logger.PrintInfo("enriched movies", map[string]string{
+			"provider":  app.enricher.Name(),
+			"movies":    strconv.Itoa(checked),
+			"facts":     strconv.Itoa(stored),
+			"not_found": strconv.Itoa(notFound),
+		})
+	}
+	return nil
+}
+
+// The lookupMovieFacts() helper looks a movie up at the metadata provider and returns
+// the facts it knows, by field.
+func (app *application) lookupMovieFacts(movie *data.Movie) (map[string]float64, error) {
+	facts, err := app.enricher.Lookup(movie.Title, movie.Year)
+	if err != nil {
+		return nil, err
+	}
+	values := make(map[string]float64)
+	for field, value := range map[string]*float64{
+		data.FactRating:     facts.Rating,
+		data.FactPopularity: facts.Popularity,
+		data.FactBoxOffice:  facts.BoxOffice,
+	} {
+		if value != nil {
+			values[field] = *value
+		}
+	}
+	return values, nil
+}
+
+// The listMovieFactsHandler for the "GET /v1/movies/:id/facts" endpoint returns the
+// facts about a movie, with the source of each.
+func (app *application) listMovieFactsHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readMovieIDParam(r)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	_, err = app.modelsFor(r).Movies.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	facts, err := app.modelsFor(r).MovieFacts.GetForMovie(id)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"facts": facts}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The setMovieFactHandler for the "PUT /v1/movies/:id/facts/:field" endpoint lets
+// curators set a fact about a movie by hand. The provider won't overwrite it.
+func (app *application) setMovieFactHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readMovieIDParam(r)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	var input struct {
+		Value *float64 `json:"value"`
+	}
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	fact := &data.MovieFact{Field: httprouter.ParamsFromContext(r.Context()).ByName("field")}
+	v := validator.New()
+	v.Check(input.Value != nil, "value", "must be provided")
+	if input.Value != nil {
+		fact.Value = *input.Value
+	}
+	if data.ValidateMovieFact(v, fact); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.modelsFor(r).MovieFacts.SetManual(id, fact)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"fact": fact}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The deleteMovieFactHandler for the "DELETE /v1/movies/:id/facts/:field" endpoint
+// removes a fact about a movie. For a manual fact, this hands the field back to the
+// provider, which fills it in again on its next run.
+func (app *application) deleteMovieFactHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readMovieIDParam(r)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.modelsFor(r).MovieFacts.Delete(id, httprouter.ParamsFromContext(r.Context()).ByName("field"))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "fact successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
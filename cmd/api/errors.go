@@ -3,6 +3,8 @@ package main
 import (
 	"fmt"
 	"net/http"
+	"strconv"
+	"time"
 )
 
 func (app *application) invalidCredentialsResponse(w http.ResponseWriter, r *http.Request) {
@@ -12,7 +14,7 @@ func (app *application) invalidCredentialsResponse(w http.ResponseWriter, r *htt
 
 // The logError() method is a generic helper for logging an error message.
 func (app *application) logError(r *http.Request, err error) {
-	app.logger.PrintInfo(fmt.Sprintf("The error is %s", err), map[string]string{
+	app.requestLogger(r).PrintInfo(fmt.Sprintf("The error is %s", err), map[string]string{
 		"request_method": r.Method,
 		"request_url":    r.URL.String(),
 	})
@@ -20,8 +22,12 @@ func (app *application) logError(r *http.Request, err error) {
 
 // The errorResponse() method is a generic helper for sending JSON-formatted error
 // messages to the client with a given status code. CHANGE "interface" to "any" if go version is 1.18 or newer
+// The ID of the request goes next to the error, for users to quote when they report it.
 func (app *application) errorResponse(w http.ResponseWriter, r *http.Request, status int, message interface{}) {
 	env := envelope{"error": message}
+	if id := contextGetRequestID(r.Context()); id != "" {
+		env["request_id"] = id
+	}
 	// Write the response using the writeJSON() helper. If this happens to return an
 	// error then log it, and fall back to sending the client an empty response with a
 	// 500 Internal Server Error status code.
@@ -32,14 +38,24 @@ func (app *application) errorResponse(w http.ResponseWriter, r *http.Request, st
 	}
 }
 
+// serverErrorMessage is the message of every 500 Internal Server Error response, which
+// doesn't reveal anything about what went wrong.
+const serverErrorMessage = "the server encountered a problem and could not process your request"
+
 // The serverErrorResponse() method will be used when our application encounters an
 // unexpected problem at runtime. It logs the detailed error message, then uses the
 // errorResponse() helper to send a 500 Internal Server Error status code and JSON
 // response (containing a generic error message) to the client.
 func (app *application) serverErrorResponse(w http.ResponseWriter, r *http.Request, err error) {
+	// A write refused because the database has become read-only puts the API in
+	// read-only mode, and the client is told to try again later.
+	if isReadOnlyError(err) {
+		app.enterReadOnly(err.Error())
+		app.readOnlyResponse(w, r)
+		return
+	}
 	app.logError(r, err)
-	message := "the server encountered a problem and could not process your request"
-	app.errorResponse(w, r, http.StatusInternalServerError, message)
+	app.errorResponse(w, r, http.StatusInternalServerError, serverErrorMessage)
 }
 
 // The notFoundResponse() method will be used to send a 404 Not Found status code and
@@ -61,6 +77,27 @@ func (app *application) rateLimitExceededResponse(w http.ResponseWriter, r *http
 	app.errorResponse(w, r, http.StatusTooManyRequests, message)
 }
 
+func (app *application) quotaExceededResponse(w http.ResponseWriter, r *http.Request) {
+	message := "the daily request quota of your plan has been used up"
+	app.errorResponse(w, r, http.StatusTooManyRequests, message)
+}
+
+// The planLimitResponse() method is sent when a request needs more than the caller's
+// plan allows, for example a bigger export.
+func (app *application) planLimitResponse(w http.ResponseWriter, r *http.Request, message string) {
+	app.errorResponse(w, r, http.StatusForbidden, message+"; upgrade your plan to do this")
+}
+
+func (app *application) billingNotConfiguredResponse(w http.ResponseWriter, r *http.Request) {
+	message := "billing is not available on this server"
+	app.errorResponse(w, r, http.StatusServiceUnavailable, message)
+}
+
+func (app *application) twoFactorNotConfiguredResponse(w http.ResponseWriter, r *http.Request) {
+	message := "two-factor authentication is not available on this server"
+	app.errorResponse(w, r, http.StatusServiceUnavailable, message)
+}
+
 // Note that the errors parameter here has the type map[string]string, which is exactly
 // the same as the errors map contained in our Validator type.
 func (app *application) failedValidationResponse(w http.ResponseWriter, r *http.Request, errors map[string]string) {
@@ -76,8 +113,104 @@ func (app *application) editConflictResponse(w http.ResponseWriter, r *http.Requ
 	app.errorResponse(w, r, http.StatusConflict, message)
 }
 
+// The preconditionFailedResponse() method is the counterpart of editConflictResponse()
+// for endpoints which use ETags: the If-Match header didn't match the current version
+// of the record.
+func (app *application) preconditionFailedResponse(w http.ResponseWriter, r *http.Request) {
+	message := "the record has been modified since you last fetched it, please fetch it again"
+	app.errorResponse(w, r, http.StatusPreconditionFailed, message)
+}
+
+func (app *application) preconditionRequiredResponse(w http.ResponseWriter, r *http.Request) {
+	message := "this request must include an If-Match header with the record's ETag"
+	app.errorResponse(w, r, http.StatusPreconditionRequired, message)
+}
+
 func (app *application) invalidAuthenticationTokenResponse(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("WWW-Authenticate", "Bearer")
 	message := "invalid or missing authentication token"
 	app.errorResponse(w, r, http.StatusUnauthorized, message)
 }
+
+func (app *application) authenticationRequiredResponse(w http.ResponseWriter, r *http.Request) {
+	message := "you must be authenticated to access this resource"
+	app.errorResponse(w, r, http.StatusUnauthorized, message)
+}
+
+func (app *application) inactiveAccountResponse(w http.ResponseWriter, r *http.Request) {
+	message := "your user account must be activated to access this resource"
+	app.errorResponse(w, r, http.StatusForbidden, message)
+}
+
+func (app *application) deactivatedAccountResponse(w http.ResponseWriter, r *http.Request) {
+	message := "your user account has been deactivated"
+	app.errorResponse(w, r, http.StatusForbidden, message)
+}
+
+func (app *application) notPermittedResponse(w http.ResponseWriter, r *http.Request) {
+	message := "your user account doesn't have the necessary permissions to access this resource"
+	app.errorResponse(w, r, http.StatusForbidden, message)
+}
+
+func (app *application) passwordResetRequiredResponse(w http.ResponseWriter, r *http.Request) {
+	message := "your password must be reset before you can log in again"
+	app.errorResponse(w, r, http.StatusForbidden, message)
+}
+
+// The accountLockedResponse() method is sent when a user tries to log in to an account
+// which is locked after too many failed logins. Retry-After says when it unlocks.
+func (app *application) accountLockedResponse(w http.ResponseWriter, r *http.Request, until time.Time) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(time.Until(until).Seconds())+1))
+	message := "your user account is locked after too many failed logins, please try again later"
+	app.errorResponse(w, r, http.StatusLocked, message)
+}
+
+// The accountNotActivatedResponse() method is sent when a user with an unactivated
+// account tries to log in. Besides the usual error message it includes a
+// machine-readable code, so clients can show an "activate your account" flow, and
+// whether a new activation email has just been sent.
+func (app *application) accountNotActivatedResponse(w http.ResponseWriter, r *http.Request, emailSent bool) {
+	env := envelope{
+		"error":                 "your user account must be activated before you can log in",
+		"code":                  "account_not_activated",
+		"activation_email_sent": emailSent,
+	}
+	err := app.writeJSON(w, http.StatusForbidden, env, nil)
+	if err != nil {
+		app.logError(r, err)
+		w.WriteHeader(500)
+	}
+}
+
+// The accountHeldResponse() method is sent when a user whose account is held for
+// moderation, after writing too fast, tries to write, with a machine-readable code so
+// clients can tell them why. See abuse.go.
+func (app *application) accountHeldResponse(w http.ResponseWriter, r *http.Request) {
+	env := envelope{
+		"error": "your user account is held for moderation and can't post for now",
+		"code":  "account_held",
+	}
+	if id := contextGetRequestID(r.Context()); id != "" {
+		env["request_id"] = id
+	}
+	err := app.writeJSON(w, http.StatusForbidden, env, nil)
+	if err != nil {
+		app.logError(r, err)
+		w.WriteHeader(500)
+	}
+}
+
+// The twoFactorRequiredResponse() method is sent when the password of a user with
+// two-factor authentication is right but no code came with it, with a machine-readable
+// code so clients know to ask for one and try again.
+func (app *application) twoFactorRequiredResponse(w http.ResponseWriter, r *http.Request) {
+	env := envelope{
+		"error": "a two-factor authentication code is required",
+		"code":  "two_factor_required",
+	}
+	err := app.writeJSON(w, http.StatusUnauthorized, env, nil)
+	if err != nil {
+		app.logError(r, err)
+		w.WriteHeader(500)
+	}
+}
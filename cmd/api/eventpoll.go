@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/shyngys9219/greenlight/internal/data"
+	"github.com/shyngys9219/greenlight/internal/events"
+	"github.com/shyngys9219/greenlight/internal/validator"
+)
+
+// Clients get the domain events which concern them through app.eventHub, which buffers
+// the last eventHubSize of them. GET /v1/events/poll reads them by long polling, for
+// clients behind proxies which break streaming responses. Like the event bus, the hub
+// only holds the events of this replica, so deployments with several replicas should
+// send a user's polls to the same one.
+
+const (
+	// eventHubSize is the number of events the hub keeps for clients to catch up on.
+	eventHubSize = 1000
+	// eventPollLimit is the maximum number of events returned by a poll.
+	eventPollLimit = 100
+	// eventPollDefaultWait and eventPollMaxWait are the default and the longest time a
+	// poll waits for an event. The longest wait stays below the server's WriteTimeout.
+	eventPollDefaultWait = 20 * time.Second
+	eventPollMaxWait     = 25 * time.Second
+)
+
+// The registerEventHub() method adds the domain events which clients are told about to
+// the event hub: new movies and reviews go to everyone, and changes to an account and
+// in-app notifications only to its user.
+func (app *application) registerEventHub() {
+	app.events.Subscribe(events.MovieCreated, func(e events.Event) {
+		if movie, ok := e.Payload.(*data.Movie); ok {
+			app.eventHub.Add(0, e.Type, e.Time, envelope{"movie": movie})
+		}
+	})
+	app.events.Subscribe(events.ReviewPosted, func(e events.Event) {
+		if review, ok := e.Payload.(*data.Review); ok {
+			app.eventHub.Add(0, e.Type, e.Time, envelope{"review": review})
+		}
+	})
+	app.events.Subscribe(events.UserActivated, func(e events.Event) {
+		if user, ok := e.Payload.(*data.User); ok {
+			app.eventHub.Add(user.ID, e.Type, e.Time, envelope{"user": user})
+		}
+	})
+	app.events.Subscribe(events.NotificationCreated, func(e events.Event) {
+		if notification, ok := e.Payload.(*data.Notification); ok {
+			app.eventHub.Add(notification.UserID, e.Type, e.Time, envelope{"notification": notification})
+		}
+	})
+	app.events.Subscribe(events.PermissionsChanged, func(e events.Event) {
+		if userID, ok := e.Payload.(int64); ok {
+			app.eventHub.Add(userID, e.Type, e.Time, nil)
+		}
+	})
+}
+
+// The pollEventsHandler for the "GET /v1/events/poll" endpoint returns the events for
+// the current user after the since cursor, waiting up to wait seconds (20 by default)
+// for one if there are none yet. Without a cursor it waits for the next event. The
+// response holds the cursor to poll from next; missed is true if events after since may
+// have been dropped, such as after a restart of the server, in which case the client
+// should reload whatever it keeps up to date with the events.
+func (app *application) pollEventsHandler(w http.ResponseWriter, r *http.Request) {
+	qs := r.URL.Query()
+	v := validator.New()
+	since := app.readString(qs, "since", "")
+	wait := time.Duration(app.readInt(qs, "wait", int(eventPollDefaultWait/time.Second), v)) * time.Second
+	v.Check(wait >= 0 && wait <= eventPollMaxWait, "wait", "must be between 0 and 25")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	userID := app.contextGetUser(r).ID
+	// An empty cursor reads from the latest event, so take it now, before waiting.
+	if since == "" {
+		since = app.eventHub.Cursor()
+	}
+
+	changed := app.eventHub.Changed()
+	deliveries, next, missed, err := app.eventHub.Read(since, userID, eventPollLimit)
+	if err == nil && len(deliveries) == 0 && !missed && wait > 0 {
+		ctx, cancel := context.WithTimeout(r.Context(), wait)
+		defer cancel()
+		select {
+		case <-changed:
+		case <-ctx.Done():
+		}
+		// Read once more whatever woke us up, even if the new event was for another
+		// user, rather than going back to waiting: the client polls again anyway.
+		deliveries, next, missed, err = app.eventHub.Read(since, userID, eventPollLimit)
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, events.ErrInvalidCursor):
+			v.AddError("since", "must be a cursor returned by a previous poll")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Cache-Control", "no-store")
+	env := envelope{"events": deliveries, "cursor": next, "missed": missed}
+	err = app.writeJSON(w, http.StatusOK, env, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
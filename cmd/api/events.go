@@ -0,0 +1,33 @@
+package main
+
+import (
+	"github.com/shyngys9219/greenlight/internal/events"
+)
+
+// The publish() helper publishes a domain event on the application's event bus.
+func (app *application) publish(eventType string, payload any) {
+	app.events.Publish(events.Event{Type: eventType, Payload: payload})
+}
+
+// The subscribe() helper registers a handler for a domain event. Each call of the
+// handler runs as a named background task, so a slow or failing subscriber never holds
+// up the request which published the event.
+func (app *application) subscribe(eventType, name string, fn func(events.Event) error) {
+	app.events.Subscribe(eventType, func(e events.Event) {
+		app.background(backgroundTask{
+			name: name,
+			fn:   func() error { return fn(e) },
+		})
+	})
+}
+
+// The registerSubscribers() method wires up every subscriber of the application. It's
+// called once from main() after the application struct has been created.
+func (app *application) registerSubscribers() {
+	app.subscribe(events.MovieCreated, "notify_followers", app.notifyFollowers)
+	app.subscribe(events.CreditAdded, "notify_person_followers", app.notifyPersonFollowers)
+	app.subscribe(events.AvailabilityChanged, "queue_webhooks", app.queueAvailabilityWebhooks)
+	app.events.Subscribe(events.PermissionsChanged, app.invalidatePermissions)
+	app.registerBusinessMetrics()
+	app.registerEventHub()
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/shyngys9219/greenlight/internal/data"
+	"github.com/shyngys9219/greenlight/internal/events"
+	"github.com/shyngys9219/greenlight/internal/notify"
+	"github.com/shyngys9219/greenlight/internal/validator"
+)
+
+// The listFollowsHandler for the "GET /v1/users/me/follows" endpoint returns the
+// genres and people the current user follows. It honours If-Modified-Since, so clients
+// which poll it for changes only download it again once it has changed.
+func (app *application) listFollowsHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	// The watermark is read before the listing, so a change made in between makes the
+	// Last-Modified time older than the listing, rather than newer.
+	modified, err := app.modelsFor(r).ListWatermarks.Get(data.FollowsList(user.ID))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if app.notModified(w, r, modified) {
+		return
+	}
+
+	follows, err := app.modelsFor(r).Follows.GetAllForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"follows": follows}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The createFollowHandler for the "POST /v1/users/me/follows" endpoint follows a genre
+// or a person for the current user.
+func (app *application) createFollowHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Kind  string `json:"kind"`
+		Value string `json:"value"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	follow := &data.Follow{
+		UserID: app.contextGetUser(r).ID,
+		Kind:   input.Kind,
+		Value:  input.Value,
+	}
+
+	v := validator.New()
+	if data.ValidateFollow(v, follow); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.modelsFor(r).Follows.Insert(follow)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("value", "must be an existing person")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"follow": follow}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The deleteFollowHandler for the "DELETE /v1/users/me/follows/:kind/:value" endpoint
+// unfollows a genre or person.
+func (app *application) deleteFollowHandler(w http.ResponseWriter, r *http.Request) {
+	params := httprouter.ParamsFromContext(r.Context())
+	user := app.contextGetUser(r)
+
+	err := app.modelsFor(r).Follows.Delete(user.ID, params.ByName("kind"), params.ByName("value"))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "follow successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// creditNotificationWindow is how long after a movie is added that crediting someone on
+// it still notifies their followers of the new movie. Credits added later are edits of
+// an older movie, which isn't news to them.
+const creditNotificationWindow = 24 * time.Hour
+
+// The notifyFollowers() subscriber notifies everyone following one of the genres, or
+// one of the people credited on, a newly created movie.
+func (app *application) notifyFollowers(e events.Event) error {
+	movie, ok := e.Payload.(*data.Movie)
+	if !ok {
+		return fmt.Errorf("unexpected payload %T for %s event", e.Payload, e.Type)
+	}
+
+	personIDs, err := app.creditedPeople(movie.ID)
+	if err != nil {
+		return err
+	}
+
+	followers, err := app.models.Follows.GetFollowersForMovie(movie.Genres, personIDs)
+	if err != nil {
+		return err
+	}
+
+	app.sendNewMovieNotifications(movie, followers)
+	return nil
+}
+
+// The notifyPersonFollowers() subscriber notifies the followers of a person credited on
+// a movie which was added recently, since credits usually come in after the movie
+// itself. Followers who were told about the movie already, through one of its genres
+// or another of its people, aren't told again.
+func (app *application) notifyPersonFollowers(e events.Event) error {
+	credit, ok := e.Payload.(*data.Credit)
+	if !ok {
+		return fmt.Errorf("unexpected payload %T for %s event", e.Payload, e.Type)
+	}
+
+	movie, err := app.models.Movies.Get(credit.MovieID)
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			return nil
+		}
+		return err
+	}
+	if time.Since(movie.CreatedAt) > creditNotificationWindow {
+		return nil
+	}
+
+	credits, err := app.models.People.GetCreditsForMovie(movie.ID)
+	if err != nil {
+		return err
+	}
+	var personIDs []int64
+	for _, c := range credits {
+		// A person credited twice, say as director and writer, was announced the first time.
+		if c.PersonID == credit.PersonID && c.ID != credit.ID {
+			return nil
+		}
+		personIDs = append(personIDs, c.PersonID)
+	}
+
+	followers, err := app.models.Follows.GetFollowersForMovie(movie.Genres, personIDs)
+	if err != nil {
+		return err
+	}
+
+	matched := fmt.Sprintf("person:%d", credit.PersonID)
+	var news []*data.Follower
+	for _, follower := range followers {
+		if len(follower.Matched) == 1 && follower.Matched[0] == matched {
+			news = append(news, follower)
+		}
+	}
+
+	app.sendNewMovieNotifications(movie, news)
+	return nil
+}
+
+// The creditedPeople() helper returns the IDs of the people credited on a movie, once
+// each.
+func (app *application) creditedPeople(movieID int64) ([]int64, error) {
+	credits, err := app.models.People.GetCreditsForMovie(movieID)
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[int64]bool, len(credits))
+	var ids []int64
+	for _, c := range credits {
+		if !seen[c.PersonID] {
+			seen[c.PersonID] = true
+			ids = append(ids, c.PersonID)
+		}
+	}
+	return ids, nil
+}
+
+// The sendNewMovieNotifications() helper tells each of the followers about a new movie.
+// A failed notification is logged, and doesn't stop the others.
+func (app *application) sendNewMovieNotifications(movie *data.Movie, followers []*data.Follower) {
+	for _, follower := range followers {
+		data := map[string]any{
+			"name":       follower.Name,
+			"movieTitle": movie.Title,
+			"movieYear":  movie.Year,
+			"movieID":    movie.ID,
+			"matched":    follower.Matched,
+		}
+		to := notify.Recipient{UserID: follower.UserID, Name: follower.Name, Email: follower.Email}
+		err := app.notifier.Send(to, notifyNewMovie, data)
+		if err != nil {
+			app.logger.PrintError(err, map[string]string{
+				"movie_id": fmt.Sprint(movie.ID),
+				"user_id":  fmt.Sprint(follower.UserID),
+			})
+		}
+	}
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/shyngys9219/greenlight/internal/validator"
+)
+
+// Define constants for the representations a client can ask for. The first of each
+// group is the default.
+const (
+	runtimeMinutes = "minutes" // "102"
+	runtimeText    = "text"    // "102 mins"
+	runtimeISO8601 = "iso8601" // "PT1H42M"
+
+	timeRFC3339 = "rfc3339" // "2022-03-01T12:30:00Z"
+	timeUnix    = "unix"    // 1646137800
+)
+
+// formats holds the representations negotiated for a request.
+type formats struct {
+	runtime string
+	time    string
+}
+
+var defaultFormats = formats{runtime: runtimeMinutes, time: timeRFC3339}
+
+// The negotiateFormats() middleware works out which representations of runtimes and
+// timestamps the client wants. They can be asked for with the runtime_format and
+// time_format query string parameters, or with a Prefer header such as "Prefer:
+// runtime-format=iso8601, time-format=unix". Unsupported preferences in the header are
+// ignored, as RFC 7240 requires, while unsupported query string values are an error.
+//
+// The outcome is recorded in the Preference-Applied response header, which is where
+// writeJSON() picks it up. Doing it that way means that the handlers don't need to know
+// anything about it.
+func (app *application) negotiateFormats(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f := defaultFormats
+
+		for _, pref := range strings.Split(r.Header.Get("Prefer"), ",") {
+			name, value, _ := strings.Cut(strings.TrimSpace(pref), "=")
+			value = strings.Trim(strings.TrimSpace(value), `"`)
+			switch {
+			case name == "runtime-format" && validator.PermittedValue(value, runtimeMinutes, runtimeText, runtimeISO8601):
+				f.runtime = value
+			case name == "time-format" && validator.PermittedValue(value, timeRFC3339, timeUnix):
+				f.time = value
+			}
+		}
+
+		qs := r.URL.Query()
+		v := validator.New()
+		f.runtime = app.readString(qs, "runtime_format", f.runtime)
+		f.time = app.readString(qs, "time_format", f.time)
+		v.Check(validator.PermittedValue(f.runtime, runtimeMinutes, runtimeText, runtimeISO8601), "runtime_format", "must be minutes, text or iso8601")
+		v.Check(validator.PermittedValue(f.time, timeRFC3339, timeUnix), "time_format", "must be rfc3339 or unix")
+		if !v.Valid() {
+			app.failedValidationResponse(w, r, v.Errors)
+			return
+		}
+
+		w.Header().Add("Vary", "Prefer")
+		if f != defaultFormats {
+			w.Header().Set("Preference-Applied", fmt.Sprintf("runtime-format=%s, time-format=%s", f.runtime, f.time))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// appliedFormats reads back the representations recorded by negotiateFormats().
+func appliedFormats(h http.Header) formats {
+	f := defaultFormats
+	for _, pref := range strings.Split(h.Get("Preference-Applied"), ",") {
+		name, value, _ := strings.Cut(strings.TrimSpace(pref), "=")
+		switch name {
+		case "runtime-format":
+			f.runtime = value
+		case "time-format":
+			f.time = value
+		}
+	}
+	return f
+}
+
+// The apply() method rewrites an encoded JSON document into the negotiated
+// representations. It streams through the document token by token, so the order of
+// the fields is kept. Runtimes are recognised by their field name ("runtime" or
+// "runtimes") and timestamps by being RFC 3339 strings.
+func (f formats) apply(js []byte) ([]byte, error) {
+	if f == defaultFormats {
+		return js, nil
+	}
+
+	type frame struct {
+		object bool
+		key    string // the current key, which arrays inherit from their parent
+		count  int
+	}
+	var (
+		out   bytes.Buffer
+		stack []*frame
+	)
+	dec := json.NewDecoder(bytes.NewReader(js))
+	dec.UseNumber()
+
+	// separate writes the comma before the next key or array element.
+	separate := func() {
+		if len(stack) > 0 && stack[len(stack)-1].count > 0 {
+			out.WriteByte(',')
+		}
+	}
+	// expectingKey reports whether the next string is an object key.
+	expectingKey := func() bool {
+		if len(stack) == 0 {
+			return false
+		}
+		top := stack[len(stack)-1]
+		return top.object && top.key == ""
+	}
+	// done marks a value as written in the enclosing container.
+	done := func() {
+		if len(stack) == 0 {
+			return
+		}
+		top := stack[len(stack)-1]
+		top.count++
+		if top.object {
+			top.key = ""
+		}
+	}
+
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		switch t := tok.(type) {
+		case json.Delim:
+			switch t {
+			case '{', '[':
+				// Inside an object the key, and its comma, have already been written.
+				if len(stack) > 0 && !stack[len(stack)-1].object {
+					separate()
+				}
+				key := ""
+				if len(stack) > 0 && t == '[' {
+					key = stack[len(stack)-1].key
+				}
+				out.WriteRune(rune(t))
+				stack = append(stack, &frame{object: t == '{', key: key})
+			default:
+				stack = stack[:len(stack)-1]
+				out.WriteRune(rune(t))
+				done()
+			}
+			continue
+		case string:
+			if expectingKey() {
+				separate()
+				b, _ := json.Marshal(t)
+				out.Write(b)
+				out.WriteByte(':')
+				stack[len(stack)-1].key = t
+				continue
+			}
+		}
+
+		if len(stack) > 0 && !stack[len(stack)-1].object {
+			separate()
+		}
+		key := ""
+		if len(stack) > 0 {
+			key = stack[len(stack)-1].key
+		}
+		b, err := json.Marshal(f.value(key, tok))
+		if err != nil {
+			return nil, err
+		}
+		out.Write(b)
+		done()
+	}
+	return out.Bytes(), nil
+}
+
+// The value() method converts a single value into the negotiated representation.
+func (f formats) value(key string, tok json.Token) json.Token {
+	if key == "runtime" || key == "runtimes" {
+		var minutes int64
+		var err error
+		switch t := tok.(type) {
+		case string:
+			minutes, err = strconv.ParseInt(t, 10, 64)
+		case json.Number:
+			minutes, err = t.Int64()
+		default:
+			return tok
+		}
+		if err != nil {
+			return tok
+		}
+		switch f.runtime {
+		case runtimeText:
+			return fmt.Sprintf("%d mins", minutes)
+		case runtimeISO8601:
+			return isoDuration(minutes)
+		}
+		return tok
+	}
+
+	if s, ok := tok.(string); ok && f.time == timeUnix {
+		if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+			return t.Unix()
+		}
+	}
+	return tok
+}
+
+// isoDuration formats a number of minutes as an ISO 8601 duration, for example
+// "PT1H42M".
+func isoDuration(minutes int64) string {
+	h, m := minutes/60, minutes%60
+	switch {
+	case h > 0 && m > 0:
+		return fmt.Sprintf("PT%dH%dM", h, m)
+	case h > 0:
+		return fmt.Sprintf("PT%dH", h)
+	default:
+		return fmt.Sprintf("PT%dM", m)
+	}
+}
@@ -0,0 +1,21 @@
+package main
+
+import (
+	"net/http"
+)
+
+// The listGenresHandler for the "GET /v1/genres" endpoint returns every genre with the
+// number of movies which have it. The ids can be used to filter the movie listing with
+// ?genre_ids=.
+func (app *application) listGenresHandler(w http.ResponseWriter, r *http.Request) {
+	genres, err := app.modelsFor(r).Genres.GetAll()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"genres": genres}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/shyngys9219/greenlight/internal/health"
+	"github.com/shyngys9219/greenlight/internal/validator"
+)
+
+// healthRetention is how long persisted probe results are kept in the health_checks
+// table.
+const healthRetention = 30 * 24 * time.Hour
+
+// The probes() method returns the dependency probes run by the health job.
+func (app *application) probes() map[string]health.Probe {
+	return map[string]health.Probe{
+		"database": app.models.Health.Ping,
+		// Still named smtp, so the history carries on, but it checks whichever
+		// -mail-provider is in use.
+		"smtp": func(ctx context.Context) error {
+			return app.mailer.Ping()
+		},
+		"email_queue": app.checkEmailQueue,
+	}
+}
+
+// The recordHealth() job probes every dependency and records the result in the
+// in-memory history and, if enabled, in the health_checks table. Status changes are
+// logged so that degradations show up in the logs too.
+func (app *application) recordHealth() error {
+	previous, hadPrevious := app.healthHistory.Latest()
+
+	res := health.Run(app.probes(), 5*time.Second)
+	app.healthHistory.Add(res)
+
+	if hadPrevious && previous.Status != res.Status {
+		properties := map[string]string{"status": res.Status}
+		for name, check := range res.Checks {
+			properties[name] = check.Status
+		}
+		app.logger.PrintInfo("health status changed", properties)
+	}
+
+	if !app.config.health.persist {
+		return nil
+	}
+	err := app.models.Health.Insert(&res)
+	if err != nil {
+		return err
+	}
+	return app.models.Health.DeleteBefore(time.Now().Add(-healthRetention))
+}
+
+// The healthHistoryHandler for the "GET /v1/admin/health/history" endpoint returns the
+// probe results recorded in the last hours (24 by default), oldest first. By default
+// they come from the in-memory ring buffer; source=db reads the persisted history
+// instead, which survives restarts and goes further back.
+func (app *application) healthHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	qs := r.URL.Query()
+	v := validator.New()
+
+	source := app.readString(qs, "source", "memory")
+	limit := app.readInt(qs, "limit", 100, v)
+	hours := app.readInt(qs, "hours", 24, v)
+
+	v.Check(validator.PermittedValue(source, "memory", "db"), "source", "must be memory or db")
+	v.Check(source != "db" || app.config.health.persist, "source", "health history persistence is not enabled")
+	v.Check(limit > 0 && limit <= 10_000, "limit", "must be between 1 and 10000")
+	v.Check(hours > 0, "hours", "must be greater than zero")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	since := time.Now().Add(-time.Duration(hours) * time.Hour)
+	var results []health.Result
+	if source == "db" {
+		var err error
+		results, err = app.modelsFor(r).Health.GetSince(since, limit)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	} else {
+		results = app.healthHistory.Since(since)
+		if len(results) > limit {
+			results = results[len(results)-limit:]
+		}
+	}
+
+	err := app.writeJSON(w, http.StatusOK, envelope{"history": results}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -1,9 +1,20 @@
 package main
 
 import (
+	"context"
+	"fmt"
 	"net/http"
+	"time"
+
+	"github.com/shyngys9219/greenlight/internal/health"
+	"github.com/shyngys9219/greenlight/internal/migrate"
+	"github.com/shyngys9219/greenlight/migrations"
 )
 
+// readinessTimeout is how long each of the readiness probes may take. They run at the
+// same time, so the orchestrator's probe timeout should be a bit longer than this.
+const readinessTimeout = 2 * time.Second
+
 func (app *application) healthcheckHandler(w http.ResponseWriter, r *http.Request) {
 	env := envelope{
 		"status": "available",
@@ -12,6 +23,11 @@ func (app *application) healthcheckHandler(w http.ResponseWriter, r *http.Reques
 			"version":     version,
 		},
 	}
+	// In read-only mode the API is available for reads only.
+	if on, since, _ := app.readOnly.status(); on {
+		env["status"] = "read_only"
+		env["read_only_since"] = since.UTC().Format(time.RFC3339)
+	}
 	// Add a 4 second delay. uncomment to test
 	// time.Sleep(4 * time.Second)
 	err := app.writeJSON(w, http.StatusOK, env, nil)
@@ -19,3 +35,72 @@ func (app *application) healthcheckHandler(w http.ResponseWriter, r *http.Reques
 		app.serverErrorResponse(w, r, err)
 	}
 }
+
+// The livenessHandler for the "GET /v1/healthcheck/live" endpoint tells the
+// orchestrator that the process is up and serving requests. It doesn't look at any
+// dependency: a database outage shouldn't get every replica restarted, which is what a
+// failed liveness probe does.
+func (app *application) livenessHandler(w http.ResponseWriter, r *http.Request) {
+	err := app.writeJSON(w, http.StatusOK, envelope{"status": "alive"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The readinessHandler for the "GET /v1/healthcheck/ready" endpoint tells the
+// orchestrator whether the server can handle requests, so that it's only sent traffic
+// when it can. It runs the probe of every component which has one, see lifecycle.go,
+// such as the database, Redis if the rate limiter keeps its counters there and, with
+// -ready-check-smtp, the mail provider, and checks that the schema has been migrated to
+// the version this release needs. If any of them is down it answers 503 Service
+// Unavailable, with the status of each. In read-only mode the database checked is the
+// replica which serves the reads, if there is one, so that the server keeps getting the
+// traffic it can handle.
+func (app *application) readinessHandler(w http.ResponseWriter, r *http.Request) {
+	db := app.readinessModels()
+	readOnly := app.readOnly.active()
+	var schema struct {
+		Version int64 `json:"version"`
+		Latest  int64 `json:"latest"`
+		Dirty   bool  `json:"dirty"`
+	}
+	probes := app.lifecycle.Probes()
+	probes["schema"] = func(ctx context.Context) error {
+		mg, err := migrate.New(db.DB, migrations.FS)
+		if err != nil {
+			return err
+		}
+		schema.Latest = mg.Latest()
+		schema.Version, schema.Dirty, err = mg.Version(ctx)
+		switch {
+		case err != nil:
+			return err
+		case schema.Dirty:
+			return migrate.ErrDirty
+		case schema.Version < schema.Latest:
+			return fmt.Errorf("the database schema is at version %d but this release needs version %d", schema.Version, schema.Latest)
+		}
+		return nil
+	}
+
+	res := health.Run(probes, readinessTimeout)
+
+	status := http.StatusOK
+	if res.Status != health.StatusUp {
+		status = http.StatusServiceUnavailable
+	}
+	env := envelope{
+		"status":    res.Status,
+		"checks":    res.Checks,
+		"schema":    schema,
+		"read_only": readOnly,
+		"system_info": map[string]string{
+			"environment": app.config.env,
+			"version":     version,
+		},
+	}
+	err := app.writeJSON(w, status, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
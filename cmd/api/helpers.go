@@ -3,12 +3,20 @@ package main
 import (
 	"encoding/json"
 	"errors"
+	"expvar"
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
+	"sort"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/julienschmidt/httprouter"
+	"github.com/shyngys9219/greenlight/internal/data"
+	"github.com/shyngys9219/greenlight/internal/publicid"
+	"github.com/shyngys9219/greenlight/internal/validator"
 )
 
 // again, in the book you have "any" type, but if you use go 1.17 and lower
@@ -18,31 +26,322 @@ type envelope map[string]interface{}
 // Retrieve the "id" URL parameter from the current request context, then convert it to
 // an integer and return it. If the operation isn't successful, return 0 and an error.
 func (app *application) readIDParam(r *http.Request) (int64, error) {
+	return app.readNamedIDParam(r, "id")
+}
+
+// The readNamedIDParam() helper reads an ID from the URL parameter with the given name,
+// for routes which have more than one.
+func (app *application) readNamedIDParam(r *http.Request, name string) (int64, error) {
+	params := httprouter.ParamsFromContext(r.Context())
+	id, err := strconv.ParseInt(params.ByName(name), 10, 64)
+	if err != nil || id < 1 {
+		return 0, fmt.Errorf("invalid %s parameter", name)
+	}
+	return id, nil
+}
+
+// The readMovieIDParam() helper resolves the "id" URL parameter of a movie endpoint to
+// the movie's internal ID, see resolveMovieID().
+func (app *application) readMovieIDParam(r *http.Request) (int64, error) {
 	params := httprouter.ParamsFromContext(r.Context())
-	id, err := strconv.ParseInt(params.ByName("id"), 10, 64)
+	return app.resolveMovieID(r, params.ByName("id"))
+}
+
+// The resolveMovieID() helper resolves a movie ID given by a client to the movie's
+// internal ID. It can be the movie's public ID or, unless the application is
+// configured to accept public IDs only, its sequential ID. If it doesn't name a movie
+// an ErrRecordNotFound error is returned.
+func (app *application) resolveMovieID(r *http.Request, s string) (int64, error) {
+	if publicid.Valid(s) {
+		return app.modelsFor(r).Movies.GetIDByPublicID(s)
+	}
+	if app.config.publicID.only {
+		return 0, data.ErrRecordNotFound
+	}
+	id, err := strconv.ParseInt(s, 10, 64)
 	if err != nil || id < 1 {
-		return 0, errors.New("invalid id parameter")
+		return 0, data.ErrRecordNotFound
 	}
 	return id, nil
 }
 
+// The etag() helper returns the ETag for a version of a record. ETags are strong
+// validators, since any change to a record bumps its version.
+func etag(version int) string {
+	return `"` + strconv.Itoa(version) + `"`
+}
+
+// The ifMatch() helper reports whether the request's If-Match header matches the given
+// ETag. The header may hold a comma-separated list of ETags, or "*" to match any
+// version. A request without the header doesn't match.
+func (app *application) ifMatch(r *http.Request, etag string) bool {
+	for _, tag := range strings.Split(r.Header.Get("If-Match"), ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" || tag == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// The ifNoneMatch() helper reports whether the request's If-None-Match header matches
+// the given ETag, which means the client already has that version of the record. Weak
+// ETags match too, as the header is compared weakly.
+func (app *application) ifNoneMatch(r *http.Request, etag string) bool {
+	for _, tag := range strings.Split(r.Header.Get("If-None-Match"), ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		if tag == "*" || tag == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// The checkPrecondition() helper makes sure that a change of a record was based on the
+// version with the given ETag, sending a 428 Precondition Required response if the
+// client didn't say which version it had, and a 412 Precondition Failed response if
+// that version is out of date.
+func (app *application) checkPrecondition(w http.ResponseWriter, r *http.Request, etag string) bool {
+	if r.Header.Get("If-Match") == "" {
+		app.preconditionRequiredResponse(w, r)
+		return false
+	}
+	if !app.ifMatch(r, etag) {
+		app.preconditionFailedResponse(w, r)
+		return false
+	}
+	return true
+}
+
+// The notModified() helper handles conditional requests for a listing which last
+// changed at the given time. It sets the Last-Modified header, and sends a 304 Not
+// Modified response if the listing hasn't changed since the time in the request's
+// If-Modified-Since header, reporting whether it did. Last-Modified only has a
+// resolution of a second, so a listing which changed within the current second doesn't
+// get the header: a second change in the same second would go unnoticed otherwise.
+func (app *application) notModified(w http.ResponseWriter, r *http.Request, modified time.Time) bool {
+	modified = modified.UTC().Truncate(time.Second)
+	if modified.IsZero() || !modified.Before(time.Now().Truncate(time.Second)) {
+		return false
+	}
+	w.Header().Set("Last-Modified", modified.Format(http.TimeFormat))
+
+	since, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	if err != nil || modified.After(since) {
+		return false
+	}
+	w.WriteHeader(http.StatusNotModified)
+	return true
+}
+
+// The readString() helper returns a string value from the query string, or the provided
+// default value if no matching key could be found.
+func (app *application) readString(qs url.Values, key string, defaultValue string) string {
+	s := qs.Get(key)
+	if s == "" {
+		return defaultValue
+	}
+	return s
+}
+
+// The readCSV() helper reads a string value from the query string and then splits it
+// into a slice on the comma character. If no matching key could be found, it returns
+// the provided default value.
+func (app *application) readCSV(qs url.Values, key string, defaultValue []string) []string {
+	csv := qs.Get(key)
+	if csv == "" {
+		return defaultValue
+	}
+	return strings.Split(csv, ",")
+}
+
+// The readIDCSV() helper reads a comma-separated list of IDs from the query string. If
+// no matching key could be found it returns nil, and if any of the values isn't a valid
+// ID it records an error message in the provided Validator instance.
+func (app *application) readIDCSV(qs url.Values, key string, v *validator.Validator) []int64 {
+	var ids []int64
+	for _, s := range app.readCSV(qs, key, nil) {
+		id, err := strconv.ParseInt(strings.TrimSpace(s), 10, 64)
+		if err != nil || id < 1 {
+			v.AddError(key, "must be a comma-separated list of ids")
+			return nil
+		}
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// The readInt() helper reads a string value from the query string and converts it to an
+// integer before returning. If no matching key could be found it returns the provided
+// default value. If the value couldn't be converted to an integer, then we record an
+// error message in the provided Validator instance.
+func (app *application) readInt(qs url.Values, key string, defaultValue int, v *validator.Validator) int {
+	s := qs.Get(key)
+	if s == "" {
+		return defaultValue
+	}
+	i, err := strconv.Atoi(s)
+	if err != nil {
+		v.AddError(key, "must be an integer value")
+		return defaultValue
+	}
+	return i
+}
+
+// The readBool() helper reads a boolean value from the query string, such as
+// highlight=true. If no matching key could be found it returns false, and values which
+// aren't booleans are recorded in the provided Validator instance.
+func (app *application) readBool(qs url.Values, key string, v *validator.Validator) bool {
+	s := qs.Get(key)
+	if s == "" {
+		return false
+	}
+	b, err := strconv.ParseBool(s)
+	if err != nil {
+		v.AddError(key, "must be true or false")
+		return false
+	}
+	return b
+}
+
+// The readDryRun() helper reports whether a request asks for a dry run, with
+// dry_run=true in the query string or an "X-Dry-Run: true" header. A dry run of a
+// create or update endpoint validates the request and checks it for conflicts, then
+// responds with what would have been saved, without saving anything. Values which
+// aren't booleans are recorded in the provided Validator instance.
+func (app *application) readDryRun(r *http.Request, v *validator.Validator) bool {
+	s := r.URL.Query().Get("dry_run")
+	if s == "" {
+		s = r.Header.Get("X-Dry-Run")
+	}
+	if s == "" {
+		return false
+	}
+	dryRun, err := strconv.ParseBool(s)
+	if err != nil {
+		v.AddError("dry_run", "must be true or false")
+		return false
+	}
+	return dryRun
+}
+
+// The dryRunResponse() helper sends the result of a dry run which passed every check:
+// the records which would have been saved, marked with "dry_run": true.
+func (app *application) dryRunResponse(w http.ResponseWriter, r *http.Request, env envelope) {
+	env["dry_run"] = true
+	err := app.writeJSON(w, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The readFilters() helper reads every comparison filter from the query string. Filters
+// are written as field[op]=value, for example year[gte]=2000&runtime[lt]=120. Values
+// which aren't integers are recorded in the provided Validator instance; fields and
+// operators are left for data.ValidateFilters() to check. The filters are in the order
+// of their keys, so that the same filters always make the same query.
+func (app *application) readFilters(qs url.Values, v *validator.Validator) []data.Filter {
+	var filters []data.Filter
+	for _, key := range sortedKeys(qs) {
+		values := qs[key]
+		field, rest, ok := strings.Cut(key, "[")
+		if !ok || !strings.HasSuffix(rest, "]") || strings.HasPrefix(key, customFieldPrefix) {
+			continue
+		}
+		op := strings.TrimSuffix(rest, "]")
+		for _, s := range values {
+			i, err := strconv.Atoi(s)
+			if err != nil {
+				v.AddError(key, "must be an integer value")
+				continue
+			}
+			filters = append(filters, data.Filter{Field: field, Op: op, Value: i})
+		}
+	}
+	return filters
+}
+
+// The sortedKeys() helper returns the keys of the query string in order.
+func sortedKeys(qs url.Values) []string {
+	keys := make([]string, 0, len(qs))
+	for key := range qs {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// customFieldPrefix starts the query string keys of the filters on custom fields.
+const customFieldPrefix = "cf."
+
+// The readCustomFieldFilters() helper reads the filters on movies' custom fields from
+// the query string. They're written as cf.name[op]=value, or cf.name=value for eq, for
+// example cf.catalog_number=A-1234&cf.acquired[gte]=1990-01-01. The values are left as
+// strings, for data.ValidateCustomFieldFilters() to parse by the type of their field.
+// Like readFilters(), they're in the order of their keys.
+func (app *application) readCustomFieldFilters(qs url.Values) []data.CustomFieldFilter {
+	var filters []data.CustomFieldFilter
+	for _, key := range sortedKeys(qs) {
+		values := qs[key]
+		if !strings.HasPrefix(key, customFieldPrefix) {
+			continue
+		}
+		name, op := strings.TrimPrefix(key, customFieldPrefix), "eq"
+		if field, rest, ok := strings.Cut(name, "["); ok && strings.HasSuffix(rest, "]") {
+			name, op = field, strings.TrimSuffix(rest, "]")
+		}
+		for _, s := range values {
+			filters = append(filters, data.CustomFieldFilter{Name: name, Op: op, Value: s})
+		}
+	}
+	return filters
+}
+
 // in my version of go there is no type as 'any', and instead of it I used interface{},
 // cuz Marshal actually accepts it as a parameter and map is implementing interface.
 // on your side data interface{} must be data any if you are using go version 1.18 or newer
 // any is a type alias of interface
 func (app *application) writeJSON(w http.ResponseWriter, status int, data interface{}, headers http.Header) error {
+	f := appliedFormats(w.Header())
+
+	// Fill in the metadata section of envelopes, see metadata.go, including notices
+	// about any deprecated fields in the response, see deprecations().
+	if env, ok := data.(envelope); ok {
+		fields := map[string]any{}
+		if meta := responseMetadataFor(w); meta != nil {
+			fields = meta.fields()
+		}
+		if notices := f.deprecations(env); len(notices) > 0 {
+			fields["deprecations"] = notices
+		}
+		if len(fields) > 0 {
+			var err error
+			data, err = withMetadata(env, fields)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
 	js, err := json.Marshal(data)
 	if err != nil {
 		return err
 	}
 
-	js = append(js, '\n')
-
 	//adding additional headers if there are any to be added
 	for key, value := range headers {
 		w.Header()[key] = value
 	}
 
+	// Convert runtimes and timestamps into the representations the client asked for,
+	// see negotiateFormats().
+	js, err = f.apply(js)
+	if err != nil {
+		return err
+	}
+
+	js = append(js, '\n')
+
 	// Adding Content-Type and status code to header and response as json
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(status)
@@ -51,7 +350,18 @@ func (app *application) writeJSON(w http.ResponseWriter, status int, data interf
 }
 
 func (app *application) readJSON(w http.ResponseWriter, r *http.Request, dst interface{}) error {
-	err := json.NewDecoder(r.Body).Decode(dst)
+	return jsonError(json.NewDecoder(r.Body).Decode(dst))
+}
+
+// The decodeJSON() helper decodes JSON which has already been read, such as a request
+// body held as a json.RawMessage, reporting errors just like readJSON().
+func decodeJSON(data []byte, dst interface{}) error {
+	return jsonError(json.Unmarshal(data, dst))
+}
+
+// The jsonError() function turns an error decoding a request body into one which can
+// be shown to the client, or returns nil if err is nil.
+func jsonError(err error) error {
 	if err != nil {
 		var syntaxError *json.SyntaxError
 		var unmarshalTypeError *json.UnmarshalTypeError
@@ -81,22 +391,77 @@ func (app *application) readJSON(w http.ResponseWriter, r *http.Request, dst int
 	return nil
 }
 
-// The background() helper accepts an arbitrary function as a parameter.
-func (app *application) background(fn func()) {
+// backgroundTasks counts the outcome of every background task, keyed by
+// "<task name>.<outcome>" where the outcome is succeeded, failed, retried or panicked.
+var backgroundTasks = expvar.NewMap("background_tasks")
+
+// Emails are queued by background tasks, which try again a few times when queueing one
+// fails, say because the database connection dropped, before giving up on it.
+const (
+	emailTaskAttempts = 3
+	emailTaskBackoff  = time.Second
+)
+
+// A backgroundTask describes a function which runs outside of the request cycle.
+type backgroundTask struct {
+	name      string        // short name used in logs and metrics, e.g. "welcome_email"
+	requestID string        // ID of the request which started the task, if any
+	attempts  int           // maximum number of attempts; zero or less means one
+	backoff   time.Duration // delay before the first retry, doubled after each one
+	fn        func() error
+}
+
+// The background() helper runs a task in a new goroutine. Each task gets its own
+// recover, so a panic in one task can't take down the application, and failures are
+// logged together with the task name and the originating request ID.
+func (app *application) background(task backgroundTask) {
 
 	// increment go routine quantity each time background method is called
 	app.wg.Add(1)
+	app.backgroundRunning.Add(1)
 	// Launch a background goroutine.
 	go func() {
-		// decrease value of goroutines before this goroutine is finished
-		app.wg.Done()
+		// decrease value of goroutines when this goroutine is finished
+		defer app.wg.Done()
+		defer app.backgroundRunning.Add(-1)
+
+		properties := map[string]string{
+			"task":       task.name,
+			"request_id": task.requestID,
+		}
+
 		// Recover any panic.
 		defer func() {
 			if err := recover(); err != nil {
-				app.logger.PrintError(fmt.Errorf("%s", err), nil)
+				backgroundTasks.Add(task.name+".panicked", 1)
+				app.logger.PrintError(fmt.Errorf("%s", err), properties)
 			}
 		}()
-		// Execute the arbitrary function that we passed as the parameter.
-		fn()
+
+		attempts := task.attempts
+		if attempts < 1 {
+			attempts = 1
+		}
+		backoff := task.backoff
+
+		// Execute the function that we passed in the task, retrying it with an
+		// exponential backoff until it succeeds or we run out of attempts.
+		var err error
+		for i := 1; i <= attempts; i++ {
+			err = task.fn()
+			if err == nil {
+				backgroundTasks.Add(task.name+".succeeded", 1)
+				return
+			}
+			if i < attempts {
+				backgroundTasks.Add(task.name+".retried", 1)
+				time.Sleep(backoff)
+				backoff *= 2
+			}
+		}
+
+		backgroundTasks.Add(task.name+".failed", 1)
+		properties["attempts"] = strconv.Itoa(attempts)
+		app.logger.PrintError(err, properties)
 	}()
 }
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"net/url"
+	"reflect"
+	"testing"
+
+	"github.com/shyngys9219/greenlight/internal/data"
+	"github.com/shyngys9219/greenlight/internal/validator"
+)
+
+// The same filters must always make the same query, whatever order the map of the
+// query string is ranged over in, or each request could prepare a statement of its own.
+func TestReadFiltersOrder(t *testing.T) {
+	app := &application{}
+	qs, err := url.ParseQuery("year[lt]=2000&runtime[gte]=90&year[gte]=1990&cf.studio=A&cf.acquired[gte]=1990-01-01")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wantFilters := []data.Filter{
+		{Field: "runtime", Op: "gte", Value: 90},
+		{Field: "year", Op: "gte", Value: 1990},
+		{Field: "year", Op: "lt", Value: 2000},
+	}
+	wantCustom := []data.CustomFieldFilter{
+		{Name: "acquired", Op: "gte", Value: "1990-01-01"},
+		{Name: "studio", Op: "eq", Value: "A"},
+	}
+	for i := 0; i < 20; i++ {
+		v := validator.New()
+		if got := app.readFilters(qs, v); !reflect.DeepEqual(got, wantFilters) || !v.Valid() {
+			t.Fatalf("readFilters() = %v, errors %v; want %v", got, v.Errors, wantFilters)
+		}
+		if got := app.readCustomFieldFilters(qs); !reflect.DeepEqual(got, wantCustom) {
+			t.Fatalf("readCustomFieldFilters() = %v; want %v", got, wantCustom)
+		}
+	}
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/shyngys9219/greenlight/internal/data"
+	"github.com/shyngys9219/greenlight/internal/validator"
+)
+
+// incidentState holds the temporary measures put in place by incident mode. It's kept
+// in memory, so each API instance must be put into incident mode separately, and a
+// restart ends it. That includes the revocation of JWT authentication tokens, which
+// aren't stored anywhere: a restart lets revoked JWTs through again until they expire.
+type incidentState struct {
+	mu     sync.RWMutex
+	until  time.Time
+	factor float64
+	// JWTs issued up to jwtsBefore are revoked, as are those of the users in
+	// userJWTsBefore issued up to the user's time.
+	jwtsBefore     time.Time
+	userJWTsBefore map[int64]time.Time
+}
+
+// limitFactor returns the number the rate limits should currently be multiplied by: 1
+// normally, or the incident's factor while incident mode is active.
+func (s *incidentState) limitFactor() float64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if time.Now().Before(s.until) {
+		return s.factor
+	}
+	return 1
+}
+
+func (s *incidentState) set(until time.Time, factor float64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.until = until
+	s.factor = factor
+}
+
+func (s *incidentState) activeUntil() time.Time {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.until
+}
+
+// revokeJWTs revokes the JWTs issued up to the given time, of the given users or, if
+// there are none, of everyone. Revocations are never brought forward.
+func (s *incidentState) revokeJWTs(before time.Time, userIDs ...int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(userIDs) == 0 {
+		if before.After(s.jwtsBefore) {
+			s.jwtsBefore = before
+		}
+		return
+	}
+	if s.userJWTsBefore == nil {
+		s.userJWTsBefore = make(map[int64]time.Time)
+	}
+	for _, id := range userIDs {
+		if before.After(s.userJWTsBefore[id]) {
+			s.userJWTsBefore[id] = before
+		}
+	}
+}
+
+// jwtRevoked reports whether a JWT of the user issued at the given time was revoked.
+// JWT times only have a precision of seconds, so tokens issued in the same second as
+// a revocation are revoked too.
+func (s *incidentState) jwtRevoked(userID int64, issuedAt time.Time) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return !issuedAt.After(s.jwtsBefore) || !issuedAt.After(s.userJWTsBefore[userID])
+}
+
+// The startIncidentHandler for the "POST /v1/admin/incident" endpoint is meant for use
+// after a credential leak. In one call it can revoke tokens (by scope, and optionally
+// only those issued before a given time), flag accounts so they must reset their
+// password before logging in again, and tighten the rate limits for a while.
+func (app *application) startIncidentHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		RevokeScope     *string    `json:"revoke_scope"`
+		IssuedBefore    *time.Time `json:"issued_before"`
+		FlagUserIDs     []int64    `json:"flag_user_ids"`
+		TightenFor      string     `json:"tighten_rate_limits_for"`
+		RateLimitFactor float64    `json:"rate_limit_factor"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	// Revoke authentication tokens issued up to now, unless told otherwise. An empty
+	// scope revokes tokens of every scope.
+	scope := data.ScopeAuthentication
+	if input.RevokeScope != nil {
+		scope = *input.RevokeScope
+	}
+	issuedBefore := time.Now()
+	if input.IssuedBefore != nil {
+		issuedBefore = *input.IssuedBefore
+	}
+	factor := input.RateLimitFactor
+	if factor == 0 {
+		factor = 0.25
+	}
+
+	v := validator.New()
+	v.Check(validator.PermittedValue(scope, "", data.ScopeAuthentication, data.ScopeActivation), "revoke_scope", "must be authentication, activation or empty for all scopes")
+	v.Check(!issuedBefore.After(time.Now()), "issued_before", "must not be in the future")
+	v.Check(validator.Unique(input.FlagUserIDs), "flag_user_ids", "must not contain duplicate values")
+	v.Check(factor > 0 && factor <= 1, "rate_limit_factor", "must be greater than 0 and at most 1")
+	var tightenFor time.Duration
+	if input.TightenFor != "" {
+		tightenFor, err = time.ParseDuration(input.TightenFor)
+		v.Check(err == nil && tightenFor > 0, "tighten_rate_limits_for", "must be a positive duration, such as 1h")
+		v.Check(tightenFor <= 7*24*time.Hour, "tighten_rate_limits_for", "must not be more than 168h")
+	}
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	// Revoking tokens logs users out everywhere, so it needs a confirmation.
+	if !app.confirmed(w, r, "start_incident", input, nil) {
+		return
+	}
+
+	revoked, err := app.modelsFor(r).Tokens.DeleteAll(scope, issuedBefore)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	// Revoking authentication tokens revokes the refresh tokens they can be renewed
+	// with, and the JWTs which aren't stored at all.
+	if scope == data.ScopeAuthentication {
+		refreshRevoked, err := app.modelsFor(r).Tokens.DeleteAll(data.ScopeRefresh, issuedBefore)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		revoked += refreshRevoked
+	}
+	if scope == "" || scope == data.ScopeAuthentication {
+		app.incident.revokeJWTs(issuedBefore)
+	}
+
+	var flagged int64
+	if len(input.FlagUserIDs) > 0 {
+		flagged, err = app.modelsFor(r).Users.FlagPasswordReset(input.FlagUserIDs)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		// Flagged users lose every authentication and refresh token, whenever it was
+		// issued.
+		for _, scope := range []string{data.ScopeAuthentication, data.ScopeRefresh} {
+			err = app.modelsFor(r).Tokens.DeleteAllForUsers(scope, input.FlagUserIDs)
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+				return
+			}
+		}
+		app.incident.revokeJWTs(time.Now(), input.FlagUserIDs...)
+	}
+
+	if tightenFor > 0 {
+		app.incident.set(time.Now().Add(tightenFor), factor)
+	}
+
+	summary := envelope{
+		"tokens_revoked":              revoked,
+		"users_flagged":               flagged,
+		"rate_limits_tightened_until": app.incident.activeUntil(),
+	}
+
+	// Record who did what, so there's an audit trail of the incident response.
+	app.requestLogger(r).PrintInfo("incident mode started", map[string]string{
+		"admin_id":       fmt.Sprint(app.contextGetUser(r).ID),
+		"revoke_scope":   scope,
+		"issued_before":  issuedBefore.UTC().Format(time.RFC3339),
+		"tokens_revoked": fmt.Sprint(revoked),
+		"flagged_users":  strings.Trim(fmt.Sprint(input.FlagUserIDs), "[]"),
+		"tighten_for":    tightenFor.String(),
+	})
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"incident": summary}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The endIncidentHandler for the "DELETE /v1/admin/incident" endpoint restores the
+// normal rate limits. Revoked tokens and password reset flags are not undone.
+func (app *application) endIncidentHandler(w http.ResponseWriter, r *http.Request) {
+	app.incident.set(time.Time{}, 1)
+
+	app.requestLogger(r).PrintInfo("incident mode ended", map[string]string{
+		"admin_id": fmt.Sprint(app.contextGetUser(r).ID),
+	})
+
+	err := app.writeJSON(w, http.StatusOK, envelope{"message": "rate limits restored"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
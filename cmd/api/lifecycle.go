@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+
+	"github.com/shyngys9219/greenlight/internal/data"
+	"github.com/shyngys9219/greenlight/internal/leader"
+	"github.com/shyngys9219/greenlight/internal/lifecycle"
+	"github.com/shyngys9219/greenlight/internal/publicid"
+	"github.com/shyngys9219/greenlight/internal/tracing"
+)
+
+// The application is made of components which are started in order, and stopped in
+// the reverse order on shutdown, see the lifecycle package. A new subsystem which
+// connects to something, or runs goroutines which must be stopped, gets a component
+// here; if it has a health probe the readiness check picks it up.
+
+// The registerComponents() method adds the components of the application to
+// app.lifecycle, in the order they're started: tracing first, so that the rest is
+// traced, then the connections, then the background jobs, and the servers last, so
+// that requests are only taken once everything they need is up.
+func (app *application) registerComponents(publicIDs publicid.Generator) error {
+	lc := app.lifecycle
+	if app.config.otel.endpoint != "" {
+		lc.Add(app.tracingComponent())
+	}
+	lc.Add(app.databaseComponent(publicIDs))
+	if app.config.db.replicaDSN != "" {
+		lc.Add(app.replicaComponent(publicIDs))
+	}
+	if app.redis != nil {
+		lc.Add(app.redisComponent())
+	}
+	if app.config.health.readySMTP {
+		// The mail provider isn't connected to until an email is sent, so it has a probe
+		// and nothing else.
+		lc.Add(lifecycle.Component{
+			Name: "smtp",
+			Health: func(ctx context.Context) error {
+				return app.mailer.Ping()
+			},
+		})
+	}
+	lc.Add(app.jobsComponent())
+
+	// With TLS on the server speaks HTTPS, and a second, plain HTTP server redirects to
+	// it. With Let's Encrypt that server also answers the HTTP-01 challenges.
+	tlsConfig, certManager, err := newTLSConfig(app.config)
+	if err != nil {
+		return err
+	}
+	lc.Add(app.httpComponent(tlsConfig))
+	if tlsConfig != nil && app.config.tls.redirectPort > 0 {
+		var handler http.Handler = http.HandlerFunc(app.redirectToHTTPS)
+		if certManager != nil {
+			handler = certManager.HTTPHandler(handler)
+		}
+		lc.Add(app.redirectComponent(handler))
+	}
+	return nil
+}
+
+// The tracingComponent() method returns the component which exports the traces to the
+// -otel-endpoint collector. Stopping it exports the spans which are still buffered.
+func (app *application) tracingComponent() lifecycle.Component {
+	var stop func(context.Context) error
+	return lifecycle.Component{
+		Name: "tracing",
+		Start: func(ctx context.Context) error {
+			var err error
+			stop, err = tracing.Start(tracing.Config{
+				Endpoint:       app.config.otel.endpoint,
+				ServiceName:    app.config.otel.serviceName,
+				ServiceVersion: version,
+				SampleRatio:    app.config.otel.sampleRatio,
+			})
+			return err
+		},
+		Stop: func(ctx context.Context) error {
+			return stop(ctx)
+		},
+	}
+}
+
+// The databaseComponent() method returns the component of the primary database. Starting
+// it opens the connection pool, checks the schema is up to date, migrating it first
+// with -db-auto-migrate, and sets up the models and the leader lock of the singleton
+// jobs, which need the pool. Its probe pings the database the reads are served from,
+// which in read-only mode is the replica, if there is one.
+func (app *application) databaseComponent(publicIDs publicid.Generator) lifecycle.Component {
+	var closeDB func() error
+	return lifecycle.Component{
+		Name: "database",
+		Start: func(ctx context.Context) error {
+			db, err := openDB(app.config, app.config.db.dsn)
+			if err != nil {
+				return err
+			}
+			closeDB = db.Close
+			err = checkSchema(db, app.logger, app.config.db.autoMigrate)
+			if err != nil {
+				db.Close()
+				return err
+			}
+			app.models = data.NewModels(db, publicIDs)
+			app.jobLeader = leader.New(db, "scheduler", leaderCheckInterval)
+			publishMetrics(db, app.mailer, app.models.EmailQueue, app.jobLeader)
+			return nil
+		},
+		Stop: func(ctx context.Context) error {
+			return closeDB()
+		},
+		Health: func(ctx context.Context) error {
+			return app.readinessModels().Ping(ctx)
+		},
+	}
+}
+
+// The replicaComponent() method returns the component of the -db-replica-dsn read
+// replica, which serves the reads in read-only mode, see readonly.go. It has no probe of
+// its own: the database probe checks it when it's in use.
+func (app *application) replicaComponent(publicIDs publicid.Generator) lifecycle.Component {
+	var closeDB func() error
+	return lifecycle.Component{
+		Name: "database_replica",
+		Start: func(ctx context.Context) error {
+			db, err := openDB(app.config, app.config.db.replicaDSN)
+			if err != nil {
+				return err
+			}
+			closeDB = db.Close
+			models := data.NewModels(db, publicIDs)
+			app.replicaModels = &models
+			return nil
+		},
+		Stop: func(ctx context.Context) error {
+			return closeDB()
+		},
+	}
+}
+
+// The redisComponent() method returns the component of the Redis server which the rate
+// limiters share.
+func (app *application) redisComponent() lifecycle.Component {
+	return lifecycle.Component{
+		Name: "redis",
+		Start: func(ctx context.Context) error {
+			return pingRedis(app.redis)
+		},
+		Stop: func(ctx context.Context) error {
+			return app.redis.Close()
+		},
+		Health: func(ctx context.Context) error {
+			return app.redis.Ping(ctx).Err()
+		},
+	}
+}
+
+// The jobsComponent() method returns the component of the scheduled jobs and the
+// workers, see scheduler.go. Stopping it stops new runs, then waits for the background
+// tasks still going, including those started by requests, which is why it's stopped
+// after the servers. The business metrics counted since the last rollup are stored, as
+// they would otherwise be lost, and the leader lock is released so that another replica
+// can take the singleton jobs over straight away.
+func (app *application) jobsComponent() lifecycle.Component {
+	return lifecycle.Component{
+		Name: "jobs",
+		Start: func(ctx context.Context) error {
+			app.startJobs()
+			return nil
+		},
+		Stop: func(ctx context.Context) error {
+			close(app.stopJobs)
+			app.logger.PrintInfo("completing background tasks", map[string]string{
+				"tasks": strconv.FormatInt(app.backgroundRunning.Load(), 10),
+			})
+			err := app.drainBackground(ctx)
+			rollupErr := app.rollupBusinessMetrics()
+			if rollupErr != nil {
+				app.logger.PrintError(rollupErr, map[string]string{"job": "business_metrics_rollup"})
+			}
+			app.jobLeader.Stop()
+			return err
+		},
+	}
+}
+
+// readinessModels() returns the health model of the database the reads are served
+// from: the primary's, or the replica's in read-only mode if there is one.
+func (app *application) readinessModels() data.HealthModel {
+	if app.readOnly.active() && app.replicaModels != nil {
+		return app.replicaModels.Health
+	}
+	return app.models.Health
+}
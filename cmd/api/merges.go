@@ -0,0 +1,327 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/shyngys9219/greenlight/internal/data"
+	"github.com/shyngys9219/greenlight/internal/validator"
+)
+
+// The mergeMovieHandler for the "POST /v1/admin/movies/:id/merge" endpoint merges the
+// duplicate movie given in the URL into the canonical movie given by the "into" field
+// of the request body. The duplicate is deleted, and requests for it are redirected to
+// the canonical movie from then on. It's a destructive operation, which needs a
+// confirmation (see confirmed()).
+func (app *application) mergeMovieHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Into string `json:"into"`
+	}
+
+	duplicateID, err := app.readMovieIDParam(r)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	v.Check(input.Into != "", "into", "must be provided")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	canonicalID, err := app.resolveMovieID(r, input.Into)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("into", "must be an existing movie")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	if canonicalID == duplicateID {
+		v.AddError("into", "must be a different movie")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	preview := envelope{"merge": map[string]int64{"duplicate_id": duplicateID, "canonical_id": canonicalID}}
+	if !app.confirmed(w, r, "merge_movie", canonicalID, preview) {
+		return
+	}
+
+	report, err := app.modelsFor(r).Movies.Merge(duplicateID, canonicalID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	app.movieCache.Delete(duplicateID)
+	app.movieCache.Delete(canonicalID)
+
+	// There's no audit log yet, so the merge is recorded in the application log.
+	app.requestLogger(r).PrintInfo("movies merged", map[string]string{
+		"duplicate_id": fmt.Sprint(duplicateID),
+		"canonical_id": fmt.Sprint(canonicalID),
+		"merged_by":    fmt.Sprint(app.contextGetUser(r).ID),
+	})
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"merge": report}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The redirectMergedMovie() method sends a 301 Moved Permanently response pointing at
+// the movie that the given movie was merged into, or a 404 Not Found response if it
+// was never merged.
+func (app *application) redirectMergedMovie(w http.ResponseWriter, r *http.Request, id int64) {
+	movie, err := app.modelsFor(r).Movies.GetRedirect(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", app.movieURL(movie))
+
+	err = app.writeJSON(w, http.StatusMovedPermanently, envelope{"message": "this movie has been merged into another one", "movie": movie}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// accountMergeTTL is how long the confirmation token of an account merge is valid.
+const accountMergeTTL = 15 * time.Minute
+
+// The requestAccountMergeHandler for the "POST /v1/users/me/merges" endpoint is the
+// first step of merging another account of the current user into this one. The client
+// proves that it owns the other account by sending its email address and password,
+// with a code or recovery_code if the account has two-factor authentication, and gets
+// back a preview of what will be transferred and a short-lived confirmation token.
+// Nothing is changed until the token is sent to the confirm endpoint.
+func (app *application) requestAccountMergeHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Email        string `json:"email"`
+		Password     string `json:"password"`
+		Code         string `json:"code"`
+		RecoveryCode string `json:"recovery_code"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	data.ValidateEmail(v, input.Email)
+	data.ValidatePasswordPlaintext(v, input.Password)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	target := app.contextGetUser(r)
+
+	source, err := app.modelsFor(r).Users.GetByEmail(input.Email)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.invalidCredentialsResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	// The password is checked like a login's, so it can't be used to guess passwords
+	// past the lockout.
+	if !app.checkPassword(w, r, source, input.Password) {
+		return
+	}
+	if !source.Active {
+		app.deactivatedAccountResponse(w, r)
+		return
+	}
+	if !source.Activated {
+		app.accountNotActivatedResponse(w, r, false)
+		return
+	}
+	// The credentials of a flagged account may be in the wrong hands, so they're not
+	// good enough to prove ownership.
+	if source.PasswordResetRequired {
+		app.passwordResetRequiredResponse(w, r)
+		return
+	}
+	// The password alone isn't enough for an account with two-factor authentication,
+	// just as it isn't to log in to it.
+	if !app.checkSecondFactor(w, r, source, input.Code, input.RecoveryCode) {
+		return
+	}
+	if source.ID == target.ID {
+		v.AddError("email", "must belong to a different account")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	preview, err := app.modelsFor(r).Users.Merge(source.ID, target.ID, true)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	token, err := app.modelsFor(r).Tokens.New(source.ID, accountMergeTTL, data.ScopeAccountMerge)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	env := envelope{
+		"message":      fmt.Sprintf("%s will be merged into your account and then deleted; this can't be undone", source.Email),
+		"merge":        preview,
+		"confirmation": token,
+	}
+	err = app.writeJSON(w, http.StatusCreated, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The confirmAccountMergeHandler for the "POST /v1/users/me/merges/confirm" endpoint
+// carries out a merge requested with requestAccountMergeHandler.
+func (app *application) confirmAccountMergeHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		TokenPlaintext string `json:"token"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	if data.ValidateTokenPlaintext(v, input.TokenPlaintext); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	target := app.contextGetUser(r)
+
+	source, err := app.modelsFor(r).Users.GetForToken(data.ScopeAccountMerge, input.TokenPlaintext)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("token", "invalid or expired confirmation token")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	if source.ID == target.ID {
+		v.AddError("token", "invalid or expired confirmation token")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	app.mergeAccounts(w, r, source.ID, target.ID)
+}
+
+// The mergeUserHandler for the "POST /v1/admin/users/:id/merge" endpoint lets an
+// administrator merge the user given in the URL into the user given by the "into"
+// field. The first call only returns a preview with a confirmation token, and the merge
+// is done when the request is repeated with the token (see confirmed()).
+func (app *application) mergeUserHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Into int64 `json:"into"`
+	}
+
+	sourceID, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	v.Check(input.Into > 0, "into", "must be provided")
+	v.Check(input.Into != sourceID, "into", "must be a different user")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	preview, err := app.modelsFor(r).Users.Merge(sourceID, input.Into, true)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if !app.confirmed(w, r, "merge_user", input.Into, envelope{"merge": preview}) {
+		return
+	}
+	app.mergeAccounts(w, r, sourceID, input.Into)
+}
+
+// The mergeAccounts() method merges the source user into the target user and sends the
+// report to the client.
+func (app *application) mergeAccounts(w http.ResponseWriter, r *http.Request, sourceID, targetID int64) {
+	report, err := app.modelsFor(r).Users.Merge(sourceID, targetID, false)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	// The source's tokens went with it, and its JWTs are revoked too, so every session
+	// of the source ends and its clients log in again.
+	app.incident.revokeJWTs(time.Now(), sourceID)
+	app.permissionCache.Delete(sourceID)
+
+	app.requestLogger(r).PrintInfo("accounts merged", map[string]string{
+		"source_id": fmt.Sprint(sourceID),
+		"target_id": fmt.Sprint(targetID),
+		"merged_by": fmt.Sprint(app.contextGetUser(r).ID),
+	})
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"merge": report}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
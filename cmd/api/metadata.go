@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/shyngys9219/greenlight/internal/jsonlog"
+)
+
+// Every JSON envelope the API sends has a metadata section with the information about
+// the request itself, so that clients don't need to read it from the headers:
+//
+//	"metadata": {
+//	    "request_id": "3f2a9c1e7b5d4a60",
+//	    "processing_time_ms": 1.283,
+//	    "rate_limit": {"key": "127.0.0.1", "rps": 2, "burst": 4, "tokens_remaining": 3},
+//	    "deprecations": [...],
+//	    "current_page": 1, "page_size": 20, ...
+//	}
+//
+// The listing endpoints' pagination fields stay where they've always been, at the top
+// level of the metadata, and the other fields are only present when they apply. The
+// section is filled in centrally by writeJSON(), from the responseMetadata of the
+// request.
+
+// A responseMetadata holds what the metadata section reports about a request. It's
+// created by the trackResponseMetadata() middleware, and filled in as the request goes
+// through the middleware chain.
+type responseMetadata struct {
+	requestID string
+	start     time.Time
+	rateLimit *rateLimitState
+	logger    *jsonlog.Logger // adds the request ID to every entry, see requestLogger()
+	userID    int64           // the authenticated user, for the access log
+}
+
+const responseMetadataContextKey = contextKey("responseMetadata")
+
+// The trackResponseMetadata() middleware starts the clock for the processing time and
+// gives the request an ID, see requestIDFor(), which is also sent in the X-Request-ID
+// header. The metadata is kept in the request context, for the middleware which adds to
+// it, and on the response writer, where writeJSON() finds it.
+func (app *application) trackResponseMetadata(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		meta := &responseMetadata{requestID: requestIDFor(r), start: time.Now()}
+		meta.logger = app.logger.With(map[string]string{"request_id": meta.requestID})
+		w.Header().Set("X-Request-ID", meta.requestID)
+
+		ctx := context.WithValue(r.Context(), responseMetadataContextKey, meta)
+		next.ServeHTTP(&metadataWriter{ResponseWriter: w, meta: meta}, r.WithContext(ctx))
+	})
+}
+
+// The carryResponseMetadata() middleware attaches the request's metadata to the response
+// writer again. It's needed under http.TimeoutHandler, which hands the handler a writer
+// of its own that can't be unwrapped.
+func (app *application) carryResponseMetadata(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if meta := contextGetResponseMetadata(r.Context()); meta != nil && responseMetadataFor(w) == nil {
+			w = &metadataWriter{ResponseWriter: w, meta: meta}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func contextGetResponseMetadata(ctx context.Context) *responseMetadata {
+	meta, _ := ctx.Value(responseMetadataContextKey).(*responseMetadata)
+	return meta
+}
+
+// metadataWriter carries the responseMetadata of a request down to writeJSON().
+type metadataWriter struct {
+	http.ResponseWriter
+	meta *responseMetadata
+}
+
+func (mw *metadataWriter) Unwrap() http.ResponseWriter {
+	return mw.ResponseWriter
+}
+
+// responseMetadataFor() finds the responseMetadata on a response writer, looking through
+// the writers wrapped around it by other middleware. It returns nil if there's none, as
+// for a response written outside the middleware chain.
+func responseMetadataFor(w http.ResponseWriter) *responseMetadata {
+	for {
+		switch rw := w.(type) {
+		case *metadataWriter:
+			return rw.meta
+		case interface{ Unwrap() http.ResponseWriter }:
+			w = rw.Unwrap()
+		default:
+			return nil
+		}
+	}
+}
+
+// fields returns the metadata fields for a response written now.
+func (meta *responseMetadata) fields() map[string]any {
+	fields := map[string]any{
+		"request_id": meta.requestID,
+		// Milliseconds, to the microsecond.
+		"processing_time_ms": math.Round(float64(time.Since(meta.start).Microseconds())) / 1000,
+	}
+	if meta.rateLimit != nil {
+		fields["rate_limit"] = meta.rateLimit
+	}
+	return fields
+}
+
+// withMetadata returns a copy of the envelope with the fields added to its metadata,
+// merging them into any metadata it has already, such as the pagination of a listing.
+func withMetadata(env envelope, fields map[string]any) (envelope, error) {
+	metadata := map[string]any{}
+	if existing, ok := env["metadata"]; ok {
+		js, err := json.Marshal(existing)
+		if err != nil {
+			return nil, err
+		}
+		err = json.Unmarshal(js, &metadata)
+		if err != nil {
+			return nil, err
+		}
+	}
+	for key, value := range fields {
+		metadata[key] = value
+	}
+
+	out := make(envelope, len(env)+1)
+	for key, value := range env {
+		out[key] = value
+	}
+	out["metadata"] = metadata
+	return out, nil
+}
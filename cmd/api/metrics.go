@@ -0,0 +1,144 @@
+package main
+
+import (
+	"database/sql"
+	"expvar"
+	"fmt"
+	"net/http"
+	"runtime"
+	"strconv"
+	"time"
+
+	"github.com/shyngys9219/greenlight/internal/data"
+	"github.com/shyngys9219/greenlight/internal/leader"
+	"github.com/shyngys9219/greenlight/internal/mailer"
+)
+
+// The request metrics collected by the metrics() middleware. Like every other expvar
+// variable they're served at GET /debug/vars when -metrics is set.
+var (
+	totalRequestsReceived           = expvar.NewInt("total_requests_received")
+	totalResponsesSent              = expvar.NewInt("total_responses_sent")
+	totalRequestsInFlight           = expvar.NewInt("total_requests_in_flight")
+	totalProcessingTimeMicroseconds = expvar.NewInt("total_processing_time_μs")
+	totalResponsesSentByStatus      = expvar.NewMap("total_responses_sent_by_status")
+)
+
+// The publishMetrics() function publishes the application's version, the number of
+// running goroutines, the connection pool statistics, how much the mailer's throttle is
+// holding emails back, the state of the leader locks and the current time. They're
+// computed whenever the metrics are read.
+func publishMetrics(db *sql.DB, mail mailer.Mailer, emails data.EmailQueueModel, locks ...*leader.Lock) {
+	expvar.NewString("version").Set(version)
+	expvar.Publish("goroutines", expvar.Func(func() any {
+		return runtime.NumGoroutine()
+	}))
+	expvar.Publish("database", expvar.Func(func() any {
+		return db.Stats()
+	}))
+	expvar.Publish("mailer", expvar.Func(func() any {
+		return mail.ThrottleStats()
+	}))
+	expvar.Publish("email_queue", expvar.Func(func() any {
+		stats, err := emails.Stats()
+		if err != nil {
+			return nil
+		}
+		return stats
+	}))
+	expvar.Publish("leaders", expvar.Func(func() any {
+		stats := make(map[string]leader.Stats, len(locks))
+		for _, l := range locks {
+			st := l.Stats()
+			stats[st.Name] = st
+		}
+		return stats
+	}))
+	expvar.Publish("timestamp", expvar.Func(func() any {
+		return time.Now().Unix()
+	}))
+}
+
+// The metrics() middleware counts every request, the responses sent by status code and
+// the time spent processing them, and keeps track of the requests in flight. It wraps
+// recoverPanic(), so a panicking handler is counted as the 500 response it turns into.
+func (app *application) metrics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		totalRequestsReceived.Add(1)
+		totalRequestsInFlight.Add(1)
+
+		mw := &metricsResponseWriter{ResponseWriter: w}
+		next.ServeHTTP(mw, r)
+
+		totalRequestsInFlight.Add(-1)
+		totalResponsesSent.Add(1)
+		totalResponsesSentByStatus.Add(strconv.Itoa(mw.status()), 1)
+		totalProcessingTimeMicroseconds.Add(time.Since(start).Microseconds())
+	})
+}
+
+// metricsResponseWriter records the status code of the response and the number of bytes
+// of its body, for the metrics, the traces and the access log.
+type metricsResponseWriter struct {
+	http.ResponseWriter
+	statusCode int
+	bytes      int64
+}
+
+// The status() method returns the status code of the response. A handler which writes
+// a body without calling WriteHeader(), or returns without writing anything, sends a
+// 200 OK.
+func (mw *metricsResponseWriter) status() int {
+	if mw.statusCode == 0 {
+		return http.StatusOK
+	}
+	return mw.statusCode
+}
+
+func (mw *metricsResponseWriter) WriteHeader(status int) {
+	if mw.statusCode == 0 {
+		mw.statusCode = status
+	}
+	mw.ResponseWriter.WriteHeader(status)
+}
+
+func (mw *metricsResponseWriter) Write(b []byte) (int, error) {
+	if mw.statusCode == 0 {
+		mw.statusCode = http.StatusOK
+	}
+	n, err := mw.ResponseWriter.Write(b)
+	mw.bytes += int64(n)
+	return n, err
+}
+
+func (mw *metricsResponseWriter) Unwrap() http.ResponseWriter {
+	return mw.ResponseWriter
+}
+
+// The metricsHandler for the "GET /debug/vars" endpoint sends the metrics as JSON. It's
+// only exposed when the -metrics flag is set, and should be kept off the public
+// internet, for example by only letting the load balancer's internal network reach it.
+// It's expvar.Handler() without the cmdline variable, which the expvar package
+// publishes by default: the command line holds secrets such as -db-dsn and -jwt-secret.
+func (app *application) metricsHandler(w http.ResponseWriter, r *http.Request) {
+	if !app.config.metrics {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	fmt.Fprintf(w, "{\n")
+	first := true
+	expvar.Do(func(kv expvar.KeyValue) {
+		if kv.Key == "cmdline" {
+			return
+		}
+		if !first {
+			fmt.Fprintf(w, ",\n")
+		}
+		first = false
+		fmt.Fprintf(w, "%q: %s", kv.Key, kv.Value)
+	})
+	fmt.Fprintf(w, "\n}\n")
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// The command line holds secrets, so it must never be served with the metrics.
+func TestMetricsHandlerOmitsCmdline(t *testing.T) {
+	app := &application{config: config{metrics: true}}
+
+	rr := httptest.NewRecorder()
+	app.metricsHandler(rr, httptest.NewRequest(http.MethodGet, "/debug/vars", nil))
+
+	var vars map[string]json.RawMessage
+	err := json.Unmarshal(rr.Body.Bytes(), &vars)
+	if err != nil {
+		t.Fatalf("body isn't a JSON object: %v", err)
+	}
+	if _, ok := vars["cmdline"]; ok {
+		t.Error("cmdline is served")
+	}
+	if _, ok := vars["memstats"]; !ok {
+		t.Error("memstats isn't served")
+	}
+}
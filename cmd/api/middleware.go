@@ -1,13 +1,17 @@
 package main
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
+	"github.com/julienschmidt/httprouter"
 	"github.com/shyngys9219/greenlight/internal/data"
+	"github.com/shyngys9219/greenlight/internal/ratelimit"
 	"github.com/shyngys9219/greenlight/internal/validator"
-	"golang.org/x/time/rate"
+	"math"
 	"net"
 	"net/http"
+	"strconv"
 	"strings"
 	_ "strings"
 	"sync"
@@ -22,83 +26,238 @@ func (app *application) recoverPanic(next http.Handler) http.Handler {
 			// Use the builtin recover function to check if there has been a panic or
 			// not.
 			if err := recover(); err != nil {
+				// A handler panics with http.ErrAbortHandler to abort the response on
+				// purpose. It isn't a failure, and the server relies on it reaching it,
+				// so it's passed on.
+				if err == http.ErrAbortHandler {
+					panic(err)
+				}
 				// If there was a panic, set a "Connection: close" header on the
 				// response. This acts as a trigger to make Go's HTTP server
 				// automatically close the current connection after a response has been
 				// sent.
 				w.Header().Set("Connection", "close")
-				// The value returned by recover() has the type any, so we use
-				// fmt.Errorf() to normalize it into an error and call our
-				// serverErrorResponse() helper. In turn, this will log the error using
-				// our custom Logger type at the ERROR level and send the client a 500
-				// Internal Server Error response.
-				app.serverErrorResponse(w, r, fmt.Errorf("%s", err))
+				// The panic is logged at the ERROR level, whose entries carry a stack
+				// trace. The stack still holds the frames of the panic at this point,
+				// so the trace shows where it happened.
+				app.requestLogger(r).PrintError(fmt.Errorf("panic: %v", err), map[string]string{
+					"request_method": r.Method,
+					"request_url":    r.URL.String(),
+				})
+				// The client gets the same 500 Internal Server Error response as for
+				// any other server error.
+				app.errorResponse(w, r, http.StatusInternalServerError, serverErrorMessage)
 			}
 		}()
 		next.ServeHTTP(w, r)
 	})
 }
 
+// The timeout() middleware sends a 503 Service Unavailable response if next hasn't
+// written its response within d. It's built on http.TimeoutHandler, whose response is
+// a fixed body, so a handler is made for each request with the same JSON envelope as
+// every other error response, request ID included.
+func (app *application) timeout(d time.Duration, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		env := envelope{"error": "the request timed out"}
+		if id := contextGetRequestID(r.Context()); id != "" {
+			env["request_id"] = id
+		}
+		body, err := json.Marshal(env)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		// A handler which finishes in time replaces the Content-Type with its own.
+		w.Header().Set("Content-Type", "application/json")
+		http.TimeoutHandler(next, d, string(body)).ServeHTTP(w, r)
+	})
+}
+
+// A rateLimitPolicy holds the requests-per-second and burst values for a token bucket
+// rate limiter. The optional soft tier is a lower threshold which doesn't reject any
+// requests: clients going over it get a warning header and are logged, which gives
+// integrators a grace band before they hit the hard limit and start getting 429s.
+type rateLimitPolicy struct {
+	rps       float64
+	burst     int
+	softRPS   float64 // zero disables the soft tier
+	softBurst int
+	// overridable policies are replaced by the rps and burst of a principal's "limit"
+	// override. Unlimited and blocked principals are exempt from, or rejected by, every
+	// policy.
+	overridable bool
+	// authenticated, if set, is the policy for requests made by a user or with an API
+	// key; otherwise they get the same limits as anonymous ones.
+	authenticated *rateLimitPolicy
+}
+
+// The rateLimit() middleware applies the global rate limit policy from the config
+// struct to every request, or the principal's override of it (see ratelimits.go).
+// Authenticated requests have limits of their own, and the soft tier is scaled to them
+// in proportion.
 func (app *application) rateLimit(next http.Handler) http.Handler {
-	// Define a client struct to hold the rate limiter and last seen time for each
-	// client.
-	type client struct {
-		limiter  *rate.Limiter
-		lastSeen time.Time
+	cfg := app.config.limiter
+	policy := rateLimitPolicy{
+		rps:       cfg.rps,
+		burst:     cfg.burst,
+		softRPS:   cfg.softRPS,
+		softBurst: cfg.softBurst,
+
+		overridable: true,
 	}
+	if cfg.authRPS > 0 && cfg.rps > 0 {
+		factor := cfg.authRPS / cfg.rps
+		policy.authenticated = &rateLimitPolicy{
+			rps:         cfg.authRPS,
+			burst:       cfg.authBurst,
+			softRPS:     cfg.softRPS * factor,
+			softBurst:   scaleBurst(cfg.softBurst, factor),
+			overridable: true,
+		}
+	}
+	return app.rateLimitWith("global", policy, next)
+}
+
+// The rateLimitWith() middleware limits each client to the given policy: requests made
+// by a user or with an API key are counted against that principal, wherever they come
+// from, and anonymous requests against their IP address. Every call has its own set of
+// buckets, named by name, so routes with their own policy are counted separately from
+// the global limit. Principals with a "limit" override of an overridable policy get a
+// bucket of their own. The buckets are kept in the store chosen by -limiter-store, see
+// newRateLimitStore().
+//
+// The X-RateLimit-Limit, X-RateLimit-Remaining and X-RateLimit-Reset headers tell the
+// client the size of its bucket, the requests left in it and the number of seconds
+// until it's full again. Where a route has a policy of its own, its headers replace
+// the global ones.
+func (app *application) rateLimitWith(name string, policy rateLimitPolicy, next http.Handler) http.Handler {
+	store := app.newRateLimitStore(name)
+
+	// Clients over the soft limit are logged at most once a minute, so a noisy client
+	// can't flood the logs. The times they were last logged are kept here, and
+	// forgotten by a background goroutine once they're over a minute old.
 	var (
-		mu sync.Mutex
-		// Update the map so the values are pointers to a client struct.
-		clients = make(map[string]*client)
+		mu     sync.Mutex
+		warned = make(map[string]time.Time)
 	)
-	// Launch a background goroutine which removes old entries from the clients map once
-	// every minute.
 	go func() {
 		for {
 			time.Sleep(time.Minute)
-			// Lock the mutex to prevent any rate limiter checks from happening while
-			// the cleanup is taking place.
 			mu.Lock()
-			// Loop through all clients. If they haven't been seen within the last three
-			// minutes, delete the corresponding entry from the map.
-			for ip, client := range clients {
-				if time.Since(client.lastSeen) > 3*time.Minute {
-					delete(clients, ip)
+			for key, t := range warned {
+				if time.Since(t) > time.Minute {
+					delete(warned, key)
 				}
 			}
-			// Importantly, unlock the mutex when the cleanup is complete.
 			mu.Unlock()
 		}
 	}()
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		// Blocked principals are rejected even when rate limiting is disabled, and
+		// unlimited ones are let through straight away.
+		key, p := ip, policy
+		if principal := app.rateLimitPrincipal(r); principal != "" {
+			key = principal
+			if policy.authenticated != nil {
+				p = *policy.authenticated
+			}
+		}
+		overridden := false
+		if override := app.rateLimitOverrideFor(r, ip); override != nil {
+			switch {
+			case override.Mode == data.OverrideBlock:
+				app.rateLimitExceededResponse(w, r)
+				return
+			case override.Mode == data.OverrideUnlimited:
+				if policy.overridable {
+					r = app.contextSetRateLimit(r, &rateLimitState{Key: override.Key(), Override: override.Mode})
+				}
+				next.ServeHTTP(w, r)
+				return
+			case policy.overridable:
+				key = override.Key()
+				p = rateLimitPolicy{rps: override.RPS, burst: override.Burst}
+				overridden = true
+			}
+		}
 		// Only carry out the check if rate limiting is enabled.
 		if app.config.limiter.enabled {
-			ip, _, err := net.SplitHostPort(r.RemoteAddr)
+			// While incident mode is active the limits are scaled down. The stores
+			// apply a change of the limits to the buckets they already have, so it
+			// applies to clients we already know about, and so does a change of a
+			// principal's override.
+			factor := app.incident.limitFactor()
+			limit, burst := p.rps*factor, scaleBurst(p.burst, factor)
+			res, err := store.Allow(r.Context(), key, limit, burst)
 			if err != nil {
-				app.serverErrorResponse(w, r, err)
+				// If the store is unavailable, requests are let through rather than
+				// the whole API going down with it.
+				app.rateLimitStoreError(err)
+				next.ServeHTTP(w, r)
 				return
 			}
-			mu.Lock()
-			if _, found := clients[ip]; !found {
-				clients[ip] = &client{
-					// Use the requests-per-second and burst values from the config
-					// struct.
-					limiter: rate.NewLimiter(rate.Limit(app.config.limiter.rps), app.config.limiter.burst),
-				}
-			}
-			clients[ip].lastSeen = time.Now()
-			if !clients[ip].limiter.Allow() {
-				mu.Unlock()
+			setRateLimitHeaders(w.Header(), res)
+			if !res.Allowed {
 				app.rateLimitExceededResponse(w, r)
 				return
 			}
-			mu.Unlock()
+			// The soft limit only warns.
+			if p.softRPS > 0 {
+				soft, err := store.Allow(r.Context(), "soft:"+key, p.softRPS, p.softBurst)
+				if err == nil && !soft.Allowed {
+					w.Header().Set("X-RateLimit-Warning", "approaching rate limit, please slow down")
+					mu.Lock()
+					log := time.Since(warned[key]) > time.Minute
+					if log {
+						warned[key] = time.Now()
+					}
+					mu.Unlock()
+					if log {
+						app.requestLogger(r).PrintInfo("client over soft rate limit", map[string]string{
+							"client_ip":      ip,
+							"request_method": r.Method,
+							"request_url":    r.URL.String(),
+						})
+					}
+				}
+			}
+			if policy.overridable {
+				state := &rateLimitState{Key: key, RPS: limit, Burst: burst, Remaining: res.Remaining}
+				if overridden {
+					state.Override = data.OverrideLimit
+				}
+				r = app.contextSetRateLimit(r, state)
+			}
 		}
 		next.ServeHTTP(w, r)
 	})
 
 }
 
+// setRateLimitHeaders describes the state of a client's bucket in the X-RateLimit-*
+// response headers.
+func setRateLimitHeaders(h http.Header, res ratelimit.Result) {
+	h.Set("X-RateLimit-Limit", strconv.Itoa(res.Burst))
+	h.Set("X-RateLimit-Remaining", strconv.Itoa(int(math.Max(math.Floor(res.Remaining), 0))))
+	h.Set("X-RateLimit-Reset", strconv.Itoa(int(math.Ceil(res.Reset.Seconds()))))
+}
+
+// scaleBurst scales a burst size by factor, never going below 1 so that a client can
+// always make at least one request.
+func scaleBurst(burst int, factor float64) int {
+	scaled := int(float64(burst) * factor)
+	if scaled < 1 {
+		return 1
+	}
+	return scaled
+}
+
 func (app *application) authenticate(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// Add the "Vary: Authorization" header to the response. This indicates to any
@@ -129,6 +288,43 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 		}
 		// Extract the actual authentication token from the header parts.
 		token := headerParts[1]
+		// Organization API keys are told apart from user tokens by their prefix. A
+		// request made with one acts for the organization, not for any user.
+		if strings.HasPrefix(token, data.APIKeyPrefix) {
+			// Recording the key's use is a write, which is skipped in read-only mode.
+			lookup := app.modelsFor(r).APIKeys.GetForPlaintext
+			if app.readOnly.active() {
+				lookup = app.modelsFor(r).APIKeys.Lookup
+			}
+			key, err := lookup(token)
+			if err != nil {
+				switch {
+				case errors.Is(err, data.ErrRecordNotFound):
+					app.invalidAuthenticationTokenResponse(w, r)
+				default:
+					app.serverErrorResponse(w, r, err)
+				}
+				return
+			}
+			r = app.contextSetUser(r, data.AnonymousUser)
+			r = app.contextSetAPIKey(r, key)
+			next.ServeHTTP(w, r)
+			return
+		}
+		// When JWTs are turned on, tokens made of three dot-separated parts are JWTs.
+		// They're checked without a database lookup, so the user added to the context
+		// only has the details carried by the token (see the currentUser() helper).
+		if app.jwt != nil && strings.Count(token, ".") == 2 {
+			user, err := app.userForJWT(token)
+			if err != nil {
+				app.invalidAuthenticationTokenResponse(w, r)
+				return
+			}
+			r = app.contextSetUser(r, user)
+			r = app.contextSetJWTUser(r)
+			next.ServeHTTP(w, r)
+			return
+		}
 		// Validate the token to make sure it is in a sensible format.
 		v := validator.New()
 		// If the token isn't valid, use the invalidAuthenticationTokenResponse()
@@ -142,7 +338,7 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 		// again calling the invalidAuthenticationTokenResponse() helper if no
 		// matching record was found. IMPORTANT: Notice that we are using
 		// ScopeAuthentication as the first parameter here.
-		user, err := app.models.Users.GetForToken(data.ScopeAuthentication, token)
+		user, err := app.modelsFor(r).Users.GetForToken(data.ScopeAuthentication, token)
 		if err != nil {
 			switch {
 			case errors.Is(err, data.ErrRecordNotFound):
@@ -159,3 +355,123 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 		next.ServeHTTP(w, r)
 	})
 }
+
+// The requireAuthenticatedUser() middleware checks that a user is not anonymous.
+func (app *application) requireAuthenticatedUser(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user := app.contextGetUser(r)
+		if user.IsAnonymous() {
+			app.authenticationRequiredResponse(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// The requireActivatedUser() middleware checks that a user is both authenticated and
+// activated.
+func (app *application) requireActivatedUser(next http.Handler) http.Handler {
+	fn := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user := app.contextGetUser(r)
+		if !user.Activated {
+			app.inactiveAccountResponse(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+	// Wrap fn with the requireAuthenticatedUser() middleware before returning it.
+	return app.requireAuthenticatedUser(fn)
+}
+
+// The requirePermission() middleware guards a route which declares a permission code
+// in the route table. The user must be activated and have been granted the permission,
+// or they get a 403 Forbidden response, unless the permission is one which anonymous
+// users have too.
+func (app *application) requirePermission(code string, next http.Handler) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		// Retrieve the user from the request context.
+		user := app.contextGetUser(r)
+		// Get the slice of permissions for the user, which is usually cached.
+		permissions, err := app.userPermissions(r, user.ID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		// Check if the slice includes the required permission. If it doesn't, then
+		// return a 403 Forbidden response.
+		if !permissions.Include(code) {
+			app.notPermittedResponse(w, r)
+			return
+		}
+		// Otherwise they have the required permission so we call the next handler in
+		// the chain.
+		next.ServeHTTP(w, r)
+	}
+	// Wrap this with the requireActivatedUser() middleware.
+	activated := app.requireActivatedUser(http.HandlerFunc(fn))
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if app.config.anonymousPermissions.Include(code) && app.contextGetUser(r).IsAnonymous() {
+			next.ServeHTTP(w, r)
+			return
+		}
+		activated.ServeHTTP(w, r)
+	})
+}
+
+// The requireOrgRole() middleware guards the routes of an organization, which is named
+// by the "org" URL parameter. The caller needs at least the given role in it, either as
+// an activated member or through one of the organization's API keys. Organizations the
+// caller doesn't belong to are reported as not found, so their slugs aren't revealed.
+func (app *application) requireOrgRole(role string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := app.contextGetAPIKey(r)
+		user := app.contextGetUser(r)
+		if key == nil {
+			if user.IsAnonymous() {
+				app.authenticationRequiredResponse(w, r)
+				return
+			}
+			if !user.Activated {
+				app.inactiveAccountResponse(w, r)
+				return
+			}
+		}
+
+		params := httprouter.ParamsFromContext(r.Context())
+		org, err := app.modelsFor(r).Organizations.GetBySlug(params.ByName("org"))
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				app.notFoundResponse(w, r)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+
+		var have string
+		if key != nil {
+			if key.OrganizationID == org.ID {
+				have = key.Role
+			}
+		} else {
+			have, err = app.modelsFor(r).Organizations.GetRole(org.ID, user.ID)
+			if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+				app.serverErrorResponse(w, r, err)
+				return
+			}
+		}
+		if have == "" {
+			app.notFoundResponse(w, r)
+			return
+		}
+		if !data.RoleAtLeast(have, role) {
+			app.notPermittedResponse(w, r)
+			return
+		}
+
+		r = app.contextSetOrgAccess(r, &orgAccess{org: org, role: have})
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/shyngys9219/greenlight/internal/jsonlog"
+	"github.com/shyngys9219/greenlight/internal/migrate"
+	"github.com/shyngys9219/greenlight/migrations"
+)
+
+// migrateTimeout is how long migrating the database may take, which is long: a
+// migration may have to rewrite a big table.
+const migrateTimeout = 10 * time.Minute
+
+// The runMigrate() function implements -migrate, which migrates the database with the
+// migrations embedded in the binary instead of starting the server:
+//
+//	-migrate up       applies every migration which hasn't been yet
+//	-migrate down     undoes the latest migration
+//	-migrate version  prints the version of the schema
+func runMigrate(db *sql.DB, logger *jsonlog.Logger, command string) error {
+	mg, err := migrate.New(db, migrations.FS)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), migrateTimeout)
+	defer cancel()
+
+	switch command {
+	case "up":
+		applied, err := mg.Up(ctx)
+		for _, version := range applied {
+			logger.PrintInfo("migration applied", map[string]string{"version": strconv.FormatInt(version, 10)})
+		}
+		return err
+	case "down":
+		version, err := mg.Down(ctx)
+		if err != nil {
+			return err
+		}
+		logger.PrintInfo("migration undone", map[string]string{"version": strconv.FormatInt(version, 10)})
+		return nil
+	case "version":
+		version, dirty, err := mg.Version(ctx)
+		if err != nil {
+			return err
+		}
+		logger.PrintInfo("database schema version", map[string]string{
+			"version": strconv.FormatInt(version, 10),
+			"latest":  strconv.FormatInt(mg.Latest(), 10),
+			"dirty":   strconv.FormatBool(dirty),
+		})
+		return nil
+	default:
+		return fmt.Errorf("unknown migrate command %q (want up, down or version)", command)
+	}
+}
+
+// The checkSchema() function makes sure the database schema is up to date before the
+// server starts, applying the missing migrations first if -db-auto-migrate is set. A
+// schema which is behind the code fails the startup, rather than the requests which
+// need the new tables and columns. A schema ahead of the code, as after rolling back a
+// deployment, is only logged, since migrations are written to be compatible with the
+// previous release.
+func checkSchema(db *sql.DB, logger *jsonlog.Logger, autoMigrate bool) error {
+	if autoMigrate {
+		err := runMigrate(db, logger, "up")
+		if err != nil {
+			return err
+		}
+	}
+
+	mg, err := migrate.New(db, migrations.FS)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	version, dirty, err := mg.Version(ctx)
+	switch {
+	case err != nil:
+		return err
+	case dirty:
+		return migrate.ErrDirty
+	case version < mg.Latest():
+		return fmt.Errorf("the database schema is at version %d but this release needs version %d; run with -migrate up or -db-auto-migrate", version, mg.Latest())
+	case version > mg.Latest():
+		logger.PrintInfo("database schema is newer than this release", map[string]string{
+			"version": strconv.FormatInt(version, 10),
+			"latest":  strconv.FormatInt(mg.Latest(), 10),
+		})
+	}
+	return nil
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/shyngys9219/greenlight/internal/data"
+	"github.com/shyngys9219/greenlight/internal/validator"
+)
+
+// GET /v1/movies also exports the movies matching a listing's filters, as a CSV file
+// with Accept: text/csv or ?format=csv, or an NDJSON file with Accept:
+// application/x-ndjson or ?format=ndjson, for analysts who want the whole catalog
+// rather than pages of it. The file is streamed as the movies are read from the
+// database, so it's never held in memory, and it has to finish within the server's
+// WriteTimeout.
+
+// The formats of the movie listing.
+const (
+	movieFormatJSON   = "json"
+	movieFormatCSV    = "csv"
+	movieFormatNDJSON = "ndjson"
+)
+
+// movieFormatMediaTypes map the media types of the Accept header to the formats of the
+// movie listing.
+var movieFormatMediaTypes = map[string]string{
+	"application/json":     movieFormatJSON,
+	"text/csv":             movieFormatCSV,
+	"application/x-ndjson": movieFormatNDJSON,
+	"application/ndjson":   movieFormatNDJSON,
+}
+
+// movieExportColumns are the columns of a CSV export of the movies. The genres are
+// separated by "|", and the custom_fields column holds a JSON object.
+var movieExportColumns = []string{"id", "public_id", "title", "year", "runtime", "genres", "average_rating", "review_count", "poster_url", "custom_fields"}
+
+// movieExportFlushRows is how many movies are written between flushes of an export, so
+// that the client gets them as they're read rather than when the buffers fill up.
+const movieExportFlushRows = 100
+
+// The readMovieFormat() helper returns the format a movie listing is asked for in: the
+// format query string parameter if there is one, or else the most preferred of the
+// formats in the Accept header, with JSON by default. Unknown formats are recorded in
+// the provided Validator instance.
+func (app *application) readMovieFormat(w http.ResponseWriter, r *http.Request, v *validator.Validator) string {
+	w.Header().Add("Vary", "Accept")
+
+	if format := r.URL.Query().Get("format"); format != "" {
+		v.Check(validator.PermittedValue(format, movieFormatJSON, movieFormatCSV, movieFormatNDJSON), "format", "must be json, csv or ndjson")
+		return format
+	}
+
+	format, best := movieFormatJSON, 0.0
+	for _, part := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if s, ok := params["q"]; ok {
+			q, err = strconv.ParseFloat(s, 64)
+			if err != nil {
+				continue
+			}
+		}
+		if f, ok := movieFormatMediaTypes[mediaType]; ok && q > best {
+			format, best = f, q
+		}
+	}
+	return format
+}
+
+// The exportMovies() helper sends the movies passed to fn by export as a file in the
+// format. Nothing is sent until the first movie is read, so that an error before then
+// still gets an error response; after that the response is under way, and an error can
+// only cut it short.
+func (app *application) exportMovies(w http.ResponseWriter, r *http.Request, format string, export func(fn func(*data.Movie) error) error) {
+	var cw *csv.Writer
+	f := appliedFormats(w.Header())
+
+	started := false
+	start := func() error {
+		if started {
+			return nil
+		}
+		started = true
+
+		contentType := "application/x-ndjson"
+		if format == movieFormatCSV {
+			contentType = "text/csv; charset=utf-8"
+		}
+		filename := fmt.Sprintf("greenlight-movies-%s.%s", time.Now().UTC().Format("20060102T150405Z"), format)
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+		w.WriteHeader(http.StatusOK)
+
+		if format == movieFormatCSV {
+			cw = csv.NewWriter(w)
+			return cw.Write(movieExportColumns)
+		}
+		return nil
+	}
+
+	rows := 0
+	err := export(func(movie *data.Movie) error {
+		err := start()
+		if err != nil {
+			return err
+		}
+
+		if format == movieFormatCSV {
+			err = cw.Write(movieExportRecord(movie))
+		} else {
+			var js []byte
+			js, err = json.Marshal(movie)
+			if err == nil {
+				js, err = f.apply(js)
+			}
+			if err == nil {
+				_, err = w.Write(append(js, '\n'))
+			}
+		}
+		if err != nil {
+			return err
+		}
+
+		if rows++; rows%movieExportFlushRows == 0 {
+			if cw != nil {
+				cw.Flush()
+			}
+			flushResponse(w)
+		}
+		return nil
+	})
+	if err == nil {
+		// An export without any movies is still a file, with its header row.
+		err = start()
+	}
+	if err != nil {
+		if !started {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		app.logError(r, err)
+		return
+	}
+
+	if cw != nil {
+		cw.Flush()
+		if err := cw.Error(); err != nil {
+			app.logError(r, err)
+		}
+	}
+	app.requestLogger(r).PrintInfo("movies exported", map[string]string{
+		"format": format,
+		"movies": strconv.Itoa(rows),
+	})
+}
+
+// The movieExportRecord() helper returns a movie as a record of a CSV export, with the
+// columns of movieExportColumns.
+func movieExportRecord(movie *data.Movie) []string {
+	var rating string
+	if movie.AverageRating != nil {
+		rating = strconv.FormatFloat(*movie.AverageRating, 'f', 2, 64)
+	}
+	var customFields string
+	if len(movie.CustomFields) > 0 {
+		js, err := json.Marshal(movie.CustomFields)
+		if err == nil {
+			customFields = string(js)
+		}
+	}
+	return []string{
+		strconv.FormatInt(movie.ID, 10),
+		movie.PublicID,
+		csvText(movie.Title),
+		strconv.Itoa(int(movie.Year)),
+		strconv.Itoa(int(movie.Runtime)),
+		csvText(strings.Join(movie.Genres, "|")),
+		rating,
+		strconv.Itoa(movie.ReviewCount),
+		csvText(movie.PosterURL),
+		csvText(customFields),
+	}
+}
+
+// The csvText() helper guards a text field of a CSV export against formula injection.
+// Spreadsheets run a cell starting with =, +, - or @ as a formula, so such a value,
+// which users could have given a movie title, is prefixed with a ' to keep it text.
+func csvText(s string) string {
+	if s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
+
+// The flushResponse() helper sends what's been written of a response so far to the
+// client, through the writers middleware has wrapped around it.
+func flushResponse(w http.ResponseWriter) {
+	for {
+		switch rw := w.(type) {
+		case http.Flusher:
+			rw.Flush()
+			return
+		case interface{ Unwrap() http.ResponseWriter }:
+			w = rw.Unwrap()
+		default:
+			return
+		}
+	}
+}
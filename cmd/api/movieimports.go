@@ -0,0 +1,312 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/shyngys9219/greenlight/internal/data"
+	"github.com/shyngys9219/greenlight/internal/validator"
+)
+
+// Catalogs moving over from another system are loaded with POST /v1/movies/import, from
+// a CSV or NDJSON file. Every row is checked like a movie created on its own, and the
+// ones which pass are inserted data.MovieImportBatchSize at a time, each batch in its
+// own transaction. The response reports the rows which failed, so they can be fixed and
+// imported again; with conflict=skip, the default, importing the same file twice
+// doesn't duplicate the movies which made it the first time.
+
+// movieImportMaxBytes is the largest file a movie import accepts.
+const movieImportMaxBytes = 64 << 20
+
+// movieImportColumns are the columns of a CSV file of movies. The custom_fields column is
+// optional, and holds a JSON object.
+var movieImportColumns = []string{"title", "year", "runtime", "genres", "custom_fields"}
+
+// A movieImportRow is a line of an NDJSON file of movies.
+type movieImportRow struct {
+	Title        string            `json:"title"`
+	Year         int32             `json:"year"`
+	Runtime      int32             `json:"runtime"`
+	Genres       []string          `json:"genres"`
+	CustomFields data.CustomFields `json:"custom_fields"`
+}
+
+// The importMoviesHandler for the "POST /v1/movies/import" endpoint adds the movies of a
+// file to the catalog. The body is a CSV file, with the text/csv content type and a
+// header row naming the columns of movieImportColumns, or an NDJSON file, with the
+// application/x-ndjson content type and a movie object on each line. In a CSV file
+// the genres are separated by "|" or ",". With conflict=skip, the default, movies whose
+// title (ignoring case) and year are already in the catalog, or earlier in the file,
+// are skipped; conflict=create adds them all. A dry run checks the rows without
+// importing any; its created count includes movies which may turn out to be in the
+// catalog already.
+func (app *application) importMoviesHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	dryRun := app.readDryRun(r, v)
+	conflict := app.readString(r.URL.Query(), "conflict", data.ConflictSkip)
+	v.Check(validator.PermittedValue(conflict, data.ConflictSkip, data.ConflictCreate), "conflict", "must be skip or create")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	models := app.modelsFor(r)
+	user := app.contextGetUser(r)
+	// Restricted accounts can't add movies one at a time, so they can't import them
+	// either.
+	status, err := models.Abuse.GetRestriction(user.ID)
+	switch {
+	case err == nil && status != data.RestrictionExempt:
+		app.accountHeldResponse(w, r)
+		return
+	case err != nil && !errors.Is(err, data.ErrRecordNotFound):
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	defs, err := models.CustomFields.GetAll()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	report := &data.MovieImportReport{Errors: []*data.MovieImportRowError{}}
+	var movies []*data.Movie
+	seen := make(map[string]bool)
+	err = app.readMovieImport(w, r, func(row int, movie *data.Movie, rowErrors map[string]string) {
+		report.Rows++
+		if rowErrors == nil {
+			v := validator.New()
+			data.ValidateCustomFields(v, defs, movie.CustomFields)
+			data.ValidateMovie(v, movie)
+			rowErrors = v.Errors
+		}
+		if len(rowErrors) > 0 {
+			report.Failed++
+			report.Errors = append(report.Errors, &data.MovieImportRowError{Row: row, Errors: rowErrors})
+			return
+		}
+		if conflict == data.ConflictSkip {
+			key := strings.ToLower(movie.Title) + "\x00" + strconv.Itoa(int(movie.Year))
+			if seen[key] {
+				report.Skipped++
+				return
+			}
+			seen[key] = true
+		}
+		movies = append(movies, movie)
+	})
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v.Check(report.Rows > 0, "rows", "must contain at least one movie")
+	v.Check(report.Rows <= data.MaxMovieImport, "rows", fmt.Sprintf("must not contain more than %d movies", data.MaxMovieImport))
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	if dryRun {
+		report.Created = len(movies)
+		app.dryRunResponse(w, r, envelope{"report": report})
+		return
+	}
+
+	start := time.Now()
+	properties := func() map[string]string {
+		return map[string]string{
+			"user_id":  fmt.Sprint(user.ID),
+			"rows":     fmt.Sprint(report.Rows),
+			"created":  fmt.Sprint(report.Created),
+			"skipped":  fmt.Sprint(report.Skipped),
+			"failed":   fmt.Sprint(report.Failed),
+			"batches":  fmt.Sprint(report.Batches),
+			"duration": time.Since(start).String(),
+		}
+	}
+	for i := 0; i < len(movies); i += data.MovieImportBatchSize {
+		end := i + data.MovieImportBatchSize
+		if end > len(movies) {
+			end = len(movies)
+		}
+		created, err := models.Movies.InsertBatch(movies[i:end], conflict == data.ConflictSkip)
+		if err != nil {
+			// The batches before this one stay imported. Running the import again with
+			// conflict=skip picks up where it stopped.
+			app.requestLogger(r).PrintInfo("movie import stopped", properties())
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		report.Batches++
+		report.Created += created
+		report.Skipped += end - i - created
+	}
+	// Imported movies don't publish movie.created events, which would email the
+	// followers of their genres about every one of them; they're only counted.
+	moviesCreated.Add(int64(report.Created))
+	app.requestLogger(r).PrintInfo("movies imported", properties())
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"report": report}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The readMovieImport() helper reads the movies of an import from the request body, as
+// CSV or NDJSON depending on its content type, and calls fn with each row. Rows which
+// can't be read as a movie are passed with their errors, keyed by field; they don't
+// stop the others being read. Reading stops once there are more rows than an import
+// can hold. The error returned is about the body as a whole.
+func (app *application) readMovieImport(w http.ResponseWriter, r *http.Request, fn func(row int, movie *data.Movie, rowErrors map[string]string)) error {
+	r.Body = http.MaxBytesReader(w, r.Body, movieImportMaxBytes)
+
+	var err error
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch mediaType {
+	case "text/csv":
+		err = readMovieImportCSV(r.Body, fn)
+	case "application/x-ndjson", "application/ndjson", "application/jsonl":
+		err = readMovieImportNDJSON(r.Body, fn)
+	default:
+		return errors.New("body must be a CSV file, with the text/csv content type, or an NDJSON file, with the application/x-ndjson content type")
+	}
+	var maxBytesError *http.MaxBytesError
+	if errors.As(err, &maxBytesError) {
+		return fmt.Errorf("body must not be larger than %d bytes", movieImportMaxBytes)
+	}
+	return err
+}
+
+// The readMovieImportCSV() function reads the rows of a CSV file of movies, counting
+// them from the one after the header.
+func readMovieImportCSV(body io.Reader, fn func(int, *data.Movie, map[string]string)) error {
+	reader := csv.NewReader(body)
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return errors.New("body must not be empty")
+		}
+		return err
+	}
+	columns := make(map[string]int)
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if !validator.PermittedValue(name, movieImportColumns...) {
+			return fmt.Errorf("CSV header has an unknown column %q; the columns are %s", name, strings.Join(movieImportColumns, ", "))
+		}
+		columns[name] = i
+	}
+	for _, name := range movieImportColumns[:4] {
+		if _, ok := columns[name]; !ok {
+			return fmt.Errorf("CSV header must include a %q column", name)
+		}
+	}
+	field := func(record []string, name string) string {
+		i, ok := columns[name]
+		if !ok {
+			return ""
+		}
+		return strings.TrimSpace(record[i])
+	}
+
+	for row := 1; row <= data.MaxMovieImport+1; row++ {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if errors.Is(err, csv.ErrFieldCount) {
+			fn(row, nil, map[string]string{"row": fmt.Sprintf("must have %d fields", len(header))})
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		movie := &data.Movie{Title: field(record, "title")}
+		rowErrors := make(map[string]string)
+		for name, dst := range map[string]*int32{"year": &movie.Year, "runtime": &movie.Runtime} {
+			if s := field(record, name); s != "" {
+				n, err := strconv.ParseInt(s, 10, 32)
+				if err != nil {
+					rowErrors[name] = "must be an integer"
+				}
+				*dst = int32(n)
+			}
+		}
+		if s := field(record, "genres"); s != "" {
+			movie.Genres = []string{}
+			for _, genre := range strings.FieldsFunc(s, func(c rune) bool { return c == '|' || c == ',' }) {
+				if genre = strings.TrimSpace(genre); genre != "" {
+					movie.Genres = append(movie.Genres, genre)
+				}
+			}
+		}
+		if s := field(record, "custom_fields"); s != "" {
+			err := json.Unmarshal([]byte(s), &movie.CustomFields)
+			if err != nil {
+				rowErrors["custom_fields"] = "must be a JSON object"
+			}
+		}
+		if len(rowErrors) > 0 {
+			fn(row, nil, rowErrors)
+			continue
+		}
+		fn(row, movie, nil)
+	}
+	return nil
+}
+
+// The readMovieImportNDJSON() function reads the lines of an NDJSON file of movies,
+// counting them from 1. Blank lines are skipped, but counted.
+func readMovieImportNDJSON(body io.Reader, fn func(int, *data.Movie, map[string]string)) error {
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 64<<10), 1<<20)
+
+	rows := 0
+	for line := 1; scanner.Scan(); line++ {
+		b := bytes.TrimSpace(scanner.Bytes())
+		if len(b) == 0 {
+			continue
+		}
+		if rows++; rows > data.MaxMovieImport+1 {
+			return nil
+		}
+
+		var input movieImportRow
+		dec := json.NewDecoder(bytes.NewReader(b))
+		dec.DisallowUnknownFields()
+		err := jsonError(dec.Decode(&input))
+		if err == nil && dec.More() {
+			err = errors.New("line must only contain a single JSON object")
+		}
+		if err != nil {
+			fn(line, nil, map[string]string{"row": err.Error()})
+			continue
+		}
+		fn(line, &data.Movie{
+			Title:        input.Title,
+			Year:         input.Year,
+			Runtime:      input.Runtime,
+			Genres:       input.Genres,
+			CustomFields: input.CustomFields,
+		}, nil)
+	}
+	if errors.Is(scanner.Err(), bufio.ErrTooLong) {
+		return errors.New("body must not have lines longer than 1 MB")
+	}
+	return scanner.Err()
+}
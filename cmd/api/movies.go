@@ -1,11 +1,18 @@
 package main
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
+	"mime"
 	"net/http"
+	"strconv"
+	"strings"
 
 	"github.com/shyngys9219/greenlight/internal/data"
+	"github.com/shyngys9219/greenlight/internal/events"
+	"github.com/shyngys9219/greenlight/internal/publicid"
+	"github.com/shyngys9219/greenlight/internal/validator"
 )
 
 // Add a createMovieHandler for the "POST /v1/movies" endpoint.
@@ -16,33 +23,62 @@ func (app *application) createMovieHandler(w http.ResponseWriter, r *http.Reques
 	// of the Movie struct that we created earlier). This struct will be our *target
 	// decode destination*.
 	var input struct {
-		Title   string   `json:"title"`
-		Year    int32    `json:"year"`
-		Runtime int32    `json:"runtime"`
-		Genres  []string `json:"genres"`
+		Title        string            `json:"title"`
+		Year         int32             `json:"year"`
+		Runtime      int32             `json:"runtime"`
+		Genres       []string          `json:"genres"`
+		CustomFields data.CustomFields `json:"custom_fields"`
 	}
 
 	// if there is error with decoding, we are sending corresponding message
 	err := app.readJSON(w, r, &input) //non-nil pointer as the target decode destination
 	if err != nil {
-		app.errorResponse(w, r, http.StatusBadRequest, err.Error())
+		app.badRequestResponse(w, r, err)
+		return
 	}
 
 	movie := &data.Movie{
-		Title:   input.Title,
-		Year:    input.Year,
-		Runtime: input.Runtime,
-		Genres:  input.Genres,
+		Title:        input.Title,
+		Year:         input.Year,
+		Runtime:      input.Runtime,
+		Genres:       input.Genres,
+		CustomFields: input.CustomFields,
+	}
+
+	defs, err := app.modelsFor(r).CustomFields.GetAll()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	dryRun := app.readDryRun(r, v)
+	data.ValidateCustomFields(v, defs, movie.CustomFields)
+	if data.ValidateMovie(v, movie); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	if dryRun {
+		app.movieDryRunResponse(w, r, movie)
+		return
+	}
+	// Movies can't be hidden like reviews, so shadow-limited accounts can't add any
+	// either.
+	if app.checkWriteVelocity(r, writeMovie) != "" {
+		app.accountHeldResponse(w, r)
+		return
 	}
 
-	err = app.models.Movies.Insert(movie)
+	err = app.modelsFor(r).Movies.Insert(movie)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
+	app.publish(events.MovieCreated, movie)
 
 	headers := make(http.Header)
-	headers.Set("Location", fmt.Sprintf("/v1/movies/%d", movie.ID))
+	headers.Set("Location", app.movieURL(movie))
+	headers.Set("ETag", etag(int(movie.Version)))
 
 	err = app.writeJSON(w, http.StatusCreated, envelope{"movie": movie}, headers)
 	if err != nil {
@@ -55,64 +91,487 @@ func (app *application) createMovieHandler(w http.ResponseWriter, r *http.Reques
 // Add a showMovieHandler for the "GET /v1/movies/:id" endpoint.
 // TO-DO: Change this handler to retrieve data from a real db
 func (app *application) showMovieHandler(w http.ResponseWriter, r *http.Request) {
-	id, err := app.readIDParam(r)
+	app.showMovie(w, r, false)
+}
+
+// The showMovieWithCreditsHandler is the canary of the "GET /v1/movies/:id" endpoint,
+// see canary.go. It sends the movie's credits along with it, so that a client showing
+// a movie doesn't have to ask for them separately. The ETag is still the movie's
+// version, which If-Match is checked against, but it doesn't change with the credits,
+// so the canary ignores If-None-Match; canary() keeps shared caches from storing it.
+func (app *application) showMovieWithCreditsHandler(w http.ResponseWriter, r *http.Request) {
+	app.showMovie(w, r, true)
+}
+
+// The showMovie() helper sends a movie, with its credits if withCredits is set.
+func (app *application) showMovie(w http.ResponseWriter, r *http.Request, withCredits bool) {
+	id, err := app.readMovieIDParam(r)
 	if err != nil {
-		app.notFoundResponse(w, r)
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
 	}
 
-	movie, err := app.models.Movies.Get(id)
+	// Movie details are served from the stale-while-revalidate cache, so a slow
+	// database only delays the first request for a movie. The cached movie includes its
+	// translations, so it can be localized for any request.
+	movie, err := app.movieCache.Get(id, func() (*data.Movie, error) {
+		movie, err := app.modelsFor(r).Movies.Get(id)
+		if err != nil {
+			return nil, err
+		}
+		return movie, app.modelsFor(r).MovieTranslations.Load(movie)
+	})
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
-			app.notFoundResponse(w, r)
+			// The movie may have been merged into another one.
+			app.redirectMergedMovie(w, r, id)
 		default:
 			app.serverErrorResponse(w, r, err)
 		}
 		return
 	}
+	movie = app.localizeMovies(w, r, movie)[0]
+	app.recordVisitorView(r, movie.ID)
+
+	// The ETag carries the movie's version, which must be sent back in the If-Match
+	// header of an update or a deletion. A client which already has this version, and
+	// says so with If-None-Match, gets a 304 Not Modified response without the body.
+	headers := make(http.Header)
+	headers.Set("ETag", etag(int(movie.Version)))
+	if !withCredits && app.ifNoneMatch(r, headers.Get("ETag")) {
+		w.Header().Set("ETag", headers.Get("ETag"))
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	env := envelope{"movie": movie}
+	if withCredits {
+		credits, err := app.modelsFor(r).People.GetCreditsForMovie(movie.ID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		env["credits"] = credits
+	}
 	// Encode the struct to JSON and send it as the HTTP response.
 	// using envelope
+	err = app.writeJSON(w, http.StatusOK, env, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The listMoviesHandler for the "GET /v1/movies" endpoint returns a page of the movies,
+// optionally narrowed down by words of the title, genres, by name or with genre_ids
+// (all of which a movie must have), year/runtime filters and filters on custom fields, for example
+// /v1/movies?title=godfather&genres=crime,drama&year[gte]=1970&sort=-year&page=2 or
+// /v1/movies?cf.catalog_number=A-1234.
+// Misspelled titles are found too when the -search-trigram flag is set, and long
+// overviews can be shortened with ?truncate=, see truncate.go.
+// A search page can ask for the titles with the words searched for marked up, with
+// ?highlight=true, and for facets, with ?facets=true: the number of movies matching
+// the search by genre, decade and rating band, to offer as further filters. They're
+// computed in the same query as the page, see data.MovieSearch. The movies can be
+// exported as CSV or NDJSON too, see movieexports.go.
+// The metadata in the response tells the client which pages there are. Deep pages are
+// better fetched with a cursor: ?cursor= asks for the first page of a keyset listing,
+// and the next_cursor in its metadata for the page after it.
+func (app *application) listMoviesHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	qs := r.URL.Query()
+
+	title := app.readString(qs, "title", "")
+	genres := app.readCSV(qs, "genres", nil)
+	genreIDs := app.readIDCSV(qs, "genre_ids", v)
+	filters := app.readFilters(qs, v)
+	custom := app.readCustomFieldFilters(qs)
+	truncate := app.readTruncate(qs, v)
+	format := app.readMovieFormat(w, r, v)
+	search := data.MovieSearch{
+		Highlight: app.readBool(qs, "highlight", v),
+		Facets:    app.readBool(qs, "facets", v),
+	}
+	page := data.Filters{
+		Page:         app.readInt(qs, "page", 1, v),
+		PageSize:     app.readInt(qs, "page_size", 20, v),
+		Sort:         app.readString(qs, "sort", "id"),
+		SortSafelist: data.MovieSortSafelist,
+		Keyset:       qs.Has("cursor"),
+		Cursor:       qs.Get("cursor"),
+	}
+
+	data.ValidateFilters(v, filters, data.MovieFilterFields...)
+	if len(custom) > 0 {
+		defs, err := app.modelsFor(r).CustomFields.GetAll()
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		data.ValidateCustomFieldFilters(v, defs, custom)
+	}
+	if data.ValidateListFilters(v, page); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// An export has every movie matching the filters, in the order of the sort, see
+	// movieexports.go.
+	if format != movieFormatJSON {
+		app.exportMovies(w, r, format, func(fn func(*data.Movie) error) error {
+			return app.modelsFor(r).Movies.Export(title, genres, genreIDs, filters, custom, page, fn)
+		})
+		return
+	}
+
+	movies, facets, metadata, err := app.modelsFor(r).Movies.GetAll(title, false, genres, genreIDs, filters, custom, page, search)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	// If no title has all the words searched for, and fuzzy search is on, fall back to
+	// the titles which are similar to them.
+	if len(movies) == 0 && title != "" && app.config.search.trigram {
+		movies, facets, metadata, err = app.modelsFor(r).Movies.GetAll(title, true, genres, genreIDs, filters, custom, page, search)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+	// Searches by title count towards the search analytics, but paging through the
+	// results of one doesn't. Keyset pages aren't counted, so the number of results of
+	// a search made with them is only the number on the first page.
+	if page.Page == 1 && page.Cursor == "" {
+		results := metadata.TotalRecords
+		if page.Keyset {
+			results = len(movies)
+		}
+		app.recordSearch(r, title, results)
+	}
+
+	err = app.modelsFor(r).MovieTranslations.Load(movies...)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	movies = app.localizeMovies(w, r, movies...)
+	truncateMovies(movies, truncate)
+
+	env := envelope{"movies": movies, "metadata": metadata}
+	if facets != nil {
+		env["facets"] = facets
+	}
+	err = app.writeJSON(w, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The movieDryRunResponse() helper ends a dry run of creating or updating a movie.
+// Movies can share a title and year, so another movie with the same ones doesn't stop
+// the movie from being saved, but the dry run warns about it, since it's most likely a
+// duplicate.
+func (app *application) movieDryRunResponse(w http.ResponseWriter, r *http.Request, movie *data.Movie) {
+	env := envelope{"movie": movie}
+	duplicate, err := app.modelsFor(r).Movies.FindDuplicate(movie.Title, movie.Year, movie.ID)
+	switch {
+	case err == nil:
+		env["warnings"] = map[string]string{
+			"title": "a movie with this title and year already exists at " + app.movieURL(duplicate),
+		}
+	case !errors.Is(err, data.ErrRecordNotFound):
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	app.dryRunResponse(w, r, env)
+}
+
+// The movieURL() method returns the URL of a movie, using its public ID if sequential
+// IDs are not accepted.
+func (app *application) movieURL(movie *data.Movie) string {
+	if app.config.publicID.only {
+		return "/v1/movies/" + movie.PublicID
+	}
+	return fmt.Sprintf("/v1/movies/%d", movie.ID)
+}
+
+// maxCompareMovies is the most movies which can be compared in a single request.
+const maxCompareMovies = 5
+
+// The compareMoviesHandler for the "GET /v1/movies/compare?ids=1,2,3" endpoint returns
+// the requested movies side by side, so a client can build a comparison view without
+// fetching every movie separately.
+func (app *application) compareMoviesHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+
+	raw := app.readCSV(r.URL.Query(), "ids", nil)
+	v.Check(len(raw) >= 2, "ids", "must contain at least 2 movie ids")
+	v.Check(len(raw) <= maxCompareMovies, "ids", fmt.Sprintf("must not contain more than %d movie ids", maxCompareMovies))
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// Public IDs are resolved to internal IDs one at a time, which is fine for the
+	// handful of movies a comparison allows. The ids are echoed back as they were
+	// given, so internal IDs don't leak when public ones were asked for.
+	var ids []int64
+	var requested []string
+	for _, s := range raw {
+		s = strings.TrimSpace(s)
+		requested = append(requested, s)
+		if publicid.Valid(s) {
+			id, err := app.modelsFor(r).Movies.GetIDByPublicID(s)
+			if err != nil {
+				switch {
+				case errors.Is(err, data.ErrRecordNotFound):
+					app.notFoundResponse(w, r)
+				default:
+					app.serverErrorResponse(w, r, err)
+				}
+				return
+			}
+			ids = append(ids, id)
+			continue
+		}
+		id, err := strconv.ParseInt(s, 10, 64)
+		if err != nil || id < 1 || app.config.publicID.only {
+			v.AddError("ids", "must be a comma-separated list of movie ids")
+			break
+		}
+		ids = append(ids, id)
+	}
+
+	v.Check(validator.Unique(ids), "ids", "must not contain duplicate values")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	movies, err := app.modelsFor(r).Movies.GetByIDs(ids)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.modelsFor(r).MovieTranslations.Load(movies...)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	movies = app.localizeMovies(w, r, movies...)
+
+	// The people credited on all of the movies, such as a director they share.
+	commonPeople, err := app.modelsFor(r).People.GetSharedByMovies(ids)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	credits, err := app.modelsFor(r).People.GetCreditsForMovies(ids)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Lay the comparable fields out side by side, in the order the ids were given, and
+	// work out which genres all of the movies have in common. A movie without reviews
+	// has a null rating, and one without credits an empty list of them.
+	runtimes := make([]int32, len(movies))
+	years := make([]int32, len(movies))
+	ratings := make([]*float64, len(movies))
+	genres := make([][]string, len(movies))
+	movieCredits := make([][]*data.Credit, len(movies))
+	genreCounts := make(map[string]int)
+	for i, movie := range movies {
+		movieCredits[i] = credits[movie.ID]
+		if movieCredits[i] == nil {
+			movieCredits[i] = []*data.Credit{}
+		}
+		runtimes[i] = movie.Runtime
+		years[i] = movie.Year
+		ratings[i] = movie.AverageRating
+		genres[i] = movie.Genres
+		for _, genre := range movie.Genres {
+			genreCounts[genre]++
+		}
+	}
+
+	commonGenres := []string{}
+	for _, genre := range movies[0].Genres {
+		if genreCounts[genre] == len(movies) {
+			commonGenres = append(commonGenres, genre)
+		}
+	}
+
+	comparison := envelope{
+		"ids":             requested,
+		"movies":          movies,
+		"runtimes":        runtimes,
+		"years":           years,
+		"average_ratings": ratings,
+		"genres":          genres,
+		"credits":         movieCredits,
+		"common_genres":   commonGenres,
+		"common_people":   commonPeople,
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"comparison": comparison}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The randomMovieHandler for the "GET /v1/movies/random" endpoint returns a random
+// movie matching the optional genre filter and year/runtime/rating filters (for example
+// year[gte]=1990&runtime[lt]=120), for "surprise me" style features. The year_from and
+// year_to parameters are shorthands for year[gte] and year[lte], and min_rating, a whole
+// number from 1 to 10, for rating[gte]; movies without reviews have no rating, so
+// min_rating leaves them out.
+func (app *application) randomMovieHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	qs := r.URL.Query()
+
+	genre := app.readString(qs, "genre", "")
+	filters := app.readFilters(qs, v)
+	if yearFrom := app.readInt(qs, "year_from", 0, v); yearFrom != 0 {
+		filters = append(filters, data.Filter{Field: "year", Op: "gte", Value: yearFrom})
+	}
+	if yearTo := app.readInt(qs, "year_to", 0, v); yearTo != 0 {
+		filters = append(filters, data.Filter{Field: "year", Op: "lte", Value: yearTo})
+	}
+	if minRating := app.readInt(qs, "min_rating", 0, v); minRating != 0 {
+		v.Check(minRating >= 1 && minRating <= 10, "min_rating", "must be between 1 and 10")
+		filters = append(filters, data.Filter{Field: "rating", Op: "gte", Value: minRating})
+	}
+
+	if data.ValidateFilters(v, filters, data.MovieFilterFields...); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	movie, err := app.modelsFor(r).Movies.GetRandom(genre, filters)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.modelsFor(r).MovieTranslations.Load(movie)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	movie = app.localizeMovies(w, r, movie)[0]
+
 	err = app.writeJSON(w, http.StatusOK, envelope{"movie": movie}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
 }
 
-// TO-DO: Erase existing data by id
+// The deleteMovieHandler for the "DELETE /v1/movies/:id" endpoint deletes a movie,
+// with everything which refers to it. Under the archive policy a snapshot of the movie
+// is kept first, see data.DeletionReport. The policy is -movie-delete-policy, unless
+// another one is asked for with ?policy=, and the response reports what was removed.
+// Like an update, the deletion needs the movie's ETag in the If-Match header, so that a
+// movie which was changed since the client last saw it isn't deleted unawares.
 func (app *application) deleteMovieHandler(w http.ResponseWriter, r *http.Request) {
-	id, err := app.readIDParam(r)
+	id, err := app.readMovieIDParam(r)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	v := validator.New()
+	policy := app.readString(r.URL.Query(), "policy", app.config.movies.deletePolicy)
+	if data.ValidateDeletePolicy(v, policy); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	movie, err := app.modelsFor(r).Movies.Get(id)
 	if err != nil {
-		app.notFoundResponse(w, r)
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	if !app.checkPrecondition(w, r, etag(int(movie.Version))) {
 		return
 	}
 
-	err = app.models.Movies.Delete(id)
+	user := app.contextGetUser(r)
+	report, err := app.modelsFor(r).Movies.Delete(id, movie.Version, policy, user.ID)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
 			app.notFoundResponse(w, r)
+		case errors.Is(err, data.ErrEditConflict):
+			app.preconditionFailedResponse(w, r)
 		default:
 			app.serverErrorResponse(w, r, err)
 		}
 		return
 	}
+	app.movieCache.Delete(id)
+	app.requestLogger(r).PrintInfo("movie deleted", map[string]string{
+		"movie_id": fmt.Sprint(id),
+		"policy":   policy,
+		"user_id":  fmt.Sprint(user.ID),
+	})
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"message": "movie successfully deleted"}, nil)
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "movie successfully deleted", "deletion": report}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
 
 }
 
-// TO-DO: Update existing movie
+// The updateMovieHandler for the "PATCH /v1/movies/:id" endpoint updates a movie, and
+// is still served for "PUT /v1/movies/:id" too. Only the fields in the request body are
+// changed, so a client can change just the year without sending the rest of the movie.
+// The body can also be sent as a JSON Merge Patch (RFC 7396), with the content type
+// application/merge-patch+json, in which null removes a field: custom fields can be
+// removed either way, but the movie's own fields can't be, and a null for one of them
+// is reported as a validation error rather than ignored. The If-Match header must hold
+// the ETag of the version the changes were based on, and the update is rejected with a
+// 412 Precondition Failed response if the movie has been changed since, including by a
+// request which got in while this one was being handled.
 func (app *application) updateMovieHandler(w http.ResponseWriter, r *http.Request) {
-	id, err := app.readIDParam(r)
+	id, err := app.readMovieIDParam(r)
 	if err != nil {
-		app.notFoundResponse(w, r)
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
 		return
 	}
 
-	movie, err := app.models.Movies.Get(id)
+	movie, err := app.modelsFor(r).Movies.Get(id)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -122,34 +581,114 @@ func (app *application) updateMovieHandler(w http.ResponseWriter, r *http.Reques
 		}
 		return
 	}
+	if !app.checkPrecondition(w, r, etag(int(movie.Version))) {
+		return
+	}
 
+	// The fields are pointers, so that a field which is missing from the request body
+	// (nil) can be told apart from one set to its zero value.
 	var input struct {
-		Title   string   `json:"title"`
-		Year    int32    `json:"year"`
-		Runtime int32    `json:"runtime"`
+		Title   *string  `json:"title"`
+		Year    *int32   `json:"year"`
+		Runtime *int32   `json:"runtime"`
 		Genres  []string `json:"genres"`
+		// Only the custom fields in the request body are changed, and those set to
+		// null are removed.
+		CustomFields data.CustomFields `json:"custom_fields"`
 	}
 
-	err = app.readJSON(w, r, &input)
+	var body json.RawMessage
+	err = app.readJSON(w, r, &body)
+	if err == nil {
+		err = decodeJSON(body, &input)
+	}
 	if err != nil {
-		app.serverErrorResponse(w, r, err)
+		app.badRequestResponse(w, r, err)
 		return
 	}
 
-	movie.Title = input.Title
-	movie.Year = input.Year
-	movie.Runtime = input.Runtime
-	movie.Genres = input.Genres
+	v := validator.New()
+	if isMergePatch(r) {
+		var fields map[string]json.RawMessage
+		err = decodeJSON(body, &fields)
+		if err != nil || fields == nil {
+			app.badRequestResponse(w, r, errors.New("body must be a JSON object"))
+			return
+		}
+		for _, name := range []string{"title", "year", "runtime", "genres"} {
+			if string(fields[name]) == "null" {
+				v.AddError(name, "can't be removed")
+			}
+		}
+	}
+
+	if input.Title != nil {
+		movie.Title = *input.Title
+	}
+	if input.Year != nil {
+		movie.Year = *input.Year
+	}
+	if input.Runtime != nil {
+		movie.Runtime = *input.Runtime
+	}
+	if input.Genres != nil {
+		movie.Genres = input.Genres
+	}
+	for name, value := range input.CustomFields {
+		if movie.CustomFields == nil {
+			movie.CustomFields = make(data.CustomFields)
+		}
+		if value == nil {
+			delete(movie.CustomFields, name)
+			continue
+		}
+		movie.CustomFields[name] = value
+	}
 
-	err = app.models.Movies.Update(movie)
+	defs, err := app.modelsFor(r).CustomFields.GetAll()
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"movie": movie}, nil)
+	dryRun := app.readDryRun(r, v)
+	data.ValidateCustomFields(v, defs, movie.CustomFields)
+	if data.ValidateMovie(v, movie); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	if dryRun {
+		app.movieDryRunResponse(w, r, movie)
+		return
+	}
+
+	err = app.modelsFor(r).Movies.Update(movie)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.preconditionFailedResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	app.movieCache.Delete(id)
+
+	headers := make(http.Header)
+	headers.Set("ETag", etag(int(movie.Version)))
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"movie": movie}, headers)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
 
 }
+
+// mergePatchType is the media type of JSON Merge Patch (RFC 7396) request bodies.
+const mergePatchType = "application/merge-patch+json"
+
+// The isMergePatch() helper reports whether the request body is a JSON Merge Patch.
+func isMergePatch(r *http.Request) bool {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return mediaType == mergePatchType
+}
@@ -0,0 +1,313 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/shyngys9219/greenlight/internal/data"
+	"github.com/shyngys9219/greenlight/internal/events"
+	"github.com/shyngys9219/greenlight/internal/notify"
+	"github.com/shyngys9219/greenlight/internal/validator"
+)
+
+// Users are told about things, such as a screening they're invited to, by notifications.
+// The code which triggers one names its type and passes the template data to
+// app.notifier, which delivers it over the channels the type is routed to: email
+// through the email queue, and the in-app inbox, whose new notifications also reach
+// the user's event poll. Types are routed to their default channels, which
+// -notification-routes can change, and users can turn the channels of each type off,
+// except for the required ones such as password resets. A new channel is a
+// notify.Channel added in newNotifier(), and routed to with -notification-routes.
+
+// The types of notification.
+const (
+	notifyWelcome              = "welcome"
+	notifyActivation           = "activation"
+	notifyActivationReminder   = "activation_reminder"
+	notifyPasswordReset        = "password_reset"
+	notifyAccountLocked        = "account_locked"
+	notifyCampaignActivation   = "campaign_activation"
+	notifyCampaignReengagement = "campaign_reengagement"
+	notifyNewMovie             = "new_movie"
+	notifyScreeningInvite      = "screening_invite"
+	notifyScreeningReminder    = "screening_reminder"
+)
+
+const (
+	// notificationRetention is how long notifications are kept in the inbox.
+	notificationRetention = 90 * 24 * time.Hour
+	// notificationReadLimit is the most notifications which can be marked read by ID at
+	// once.
+	notificationReadLimit = 100
+)
+
+// notificationTypes are the types of notification, with their templates and default
+// channels. Those about the account itself are required; users can't turn them off.
+// Marketing campaigns stay out of the inbox, and are subject to the user's consent too.
+var notificationTypes = []notify.Type{
+	{Name: notifyWelcome, Template: "user_welcome.tmpl", Required: true, Channels: []string{notify.ChannelEmail}},
+	{Name: notifyActivation, Template: "token_activation.tmpl", Required: true, Channels: []string{notify.ChannelEmail}},
+	{Name: notifyActivationReminder, Template: "activation_reminder.tmpl", Required: true, Channels: []string{notify.ChannelEmail}},
+	{Name: notifyPasswordReset, Template: "token_password_reset.tmpl", Required: true, Channels: []string{notify.ChannelEmail}},
+	{Name: notifyAccountLocked, Template: "account_locked.tmpl", Required: true, Channels: []string{notify.ChannelEmail}},
+	{Name: notifyCampaignActivation, Template: "campaign_activation.tmpl", Channels: []string{notify.ChannelEmail}},
+	{Name: notifyCampaignReengagement, Template: "campaign_reengagement.tmpl", Channels: []string{notify.ChannelEmail}},
+	{Name: notifyNewMovie, Template: "new_movie.tmpl", Channels: []string{notify.ChannelEmail, notify.ChannelInApp}},
+	{Name: notifyScreeningInvite, Template: "screening_invite.tmpl", Channels: []string{notify.ChannelEmail, notify.ChannelInApp}},
+	{Name: notifyScreeningReminder, Template: "screening_reminder.tmpl", Channels: []string{notify.ChannelEmail, notify.ChannelInApp}},
+}
+
+// The newNotifier() function returns the notifier of the application, with the routes
+// of -notification-routes applied. With a nil app it only checks the routes, as
+// validateConfig() does.
+func newNotifier(app *application, routes string) (*notify.Notifier, error) {
+	var renderer notify.Renderer
+	var disabled notify.DisabledFunc
+	if app != nil {
+		renderer = app.mailer
+		disabled = func(userID int64, notificationType string) (map[string]bool, error) {
+			return app.models.Notifications.DisabledChannels(userID, notificationType)
+		}
+	}
+
+	n := notify.New(renderer, disabled)
+	n.AddChannel(emailChannel{app: app})
+	n.AddChannel(inAppChannel{app: app})
+	for _, t := range notificationTypes {
+		err := n.AddType(t)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	parsed, err := notify.ParseRoutes(routes)
+	if err != nil {
+		return nil, err
+	}
+	for typeName, channels := range parsed {
+		err = n.Route(typeName, channels)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return n, nil
+}
+
+// emailChannel delivers notifications by email, through the email queue.
+type emailChannel struct {
+	app *application
+}
+
+func (c emailChannel) Name() string {
+	return notify.ChannelEmail
+}
+
+func (c emailChannel) Deliver(to notify.Recipient, msg *notify.Message) error {
+	if to.Email == "" {
+		return nil
+	}
+	return c.app.enqueueEmail(to.Email, msg)
+}
+
+// inAppChannel delivers notifications to the user's inbox, and publishes them so that
+// clients polling for events see them straight away.
+type inAppChannel struct {
+	app *application
+}
+
+func (c inAppChannel) Name() string {
+	return notify.ChannelInApp
+}
+
+func (c inAppChannel) Deliver(to notify.Recipient, msg *notify.Message) error {
+	if to.UserID == 0 {
+		return nil
+	}
+	notification := &data.Notification{
+		UserID:  to.UserID,
+		Type:    msg.Type,
+		Subject: msg.Subject,
+		Body:    strings.TrimSpace(msg.PlainBody),
+	}
+	err := c.app.models.Notifications.Insert(notification)
+	if err != nil {
+		return err
+	}
+	c.app.publish(events.NotificationCreated, notification)
+	return nil
+}
+
+// The userRecipient() helper returns the recipient of notifications to a user.
+func userRecipient(user *data.User) notify.Recipient {
+	return notify.Recipient{UserID: user.ID, Name: user.Name, Email: user.Email}
+}
+
+// The deleteOldNotifications() job removes the notifications older than
+// notificationRetention from the users' inboxes, read or not.
+func (app *application) deleteOldNotifications() error {
+	return app.models.Notifications.DeleteBefore(time.Now().Add(-notificationRetention))
+}
+
+// The listNotificationsHandler for the "GET /v1/users/me/notifications" endpoint returns
+// a page of the current user's in-app notifications, newest first, and how many are
+// unread. With unread=true only the unread ones are listed.
+func (app *application) listNotificationsHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	qs := r.URL.Query()
+
+	var unreadOnly bool
+	if s := qs.Get("unread"); s != "" {
+		b, err := strconv.ParseBool(s)
+		v.Check(err == nil, "unread", "must be true or false")
+		unreadOnly = b
+	}
+	filters := data.Filters{
+		Page:         app.readInt(qs, "page", 1, v),
+		PageSize:     app.readInt(qs, "page_size", 20, v),
+		Sort:         app.readString(qs, "sort", "-created_at"),
+		SortSafelist: data.NotificationsSortSafelist,
+	}
+	if data.ValidateListFilters(v, filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user := app.contextGetUser(r)
+	notifications, unread, metadata, err := app.modelsFor(r).Notifications.GetAllForUser(user.ID, unreadOnly, filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"notifications": notifications, "unread": unread, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The markNotificationsReadHandler for the "POST /v1/users/me/notifications/read"
+// endpoint marks the current user's notifications with the given IDs as read, or all
+// of them if no IDs are given, and returns how many were marked.
+func (app *application) markNotificationsReadHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		IDs []int64 `json:"ids"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	v.Check(len(input.IDs) <= notificationReadLimit, "ids", "must not contain more than "+strconv.Itoa(notificationReadLimit)+" ids")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	marked, err := app.modelsFor(r).Notifications.MarkRead(app.contextGetUser(r).ID, input.IDs)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"marked_read": marked}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// notificationPreference is a type of notification as the user sees it: the channels
+// it's routed to, and whether each is on for them.
+type notificationPreference struct {
+	Type     string          `json:"type"`
+	Required bool            `json:"required"`
+	Channels map[string]bool `json:"channels"`
+}
+
+// The showNotificationPreferencesHandler for the "GET
+// /v1/users/me/notification-preferences" endpoint returns, for each type of
+// notification, the channels it's delivered over and whether the current user gets it
+// over each of them.
+func (app *application) showNotificationPreferencesHandler(w http.ResponseWriter, r *http.Request) {
+	app.writeNotificationPreferences(w, r, app.contextGetUser(r).ID)
+}
+
+// The updateNotificationPreferencesHandler for the "PATCH
+// /v1/users/me/notification-preferences" endpoint turns channels on or off for types
+// of notification, given as an object such as {"new_movie": {"email": false}}, and
+// returns the preferences. Channels which aren't given are left as they are. Required
+// types can't be changed, and only the channels a type is routed to can be.
+func (app *application) updateNotificationPreferencesHandler(w http.ResponseWriter, r *http.Request) {
+	var input map[string]map[string]bool
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	v.Check(len(input) > 0, "preferences", "must contain at least 1 type of notification")
+	var preferences []data.NotificationPreference
+	for typeName, channels := range input {
+		t, ok := app.notifier.Type(typeName)
+		if !ok {
+			v.AddError(typeName, "must be a type of notification")
+			continue
+		}
+		if t.Required {
+			v.AddError(typeName, "is required, and can't be turned off")
+			continue
+		}
+		for channel, enabled := range channels {
+			if !validator.PermittedValue(channel, t.Channels...) {
+				v.AddError(typeName, "must only contain the channels "+strings.Join(t.Channels, ", "))
+				break
+			}
+			preferences = append(preferences, data.NotificationPreference{Type: typeName, Channel: channel, Enabled: enabled})
+		}
+	}
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user := app.contextGetUser(r)
+	err = app.modelsFor(r).Notifications.SetPreferences(user.ID, preferences)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeNotificationPreferences(w, r, user.ID)
+}
+
+// The writeNotificationPreferences() helper sends a user's notification preferences,
+// for the types which are routed to a channel.
+func (app *application) writeNotificationPreferences(w http.ResponseWriter, r *http.Request, userID int64) {
+	recorded, err := app.modelsFor(r).Notifications.GetPreferences(userID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	preferences := []notificationPreference{}
+	for _, t := range app.notifier.Types() {
+		if len(t.Channels) == 0 {
+			continue
+		}
+		p := notificationPreference{Type: t.Name, Required: t.Required, Channels: make(map[string]bool)}
+		for _, channel := range t.Channels {
+			enabled, ok := recorded[t.Name][channel]
+			p.Channels[channel] = t.Required || !ok || enabled
+		}
+		preferences = append(preferences, p)
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"notification_preferences": preferences}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
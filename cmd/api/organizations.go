@@ -0,0 +1,406 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/shyngys9219/greenlight/internal/data"
+	"github.com/shyngys9219/greenlight/internal/validator"
+)
+
+// The createOrganizationHandler for the "POST /v1/orgs" endpoint creates an
+// organization with the current user as its owner.
+func (app *application) createOrganizationHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Name string `json:"name"`
+		Slug string `json:"slug"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	org := &data.Organization{Name: input.Name, Slug: input.Slug}
+
+	v := validator.New()
+	if data.ValidateOrganization(v, org); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.modelsFor(r).Organizations.Insert(org, app.contextGetUser(r).ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateSlug):
+			v.AddError("slug", "an organization with this slug already exists")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/orgs/%s", org.Slug))
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"organization": org, "role": data.RoleOwner}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The listOrganizationsHandler for the "GET /v1/orgs" endpoint returns the
+// organizations the current user is a member of, with their role in each.
+func (app *application) listOrganizationsHandler(w http.ResponseWriter, r *http.Request) {
+	memberships, err := app.modelsFor(r).Organizations.GetAllForUser(app.contextGetUser(r).ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"organizations": memberships}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The showOrganizationHandler for the "GET /v1/orgs/:org" endpoint returns an
+// organization and the caller's role in it.
+func (app *application) showOrganizationHandler(w http.ResponseWriter, r *http.Request) {
+	access := app.contextGetOrgAccess(r)
+
+	err := app.writeJSON(w, http.StatusOK, envelope{"organization": access.org, "role": access.role}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The listMembersHandler for the "GET /v1/orgs/:org/members" endpoint returns an
+// organization's members.
+func (app *application) listMembersHandler(w http.ResponseWriter, r *http.Request) {
+	access := app.contextGetOrgAccess(r)
+
+	members, err := app.modelsFor(r).Organizations.GetMembers(access.org.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"members": members}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The setMemberHandler for the "PUT /v1/orgs/:org/members" endpoint adds the user with
+// the given email address to an organization, or changes their role if they're
+// already a member.
+func (app *application) setMemberHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Email string `json:"email"`
+		Role  string `json:"role"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	data.ValidateEmail(v, input.Email)
+	data.ValidateRole(v, input.Role, data.RoleOwner, data.RoleEditor, data.RoleViewer)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user, err := app.modelsFor(r).Users.GetByEmail(input.Email)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("email", "must belong to an existing user")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	access := app.contextGetOrgAccess(r)
+	err = app.modelsFor(r).Organizations.SetMember(access.org.ID, user.ID, input.Role)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrLastOwner):
+			v.AddError("role", "the organization must keep at least one owner")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	member := &data.Member{UserID: user.ID, Name: user.Name, Role: input.Role}
+	err = app.writeJSON(w, http.StatusOK, envelope{"member": member}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The removeMemberHandler for the "DELETE /v1/orgs/:org/members/:user_id" endpoint
+// removes a user from an organization. Owners can remove themselves, as long as
+// another owner is left.
+func (app *application) removeMemberHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := app.readNamedIDParam(r, "user_id")
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	access := app.contextGetOrgAccess(r)
+	err = app.modelsFor(r).Organizations.RemoveMember(access.org.ID, userID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		case errors.Is(err, data.ErrLastOwner):
+			app.errorResponse(w, r, http.StatusConflict, "the organization must keep at least one owner")
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "member successfully removed"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The listAPIKeysHandler for the "GET /v1/orgs/:org/keys" endpoint returns an
+// organization's API keys. The keys themselves are only shown when they're created.
+func (app *application) listAPIKeysHandler(w http.ResponseWriter, r *http.Request) {
+	access := app.contextGetOrgAccess(r)
+
+	keys, err := app.modelsFor(r).APIKeys.GetAllForOrganization(access.org.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"keys": keys}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The createAPIKeyHandler for the "POST /v1/orgs/:org/keys" endpoint creates an API
+// key for an organization. Keys can be editors or viewers, but never owners, so a
+// leaked key can't lock the organization's members out.
+func (app *application) createAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Name string `json:"name"`
+		Role string `json:"role"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	access := app.contextGetOrgAccess(r)
+	key := &data.APIKey{
+		OrganizationID: access.org.ID,
+		Name:           input.Name,
+		Role:           input.Role,
+		CreatedBy:      app.contextGetUser(r).ID,
+	}
+
+	v := validator.New()
+	if data.ValidateAPIKey(v, key); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.modelsFor(r).APIKeys.Insert(key)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"key": key}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The deleteAPIKeyHandler for the "DELETE /v1/orgs/:org/keys/:key_id" endpoint revokes
+// one of an organization's API keys.
+func (app *application) deleteAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readNamedIDParam(r, "key_id")
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	access := app.contextGetOrgAccess(r)
+	err = app.modelsFor(r).APIKeys.Delete(access.org.ID, id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "key successfully revoked"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The listWatchlistHandler for the "GET /v1/orgs/:org/watchlist" endpoint returns an
+// organization's shared watchlist. Like the follows listing, it honours
+// If-Modified-Since.
+func (app *application) listWatchlistHandler(w http.ResponseWriter, r *http.Request) {
+	access := app.contextGetOrgAccess(r)
+
+	modified, err := app.modelsFor(r).ListWatermarks.Get(data.WatchlistList(access.org.ID))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if app.notModified(w, r, modified) {
+		return
+	}
+
+	entries, err := app.modelsFor(r).Organizations.GetWatchlist(access.org.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"watchlist": entries}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The addToWatchlistHandler for the "PUT /v1/orgs/:org/watchlist" endpoint adds a movie
+// to an organization's watchlist, or updates its note if it's already there. Movies
+// added with an API key aren't attributed to any user.
+func (app *application) addToWatchlistHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		MovieID string `json:"movie_id"`
+		Note    string `json:"note"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	entry := &data.WatchlistEntry{Note: input.Note}
+	entry.MovieID, err = app.resolveMovieID(r, input.MovieID)
+	if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if user := app.contextGetUser(r); !user.IsAnonymous() {
+		entry.AddedBy = &user.ID
+	}
+
+	if data.ValidateWatchlistEntry(v, entry); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	access := app.contextGetOrgAccess(r)
+	ok, err := app.watchlistHasRoom(r, access.org.ID, entry.MovieID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if !ok {
+		app.planLimitResponse(w, r, "the watchlist is full")
+		return
+	}
+
+	err = app.modelsFor(r).Organizations.AddToWatchlist(access.org.ID, entry)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("movie_id", "must be an existing movie")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"entry": entry}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The removeFromWatchlistHandler for the "DELETE /v1/orgs/:org/watchlist/:movie_id"
+// endpoint takes a movie off an organization's watchlist.
+func (app *application) removeFromWatchlistHandler(w http.ResponseWriter, r *http.Request) {
+	params := httprouter.ParamsFromContext(r.Context())
+	movieID, err := app.resolveMovieID(r, params.ByName("movie_id"))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	access := app.contextGetOrgAccess(r)
+	err = app.modelsFor(r).Organizations.RemoveFromWatchlist(access.org.ID, movieID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "movie successfully removed from the watchlist"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The watchlistHasRoom() helper reports whether the movie can be put on the
+// organization's watchlist without going over its plan's watchlist size. Movies which
+// are already on it can always be updated.
+func (app *application) watchlistHasRoom(r *http.Request, orgID, movieID int64) (bool, error) {
+	ent, err := app.entitlements(r, data.Account{Kind: data.AccountOrganization, ID: orgID})
+	if err != nil {
+		return false, err
+	}
+	if ent.WatchlistSize == 0 {
+		return true, nil
+	}
+	entries, err := app.modelsFor(r).Organizations.GetWatchlist(orgID)
+	if err != nil {
+		return false, err
+	}
+	for _, entry := range entries {
+		if entry.MovieID == movieID {
+			return true, nil
+		}
+	}
+	return len(entries) < ent.WatchlistSize, nil
+}
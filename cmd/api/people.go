@@ -0,0 +1,288 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/shyngys9219/greenlight/internal/data"
+	"github.com/shyngys9219/greenlight/internal/events"
+	"github.com/shyngys9219/greenlight/internal/validator"
+)
+
+// The cast and crew of movies are people, credited on each movie they worked on with
+// a role, and a character for actors. People are managed by users with movies:write,
+// like the movies themselves.
+
+// The createPersonHandler for the "POST /v1/people" endpoint adds a person, who can
+// then be credited on movies.
+func (app *application) createPersonHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Name string `json:"name"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	person := &data.Person{Name: input.Name}
+
+	v := validator.New()
+	if data.ValidatePerson(v, person); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.modelsFor(r).People.Insert(person)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"person": person}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The listPeopleHandler for the "GET /v1/people" endpoint shows a page of the people,
+// in order of name, which can be searched with ?name=.
+func (app *application) listPeopleHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	qs := r.URL.Query()
+	name := app.readString(qs, "name", "")
+	filters := data.Filters{
+		Page:         app.readInt(qs, "page", 1, v),
+		PageSize:     app.readInt(qs, "page_size", 20, v),
+		Sort:         app.readString(qs, "sort", "name"),
+		SortSafelist: data.PeopleSortSafelist,
+	}
+	if data.ValidateListFilters(v, filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	people, metadata, err := app.modelsFor(r).People.GetAll(name, filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"people": people, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The showPersonHandler for the "GET /v1/people/:id" endpoint shows a person.
+func (app *application) showPersonHandler(w http.ResponseWriter, r *http.Request) {
+	person := app.readPerson(w, r)
+	if person == nil {
+		return
+	}
+
+	err := app.writeJSON(w, http.StatusOK, envelope{"person": person}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The deletePersonHandler for the "DELETE /v1/people/:id" endpoint removes a person,
+// along with their credits.
+func (app *application) deletePersonHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.modelsFor(r).People.Delete(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "person successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The listPersonMoviesHandler for the "GET /v1/people/:id/movies" endpoint shows a page
+// of a person's filmography, the most recent movies first unless another sort order is
+// asked for. It can be narrowed down to a role with ?role=.
+func (app *application) listPersonMoviesHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	qs := r.URL.Query()
+	role := app.readString(qs, "role", "")
+	filters := data.Filters{
+		Page:         app.readInt(qs, "page", 1, v),
+		PageSize:     app.readInt(qs, "page_size", 20, v),
+		Sort:         app.readString(qs, "sort", "-year"),
+		SortSafelist: data.FilmographySortSafelist,
+	}
+	v.Check(role == "" || validator.PermittedValue(role, data.CreditRoles...), "role", "must be actor, director, writer, producer or composer")
+	if data.ValidateListFilters(v, filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// Look the person up, so that a person without credits can be told apart from one
+	// who doesn't exist.
+	person := app.readPerson(w, r)
+	if person == nil {
+		return
+	}
+
+	credits, metadata, err := app.modelsFor(r).People.GetFilmography(person.ID, role, filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"person": person, "movies": credits, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The listCreditsHandler for the "GET /v1/movies/:id/credits" endpoint shows the cast
+// and crew of a movie, in billing order.
+func (app *application) listCreditsHandler(w http.ResponseWriter, r *http.Request) {
+	movie := app.readMovieParam(w, r)
+	if movie == nil {
+		return
+	}
+
+	credits, err := app.modelsFor(r).People.GetCreditsForMovie(movie.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"credits": credits}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The addCreditHandler for the "POST /v1/movies/:id/credits" endpoint credits a person
+// on a movie with a role, and the character they play for actors. Without a position
+// the credit goes after the movie's others.
+func (app *application) addCreditHandler(w http.ResponseWriter, r *http.Request) {
+	movie := app.readMovieParam(w, r)
+	if movie == nil {
+		return
+	}
+
+	var input struct {
+		PersonID  int64  `json:"person_id"`
+		Role      string `json:"role"`
+		Character string `json:"character"`
+		Position  int    `json:"position"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	credit := &data.Credit{
+		MovieID:   movie.ID,
+		PersonID:  input.PersonID,
+		Role:      input.Role,
+		Character: input.Character,
+		Position:  input.Position,
+	}
+
+	v := validator.New()
+	if data.ValidateCredit(v, credit); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.modelsFor(r).People.AddCredit(credit)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			// The movie was there a moment ago, so it's most likely the person who isn't.
+			v.AddError("person_id", "must be the id of a person")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrDuplicateCredit):
+			v.AddError("credit", "this person is already credited on the movie with this role")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.publish(events.CreditAdded, credit)
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"credit": credit}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The deleteCreditHandler for the "DELETE /v1/movies/:id/credits/:credit_id" endpoint
+// removes a credit from a movie.
+func (app *application) deleteCreditHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readMovieIDParam(r)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	creditID, err := app.readNamedIDParam(r, "credit_id")
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.modelsFor(r).People.DeleteCredit(id, creditID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "credit successfully removed"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The readPerson() helper returns the person named by the "id" URL parameter, sending
+// the error response and returning nil if there's none.
+func (app *application) readPerson(w http.ResponseWriter, r *http.Request) *data.Person {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil
+	}
+	person, err := app.modelsFor(r).People.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil
+	}
+	return person
+}
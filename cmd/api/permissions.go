@@ -0,0 +1,157 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/shyngys9219/greenlight/internal/data"
+	"github.com/shyngys9219/greenlight/internal/events"
+	"github.com/shyngys9219/greenlight/internal/validator"
+)
+
+// The userPermissions() helper returns the permission codes of a user, from the
+// permission cache when they were loaded less than -cache-permission-ttl ago. Changes
+// made through the admin endpoints invalidate the cached codes straight away; the TTL
+// bounds how long any other change, such as one made directly in the database or on
+// another instance, takes to be picked up.
+func (app *application) userPermissions(r *http.Request, userID int64) (data.Permissions, error) {
+	return app.permissionCache.Get(userID, func() (data.Permissions, error) {
+		return app.modelsFor(r).Permissions.GetAllForUser(userID)
+	})
+}
+
+// The invalidatePermissions() subscriber drops the cached permission codes of the user
+// whose permissions changed. It's subscribed on the bus directly rather than through
+// subscribe(), so the cache has been updated by the time the change is reported back
+// to the admin who made it.
+func (app *application) invalidatePermissions(e events.Event) {
+	if userID, ok := e.Payload.(int64); ok {
+		app.permissionCache.Delete(userID)
+	}
+}
+
+// The listUserPermissionsHandler for the "GET /v1/admin/users/:id/permissions" endpoint
+// shows the permissions a user has been granted, and the ones that exist.
+func (app *application) listUserPermissionsHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	_, err = app.modelsFor(r).Users.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	permissions, err := app.modelsFor(r).Permissions.GetAllForUser(id)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	available, err := app.modelsFor(r).Permissions.GetAll()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	env := envelope{"permissions": permissions, "available": available}
+	err = app.writeJSON(w, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The grantUserPermissionsHandler for the "POST /v1/admin/users/:id/permissions"
+// endpoint grants permissions to a user. Permissions the user already has are left
+// alone.
+func (app *application) grantUserPermissionsHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		Permissions []string `json:"permissions"`
+	}
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	available, err := app.modelsFor(r).Permissions.GetAll()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	v.Check(len(input.Permissions) > 0, "permissions", "must contain at least 1 permission")
+	for _, code := range input.Permissions {
+		v.Check(available.Include(code), "permissions", "must only contain existing permissions")
+	}
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.modelsFor(r).Permissions.AddForUser(id, input.Permissions...)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	app.publish(events.PermissionsChanged, id)
+
+	permissions, err := app.modelsFor(r).Permissions.GetAllForUser(id)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"permissions": permissions}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The revokeUserPermissionHandler for the "DELETE /v1/admin/users/:id/permissions/:code"
+// endpoint revokes a permission from a user.
+func (app *application) revokeUserPermissionHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	code := httprouter.ParamsFromContext(r.Context()).ByName("code")
+	err = app.modelsFor(r).Permissions.RemoveForUser(id, code)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	app.publish(events.PermissionsChanged, id)
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "permission successfully revoked"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
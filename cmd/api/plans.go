@@ -0,0 +1,207 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/shyngys9219/greenlight/internal/data"
+	"github.com/shyngys9219/greenlight/internal/validator"
+)
+
+// The requestAccount() helper returns the account a request is counted against: the
+// organization for requests made with an API key, otherwise the authenticated user.
+// Anonymous requests aren't counted against any account, and ok is false for them.
+func (app *application) requestAccount(r *http.Request) (account data.Account, ok bool) {
+	if key := app.contextGetAPIKey(r); key != nil {
+		return data.Account{Kind: data.AccountOrganization, ID: key.OrganizationID}, true
+	}
+	user := app.contextGetUser(r)
+	if user.IsAnonymous() {
+		return data.Account{}, false
+	}
+	return data.Account{Kind: data.AccountUser, ID: user.ID}, true
+}
+
+// The enforceQuota() middleware counts each authenticated request against the daily
+// request quota of the caller's plan, and rejects requests once it has been used up.
+// The X-Quota-Limit, X-Quota-Remaining and X-Quota-Reset headers tell clients where
+// they stand. Anonymous requests are only subject to the rate limiter, and requests
+// made in read-only mode aren't counted, since counting them is a write.
+func (app *application) enforceQuota(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		account, ok := app.requestAccount(r)
+		if !app.config.plans.quotas || !ok || app.readOnly.active() {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		requests, sub, err := app.modelsFor(r).Plans.CountRequest(account)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		now := time.Now().UTC()
+		limit := sub.Entitlements(now).DailyRequests
+		if limit == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		remaining := limit - requests
+		if remaining < 0 {
+			remaining = 0
+		}
+		reset := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, time.UTC)
+		w.Header().Set("X-Quota-Limit", strconv.Itoa(limit))
+		w.Header().Set("X-Quota-Remaining", strconv.Itoa(remaining))
+		w.Header().Set("X-Quota-Reset", strconv.FormatInt(reset.Unix(), 10))
+
+		if requests > limit {
+			w.Header().Set("Retry-After", strconv.Itoa(int(reset.Sub(now).Seconds())+1))
+			app.quotaExceededResponse(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// The entitlements() helper returns the entitlements currently in effect for an
+// account.
+func (app *application) entitlements(r *http.Request, account data.Account) (data.Entitlements, error) {
+	sub, err := app.modelsFor(r).Plans.Get(account)
+	if err != nil {
+		return data.Entitlements{}, err
+	}
+	return sub.Entitlements(time.Now()), nil
+}
+
+// The writePlan() helper sends an account's subscription along with the plan in
+// effect, its entitlements and the requests made today.
+func (app *application) writePlan(w http.ResponseWriter, r *http.Request, account data.Account) {
+	sub, err := app.modelsFor(r).Plans.Get(account)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	usage, err := app.modelsFor(r).Plans.GetUsage(account)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	now := time.Now()
+	env := envelope{
+		"subscription":   sub,
+		"effective_plan": sub.Effective(now),
+		"entitlements":   sub.Entitlements(now),
+		"requests_today": usage,
+	}
+	err = app.writeJSON(w, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The showCurrentPlanHandler for the "GET /v1/users/me/plan" endpoint returns the
+// current user's plan.
+func (app *application) showCurrentPlanHandler(w http.ResponseWriter, r *http.Request) {
+	app.writePlan(w, r, data.Account{Kind: data.AccountUser, ID: app.contextGetUser(r).ID})
+}
+
+// The showOrganizationPlanHandler for the "GET /v1/orgs/:org/plan" endpoint returns an
+// organization's plan.
+func (app *application) showOrganizationPlanHandler(w http.ResponseWriter, r *http.Request) {
+	app.writePlan(w, r, data.Account{Kind: data.AccountOrganization, ID: app.contextGetOrgAccess(r).org.ID})
+}
+
+// The assignUserPlanHandler for the "PUT /v1/admin/users/:id/plan" endpoint puts a
+// user on a plan.
+func (app *application) assignUserPlanHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	app.assignPlan(w, r, data.Account{Kind: data.AccountUser, ID: id})
+}
+
+// The assignOrganizationPlanHandler for the "PUT /v1/admin/orgs/:org/plan" endpoint
+// puts an organization on a plan.
+func (app *application) assignOrganizationPlanHandler(w http.ResponseWriter, r *http.Request) {
+	params := httprouter.ParamsFromContext(r.Context())
+	org, err := app.modelsFor(r).Organizations.GetBySlug(params.ByName("org"))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	app.assignPlan(w, r, data.Account{Kind: data.AccountOrganization, ID: org.ID})
+}
+
+// The assignPlan() helper reads a plan and an optional expiry from the request body
+// and assigns them to the account. Plans assigned here are recorded as coming from an
+// admin.
+func (app *application) assignPlan(w http.ResponseWriter, r *http.Request, account data.Account) {
+	var input struct {
+		Plan      string     `json:"plan"`
+		ExpiresAt *time.Time `json:"expires_at"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	_, known := data.Plans[input.Plan]
+	v.Check(known, "plan", "must be free or pro")
+	v.Check(input.ExpiresAt == nil || input.ExpiresAt.After(time.Now()), "expires_at", "must be in the future")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	sub := &data.Subscription{Plan: input.Plan, Source: data.PlanSourceAdmin, ExpiresAt: input.ExpiresAt}
+	err = app.modelsFor(r).Plans.Assign(account, sub)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.writePlan(w, r, account)
+}
+
+// The startTrial() helper puts a newly registered user on a pro trial, if trials are
+// enabled.
+func (app *application) startTrial(r *http.Request, user *data.User) error {
+	if app.config.plans.trial <= 0 {
+		return nil
+	}
+	expiresAt := time.Now().Add(app.config.plans.trial)
+	sub := &data.Subscription{Plan: data.PlanPro, Source: data.PlanSourceTrial, ExpiresAt: &expiresAt}
+	err := app.modelsFor(r).Plans.Assign(data.Account{Kind: data.AccountUser, ID: user.ID}, sub)
+	if err != nil {
+		return err
+	}
+	user.Version++
+	return nil
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"io/fs"
+	"net/http"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/shyngys9219/greenlight/internal/data"
+	"github.com/shyngys9219/greenlight/internal/imagepreview"
+	"github.com/shyngys9219/greenlight/internal/storage"
+	"github.com/shyngys9219/greenlight/internal/validator"
+)
+
+// Movie posters are uploaded to the storage (see the storage package), under a key made
+// of the SHA-256 hash of the image, so a new poster gets a new URL and the old one can
+// be cached for good, and movies with the same poster share a single file. The movie
+// records the poster's key and URL, and the preview clients show while it loads (see
+// the imagepreview package).
+
+// posterTypes are the image types accepted as posters, with the extension their files
+// are stored with. The type is sniffed from the content, whatever the client says it is.
+var posterTypes = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/webp": ".webp",
+}
+
+// The uploadPosterHandler for the "POST /v1/movies/:id/poster" endpoint sets a movie's
+// poster, replacing the one it had. The image is sent as the "poster" field of a
+// multipart/form-data body, and must be a JPEG, PNG or WebP image no larger than
+// -poster-max-size.
+func (app *application) uploadPosterHandler(w http.ResponseWriter, r *http.Request) {
+	movie := app.readMovieParam(w, r)
+	if movie == nil {
+		return
+	}
+
+	content, err := app.readPosterUpload(w, r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	contentType := http.DetectContentType(content)
+	ext, ok := posterTypes[contentType]
+	v.Check(int64(len(content)) <= app.config.storage.posterMaxSize, "poster", fmt.Sprintf("must not be more than %d bytes", app.config.storage.posterMaxSize))
+	v.Check(ok, "poster", "must be a JPEG, PNG or WebP image")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	sum := sha256.Sum256(content)
+	key := fmt.Sprintf("posters/%s%s", hex.EncodeToString(sum[:]), ext)
+	url, err := app.storage.Put(r.Context(), key, contentType, content)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	previous, err := app.modelsFor(r).Movies.SetPoster(movie, key, url, app.posterPreview(r, content))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	app.movieCache.Delete(movie.ID)
+	// The old poster is removed once no movie points to it any more. If that fails the
+	// file is merely left behind.
+	if previous != "" && previous != key {
+		app.background(backgroundTask{name: "poster_cleanup", fn: func() error {
+			return app.deletePosterIfUnused(previous)
+		}})
+	}
+
+	headers := make(http.Header)
+	headers.Set("ETag", etag(int(movie.Version)))
+	err = app.writeJSON(w, http.StatusOK, envelope{"movie": movie}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The deletePosterIfUnused() helper deletes a poster from the storage, unless another
+// movie has the same image as its poster. An upload of the same image for a movie
+// which hasn't recorded it yet can still lose its file; uploading it again puts it back.
+func (app *application) deletePosterIfUnused(key string) error {
+	used, err := app.models.Movies.PosterInUse(key)
+	if err != nil || used {
+		return err
+	}
+	return app.storage.Delete(context.Background(), key)
+}
+
+// The posterPreview() helper works out the preview of a poster. A poster which can't be
+// decoded, such as a WebP one, still goes up, just without a preview.
+func (app *application) posterPreview(r *http.Request, content []byte) *data.PosterPreview {
+	preview, err := imagepreview.Extract(content)
+	if err != nil {
+		if !errors.Is(err, image.ErrFormat) {
+			app.logError(r, err)
+		}
+		return nil
+	}
+	return &data.PosterPreview{
+		DominantColor: preview.DominantColor,
+		Palette:       preview.Palette,
+		Blurhash:      preview.Blurhash,
+	}
+}
+
+// The readPosterUpload() helper reads the "poster" field of a multipart/form-data body.
+// It reads one byte more than -poster-max-size at most, so that an image which is too
+// large can be told apart, without holding on to the rest of it.
+func (app *application) readPosterUpload(w http.ResponseWriter, r *http.Request) ([]byte, error) {
+	// Leave room for the rest of the form around the image.
+	r.Body = http.MaxBytesReader(w, r.Body, app.config.storage.posterMaxSize+64<<10)
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return nil, errors.New("body must be multipart/form-data, with the image in the poster field")
+	}
+	for {
+		part, err := reader.NextPart()
+		if errors.Is(err, io.EOF) {
+			return nil, errors.New("body must have the image in the poster field")
+		}
+		if err != nil {
+			var maxBytesError *http.MaxBytesError
+			if errors.As(err, &maxBytesError) {
+				return nil, fmt.Errorf("poster must not be more than %d bytes", app.config.storage.posterMaxSize)
+			}
+			return nil, fmt.Errorf("body contains a badly-formed multipart form: %w", err)
+		}
+		if part.FormName() != "poster" {
+			continue
+		}
+		content, err := io.ReadAll(io.LimitReader(part, app.config.storage.posterMaxSize+1))
+		if err != nil {
+			var maxBytesError *http.MaxBytesError
+			if errors.As(err, &maxBytesError) {
+				return nil, fmt.Errorf("poster must not be more than %d bytes", app.config.storage.posterMaxSize)
+			}
+			return nil, err
+		}
+		if len(content) == 0 {
+			return nil, errors.New("poster must not be empty")
+		}
+		return content, nil
+	}
+}
+
+// The uploadsHandler for the "GET /uploads/*path" endpoint serves the uploaded files
+// kept on local disk, for development. With -storage=s3 the files are served from the
+// bucket, or a CDN in front of it, and there's nothing here.
+func (app *application) uploadsHandler(w http.ResponseWriter, r *http.Request) {
+	local, ok := app.storage.(*storage.Local)
+	if !ok {
+		app.notFoundResponse(w, r)
+		return
+	}
+	key := strings.TrimPrefix(httprouter.ParamsFromContext(r.Context()).ByName("path"), "/")
+	file, info, err := local.Open(key)
+	if err != nil {
+		switch {
+		case errors.Is(err, fs.ErrNotExist):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	defer file.Close()
+
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	http.ServeContent(w, r, info.Name(), info.ModTime(), file)
+}
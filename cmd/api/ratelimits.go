@@ -0,0 +1,248 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/shyngys9219/greenlight/internal/data"
+	"github.com/shyngys9219/greenlight/internal/ratelimit"
+	"github.com/shyngys9219/greenlight/internal/validator"
+)
+
+// rateLimitOverridesInterval is how often the overrides are reloaded from the database,
+// which is how long a change made on another instance takes to apply here.
+const rateLimitOverridesInterval = 30 * time.Second
+
+// rateLimitOverrides holds the overrides of the global rate limit by their key (see
+// data.RateLimitOverrideKey). The limiter consults it on every request, so it's kept in
+// memory rather than queried from the database.
+type rateLimitOverrides map[string]*data.RateLimitOverride
+
+// The loadRateLimitOverrides() job replaces the overrides in memory with the ones in
+// the database, dropping any which have expired.
+func (app *application) loadRateLimitOverrides() error {
+	err := app.models.RateLimitOverrides.DeleteExpired()
+	if err != nil {
+		return err
+	}
+	all, err := app.models.RateLimitOverrides.GetAll()
+	if err != nil {
+		return err
+	}
+	overrides := make(rateLimitOverrides, len(all))
+	for _, o := range all {
+		overrides[o.Key()] = o
+	}
+	app.rateLimitOverrides.Store(&overrides)
+	return nil
+}
+
+// The rateLimitOverrideFor() method returns the override which applies to a request
+// from the given IP address, or nil if there's none. A blocked IP address stays blocked
+// whoever is signed in; otherwise an override for the API key or user making the
+// request comes before one for the IP address. The request must have been through
+// authenticate().
+func (app *application) rateLimitOverrideFor(r *http.Request, ip string) *data.RateLimitOverride {
+	overrides := app.rateLimitOverrides.Load()
+	if overrides == nil {
+		return nil
+	}
+	lookup := func(kind, principal string) *data.RateLimitOverride {
+		o := (*overrides)[data.RateLimitOverrideKey(kind, principal)]
+		if o == nil || (o.ExpiresAt != nil && !o.ExpiresAt.After(time.Now())) {
+			return nil
+		}
+		return o
+	}
+
+	byIP := lookup(data.PrincipalIP, ip)
+	if byIP != nil && byIP.Mode == data.OverrideBlock {
+		return byIP
+	}
+	if key := app.contextGetAPIKey(r); key != nil {
+		if o := lookup(data.PrincipalAPIKey, strconv.FormatInt(key.ID, 10)); o != nil {
+			return o
+		}
+	}
+	if user := app.contextGetUser(r); !user.IsAnonymous() {
+		if o := lookup(data.PrincipalUser, strconv.FormatInt(user.ID, 10)); o != nil {
+			return o
+		}
+	}
+	return byIP
+}
+
+// The newRateLimitStore() method returns the store of the buckets of a rate limit
+// policy, named by name: in memory, or in Redis where every replica shares them.
+func (app *application) newRateLimitStore(name string) ratelimit.Store {
+	if app.config.limiter.store == ratelimit.StoreRedis && app.redis != nil {
+		return ratelimit.NewRedis(app.redis, "greenlight:ratelimit:"+name+":")
+	}
+	return ratelimit.NewMemory(3 * time.Minute)
+}
+
+// The rateLimitStoreError() method logs an error of the rate limiter's store, at most
+// once a minute so that an outage of Redis doesn't flood the logs.
+func (app *application) rateLimitStoreError(err error) {
+	now := time.Now().UnixNano()
+	last := app.rateLimitErrorLogged.Load()
+	if now-last < int64(time.Minute) || !app.rateLimitErrorLogged.CompareAndSwap(last, now) {
+		return
+	}
+	app.logger.PrintError(err, map[string]string{"store": app.config.limiter.store})
+}
+
+// The rateLimitPrincipal() method returns the key under which an authenticated request
+// is rate limited: its API key, or else its user. It returns "" for anonymous requests,
+// which are limited by IP address.
+func (app *application) rateLimitPrincipal(r *http.Request) string {
+	if key := app.contextGetAPIKey(r); key != nil {
+		return data.RateLimitOverrideKey(data.PrincipalAPIKey, strconv.FormatInt(key.ID, 10))
+	}
+	if user := app.contextGetUser(r); !user.IsAnonymous() {
+		return data.RateLimitOverrideKey(data.PrincipalUser, strconv.FormatInt(user.ID, 10))
+	}
+	return ""
+}
+
+// The listRateLimitOverridesHandler for the "GET /v1/admin/rate-limits" endpoint shows
+// the overrides of the global rate limit which haven't expired.
+func (app *application) listRateLimitOverridesHandler(w http.ResponseWriter, r *http.Request) {
+	overrides, err := app.modelsFor(r).RateLimitOverrides.GetAll()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"rate_limit_overrides": overrides}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The saveRateLimitOverrideHandler for the "PUT /v1/admin/rate-limits" endpoint sets
+// the override of the global rate limit for a principal, replacing the one it has
+// already. The override applies on this instance straight away, and on the others
+// within rateLimitOverridesInterval.
+func (app *application) saveRateLimitOverrideHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Kind      string     `json:"kind"`
+		Principal string     `json:"principal"`
+		Mode      string     `json:"mode"`
+		RPS       float64    `json:"rps"`
+		Burst     int        `json:"burst"`
+		Reason    string     `json:"reason"`
+		ExpiresAt *time.Time `json:"expires_at"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	admin := app.contextGetUser(r)
+	override := &data.RateLimitOverride{
+		Kind:      input.Kind,
+		Principal: input.Principal,
+		Mode:      input.Mode,
+		RPS:       input.RPS,
+		Burst:     input.Burst,
+		Reason:    input.Reason,
+		CreatedBy: &admin.ID,
+		ExpiresAt: input.ExpiresAt,
+	}
+	if override.Mode != data.OverrideLimit {
+		override.RPS, override.Burst = 0, 0
+	}
+
+	v := validator.New()
+	if data.ValidateRateLimitOverride(v, override); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	previous, err := app.modelsFor(r).RateLimitOverrides.Save(override)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	app.auditRateLimitOverride(r, "rate limit override set", previous, override)
+	app.reloadRateLimitOverrides()
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"rate_limit_override": override}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The deleteRateLimitOverrideHandler for the "DELETE /v1/admin/rate-limits/:id"
+// endpoint removes an override, so the principal is subject to the global rate limit
+// again.
+func (app *application) deleteRateLimitOverrideHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	override, err := app.modelsFor(r).RateLimitOverrides.Delete(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	app.auditRateLimitOverride(r, "rate limit override deleted", override, nil)
+	app.reloadRateLimitOverrides()
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "rate limit override successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The reloadRateLimitOverrides() helper reloads the overrides after an admin changed
+// them. The change has been saved by then, so if reloading fails it's logged, and the
+// next scheduled reload picks the change up.
+func (app *application) reloadRateLimitOverrides() {
+	err := app.loadRateLimitOverrides()
+	if err != nil {
+		app.logger.PrintError(err, map[string]string{"during": "reloading rate limit overrides"})
+	}
+}
+
+// The auditRateLimitOverride() helper records a change of an override, with who made
+// it and the override before and after, in the application log.
+func (app *application) auditRateLimitOverride(r *http.Request, message string, before, after *data.RateLimitOverride) {
+	describe := func(o *data.RateLimitOverride) string {
+		switch {
+		case o == nil:
+			return "none"
+		case o.Mode == data.OverrideLimit:
+			return fmt.Sprintf("%s %g rps, burst %d", o.Mode, o.RPS, o.Burst)
+		default:
+			return o.Mode
+		}
+	}
+	o := after
+	if o == nil {
+		o = before
+	}
+	properties := map[string]string{
+		"admin_id":  fmt.Sprint(app.contextGetUser(r).ID),
+		"principal": o.Key(),
+		"before":    describe(before),
+		"after":     describe(after),
+		"reason":    o.Reason,
+	}
+	if after != nil && after.ExpiresAt != nil {
+		properties["expires_at"] = after.ExpiresAt.UTC().Format(time.RFC3339)
+	}
+	app.requestLogger(r).PrintInfo(message, properties)
+}
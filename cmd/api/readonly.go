@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// While the primary database can't be written, during a failover for example, the API
+// degrades to read-only mode instead of failing every request. Requests which only
+// read are still served: from the replica given with -db-replica-dsn if there is one,
+// otherwise from the primary, which usually still answers reads, and the movie cache.
+// Requests which would write get a 503 Service Unavailable response with the
+// "read_only" code. Each replica enters the mode when a write is rejected by the
+// database, or when its probe finds the primary read-only or unreachable, and leaves
+// it by itself once the probe finds the primary writable again. The mode is shown by
+// the healthcheck and in the read_only_mode metrics.
+
+// readOnlyProbeInterval is how often each replica checks whether the primary can be
+// written. Clients are asked to retry writes after it.
+const readOnlyProbeInterval = 5 * time.Second
+
+// readOnlyMetrics counts how often the mode was entered, and the writes it rejected.
+var readOnlyMetrics = expvar.NewMap("read_only_mode")
+
+// readOnlyState records whether the API is in read-only mode, since when and why.
+type readOnlyState struct {
+	mu     sync.RWMutex
+	on     bool
+	since  time.Time
+	reason string
+}
+
+func (s *readOnlyState) active() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.on
+}
+
+func (s *readOnlyState) status() (on bool, since time.Time, reason string) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.on, s.since, s.reason
+}
+
+// enter turns the mode on, reporting whether it was off.
+func (s *readOnlyState) enter(reason string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.on {
+		return false
+	}
+	s.on, s.since, s.reason = true, time.Now(), reason
+	return true
+}
+
+// leave turns the mode off, returning how long it was on and whether it was.
+func (s *readOnlyState) leave() (time.Duration, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.on {
+		return 0, false
+	}
+	s.on = false
+	return time.Since(s.since), true
+}
+
+// The publishReadOnlyMetrics() method adds the state of the mode to the
+// read_only_mode metrics. It's called once from main().
+func (app *application) publishReadOnlyMetrics() {
+	readOnlyMetrics.Set("active", expvar.Func(func() any {
+		if app.readOnly.active() {
+			return 1
+		}
+		return 0
+	}))
+}
+
+// The enterReadOnly() method puts the API in read-only mode, if it isn't already.
+func (app *application) enterReadOnly(reason string) {
+	if app.readOnly.enter(reason) {
+		readOnlyMetrics.Add("entered", 1)
+		app.logger.PrintInfo("the database can't be written, entering read-only mode", map[string]string{"reason": reason})
+	}
+}
+
+// The leaveReadOnly() method takes the API out of read-only mode, if it's in it.
+func (app *application) leaveReadOnly() {
+	if d, ok := app.readOnly.leave(); ok {
+		app.logger.PrintInfo("the database can be written again, leaving read-only mode", map[string]string{
+			"duration": d.Round(time.Second).String(),
+		})
+	}
+}
+
+// The probeWritable() job checks whether the primary database can be written, and
+// enters or leaves read-only mode accordingly. It runs on every replica, since each
+// one has its own mode.
+func (app *application) probeWritable() error {
+	ctx, cancel := context.WithTimeout(context.Background(), readOnlyProbeInterval)
+	defer cancel()
+	writable, err := app.models.Health.Writable(ctx)
+	switch {
+	case err != nil:
+		app.enterReadOnly("the primary database can't be reached: " + err.Error())
+	case !writable:
+		app.enterReadOnly("the primary database is read-only")
+	default:
+		app.leaveReadOnly()
+	}
+	return nil
+}
+
+// isReadOnlyError() reports whether err is PostgreSQL refusing a write because the
+// database is read-only.
+func isReadOnlyError(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code.Name() == "read_only_sql_transaction"
+}
+
+// isReadMethod() reports whether requests with the method only read.
+func isReadMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
+
+// The rejectWritesWhenReadOnly() middleware answers the requests which would write
+// with a readOnlyResponse while the API is in read-only mode.
+func (app *application) rejectWritesWhenReadOnly(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !isReadMethod(r.Method) && app.readOnly.active() {
+			readOnlyMetrics.Add("rejected_writes", 1)
+			app.readOnlyResponse(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// The readOnlyResponse() method is sent for a request which would write while the API
+// is in read-only mode, with a machine-readable code so clients can tell it from other
+// outages, and Retry-After set to when the mode may have ended.
+func (app *application) readOnlyResponse(w http.ResponseWriter, r *http.Request) {
+	env := envelope{
+		"error": "the service is temporarily read-only, please try again later",
+		"code":  "read_only",
+	}
+	if id := contextGetRequestID(r.Context()); id != "" {
+		env["request_id"] = id
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(int(readOnlyProbeInterval.Seconds())))
+	err := app.writeJSON(w, http.StatusServiceUnavailable, env, nil)
+	if err != nil {
+		app.logError(r, err)
+		w.WriteHeader(500)
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+
+	"github.com/shyngys9219/greenlight/internal/jsonlog"
+)
+
+// Every request has an ID, which ties together what's known about it: it's sent back
+// in the X-Request-ID header and the metadata of the response, included in error
+// responses for users to quote in support tickets, and added to every log entry written
+// through requestLogger() while the request is served. A request which comes with an
+// X-Request-ID of its own, set by a load balancer or by a client, keeps it, so the
+// same ID can be followed from one system to the next.
+
+// maxRequestIDLength is the length of the longest X-Request-ID a request may bring.
+const maxRequestIDLength = 64
+
+// requestIDFor() returns the ID of a request: the X-Request-ID header it came with if
+// that's a usable ID, or a new one.
+func requestIDFor(r *http.Request) string {
+	id := r.Header.Get("X-Request-ID")
+	if validRequestID(id) {
+		return id
+	}
+	return newRequestID()
+}
+
+// validRequestID() reports whether an incoming request ID can be used. Only short IDs
+// made up of letters, digits and "-", "_", "." and ":" are, so that nothing a client
+// sends ends up unescaped in the logs or the headers of the response.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '-', c == '_', c == '.', c == ':':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// newRequestID returns a random ID for a request.
+func newRequestID() string {
+	b := make([]byte, 8)
+	_, err := rand.Read(b)
+	if err != nil {
+		// The ID is only for correlating logs and reports; a request without one is
+		// still served.
+		return ""
+	}
+	return hex.EncodeToString(b)
+}
+
+// contextGetRequestID() returns the ID of the request whose context ctx is, or "" for
+// a context which doesn't belong to a request.
+func contextGetRequestID(ctx context.Context) string {
+	if meta := contextGetResponseMetadata(ctx); meta != nil {
+		return meta.requestID
+	}
+	return ""
+}
+
+// The requestLogger() method returns the logger for entries about a request, which adds
+// the request's ID to each of them. Outside the middleware chain it's app.logger.
+func (app *application) requestLogger(r *http.Request) *jsonlog.Logger {
+	if meta := contextGetResponseMetadata(r.Context()); meta != nil && meta.logger != nil {
+		return meta.logger
+	}
+	return app.logger
+}
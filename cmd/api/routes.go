@@ -2,31 +2,269 @@ package main
 
 import (
 	"net/http"
+	"strings"
+	"time"
 
 	"github.com/julienschmidt/httprouter"
+	"github.com/shyngys9219/greenlight/internal/data"
 )
 
+// A route describes a single endpoint of the API. The route table below is the one
+// source of truth for what the API exposes: the router, the permission checks and the
+// per-route rate limiting and timeout policies are all derived from it.
+type route struct {
+	method  string
+	path    string
+	handler http.HandlerFunc
+	// permission is the permission code a user needs to call the route. An empty
+	// string means that the route is open to everyone, including anonymous users.
+	permission string
+	// activated routes can only be called by activated users, whatever their
+	// permissions.
+	activated bool
+	// rateLimit is an optional, stricter rate limit applied to this route on top of
+	// the global limiter.
+	rateLimit *rateLimitPolicy
+	// orgRole is the least role (see data.RoleAtLeast) needed in the organization
+	// named by the route's "org" parameter, which members and the organization's API
+	// keys can have.
+	orgRole string
+	// timeout is the maximum time the handler may take to write its response. Zero
+	// means no per-route timeout (the server's WriteTimeout still applies).
+	timeout time.Duration
+}
+
+// routeTable() returns every route served by the application.
+func (app *application) routeTable() []route {
+	return []route{
+		{method: http.MethodGet, path: "/v1/healthcheck", handler: app.healthcheckHandler},
+		{method: http.MethodGet, path: "/v1/healthcheck/live", handler: app.livenessHandler},
+		{method: http.MethodGet, path: "/v1/healthcheck/ready", handler: app.readinessHandler},
+		{method: http.MethodGet, path: "/debug/vars", handler: app.metricsHandler},
+		{method: http.MethodGet, path: "/metrics", handler: app.prometheusHandler},
+		{method: http.MethodGet, path: "/v1/debug/echo", handler: app.echoHandler},
+		{method: http.MethodGet, path: "/v1/status", handler: app.statusHandler},
+		{method: http.MethodGet, path: "/v1/changelog", handler: app.showChangelogHandler},
+		{method: http.MethodGet, path: "/sitemap.xml", handler: app.sitemapIndexHandler},
+		{method: http.MethodGet, path: "/sitemaps/:file", handler: app.sitemapHandler},
+		{method: http.MethodGet, path: "/uploads/*path", handler: app.uploadsHandler},
+
+		// movie routes here
+		{method: http.MethodGet, path: "/v1/movies", handler: app.listMoviesHandler, permission: "movies:read"},
+		{method: http.MethodPost, path: "/v1/movies", handler: app.createMovieHandler, permission: "movies:write"},
+		{method: http.MethodGet, path: "/v1/movies/compare", handler: app.compareMoviesHandler, permission: "movies:read"},
+		{method: http.MethodPost, path: "/v1/movies/import", handler: app.importMoviesHandler, permission: "movies:write", timeout: 2 * time.Minute},
+		{method: http.MethodGet, path: "/v1/movie-fields", handler: app.listCustomFieldsHandler, permission: "movies:read"},
+		{method: http.MethodGet, path: "/v1/genres", handler: app.listGenresHandler, permission: "movies:read"},
+		// Random picks are cheap to ask for and easy to hammer, so they get their own,
+		// stricter limit.
+		{method: http.MethodGet, path: "/v1/movies/random", handler: app.randomMovieHandler, permission: "movies:read", rateLimit: &rateLimitPolicy{rps: 0.5, burst: 5}},
+		{method: http.MethodGet, path: "/v1/movies/:id", handler: app.canary("show-movie", app.showMovieHandler, app.showMovieWithCreditsHandler), permission: "movies:read"},
+		{method: http.MethodPut, path: "/v1/movies/:id", handler: app.updateMovieHandler, permission: "movies:write"},
+		{method: http.MethodPatch, path: "/v1/movies/:id", handler: app.updateMovieHandler, permission: "movies:write"},
+		{method: http.MethodDelete, path: "/v1/movies/:id", handler: app.deleteMovieHandler, permission: "movies:write"},
+		{method: http.MethodGet, path: "/v1/movies/:id/translations", handler: app.listMovieTranslationsHandler, permission: "movies:read"},
+		{method: http.MethodPut, path: "/v1/movies/:id/translations/:locale", handler: app.saveMovieTranslationHandler, permission: "movies:write"},
+		{method: http.MethodDelete, path: "/v1/movies/:id/translations/:locale", handler: app.deleteMovieTranslationHandler, permission: "movies:write"},
+		{method: http.MethodGet, path: "/v1/movies/:id/facts", handler: app.listMovieFactsHandler, permission: "movies:read"},
+		{method: http.MethodPut, path: "/v1/movies/:id/facts/:field", handler: app.setMovieFactHandler, permission: "movies:write"},
+		{method: http.MethodDelete, path: "/v1/movies/:id/facts/:field", handler: app.deleteMovieFactHandler, permission: "movies:write"},
+		{method: http.MethodGet, path: "/v1/movies/:id/reviews", handler: app.listReviewsHandler, permission: "movies:read"},
+		{method: http.MethodPost, path: "/v1/movies/:id/reviews", handler: app.createReviewHandler, activated: true},
+		{method: http.MethodGet, path: "/v1/movies/:id/reviews/:review_id", handler: app.showReviewHandler, permission: "movies:read"},
+		{method: http.MethodDelete, path: "/v1/movies/:id/reviews/:review_id", handler: app.deleteReviewHandler, activated: true},
+		// Votes are rate limited on their own, to slow down scripted voting.
+		{method: http.MethodPut, path: "/v1/movies/:id/reviews/:review_id/vote", handler: app.voteReviewHandler, activated: true, rateLimit: &rateLimitPolicy{rps: 1, burst: 10}},
+		{method: http.MethodDelete, path: "/v1/movies/:id/reviews/:review_id/vote", handler: app.deleteReviewVoteHandler, activated: true},
+		{method: http.MethodGet, path: "/v1/movies/:id/availability", handler: app.listAvailabilityHandler, permission: "movies:read"},
+		{method: http.MethodPut, path: "/v1/movies/:id/availability/:region", handler: app.setAvailabilityHandler, permission: "movies:write"},
+		{method: http.MethodDelete, path: "/v1/movies/:id/availability/:region", handler: app.deleteAvailabilityHandler, permission: "movies:write"},
+		{method: http.MethodPost, path: "/v1/movies/:id/poster", handler: app.uploadPosterHandler, permission: "movies:write", timeout: time.Minute},
+		{method: http.MethodGet, path: "/v1/movies/:id/credits", handler: app.listCreditsHandler, permission: "movies:read"},
+		{method: http.MethodPost, path: "/v1/movies/:id/credits", handler: app.addCreditHandler, permission: "movies:write"},
+		{method: http.MethodDelete, path: "/v1/movies/:id/credits/:credit_id", handler: app.deleteCreditHandler, permission: "movies:write"},
+
+		// cast and crew routes here
+		{method: http.MethodGet, path: "/v1/people", handler: app.listPeopleHandler, permission: "movies:read"},
+		{method: http.MethodPost, path: "/v1/people", handler: app.createPersonHandler, permission: "movies:write"},
+		{method: http.MethodGet, path: "/v1/people/:id", handler: app.showPersonHandler, permission: "movies:read"},
+		{method: http.MethodDelete, path: "/v1/people/:id", handler: app.deletePersonHandler, permission: "movies:write"},
+		{method: http.MethodGet, path: "/v1/people/:id/movies", handler: app.listPersonMoviesHandler, permission: "movies:read"},
+
+		// user routes here
+		{method: http.MethodPost, path: "/v1/users", handler: app.registerUserHandler},
+		{method: http.MethodPut, path: "/v1/users/activated", handler: app.activateUserHandler},
+		{method: http.MethodGet, path: "/v1/users/activate", handler: app.activateUserLinkHandler},
+		{method: http.MethodPut, path: "/v1/users/password", handler: app.resetPasswordHandler},
+		{method: http.MethodGet, path: "/v1/users/me", handler: app.showCurrentUserHandler, activated: true},
+		{method: http.MethodGet, path: "/v1/events/poll", handler: app.pollEventsHandler, activated: true},
+		{method: http.MethodPatch, path: "/v1/users/me", handler: app.updateCurrentUserHandler, activated: true},
+		{method: http.MethodDelete, path: "/v1/users/me", handler: app.deleteCurrentUserHandler, activated: true},
+		{method: http.MethodPut, path: "/v1/users/me/password", handler: app.updatePasswordHandler, activated: true},
+		{method: http.MethodPost, path: "/v1/users/me/2fa/enable", handler: app.enableTwoFactorHandler, activated: true},
+		{method: http.MethodPost, path: "/v1/users/me/2fa/verify", handler: app.verifyTwoFactorHandler, activated: true},
+		{method: http.MethodGet, path: "/v1/users/me/plan", handler: app.showCurrentPlanHandler, activated: true},
+		{method: http.MethodPost, path: "/v1/users/me/merges", handler: app.requestAccountMergeHandler, activated: true},
+		{method: http.MethodPost, path: "/v1/users/me/merges/confirm", handler: app.confirmAccountMergeHandler, activated: true},
+		{method: http.MethodPut, path: "/v1/campaigns/unsubscribed", handler: app.unsubscribeCampaignHandler},
+		{method: http.MethodGet, path: "/v1/users/me/campaigns", handler: app.listCampaignSubscriptionsHandler, activated: true},
+		{method: http.MethodPut, path: "/v1/users/me/campaigns", handler: app.updateCampaignSubscriptionHandler, activated: true},
+		{method: http.MethodGet, path: "/v1/users/me/consents", handler: app.showConsentsHandler, activated: true},
+		{method: http.MethodPatch, path: "/v1/users/me/consents", handler: app.updateConsentsHandler, activated: true},
+		{method: http.MethodGet, path: "/v1/users/me/follows", handler: app.listFollowsHandler, activated: true},
+		{method: http.MethodPost, path: "/v1/users/me/follows", handler: app.createFollowHandler, activated: true},
+		{method: http.MethodDelete, path: "/v1/users/me/follows/:kind/:value", handler: app.deleteFollowHandler, activated: true},
+		{method: http.MethodGet, path: "/v1/users/me/notifications", handler: app.listNotificationsHandler, activated: true},
+		{method: http.MethodPost, path: "/v1/users/me/notifications/read", handler: app.markNotificationsReadHandler, activated: true},
+		{method: http.MethodGet, path: "/v1/users/me/notification-preferences", handler: app.showNotificationPreferencesHandler, activated: true},
+		{method: http.MethodPatch, path: "/v1/users/me/notification-preferences", handler: app.updateNotificationPreferencesHandler, activated: true},
+		{method: http.MethodGet, path: "/v1/users/me/watchlist", handler: app.listUserWatchlistHandler, activated: true},
+		{method: http.MethodPost, path: "/v1/users/me/watchlist", handler: app.addToUserWatchlistHandler, activated: true},
+		{method: http.MethodPatch, path: "/v1/users/me/watchlist/:movie_id", handler: app.updateUserWatchlistHandler, activated: true},
+		{method: http.MethodDelete, path: "/v1/users/me/watchlist/:movie_id", handler: app.removeFromUserWatchlistHandler, activated: true},
+
+		// anonymous visitor routes here
+		{method: http.MethodPost, path: "/v1/visitors", handler: app.createVisitorHandler},
+		{method: http.MethodGet, path: "/v1/visitors/history", handler: app.showVisitorHistoryHandler},
+		{method: http.MethodDelete, path: "/v1/visitors", handler: app.deleteVisitorHandler},
+
+		{method: http.MethodPost, path: "/v1/tokens/authentication", handler: app.createAuthenticationTokenHandler},
+		{method: http.MethodPost, path: "/v1/tokens/refresh", handler: app.refreshTokenHandler},
+		{method: http.MethodPost, path: "/v1/tokens/password-reset", handler: app.createPasswordResetTokenHandler},
+
+		// admin routes here
+		{method: http.MethodGet, path: "/v1/admin/export", handler: app.exportDatasetHandler, permission: "admin:data", timeout: time.Minute},
+		{method: http.MethodPost, path: "/v1/admin/import", handler: app.importDatasetHandler, permission: "admin:data", timeout: 2 * time.Minute},
+		{method: http.MethodGet, path: "/v1/admin/health/history", handler: app.healthHistoryHandler, permission: "admin:health"},
+		{method: http.MethodGet, path: "/v1/admin/campaigns", handler: app.campaignStatsHandler, permission: "admin:users"},
+		{method: http.MethodGet, path: "/v1/admin/metrics/daily", handler: app.dailyMetricsHandler, permission: "admin:data"},
+		{method: http.MethodGet, path: "/v1/admin/search/queries", handler: app.searchQueriesHandler, permission: "admin:data"},
+		{method: http.MethodPost, path: "/v1/admin/status/incidents", handler: app.createStatusIncidentHandler, permission: "admin:health"},
+		{method: http.MethodPatch, path: "/v1/admin/status/incidents/:id", handler: app.updateStatusIncidentHandler, permission: "admin:health"},
+		{method: http.MethodDelete, path: "/v1/admin/status/incidents/:id", handler: app.deleteStatusIncidentHandler, permission: "admin:health"},
+		{method: http.MethodPost, path: "/v1/admin/incident", handler: app.startIncidentHandler, permission: "admin:security"},
+		{method: http.MethodDelete, path: "/v1/admin/incident", handler: app.endIncidentHandler, permission: "admin:security"},
+		{method: http.MethodGet, path: "/v1/admin/rate-limits", handler: app.listRateLimitOverridesHandler, permission: "admin:security"},
+		{method: http.MethodPut, path: "/v1/admin/rate-limits", handler: app.saveRateLimitOverrideHandler, permission: "admin:security"},
+		{method: http.MethodDelete, path: "/v1/admin/rate-limits/:id", handler: app.deleteRateLimitOverrideHandler, permission: "admin:security"},
+		{method: http.MethodPost, path: "/v1/admin/movies/:id/merge", handler: app.mergeMovieHandler, permission: "admin:data"},
+		{method: http.MethodPut, path: "/v1/admin/movie-fields/:name", handler: app.saveCustomFieldHandler, permission: "admin:data"},
+		{method: http.MethodDelete, path: "/v1/admin/movie-fields/:name", handler: app.deleteCustomFieldHandler, permission: "admin:data"},
+		{method: http.MethodPost, path: "/v1/admin/users/import", handler: app.importUsersHandler, permission: "admin:users", timeout: 2 * time.Minute},
+		{method: http.MethodPost, path: "/v1/admin/users/:id/merge", handler: app.mergeUserHandler, permission: "admin:users"},
+		{method: http.MethodGet, path: "/v1/admin/users/:id/permissions", handler: app.listUserPermissionsHandler, permission: "admin:users"},
+		{method: http.MethodPost, path: "/v1/admin/users/:id/permissions", handler: app.grantUserPermissionsHandler, permission: "admin:users"},
+		{method: http.MethodDelete, path: "/v1/admin/users/:id/permissions/:code", handler: app.revokeUserPermissionHandler, permission: "admin:users"},
+		{method: http.MethodPut, path: "/v1/admin/users/:id/plan", handler: app.assignUserPlanHandler, permission: "admin:billing"},
+		{method: http.MethodPut, path: "/v1/admin/users/:id/restriction", handler: app.setAccountRestrictionHandler, permission: "admin:users"},
+		{method: http.MethodDelete, path: "/v1/admin/users/:id/restriction", handler: app.deleteAccountRestrictionHandler, permission: "admin:users"},
+		{method: http.MethodGet, path: "/v1/admin/abuse/restrictions", handler: app.listAccountRestrictionsHandler, permission: "admin:users"},
+		{method: http.MethodPut, path: "/v1/admin/orgs/:org/plan", handler: app.assignOrganizationPlanHandler, permission: "admin:billing"},
+
+		// SCIM provisioning routes for identity providers
+		{method: http.MethodGet, path: "/scim/v2/Users", handler: app.listSCIMUsersHandler, permission: "scim:users"},
+		{method: http.MethodPost, path: "/scim/v2/Users", handler: app.createSCIMUserHandler, permission: "scim:users"},
+		{method: http.MethodGet, path: "/scim/v2/Users/:id", handler: app.showSCIMUserHandler, permission: "scim:users"},
+		{method: http.MethodPut, path: "/scim/v2/Users/:id", handler: app.replaceSCIMUserHandler, permission: "scim:users"},
+		{method: http.MethodPatch, path: "/scim/v2/Users/:id", handler: app.patchSCIMUserHandler, permission: "scim:users"},
+		{method: http.MethodDelete, path: "/scim/v2/Users/:id", handler: app.deleteSCIMUserHandler, permission: "scim:users"},
+
+		// billing routes here
+		{method: http.MethodPost, path: "/v1/billing/checkout", handler: app.createCheckoutHandler, activated: true},
+		{method: http.MethodPost, path: "/v1/billing/webhook", handler: app.billingWebhookHandler},
+
+		// organization routes here
+		{method: http.MethodPost, path: "/v1/orgs", handler: app.createOrganizationHandler, activated: true},
+		{method: http.MethodGet, path: "/v1/orgs", handler: app.listOrganizationsHandler, activated: true},
+		{method: http.MethodGet, path: "/v1/orgs/:org", handler: app.showOrganizationHandler, orgRole: data.RoleViewer},
+		{method: http.MethodGet, path: "/v1/orgs/:org/plan", handler: app.showOrganizationPlanHandler, orgRole: data.RoleViewer},
+		{method: http.MethodGet, path: "/v1/orgs/:org/members", handler: app.listMembersHandler, orgRole: data.RoleViewer},
+		{method: http.MethodPut, path: "/v1/orgs/:org/members", handler: app.setMemberHandler, orgRole: data.RoleOwner},
+		{method: http.MethodDelete, path: "/v1/orgs/:org/members/:user_id", handler: app.removeMemberHandler, orgRole: data.RoleOwner},
+		{method: http.MethodGet, path: "/v1/orgs/:org/keys", handler: app.listAPIKeysHandler, orgRole: data.RoleOwner},
+		{method: http.MethodPost, path: "/v1/orgs/:org/keys", handler: app.createAPIKeyHandler, orgRole: data.RoleOwner},
+		{method: http.MethodDelete, path: "/v1/orgs/:org/keys/:key_id", handler: app.deleteAPIKeyHandler, orgRole: data.RoleOwner},
+		{method: http.MethodGet, path: "/v1/orgs/:org/watchlist", handler: app.listWatchlistHandler, orgRole: data.RoleViewer},
+		{method: http.MethodPut, path: "/v1/orgs/:org/watchlist", handler: app.addToWatchlistHandler, orgRole: data.RoleEditor},
+		{method: http.MethodDelete, path: "/v1/orgs/:org/watchlist/:movie_id", handler: app.removeFromWatchlistHandler, orgRole: data.RoleEditor},
+		{method: http.MethodGet, path: "/v1/orgs/:org/webhooks", handler: app.listWebhooksHandler, orgRole: data.RoleViewer},
+		{method: http.MethodPost, path: "/v1/orgs/:org/webhooks", handler: app.createWebhookHandler, orgRole: data.RoleEditor},
+		{method: http.MethodDelete, path: "/v1/orgs/:org/webhooks/:webhook_id", handler: app.deleteWebhookHandler, orgRole: data.RoleEditor},
+		{method: http.MethodGet, path: "/v1/orgs/:org/webhooks/:webhook_id/deliveries", handler: app.listWebhookDeliveriesHandler, orgRole: data.RoleViewer},
+
+		// screening (watch party) routes here
+		{method: http.MethodPost, path: "/v1/screenings", handler: app.createScreeningHandler, activated: true},
+		{method: http.MethodGet, path: "/v1/screenings/:id", handler: app.showScreeningHandler, activated: true},
+		{method: http.MethodPost, path: "/v1/screenings/:id/invites", handler: app.inviteScreeningHandler, activated: true},
+		{method: http.MethodPut, path: "/v1/screenings/:id/rsvp", handler: app.rsvpScreeningHandler, activated: true},
+	}
+}
+
+// The handler() method wraps the route's handler with the middleware required by its
+// policies. The order matters: the timeout is outermost so that it also covers the
+// time spent in the permission checks, and the format negotiation is innermost so that
+// its Preference-Applied header is seen by the handler's writeJSON() calls.
+func (app *application) handler(rt route) http.Handler {
+	var h http.Handler = app.negotiateFormats(rt.handler)
+
+	if rt.orgRole != "" {
+		h = app.requireOrgRole(rt.orgRole, h)
+	} else if rt.permission != "" {
+		h = app.requirePermission(rt.permission, h)
+	} else if rt.activated {
+		h = app.requireActivatedUser(h)
+	}
+	if rt.rateLimit != nil {
+		h = app.rateLimitWith(rt.method+" "+rt.path, *rt.rateLimit, h)
+	}
+	if rt.timeout > 0 {
+		h = app.timeout(rt.timeout, app.carryResponseMetadata(h))
+	}
+	if app.config.otel.endpoint != "" {
+		h = traceRoute(rt, h)
+	}
+	return h
+}
+
 func (app *application) routes() http.Handler {
-	// Initialize a new httprouter router instance.
+	// httprouter doesn't allow a fixed path segment in the same position as a named
+	// parameter (for example /v1/movies/compare next to /v1/movies/:id), so routes
+	// without any parameters are registered on their own router, which gets the first
+	// look at every request whose path it knows about.
+	static := httprouter.New()
 	router := httprouter.New()
-	router.NotFound = http.HandlerFunc(app.notFoundResponse)
-	router.MethodNotAllowed = http.HandlerFunc(app.methodNotAllowedResponse)
+	staticPaths := make(map[string]bool)
 
-	router.HandlerFunc(http.MethodGet, "/v1/healthcheck", app.healthcheckHandler)
+	for _, r := range []*httprouter.Router{static, router} {
+		r.NotFound = http.HandlerFunc(app.notFoundResponse)
+		r.MethodNotAllowed = http.HandlerFunc(app.methodNotAllowedResponse)
+	}
 
-	// movie routes here
-	router.HandlerFunc(http.MethodPost, "/v1/movies", app.createMovieHandler)
-	router.HandlerFunc(http.MethodGet, "/v1/movies/:id", app.showMovieHandler)
-	router.HandlerFunc(http.MethodPut, "/v1/movies/:id", app.updateMovieHandler)
-	router.HandlerFunc(http.MethodDelete, "/v1/movies/:id", app.deleteMovieHandler)
+	routes := app.routeTable()
+	if err := checkChangelog(routes); err != nil {
+		panic(err)
+	}
 
-	// user routes here
-	router.HandlerFunc(http.MethodPost, "/v1/users", app.registerUserHandler)
-	router.HandlerFunc(http.MethodPut, "/v1/users/activated", app.activateUserHandler)
+	for _, rt := range routes {
+		if strings.ContainsAny(rt.path, ":*") {
+			router.Handler(rt.method, rt.path, app.handler(rt))
+			continue
+		}
+		static.Handler(rt.method, rt.path, app.handler(rt))
+		staticPaths[rt.path] = true
+	}
 
-	router.HandlerFunc(http.MethodPost, "/v1/tokens/authentication", app.createAuthenticationTokenHandler)
+	mux := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if staticPaths[r.URL.Path] {
+			static.ServeHTTP(w, r)
+			return
+		}
+		router.ServeHTTP(w, r)
+	})
 
 	// Return the httprouter instance.
 	// wrapping the router with rateLimiter() middleware to limit requests' frequency
-	return app.recoverPanic(app.rateLimit(app.authenticate(router)))
+	// Requests are authenticated before they're rate limited, so that the limiter can
+	// apply the overrides for API keys and users.
+	return app.traceRequests(app.metrics(app.trackResponseMetadata(app.logRequests(app.recoverPanic(app.enableCORS(app.rejectWritesWhenReadOnly(app.collectDBStats(app.authenticate(app.resolveLocale(app.rateLimit(app.enforceQuota(mux))))))))))))
 }
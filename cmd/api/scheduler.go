@@ -0,0 +1,121 @@
+package main
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/shyngys9219/greenlight/internal/data"
+)
+
+// The schedule() helper runs a job every interval for the lifetime of the application.
+// Each run goes through background(), so it gets the same panic recovery, retries and
+// metrics as any other background task, and the graceful shutdown waits for a run
+// which is in progress. If a run is still going when the next one is due, the next one
+// is skipped rather than piling up. Jobs stop being run once the shutdown has begun.
+func (app *application) schedule(name string, interval time.Duration, fn func() error) {
+	var running atomic.Bool
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+			case <-app.stopJobs:
+				return
+			}
+			if !running.CompareAndSwap(false, true) {
+				app.logger.PrintDebug("job run skipped, the previous one is still running", map[string]string{"job": name})
+				continue
+			}
+			app.background(backgroundTask{
+				name: name,
+				fn: func() error {
+					defer running.Store(false)
+					return fn()
+				},
+			})
+		}
+	}()
+}
+
+// leaderCheckInterval is how often a replica tries to become the leader which runs the
+// singleton jobs, or checks that it still is. A replica which stops takes up to this
+// long to be replaced, if it didn't release the lock on its way out.
+const leaderCheckInterval = 15 * time.Second
+
+// The scheduleSingleton() helper is like schedule(), for jobs which must only run on one
+// replica at a time, such as those which send emails. Each replica schedules the job,
+// but only the one holding app.jobLeader runs it; on the others the runs are skipped.
+func (app *application) scheduleSingleton(name string, interval time.Duration, fn func() error) {
+	app.schedule(name, interval, func() error {
+		if !app.jobLeader.Held() {
+			return nil
+		}
+		return fn()
+	})
+}
+
+// The startJobs() method registers every scheduled job of the application. It's called
+// once, by the jobs component, which is started before the server. Jobs which only keep
+// this replica's state up to date run everywhere; the others are singletons.
+func (app *application) startJobs() {
+	app.stopJobs = make(chan struct{})
+	app.jobLeader.Start()
+	app.scheduleSingleton("screening_reminders", time.Minute, app.sendScreeningReminders)
+	app.schedule("health_probe", app.config.health.interval, app.recordHealth)
+	app.schedule("read_only_probe", readOnlyProbeInterval, app.probeWritable)
+	if app.config.activation.reminderBefore > 0 {
+		app.scheduleSingleton("activation_reminders", 15*time.Minute, app.sendActivationReminders)
+	}
+	if app.config.activation.importBatch > 0 {
+		app.scheduleSingleton("activation_import_emails", time.Minute, app.sendQueuedActivations)
+	}
+	if app.config.activation.cleanup {
+		app.scheduleSingleton("activation_cleanup", time.Hour, app.deleteLapsedAccounts)
+	}
+	app.scheduleSingleton("campaigns", 15*time.Minute, app.runCampaigns)
+	app.startEmailWorkers()
+	app.scheduleSingleton("email_queue_cleanup", time.Hour, app.deleteOldEmails)
+	app.scheduleSingleton("notifications_cleanup", time.Hour, app.deleteOldNotifications)
+	app.startWebhookWorkers()
+	app.scheduleSingleton("webhook_deliveries_cleanup", time.Hour, app.deleteOldWebhookDeliveries)
+	app.schedule("business_metrics_rollup", businessRollupInterval, app.rollupBusinessMetrics)
+	app.schedule("business_metrics_gauges", businessGaugesInterval, app.refreshBusinessGauges)
+	if app.enricher != nil {
+		app.scheduleSingleton("movie_enrichment", time.Hour, app.enrichMovies)
+	}
+	app.background(backgroundTask{name: "rate_limit_overrides", fn: app.loadRateLimitOverrides})
+	app.schedule("rate_limit_overrides", rateLimitOverridesInterval, app.loadRateLimitOverrides)
+	if app.config.sitemap.baseURL != "" {
+		// Generate the sitemaps straight away too, rather than serving none until the
+		// first scheduled run.
+		app.background(backgroundTask{name: "sitemaps", fn: app.generateSitemaps})
+		app.schedule("sitemaps", app.config.sitemap.interval, app.generateSitemaps)
+	}
+	app.scheduleSingleton("plan_usage_cleanup", time.Hour, func() error {
+		_, err := app.models.Plans.DeleteUsageBefore(time.Now().AddDate(0, 0, -7))
+		return err
+	})
+	app.scheduleSingleton("search_analytics_cleanup", time.Hour, func() error {
+		return app.models.Searches.DeleteBefore(time.Now().Add(-app.config.searchAnalytics.retention))
+	})
+	// Used refresh tokens are kept until they expire, to detect reuse; after that they
+	// can go.
+	app.scheduleSingleton("refresh_token_cleanup", time.Hour, func() error {
+		return app.models.Tokens.DeleteExpired(data.ScopeRefresh)
+	})
+	app.scheduleSingleton("unsubscribe_token_cleanup", time.Hour, func() error {
+		return app.models.Tokens.DeleteExpired(data.ScopeUnsubscribe)
+	})
+	app.scheduleSingleton("confirmation_cleanup", time.Hour, app.models.Confirmations.DeleteExpired)
+	app.scheduleSingleton("write_events_cleanup", time.Hour, app.deleteOldWriteEvents)
+	app.scheduleSingleton("visitor_views_cleanup", time.Hour, func() error {
+		_, err := app.models.Visitors.DeleteViewsBefore(time.Now().Add(-app.config.visitors.retention))
+		return err
+	})
+	app.schedule("cache_prune", 5*time.Minute, func() error {
+		app.movieCache.Prune()
+		app.permissionCache.Prune()
+		return nil
+	})
+}
@@ -0,0 +1,663 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/shyngys9219/greenlight/internal/data"
+	"github.com/shyngys9219/greenlight/internal/validator"
+)
+
+// The SCIM 2.0 (RFC 7643 and RFC 7644) endpoints let an enterprise identity provider
+// create, update and deactivate users automatically. SCIM has its own resource format
+// and error format, so these handlers don't use the usual envelopes.
+const (
+	scimUserSchema  = "urn:ietf:params:scim:schemas:core:2.0:User"
+	scimListSchema  = "urn:ietf:params:scim:api:messages:2.0:ListResponse"
+	scimPatchSchema = "urn:ietf:params:scim:api:messages:2.0:PatchOp"
+	scimErrorSchema = "urn:ietf:params:scim:api:messages:2.0:Error"
+)
+
+// Default and maximum page size of SCIM list responses.
+const (
+	scimDefaultCount = 100
+	scimMaxCount     = 200
+)
+
+type scimName struct {
+	Formatted  string `json:"formatted,omitempty"`
+	GivenName  string `json:"givenName,omitempty"`
+	FamilyName string `json:"familyName,omitempty"`
+}
+
+type scimEmail struct {
+	Value   string `json:"value"`
+	Type    string `json:"type,omitempty"`
+	Primary bool   `json:"primary,omitempty"`
+}
+
+type scimMeta struct {
+	ResourceType string    `json:"resourceType"`
+	Created      time.Time `json:"created"`
+	Location     string    `json:"location"`
+	Version      string    `json:"version"`
+}
+
+// A scimUser is the SCIM representation of a user. The user's public ID is its SCIM
+// id, and the email address its userName.
+type scimUser struct {
+	Schemas     []string    `json:"schemas"`
+	ID          string      `json:"id,omitempty"`
+	ExternalID  string      `json:"externalId,omitempty"`
+	UserName    string      `json:"userName"`
+	Name        *scimName   `json:"name,omitempty"`
+	DisplayName string      `json:"displayName,omitempty"`
+	Emails      []scimEmail `json:"emails,omitempty"`
+	Active      *bool       `json:"active,omitempty"`
+	Password    string      `json:"password,omitempty"`
+	Meta        *scimMeta   `json:"meta,omitempty"`
+}
+
+func newSCIMUser(user *data.User) *scimUser {
+	active := user.Active
+	return &scimUser{
+		Schemas:     []string{scimUserSchema},
+		ID:          user.PublicID,
+		ExternalID:  user.ExternalID,
+		UserName:    user.Email,
+		Name:        &scimName{Formatted: user.Name},
+		DisplayName: user.Name,
+		Emails:      []scimEmail{{Value: user.Email, Type: "work", Primary: true}},
+		Active:      &active,
+		Meta: &scimMeta{
+			ResourceType: "User",
+			Created:      user.CreatedAt,
+			Location:     "/scim/v2/Users/" + user.PublicID,
+			Version:      etag(user.Version),
+		},
+	}
+}
+
+// displayName works out the user's name from whichever SCIM attributes were sent.
+func (u *scimUser) displayName() string {
+	switch {
+	case u.DisplayName != "":
+		return u.DisplayName
+	case u.Name != nil && u.Name.Formatted != "":
+		return u.Name.Formatted
+	case u.Name != nil && (u.Name.GivenName != "" || u.Name.FamilyName != ""):
+		return strings.TrimSpace(u.Name.GivenName + " " + u.Name.FamilyName)
+	default:
+		return u.UserName
+	}
+}
+
+// The writeSCIM() helper sends a SCIM response, which has its own media type.
+func (app *application) writeSCIM(w http.ResponseWriter, status int, v any, headers http.Header) error {
+	js, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	for key, value := range headers {
+		w.Header()[key] = value
+	}
+	w.Header().Set("Content-Type", "application/scim+json")
+	w.WriteHeader(status)
+	w.Write(append(js, '\n'))
+	return nil
+}
+
+// The scimErrorResponse() method sends an error in the SCIM format. scimType is one of
+// the error types defined by RFC 7644, or empty.
+func (app *application) scimErrorResponse(w http.ResponseWriter, r *http.Request, status int, scimType, detail string) {
+	body := map[string]any{
+		"schemas": []string{scimErrorSchema},
+		"status":  strconv.Itoa(status),
+		"detail":  detail,
+	}
+	if scimType != "" {
+		body["scimType"] = scimType
+	}
+	err := app.writeSCIM(w, status, body, nil)
+	if err != nil {
+		app.logError(r, err)
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+func (app *application) scimServerErrorResponse(w http.ResponseWriter, r *http.Request, err error) {
+	app.logError(r, err)
+	app.scimErrorResponse(w, r, http.StatusInternalServerError, "", "the server encountered a problem and could not process your request")
+}
+
+func (app *application) scimValidationResponse(w http.ResponseWriter, r *http.Request, errors map[string]string) {
+	details := make([]string, 0, len(errors))
+	for field, message := range errors {
+		details = append(details, field+" "+message)
+	}
+	app.scimErrorResponse(w, r, http.StatusBadRequest, "invalidValue", strings.Join(details, "; "))
+}
+
+// The readSCIMUser() helper loads the user named by the "id" URL parameter, sending a
+// SCIM error response and returning false if that isn't possible.
+func (app *application) readSCIMUser(w http.ResponseWriter, r *http.Request) (*data.User, bool) {
+	id := httprouter.ParamsFromContext(r.Context()).ByName("id")
+	user, err := app.modelsFor(r).Users.GetByPublicID(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.scimErrorResponse(w, r, http.StatusNotFound, "", fmt.Sprintf("user %s not found", id))
+		default:
+			app.scimServerErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+	// SCIM clients may send the version they last saw in If-Match.
+	if r.Header.Get("If-Match") != "" && !app.ifMatch(r, etag(user.Version)) {
+		app.scimErrorResponse(w, r, http.StatusPreconditionFailed, "", "the user has been modified since it was last fetched")
+		return nil, false
+	}
+	return user, true
+}
+
+// The readSCIMManagedUser() helper is readSCIMUser() for the endpoints which change or
+// delete a user. Only the users the identity provider provisioned, which have its
+// externalId, are its to change: the others, such as the administrators, signed up
+// themselves, and a SCIM token mustn't be a way to take their accounts over.
+func (app *application) readSCIMManagedUser(w http.ResponseWriter, r *http.Request) (*data.User, bool) {
+	user, ok := app.readSCIMUser(w, r)
+	if !ok {
+		return nil, false
+	}
+	if user.ExternalID == "" {
+		app.scimErrorResponse(w, r, http.StatusForbidden, "", "the user wasn't provisioned by the identity provider")
+		return nil, false
+	}
+	return user, true
+}
+
+// The listSCIMUsersHandler for the "GET /scim/v2/Users" endpoint lists users, filtered
+// by the optional filter query string parameter and paged with startIndex and count.
+func (app *application) listSCIMUsersHandler(w http.ResponseWriter, r *http.Request) {
+	qs := r.URL.Query()
+
+	conditions, err := parseSCIMFilter(qs.Get("filter"))
+	if err != nil {
+		app.scimErrorResponse(w, r, http.StatusBadRequest, "invalidFilter", err.Error())
+		return
+	}
+
+	v := validator.New()
+	startIndex := app.readInt(qs, "startIndex", 1, v)
+	count := app.readInt(qs, "count", scimDefaultCount, v)
+	data.ValidateUserConditions(v, conditions)
+	if !v.Valid() {
+		app.scimValidationResponse(w, r, v.Errors)
+		return
+	}
+	// RFC 7644 says out of range values are to be treated as the nearest valid value
+	// rather than rejected.
+	if startIndex < 1 {
+		startIndex = 1
+	}
+	if count < 0 {
+		count = 0
+	}
+	if count > scimMaxCount {
+		count = scimMaxCount
+	}
+
+	// A count of zero asks for the total only, but the total comes with the rows, so
+	// one row is fetched and thrown away.
+	limit := count
+	if limit == 0 {
+		limit = 1
+	}
+	users, total, err := app.modelsFor(r).Users.Search(conditions, startIndex-1, limit)
+	if err != nil {
+		app.scimServerErrorResponse(w, r, err)
+		return
+	}
+	if len(users) > count {
+		users = users[:count]
+	}
+
+	resources := make([]*scimUser, len(users))
+	for i, user := range users {
+		resources[i] = newSCIMUser(user)
+	}
+
+	body := map[string]any{
+		"schemas":      []string{scimListSchema},
+		"totalResults": total,
+		"startIndex":   startIndex,
+		"itemsPerPage": len(resources),
+		"Resources":    resources,
+	}
+	err = app.writeSCIM(w, http.StatusOK, body, nil)
+	if err != nil {
+		app.scimServerErrorResponse(w, r, err)
+	}
+}
+
+// The showSCIMUserHandler for the "GET /scim/v2/Users/:id" endpoint returns a user.
+func (app *application) showSCIMUserHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.readSCIMUser(w, r)
+	if !ok {
+		return
+	}
+	app.sendSCIMUser(w, r, http.StatusOK, user)
+}
+
+// The createSCIMUserHandler for the "POST /scim/v2/Users" endpoint provisions a user.
+// The identity provider has already verified the email address, so the user is
+// activated straight away. Without a password the user gets an unguessable one, and
+// can only log in after resetting it. The externalId is required, since it's what marks
+// the user as the identity provider's to change later.
+func (app *application) createSCIMUserHandler(w http.ResponseWriter, r *http.Request) {
+	var input scimUser
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.scimErrorResponse(w, r, http.StatusBadRequest, "invalidSyntax", err.Error())
+		return
+	}
+
+	user := &data.User{
+		Name:       input.displayName(),
+		Email:      input.UserName,
+		Activated:  true,
+		Active:     input.Active == nil || *input.Active,
+		ExternalID: input.ExternalID,
+	}
+
+	password := input.Password
+	if password == "" {
+		password, err = randomPassword()
+		if err != nil {
+			app.scimServerErrorResponse(w, r, err)
+			return
+		}
+	}
+	err = user.Password.Set(password)
+	if err != nil {
+		app.scimServerErrorResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	v.Check(user.ExternalID != "", "externalId", "must be provided")
+	if data.ValidateUser(v, user); !v.Valid() {
+		app.scimValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.modelsFor(r).Users.Insert(user)
+	if err != nil {
+		app.handleSCIMWriteError(w, r, err)
+		return
+	}
+
+	err = app.modelsFor(r).Permissions.AddForUser(user.ID, data.DefaultPermissions...)
+	if err != nil {
+		app.scimServerErrorResponse(w, r, err)
+		return
+	}
+
+	app.sendSCIMUser(w, r, http.StatusCreated, user)
+}
+
+// The replaceSCIMUserHandler for the "PUT /scim/v2/Users/:id" endpoint replaces the
+// user's attributes with the ones sent.
+func (app *application) replaceSCIMUserHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.readSCIMManagedUser(w, r)
+	if !ok {
+		return
+	}
+
+	var input scimUser
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.scimErrorResponse(w, r, http.StatusBadRequest, "invalidSyntax", err.Error())
+		return
+	}
+
+	email := user.Email
+	user.Name = input.displayName()
+	user.Email = input.UserName
+	user.ExternalID = input.ExternalID
+	if input.Active != nil {
+		user.Active = *input.Active
+	}
+	if input.Password != "" {
+		err = user.Password.Set(input.Password)
+		if err != nil {
+			app.scimServerErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	app.saveSCIMUser(w, r, user, email)
+}
+
+// A scimPatchOp is a single operation of a SCIM PATCH request.
+type scimPatchOp struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	Value json.RawMessage `json:"value"`
+}
+
+// The patchSCIMUserHandler for the "PATCH /scim/v2/Users/:id" endpoint applies a list
+// of add, replace and remove operations to the user. This is how most identity
+// providers deactivate users: {"op": "replace", "path": "active", "value": false}.
+func (app *application) patchSCIMUserHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.readSCIMManagedUser(w, r)
+	if !ok {
+		return
+	}
+	email := user.Email
+
+	var input struct {
+		Schemas    []string      `json:"schemas"`
+		Operations []scimPatchOp `json:"Operations"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.scimErrorResponse(w, r, http.StatusBadRequest, "invalidSyntax", err.Error())
+		return
+	}
+	if !validator.PermittedValue(scimPatchSchema, input.Schemas...) || len(input.Operations) == 0 {
+		app.scimErrorResponse(w, r, http.StatusBadRequest, "invalidSyntax", "request must be a PatchOp with at least one operation")
+		return
+	}
+
+	for _, op := range input.Operations {
+		err := applySCIMPatch(user, op)
+		if err != nil {
+			app.scimErrorResponse(w, r, http.StatusBadRequest, "invalidValue", err.Error())
+			return
+		}
+	}
+
+	app.saveSCIMUser(w, r, user, email)
+}
+
+// The deleteSCIMUserHandler for the "DELETE /scim/v2/Users/:id" endpoint deletes the
+// user and everything they own.
+func (app *application) deleteSCIMUserHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.readSCIMManagedUser(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.modelsFor(r).Users.Delete(user.ID)
+	if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+		app.scimServerErrorResponse(w, r, err)
+		return
+	}
+	// The user's tokens went with them, but JWTs aren't stored, so they're revoked
+	// separately.
+	app.incident.revokeJWTs(time.Now(), user.ID)
+	app.permissionCache.Delete(user.ID)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// The saveSCIMUser() helper validates and saves an updated user, whose email address was
+// the given one before the update. A user who has just been deactivated, or whose
+// password or email address has changed, is logged out everywhere.
+func (app *application) saveSCIMUser(w http.ResponseWriter, r *http.Request, user *data.User, email string) {
+	v := validator.New()
+	v.Check(user.ExternalID != "", "externalId", "must be provided")
+	if data.ValidateUser(v, user); !v.Valid() {
+		app.scimValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err := app.modelsFor(r).Users.Update(user)
+	if err != nil {
+		app.handleSCIMWriteError(w, r, err)
+		return
+	}
+
+	if !user.Active || user.Password.Changed() || !strings.EqualFold(user.Email, email) {
+		for _, scope := range []string{data.ScopeAuthentication, data.ScopeRefresh} {
+			err = app.modelsFor(r).Tokens.DeleteAllForUser(scope, user.ID)
+			if err != nil {
+				app.scimServerErrorResponse(w, r, err)
+				return
+			}
+		}
+		app.incident.revokeJWTs(time.Now(), user.ID)
+	}
+
+	app.sendSCIMUser(w, r, http.StatusOK, user)
+}
+
+func (app *application) handleSCIMWriteError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, data.ErrDuplicateEmail):
+		app.scimErrorResponse(w, r, http.StatusConflict, "uniqueness", "a user with this userName already exists")
+	case errors.Is(err, data.ErrDuplicateExternalID):
+		app.scimErrorResponse(w, r, http.StatusConflict, "uniqueness", "a user with this externalId already exists")
+	case errors.Is(err, data.ErrEditConflict):
+		app.scimErrorResponse(w, r, http.StatusPreconditionFailed, "", "the user has been modified since it was last fetched")
+	default:
+		app.scimServerErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) sendSCIMUser(w http.ResponseWriter, r *http.Request, status int, user *data.User) {
+	resource := newSCIMUser(user)
+
+	headers := make(http.Header)
+	headers.Set("ETag", resource.Meta.Version)
+	if status == http.StatusCreated {
+		headers.Set("Location", resource.Meta.Location)
+	}
+
+	err := app.writeSCIM(w, status, resource, headers)
+	if err != nil {
+		app.scimServerErrorResponse(w, r, err)
+	}
+}
+
+// applySCIMPatch applies a single PATCH operation to a user. Only the attributes which
+// map onto a user are supported. An operation without a path carries an object of
+// attributes to set.
+func applySCIMPatch(user *data.User, op scimPatchOp) error {
+	switch strings.ToLower(op.Op) {
+	case "add", "replace":
+	case "remove":
+		// The only optional attribute is externalId; everything else is required.
+		if strings.EqualFold(op.Path, "externalId") {
+			user.ExternalID = ""
+			return nil
+		}
+		return fmt.Errorf("%s can't be removed", op.Path)
+	default:
+		return fmt.Errorf("unsupported operation %q", op.Op)
+	}
+
+	if op.Path == "" {
+		var attrs map[string]json.RawMessage
+		if err := json.Unmarshal(op.Value, &attrs); err != nil {
+			return errors.New("value must be an object when no path is given")
+		}
+		for path, value := range attrs {
+			if err := setSCIMAttribute(user, path, value); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return setSCIMAttribute(user, op.Path, op.Value)
+}
+
+func setSCIMAttribute(user *data.User, path string, value json.RawMessage) error {
+	var s string
+	switch strings.ToLower(path) {
+	case "active":
+		// Some identity providers send booleans as strings.
+		var b bool
+		if err := json.Unmarshal(value, &b); err != nil {
+			if err := json.Unmarshal(value, &s); err != nil {
+				return errors.New("active must be a boolean")
+			}
+			parsed, err := strconv.ParseBool(s)
+			if err != nil {
+				return errors.New("active must be a boolean")
+			}
+			b = parsed
+		}
+		user.Active = b
+		return nil
+	case "username", "emails[type eq \"work\"].value", "emails.value":
+		if err := json.Unmarshal(value, &s); err != nil {
+			return fmt.Errorf("%s must be a string", path)
+		}
+		user.Email = s
+	case "displayname", "name.formatted":
+		if err := json.Unmarshal(value, &s); err != nil {
+			return fmt.Errorf("%s must be a string", path)
+		}
+		user.Name = s
+	case "externalid":
+		if err := json.Unmarshal(value, &s); err != nil {
+			return fmt.Errorf("%s must be a string", path)
+		}
+		user.ExternalID = s
+	case "password":
+		if err := json.Unmarshal(value, &s); err != nil {
+			return fmt.Errorf("%s must be a string", path)
+		}
+		return user.Password.Set(s)
+	default:
+		return fmt.Errorf("%s is not a supported attribute", path)
+	}
+	return nil
+}
+
+// scimFilterFields maps the SCIM attributes which can be filtered on to user search
+// fields. Attribute names are case-insensitive.
+var scimFilterFields = map[string]string{
+	"id":             "public_id",
+	"externalid":     "external_id",
+	"username":       "email",
+	"emails":         "email",
+	"emails.value":   "email",
+	"displayname":    "name",
+	"name.formatted": "name",
+	"active":         "active",
+}
+
+// scimFilterOps maps the SCIM filter operators to user search operators. The
+// ordering operators (gt, ge, lt, le) aren't supported.
+var scimFilterOps = map[string]string{
+	"eq": data.MatchEqual,
+	"ne": data.MatchNotEqual,
+	"co": data.MatchContains,
+	"sw": data.MatchPrefix,
+	"ew": data.MatchSuffix,
+	"pr": data.MatchPresent,
+}
+
+// parseSCIMFilter parses a SCIM filter expression into user search conditions. It
+// supports any number of comparisons joined by "and", such as
+// `userName eq "alice@example.com" and active eq true`, which covers what identity
+// providers send in practice. "or", "not" and grouping are rejected.
+func parseSCIMFilter(filter string) ([]data.UserCondition, error) {
+	tokens, err := scanSCIMFilter(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	var conditions []data.UserCondition
+	for len(tokens) > 0 {
+		if len(conditions) > 0 {
+			if !strings.EqualFold(tokens[0], "and") {
+				return nil, fmt.Errorf("unsupported filter operator %q, only \"and\" is supported", tokens[0])
+			}
+			tokens = tokens[1:]
+		}
+		if len(tokens) < 2 {
+			return nil, errors.New("incomplete filter expression")
+		}
+
+		field, ok := scimFilterFields[strings.ToLower(tokens[0])]
+		if !ok {
+			return nil, fmt.Errorf("filtering on %q is not supported", tokens[0])
+		}
+		op, ok := scimFilterOps[strings.ToLower(tokens[1])]
+		if !ok {
+			return nil, fmt.Errorf("unsupported comparison operator %q", tokens[1])
+		}
+		c := data.UserCondition{Field: field, Op: op}
+		tokens = tokens[2:]
+
+		if op != data.MatchPresent {
+			if len(tokens) == 0 {
+				return nil, errors.New("incomplete filter expression")
+			}
+			var value any
+			if err := json.Unmarshal([]byte(tokens[0]), &value); err != nil {
+				return nil, fmt.Errorf("invalid comparison value %s", tokens[0])
+			}
+			c.Value = value
+			tokens = tokens[1:]
+		}
+		conditions = append(conditions, c)
+	}
+	return conditions, nil
+}
+
+// scanSCIMFilter splits a filter expression into words, keeping quoted strings
+// (including their quotes) together.
+func scanSCIMFilter(filter string) ([]string, error) {
+	var tokens []string
+	for i := 0; i < len(filter); {
+		switch c := filter[i]; {
+		case c == ' ':
+			i++
+		case c == '(' || c == ')' || c == '[' || c == ']':
+			return nil, errors.New("grouping in filters is not supported")
+		case c == '"':
+			j := i + 1
+			for j < len(filter) && filter[j] != '"' {
+				if filter[j] == '\\' {
+					j++
+				}
+				j++
+			}
+			if j >= len(filter) {
+				return nil, errors.New("unterminated string in filter")
+			}
+			tokens = append(tokens, filter[i:j+1])
+			i = j + 1
+		default:
+			j := i
+			for j < len(filter) && filter[j] != ' ' {
+				j++
+			}
+			tokens = append(tokens, filter[i:j])
+			i = j
+		}
+	}
+	return tokens, nil
+}
+
+// randomPassword returns a password nobody knows, for users provisioned without one.
+func randomPassword() (string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
@@ -0,0 +1,335 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/shyngys9219/greenlight/internal/data"
+	"github.com/shyngys9219/greenlight/internal/notify"
+	"github.com/shyngys9219/greenlight/internal/validator"
+)
+
+// screeningReminderLead is how long before a screening starts the reminder emails go
+// out.
+const screeningReminderLead = time.Hour
+
+// The createScreeningHandler for the "POST /v1/screenings" endpoint schedules a new
+// watch party hosted by the current user, optionally inviting other users straight
+// away.
+func (app *application) createScreeningHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		MovieID  int64     `json:"movie_id"`
+		StartsAt time.Time `json:"starts_at"`
+		Note     string    `json:"note"`
+		Invite   []int64   `json:"invite"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	host := app.contextGetUser(r)
+	screening := &data.Screening{
+		MovieID:  input.MovieID,
+		HostID:   host.ID,
+		StartsAt: input.StartsAt,
+		Note:     input.Note,
+	}
+
+	v := validator.New()
+	data.ValidateScreening(v, screening)
+	v.Check(len(input.Invite) <= 50, "invite", "must not contain more than 50 user ids")
+	v.Check(validator.Unique(input.Invite), "invite", "must not contain duplicate values")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	movie, err := app.modelsFor(r).Movies.Get(screening.MovieID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("movie_id", "must be an existing movie")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.modelsFor(r).Screenings.Insert(screening)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	attendees := []*data.Attendee{}
+	if len(input.Invite) > 0 {
+		attendees, err = app.modelsFor(r).Screenings.Invite(screening.ID, input.Invite)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		app.notifyInvitees(r, screening, movie, host, attendees)
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/screenings/%d", screening.ID))
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"screening": screening, "attendees": attendees}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The showScreeningHandler for the "GET /v1/screenings/:id" endpoint returns a
+// screening and its attendees. Only the host and the invited users can see it; for
+// everyone else it doesn't exist.
+func (app *application) showScreeningHandler(w http.ResponseWriter, r *http.Request) {
+	screening, attendees, ok := app.readScreening(w, r)
+	if !ok {
+		return
+	}
+
+	user := app.contextGetUser(r)
+	if screening.HostID != user.ID && findAttendee(attendees, user.ID) == nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err := app.writeJSON(w, http.StatusOK, envelope{"screening": screening, "attendees": attendees}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The inviteScreeningHandler for the "POST /v1/screenings/:id/invites" endpoint lets
+// the host invite more users. Only users who weren't invited before get an email.
+func (app *application) inviteScreeningHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		UserIDs []int64 `json:"user_ids"`
+	}
+
+	screening, attendees, ok := app.readScreening(w, r)
+	if !ok {
+		return
+	}
+
+	host := app.contextGetUser(r)
+	if screening.HostID != host.ID {
+		if findAttendee(attendees, host.ID) == nil {
+			app.notFoundResponse(w, r)
+			return
+		}
+		app.notPermittedResponse(w, r)
+		return
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	v.Check(len(input.UserIDs) > 0, "user_ids", "must contain at least 1 user id")
+	v.Check(len(input.UserIDs) <= 50, "user_ids", "must not contain more than 50 user ids")
+	v.Check(validator.Unique(input.UserIDs), "user_ids", "must not contain duplicate values")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	movie, err := app.modelsFor(r).Movies.Get(screening.MovieID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	invited, err := app.modelsFor(r).Screenings.Invite(screening.ID, input.UserIDs)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	app.notifyInvitees(r, screening, movie, host, invited)
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"invited": invited}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The rsvpScreeningHandler for the "PUT /v1/screenings/:id/rsvp" endpoint records the
+// current user's answer to an invite.
+func (app *application) rsvpScreeningHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		RSVP string `json:"rsvp"`
+	}
+
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	if data.ValidateRSVP(v, input.RSVP); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user := app.contextGetUser(r)
+	err = app.modelsFor(r).Screenings.SetRSVP(id, user.ID, input.RSVP)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"rsvp": input.RSVP}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The readScreening() helper loads the screening named by the "id" URL parameter and
+// its attendees, sending the appropriate error response and returning false if that
+// isn't possible.
+func (app *application) readScreening(w http.ResponseWriter, r *http.Request) (*data.Screening, []*data.Attendee, bool) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil, nil, false
+	}
+
+	screening, err := app.modelsFor(r).Screenings.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, nil, false
+	}
+
+	attendees, err := app.modelsFor(r).Screenings.GetAttendees(screening.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return nil, nil, false
+	}
+	return screening, attendees, true
+}
+
+func findAttendee(attendees []*data.Attendee, userID int64) *data.Attendee {
+	for _, attendee := range attendees {
+		if attendee.UserID == userID {
+			return attendee
+		}
+	}
+	return nil
+}
+
+// The attendeeRecipient() helper returns the recipient of notifications to an attendee.
+func attendeeRecipient(attendee *data.Attendee) notify.Recipient {
+	return notify.Recipient{UserID: attendee.UserID, Name: attendee.Name, Email: attendee.Email}
+}
+
+// The notifyInvitees() method notifies each newly invited user in the background.
+func (app *application) notifyInvitees(r *http.Request, screening *data.Screening, movie *data.Movie, host *data.User, attendees []*data.Attendee) {
+	for _, attendee := range attendees {
+		attendee := attendee
+		app.background(backgroundTask{
+			name:      "screening_invite_email",
+			requestID: contextGetRequestID(r.Context()),
+			attempts:  emailTaskAttempts,
+			backoff:   emailTaskBackoff,
+			fn: func() error {
+				data := map[string]any{
+					"name":        attendee.Name,
+					"hostName":    host.Name,
+					"movieTitle":  movie.Title,
+					"startsAt":    screening.StartsAt,
+					"note":        screening.Note,
+					"screeningID": screening.ID,
+				}
+				return app.notifier.Send(attendeeRecipient(attendee), notifyScreeningInvite, data)
+			},
+		})
+	}
+}
+
+// The sendScreeningReminders() job notifies the host and everyone who hasn't declined
+// the invite shortly before a screening starts. A screening whose reminders can't be
+// sent is logged, and doesn't hold up the others; it was claimed, so it isn't retried.
+func (app *application) sendScreeningReminders() error {
+	screenings, err := app.models.Screenings.ClaimDueReminders(screeningReminderLead)
+	if err != nil {
+		return err
+	}
+
+	for _, screening := range screenings {
+		err := app.sendScreeningReminder(screening)
+		if err != nil {
+			app.logger.PrintError(err, map[string]string{
+				"screening_id": fmt.Sprint(screening.ID),
+			})
+		}
+	}
+	return nil
+}
+
+// The sendScreeningReminder() method notifies the host and attendees of one screening.
+// Failing to notify one of them is only logged.
+func (app *application) sendScreeningReminder(screening *data.Screening) error {
+	movie, err := app.models.Movies.Get(screening.MovieID)
+	if err != nil {
+		return err
+	}
+	host, err := app.models.Users.Get(screening.HostID)
+	if err != nil {
+		return err
+	}
+	attendees, err := app.models.Screenings.GetAttendees(screening.ID)
+	if err != nil {
+		return err
+	}
+
+	recipients := []*data.Attendee{{UserID: host.ID, Name: host.Name, Email: host.Email, RSVP: data.RSVPYes}}
+	for _, attendee := range attendees {
+		if attendee.RSVP != data.RSVPNo {
+			recipients = append(recipients, attendee)
+		}
+	}
+
+	for _, recipient := range recipients {
+		data := map[string]any{
+			"name":        recipient.Name,
+			"movieTitle":  movie.Title,
+			"startsAt":    screening.StartsAt,
+			"note":        screening.Note,
+			"screeningID": screening.ID,
+		}
+		err = app.notifier.Send(attendeeRecipient(recipient), notifyScreeningReminder, data)
+		if err != nil {
+			app.logger.PrintError(err, map[string]string{
+				"screening_id": fmt.Sprint(screening.ID),
+				"user_id":      fmt.Sprint(recipient.UserID),
+			})
+		}
+	}
+	return nil
+}
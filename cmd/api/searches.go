@@ -0,0 +1,104 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/shyngys9219/greenlight/internal/data"
+	"github.com/shyngys9219/greenlight/internal/validator"
+)
+
+// The recordSearch() method records a search for the search analytics report, in the
+// background so the search itself isn't slowed down. Search handlers call it with the
+// query as typed and the total number of results. Empty queries aren't recorded, and
+// neither are those of users who don't consent to analytics, or any made in read-only
+// mode.
+func (app *application) recordSearch(r *http.Request, query string, results int) {
+	if !app.config.searchAnalytics.enabled || app.readOnly.active() {
+		return
+	}
+	query = data.NormalizeQuery(query)
+	if query == "" {
+		return
+	}
+
+	sq := &data.SearchQuery{Query: query, Results: results}
+	user := app.contextGetUser(r)
+	if !user.IsAnonymous() {
+		sq.UserHash = app.hashUserID(user.ID)
+	}
+
+	app.background(backgroundTask{
+		name:      "record_search",
+		requestID: contextGetRequestID(r.Context()),
+		fn: func() error {
+			// The searches of users who don't consent to analytics aren't recorded at
+			// all. Anonymous searches can't be tied to anyone, so they always are.
+			if !user.IsAnonymous() {
+				granted, err := app.models.Consents.Granted(user.ID, data.ConsentAnalytics, app.consentDefault(data.ConsentAnalytics))
+				if err != nil || !granted {
+					return err
+				}
+			}
+			return app.models.Searches.Insert(sq)
+		},
+	})
+}
+
+// The hashUserID() method returns the keyed hash which stands in for a user in search
+// analytics. It's stable for as long as the key is, so distinct users can be counted,
+// but can't be reversed without the key.
+func (app *application) hashUserID(id int64) string {
+	mac := hmac.New(sha256.New, []byte(app.config.searchAnalytics.key))
+	mac.Write([]byte(strconv.FormatInt(id, 10)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// randomKey() returns a random hex-encoded 32-byte key.
+func randomKey() (string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// The searchQueriesHandler for the "GET /v1/admin/search/queries" endpoint reports the
+// most searched queries over the last few days, and separately the most searched
+// queries which found nothing, so curators know which titles to add.
+func (app *application) searchQueriesHandler(w http.ResponseWriter, r *http.Request) {
+	qs := r.URL.Query()
+	v := validator.New()
+
+	days := app.readInt(qs, "days", 7, v)
+	limit := app.readInt(qs, "limit", 50, v)
+	v.Check(days > 0 && days <= 365, "days", "must be between 1 and 365")
+	v.Check(limit > 0 && limit <= 500, "limit", "must be between 1 and 500")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	since := time.Now().AddDate(0, 0, -days)
+	top, err := app.modelsFor(r).Searches.TopQueries(since, limit, false)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	zero, err := app.modelsFor(r).Searches.TopQueries(since, limit, true)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"since": since.UTC(), "top_queries": top, "zero_result_queries": zero}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -2,86 +2,139 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
+	"net"
 	"net/http"
-	"os"
-	"os/signal"
-	"syscall"
+	"strconv"
 	"time"
+
+	"github.com/shyngys9219/greenlight/internal/lifecycle"
 )
 
-func (app *application) serve() error {
+// The httpComponent() method returns the component of the API server, which listens on
+// -port, speaking HTTPS if tlsConfig is set. It's the last component started, so that
+// requests are only taken once everything they need is up, and the first one stopped.
+func (app *application) httpComponent(tlsConfig *tls.Config) lifecycle.Component {
 	srv := &http.Server{
 		Addr:         fmt.Sprintf(":%d", app.config.port),
 		Handler:      app.routes(),
 		IdleTimeout:  time.Minute,
 		ReadTimeout:  10 * time.Second,
 		WriteTimeout: 30 * time.Second,
+		TLSConfig:    tlsConfig,
 	}
-	// Create a shutdownError channel. We will use this to receive any errors returned
-	// by the graceful Shutdown() function.
-	shutdownError := make(chan error)
-	go func() {
-		// Intercept the signals, as before.
-		quit := make(chan os.Signal, 1)
-		signal.Notify(quit, syscall.SIGTERM, syscall.SIGINT)
-		s := <-quit
-		// Update the log entry to say "shutting down server" instead of "caught signal".
-		app.logger.PrintInfo("caught signal", map[string]string{
-			"signal": s.String(),
-		})
+	// Shutdown() waits for the requests in progress, so end the long polls waiting for
+	// events straight away rather than letting them run out their wait.
+	srv.RegisterOnShutdown(app.eventHub.Close)
 
-		// Create a context with a 20-second timeout.
-		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
-		defer cancel()
-		// Call Shutdown() on our server, passing in the context we just made.
-		// Shutdown() will return nil if the graceful shutdown was successful, or an
-		// error (which may happen because of a problem closing the listeners, or
-		// because the shutdown didn't complete before the 20-second context deadline is
-		// hit). We relay this return value to the shutdownError channel.
-		err := srv.Shutdown(ctx)
-		if err != nil {
-			shutdownError <- srv.Shutdown(ctx)
-		}
+	return lifecycle.Component{
+		Name: "http",
+		Start: func(ctx context.Context) error {
+			ln, err := net.Listen("tcp", srv.Addr)
+			if err != nil {
+				return err
+			}
+			app.logger.PrintInfo("starting server", map[string]string{
+				"addr": srv.Addr,
+				"env":  app.config.env,
+				"tls":  strconv.FormatBool(tlsConfig != nil),
+			})
+			go func() {
+				// Calling Shutdown() on our server will cause Serve() to immediately
+				// return a http.ErrServerClosed error. So if we see this error, it is
+				// actually a good thing and an indication that the graceful shutdown has
+				// started. Any other error means the server stopped by itself.
+				var err error
+				if tlsConfig != nil {
+					// The certificates are in the TLS config already, so no files are
+					// given here.
+					err = srv.ServeTLS(ln, "", "")
+				} else {
+					err = srv.Serve(ln)
+				}
+				if !errors.Is(err, http.ErrServerClosed) {
+					app.lifecycle.Fail("http", err)
+				}
+			}()
+			return nil
+		},
+		Stop: func(ctx context.Context) error {
+			// Shutdown() stops accepting connections and waits for the requests in
+			// flight, until ctx ends. It returns nil if the graceful shutdown was
+			// successful, or an error (which may happen because of a problem closing
+			// the listeners, or because the shutdown didn't complete before the context
+			// deadline is hit).
+			err := srv.Shutdown(ctx)
+			if err != nil {
+				return err
+			}
+			app.logger.PrintInfo("stopped server", map[string]string{
+				"addr": srv.Addr,
+			})
+			return nil
+		},
+	}
+}
+
+// The redirectComponent() method returns the component of the plain HTTP server on
+// -http-redirect-port, which redirects to the HTTPS server. handler answers the
+// requests; with Let's Encrypt it's the autocert manager's, which also answers the
+// HTTP-01 challenges. The server stopping doesn't stop the application.
+func (app *application) redirectComponent(handler http.Handler) lifecycle.Component {
+	srv := &http.Server{
+		Addr:         fmt.Sprintf(":%d", app.config.tls.redirectPort),
+		Handler:      handler,
+		IdleTimeout:  time.Minute,
+		ReadTimeout:  5 * time.Second,
+		WriteTimeout: 5 * time.Second,
+	}
+	return lifecycle.Component{
+		Name: "http_redirect",
+		Start: func(ctx context.Context) error {
+			ln, err := net.Listen("tcp", srv.Addr)
+			if err != nil {
+				return err
+			}
+			go func() {
+				err := srv.Serve(ln)
+				if !errors.Is(err, http.ErrServerClosed) {
+					app.logger.PrintError(err, map[string]string{"addr": srv.Addr})
+				}
+			}()
+			return nil
+		},
+		Stop: srv.Shutdown,
+	}
+}
 
-		// Log a message to say that we're waiting for any background goroutines to
-		// complete their tasks.
-		app.logger.PrintInfo("completing background tasks", map[string]string{
-			"addr": srv.Addr,
-		})
+// drainBackgroundLogInterval is how often the shutdown logs how many background tasks it
+// is still waiting for.
+const drainBackgroundLogInterval = 2 * time.Second
 
-		// Call Wait() to block until our WaitGroup counter is zero --- essentially
-		// blocking until the background goroutines have finished. Then we return nil on
-		// the shutdownError channel, to indicate that the shutdown completed without
-		// any issues.
+// The drainBackground() method waits for the background tasks to finish, logging how
+// many are left every drainBackgroundLogInterval. If they aren't done by the time ctx
+// ends it gives up and returns an error, so a stuck task can't hold up the exit forever.
+func (app *application) drainBackground(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
 		app.wg.Wait()
-		shutdownError <- nil
+		close(done)
 	}()
 
-	app.logger.PrintInfo("starting server", map[string]string{
-		"addr": srv.Addr,
-		"env":  app.config.env,
-	})
-	// Calling Shutdown() on our server will cause ListenAndServe() to immediately
-	// return a http.ErrServerClosed error. So if we see this error, it is actually a
-	// good thing and an indication that the graceful shutdown has started. So we check
-	// specifically for this, only returning the error if it is NOT http.ErrServerClosed.
-	err := srv.ListenAndServe()
-	if !errors.Is(err, http.ErrServerClosed) {
-		return err
-	}
-	// Otherwise, we wait to receive the return value from Shutdown() on the
-	// shutdownError channel. If return value is an error, we know that there was a
-	// problem with the graceful shutdown and we return the error.
-	err = <-shutdownError
-	if err != nil {
-		return err
+	ticker := time.NewTicker(drainBackgroundLogInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return nil
+		case <-ticker.C:
+			app.logger.PrintInfo("waiting for background tasks", map[string]string{
+				"tasks": strconv.FormatInt(app.backgroundRunning.Load(), 10),
+			})
+		case <-ctx.Done():
+			return fmt.Errorf("gave up on %d background tasks after the shutdown timeout", app.backgroundRunning.Load())
+		}
 	}
-	// At this point we know that the graceful shutdown completed successfully and we
-	// log a "stopped server" message.
-	app.logger.PrintInfo("stopped server", map[string]string{
-		"addr": srv.Addr,
-	})
-	return nil
 }
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/shyngys9219/greenlight/internal/data"
+)
+
+const sitemapNamespace = "http://www.sitemaps.org/schemas/sitemap/0.9"
+
+// A sitemapSet is one generation of the sitemaps: the sitemap.xml index and the
+// movies-<n>.xml files it lists, by file name. There's no shared file storage, so each
+// instance generates its own set and keeps it in memory.
+type sitemapSet struct {
+	files       map[string][]byte
+	generatedAt time.Time
+}
+
+// A sitemapURL is a page in a sitemap file, or a sitemap file in the index.
+type sitemapURL struct {
+	Loc     string `xml:"loc"`
+	LastMod string `xml:"lastmod,omitempty"`
+}
+
+type sitemapURLSet struct {
+	XMLName xml.Name     `xml:"urlset"`
+	Xmlns   string       `xml:"xmlns,attr"`
+	URLs    []sitemapURL `xml:"url"`
+}
+
+type sitemapIndex struct {
+	XMLName  xml.Name     `xml:"sitemapindex"`
+	Xmlns    string       `xml:"xmlns,attr"`
+	Sitemaps []sitemapURL `xml:"sitemap"`
+}
+
+// The generateSitemaps() job lists the page of every movie of the public catalog, at
+// <base URL>/movies/<public id>, in sitemap files of up to data.MaxSitemapURLs pages
+// each, and replaces the served sitemaps with them once they're all done. The sitemaps
+// protocol requires sitemaps to be on the same host as the pages they list, so the
+// site at the base URL is expected to pass /sitemap.xml and /sitemaps/ on to the API.
+func (app *application) generateSitemaps() error {
+	base := strings.TrimSuffix(app.config.sitemap.baseURL, "/")
+	set := &sitemapSet{files: make(map[string][]byte), generatedAt: time.Now()}
+	index := sitemapIndex{Xmlns: sitemapNamespace}
+	movies := 0
+
+	var afterID int64
+	for page := 1; ; page++ {
+		entries, err := app.models.Movies.GetSitemapEntries(afterID, data.MaxSitemapURLs)
+		if err != nil {
+			return err
+		}
+		if len(entries) == 0 && page > 1 {
+			break
+		}
+
+		urlSet := sitemapURLSet{Xmlns: sitemapNamespace, URLs: make([]sitemapURL, len(entries))}
+		var lastMod time.Time
+		for i, entry := range entries {
+			urlSet.URLs[i] = sitemapURL{
+				Loc:     base + "/movies/" + url.PathEscape(entry.PublicID),
+				LastMod: entry.LastModified.UTC().Format(time.RFC3339),
+			}
+			if entry.LastModified.After(lastMod) {
+				lastMod = entry.LastModified
+			}
+		}
+
+		name := fmt.Sprintf("movies-%d.xml", page)
+		set.files[name], err = encodeSitemap(urlSet)
+		if err != nil {
+			return err
+		}
+		file := sitemapURL{Loc: base + "/sitemaps/" + name}
+		if !lastMod.IsZero() {
+			file.LastMod = lastMod.UTC().Format(time.RFC3339)
+		}
+		index.Sitemaps = append(index.Sitemaps, file)
+		movies += len(entries)
+
+		if len(entries) < data.MaxSitemapURLs {
+			break
+		}
+		afterID = entries[len(entries)-1].ID
+	}
+
+	var err error
+	set.files["sitemap.xml"], err = encodeSitemap(index)
+	if err != nil {
+		return err
+	}
+	app.sitemaps.Store(set)
+
+	app.logger.PrintInfo("sitemaps generated", map[string]string{
+		"files":  fmt.Sprint(len(index.Sitemaps)),
+		"movies": fmt.Sprint(movies),
+	})
+	return nil
+}
+
+// The encodeSitemap() helper encodes a sitemap file or index as an XML document.
+func encodeSitemap(v any) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteString(xml.Header)
+	err := xml.NewEncoder(&buf).Encode(v)
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// The sitemapIndexHandler for the "GET /sitemap.xml" endpoint sends the sitemap index,
+// which lists the sitemap files.
+func (app *application) sitemapIndexHandler(w http.ResponseWriter, r *http.Request) {
+	app.serveSitemap(w, r, "sitemap.xml")
+}
+
+// The sitemapHandler for the "GET /sitemaps/:file" endpoint sends one of the sitemap
+// files listed in the index.
+func (app *application) sitemapHandler(w http.ResponseWriter, r *http.Request) {
+	app.serveSitemap(w, r, httprouter.ParamsFromContext(r.Context()).ByName("file"))
+}
+
+// The serveSitemap() helper sends a file of the current sitemaps, or a 404 Not Found
+// response if there's no such file or the sitemaps haven't been generated (yet).
+// http.ServeContent() takes care of conditional requests, with the time the sitemaps
+// were generated as the Last-Modified time.
+func (app *application) serveSitemap(w http.ResponseWriter, r *http.Request, name string) {
+	set := app.sitemaps.Load()
+	if set == nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	file, ok := set.files[name]
+	if !ok {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	w.Header().Set("Cache-Control", "public, max-age=3600")
+	http.ServeContent(w, r, name, set.generatedAt, bytes.NewReader(file))
+}
@@ -0,0 +1,296 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/shyngys9219/greenlight/internal/data"
+	"github.com/shyngys9219/greenlight/internal/health"
+	"github.com/shyngys9219/greenlight/internal/validator"
+)
+
+// statusCacheTTL is how long a built status page is served before it's rebuilt. The
+// page is public, so it mustn't cost a database query per request.
+const statusCacheTTL = 30 * time.Second
+
+// statusChecks maps the components on the status page to the health checks which
+// report on them. The API itself has no check: it's up for as long as it's recording
+// probe results.
+var statusChecks = map[string]string{
+	"database": "database",
+	"email":    "smtp",
+}
+
+// statusWindows are the periods over which uptime is reported.
+var statusWindows = []struct {
+	name string
+	d    time.Duration
+}{
+	{"24h", 24 * time.Hour},
+	{"7d", 7 * 24 * time.Hour},
+	{"30d", 30 * 24 * time.Hour},
+}
+
+// A statusComponent is one component on the status page. An uptime is nil when there
+// are no probe results for its window.
+type statusComponent struct {
+	Name   string              `json:"name"`
+	Status string              `json:"status"`
+	Uptime map[string]*float64 `json:"uptime"`
+}
+
+// The statusHandler for the "GET /v1/status" endpoint returns the data of the public
+// status page: the current state of each component, its uptime over the last day, week
+// and month, and recent incident notes.
+func (app *application) statusHandler(w http.ResponseWriter, r *http.Request) {
+	env, err := app.statusCache.Get("status", app.buildStatus)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(statusCacheTTL.Seconds())))
+
+	err = app.writeJSON(w, http.StatusOK, env, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The buildStatus() method builds the status page. The current state comes from the
+// in-memory health history, so the page still works while the database is down;
+// uptimes come from the persisted history when there is one, falling back to memory,
+// and incidents are left out if they can't be read.
+func (app *application) buildStatus() (envelope, error) {
+	now := time.Now().UTC()
+
+	components := []*statusComponent{}
+	for _, name := range data.StatusComponents {
+		components = append(components, &statusComponent{Name: name, Uptime: make(map[string]*float64)})
+	}
+
+	overall := "operational"
+	latest, ok := app.healthHistory.Latest()
+	for _, c := range components {
+		switch {
+		case !ok:
+			c.Status = "unknown"
+			overall = "unknown"
+		case c.Name == "api" || latest.Checks[statusChecks[c.Name]].Status == health.StatusUp:
+			c.Status = "operational"
+		default:
+			c.Status = "outage"
+			if overall == "operational" {
+				overall = "degraded"
+			}
+		}
+	}
+
+	for _, window := range statusWindows {
+		since := now.Add(-window.d)
+		tallies, probes, first := app.tallyHealth(since)
+		for _, c := range components {
+			var uptime float64
+			var known bool
+			if c.Name == "api" {
+				uptime, known = app.apiUptime(probes, first, now)
+			} else {
+				uptime, known = tallies[statusChecks[c.Name]].Uptime()
+			}
+			if known {
+				uptime = math.Round(uptime*1000) / 1000
+				c.Uptime[window.name] = &uptime
+			} else {
+				c.Uptime[window.name] = nil
+			}
+		}
+	}
+
+	incidents, err := app.models.StatusIncidents.GetRecent(now.Add(-7 * 24 * time.Hour))
+	if err != nil {
+		app.logger.PrintError(err, map[string]string{"during": "building status page"})
+		incidents = []*data.StatusIncident{}
+	}
+
+	env := envelope{
+		"status":     overall,
+		"updated_at": now,
+		"components": components,
+		"incidents":  incidents,
+	}
+	return env, nil
+}
+
+// The tallyHealth() method counts the probe results since the given time, from the
+// persisted history if it's enabled and readable, otherwise from memory.
+func (app *application) tallyHealth(since time.Time) (map[string]health.Tally, int, time.Time) {
+	if app.config.health.persist {
+		tallies, probes, first, err := app.models.Health.TallySince(since)
+		if err == nil {
+			return tallies, probes, first
+		}
+		app.logger.PrintError(err, map[string]string{"during": "building status page"})
+	}
+	return health.TallySince(app.healthHistory.All(), since)
+}
+
+// The apiUptime() method works out the API's uptime as the share of the probes which
+// should have run since the first one that actually did. Probes only run while the
+// API is up, so gaps in the history are downtime.
+func (app *application) apiUptime(probes int, first, now time.Time) (float64, bool) {
+	if probes == 0 {
+		return 0, false
+	}
+	expected := int(now.Sub(first)/app.config.health.interval) + 1
+	if probes >= expected {
+		return 100, true
+	}
+	return 100 * float64(probes) / float64(expected), true
+}
+
+// The createStatusIncidentHandler for the "POST /v1/admin/status/incidents" endpoint
+// publishes an incident note on the status page.
+func (app *application) createStatusIncidentHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Title      string   `json:"title"`
+		Message    string   `json:"message"`
+		Status     string   `json:"status"`
+		Components []string `json:"components"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	incident := &data.StatusIncident{
+		Title:      input.Title,
+		Message:    input.Message,
+		Status:     input.Status,
+		Components: input.Components,
+	}
+	if incident.Status == "" {
+		incident.Status = data.IncidentInvestigating
+	}
+	if incident.Components == nil {
+		incident.Components = []string{}
+	}
+
+	v := validator.New()
+	if data.ValidateStatusIncident(v, incident); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.modelsFor(r).StatusIncidents.Insert(incident)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	app.statusCache.Delete("status")
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"incident": incident}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The updateStatusIncidentHandler for the "PATCH /v1/admin/status/incidents/:id"
+// endpoint updates an incident note, typically to move it on to the next stage.
+func (app *application) updateStatusIncidentHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	incident, err := app.modelsFor(r).StatusIncidents.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	var input struct {
+		Title      *string  `json:"title"`
+		Message    *string  `json:"message"`
+		Status     *string  `json:"status"`
+		Components []string `json:"components"`
+	}
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if input.Title != nil {
+		incident.Title = *input.Title
+	}
+	if input.Message != nil {
+		incident.Message = *input.Message
+	}
+	if input.Status != nil {
+		incident.Status = *input.Status
+	}
+	if input.Components != nil {
+		incident.Components = input.Components
+	}
+
+	v := validator.New()
+	if data.ValidateStatusIncident(v, incident); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.modelsFor(r).StatusIncidents.Update(incident)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	app.statusCache.Delete("status")
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"incident": incident}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The deleteStatusIncidentHandler for the "DELETE /v1/admin/status/incidents/:id"
+// endpoint removes an incident note, for example one published by mistake.
+func (app *application) deleteStatusIncidentHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.modelsFor(r).StatusIncidents.Delete(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	app.statusCache.Delete("status")
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "incident successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// The newTLSConfig() function returns the TLS configuration of the server set up by the
+// -tls-* and -autocert-* flags, with the autocert manager if the certificates come from
+// Let's Encrypt. Both are nil if TLS is off.
+func newTLSConfig(cfg config) (*tls.Config, *autocert.Manager, error) {
+	switch {
+	case cfg.tls.certFile != "" && cfg.tls.autocertDomains != "":
+		return nil, nil, errors.New("-tls-cert and -autocert-domain can't be used together")
+	case (cfg.tls.certFile == "") != (cfg.tls.keyFile == ""):
+		return nil, nil, errors.New("-tls-cert and -tls-key must be used together")
+	}
+
+	var tlsConfig *tls.Config
+	var manager *autocert.Manager
+	switch {
+	case cfg.tls.certFile != "":
+		cert, err := tls.LoadX509KeyPair(cfg.tls.certFile, cfg.tls.keyFile)
+		if err != nil {
+			return nil, nil, err
+		}
+		tlsConfig = &tls.Config{Certificates: []tls.Certificate{cert}}
+	case cfg.tls.autocertDomains != "":
+		var domains []string
+		for _, domain := range strings.Split(cfg.tls.autocertDomains, ",") {
+			if domain = strings.TrimSpace(domain); domain != "" {
+				domains = append(domains, domain)
+			}
+		}
+		manager = &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(domains...),
+			Cache:      autocert.DirCache(cfg.tls.autocertCache),
+			Email:      cfg.tls.autocertEmail,
+		}
+		tlsConfig = manager.TLSConfig()
+	default:
+		return nil, nil, nil
+	}
+
+	// Only offer TLS 1.2 and up, and prefer the elliptic curves with assembly
+	// implementations, which are the fastest.
+	tlsConfig.MinVersion = tls.VersionTLS12
+	tlsConfig.CurvePreferences = []tls.CurveID{tls.X25519, tls.CurveP256}
+	return tlsConfig, manager, nil
+}
+
+// The redirectToHTTPS() handler sends plain HTTP requests to the same URL on the HTTPS
+// port with a 308 Permanent Redirect, which unlike a 301 keeps the method and body.
+func (app *application) redirectToHTTPS(w http.ResponseWriter, r *http.Request) {
+	host := r.Host
+	if h, _, err := net.SplitHostPort(r.Host); err == nil {
+		host = h
+	}
+	if app.config.port != 443 {
+		host = net.JoinHostPort(host, strconv.Itoa(app.config.port))
+	}
+	http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusPermanentRedirect)
+}
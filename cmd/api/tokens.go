@@ -2,17 +2,23 @@ package main
 
 import (
 	"errors"
+	"fmt"
 	"github.com/shyngys9219/greenlight/internal/data"
+	"github.com/shyngys9219/greenlight/internal/jwt"
 	"github.com/shyngys9219/greenlight/internal/validator"
 	"net/http"
+	"strconv"
 	"time"
 )
 
 func (app *application) createAuthenticationTokenHandler(w http.ResponseWriter, r *http.Request) {
-	// Parse the email and password from the request body.
+	// Parse the email and password from the request body, and the two-factor
+	// authentication code or a recovery code for users who need one.
 	var input struct {
-		Email    string `json:"email"`
-		Password string `json:"password"`
+		Email        string `json:"email"`
+		Password     string `json:"password"`
+		Code         string `json:"code"`
+		RecoveryCode string `json:"recovery_code"`
 	}
 	err := app.readJSON(w, r, &input)
 	if err != nil {
@@ -30,7 +36,7 @@ func (app *application) createAuthenticationTokenHandler(w http.ResponseWriter,
 	// Lookup the user record based on the email address. If no matching user was
 	// found, then we call the app.invalidCredentialsResponse() helper to send a 401
 	// Unauthorized response to the client (we will create this helper in a moment).
-	user, err := app.models.Users.GetByEmail(input.Email)
+	user, err := app.modelsFor(r).Users.GetByEmail(input.Email)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -40,29 +46,352 @@ func (app *application) createAuthenticationTokenHandler(w http.ResponseWriter,
 		}
 		return
 	}
-	// Check if the provided password matches the actual password for the user.
-	match, err := user.Password.Matches(input.Password)
+	// Check the password, counting a wrong one towards locking the account.
+	if !app.checkPassword(w, r, user, input.Password) {
+		return
+	}
+	// Deactivated accounts can't log in at all.
+	if !user.Active {
+		app.deactivatedAccountResponse(w, r)
+		return
+	}
+	// A user who hasn't activated their account gets a specific error rather than
+	// a token, and we offer to help by resending the activation email (at most once
+	// every activationResendInterval).
+	if !user.Activated {
+		resent, err := app.resendActivationEmail(r, user)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		app.accountNotActivatedResponse(w, r, resent)
+		return
+	}
+	// Accounts flagged during a security incident can't log in until they've reset
+	// their password.
+	if user.PasswordResetRequired {
+		app.passwordResetRequiredResponse(w, r)
+		return
+	}
+	// Users with two-factor authentication need a code from their authenticator app,
+	// or a recovery code, as well.
+	if !app.checkSecondFactor(w, r, user, input.Code, input.RecoveryCode) {
+		return
+	}
+	// Otherwise, if the password is correct, we generate a new authentication token,
+	// and a refresh token to get the next one with. Both start a new token family.
+	family, err := data.NewFamily()
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
-	// If the passwords don't match, then we call the app.invalidCredentialsResponse()
-	// helper again and return.
-	if !match {
-		app.invalidCredentialsResponse(w, r)
+	token, err := app.newAuthenticationToken(r, user, family)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
 		return
 	}
-	// Otherwise, if the password is correct, we generate a new token with a 24-hour
-	// expiry time and the scope 'authentication'.
-	token, err := app.models.Tokens.New(user.ID, 24*time.Hour, data.ScopeAuthentication)
+	refresh, err := app.modelsFor(r).Tokens.NewInFamily(user.ID, app.config.auth.refreshTTL, data.ScopeRefresh, family)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
-	// Encode the token to JSON and send it in the response along with a 201 Created
+	// Logins are what the re-engagement campaign counts as activity. Failing to record
+	// one isn't worth failing the login over.
+	err = app.modelsFor(r).Users.SetLastActive(user.ID)
+	if err != nil {
+		app.requestLogger(r).PrintError(err, map[string]string{"during": "recording last activity"})
+	}
+	// Encode the tokens to JSON and send them in the response along with a 201 Created
 	// status code.
-	err = app.writeJSON(w, http.StatusCreated, envelope{"authentication_token": token}, nil)
+	err = app.writeJSON(w, http.StatusCreated, envelope{"authentication_token": token, "refresh_token": refresh}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The newAuthenticationToken() helper issues an authentication token for the user,
+// valid for the configured access token lifetime. By default it's an opaque token with
+// the scope 'authentication', stored in the database in the given token family; when
+// JWTs are turned on it's a JWT signed by app.jwt instead. Clients get both in the same
+// form.
+func (app *application) newAuthenticationToken(r *http.Request, user *data.User, family []byte) (*data.Token, error) {
+	if app.jwt == nil {
+		return app.modelsFor(r).Tokens.NewInFamily(user.ID, app.config.auth.accessTTL, data.ScopeAuthentication, family)
+	}
+
+	claims := &jwt.Claims{
+		Subject: strconv.FormatInt(user.ID, 10),
+		Name:    user.Name,
+		Email:   user.Email,
+	}
+	plaintext, err := app.jwt.Sign(claims, app.config.auth.accessTTL)
+	if err != nil {
+		return nil, err
+	}
+	token := &data.Token{
+		Plaintext: plaintext,
+		UserID:    user.ID,
+		Expiry:    time.Unix(claims.Expiry, 0),
+		Scope:     data.ScopeAuthentication,
+	}
+	return token, nil
+}
+
+// The userForJWT() helper verifies a JWT authentication token and returns its user,
+// as far as the token's claims describe them: only activated users are issued tokens.
+// Tokens revoked by incident mode are rejected with jwt.ErrInvalidToken.
+func (app *application) userForJWT(token string) (*data.User, error) {
+	claims, err := app.jwt.Verify(token)
+	if err != nil {
+		return nil, err
+	}
+	id, err := strconv.ParseInt(claims.Subject, 10, 64)
+	if err != nil || id < 1 {
+		return nil, jwt.ErrInvalidToken
+	}
+	if app.incident.jwtRevoked(id, time.Unix(claims.IssuedAt, 0)) {
+		return nil, jwt.ErrInvalidToken
+	}
+
+	user := &data.User{
+		ID:        id,
+		Name:      claims.Name,
+		Email:     claims.Email,
+		Activated: true,
+		Active:    true,
+	}
+	return user, nil
+}
+
+// The refreshTokenHandler for the "POST /v1/tokens/refresh" endpoint exchanges a
+// refresh token for a new authentication token and a new refresh token. Refresh tokens
+// are single-use: presenting one a second time revokes every token issued since the
+// login it came from, so a stolen refresh token is only good until either party uses
+// it again. JWTs can't be revoked one by one, so on reuse all of the user's JWTs are
+// revoked by this instance.
+func (app *application) refreshTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	v.Check(input.RefreshToken != "", "refresh_token", "must be provided")
+	v.Check(len(input.RefreshToken) == 26, "refresh_token", "must be 26 bytes long")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	refresh, err := app.modelsFor(r).Tokens.Rotate(input.RefreshToken, app.config.auth.refreshTTL)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrTokenReused):
+			if app.jwt != nil {
+				app.incident.revokeJWTs(time.Now(), refresh.UserID)
+			}
+			app.requestLogger(r).PrintInfo("refresh token reused, token family revoked", map[string]string{
+				"user_id": strconv.FormatInt(refresh.UserID, 10),
+			})
+			app.invalidAuthenticationTokenResponse(w, r)
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.invalidAuthenticationTokenResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// The user must still be allowed to log in.
+	user, err := app.modelsFor(r).Users.Get(refresh.UserID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if !user.Active || !user.Activated || user.PasswordResetRequired {
+		err = app.modelsFor(r).Tokens.DeleteFamily(refresh.Family)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		switch {
+		case !user.Active:
+			app.deactivatedAccountResponse(w, r)
+		case user.PasswordResetRequired:
+			app.passwordResetRequiredResponse(w, r)
+		default:
+			app.invalidAuthenticationTokenResponse(w, r)
+		}
+		return
+	}
+
+	token, err := app.newAuthenticationToken(r, user, refresh.Family)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"authentication_token": token, "refresh_token": refresh}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// passwordResetTokenTTL is how long a password reset token is valid, and
+// passwordResetInterval is the minimum time between two reset emails for the same user.
+const (
+	passwordResetTokenTTL = 45 * time.Minute
+	passwordResetInterval = 5 * time.Minute
+)
+
+// The createPasswordResetTokenHandler for the "POST /v1/tokens/password-reset" endpoint
+// emails a password reset token to the owner of the given email address. The response
+// is the same whether or not there's an account for the address, so the endpoint can't
+// be used to find out who has an account. Only active, activated accounts are sent a
+// token.
+func (app *application) createPasswordResetTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Email string `json:"email"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	if data.ValidateEmail(v, input.Email); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user, err := app.modelsFor(r).Users.GetByEmail(input.Email)
+	if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if user != nil && user.Active && user.Activated {
+		err = app.sendPasswordResetEmail(r, user)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	env := envelope{"message": "if there's an account for this email address, an email will be sent to it containing password reset instructions"}
+	err = app.writeJSON(w, http.StatusAccepted, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The sendPasswordResetEmail() helper emails a new password reset token to the user in
+// the background, unless one was already sent within the last passwordResetInterval.
+func (app *application) sendPasswordResetEmail(r *http.Request, user *data.User) error {
+	last, err := app.modelsFor(r).Tokens.LastIssuedAt(data.ScopePasswordReset, user.ID)
+	if err != nil {
+		return err
+	}
+	if time.Since(last) < passwordResetInterval {
+		return nil
+	}
+
+	token, err := app.modelsFor(r).Tokens.New(user.ID, passwordResetTokenTTL, data.ScopePasswordReset)
+	if err != nil {
+		return err
+	}
+
+	app.background(backgroundTask{
+		name:      "password_reset_email",
+		requestID: contextGetRequestID(r.Context()),
+		attempts:  emailTaskAttempts,
+		backoff:   emailTaskBackoff,
+		fn: func() error {
+			data := map[string]any{
+				"passwordResetToken":  token.Plaintext,
+				"passwordResetExpiry": token.Expiry.UTC().Format("Mon, 02 Jan 2006 15:04 MST"),
+				"name":                user.Name,
+			}
+			return app.notifier.Send(userRecipient(user), notifyPasswordReset, data)
+		},
+	})
+	return nil
+}
+
+// The checkPassword() helper checks the password of a user whose account is being
+// signed in to, or otherwise proven to be someone's, and sends the error response if it
+// doesn't match. Every such check goes through it, so that they all count towards the
+// lockout. A locked account can't be signed in to until the lock expires, and the
+// password isn't even checked, so guessing it carries on being pointless; a wrong
+// password is counted as a failed login, which may lock the account.
+func (app *application) checkPassword(w http.ResponseWriter, r *http.Request, user *data.User, password string) bool {
+	if user.IsLocked() {
+		app.accountLockedResponse(w, r, *user.LockedUntil)
+		return false
+	}
+	match, err := user.Password.Matches(password)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
+		return false
 	}
+	if !match {
+		app.failedLoginResponse(w, r, user)
+		return false
+	}
+	return true
+}
+
+// The failedLoginResponse() helper counts a failed login to a user's account, and tells
+// the client the credentials are invalid, or that the account is now locked.
+func (app *application) failedLoginResponse(w http.ResponseWriter, r *http.Request, user *data.User) {
+	locked, until, err := app.recordFailedLogin(r, user)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if locked {
+		app.accountLockedResponse(w, r, until)
+		return
+	}
+	app.invalidCredentialsResponse(w, r)
+}
+
+// The recordFailedLogin() helper counts a failed login to a user's account. If it's
+// the one which locks the account, the user is told by email, and the time the account
+// is locked until is returned.
+func (app *application) recordFailedLogin(r *http.Request, user *data.User) (bool, time.Time, error) {
+	if app.config.auth.lockoutAttempts <= 0 {
+		return false, time.Time{}, nil
+	}
+	until := time.Now().Add(app.config.auth.lockoutDuration)
+	locked, err := app.modelsFor(r).Users.RecordFailedLogin(user.ID, app.config.auth.lockoutAttempts, app.config.auth.lockoutWindow, until)
+	if err != nil || !locked {
+		return false, time.Time{}, err
+	}
+
+	app.requestLogger(r).PrintInfo("account locked", map[string]string{
+		"user_id":      fmt.Sprint(user.ID),
+		"remote_addr":  r.RemoteAddr,
+		"locked_until": until.UTC().Format(time.RFC3339),
+	})
+	app.background(backgroundTask{
+		name:      "account_locked_email",
+		requestID: contextGetRequestID(r.Context()),
+		attempts:  emailTaskAttempts,
+		backoff:   emailTaskBackoff,
+		fn: func() error {
+			data := map[string]any{
+				"name":        user.Name,
+				"attempts":    app.config.auth.lockoutAttempts,
+				"lockedUntil": until.UTC().Format("Mon, 02 Jan 2006 15:04 MST"),
+			}
+			return app.notifier.Send(userRecipient(user), notifyAccountLocked, data)
+		},
+	})
+	return true, until, nil
 }
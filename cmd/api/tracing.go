@@ -0,0 +1,82 @@
+package main
+
+import (
+	"database/sql"
+	"net/http"
+
+	"github.com/lib/pq"
+	"github.com/shyngys9219/greenlight/internal/dbstats"
+	"github.com/shyngys9219/greenlight/internal/tracing"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// With -otel-endpoint set, requests are traced with OpenTelemetry: every request gets a
+// server span, named after its route, with a child span for each of the SQL queries it
+// makes, and every email sent gets a span of its own. The spans are exported over
+// OTLP/HTTP to the collector at the endpoint. A trace started by a client or a proxy
+// in front of the API, with a traceparent header, is carried on.
+
+// The database is opened through a copy of the pq driver which records the spans of the
+// queries, or, in development, one which also collects the statistics of dbstats.go.
+const (
+	tracedDriver             = "postgres-traced"
+	tracedInstrumentedDriver = "postgres-dbstats-traced"
+)
+
+func init() {
+	sql.Register(tracedDriver, tracing.WrapDriver(&pq.Driver{}))
+	sql.Register(tracedInstrumentedDriver, dbstats.Wrap(tracing.WrapDriver(&pq.Driver{})))
+}
+
+// tracerName names the tracer of the request spans.
+const tracerName = "github.com/shyngys9219/greenlight/cmd/api"
+
+// The traceRequests() middleware starts the server span of every request, which is
+// named after the method until handler() renames it after the route, and records the
+// response's status code on it. It's the outermost middleware, so the span covers the
+// whole request, including the authentication and rate limiting queries. It does
+// nothing when tracing is off.
+func (app *application) traceRequests(next http.Handler) http.Handler {
+	if app.config.otel.endpoint == "" {
+		return next
+	}
+	tracer := otel.Tracer(tracerName)
+	propagator := otel.GetTextMapPropagator()
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := propagator.Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		ctx, span := tracer.Start(ctx, r.Method,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				attribute.String("http.request.method", r.Method),
+				attribute.String("url.path", r.URL.Path),
+			),
+		)
+		defer span.End()
+
+		mw := &metricsResponseWriter{ResponseWriter: w}
+		next.ServeHTTP(mw, r.WithContext(ctx))
+
+		status := mw.status()
+		span.SetAttributes(attribute.Int("http.response.status_code", status))
+		if status >= 500 {
+			span.SetStatus(codes.Error, http.StatusText(status))
+		}
+	})
+}
+
+// The traceRoute() middleware names the request's span after the route which handles
+// it, such as "GET /v1/movies", so that the traces of an endpoint can be found
+// together however their paths differ.
+func traceRoute(rt route, next http.Handler) http.Handler {
+	name := rt.method + " " + rt.path
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		span := trace.SpanFromContext(r.Context())
+		span.SetName(name)
+		span.SetAttributes(attribute.String("http.route", rt.path))
+		next.ServeHTTP(w, r)
+	})
+}
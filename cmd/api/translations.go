@@ -0,0 +1,223 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/shyngys9219/greenlight/internal/data"
+	"github.com/shyngys9219/greenlight/internal/validator"
+)
+
+// The acceptedLocales() helper returns the locales movie metadata can be served in for
+// a request, most preferred first, from its Accept-Language header. Regional variants
+// fall back to their language ("ru-RU" to "ru"), and the default locale always comes
+// last.
+func (app *application) acceptedLocales(r *http.Request) []string {
+	type preference struct {
+		locale string
+		q      float64
+	}
+	var prefs []preference
+	for _, part := range strings.Split(r.Header.Get("Accept-Language"), ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		q := 1.0
+		if params = strings.TrimSpace(params); strings.HasPrefix(params, "q=") {
+			var err error
+			q, err = strconv.ParseFloat(strings.TrimPrefix(params, "q="), 64)
+			if err != nil {
+				continue
+			}
+		}
+		language, _, _ := strings.Cut(strings.ToLower(strings.TrimSpace(tag)), "-")
+		if q > 0 && validator.PermittedValue(language, data.Locales...) {
+			prefs = append(prefs, preference{language, q})
+		}
+	}
+	sort.SliceStable(prefs, func(i, j int) bool { return prefs[i].q > prefs[j].q })
+
+	locales := []string{}
+	for _, pref := range append(prefs, preference{locale: data.DefaultLocale}) {
+		if !validator.PermittedValue(pref.locale, locales...) {
+			locales = append(locales, pref.locale)
+		}
+	}
+	return locales
+}
+
+// The resolveLocale() middleware works out the locales a request is served in, most
+// preferred first, and adds them to the request context for the handlers. A locale
+// given in the locale query string parameter comes first, then the one the user chose
+// in their settings, then those of the Accept-Language header, with the default locale
+// last. It must come after authenticate(). JWT users only have the details carried by
+// their token, so their setting isn't known and doesn't count.
+func (app *application) resolveLocale(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var locales []string
+		if qs := r.URL.Query(); qs.Has("locale") {
+			locale := strings.ToLower(qs.Get("locale"))
+			if !validator.PermittedValue(locale, data.Locales...) {
+				app.failedValidationResponse(w, r, map[string]string{"locale": "must be en, kk or ru"})
+				return
+			}
+			locales = append(locales, locale)
+		}
+		if user := app.contextGetUser(r); user.Locale != "" {
+			locales = append(locales, user.Locale)
+		}
+		for _, locale := range app.acceptedLocales(r) {
+			if !validator.PermittedValue(locale, locales...) {
+				locales = append(locales, locale)
+			}
+		}
+
+		next.ServeHTTP(w, app.contextSetLocales(r, locales))
+	})
+}
+
+// The localizeMovies() helper returns the movies localized for the request, whose
+// translations must have been loaded. The response varies with the Accept-Language
+// header, and when there's a single movie its Content-Language header is set to the
+// locale it was localized to, if any.
+func (app *application) localizeMovies(w http.ResponseWriter, r *http.Request, movies ...*data.Movie) []*data.Movie {
+	w.Header().Add("Vary", "Accept-Language")
+	locales := app.contextGetLocales(r)
+
+	localized := make([]*data.Movie, len(movies))
+	for i, movie := range movies {
+		var locale string
+		localized[i], locale = movie.Localize(locales)
+		if len(movies) == 1 && locale != "" {
+			w.Header().Set("Content-Language", locale)
+		}
+	}
+	return localized
+}
+
+// The listMovieTranslationsHandler for the "GET /v1/movies/:id/translations" endpoint
+// returns all the translations of a movie.
+func (app *application) listMovieTranslationsHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readMovieIDParam(r)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	movie, err := app.modelsFor(r).Movies.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.modelsFor(r).MovieTranslations.Load(movie)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"translations": movie.Translations}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The saveMovieTranslationHandler for the "PUT /v1/movies/:id/translations/:locale"
+// endpoint lets curators set a movie's title and overview in a locale.
+func (app *application) saveMovieTranslationHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readMovieIDParam(r)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	var input struct {
+		Title    string `json:"title"`
+		Overview string `json:"overview"`
+	}
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	translation := &data.MovieTranslation{
+		MovieID:  id,
+		Locale:   httprouter.ParamsFromContext(r.Context()).ByName("locale"),
+		Title:    input.Title,
+		Overview: input.Overview,
+	}
+
+	v := validator.New()
+	if data.ValidateMovieTranslation(v, translation); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.modelsFor(r).MovieTranslations.Save(translation)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	app.movieCache.Delete(id)
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"translation": translation}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The deleteMovieTranslationHandler for the "DELETE /v1/movies/:id/translations/:locale"
+// endpoint removes a movie's translation into a locale.
+func (app *application) deleteMovieTranslationHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readMovieIDParam(r)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	locale := httprouter.ParamsFromContext(r.Context()).ByName("locale")
+	err = app.modelsFor(r).MovieTranslations.Delete(id, locale)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	app.movieCache.Delete(id)
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "translation successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
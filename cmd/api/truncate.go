@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/shyngys9219/greenlight/internal/data"
+	"github.com/shyngys9219/greenlight/internal/validator"
+)
+
+// List views rarely show the whole of a movie's overview or a review's text, so clients
+// can ask for them to be shortened with the truncate query string parameter, for example
+// /v1/movies?truncate=200. Fields longer than that many characters are cut at a word
+// boundary and end with an ellipsis, and the record gets a "truncated" object naming the
+// fields and linking to the complete record. The detail endpoints never truncate.
+
+// maxTruncate is the largest length the truncate parameter accepts.
+const maxTruncate = 10000
+
+// The readTruncate() helper reads the truncate query string parameter; 0 means the
+// fields are sent in full.
+func (app *application) readTruncate(qs url.Values, v *validator.Validator) int {
+	n := app.readInt(qs, "truncate", 0, v)
+	v.Check(n >= 0, "truncate", "must not be negative")
+	v.Check(n <= maxTruncate, "truncate", fmt.Sprintf("must not be more than %d", maxTruncate))
+	return n
+}
+
+// truncateText shortens s to at most n characters, not counting the ellipsis, and
+// reports whether it did. The cut is made at the last space before the limit, unless
+// that would lose more than half of the text.
+func truncateText(s string, n int) (string, bool) {
+	if n <= 0 || utf8.RuneCountInString(s) <= n {
+		return s, false
+	}
+	runes := []rune(s)[:n]
+	cut := len(runes)
+	for i := len(runes) - 1; i > n/2; i-- {
+		if unicode.IsSpace(runes[i]) {
+			cut = i
+			break
+		}
+	}
+	return strings.TrimRightFunc(string(runes[:cut]), unicode.IsSpace) + "…", true
+}
+
+// movieHref returns the path of a movie's detail endpoint, by its public ID where it
+// has one.
+func movieHref(movie *data.Movie) string {
+	if movie.PublicID != "" {
+		return "/v1/movies/" + movie.PublicID
+	}
+	return fmt.Sprintf("/v1/movies/%d", movie.ID)
+}
+
+// truncateMovies shortens the overviews of a listing of movies.
+func truncateMovies(movies []*data.Movie, n int) {
+	for _, movie := range movies {
+		var ok bool
+		if movie.Overview, ok = truncateText(movie.Overview, n); ok {
+			movie.Truncated = &data.Truncation{Fields: []string{"overview"}, Href: movieHref(movie)}
+		}
+	}
+}
+
+// truncateReviews shortens the bodies of a listing of the movie's reviews.
+func truncateReviews(movie *data.Movie, reviews []*data.Review, n int) {
+	for _, review := range reviews {
+		var ok bool
+		if review.Body, ok = truncateText(review.Body, n); ok {
+			href := fmt.Sprintf("%s/reviews/%d", movieHref(movie), review.ID)
+			review.Truncated = &data.Truncation{Fields: []string{"body"}, Href: href}
+		}
+	}
+}
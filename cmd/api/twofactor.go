@@ -0,0 +1,217 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/shyngys9219/greenlight/internal/data"
+	"github.com/shyngys9219/greenlight/internal/totp"
+	"github.com/shyngys9219/greenlight/internal/validator"
+)
+
+// The enableTwoFactorHandler for the "POST /v1/users/me/2fa/enable" endpoint starts
+// setting up two-factor authentication for the current user, who has to confirm their
+// password. It returns a new TOTP secret, and the otpauth:// URL for authenticator apps
+// to scan as a QR code. Logins don't ask for codes until the user has shown their app
+// works at "POST /v1/users/me/2fa/verify", and until then enabling again just replaces
+// the secret.
+func (app *application) enableTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	if app.totp == nil {
+		app.twoFactorNotConfiguredResponse(w, r)
+		return
+	}
+	user, ok := app.currentUser(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		CurrentPassword string `json:"current_password"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	v := validator.New()
+	if !app.checkCurrentPassword(w, r, v, user, input.CurrentPassword) {
+		return
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	encrypted, err := app.totp.Encrypt(secret)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.modelsFor(r).TwoFactor.Start(user.ID, encrypted)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrTwoFactorEnabled):
+			app.errorResponse(w, r, http.StatusConflict, err.Error())
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	env := envelope{"two_factor": map[string]string{
+		"secret":      totp.EncodeSecret(secret),
+		"otpauth_url": totp.URL(app.config.totp.issuer, user.Email, secret),
+	}}
+	err = app.writeJSON(w, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The verifyTwoFactorHandler for the "POST /v1/users/me/2fa/verify" endpoint confirms
+// the secret from the enable endpoint with a code from the user's authenticator app, and
+// turns two-factor authentication on. The response holds the user's recovery codes,
+// which are only ever shown this once.
+func (app *application) verifyTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	if app.totp == nil {
+		app.twoFactorNotConfiguredResponse(w, r)
+		return
+	}
+	user := app.contextGetUser(r)
+
+	var input struct {
+		Code string `json:"code"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	v := validator.New()
+	if v.Check(input.Code != "", "code", "must be provided"); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	tf, err := app.modelsFor(r).TwoFactor.Get(user.ID)
+	if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if tf == nil {
+		v.AddError("code", "two-factor authentication must be enabled first")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	if tf.Confirmed {
+		app.errorResponse(w, r, http.StatusConflict, data.ErrTwoFactorEnabled.Error())
+		return
+	}
+	secret, err := app.totp.Decrypt(tf.Secret)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	step, valid := totp.Validate(secret, input.Code, time.Now())
+	if !valid {
+		v.AddError("code", "is invalid or has expired")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	codes, hashes, err := data.NewRecoveryCodes(data.RecoveryCodeCount)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.modelsFor(r).TwoFactor.Confirm(user.ID, step, hashes)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrTwoFactorEnabled):
+			app.errorResponse(w, r, http.StatusConflict, err.Error())
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("code", "two-factor authentication must be enabled first")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	app.requestLogger(r).PrintInfo("two-factor authentication enabled", map[string]string{
+		"user_id": fmt.Sprint(user.ID),
+	})
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"recovery_codes": codes}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The checkSecondFactor() helper checks the two-factor code, or recovery code, of a user
+// with two-factor authentication whose account is being signed in to, or otherwise
+// proven to be someone's, and sends the error response if it doesn't let them in.
+// Clients which didn't send a code are told to ask for it; a wrong one counts as a
+// failed login like a wrong password. Users without two-factor authentication pass.
+func (app *application) checkSecondFactor(w http.ResponseWriter, r *http.Request, user *data.User, code, recoveryCode string) bool {
+	enabled, passed, err := app.checkTwoFactor(r, user, code, recoveryCode)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return false
+	}
+	if enabled && !passed {
+		if code == "" && recoveryCode == "" {
+			app.twoFactorRequiredResponse(w, r)
+			return false
+		}
+		app.failedLoginResponse(w, r, user)
+		return false
+	}
+	return true
+}
+
+// The checkTwoFactor() helper is the second step of logging in, for users with
+// two-factor authentication. It reports whether the user has it turned on, and if so
+// whether the code, or else the recovery code, lets them in. Each code is only accepted
+// once.
+func (app *application) checkTwoFactor(r *http.Request, user *data.User, code, recoveryCode string) (enabled, passed bool, err error) {
+	tf, err := app.modelsFor(r).TwoFactor.Get(user.ID)
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			return false, false, nil
+		}
+		return false, false, err
+	}
+	if !tf.Confirmed {
+		return false, false, nil
+	}
+
+	if code != "" {
+		if app.totp == nil {
+			return true, false, errors.New("a user has two-factor authentication but -totp-key isn't set")
+		}
+		secret, err := app.totp.Decrypt(tf.Secret)
+		if err != nil {
+			return true, false, err
+		}
+		step, valid := totp.Validate(secret, code, time.Now())
+		if !valid {
+			return true, false, nil
+		}
+		passed, err = app.modelsFor(r).TwoFactor.UseStep(user.ID, step)
+		return true, passed, err
+	}
+	if recoveryCode != "" {
+		passed, err = app.modelsFor(r).TwoFactor.UseRecoveryCode(user.ID, recoveryCode)
+		if passed {
+			app.requestLogger(r).PrintInfo("recovery code used", map[string]string{
+				"user_id":     fmt.Sprint(user.ID),
+				"remote_addr": r.RemoteAddr,
+			})
+		}
+		return true, passed, err
+	}
+	return true, false, nil
+}
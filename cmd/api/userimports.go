@@ -0,0 +1,142 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/shyngys9219/greenlight/internal/data"
+	"github.com/shyngys9219/greenlight/internal/validator"
+)
+
+// The importUsersHandler for the "POST /v1/admin/users/import" endpoint creates accounts
+// for users moving over from another system. The body is either a JSON object with a
+// "users" array, or, with a text/csv content type, a CSV file with a header row naming
+// the name, email and (optional) password_hash columns. With activated=true in the
+// query string the accounts are activated straight away; otherwise each user is sent an
+// activation email by the activation_import_emails job, in throttled batches.
+func (app *application) importUsersHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	activated := false
+	if s := r.URL.Query().Get("activated"); s != "" {
+		var err error
+		activated, err = strconv.ParseBool(s)
+		v.Check(err == nil, "activated", "must be true or false")
+	}
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	users, err := app.readUserImport(w, r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v.Check(len(users) > 0, "users", "must contain at least one user")
+	v.Check(len(users) <= data.MaxUserImport, "users", fmt.Sprintf("must not contain more than %d users", data.MaxUserImport))
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	start := time.Now()
+	report, err := app.modelsFor(r).Users.ImportUsers(users, activated)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	activationMetrics.Add("imported", int64(report.Created))
+
+	app.requestLogger(r).PrintInfo("users imported", map[string]string{
+		"admin_id":  fmt.Sprint(app.contextGetUser(r).ID),
+		"created":   fmt.Sprint(report.Created),
+		"skipped":   fmt.Sprint(report.Skipped),
+		"queued":    fmt.Sprint(report.Queued),
+		"errors":    fmt.Sprint(len(report.Errors)),
+		"activated": strconv.FormatBool(activated),
+		"duration":  time.Since(start).String(),
+	})
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"report": report}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The readUserImport() helper reads the users to import from the request body, as JSON
+// or CSV depending on its content type.
+func (app *application) readUserImport(w http.ResponseWriter, r *http.Request) ([]*data.ImportedUser, error) {
+	r.Body = http.MaxBytesReader(w, r.Body, 16<<20)
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != "text/csv" {
+		var input struct {
+			Users []*data.ImportedUser `json:"users"`
+		}
+		err := app.readJSON(w, r, &input)
+		if err != nil {
+			return nil, err
+		}
+		for i, user := range input.Users {
+			if user == nil {
+				return nil, fmt.Errorf("users[%d] must be an object", i)
+			}
+		}
+		return input.Users, nil
+	}
+
+	reader := csv.NewReader(r.Body)
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, errors.New("body must not be empty")
+		}
+		return nil, err
+	}
+	columns := make(map[string]int)
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for _, name := range []string{"name", "email"} {
+		if _, ok := columns[name]; !ok {
+			return nil, fmt.Errorf("CSV header must include a %q column", name)
+		}
+	}
+	field := func(record []string, name string) string {
+		i, ok := columns[name]
+		if !ok {
+			return ""
+		}
+		return strings.TrimSpace(record[i])
+	}
+
+	users := []*data.ImportedUser{}
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, &data.ImportedUser{
+			Name:         field(record, "name"),
+			Email:        field(record, "email"),
+			PasswordHash: field(record, "password_hash"),
+		})
+		if len(users) > data.MaxUserImport {
+			break
+		}
+	}
+	return users, nil
+}
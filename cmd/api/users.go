@@ -2,10 +2,14 @@ package main
 
 import (
 	"errors"
+	"fmt"
 	"net/http"
+	"net/url"
+	"strings"
 	"time"
 
 	"github.com/shyngys9219/greenlight/internal/data"
+	"github.com/shyngys9219/greenlight/internal/events"
 	"github.com/shyngys9219/greenlight/internal/validator"
 )
 
@@ -30,6 +34,7 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 		Name:      input.Name,
 		Email:     input.Email,
 		Activated: false,
+		Active:    true,
 	}
 	// Use the Password.Set() method to generate and store the hashed and plaintext
 	// passwords.
@@ -39,14 +44,19 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 		return
 	}
 	v := validator.New()
+	dryRun := app.readDryRun(r, v)
 	// Validate the user struct and return the error messages to the client if any of
 	// the checks fail.
 	if data.ValidateUser(v, user); !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
+	if dryRun {
+		app.userDryRunResponse(w, r, v, user)
+		return
+	}
 	// Insert the user data into the database.
-	err = app.models.Users.Insert(user)
+	err = app.modelsFor(r).Users.Insert(user)
 	if err != nil {
 		switch {
 		// If we get a ErrDuplicateEmail error, use the v.AddError() method to manually
@@ -61,32 +71,28 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	// token generation to activate account
-	token, err := app.models.Tokens.New(user.ID, 3*24*time.Hour, data.ScopeActivation)
+	// Add the default permissions for the new user, so they can read the catalog.
+	err = app.modelsFor(r).Permissions.AddForUser(user.ID, data.DefaultPermissions...)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
-	// Call the Send() method on our Mailer, passing in the user's email address,
-	// name of the template file, and the User struct containing the new user's data.
-	app.background(func() {
-
-		//
-		data := map[string]any{
-			"activationToken": token.Plaintext,
-			"userID":          user.ID,
-		}
+	err = app.startTrial(r, user)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	app.mergeVisitorHistory(w, r, user)
 
-		// sending context data to template page
-		err = app.mailer.Send(user.Email, "user_welcome.tmpl", data)
-		if err != nil {
-			// Importantly, if there is an error sending the email then we use the
-			// app.logger.PrintError() helper to manage it, instead of the
-			// app.serverErrorResponse() helper like before.
-			app.logger.PrintError(err, nil)
-		}
-	})
+	// Generate an activation token and send it in the welcome email.
+	err = app.sendActivationEmail(user, notifyWelcome, contextGetRequestID(r.Context()))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	activationMetrics.Add("registered", 1)
+	app.publish(events.UserRegistered, user)
 
 	// Write a JSON response containing the user data along with a 201 Created status
 	// code.
@@ -114,33 +120,162 @@ func (app *application) activateUserHandler(w http.ResponseWriter, r *http.Reque
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
-	// Retrieve the details of the user associated with the token using the
-	// GetForToken() method (which we will create in a minute). If no matching record
-	// is found, then we let the client know that the token they provided is not valid.
-	user, err := app.models.Users.GetForToken(data.ScopeActivation, input.TokenPlaintext)
+	user, err := app.activateUser(r, input.TokenPlaintext)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
 			v.AddError("token", "invalid or expired activation token")
 			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
 		default:
 			app.serverErrorResponse(w, r, err)
 		}
 		return
 	}
+	// Send the updated user details to the client in a JSON response.
+	err = app.writeJSON(w, http.StatusOK, envelope{"user": user}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The activateUser() helper activates the account an activation token was issued for,
+// and deletes all of the account's activation tokens. If the token doesn't match an
+// account an ErrRecordNotFound error is returned, and if the account was changed in
+// the meantime an ErrEditConflict error.
+func (app *application) activateUser(r *http.Request, plaintext string) (*data.User, error) {
+	// Retrieve the details of the user associated with the token using the
+	// GetForToken() method. If no matching record is found, it returns the
+	// ErrRecordNotFound error which tells the caller that the token is not valid.
+	user, err := app.modelsFor(r).Users.GetForToken(data.ScopeActivation, plaintext)
+	if err != nil {
+		return nil, err
+	}
 	// Update the user's activation status.
 	user.Activated = true
 
 	// Used for the assignment 4 defence
 	err = user.Password.Set("newpassword")
 	if err != nil {
-		app.serverErrorResponse(w, r, err)
-		return
+		return nil, err
 	}
 
 	// Save the updated user record in our database, checking for any edit conflicts in
 	// the same way that we did for our movie records.
-	err = app.models.Users.Update(user)
+	err = app.modelsFor(r).Users.Update(user)
+	if err != nil {
+		return nil, err
+	}
+	// If everything went successfully, then we delete all activation tokens for the
+	// user.
+	err = app.modelsFor(r).Tokens.DeleteAllForUser(data.ScopeActivation, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	activationMetrics.Add("activated", 1)
+	app.publish(events.UserActivated, user)
+	return user, nil
+}
+
+// The activateUserLinkHandler for the "GET /v1/users/activate?token=..." endpoint is the
+// link in activation emails. It activates the account like the PUT /v1/users/activated
+// endpoint, and then redirects the browser to the frontend's -activation-success-url,
+// or to its -activation-failure-url with a reason query parameter of "invalid_token"
+// or "server_error". Mail scanners which follow links may activate an account this
+// way, which does no harm: it only proves that the email was delivered.
+func (app *application) activateUserLinkHandler(w http.ResponseWriter, r *http.Request) {
+	// The token is in the URL, so don't let it leak to the frontend in the Referer
+	// header or be kept by a cache.
+	w.Header().Set("Referrer-Policy", "no-referrer")
+	w.Header().Set("Cache-Control", "no-store")
+
+	plaintext := r.URL.Query().Get("token")
+	v := validator.New()
+	if data.ValidateTokenPlaintext(v, plaintext); !v.Valid() {
+		app.redirectActivation(w, r, "invalid_token")
+		return
+	}
+
+	_, err := app.activateUser(r, plaintext)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.redirectActivation(w, r, "invalid_token")
+		default:
+			// Log the error without the URL, which has the token in it.
+			app.requestLogger(r).PrintError(err, map[string]string{
+				"request_method": r.Method,
+				"request_path":   r.URL.Path,
+			})
+			app.redirectActivation(w, r, "server_error")
+		}
+		return
+	}
+	app.redirectActivation(w, r, "")
+}
+
+// The redirectActivation() helper ends the activation link: an empty reason redirects
+// to the success page, any other one to the failure page.
+func (app *application) redirectActivation(w http.ResponseWriter, r *http.Request, reason string) {
+	target := app.config.activation.successURL
+	if reason != "" {
+		u, err := url.Parse(app.config.activation.failureURL)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		qs := u.Query()
+		qs.Set("reason", reason)
+		u.RawQuery = qs.Encode()
+		target = u.String()
+	}
+	http.Redirect(w, r, target, http.StatusSeeOther)
+}
+
+// The resetPasswordHandler for the "PUT /v1/users/password" endpoint sets a new password
+// for the user a password reset token was emailed to. Resetting the password also
+// clears a required reset, and logs the user out everywhere by deleting their
+// authentication tokens.
+func (app *application) resetPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Password       string `json:"password"`
+		TokenPlaintext string `json:"token"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	data.ValidatePasswordPlaintext(v, input.Password)
+	data.ValidateTokenPlaintext(v, input.TokenPlaintext)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user, err := app.modelsFor(r).Users.GetForToken(data.ScopePasswordReset, input.TokenPlaintext)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("token", "invalid or expired password reset token")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = user.Password.Set(input.Password)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	user.PasswordResetRequired = false
+
+	err = app.modelsFor(r).Users.Update(user)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrEditConflict):
@@ -150,15 +285,309 @@ func (app *application) activateUserHandler(w http.ResponseWriter, r *http.Reque
 		}
 		return
 	}
-	// If everything went successfully, then we delete all activation tokens for the
-	// user.
-	err = app.models.Tokens.DeleteAllForUser(data.ScopeActivation, user.ID)
+
+	for _, scope := range []string{data.ScopePasswordReset, data.ScopeAuthentication, data.ScopeRefresh} {
+		err = app.modelsFor(r).Tokens.DeleteAllForUser(scope, user.ID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+	// JWTs aren't stored, so the ones issued with the old password are revoked
+	// separately.
+	app.incident.revokeJWTs(time.Now(), user.ID)
+	// Resetting the password proves the user owns the account, so it also lifts a
+	// lockout after failed logins.
+	err = app.modelsFor(r).Users.Unlock(user.ID)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
-	// Send the updated user details to the client in a JSON response.
-	err = app.writeJSON(w, http.StatusOK, envelope{"user": user}, nil)
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "your password was successfully reset"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The showCurrentUserHandler for the "GET /v1/users/me" endpoint returns the current
+// user. The ETag header carries the record's version, which must be sent back in the
+// If-Match header of any update.
+func (app *application) showCurrentUserHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.currentUser(w, r)
+	if !ok {
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("ETag", etag(user.Version))
+
+	err := app.writeJSON(w, http.StatusOK, envelope{"user": user}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The updateCurrentUserHandler for the "PATCH /v1/users/me" endpoint updates the
+// current user's name, email address and preferred locale (an empty locale clears it).
+// Changing the email address requires the current password as well.
+func (app *application) updateCurrentUserHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.currentUser(w, r)
+	if !ok {
+		return
+	}
+	if !app.checkUserPrecondition(w, r, user) {
+		return
+	}
+
+	var input struct {
+		Name            *string `json:"name"`
+		Email           *string `json:"email"`
+		Locale          *string `json:"locale"`
+		CurrentPassword string  `json:"current_password"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	if input.Name != nil {
+		user.Name = *input.Name
+	}
+	if input.Locale != nil {
+		user.Locale = *input.Locale
+	}
+	if input.Email != nil && *input.Email != user.Email {
+		if !app.checkCurrentPassword(w, r, v, user, input.CurrentPassword) {
+			return
+		}
+		user.Email = *input.Email
+	}
+	dryRun := app.readDryRun(r, v)
+	if data.ValidateUser(v, user); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	if dryRun {
+		app.userDryRunResponse(w, r, v, user)
+		return
+	}
+
+	app.saveCurrentUser(w, r, v, user)
+}
+
+// The updatePasswordHandler for the "PUT /v1/users/me/password" endpoint changes the
+// current user's password. Every other session of the user is ended, in case the old
+// password was what let someone else in. A session with an opaque token carries on;
+// JWTs can only be revoked all together, so with JWTs the user logs in again.
+func (app *application) updatePasswordHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.currentUser(w, r)
+	if !ok {
+		return
+	}
+	if !app.checkUserPrecondition(w, r, user) {
+		return
+	}
+
+	var input struct {
+		CurrentPassword string `json:"current_password"`
+		Password        string `json:"password"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	if data.ValidatePasswordPlaintext(v, input.Password); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	if !app.checkCurrentPassword(w, r, v, user, input.CurrentPassword) {
+		return
+	}
+
+	err = user.Password.Set(input.Password)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !app.updateCurrentUser(w, r, v, user) {
+		return
+	}
+
+	current := ""
+	if !app.contextIsJWTUser(r) {
+		_, current, _ = strings.Cut(r.Header.Get("Authorization"), " ")
+	}
+	err = app.modelsFor(r).Tokens.DeleteOtherSessions(user.ID, current)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	app.incident.revokeJWTs(time.Now(), user.ID)
+
+	app.sendCurrentUser(w, r, user)
+}
+
+// The deleteCurrentUserHandler for the "DELETE /v1/users/me" endpoint deletes the
+// current user's account and everything they own. The user confirms their password,
+// and as a destructive operation the deletion needs a confirmation too (see
+// confirmed()).
+func (app *application) deleteCurrentUserHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.currentUser(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		CurrentPassword string `json:"current_password"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	v := validator.New()
+	if !app.checkCurrentPassword(w, r, v, user, input.CurrentPassword) {
+		return
+	}
+	// The password isn't part of the confirmed parameters, so it isn't hashed into
+	// the confirmations table.
+	if !app.confirmed(w, r, "delete_account", nil, nil) {
+		return
+	}
+
+	err = app.modelsFor(r).Users.Delete(user.ID)
+	if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	// The user's tokens went with the account, but JWTs aren't stored, so they're
+	// revoked separately.
+	app.incident.revokeJWTs(time.Now(), user.ID)
+	app.permissionCache.Delete(user.ID)
+
+	app.requestLogger(r).PrintInfo("account deleted", map[string]string{
+		"user_id": fmt.Sprint(user.ID),
+	})
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "your account has been deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The currentUser() helper returns the full record of the current user. The user in
+// the context of requests authenticated with a JWT only has the details carried by the
+// token, so their record is read from the database. If that fails, a response has been
+// sent and ok is false.
+func (app *application) currentUser(w http.ResponseWriter, r *http.Request) (user *data.User, ok bool) {
+	user = app.contextGetUser(r)
+	if !app.contextIsJWTUser(r) {
+		return user, true
+	}
+
+	user, err := app.modelsFor(r).Users.Get(user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.invalidAuthenticationTokenResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+	if !user.Active {
+		app.deactivatedAccountResponse(w, r)
+		return nil, false
+	}
+	return user, true
+}
+
+// The checkUserPrecondition() helper makes sure that an update of the user was based on
+// the current version of the record, see checkPrecondition().
+func (app *application) checkUserPrecondition(w http.ResponseWriter, r *http.Request, user *data.User) bool {
+	return app.checkPrecondition(w, r, etag(user.Version))
+}
+
+// The checkCurrentPassword() helper confirms the user's current password before a
+// sensitive change, sending a failed validation response if it's missing or wrong.
+func (app *application) checkCurrentPassword(w http.ResponseWriter, r *http.Request, v *validator.Validator, user *data.User, current string) bool {
+	if current == "" {
+		v.AddError("current_password", "must be provided")
+		app.failedValidationResponse(w, r, v.Errors)
+		return false
+	}
+	match, err := user.Password.Matches(current)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return false
+	}
+	if !match {
+		v.AddError("current_password", "is incorrect")
+		app.failedValidationResponse(w, r, v.Errors)
+		return false
+	}
+	return true
+}
+
+// The userDryRunResponse() helper ends a dry run of registering or updating a user,
+// failing it if another user has the email address.
+func (app *application) userDryRunResponse(w http.ResponseWriter, r *http.Request, v *validator.Validator, user *data.User) {
+	other, err := app.modelsFor(r).Users.GetByEmail(user.Email)
+	switch {
+	case err == nil && other.ID != user.ID:
+		v.AddError("email", "a user with this email address already exists")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	case err != nil && !errors.Is(err, data.ErrRecordNotFound):
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	app.dryRunResponse(w, r, envelope{"user": user})
+}
+
+// The saveCurrentUser() helper saves the changes made to the current user and sends the
+// updated record, with its new ETag, to the client. The Update() method only succeeds
+// if the version still matches, so a concurrent update which happened after the
+// If-Match check also gets a 412 Precondition Failed response.
+func (app *application) saveCurrentUser(w http.ResponseWriter, r *http.Request, v *validator.Validator, user *data.User) {
+	if app.updateCurrentUser(w, r, v, user) {
+		app.sendCurrentUser(w, r, user)
+	}
+}
+
+// The updateCurrentUser() helper is the first half of saveCurrentUser(): it saves the
+// user, and reports whether it did, having sent the error response if it didn't.
+func (app *application) updateCurrentUser(w http.ResponseWriter, r *http.Request, v *validator.Validator, user *data.User) bool {
+	err := app.modelsFor(r).Users.Update(user)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateEmail):
+			v.AddError("email", "a user with this email address already exists")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrEditConflict):
+			app.preconditionFailedResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return false
+	}
+	return true
+}
+
+// The sendCurrentUser() helper is the second half of saveCurrentUser(): it sends the
+// saved user, with its new ETag.
+func (app *application) sendCurrentUser(w http.ResponseWriter, r *http.Request, user *data.User) {
+	headers := make(http.Header)
+	headers.Set("ETag", etag(user.Version))
+
+	err := app.writeJSON(w, http.StatusOK, envelope{"user": user}, headers)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
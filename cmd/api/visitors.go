@@ -0,0 +1,151 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/shyngys9219/greenlight/internal/data"
+)
+
+// visitorCookie is the name of the cookie holding the ID of an anonymous visitor who
+// opted in to personalization. It's only ever set at the visitor's request.
+const visitorCookie = "greenlight_visitor"
+
+// The readVisitor() helper returns the hash of the request's visitor ID, or nil if the
+// request has none. Signed in users are never tracked as visitors.
+func (app *application) readVisitor(r *http.Request) []byte {
+	if !app.contextGetUser(r).IsAnonymous() {
+		return nil
+	}
+	cookie, err := r.Cookie(visitorCookie)
+	if err != nil || len(cookie.Value) != 26 {
+		return nil
+	}
+	return data.HashVisitorID(cookie.Value)
+}
+
+// The setVisitorCookie() helper sets the visitor cookie, or clears it if id is empty.
+// It's kept out of reach of scripts and other sites, and expires with the history it
+// identifies.
+func (app *application) setVisitorCookie(w http.ResponseWriter, id string) {
+	cookie := &http.Cookie{
+		Name:     visitorCookie,
+		Value:    id,
+		Path:     "/",
+		MaxAge:   int(app.config.visitors.retention.Seconds()),
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+	}
+	if id == "" {
+		cookie.MaxAge = -1
+	}
+	http.SetCookie(w, cookie)
+}
+
+// The recordVisitorView() helper records in the background that the request's visitor
+// viewed a movie, if the visitor opted in to personalization. Nothing is recorded in
+// read-only mode.
+func (app *application) recordVisitorView(r *http.Request, movieID int64) {
+	hash := app.readVisitor(r)
+	if hash == nil || app.readOnly.active() {
+		return
+	}
+	app.background(backgroundTask{
+		name:      "visitor_view",
+		requestID: contextGetRequestID(r.Context()),
+		fn: func() error {
+			return app.models.Visitors.RecordView(hash, movieID)
+		},
+	})
+}
+
+// The mergeVisitorHistory() helper moves the browsing history of the request's visitor
+// over to a user who has just signed up, to seed their recommendations, and clears the
+// visitor cookie. Failing to merge isn't worth failing the signup over. New users
+// haven't decided on personalization yet, so the history is only kept if it's
+// consented to by default.
+func (app *application) mergeVisitorHistory(w http.ResponseWriter, r *http.Request, user *data.User) {
+	hash := app.readVisitor(r)
+	if hash == nil || !app.consentDefault(data.ConsentPersonalization) {
+		return
+	}
+	merged, err := app.modelsFor(r).Visitors.MergeIntoUser(hash, user.ID)
+	if err != nil {
+		app.requestLogger(r).PrintError(err, map[string]string{"during": "merging visitor history"})
+		return
+	}
+	app.setVisitorCookie(w, "")
+	app.requestLogger(r).PrintInfo("visitor history merged", map[string]string{
+		"user_id": fmt.Sprint(user.ID),
+		"views":   fmt.Sprint(merged),
+	})
+}
+
+// The createVisitorHandler for the "POST /v1/visitors" endpoint opts an anonymous
+// visitor in to personalization: it sets a cookie with a new visitor ID, under which the
+// movies they view are recorded until they sign up, opt out, or the views are older
+// than the retention period.
+func (app *application) createVisitorHandler(w http.ResponseWriter, r *http.Request) {
+	if !app.contextGetUser(r).IsAnonymous() {
+		app.errorResponse(w, r, http.StatusConflict, "signed in users are not tracked as visitors")
+		return
+	}
+
+	id, _, err := data.NewVisitorID()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	app.setVisitorCookie(w, id)
+
+	env := envelope{"visitor": envelope{
+		"retention":  app.config.visitors.retention.String(),
+		"expires_at": time.Now().Add(app.config.visitors.retention).UTC(),
+	}}
+	err = app.writeJSON(w, http.StatusCreated, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The showVisitorHistoryHandler for the "GET /v1/visitors/history" endpoint shows a
+// visitor what has been recorded about them.
+func (app *application) showVisitorHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	hash := app.readVisitor(r)
+	if hash == nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	views, err := app.modelsFor(r).Visitors.GetHistory(hash)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"history": views}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The deleteVisitorHandler for the "DELETE /v1/visitors" endpoint opts a visitor out:
+// their history is deleted and the cookie cleared.
+func (app *application) deleteVisitorHandler(w http.ResponseWriter, r *http.Request) {
+	hash := app.readVisitor(r)
+	if hash != nil {
+		err := app.modelsFor(r).Visitors.DeleteHistory(hash)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+	app.setVisitorCookie(w, "")
+
+	err := app.writeJSON(w, http.StatusOK, envelope{"message": "browsing history deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/shyngys9219/greenlight/internal/data"
+	"github.com/shyngys9219/greenlight/internal/validator"
+)
+
+// The listUserWatchlistHandler for the "GET /v1/users/me/watchlist" endpoint shows a
+// page of the current user's watchlist, the most recently saved movies first unless
+// another sort order is asked for. With watched=true or watched=false in the query
+// string only the movies which have or haven't been watched are listed.
+func (app *application) listUserWatchlistHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	qs := r.URL.Query()
+
+	var watched *bool
+	if s := qs.Get("watched"); s != "" {
+		b, err := strconv.ParseBool(s)
+		v.Check(err == nil, "watched", "must be true or false")
+		watched = &b
+	}
+	filters := data.Filters{
+		Page:         app.readInt(qs, "page", 1, v),
+		PageSize:     app.readInt(qs, "page_size", 20, v),
+		Sort:         app.readString(qs, "sort", "-added_at"),
+		SortSafelist: data.WatchlistSortSafelist,
+	}
+	if data.ValidateListFilters(v, filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user := app.contextGetUser(r)
+	items, metadata, err := app.modelsFor(r).Watchlist.GetAllForUser(user.ID, watched, filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"watchlist": items, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The addToUserWatchlistHandler for the "POST /v1/users/me/watchlist" endpoint saves a
+// movie to the current user's watchlist. Saving a movie which is already on it isn't an
+// error, and doesn't change whether it has been watched.
+func (app *application) addToUserWatchlistHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		MovieID string `json:"movie_id"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	item := &data.WatchlistItem{}
+	item.MovieID, err = app.resolveMovieID(r, input.MovieID)
+	if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if data.ValidateWatchlistItem(v, item); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user := app.contextGetUser(r)
+	err = app.modelsFor(r).Watchlist.Add(user.ID, item)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("movie_id", "must be an existing movie")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"item": item}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The updateUserWatchlistHandler for the "PATCH /v1/users/me/watchlist/:movie_id"
+// endpoint marks a movie on the current user's watchlist as watched, or not.
+func (app *application) updateUserWatchlistHandler(w http.ResponseWriter, r *http.Request) {
+	movieID, err := app.resolveMovieID(r, httprouter.ParamsFromContext(r.Context()).ByName("movie_id"))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	var input struct {
+		Watched *bool `json:"watched"`
+	}
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	if v.Check(input.Watched != nil, "watched", "must be provided"); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user := app.contextGetUser(r)
+	item, err := app.modelsFor(r).Watchlist.SetWatched(user.ID, movieID, *input.Watched)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"item": item}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The removeFromUserWatchlistHandler for the "DELETE /v1/users/me/watchlist/:movie_id"
+// endpoint takes a movie off the current user's watchlist.
+func (app *application) removeFromUserWatchlistHandler(w http.ResponseWriter, r *http.Request) {
+	movieID, err := app.resolveMovieID(r, httprouter.ParamsFromContext(r.Context()).ByName("movie_id"))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	user := app.contextGetUser(r)
+	err = app.modelsFor(r).Watchlist.Remove(user.ID, movieID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "movie successfully removed from the watchlist"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
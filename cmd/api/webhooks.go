@@ -0,0 +1,369 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"expvar"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/shyngys9219/greenlight/internal/data"
+	"github.com/shyngys9219/greenlight/internal/events"
+	"github.com/shyngys9219/greenlight/internal/validator"
+)
+
+// Partners subscribe to the changes of movies' availability and release dates with a
+// webhook of their organization, usually made with one of its API keys, optionally
+// filtered to some genres and regions. Each change is rendered into a payload for every
+// matching subscription, which only describes the movie's public details and the one
+// region which changed, and queued in the webhook_deliveries table. The deliveries are
+// made by a pool of -webhook-workers workers on every replica, signed with the
+// subscription's secret (see internal/webhook), and retried with exponential backoff
+// like the emails in emailqueue.go, until -webhook-max-attempts attempts have failed.
+
+const (
+	// webhookPollInterval is how often idle workers look for deliveries which are due.
+	webhookPollInterval = 5 * time.Second
+	// webhookLease is how long a worker has to make a delivery it claimed, before
+	// another one may claim it again.
+	webhookLease = time.Minute
+	// webhookRetryBackoff is the delay before the first retry of a failed delivery,
+	// doubled for every retry after it up to webhookMaxBackoff.
+	webhookRetryBackoff = 30 * time.Second
+	webhookMaxBackoff   = 6 * time.Hour
+	// webhookRecentDeliveries is how many deliveries are shown by the deliveries
+	// endpoint.
+	webhookRecentDeliveries = 50
+)
+
+// webhookMetrics counts what happens to webhook deliveries: how many were queued, made,
+// failed an attempt, and were dead-lettered.
+var webhookMetrics = expvar.NewMap("webhooks")
+
+// webhookAvailability is a movie's availability in a region, as it's delivered.
+type webhookAvailability struct {
+	Available   bool    `json:"available"`
+	ReleaseDate *string `json:"release_date"`
+}
+
+func newWebhookAvailability(a *data.MovieAvailability) webhookAvailability {
+	if a == nil {
+		return webhookAvailability{}
+	}
+	return webhookAvailability{Available: a.Available, ReleaseDate: a.ReleaseDate}
+}
+
+// webhookPayload is the body of a delivery.
+type webhookPayload struct {
+	Event          string    `json:"event"`
+	SubscriptionID int64     `json:"subscription_id"`
+	OccurredAt     time.Time `json:"occurred_at"`
+	Movie          struct {
+		ID       int64    `json:"id"`
+		PublicID string   `json:"public_id"`
+		Title    string   `json:"title"`
+		Year     int32    `json:"year,omitempty"`
+		Genres   []string `json:"genres,omitempty"`
+	} `json:"movie"`
+	Region       string              `json:"region"`
+	Availability webhookAvailability `json:"availability"`
+	Previous     webhookAvailability `json:"previous"`
+}
+
+// The queueAvailabilityWebhooks() subscriber queues the deliveries of an
+// events.AvailabilityChanged event, as a movie.availability_changed event if the movie
+// became available or unavailable, and a movie.release_date_changed one if its release
+// date changed.
+func (app *application) queueAvailabilityWebhooks(e events.Event) error {
+	change := e.Payload.(*availabilityChange)
+	before, after := newWebhookAvailability(change.Before), newWebhookAvailability(change.After)
+
+	var changed []string
+	if before.Available != after.Available {
+		changed = append(changed, data.WebhookAvailabilityChanged)
+	}
+	if !sameDate(before.ReleaseDate, after.ReleaseDate) {
+		changed = append(changed, data.WebhookReleaseDateChanged)
+	}
+
+	queued := false
+	for _, event := range changed {
+		subs, err := app.models.Webhooks.GetMatching(event, change.Movie.Genres, change.Region)
+		if err != nil {
+			return err
+		}
+		for _, sub := range subs {
+			payload := webhookPayload{
+				Event:          event,
+				SubscriptionID: sub.ID,
+				OccurredAt:     e.Time.UTC(),
+				Region:         change.Region,
+				Availability:   after,
+				Previous:       before,
+			}
+			payload.Movie.ID = change.Movie.ID
+			payload.Movie.PublicID = change.Movie.PublicID
+			payload.Movie.Title = change.Movie.Title
+			payload.Movie.Year = change.Movie.Year
+			payload.Movie.Genres = change.Movie.Genres
+
+			js, err := json.Marshal(payload)
+			if err != nil {
+				return err
+			}
+			err = app.models.WebhookDeliveries.Insert(&data.WebhookDelivery{
+				SubscriptionID: sub.ID,
+				Event:          event,
+				Payload:        js,
+			})
+			if err != nil {
+				return err
+			}
+			webhookMetrics.Add("queued", 1)
+			queued = true
+		}
+	}
+
+	if queued {
+		select {
+		case app.webhookKick <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+// sameDate reports whether two optional dates are the same.
+func sameDate(a, b *string) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+// The startWebhookWorkers() method starts the workers which make the queued
+// deliveries, as background tasks like the email workers.
+func (app *application) startWebhookWorkers() {
+	for i := 0; i < app.config.webhooks.workers; i++ {
+		app.background(backgroundTask{name: "webhook_worker", fn: app.runWebhookWorker})
+	}
+}
+
+// The runWebhookWorker() method makes deliveries one at a time until none are due, then
+// waits to be woken up by a new delivery or the poll interval.
+func (app *application) runWebhookWorker() error {
+	ticker := time.NewTicker(webhookPollInterval)
+	defer ticker.Stop()
+	for {
+		for {
+			deliveries, err := app.models.WebhookDeliveries.Claim(1, webhookLease)
+			if err != nil {
+				app.logger.PrintError(err, map[string]string{"task": "webhook_worker"})
+				break
+			}
+			if len(deliveries) == 0 {
+				break
+			}
+			app.makeWebhookDelivery(deliveries[0])
+
+			select {
+			case <-app.stopJobs:
+				return nil
+			default:
+			}
+		}
+
+		select {
+		case <-ticker.C:
+		case <-app.webhookKick:
+		case <-app.stopJobs:
+			return nil
+		}
+	}
+}
+
+// The makeWebhookDelivery() method makes an attempt to deliver a claimed delivery, and
+// records the outcome.
+func (app *application) makeWebhookDelivery(d *data.WebhookDelivery) {
+	err := app.webhookSender.Send(d.URL, d.Secret, d.Event, d.ID, d.Payload)
+	if err == nil {
+		webhookMetrics.Add("delivered", 1)
+		err = app.models.WebhookDeliveries.MarkDelivered(d.ID)
+		if err != nil {
+			app.logger.PrintError(err, map[string]string{"delivery_id": fmt.Sprint(d.ID)})
+		}
+		return
+	}
+
+	webhookMetrics.Add("failed_attempts", 1)
+	properties := map[string]string{
+		"delivery_id":     fmt.Sprint(d.ID),
+		"subscription_id": fmt.Sprint(d.SubscriptionID),
+		"event":           d.Event,
+		"attempts":        fmt.Sprint(d.Attempts),
+	}
+	if d.Attempts >= app.config.webhooks.maxAttempts {
+		webhookMetrics.Add("dead_lettered", 1)
+		properties["dead_lettered"] = "true"
+		app.logger.PrintInfo(fmt.Sprintf("delivering webhook failed: %s", err), properties)
+		err = app.models.WebhookDeliveries.DeadLetter(d.ID, err.Error())
+	} else {
+		next := time.Now().Add(webhookBackoff(d.Attempts))
+		properties["next_attempt_at"] = next.UTC().Format(time.RFC3339)
+		app.logger.PrintInfo(fmt.Sprintf("delivering webhook failed: %s", err), properties)
+		err = app.models.WebhookDeliveries.Retry(d.ID, next, err.Error())
+	}
+	if err != nil {
+		app.logger.PrintError(err, map[string]string{"delivery_id": fmt.Sprint(d.ID)})
+	}
+}
+
+// webhookBackoff returns how long to wait before the next attempt of a delivery which
+// has failed the given number of attempts.
+func webhookBackoff(attempts int) time.Duration {
+	backoff := webhookRetryBackoff
+	for i := 1; i < attempts && backoff < webhookMaxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > webhookMaxBackoff {
+		backoff = webhookMaxBackoff
+	}
+	return backoff
+}
+
+// The deleteOldWebhookDeliveries() job deletes deliveries a week after they're made,
+// and dead letters after a month.
+func (app *application) deleteOldWebhookDeliveries() error {
+	now := time.Now()
+	return app.models.WebhookDeliveries.DeleteBefore(now.AddDate(0, 0, -7), now.AddDate(0, -1, 0))
+}
+
+// The listWebhooksHandler for the "GET /v1/orgs/:org/webhooks" endpoint returns an
+// organization's webhook subscriptions, without their secrets.
+func (app *application) listWebhooksHandler(w http.ResponseWriter, r *http.Request) {
+	access := app.contextGetOrgAccess(r)
+	subs, err := app.modelsFor(r).Webhooks.GetAllForOrganization(access.org.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"webhooks": subs}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The createWebhookHandler for the "POST /v1/orgs/:org/webhooks" endpoint subscribes an
+// organization's URL to some of the webhook events, for the movies in any of the given
+// genres and regions (all of them if none are given). The response holds the secret
+// the deliveries are signed with, which isn't shown again. A subscription made with an
+// API key is removed when the key is revoked.
+func (app *application) createWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		URL     string   `json:"url"`
+		Events  []string `json:"events"`
+		Genres  []string `json:"genres"`
+		Regions []string `json:"regions"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	sub := &data.WebhookSubscription{
+		OrganizationID: app.contextGetOrgAccess(r).org.ID,
+		URL:            input.URL,
+		Events:         input.Events,
+		Genres:         input.Genres,
+		Regions:        input.Regions,
+	}
+	if sub.Genres == nil {
+		sub.Genres = []string{}
+	}
+	if sub.Regions == nil {
+		sub.Regions = []string{}
+	}
+	if key := app.contextGetAPIKey(r); key != nil {
+		sub.APIKeyID = &key.ID
+	}
+
+	v := validator.New()
+	if data.ValidateWebhookSubscription(v, sub); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.modelsFor(r).Webhooks.Insert(sub)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"webhook": sub}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The deleteWebhookHandler for the "DELETE /v1/orgs/:org/webhooks/:webhook_id" endpoint
+// removes one of an organization's subscriptions. Its pending deliveries aren't made.
+func (app *application) deleteWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readNamedIDParam(r, "webhook_id")
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.modelsFor(r).Webhooks.Delete(app.contextGetOrgAccess(r).org.ID, id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "webhook successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The listWebhookDeliveriesHandler for the "GET /v1/orgs/:org/webhooks/:webhook_id/deliveries"
+// endpoint shows the most recent deliveries of a subscription, with the outcome of
+// their last attempt, so that partners can see why events didn't reach them.
+func (app *application) listWebhookDeliveriesHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readNamedIDParam(r, "webhook_id")
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	orgID := app.contextGetOrgAccess(r).org.ID
+	_, err = app.modelsFor(r).Webhooks.Get(orgID, id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	deliveries, err := app.modelsFor(r).WebhookDeliveries.GetRecent(orgID, id, webhookRecentDeliveries)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"deliveries": deliveries}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
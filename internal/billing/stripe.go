@@ -0,0 +1,207 @@
+// Package billing talks to Stripe, the billing provider for paid plans. It only covers
+// what the API needs: creating checkout sessions and reading webhook events. There is
+// no Stripe SDK dependency; the few calls are made over plain HTTP.
+package billing
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Source is recorded as the source of plans assigned by Stripe.
+const Source = "stripe"
+
+// SignatureTolerance is how old a webhook's signature may be before it's rejected, to
+// stop old events being replayed.
+const SignatureTolerance = 5 * time.Minute
+
+var (
+	ErrInvalidSignature = errors.New("invalid webhook signature")
+	ErrNotConfigured    = errors.New("billing is not configured")
+)
+
+// Stripe is a minimal Stripe API client.
+type Stripe struct {
+	secretKey     string
+	webhookSecret string
+	client        *http.Client
+	baseURL       string
+}
+
+func NewStripe(secretKey, webhookSecret string) *Stripe {
+	return &Stripe{
+		secretKey:     secretKey,
+		webhookSecret: webhookSecret,
+		client:        &http.Client{Timeout: 10 * time.Second},
+		baseURL:       "https://api.stripe.com/v1",
+	}
+}
+
+// CheckoutParams describes a subscription checkout. Reference identifies the account
+// which is subscribing; it's attached to the session and the subscription, and comes
+// back in their webhook events.
+type CheckoutParams struct {
+	PriceID    string
+	Reference  string
+	CustomerID string // an existing customer, if the account has subscribed before
+	Email      string // prefilled for new customers
+	SuccessURL string
+	CancelURL  string
+}
+
+// A CheckoutSession is a Stripe-hosted payment page.
+type CheckoutSession struct {
+	ID  string `json:"id"`
+	URL string `json:"url"`
+}
+
+// CreateCheckoutSession creates a subscription checkout session.
+func (s *Stripe) CreateCheckoutSession(p CheckoutParams) (*CheckoutSession, error) {
+	if s.secretKey == "" {
+		return nil, ErrNotConfigured
+	}
+
+	form := url.Values{}
+	form.Set("mode", "subscription")
+	form.Set("line_items[0][price]", p.PriceID)
+	form.Set("line_items[0][quantity]", "1")
+	form.Set("success_url", p.SuccessURL)
+	form.Set("cancel_url", p.CancelURL)
+	form.Set("client_reference_id", p.Reference)
+	form.Set("metadata[account]", p.Reference)
+	form.Set("subscription_data[metadata][account]", p.Reference)
+	if p.CustomerID != "" {
+		form.Set("customer", p.CustomerID)
+	} else if p.Email != "" {
+		form.Set("customer_email", p.Email)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, s.baseURL+"/checkout/sessions", strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.SetBasicAuth(s.secretKey, "")
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	res, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		var body struct {
+			Error struct {
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		_ = json.NewDecoder(res.Body).Decode(&body)
+		return nil, fmt.Errorf("stripe: creating checkout session: %s: %s", res.Status, body.Error.Message)
+	}
+
+	var session CheckoutSession
+	err = json.NewDecoder(res.Body).Decode(&session)
+	if err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// An Event is a webhook event. Object holds the fields of the event's object which
+// the API uses; which ones are set depends on the type of the object.
+type Event struct {
+	ID   string `json:"id"`
+	Type string `json:"type"`
+	Data struct {
+		Object Object `json:"object"`
+	} `json:"data"`
+}
+
+type Object struct {
+	ID                string            `json:"id"`
+	Object            string            `json:"object"` // checkout.session, subscription or invoice
+	Customer          string            `json:"customer"`
+	ClientReferenceID string            `json:"client_reference_id"`
+	Status            string            `json:"status"`
+	Metadata          map[string]string `json:"metadata"`
+	Items             struct {
+		Data []struct {
+			Price struct {
+				ID string `json:"id"`
+			} `json:"price"`
+		} `json:"data"`
+	} `json:"items"`
+}
+
+// HasPrice reports whether a subscription object includes the given price.
+func (o *Object) HasPrice(priceID string) bool {
+	for _, item := range o.Items.Data {
+		if item.Price.ID == priceID {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseEvent verifies the signature of a webhook payload, given the value of its
+// Stripe-Signature header, and decodes the event. Payloads with a missing, invalid or
+// expired signature return ErrInvalidSignature.
+func (s *Stripe) ParseEvent(payload []byte, header string, now time.Time) (*Event, error) {
+	if s.webhookSecret == "" {
+		return nil, ErrNotConfigured
+	}
+
+	var timestamp string
+	var signatures []string
+	for _, part := range strings.Split(header, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+		switch key {
+		case "t":
+			timestamp = value
+		case "v1":
+			signatures = append(signatures, value)
+		}
+	}
+
+	t, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil || len(signatures) == 0 {
+		return nil, ErrInvalidSignature
+	}
+	if age := now.Sub(time.Unix(t, 0)); age > SignatureTolerance || age < -SignatureTolerance {
+		return nil, ErrInvalidSignature
+	}
+
+	mac := hmac.New(sha256.New, []byte(s.webhookSecret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(payload)
+	expected := mac.Sum(nil)
+
+	valid := false
+	for _, signature := range signatures {
+		sig, err := hex.DecodeString(signature)
+		if err == nil && hmac.Equal(sig, expected) {
+			valid = true
+			break
+		}
+	}
+	if !valid {
+		return nil, ErrInvalidSignature
+	}
+
+	var event Event
+	err = json.Unmarshal(payload, &event)
+	if err != nil {
+		return nil, err
+	}
+	return &event, nil
+}
@@ -0,0 +1,144 @@
+package cache
+
+import (
+	"sync"
+	"time"
+)
+
+// A Policy controls how long cached values are used. A value is fresh for TTL after it
+// was loaded, and is then served stale for up to StaleFor more while it's refreshed in
+// the background. After that it has expired and the next Get loads it again before
+// returning.
+type Policy struct {
+	TTL      time.Duration
+	StaleFor time.Duration
+}
+
+type entry[V any] struct {
+	value    V
+	loadedAt time.Time
+}
+
+// Cache is an in-memory cache with stale-while-revalidate semantics: callers are served
+// from the cache right away for as long as the value is fresh or stale, and only wait
+// on the loader when the value is missing or has expired. That keeps latency flat
+// while the backing store is slow. It's safe for concurrent use.
+type Cache[K comparable, V any] struct {
+	policy Policy
+	// Background is used to run refreshes. It defaults to starting a plain goroutine,
+	// and can be replaced so refreshes are tracked like other background work.
+	Background func(fn func())
+
+	mu         sync.Mutex
+	entries    map[K]*entry[V]
+	refreshing map[K]bool
+}
+
+// New returns an empty Cache using the given policy. A policy with a zero TTL disables
+// caching, so every Get calls the loader.
+func New[K comparable, V any](policy Policy) *Cache[K, V] {
+	return &Cache[K, V]{
+		policy:     policy,
+		Background: func(fn func()) { go fn() },
+		entries:    make(map[K]*entry[V]),
+		refreshing: make(map[K]bool),
+	}
+}
+
+// Get returns the value for key, calling load when it isn't cached or has expired. If
+// the cached value is stale it's returned as is, and a single background refresh is
+// started. Errors from load are never cached; if a background refresh fails the stale
+// value is kept until it expires.
+func (c *Cache[K, V]) Get(key K, load func() (V, error)) (V, error) {
+	if c.policy.TTL <= 0 {
+		return load()
+	}
+
+	c.mu.Lock()
+	e, ok := c.entries[key]
+	if ok {
+		age := time.Since(e.loadedAt)
+		switch {
+		case age < c.policy.TTL:
+			c.mu.Unlock()
+			return e.value, nil
+		case age < c.policy.TTL+c.policy.StaleFor:
+			if !c.refreshing[key] {
+				c.refreshing[key] = true
+				c.Background(func() { c.refresh(key, load) })
+			}
+			c.mu.Unlock()
+			return e.value, nil
+		}
+	}
+	c.mu.Unlock()
+
+	value, err := load()
+	if err != nil {
+		return value, err
+	}
+	c.Set(key, value)
+	return value, nil
+}
+
+func (c *Cache[K, V]) refresh(key K, load func() (V, error)) {
+	var (
+		value  V
+		loaded bool
+	)
+	// The deferred function also runs if load panics, so the key doesn't stay marked
+	// as refreshing forever.
+	defer func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		// If the key was deleted while we were loading, the value we have may be from
+		// before the change which caused the delete, so we throw it away.
+		if !c.refreshing[key] {
+			return
+		}
+		delete(c.refreshing, key)
+		if loaded {
+			c.entries[key] = &entry[V]{value: value, loadedAt: time.Now()}
+		}
+	}()
+
+	value, err := load()
+	loaded = err == nil
+}
+
+// Set stores a freshly loaded value for key.
+func (c *Cache[K, V]) Set(key K, value V) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[key] = &entry[V]{value: value, loadedAt: time.Now()}
+}
+
+// Delete removes key from the cache, so the next Get loads it again. It should be
+// called whenever the underlying record changes.
+func (c *Cache[K, V]) Delete(key K) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.entries, key)
+	delete(c.refreshing, key)
+}
+
+// Clear removes every entry, for changes which affect many of the underlying records
+// at once.
+func (c *Cache[K, V]) Clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries = make(map[K]*entry[V])
+	c.refreshing = make(map[K]bool)
+}
+
+// Prune removes every expired entry. It's meant to be called periodically so values
+// which are no longer requested don't stay in memory forever.
+func (c *Cache[K, V]) Prune() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key, e := range c.entries {
+		if time.Since(e.loadedAt) >= c.policy.TTL+c.policy.StaleFor {
+			delete(c.entries, key)
+		}
+	}
+}
@@ -0,0 +1,264 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/shyngys9219/greenlight/internal/validator"
+)
+
+// The statuses of a restricted account. A held account can't write until an admin
+// lifts the restriction; a shadow-limited one can, but its new reviews are hidden from
+// everyone but itself. An exempt account is never restricted automatically.
+const (
+	RestrictionHeld          = "held"
+	RestrictionShadowLimited = "shadow_limited"
+	RestrictionExempt        = "exempt"
+)
+
+// AccountRestrictionsSortSafelist holds the sort values supported by the listing of
+// restricted accounts.
+var AccountRestrictionsSortSafelist = []string{"updated_at", "-updated_at"}
+
+// An AccountRestriction is the restriction of a user's account, set automatically when
+// they write faster than the thresholds allow, or by an admin.
+type AccountRestriction struct {
+	UserID       int64     `json:"-"`
+	UserPublicID string    `json:"user_public_id"`
+	UserEmail    string    `json:"user_email"`
+	Status       string    `json:"status"`
+	Reason       string    `json:"reason"`
+	Automatic    bool      `json:"automatic"`
+	CreatedBy    *int64    `json:"created_by"`
+	UpdatedAt    time.Time `json:"updated_at"`
+	// RecentWrites counts the user's recorded writes by kind, such as "review".
+	RecentWrites map[string]int `json:"recent_writes"`
+}
+
+func ValidateAccountRestriction(v *validator.Validator, restriction *AccountRestriction) {
+	v.Check(validator.PermittedValue(restriction.Status, RestrictionHeld, RestrictionShadowLimited, RestrictionExempt), "status", "must be held, shadow_limited or exempt")
+	v.Check(len(restriction.Reason) <= 500, "reason", "must not be more than 500 bytes long")
+}
+
+// AbuseModel wraps the connection pool for the write_events and account_restrictions
+// tables.
+type AbuseModel struct {
+	queryScope
+	DB *sql.DB
+}
+
+// RecordWrite records a write of the kind by a user, and returns how many writes of
+// that kind they have made since the given time, counting this one.
+func (m AbuseModel) RecordWrite(userID int64, kind string, since time.Time) (int, error) {
+	// The count doesn't see the row the same statement inserts, hence the + 1.
+	query := `
+		WITH recorded AS (
+			INSERT INTO write_events (user_id, kind) VALUES ($1, $2)
+		)
+		SELECT count(*) + 1 FROM write_events
+		WHERE user_id = $1 AND kind = $2 AND created_at >= $3`
+
+	ctx, cancel := context.WithTimeout(m.context(), 3*time.Second)
+	defer cancel()
+
+	var count int
+	err := m.DB.QueryRowContext(ctx, query, userID, kind, since).Scan(&count)
+	return count, err
+}
+
+// GetRestriction returns the status of a user's restriction, or ErrRecordNotFound if
+// the account isn't restricted.
+func (m AbuseModel) GetRestriction(userID int64) (string, error) {
+	ctx, cancel := context.WithTimeout(m.context(), 3*time.Second)
+	defer cancel()
+
+	var status string
+	err := m.DB.QueryRowContext(ctx, `SELECT status FROM account_restrictions WHERE user_id = $1`, userID).Scan(&status)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return "", ErrRecordNotFound
+		default:
+			return "", err
+		}
+	}
+	return status, nil
+}
+
+// Restrict restricts a user's account automatically, unless it has a restriction
+// already, which is left as it is. It reports whether the account was restricted.
+func (m AbuseModel) Restrict(userID int64, status, reason string) (bool, error) {
+	query := `
+		INSERT INTO account_restrictions (user_id, status, reason, automatic)
+		VALUES ($1, $2, $3, true)
+		ON CONFLICT (user_id) DO NOTHING`
+
+	ctx, cancel := context.WithTimeout(m.context(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, userID, status, reason)
+	if err != nil {
+		return false, err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rowsAffected > 0, nil
+}
+
+// Set sets the restriction of a user's account on an admin's behalf, replacing the one
+// it has. Unless the account stays shadow-limited, its hidden reviews are shown again.
+// The previous status is returned, or "" if there was none.
+func (m AbuseModel) Set(restriction *AccountRestriction) (string, error) {
+	ctx, cancel := context.WithTimeout(m.context(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	var previous string
+	query := `SELECT status FROM account_restrictions WHERE user_id = $1 FOR UPDATE`
+	err = tx.QueryRowContext(ctx, query, restriction.UserID).Scan(&previous)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return "", err
+	}
+
+	query = `
+		INSERT INTO account_restrictions (user_id, status, reason, automatic, created_by)
+		VALUES ($1, $2, $3, false, $4)
+		ON CONFLICT (user_id) DO UPDATE
+		SET status = EXCLUDED.status, reason = EXCLUDED.reason, automatic = false,
+			created_by = EXCLUDED.created_by, updated_at = NOW()
+		RETURNING updated_at`
+	args := []any{restriction.UserID, restriction.Status, restriction.Reason, restriction.CreatedBy}
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&restriction.UpdatedAt)
+	if err != nil {
+		return "", err
+	}
+	restriction.Automatic = false
+
+	if restriction.Status != RestrictionShadowLimited {
+		err = showHiddenReviews(ctx, tx, restriction.UserID)
+		if err != nil {
+			return "", err
+		}
+	}
+	return previous, tx.Commit()
+}
+
+// Delete lifts the restriction of a user's account, showing its hidden reviews again,
+// and returns the status it had. If the account isn't restricted ErrRecordNotFound is
+// returned.
+func (m AbuseModel) Delete(userID int64) (string, error) {
+	ctx, cancel := context.WithTimeout(m.context(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	var status string
+	query := `DELETE FROM account_restrictions WHERE user_id = $1 RETURNING status`
+	err = tx.QueryRowContext(ctx, query, userID).Scan(&status)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return "", ErrRecordNotFound
+		default:
+			return "", err
+		}
+	}
+
+	err = showHiddenReviews(ctx, tx, userID)
+	if err != nil {
+		return "", err
+	}
+	return status, tx.Commit()
+}
+
+// GetAll returns a page of the restricted accounts with the status given, or all of
+// them if it's empty, and the numbers of writes each user has made since the given
+// time.
+func (m AbuseModel) GetAll(status string, since time.Time, filters Filters) ([]*AccountRestriction, Metadata, error) {
+	query := fmt.Sprintf(`
+		SELECT count(*) OVER(), account_restrictions.user_id, users.public_id, users.email,
+			account_restrictions.status, account_restrictions.reason, account_restrictions.automatic,
+			account_restrictions.created_by, account_restrictions.updated_at,
+			coalesce((
+				SELECT jsonb_object_agg(writes.kind, writes.count)
+				FROM (
+					SELECT kind, count(*) AS count FROM write_events
+					WHERE write_events.user_id = account_restrictions.user_id AND created_at >= $2
+					GROUP BY kind
+				) AS writes
+			), '{}')
+		FROM account_restrictions
+		INNER JOIN users ON users.id = account_restrictions.user_id
+		WHERE account_restrictions.status = $1 OR $1 = ''
+		ORDER BY account_restrictions.%s %s, account_restrictions.user_id %[2]s
+		LIMIT $3 OFFSET $4`, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(m.context(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, status, since, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	restrictions := []*AccountRestriction{}
+	for rows.Next() {
+		var r AccountRestriction
+		var writes []byte
+		err := rows.Scan(
+			&totalRecords, &r.UserID, &r.UserPublicID, &r.UserEmail,
+			&r.Status, &r.Reason, &r.Automatic, &r.CreatedBy, &r.UpdatedAt, &writes,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		err = json.Unmarshal(writes, &r.RecentWrites)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		restrictions = append(restrictions, &r)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+	return restrictions, calculateMetadata(totalRecords, filters.Page, filters.PageSize), nil
+}
+
+// DeleteEventsBefore removes the writes recorded before the given time.
+func (m AbuseModel) DeleteEventsBefore(before time.Time) error {
+	ctx, cancel := context.WithTimeout(m.context(), 30*time.Second)
+	defer cancel()
+	_, err := m.DB.ExecContext(ctx, `DELETE FROM write_events WHERE created_at < $1`, before)
+	return err
+}
+
+// The showHiddenReviews() helper shows the hidden reviews of a user again, and updates
+// the ratings of the movies they're of, which they now count towards.
+func showHiddenReviews(ctx context.Context, tx *sql.Tx, userID int64) error {
+	movieIDs, err := lockReviewedMovies(ctx, tx, userID)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `UPDATE reviews SET hidden = false WHERE user_id = $1 AND hidden`, userID)
+	if err != nil {
+		return err
+	}
+	return updateRatings(ctx, tx, movieIDs...)
+}
@@ -0,0 +1,156 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"regexp"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/shyngys9219/greenlight/internal/validator"
+)
+
+// RegionRX matches the ISO 3166-1 alpha-2 codes regions are identified by, such as "GB".
+var RegionRX = regexp.MustCompile(`^[A-Z]{2}$`)
+
+// A MovieAvailability records whether a movie is available in a region, and when it's
+// released there. ReleaseDate is a date in the form 2006-01-02, or nil if it isn't
+// known.
+type MovieAvailability struct {
+	MovieID     int64     `json:"-"`
+	Region      string    `json:"region"`
+	Available   bool      `json:"available"`
+	ReleaseDate *string   `json:"release_date"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+func ValidateRegion(v *validator.Validator, key, region string) {
+	v.Check(validator.Matches(region, RegionRX), key, "must be an ISO 3166-1 alpha-2 country code, such as GB")
+}
+
+func ValidateMovieAvailability(v *validator.Validator, a *MovieAvailability) {
+	ValidateRegion(v, "region", a.Region)
+	if a.ReleaseDate != nil {
+		_, err := time.Parse("2006-01-02", *a.ReleaseDate)
+		v.Check(err == nil, "release_date", "must be a date in the form 2006-01-02")
+	}
+}
+
+// AvailabilityModel wraps the connection pool for the movie_availability table.
+type AvailabilityModel struct {
+	queryScope
+	DB *sql.DB
+}
+
+// GetAllForMovie returns the regions a movie has an availability in, in order.
+func (m AvailabilityModel) GetAllForMovie(movieID int64) ([]*MovieAvailability, error) {
+	query := `
+		SELECT movie_id, region, available, release_date::text, updated_at
+		FROM movie_availability
+		WHERE movie_id = $1
+		ORDER BY region`
+
+	ctx, cancel := context.WithTimeout(m.context(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, movieID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	availability := []*MovieAvailability{}
+	for rows.Next() {
+		var a MovieAvailability
+		err := rows.Scan(&a.MovieID, &a.Region, &a.Available, &a.ReleaseDate, &a.UpdatedAt)
+		if err != nil {
+			return nil, err
+		}
+		availability = append(availability, &a)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return availability, nil
+}
+
+// Set records a movie's availability in a region, returning what it was before, or nil
+// if the movie had no availability there. If the movie doesn't exist an
+// ErrRecordNotFound error is returned.
+func (m AvailabilityModel) Set(a *MovieAvailability) (*MovieAvailability, error) {
+	ctx, cancel := context.WithTimeout(m.context(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// The previous availability is read under a lock, so that two concurrent changes
+	// each report the one before them.
+	query := `
+		SELECT movie_id, region, available, release_date::text, updated_at
+		FROM movie_availability
+		WHERE movie_id = $1 AND region = $2
+		FOR UPDATE`
+	var previous MovieAvailability
+	err = tx.QueryRowContext(ctx, query, a.MovieID, a.Region).Scan(
+		&previous.MovieID, &previous.Region, &previous.Available, &previous.ReleaseDate, &previous.UpdatedAt,
+	)
+	found := true
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			return nil, err
+		}
+		found = false
+	}
+
+	query = `
+		INSERT INTO movie_availability (movie_id, region, available, release_date)
+		VALUES ($1, $2, $3, $4::date)
+		ON CONFLICT (movie_id, region) DO UPDATE
+		SET available = EXCLUDED.available, release_date = EXCLUDED.release_date, updated_at = NOW()
+		RETURNING updated_at`
+	err = tx.QueryRowContext(ctx, query, a.MovieID, a.Region, a.Available, a.ReleaseDate).Scan(&a.UpdatedAt)
+	if err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code.Name() == "foreign_key_violation" {
+			return nil, ErrRecordNotFound
+		}
+		return nil, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, nil
+	}
+	return &previous, nil
+}
+
+// Delete removes a movie's availability in a region, returning what it was. If there's
+// none an ErrRecordNotFound error is returned.
+func (m AvailabilityModel) Delete(movieID int64, region string) (*MovieAvailability, error) {
+	query := `
+		DELETE FROM movie_availability
+		WHERE movie_id = $1 AND region = $2
+		RETURNING movie_id, region, available, release_date::text, updated_at`
+
+	ctx, cancel := context.WithTimeout(m.context(), 3*time.Second)
+	defer cancel()
+
+	var a MovieAvailability
+	err := m.DB.QueryRowContext(ctx, query, movieID, region).Scan(&a.MovieID, &a.Region, &a.Available, &a.ReleaseDate, &a.UpdatedAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &a, nil
+}
@@ -0,0 +1,128 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// A BillingEvent is a webhook event from the billing provider, translated into the
+// changes it makes to an account. Either change may be left out: CustomerID links the
+// account to its customer at the provider, and Subscription replaces its plan.
+type BillingEvent struct {
+	ID           string
+	Type         string
+	Account      Account
+	CustomerID   string
+	Subscription *Subscription
+}
+
+// BillingModel links accounts to the billing provider and applies its events.
+type BillingModel struct {
+	queryScope
+	DB *sql.DB
+}
+
+// GetCustomerID returns the billing provider's customer ID for an account, or the
+// empty string if it has never subscribed.
+func (m BillingModel) GetCustomerID(account Account) (string, error) {
+	query := fmt.Sprintf(`SELECT billing_customer_id FROM %s WHERE id = $1`, planTables[account.Kind])
+	var customerID string
+	ctx, cancel := context.WithTimeout(m.context(), 3*time.Second)
+	defer cancel()
+	err := m.DB.QueryRowContext(ctx, query, account.ID).Scan(&customerID)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return "", ErrRecordNotFound
+		default:
+			return "", err
+		}
+	}
+	return customerID, nil
+}
+
+// GetAccountForCustomer returns the account linked to a customer of the billing
+// provider. If there is none an ErrRecordNotFound error is returned.
+func (m BillingModel) GetAccountForCustomer(customerID string) (Account, error) {
+	query := `
+	SELECT 'user', id FROM users WHERE billing_customer_id = $1
+	UNION ALL
+	SELECT 'organization', id FROM organizations WHERE billing_customer_id = $1
+	LIMIT 1`
+	var account Account
+	ctx, cancel := context.WithTimeout(m.context(), 3*time.Second)
+	defer cancel()
+	err := m.DB.QueryRowContext(ctx, query, customerID).Scan(&account.Kind, &account.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return Account{}, ErrRecordNotFound
+		default:
+			return Account{}, err
+		}
+	}
+	return account, nil
+}
+
+// Apply applies a billing event in a single transaction, recording it so that it's
+// applied only once however often the provider delivers it. It returns false if the
+// event had already been applied. If the event's account doesn't exist (it may have
+// been deleted since) an ErrRecordNotFound error is returned, and the event isn't
+// recorded.
+func (m BillingModel) Apply(event *BillingEvent) (bool, error) {
+	ctx, cancel := context.WithTimeout(m.context(), 5*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	query := `
+	INSERT INTO billing_events (id, type)
+	VALUES ($1, $2)
+	ON CONFLICT (id) DO NOTHING`
+	result, err := tx.ExecContext(ctx, query, event.ID, event.Type)
+	if err != nil {
+		return false, err
+	}
+	inserted, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	if inserted == 0 {
+		return false, nil
+	}
+
+	if event.CustomerID != "" {
+		query := fmt.Sprintf(`UPDATE %s SET billing_customer_id = $1 WHERE id = $2`, planTables[event.Account.Kind])
+		result, err := tx.ExecContext(ctx, query, event.CustomerID, event.Account.ID)
+		if err != nil {
+			return false, err
+		}
+		rowsAffected, err := result.RowsAffected()
+		if err != nil {
+			return false, err
+		}
+		if rowsAffected == 0 {
+			return false, ErrRecordNotFound
+		}
+	}
+
+	if event.Subscription != nil {
+		err = assignPlan(ctx, tx, event.Account, event.Subscription)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
@@ -0,0 +1,101 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// A DailyMetric is the total of a business metric, such as signups, on one (UTC) day.
+type DailyMetric struct {
+	Day    string `json:"day"` // in the form 2006-01-02
+	Metric string `json:"metric"`
+	Value  int64  `json:"value"`
+}
+
+// CatalogTotals are the current sizes of the catalog and of the user base.
+type CatalogTotals struct {
+	Users          int64
+	ActivatedUsers int64
+	Movies         int64
+	Reviews        int64
+}
+
+// BusinessMetricModel wraps the connection pool for the business_metrics_daily table.
+type BusinessMetricModel struct {
+	queryScope
+	DB *sql.DB
+}
+
+// AddDaily adds the counts to the totals of the given day, in a single transaction.
+func (m BusinessMetricModel) AddDaily(day time.Time, counts map[string]int64) error {
+	query := `
+		INSERT INTO business_metrics_daily (day, metric, value)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (day, metric) DO UPDATE SET value = business_metrics_daily.value + EXCLUDED.value`
+
+	ctx, cancel := context.WithTimeout(m.context(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	date := day.UTC().Format("2006-01-02")
+	for metric, value := range counts {
+		_, err = tx.ExecContext(ctx, query, date, metric, value)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// GetDaily returns the daily totals between the two days, inclusive, ordered by day and
+// metric. If metric isn't empty only that metric is returned.
+func (m BusinessMetricModel) GetDaily(from, to time.Time, metric string) ([]*DailyMetric, error) {
+	query := `
+		SELECT to_char(day, 'YYYY-MM-DD'), metric, value
+		FROM business_metrics_daily
+		WHERE day BETWEEN $1 AND $2 AND ($3 = '' OR metric = $3)
+		ORDER BY day, metric`
+
+	ctx, cancel := context.WithTimeout(m.context(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, from.Format("2006-01-02"), to.Format("2006-01-02"), metric)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	metrics := []*DailyMetric{}
+	for rows.Next() {
+		var dm DailyMetric
+		err := rows.Scan(&dm.Day, &dm.Metric, &dm.Value)
+		if err != nil {
+			return nil, err
+		}
+		metrics = append(metrics, &dm)
+	}
+	return metrics, rows.Err()
+}
+
+// CatalogTotals counts the users, activated users, movies and reviews.
+func (m BusinessMetricModel) CatalogTotals() (CatalogTotals, error) {
+	query := `
+		SELECT
+			(SELECT count(*) FROM users),
+			(SELECT count(*) FROM users WHERE activated),
+			(SELECT count(*) FROM movies),
+			(SELECT count(*) FROM reviews)`
+
+	ctx, cancel := context.WithTimeout(m.context(), 3*time.Second)
+	defer cancel()
+
+	var totals CatalogTotals
+	err := m.DB.QueryRowContext(ctx, query).Scan(&totals.Users, &totals.ActivatedUsers, &totals.Movies, &totals.Reviews)
+	return totals, err
+}
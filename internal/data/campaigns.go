@@ -0,0 +1,233 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// Define constants for the email campaigns. Suppressing CampaignAll suppresses every
+// campaign.
+const (
+	CampaignActivation   = "activation"   // reminds users to activate their account
+	CampaignReengagement = "reengagement" // invites back users who haven't logged in for a while
+	CampaignAll          = "all"
+)
+
+// Campaigns lists the email campaigns.
+var Campaigns = []string{CampaignActivation, CampaignReengagement}
+
+// SuppressionUnsubscribed is the reason recorded when users unsubscribe themselves.
+const SuppressionUnsubscribed = "unsubscribed"
+
+// campaignAudiences maps each campaign to the users it's for, and to the column its
+// sequence's delays count from. A sequence starts again whenever that column moves on,
+// so users who become inactive again are re-engaged again.
+var campaignAudiences = map[string]struct {
+	where  string
+	anchor string
+}{
+	CampaignActivation: {
+		where: `NOT users.activated AND EXISTS (
+			SELECT 1 FROM tokens
+			WHERE tokens.user_id = users.id AND tokens.scope = 'activation' AND tokens.expiry > NOW()
+		)`,
+		anchor: "users.created_at",
+	},
+	CampaignReengagement: {where: "users.activated", anchor: "users.last_active_at"},
+}
+
+// A CampaignRecipient is a user a campaign email is due for. ActivationExpiry is when
+// the user's activation token expires, for the activation campaign.
+type CampaignRecipient struct {
+	User             *User
+	ActivationExpiry time.Time
+}
+
+// A CampaignStat summarises the emails sent for one step of a campaign. An email
+// converted if the user went on to do what it asked: activate their account, or log
+// in again.
+type CampaignStat struct {
+	Campaign  string `json:"campaign"`
+	Step      int    `json:"step"`
+	Sent      int    `json:"sent"`
+	Converted int    `json:"converted"`
+}
+
+// CampaignModel wraps the connection pool for the campaign tables.
+type CampaignModel struct {
+	queryScope
+	DB *sql.DB
+}
+
+// ClaimDue records a step of a campaign's sequence as sent to up to limit users it's
+// due for, and returns them. A step is due once delay has passed since the start of
+// the user's sequence, if the previous step has been sent; users who are deactivated
+// or suppressed from the campaign are left out, as are those who don't consent to
+// marketing emails. Users who haven't decided on it get them if consentDefault is true.
+func (m CampaignModel) ClaimDue(campaign string, step int, delay time.Duration, limit int, consentDefault bool) ([]*CampaignRecipient, error) {
+	audience, ok := campaignAudiences[campaign]
+	if !ok {
+		return nil, fmt.Errorf("unknown campaign %q", campaign)
+	}
+
+	query := fmt.Sprintf(`
+	WITH due AS (
+		SELECT users.id
+		FROM users
+		WHERE users.active AND %[1]s
+		AND %[2]s <= $3
+		AND NOT EXISTS (
+			SELECT 1 FROM campaign_sends
+			WHERE campaign_sends.user_id = users.id AND campaign = $1 AND step = $2 AND sent_at >= %[2]s
+		)
+		AND ($2 = 0 OR EXISTS (
+			SELECT 1 FROM campaign_sends
+			WHERE campaign_sends.user_id = users.id AND campaign = $1 AND step = $2 - 1 AND sent_at >= %[2]s
+		))
+		AND NOT EXISTS (
+			SELECT 1 FROM campaign_suppressions
+			WHERE campaign_suppressions.user_id = users.id AND campaign IN ($1, 'all')
+		)
+		AND %[3]s
+		ORDER BY %[2]s, users.id
+		LIMIT $4
+		FOR UPDATE OF users SKIP LOCKED
+	), sent AS (
+		INSERT INTO campaign_sends (campaign, step, user_id)
+		SELECT $1, $2, id FROM due
+		RETURNING user_id
+	)
+	SELECT users.id, users.created_at, users.name, users.email, users.activated,
+		(SELECT max(expiry) FROM tokens WHERE tokens.user_id = users.id AND tokens.scope = 'activation')
+	FROM users
+	INNER JOIN sent ON sent.user_id = users.id`, audience.where, audience.anchor, consentGrantedSQL("users.id", ConsentMarketingEmail, "$5"))
+
+	ctx, cancel := context.WithTimeout(m.context(), 10*time.Second)
+	defer cancel()
+	rows, err := m.DB.QueryContext(ctx, query, campaign, step, time.Now().Add(-delay), limit, consentDefault)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	recipients := []*CampaignRecipient{}
+	for rows.Next() {
+		var (
+			user   User
+			expiry sql.NullTime
+		)
+		err := rows.Scan(&user.ID, &user.CreatedAt, &user.Name, &user.Email, &user.Activated, &expiry)
+		if err != nil {
+			return nil, err
+		}
+		recipients = append(recipients, &CampaignRecipient{User: &user, ActivationExpiry: expiry.Time})
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return recipients, nil
+}
+
+// GetSuppressions returns the campaigns the user is suppressed from, mapped to the
+// reason.
+func (m CampaignModel) GetSuppressions(userID int64) (map[string]string, error) {
+	query := `SELECT campaign, reason FROM campaign_suppressions WHERE user_id = $1`
+	ctx, cancel := context.WithTimeout(m.context(), 3*time.Second)
+	defer cancel()
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	suppressions := make(map[string]string)
+	for rows.Next() {
+		var campaign, reason string
+		err := rows.Scan(&campaign, &reason)
+		if err != nil {
+			return nil, err
+		}
+		suppressions[campaign] = reason
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return suppressions, nil
+}
+
+// Suppress stops a campaign's emails to the user, replacing the reason if they're
+// already suppressed from it.
+func (m CampaignModel) Suppress(userID int64, campaign, reason string) error {
+	query := `
+	INSERT INTO campaign_suppressions (user_id, campaign, reason)
+	VALUES ($1, $2, $3)
+	ON CONFLICT (user_id, campaign) DO UPDATE SET reason = EXCLUDED.reason, created_at = NOW()`
+	ctx, cancel := context.WithTimeout(m.context(), 3*time.Second)
+	defer cancel()
+	_, err := m.DB.ExecContext(ctx, query, userID, campaign, reason)
+	return err
+}
+
+// Unsuppress lets a campaign email the user again.
+func (m CampaignModel) Unsuppress(userID int64, campaign string) error {
+	query := `DELETE FROM campaign_suppressions WHERE user_id = $1 AND campaign = $2`
+	ctx, cancel := context.WithTimeout(m.context(), 3*time.Second)
+	defer cancel()
+	_, err := m.DB.ExecContext(ctx, query, userID, campaign)
+	return err
+}
+
+// Stats returns the analytics of the campaign emails sent since the given time, by
+// campaign and step, along with how many users are suppressed from each campaign.
+func (m CampaignModel) Stats(since time.Time) ([]*CampaignStat, map[string]int, error) {
+	query := `
+	SELECT campaign_sends.campaign, campaign_sends.step, count(*),
+		count(*) FILTER (WHERE CASE campaign_sends.campaign
+			WHEN 'activation' THEN users.activated
+			ELSE users.last_active_at > campaign_sends.sent_at
+		END)
+	FROM campaign_sends
+	LEFT JOIN users ON users.id = campaign_sends.user_id
+	WHERE campaign_sends.sent_at >= $1
+	GROUP BY campaign_sends.campaign, campaign_sends.step
+	ORDER BY campaign_sends.campaign, campaign_sends.step`
+	ctx, cancel := context.WithTimeout(m.context(), 10*time.Second)
+	defer cancel()
+	rows, err := m.DB.QueryContext(ctx, query, since)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+	stats := []*CampaignStat{}
+	for rows.Next() {
+		var stat CampaignStat
+		err := rows.Scan(&stat.Campaign, &stat.Step, &stat.Sent, &stat.Converted)
+		if err != nil {
+			return nil, nil, err
+		}
+		stats = append(stats, &stat)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	rows, err = m.DB.QueryContext(ctx, `SELECT campaign, count(*) FROM campaign_suppressions GROUP BY campaign`)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+	suppressed := make(map[string]int)
+	for rows.Next() {
+		var campaign string
+		var count int
+		err := rows.Scan(&campaign, &count)
+		if err != nil {
+			return nil, nil, err
+		}
+		suppressed[campaign] = count
+	}
+	if err = rows.Err(); err != nil {
+		return nil, nil, err
+	}
+	return stats, suppressed, nil
+}
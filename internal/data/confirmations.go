@@ -0,0 +1,65 @@
+package data
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"time"
+)
+
+// ConfirmationModel wraps the connection pool for the confirmations table, which holds
+// the tokens confirming destructive operations.
+type ConfirmationModel struct {
+	queryScope
+	DB *sql.DB
+}
+
+// New issues a token confirming the given action with the given parameters, as
+// identified by their fingerprint, for the user.
+func (m ConfirmationModel) New(userID int64, action string, fingerprint []byte, ttl time.Duration) (*Token, error) {
+	token, err := generateToken(userID, ttl, action)
+	if err != nil {
+		return nil, err
+	}
+	query := `
+	INSERT INTO confirmations (hash, user_id, action, fingerprint, expiry)
+	VALUES ($1, $2, $3, $4, $5)`
+	ctx, cancel := context.WithTimeout(m.context(), 3*time.Second)
+	defer cancel()
+	_, err = m.DB.ExecContext(ctx, query, token.Hash, userID, action, fingerprint, token.Expiry)
+	if err != nil {
+		return nil, err
+	}
+	return token, nil
+}
+
+// Consume uses up a confirmation token. It returns ErrRecordNotFound unless the token
+// was issued to the user for the same action and parameters, and hasn't expired.
+func (m ConfirmationModel) Consume(plaintext string, userID int64, action string, fingerprint []byte) error {
+	hash := sha256.Sum256([]byte(plaintext))
+	query := `
+	DELETE FROM confirmations
+	WHERE hash = $1 AND user_id = $2 AND action = $3 AND fingerprint = $4 AND expiry > NOW()`
+	ctx, cancel := context.WithTimeout(m.context(), 3*time.Second)
+	defer cancel()
+	result, err := m.DB.ExecContext(ctx, query, hash[:], userID, action, fingerprint)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// DeleteExpired deletes the confirmation tokens which expired without being used.
+func (m ConfirmationModel) DeleteExpired() error {
+	ctx, cancel := context.WithTimeout(m.context(), 30*time.Second)
+	defer cancel()
+	_, err := m.DB.ExecContext(ctx, `DELETE FROM confirmations WHERE expiry <= NOW()`)
+	return err
+}
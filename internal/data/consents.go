@@ -0,0 +1,142 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// Define constants for the purposes users consent to their data being processed for.
+const (
+	ConsentAnalytics       = "analytics"       // their searches are recorded for the search analytics
+	ConsentMarketingEmail  = "marketing_email" // they're sent the campaign emails
+	ConsentPersonalization = "personalization" // their viewing history is kept to personalize the catalog
+)
+
+// ConsentPurposes lists the purposes users consent to.
+var ConsentPurposes = []string{ConsentAnalytics, ConsentMarketingEmail, ConsentPersonalization}
+
+// A Consent is a user's current consent to a purpose. Version counts the user's changes
+// to it, and is 0 while they haven't made one and the server's default applies.
+type Consent struct {
+	Purpose       string     `json:"purpose"`
+	Granted       bool       `json:"granted"`
+	Version       int        `json:"version"`
+	PolicyVersion string     `json:"policy_version,omitempty"`
+	UpdatedAt     *time.Time `json:"updated_at,omitempty"`
+}
+
+// ConsentModel wraps the connection pool for the user_consents table.
+type ConsentModel struct {
+	queryScope
+	DB *sql.DB
+}
+
+// GetForUser returns the user's current consent to each of the purposes, in the order
+// of ConsentPurposes. Purposes the user hasn't decided on are granted if they're set
+// in defaults.
+func (m ConsentModel) GetForUser(userID int64, defaults map[string]bool) ([]*Consent, error) {
+	query := `
+		SELECT DISTINCT ON (purpose) purpose, granted, version, policy_version, created_at
+		FROM user_consents
+		WHERE user_id = $1
+		ORDER BY purpose, version DESC`
+
+	ctx, cancel := context.WithTimeout(m.context(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	recorded := make(map[string]*Consent)
+	for rows.Next() {
+		var c Consent
+		var updatedAt time.Time
+		err := rows.Scan(&c.Purpose, &c.Granted, &c.Version, &c.PolicyVersion, &updatedAt)
+		if err != nil {
+			return nil, err
+		}
+		c.UpdatedAt = &updatedAt
+		recorded[c.Purpose] = &c
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	consents := make([]*Consent, 0, len(ConsentPurposes))
+	for _, purpose := range ConsentPurposes {
+		c, ok := recorded[purpose]
+		if !ok {
+			c = &Consent{Purpose: purpose, Granted: defaults[purpose]}
+		}
+		consents = append(consents, c)
+	}
+	return consents, nil
+}
+
+// Granted reports whether the user consents to the purpose, or, if they haven't
+// decided, returns defaultGranted.
+func (m ConsentModel) Granted(userID int64, purpose string, defaultGranted bool) (bool, error) {
+	query := `
+		SELECT COALESCE((
+			SELECT granted FROM user_consents
+			WHERE user_id = $1 AND purpose = $2
+			ORDER BY version DESC
+			LIMIT 1
+		), $3)`
+
+	ctx, cancel := context.WithTimeout(m.context(), 3*time.Second)
+	defer cancel()
+
+	var granted bool
+	err := m.DB.QueryRowContext(ctx, query, userID, purpose, defaultGranted).Scan(&granted)
+	return granted, err
+}
+
+// Record records the user's new consents, by purpose, under the policy version, in a
+// single transaction. Each one gets the next version for its purpose, even when it
+// doesn't change the consent, so that the user's confirmation is on record too.
+func (m ConsentModel) Record(userID int64, consents map[string]bool, policyVersion string) error {
+	query := `
+		INSERT INTO user_consents (user_id, purpose, version, granted, policy_version)
+		SELECT $1, $2, COALESCE(max(version), 0) + 1, $3, $4
+		FROM user_consents
+		WHERE user_id = $1 AND purpose = $2`
+
+	ctx, cancel := context.WithTimeout(m.context(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Lock the user, so that concurrent changes don't both take the same version.
+	_, err = tx.ExecContext(ctx, `SELECT 1 FROM users WHERE id = $1 FOR UPDATE`, userID)
+	if err != nil {
+		return err
+	}
+	for purpose, granted := range consents {
+		_, err = tx.ExecContext(ctx, query, userID, purpose, granted, policyVersion)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// consentGrantedSQL returns a condition which is true for the users whose id is in the
+// userColumn if they consent to the purpose, or, if they haven't decided, if the
+// boolean query parameter defaultParam (such as "$5") is.
+func consentGrantedSQL(userColumn, purpose, defaultParam string) string {
+	return `COALESCE((
+			SELECT granted FROM user_consents
+			WHERE user_consents.user_id = ` + userColumn + ` AND purpose = '` + purpose + `'
+			ORDER BY version DESC
+			LIMIT 1
+		), ` + defaultParam + `)`
+}
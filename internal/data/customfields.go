@@ -0,0 +1,346 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"time"
+	"unicode/utf8"
+
+	"github.com/shyngys9219/greenlight/internal/validator"
+)
+
+// Define constants for the types of custom fields.
+const (
+	CustomFieldString  = "string"
+	CustomFieldNumber  = "number"
+	CustomFieldBoolean = "boolean"
+	CustomFieldDate    = "date" // written as 2006-01-02
+)
+
+// CustomFieldTypes lists the types of custom fields.
+var CustomFieldTypes = []string{CustomFieldString, CustomFieldNumber, CustomFieldBoolean, CustomFieldDate}
+
+// ErrCustomFieldType is returned when a custom field is saved with a different type
+// than it was defined with. The values movies already have would no longer fit it.
+var ErrCustomFieldType = errors.New("custom field type changed")
+
+// customFieldNameRX is the shape of the names of custom fields, which are used as keys
+// in query strings and JSON.
+var customFieldNameRX = regexp.MustCompile(`^[a-z][a-z0-9_]{0,62}$`)
+
+// A CustomFieldDefinition defines a custom field of movies: its type, and the rules its
+// values must follow. MaxLength and Pattern only apply to strings, and Minimum and
+// Maximum to numbers. Pattern must match the whole value.
+type CustomFieldDefinition struct {
+	Name        string    `json:"name"`
+	Type        string    `json:"type"`
+	Description string    `json:"description,omitempty"`
+	Required    bool      `json:"required"`
+	MaxLength   *int      `json:"max_length,omitempty"`
+	Pattern     string    `json:"pattern,omitempty"`
+	Minimum     *float64  `json:"minimum,omitempty"`
+	Maximum     *float64  `json:"maximum,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+func ValidateCustomFieldDefinition(v *validator.Validator, def *CustomFieldDefinition) {
+	v.Check(validator.Matches(def.Name, customFieldNameRX), "name", "must start with a lowercase letter, contain only lowercase letters, digits and underscores, and be at most 63 characters long")
+	v.Check(validator.PermittedValue(def.Type, CustomFieldTypes...), "type", "must be string, number, boolean or date")
+	v.Check(len(def.Description) <= 1000, "description", "must not be more than 1000 bytes long")
+	if def.MaxLength != nil {
+		v.Check(def.Type == CustomFieldString, "max_length", "only applies to string fields")
+		v.Check(*def.MaxLength > 0, "max_length", "must be greater than zero")
+	}
+	if def.Pattern != "" {
+		v.Check(def.Type == CustomFieldString, "pattern", "only applies to string fields")
+		v.Check(len(def.Pattern) <= 500, "pattern", "must not be more than 500 bytes long")
+		_, err := regexp.Compile(def.Pattern)
+		v.Check(err == nil, "pattern", "must be a valid regular expression")
+	}
+	if def.Minimum != nil || def.Maximum != nil {
+		v.Check(def.Type == CustomFieldNumber, "minimum", "only applies to number fields")
+	}
+	if def.Minimum != nil && def.Maximum != nil {
+		v.Check(*def.Minimum <= *def.Maximum, "maximum", "must not be less than the minimum")
+	}
+}
+
+// ValidateCustomFields checks a movie's custom fields against their definitions: every
+// field must be defined, have a value of its type which follows its rules, and the
+// required fields must be set. The errors are keyed by custom_fields.<name>.
+func ValidateCustomFields(v *validator.Validator, defs []*CustomFieldDefinition, fields CustomFields) {
+	byName := make(map[string]*CustomFieldDefinition, len(defs))
+	for _, def := range defs {
+		byName[def.Name] = def
+		if def.Required {
+			_, ok := fields[def.Name]
+			v.Check(ok, "custom_fields."+def.Name, "must be provided")
+		}
+	}
+
+	for name, value := range fields {
+		key := "custom_fields." + name
+		def, ok := byName[name]
+		if !ok {
+			v.AddError(key, "is not a defined custom field")
+			continue
+		}
+		switch def.Type {
+		case CustomFieldString:
+			s, ok := value.(string)
+			if !ok {
+				v.AddError(key, "must be a string")
+				continue
+			}
+			if def.MaxLength != nil {
+				v.Check(utf8.RuneCountInString(s) <= *def.MaxLength, key, fmt.Sprintf("must not be more than %d characters long", *def.MaxLength))
+			}
+			if def.Pattern != "" {
+				v.Check(validator.Matches(s, regexp.MustCompile(`^(?:`+def.Pattern+`)$`)), key, "must match the pattern "+def.Pattern)
+			}
+		case CustomFieldNumber:
+			n, ok := value.(float64)
+			if !ok {
+				v.AddError(key, "must be a number")
+				continue
+			}
+			if def.Minimum != nil {
+				v.Check(n >= *def.Minimum, key, fmt.Sprintf("must not be less than %g", *def.Minimum))
+			}
+			if def.Maximum != nil {
+				v.Check(n <= *def.Maximum, key, fmt.Sprintf("must not be more than %g", *def.Maximum))
+			}
+		case CustomFieldBoolean:
+			_, ok := value.(bool)
+			v.Check(ok, key, "must be true or false")
+		case CustomFieldDate:
+			s, ok := value.(string)
+			_, err := time.Parse("2006-01-02", s)
+			v.Check(ok && err == nil, key, "must be a date, such as 2006-01-02")
+		}
+	}
+}
+
+// CustomFields holds the values of a movie's custom fields, keyed by field name, as
+// decoded from JSON: strings, float64s and bools. It's stored in a jsonb column.
+type CustomFields map[string]any
+
+// Scan implements the sql.Scanner interface, for reading the jsonb column.
+func (f *CustomFields) Scan(src any) error {
+	b, ok := src.([]byte)
+	if !ok {
+		return fmt.Errorf("custom fields: unexpected type %T", src)
+	}
+	*f = nil
+	err := json.Unmarshal(b, f)
+	if err != nil {
+		return err
+	}
+	// An empty object is left out of the movie's JSON.
+	if len(*f) == 0 {
+		*f = nil
+	}
+	return nil
+}
+
+// Value implements the driver.Valuer interface, for writing the jsonb column.
+func (f CustomFields) Value() (driver.Value, error) {
+	if f == nil {
+		return "{}", nil
+	}
+	b, err := json.Marshal(f)
+	if err != nil {
+		return nil, err
+	}
+	return string(b), nil
+}
+
+// A CustomFieldFilter narrows down the movie listing to those whose custom field
+// compares to the value, such as cf.acquired[gte]=1990-01-01. Strings and booleans can
+// only be compared with eq and neq. The filters must have been checked with
+// ValidateCustomFieldFilters, which records the type of their field.
+type CustomFieldFilter struct {
+	Name  string
+	Op    string
+	Value string // as given in the query string
+	typ   string
+	value any // Value parsed according to typ
+}
+
+// ValidateCustomFieldFilters checks that every filter is on a defined custom field,
+// with an operator and value fitting its type, and records the type in the filter.
+func ValidateCustomFieldFilters(v *validator.Validator, defs []*CustomFieldDefinition, filters []CustomFieldFilter) {
+	v.Check(len(filters) <= MaxFilters, "cf", fmt.Sprintf("must not contain more than %d filters", MaxFilters))
+	types := make(map[string]string, len(defs))
+	for _, def := range defs {
+		types[def.Name] = def.Type
+	}
+
+	for i := range filters {
+		f := &filters[i]
+		key := fmt.Sprintf("cf.%s[%s]", f.Name, f.Op)
+		typ, ok := types[f.Name]
+		if !ok {
+			v.AddError(key, "is not a defined custom field")
+			continue
+		}
+		if _, ok := filterOperators[f.Op]; !ok {
+			v.AddError(key, "must use one of the eq, neq, gt, gte, lt or lte operators")
+			continue
+		}
+		if (typ == CustomFieldString || typ == CustomFieldBoolean) && f.Op != "eq" && f.Op != "neq" {
+			v.AddError(key, "must use the eq or neq operator")
+			continue
+		}
+
+		var err error
+		switch typ {
+		case CustomFieldString:
+			f.value = f.Value
+		case CustomFieldNumber:
+			f.value, err = strconv.ParseFloat(f.Value, 64)
+		case CustomFieldBoolean:
+			f.value, err = strconv.ParseBool(f.Value)
+		case CustomFieldDate:
+			_, err = time.Parse("2006-01-02", f.Value)
+			f.value = f.Value
+		}
+		if err != nil {
+			v.AddError(key, "must be a "+typ+" value")
+			continue
+		}
+		f.typ = typ
+	}
+}
+
+// customField adds the condition of a custom field filter to b. Equality is
+// written as containment, which the GIN index on custom_fields serves; the other
+// comparisons cast the field's value to its type. The field's name is passed as a
+// value, never written into the SQL.
+func (b *filterBuilder) customField(f CustomFieldFilter) {
+	switch {
+	case f.typ == "":
+		panic("unvalidated custom field filter: " + f.Name)
+	case f.Op == "eq" || f.Op == "neq":
+		doc, err := json.Marshal(map[string]any{f.Name: f.value})
+		if err != nil {
+			panic(err)
+		}
+		condition := "movies.custom_fields @> ?::jsonb"
+		if f.Op == "neq" {
+			condition = "NOT " + condition
+		}
+		b.add(condition, string(doc))
+	case f.typ == CustomFieldNumber:
+		b.add("(movies.custom_fields->>?::text)::numeric "+filterOperators[f.Op]+" ?", f.Name, f.value)
+	case f.typ == CustomFieldDate:
+		b.add("(movies.custom_fields->>?::text)::date "+filterOperators[f.Op]+" ?::date", f.Name, f.value)
+	}
+}
+
+// CustomFieldModel wraps the connection pool for the movie_field_definitions table.
+type CustomFieldModel struct {
+	queryScope
+	DB *sql.DB
+}
+
+// GetAll returns the definitions of the custom fields, ordered by name.
+func (m CustomFieldModel) GetAll() ([]*CustomFieldDefinition, error) {
+	query := `
+		SELECT name, type, description, required, max_length, pattern, minimum, maximum, created_at, updated_at
+		FROM movie_field_definitions
+		ORDER BY name`
+
+	ctx, cancel := context.WithTimeout(m.context(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	defs := []*CustomFieldDefinition{}
+	for rows.Next() {
+		var def CustomFieldDefinition
+		err := rows.Scan(
+			&def.Name, &def.Type, &def.Description, &def.Required, &def.MaxLength,
+			&def.Pattern, &def.Minimum, &def.Maximum, &def.CreatedAt, &def.UpdatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		defs = append(defs, &def)
+	}
+	return defs, rows.Err()
+}
+
+// Save adds the definition of a custom field, or replaces it if the field is already
+// defined. A field's type can't be changed: ErrCustomFieldType is returned instead.
+// New rules, including making the field required, apply to the values written from
+// then on; the values movies already have are kept.
+func (m CustomFieldModel) Save(def *CustomFieldDefinition) error {
+	query := `
+		INSERT INTO movie_field_definitions (name, type, description, required, max_length, pattern, minimum, maximum)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		ON CONFLICT (name) DO UPDATE
+		SET description = EXCLUDED.description, required = EXCLUDED.required,
+			max_length = EXCLUDED.max_length, pattern = EXCLUDED.pattern,
+			minimum = EXCLUDED.minimum, maximum = EXCLUDED.maximum, updated_at = NOW()
+		WHERE movie_field_definitions.type = EXCLUDED.type
+		RETURNING created_at, updated_at`
+
+	ctx, cancel := context.WithTimeout(m.context(), 3*time.Second)
+	defer cancel()
+
+	args := []any{def.Name, def.Type, def.Description, def.Required, def.MaxLength, def.Pattern, def.Minimum, def.Maximum}
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&def.CreatedAt, &def.UpdatedAt)
+	if err != nil {
+		switch {
+		// The update was skipped because the existing field has another type.
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrCustomFieldType
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+// Delete removes the definition of a custom field, and the field's values from every
+// movie, in a single transaction.
+func (m CustomFieldModel) Delete(name string) error {
+	ctx, cancel := context.WithTimeout(m.context(), 10*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, `DELETE FROM movie_field_definitions WHERE name = $1`, name)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE movies SET custom_fields = custom_fields - $1 WHERE custom_fields ? $1`, name)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,450 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/shyngys9219/greenlight/internal/publicid"
+	"github.com/shyngys9219/greenlight/internal/validator"
+)
+
+// DatasetVersion is the version of the portable dataset format written by Export. It
+// must be bumped whenever the format changes in a way older importers can't read.
+// Version 2 added the user's reviews and watchlist.
+const DatasetVersion = 2
+
+// Define constants for the ways Import can resolve a movie which already exists in the
+// target deployment (matched on title and year).
+const (
+	ConflictSkip      = "skip"      // keep the existing movie and map to it
+	ConflictOverwrite = "overwrite" // update the existing movie with the imported data
+	ConflictCreate    = "create"    // always create a new movie
+)
+
+// A Dataset is a portable snapshot of the catalog, and optionally of one user's data,
+// which can be moved from one Greenlight deployment to another. IDs in a dataset are
+// the IDs of the exporting deployment; Import remaps them.
+type Dataset struct {
+	Version    int             `json:"version"`
+	ExportedAt time.Time       `json:"exported_at"`
+	Movies     []*DatasetMovie `json:"movies"`
+	User       *DatasetUser    `json:"user,omitempty"`
+}
+
+type DatasetMovie struct {
+	ID      int64    `json:"id"`
+	Title   string   `json:"title"`
+	Year    int32    `json:"year"`
+	Runtime int32    `json:"runtime"`
+	Genres  []string `json:"genres"`
+}
+
+// A DatasetUser holds a user's own data. The user is matched on email address when
+// importing, and must already have an account in the target deployment.
+type DatasetUser struct {
+	Name       string                  `json:"name"`
+	Email      string                  `json:"email"`
+	Genres     []string                `json:"followed_genres"`
+	Screenings []*DatasetScreening     `json:"screenings"`
+	Reviews    []*DatasetReview        `json:"reviews"`
+	Watchlist  []*DatasetWatchlistItem `json:"watchlist"`
+}
+
+type DatasetScreening struct {
+	MovieID  int64     `json:"movie_id"`
+	StartsAt time.Time `json:"starts_at"`
+	Note     string    `json:"note"`
+}
+
+// A DatasetReview is one of the user's reviews. Reviews hidden by moderators aren't
+// exported, so that importing them can't make them visible again.
+type DatasetReview struct {
+	MovieID   int64     `json:"movie_id"`
+	Rating    int       `json:"rating"`
+	Body      string    `json:"body"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type DatasetWatchlistItem struct {
+	MovieID   int64      `json:"movie_id"`
+	Watched   bool       `json:"watched"`
+	AddedAt   time.Time  `json:"added_at"`
+	WatchedAt *time.Time `json:"watched_at,omitempty"`
+}
+
+// An ImportReport summarises what Import did. IDMap maps the movie IDs in the dataset
+// to the IDs of the same movies in this deployment.
+type ImportReport struct {
+	MoviesCreated      int             `json:"movies_created"`
+	MoviesUpdated      int             `json:"movies_updated"`
+	MoviesSkipped      int             `json:"movies_skipped"`
+	FollowsImported    int             `json:"follows_imported"`
+	ScreeningsImported int             `json:"screenings_imported"`
+	ReviewsImported    int             `json:"reviews_imported"`
+	WatchlistImported  int             `json:"watchlist_imported"`
+	IDMap              map[int64]int64 `json:"id_map"`
+	Errors             []string        `json:"errors"`
+}
+
+func ValidateDataset(v *validator.Validator, ds *Dataset, conflict string) {
+	v.Check(ds.Version != 0, "version", "must be provided")
+	v.Check(ds.Version <= DatasetVersion, "version", fmt.Sprintf("must not be newer than %d", DatasetVersion))
+	v.Check(validator.PermittedValue(conflict, ConflictSkip, ConflictOverwrite, ConflictCreate), "conflict", "must be skip, overwrite or create")
+}
+
+// DatasetModel exports and imports datasets. It works across several tables, so it
+// wraps the connection pool directly rather than going through the other models.
+type DatasetModel struct {
+	queryScope
+	DB        *sql.DB
+	publicIDs publicid.Generator // generates the public IDs of imported movies
+}
+
+// Export builds a dataset of every movie in the catalog. If userID is greater than zero
+// the user's followed genres, hosted screenings, reviews and watchlist are included too.
+func (m DatasetModel) Export(userID int64) (*Dataset, error) {
+	ctx, cancel := context.WithTimeout(m.context(), 30*time.Second)
+	defer cancel()
+
+	ds := &Dataset{
+		Version:    DatasetVersion,
+		ExportedAt: time.Now().UTC(),
+		Movies:     []*DatasetMovie{},
+	}
+
+	rows, err := m.DB.QueryContext(ctx, `SELECT id, title, year, runtime, `+movieGenres+` FROM movies ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var movie DatasetMovie
+		err := rows.Scan(&movie.ID, &movie.Title, &movie.Year, &movie.Runtime, pq.Array(&movie.Genres))
+		if err != nil {
+			return nil, err
+		}
+		ds.Movies = append(ds.Movies, &movie)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if userID < 1 {
+		return ds, nil
+	}
+
+	user := &DatasetUser{
+		Genres:     []string{},
+		Screenings: []*DatasetScreening{},
+		Reviews:    []*DatasetReview{},
+		Watchlist:  []*DatasetWatchlistItem{},
+	}
+	err = m.DB.QueryRowContext(ctx, `SELECT name, email FROM users WHERE id = $1`, userID).Scan(&user.Name, &user.Email)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	query := `
+	SELECT coalesce(array_agg(value ORDER BY value), '{}')
+	FROM follows
+	WHERE user_id = $1 AND kind = 'genre'`
+	err = m.DB.QueryRowContext(ctx, query, userID).Scan(pq.Array(&user.Genres))
+	if err != nil {
+		return nil, err
+	}
+
+	query = `
+	SELECT movie_id, starts_at, note FROM screenings
+	WHERE host_id = $1
+	ORDER BY starts_at`
+	rows, err = m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var screening DatasetScreening
+		err := rows.Scan(&screening.MovieID, &screening.StartsAt, &screening.Note)
+		if err != nil {
+			return nil, err
+		}
+		user.Screenings = append(user.Screenings, &screening)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	query = `
+	SELECT movie_id, rating, body, created_at FROM reviews
+	WHERE user_id = $1 AND NOT hidden
+	ORDER BY created_at, id`
+	rows, err = m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var review DatasetReview
+		err := rows.Scan(&review.MovieID, &review.Rating, &review.Body, &review.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		user.Reviews = append(user.Reviews, &review)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	query = `
+	SELECT movie_id, watched, added_at, watched_at FROM watchlist
+	WHERE user_id = $1
+	ORDER BY added_at, movie_id`
+	rows, err = m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var item DatasetWatchlistItem
+		err := rows.Scan(&item.MovieID, &item.Watched, &item.AddedAt, &item.WatchedAt)
+		if err != nil {
+			return nil, err
+		}
+		user.Watchlist = append(user.Watchlist, &item)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	ds.User = user
+	return ds, nil
+}
+
+// Import loads a dataset in a single transaction. Movies which fail validation are
+// reported and skipped; anything else going wrong rolls the whole import back. Movie
+// IDs referenced by the user's screenings are remapped to the imported movies.
+func (m DatasetModel) Import(ds *Dataset, conflict string) (*ImportReport, error) {
+	ctx, cancel := context.WithTimeout(m.context(), 60*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	report := &ImportReport{IDMap: make(map[int64]int64), Errors: []string{}}
+
+	for i, dm := range ds.Movies {
+		movie := &Movie{Title: dm.Title, Year: dm.Year, Runtime: dm.Runtime, Genres: dm.Genres}
+
+		v := validator.New()
+		if ValidateMovie(v, movie); !v.Valid() {
+			for field, message := range v.Errors {
+				report.Errors = append(report.Errors, fmt.Sprintf("movies[%d]: %s %s", i, field, message))
+			}
+			continue
+		}
+
+		var existingID int64
+		if conflict != ConflictCreate {
+			query := `SELECT id FROM movies WHERE lower(title) = lower($1) AND year = $2 ORDER BY id LIMIT 1`
+			err := tx.QueryRowContext(ctx, query, movie.Title, movie.Year).Scan(&existingID)
+			if err != nil && !errors.Is(err, sql.ErrNoRows) {
+				return nil, err
+			}
+		}
+
+		switch {
+		case existingID != 0 && conflict == ConflictSkip:
+			report.MoviesSkipped++
+		case existingID != 0 && conflict == ConflictOverwrite:
+			query := `
+			UPDATE movies
+			SET title = $1, year = $2, runtime = $3, version = version + 1, updated_at = NOW()
+			WHERE id = $4`
+			_, err := tx.ExecContext(ctx, query, movie.Title, movie.Year, movie.Runtime, existingID)
+			if err != nil {
+				return nil, err
+			}
+			err = setMovieGenres(ctx, tx, existingID, movie.Genres)
+			if err != nil {
+				return nil, err
+			}
+			report.MoviesUpdated++
+		default:
+			query := `
+			INSERT INTO movies (public_id, title, year, runtime)
+			VALUES ($1, $2, $3, $4)
+			RETURNING id`
+			err := tx.QueryRowContext(ctx, query, m.publicIDs(), movie.Title, movie.Year, movie.Runtime).Scan(&existingID)
+			if err != nil {
+				return nil, err
+			}
+			err = setMovieGenres(ctx, tx, existingID, movie.Genres)
+			if err != nil {
+				return nil, err
+			}
+			report.MoviesCreated++
+		}
+		report.IDMap[dm.ID] = existingID
+	}
+
+	if ds.User != nil {
+		err = m.importUser(ctx, tx, ds.User, report)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+func (m DatasetModel) importUser(ctx context.Context, tx *sql.Tx, du *DatasetUser, report *ImportReport) error {
+	var userID int64
+	err := tx.QueryRowContext(ctx, `SELECT id FROM users WHERE email = $1`, du.Email).Scan(&userID)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			report.Errors = append(report.Errors, fmt.Sprintf("user: no account for %s, user data skipped", du.Email))
+			return nil
+		default:
+			return err
+		}
+	}
+
+	for _, genre := range du.Genres {
+		genre = strings.TrimSpace(genre)
+		if genre == "" {
+			continue
+		}
+		query := `
+		INSERT INTO follows (user_id, kind, value)
+		VALUES ($1, 'genre', $2)
+		ON CONFLICT DO NOTHING`
+		result, err := tx.ExecContext(ctx, query, userID, genre)
+		if err != nil {
+			return err
+		}
+		n, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		report.FollowsImported += int(n)
+	}
+
+	for i, ds := range du.Screenings {
+		movieID, ok := report.IDMap[ds.MovieID]
+		if !ok {
+			report.Errors = append(report.Errors, fmt.Sprintf("user.screenings[%d]: movie %d is not in the dataset", i, ds.MovieID))
+			continue
+		}
+		// Screenings in the past are kept for the record, but their reminders are
+		// marked as sent so nobody gets emailed about them.
+		query := `
+		INSERT INTO screenings (movie_id, host_id, starts_at, note, reminder_sent)
+		VALUES ($1, $2, $3, $4, $3 <= NOW())`
+		_, err := tx.ExecContext(ctx, query, movieID, userID, ds.StartsAt, ds.Note)
+		if err != nil {
+			return err
+		}
+		report.ScreeningsImported++
+	}
+
+	err = m.importReviews(ctx, tx, userID, du.Reviews, report)
+	if err != nil {
+		return err
+	}
+
+	for i, item := range du.Watchlist {
+		movieID, ok := report.IDMap[item.MovieID]
+		if !ok {
+			report.Errors = append(report.Errors, fmt.Sprintf("user.watchlist[%d]: movie %d is not in the dataset", i, item.MovieID))
+			continue
+		}
+		query := `
+		INSERT INTO watchlist (user_id, movie_id, watched, added_at, watched_at)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (user_id, movie_id) DO NOTHING`
+		result, err := tx.ExecContext(ctx, query, userID, movieID, item.Watched, item.AddedAt, item.WatchedAt)
+		if err != nil {
+			return err
+		}
+		n, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		report.WatchlistImported += int(n)
+	}
+	return nil
+}
+
+// The importReviews() method imports a user's reviews, leaving out those of movies the
+// user has already reviewed here, and then updates the ratings of the movies reviewed.
+// The movies are locked in order of their IDs first, as ReviewModel does, so that the
+// import can't deadlock with reviews being posted.
+func (m DatasetModel) importReviews(ctx context.Context, tx *sql.Tx, userID int64, reviews []*DatasetReview, report *ImportReport) error {
+	movieIDs := make(map[*DatasetReview]int64, len(reviews))
+	var locked []int64
+	for i, dr := range reviews {
+		movieID, ok := report.IDMap[dr.MovieID]
+		if !ok {
+			report.Errors = append(report.Errors, fmt.Sprintf("user.reviews[%d]: movie %d is not in the dataset", i, dr.MovieID))
+			continue
+		}
+		v := validator.New()
+		if ValidateReview(v, &Review{Rating: dr.Rating, Body: dr.Body}); !v.Valid() {
+			for field, message := range v.Errors {
+				report.Errors = append(report.Errors, fmt.Sprintf("user.reviews[%d]: %s %s", i, field, message))
+			}
+			continue
+		}
+		movieIDs[dr] = movieID
+		locked = append(locked, movieID)
+	}
+
+	sort.Slice(locked, func(i, j int) bool { return locked[i] < locked[j] })
+	for _, movieID := range locked {
+		err := lockMovie(ctx, tx, movieID)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, dr := range reviews {
+		movieID, ok := movieIDs[dr]
+		if !ok {
+			continue
+		}
+		query := `
+		INSERT INTO reviews (user_id, movie_id, rating, body, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $5)
+		ON CONFLICT (user_id, movie_id) DO NOTHING`
+		result, err := tx.ExecContext(ctx, query, userID, movieID, dr.Rating, dr.Body, dr.CreatedAt)
+		if err != nil {
+			return err
+		}
+		n, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		report.ReviewsImported += int(n)
+	}
+
+	return updateRatings(ctx, tx, locked...)
+}
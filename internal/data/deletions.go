@@ -0,0 +1,130 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/shyngys9219/greenlight/internal/validator"
+)
+
+// The policies for deleting a movie. Both take everything which refers to the movie
+// with it; the archive policy first keeps a snapshot of the movie, its reviews and its
+// translations in the movie_archive table.
+const (
+	DeletePolicyCascade = "cascade"
+	DeletePolicyArchive = "archive"
+)
+
+// ValidateDeletePolicy checks the name of a delete policy.
+func ValidateDeletePolicy(v *validator.Validator, policy string) {
+	v.Check(validator.PermittedValue(policy, DeletePolicyCascade, DeletePolicyArchive), "policy", "must be cascade or archive")
+}
+
+// A DeletionReport summarises what Delete did. Removed holds the number of rows deleted
+// from each table which referred to the movie.
+type DeletionReport struct {
+	MovieID  int64            `json:"movie_id"`
+	Policy   string           `json:"policy"`
+	Archived bool             `json:"archived"`
+	Removed  map[string]int64 `json:"removed"`
+}
+
+// movieDependents are the tables which refer to movies by their movie_id column, in the
+// order their rows are deleted. The database would delete them anyway, since their
+// foreign keys cascade, but deleting them here lets Delete count them.
+var movieDependents = []string{
+	"reviews",
+	"movie_translations",
+	"watchlist",
+	"organization_watchlist",
+	"screenings",
+	"visitor_views",
+	"user_movie_views",
+	"movie_credits",
+}
+
+// Delete deletes a movie, and everything which refers to it, in a single transaction
+// under the given policy. userID is the user making the deletion, recorded with an
+// archived movie. If the movie doesn't exist an ErrRecordNotFound error is returned,
+// and if it's no longer at the given version an ErrEditConflict error.
+func (m MovieModel) Delete(id int64, version int32, policy string, userID int64) (*DeletionReport, error) {
+	ctx, cancel := context.WithTimeout(m.context(), 10*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// Lock the movie, so that nothing can be added to it or changed while it's being
+	// deleted.
+	var current int32
+	err = tx.QueryRowContext(ctx, `SELECT version FROM movies WHERE id = $1 FOR UPDATE`, id).Scan(&current)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	if current != version {
+		return nil, ErrEditConflict
+	}
+
+	report := &DeletionReport{MovieID: id, Policy: policy, Removed: make(map[string]int64)}
+
+	if policy == DeletePolicyArchive {
+		query := `
+			INSERT INTO movie_archive (movie_id, public_id, title, snapshot, archived_by)
+			SELECT id, public_id, title, jsonb_build_object(
+				'movie', to_jsonb(movies) || jsonb_build_object('genres', ` + movieGenres + `),
+				'reviews', (SELECT coalesce(jsonb_agg(to_jsonb(reviews) ORDER BY id), '[]') FROM reviews WHERE movie_id = movies.id),
+				'translations', (SELECT coalesce(jsonb_agg(to_jsonb(movie_translations) ORDER BY locale), '[]') FROM movie_translations WHERE movie_id = movies.id),
+				'credits', (SELECT coalesce(jsonb_agg(to_jsonb(movie_credits) ORDER BY position, id), '[]') FROM movie_credits WHERE movie_id = movies.id)
+			), NULLIF($2, 0)
+			FROM movies
+			WHERE id = $1
+			ON CONFLICT (movie_id) DO UPDATE
+			SET snapshot = EXCLUDED.snapshot, archived_by = EXCLUDED.archived_by, archived_at = NOW()`
+		_, err = tx.ExecContext(ctx, query, id, userID)
+		if err != nil {
+			return nil, err
+		}
+		report.Archived = true
+	}
+
+	for _, table := range movieDependents {
+		result, err := tx.ExecContext(ctx, `DELETE FROM `+table+` WHERE movie_id = $1`, id)
+		if err != nil {
+			return nil, err
+		}
+		report.Removed[table], err = result.RowsAffected()
+		if err != nil {
+			return nil, err
+		}
+	}
+	// Redirects from merged duplicates of the movie have nowhere left to go.
+	result, err := tx.ExecContext(ctx, `DELETE FROM movie_redirects WHERE new_id = $1`, id)
+	if err != nil {
+		return nil, err
+	}
+	report.Removed["movie_redirects"], err = result.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM movies WHERE id = $1`, id)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return report, nil
+}
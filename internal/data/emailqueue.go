@@ -0,0 +1,163 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// The statuses of a queued email.
+const (
+	EmailPending = "pending"
+	EmailSent    = "sent"
+	EmailDead    = "dead"
+)
+
+// A QueuedEmail is a rendered email in the email_queue table.
+type QueuedEmail struct {
+	ID            int64     `json:"id"`
+	CreatedAt     time.Time `json:"created_at"`
+	Recipient     string    `json:"recipient"`
+	Template      string    `json:"template"`
+	Subject       string    `json:"subject"`
+	PlainBody     string    `json:"-"`
+	HTMLBody      string    `json:"-"`
+	Status        string    `json:"status"`
+	Attempts      int       `json:"attempts"`
+	NextAttemptAt time.Time `json:"next_attempt_at"`
+	LastError     string    `json:"last_error,omitempty"`
+}
+
+// EmailQueueStats describes the email queue, for the metrics and the healthcheck.
+type EmailQueueStats struct {
+	Pending  int64 `json:"pending"`
+	Retrying int64 `json:"retrying"` // pending emails which have failed at least once
+	Dead     int64 `json:"dead"`
+	// age in seconds of the oldest pending email, 0 if there's none
+	OldestPendingSeconds float64 `json:"oldest_pending_seconds"`
+}
+
+// EmailQueueModel wraps the connection pool for the email_queue table.
+type EmailQueueModel struct {
+	queryScope
+	DB *sql.DB
+}
+
+// Insert queues an email to be sent straight away.
+func (m EmailQueueModel) Insert(email *QueuedEmail) error {
+	query := `
+		INSERT INTO email_queue (recipient, template, subject, plain_body, html_body)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, created_at, status, next_attempt_at`
+
+	ctx, cancel := context.WithTimeout(m.context(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, email.Recipient, email.Template, email.Subject, email.PlainBody, email.HTMLBody).
+		Scan(&email.ID, &email.CreatedAt, &email.Status, &email.NextAttemptAt)
+}
+
+// Claim takes up to limit emails which are due to be sent, counting an attempt for each
+// of them. A claimed email isn't due again until lease has passed, so that it's picked
+// up again if whoever claimed it never reports back. Emails locked by a concurrent
+// claim are skipped, so replicas can claim emails at the same time.
+func (m EmailQueueModel) Claim(limit int, lease time.Duration) ([]*QueuedEmail, error) {
+	query := `
+		UPDATE email_queue
+		SET attempts = attempts + 1, next_attempt_at = NOW() + make_interval(secs => $2)
+		WHERE id IN (
+			SELECT id FROM email_queue
+			WHERE status = 'pending' AND next_attempt_at <= NOW()
+			ORDER BY next_attempt_at
+			LIMIT $1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id, created_at, recipient, template, subject, plain_body, html_body, status, attempts, next_attempt_at, last_error`
+
+	ctx, cancel := context.WithTimeout(m.context(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, limit, lease.Seconds())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var emails []*QueuedEmail
+	for rows.Next() {
+		var e QueuedEmail
+		err := rows.Scan(&e.ID, &e.CreatedAt, &e.Recipient, &e.Template, &e.Subject, &e.PlainBody, &e.HTMLBody,
+			&e.Status, &e.Attempts, &e.NextAttemptAt, &e.LastError)
+		if err != nil {
+			return nil, err
+		}
+		emails = append(emails, &e)
+	}
+	return emails, rows.Err()
+}
+
+// MarkSent records that an email was sent.
+func (m EmailQueueModel) MarkSent(id int64) error {
+	query := `UPDATE email_queue SET status = 'sent', sent_at = NOW(), last_error = '' WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(m.context(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, id)
+	return err
+}
+
+// Retry records a failed attempt to send an email, which is tried again at the given
+// time.
+func (m EmailQueueModel) Retry(id int64, at time.Time, lastError string) error {
+	query := `UPDATE email_queue SET next_attempt_at = $2, last_error = $3 WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(m.context(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, id, at, lastError)
+	return err
+}
+
+// DeadLetter records that an email can't be sent, and gives up on it.
+func (m EmailQueueModel) DeadLetter(id int64, lastError string) error {
+	query := `UPDATE email_queue SET status = 'dead', last_error = $2 WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(m.context(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, id, lastError)
+	return err
+}
+
+// Stats returns the depth of the queue.
+func (m EmailQueueModel) Stats() (EmailQueueStats, error) {
+	query := `
+		SELECT
+			count(*) FILTER (WHERE status = 'pending'),
+			count(*) FILTER (WHERE status = 'pending' AND last_error <> ''),
+			count(*) FILTER (WHERE status = 'dead'),
+			coalesce(extract(epoch FROM NOW() - min(created_at) FILTER (WHERE status = 'pending')), 0)
+		FROM email_queue
+		WHERE status <> 'sent'`
+
+	ctx, cancel := context.WithTimeout(m.context(), 3*time.Second)
+	defer cancel()
+
+	var stats EmailQueueStats
+	err := m.DB.QueryRowContext(ctx, query).Scan(&stats.Pending, &stats.Retrying, &stats.Dead, &stats.OldestPendingSeconds)
+	return stats, err
+}
+
+// DeleteBefore deletes the emails sent, or dead-lettered, before the given times.
+func (m EmailQueueModel) DeleteBefore(sent, dead time.Time) error {
+	query := `
+		DELETE FROM email_queue
+		WHERE (status = 'sent' AND sent_at < $1) OR (status = 'dead' AND created_at < $2)`
+
+	ctx, cancel := context.WithTimeout(m.context(), 10*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, sent, dead)
+	return err
+}
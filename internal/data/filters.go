@@ -0,0 +1,142 @@
+package data
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math"
+	"strings"
+
+	"github.com/shyngys9219/greenlight/internal/validator"
+)
+
+// Filters holds the paging and sorting parameters of a listing. Sort is a field name,
+// with a "-" prefix for descending order, and must be one of the SortSafelist values.
+//
+// Listings are paged by offset, unless Keyset is set: then each page starts after the
+// position held by Cursor (the first page when it's empty), which is taken from the
+// NextCursor of the previous page. Keyset pages cost the same however deep they are.
+type Filters struct {
+	Page         int
+	PageSize     int
+	Sort         string
+	SortSafelist []string
+	Keyset       bool
+	Cursor       string
+}
+
+// ValidateListFilters checks the paging and sorting parameters of a listing.
+func ValidateListFilters(v *validator.Validator, f Filters) {
+	v.Check(f.Page > 0, "page", "must be greater than zero")
+	v.Check(f.Page <= 10_000_000, "page", "must be a maximum of 10 million")
+	v.Check(f.PageSize > 0, "page_size", "must be greater than zero")
+	v.Check(f.PageSize <= 100, "page_size", "must be a maximum of 100")
+	v.Check(validator.PermittedValue(f.Sort, f.SortSafelist...), "sort", "invalid sort value")
+	if f.Keyset {
+		v.Check(f.Page == 1, "page", "can't be used with a cursor")
+		if f.Cursor != "" {
+			c, err := decodeCursor(f.Cursor)
+			v.Check(err == nil, "cursor", "must be a cursor returned by the previous page")
+			v.Check(err != nil || c.Sort == f.Sort, "cursor", "must come from a listing with the same sort order")
+		}
+	}
+}
+
+// sortColumn returns the field to sort on, without the "-" prefix. It panics if the
+// sort value isn't in the safelist, which means the caller skipped validation: the
+// value is written into the SQL, so it must never come straight from the client.
+func (f Filters) sortColumn() string {
+	for _, safeValue := range f.SortSafelist {
+		if f.Sort == safeValue {
+			return strings.TrimPrefix(f.Sort, "-")
+		}
+	}
+	panic("unsafe sort parameter: " + f.Sort)
+}
+
+// sortDirection returns "DESC" for a sort value with a "-" prefix, and "ASC" otherwise.
+func (f Filters) sortDirection() string {
+	if strings.HasPrefix(f.Sort, "-") {
+		return "DESC"
+	}
+	return "ASC"
+}
+
+func (f Filters) limit() int {
+	return f.PageSize
+}
+
+func (f Filters) offset() int {
+	return (f.Page - 1) * f.PageSize
+}
+
+// Metadata describes the page of a listing which was returned, and how many records
+// there are in total. Keyset pages don't count the records, which would take a scan of
+// all of them; they only have the cursor of the next page, if there is one.
+type Metadata struct {
+	CurrentPage  int    `json:"current_page,omitempty"`
+	PageSize     int    `json:"page_size,omitempty"`
+	FirstPage    int    `json:"first_page,omitempty"`
+	LastPage     int    `json:"last_page,omitempty"`
+	TotalRecords int    `json:"total_records,omitempty"`
+	NextCursor   string `json:"next_cursor,omitempty"`
+}
+
+// A Truncation marks a record in a listing whose long text fields were shortened at the
+// client's request: it names the fields, and links to the complete record.
+type Truncation struct {
+	Fields []string `json:"fields"`
+	Href   string   `json:"href"`
+}
+
+// calculateMetadata works out the pagination metadata of a listing from the total number
+// of records, and the current page and page size. If there are no records an empty
+// Metadata is returned.
+func calculateMetadata(totalRecords, page, pageSize int) Metadata {
+	if totalRecords == 0 {
+		return Metadata{}
+	}
+	return Metadata{
+		CurrentPage:  page,
+		PageSize:     pageSize,
+		FirstPage:    1,
+		LastPage:     int(math.Ceil(float64(totalRecords) / float64(pageSize))),
+		TotalRecords: totalRecords,
+	}
+}
+
+// A cursor is the position of the last record of a keyset page: its value of the sort
+// column, and its ID to break ties. It's sent to clients as opaque base64 encoded JSON.
+type cursor struct {
+	Sort  string          `json:"s"`
+	Value json.RawMessage `json:"v"`
+	ID    int64           `json:"id"`
+}
+
+func encodeCursor(sort string, value any, id int64) (string, error) {
+	v, err := json.Marshal(value)
+	if err != nil {
+		return "", err
+	}
+	js, err := json.Marshal(cursor{Sort: sort, Value: v, ID: id})
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(js), nil
+}
+
+func decodeCursor(s string) (*cursor, error) {
+	js, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	var c cursor
+	err = json.Unmarshal(js, &c)
+	if err != nil {
+		return nil, err
+	}
+	if len(c.Value) == 0 || c.ID < 1 {
+		return nil, errors.New("incomplete cursor")
+	}
+	return &c, nil
+}
@@ -0,0 +1,181 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"strconv"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/shyngys9219/greenlight/internal/validator"
+)
+
+// Define constants for the kinds of things a user can follow.
+const (
+	FollowGenre  = "genre"
+	FollowPerson = "person"
+)
+
+// A Follow records that a user wants to hear about new movies in a genre or with a
+// person (an actor or director).
+type Follow struct {
+	UserID    int64     `json:"-"`
+	Kind      string    `json:"kind"`
+	Value     string    `json:"value"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func ValidateFollow(v *validator.Validator, follow *Follow) {
+	v.Check(validator.PermittedValue(follow.Kind, FollowGenre, FollowPerson), "kind", "must be genre or person")
+	v.Check(follow.Value != "", "value", "must be provided")
+	v.Check(len(follow.Value) <= 100, "value", "must not be more than 100 bytes long")
+	if follow.Kind == FollowPerson {
+		id, err := strconv.ParseInt(follow.Value, 10, 64)
+		v.Check(err == nil && id > 0, "value", "must be a person id")
+	}
+	follow.Value = normalizeFollowValue(follow.Kind, follow.Value)
+}
+
+// The normalizeFollowValue() function returns the form a follow's value is stored in.
+// A person's id is written without a sign or leading zeros, so "+7" and "007" are the
+// same follow as "7", and match the ids movies are notified with.
+func normalizeFollowValue(kind, value string) string {
+	if kind != FollowPerson {
+		return value
+	}
+	id, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return value
+	}
+	return strconv.FormatInt(id, 10)
+}
+
+// A Follower is a user to notify about a new movie, together with the follows which
+// matched it.
+type Follower struct {
+	UserID  int64
+	Name    string
+	Email   string
+	Matched []string
+}
+
+// FollowModel wraps the connection pool for the follows table.
+type FollowModel struct {
+	queryScope
+	DB *sql.DB
+}
+
+// Insert adds a follow for a user. Following something twice is not an error. When
+// following a person who doesn't exist an ErrRecordNotFound error is returned.
+func (m FollowModel) Insert(follow *Follow) error {
+	follow.Value = normalizeFollowValue(follow.Kind, follow.Value)
+
+	ctx, cancel := context.WithTimeout(m.context(), 3*time.Second)
+	defer cancel()
+
+	if follow.Kind == FollowPerson {
+		var exists bool
+		err := m.DB.QueryRowContext(ctx, `SELECT EXISTS(SELECT 1 FROM people WHERE id = $1)`, follow.Value).Scan(&exists)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return ErrRecordNotFound
+		}
+	}
+
+	query := `
+	INSERT INTO follows (user_id, kind, value)
+	VALUES ($1, $2, $3)
+	ON CONFLICT (user_id, kind, value) DO UPDATE SET kind = EXCLUDED.kind
+	RETURNING created_at`
+	return m.DB.QueryRowContext(ctx, query, follow.UserID, follow.Kind, follow.Value).Scan(&follow.CreatedAt)
+}
+
+// Delete removes a follow, returning ErrRecordNotFound if the user wasn't following it.
+func (m FollowModel) Delete(userID int64, kind, value string) error {
+	query := `
+	DELETE FROM follows
+	WHERE user_id = $1 AND kind = $2 AND value = $3`
+	ctx, cancel := context.WithTimeout(m.context(), 3*time.Second)
+	defer cancel()
+	result, err := m.DB.ExecContext(ctx, query, userID, kind, normalizeFollowValue(kind, value))
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// GetAllForUser returns everything a user follows.
+func (m FollowModel) GetAllForUser(userID int64) ([]*Follow, error) {
+	query := `
+	SELECT user_id, kind, value, created_at
+	FROM follows
+	WHERE user_id = $1
+	ORDER BY kind, value`
+	ctx, cancel := context.WithTimeout(m.context(), 3*time.Second)
+	defer cancel()
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	follows := []*Follow{}
+	for rows.Next() {
+		var follow Follow
+		err := rows.Scan(&follow.UserID, &follow.Kind, &follow.Value, &follow.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		follows = append(follows, &follow)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return follows, nil
+}
+
+// GetFollowersForMovie returns the activated users following any of the given genres
+// or people, once each, with the values of the follows which matched.
+func (m FollowModel) GetFollowersForMovie(genres []string, personIDs []int64) ([]*Follower, error) {
+	people := make([]string, len(personIDs))
+	for i, id := range personIDs {
+		people[i] = strconv.FormatInt(id, 10)
+	}
+
+	query := `
+	SELECT users.id, users.name, users.email, array_agg(follows.kind || ':' || follows.value ORDER BY follows.kind, follows.value)
+	FROM follows
+	INNER JOIN users ON users.id = follows.user_id
+	WHERE users.activated
+	AND ((follows.kind = 'genre' AND follows.value = ANY($1))
+		OR (follows.kind = 'person' AND follows.value = ANY($2)))
+	GROUP BY users.id, users.name, users.email`
+	ctx, cancel := context.WithTimeout(m.context(), 3*time.Second)
+	defer cancel()
+	rows, err := m.DB.QueryContext(ctx, query, pq.Array(genres), pq.Array(people))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	followers := []*Follower{}
+	for rows.Next() {
+		var follower Follower
+		err := rows.Scan(&follower.UserID, &follower.Name, &follower.Email, pq.Array(&follower.Matched))
+		if err != nil {
+			return nil, err
+		}
+		followers = append(followers, &follower)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return followers, nil
+}
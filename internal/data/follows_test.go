@@ -0,0 +1,29 @@
+package data
+
+import (
+	"testing"
+
+	"github.com/shyngys9219/greenlight/internal/validator"
+)
+
+// A person is followed by their id as movies are notified with it, so other ways of
+// writing it must end up the same.
+func TestValidateFollowNormalizesPersonIDs(t *testing.T) {
+	for _, value := range []string{"7", "+7", "007"} {
+		follow := &Follow{Kind: FollowPerson, Value: value}
+		v := validator.New()
+		ValidateFollow(v, follow)
+		if !v.Valid() {
+			t.Errorf("%q: %v", value, v.Errors)
+		}
+		if follow.Value != "7" {
+			t.Errorf("%q is stored as %q, want %q", value, follow.Value, "7")
+		}
+	}
+
+	follow := &Follow{Kind: FollowGenre, Value: "007"}
+	ValidateFollow(validator.New(), follow)
+	if follow.Value != "007" {
+		t.Errorf("genre %q is stored as %q", "007", follow.Value)
+	}
+}
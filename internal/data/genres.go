@@ -0,0 +1,108 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// A movie's genres are kept in the genres table, linked to it through movies_genres,
+// and movies are still read and written with the names of their genres, in order.
+// movieGenres is the SQL expression of those names, for the SELECT lists of queries on
+// the movies table: pq.Array() scans it like the genres column it replaced.
+const movieGenres = `ARRAY(
+			SELECT genres.name
+			FROM movies_genres
+			INNER JOIN genres ON genres.id = movies_genres.genre_id
+			WHERE movies_genres.movie_id = movies.id
+			ORDER BY movies_genres.position
+		)`
+
+// A Genre is a genre movies can have, with the number of movies which have it.
+type Genre struct {
+	ID         int64  `json:"id"`
+	Name       string `json:"name"`
+	MovieCount int64  `json:"movie_count"`
+}
+
+// GenreModel wraps the connection pool for the genres table.
+type GenreModel struct {
+	queryScope
+	DB *sql.DB
+}
+
+// GetAll returns every genre, in order of name, with the number of movies which have
+// it. Genres are never removed, so some may have no movies left.
+func (m GenreModel) GetAll() ([]*Genre, error) {
+	query := `
+		SELECT genres.id, genres.name, count(movies_genres.movie_id)
+		FROM genres
+		LEFT JOIN movies_genres ON movies_genres.genre_id = genres.id
+		GROUP BY genres.id
+		ORDER BY genres.name`
+
+	ctx, cancel := context.WithTimeout(m.context(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	genres := []*Genre{}
+	for rows.Next() {
+		var genre Genre
+		err := rows.Scan(&genre.ID, &genre.Name, &genre.MovieCount)
+		if err != nil {
+			return nil, err
+		}
+		genres = append(genres, &genre)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return genres, nil
+}
+
+// setMovieGenres replaces the genres of a movie with the named ones, in order, adding
+// the genres which don't exist yet. It's run in the transaction which saves the movie.
+func setMovieGenres(ctx context.Context, db execer, movieID int64, genres []string) error {
+	query := `
+		INSERT INTO genres (name)
+		SELECT unnest($1::text[])
+		ON CONFLICT (name) DO NOTHING`
+	_, err := db.ExecContext(ctx, query, pq.Array(genres))
+	if err != nil {
+		return err
+	}
+
+	_, err = db.ExecContext(ctx, `DELETE FROM movies_genres WHERE movie_id = $1`, movieID)
+	if err != nil {
+		return err
+	}
+
+	query = `
+		INSERT INTO movies_genres (movie_id, genre_id, position)
+		SELECT $1, genres.id, g.position
+		FROM unnest($2::text[]) WITH ORDINALITY AS g(name, position)
+		INNER JOIN genres ON genres.name = g.name
+		ON CONFLICT (movie_id, genre_id) DO NOTHING`
+	_, err = db.ExecContext(ctx, query, movieID, pq.Array(genres))
+	return err
+}
+
+// dedupe returns the values without their duplicates, in the order they first appear.
+func dedupe[T comparable](values []T) []T {
+	seen := make(map[T]bool, len(values))
+	var unique []T
+	for _, value := range values {
+		if !seen[value] {
+			seen[value] = true
+			unique = append(unique, value)
+		}
+	}
+	return unique
+}
@@ -0,0 +1,134 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"github.com/shyngys9219/greenlight/internal/health"
+)
+
+// HealthModel wraps the connection pool for probing the database and for the
+// health_checks table, which keeps the probe history when persistence is enabled.
+type HealthModel struct {
+	queryScope
+	DB *sql.DB
+}
+
+// Ping checks that a connection to the database can be established and used.
+func (m HealthModel) Ping(ctx context.Context) error {
+	return m.DB.PingContext(ctx)
+}
+
+// Writable reports whether the database accepts writes. It doesn't while a standby is
+// being promoted during a failover, or when it has been made read-only.
+func (m HealthModel) Writable(ctx context.Context) (bool, error) {
+	var writable bool
+	err := m.DB.QueryRowContext(ctx, `SELECT NOT pg_is_in_recovery() AND current_setting('transaction_read_only') = 'off'`).Scan(&writable)
+	return writable, err
+}
+
+// Insert stores a probe result.
+func (m HealthModel) Insert(res *health.Result) error {
+	checks, err := json.Marshal(res.Checks)
+	if err != nil {
+		return err
+	}
+	query := `
+	INSERT INTO health_checks (checked_at, status, checks)
+	VALUES ($1, $2, $3)`
+	ctx, cancel := context.WithTimeout(m.context(), 3*time.Second)
+	defer cancel()
+	_, err = m.DB.ExecContext(ctx, query, res.Time, res.Status, checks)
+	return err
+}
+
+// GetSince returns up to limit probe results recorded after the given time, oldest
+// first.
+func (m HealthModel) GetSince(since time.Time, limit int) ([]health.Result, error) {
+	query := `
+	SELECT checked_at, status, checks FROM (
+		SELECT checked_at, status, checks
+		FROM health_checks
+		WHERE checked_at > $1
+		ORDER BY checked_at DESC
+		LIMIT $2
+	) AS recent
+	ORDER BY checked_at`
+	ctx, cancel := context.WithTimeout(m.context(), 3*time.Second)
+	defer cancel()
+	rows, err := m.DB.QueryContext(ctx, query, since, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	results := []health.Result{}
+	for rows.Next() {
+		var (
+			res    health.Result
+			checks []byte
+		)
+		err := rows.Scan(&res.Time, &res.Status, &checks)
+		if err != nil {
+			return nil, err
+		}
+		err = json.Unmarshal(checks, &res.Checks)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, res)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// DeleteBefore removes probe results older than the given time, so the table doesn't
+// grow forever.
+func (m HealthModel) DeleteBefore(before time.Time) error {
+	ctx, cancel := context.WithTimeout(m.context(), 3*time.Second)
+	defer cancel()
+	_, err := m.DB.ExecContext(ctx, `DELETE FROM health_checks WHERE checked_at < $1`, before)
+	return err
+}
+
+// TallySince counts the persisted results recorded after the given time, per
+// dependency, in the same way as health.TallySince.
+func (m HealthModel) TallySince(since time.Time) (map[string]health.Tally, int, time.Time, error) {
+	ctx, cancel := context.WithTimeout(m.context(), 5*time.Second)
+	defer cancel()
+
+	var probes int
+	var first sql.NullTime
+	err := m.DB.QueryRowContext(ctx, `SELECT count(*), min(checked_at) FROM health_checks WHERE checked_at > $1`, since).Scan(&probes, &first)
+	if err != nil {
+		return nil, 0, time.Time{}, err
+	}
+
+	query := `
+	SELECT checks.key, count(*) FILTER (WHERE checks.value->>'status' = 'up'), count(*)
+	FROM health_checks, jsonb_each(health_checks.checks) AS checks
+	WHERE health_checks.checked_at > $1
+	GROUP BY checks.key`
+	rows, err := m.DB.QueryContext(ctx, query, since)
+	if err != nil {
+		return nil, 0, time.Time{}, err
+	}
+	defer rows.Close()
+	tallies := make(map[string]health.Tally)
+	for rows.Next() {
+		var name string
+		var t health.Tally
+		err := rows.Scan(&name, &t.Up, &t.Total)
+		if err != nil {
+			return nil, 0, time.Time{}, err
+		}
+		tallies[name] = t
+	}
+	if err = rows.Err(); err != nil {
+		return nil, 0, time.Time{}, err
+	}
+	return tallies, probes, first.Time, nil
+}
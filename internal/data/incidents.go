@@ -0,0 +1,174 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/shyngys9219/greenlight/internal/validator"
+)
+
+// Define constants for the stages of an incident on the status page.
+const (
+	IncidentInvestigating = "investigating"
+	IncidentIdentified    = "identified"
+	IncidentMonitoring    = "monitoring"
+	IncidentResolved      = "resolved"
+)
+
+// StatusComponents are the components shown on the status page, which incidents can
+// affect.
+var StatusComponents = []string{"api", "database", "email"}
+
+// A StatusIncident is a note about an outage or degradation, published on the public
+// status page by an admin. Not to be confused with incident mode, which is a security
+// measure.
+type StatusIncident struct {
+	ID         int64     `json:"id"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+	Title      string    `json:"title"`
+	Message    string    `json:"message"`
+	Status     string    `json:"status"`
+	Components []string  `json:"components"`
+	Version    int32     `json:"version"`
+}
+
+func ValidateStatusIncident(v *validator.Validator, incident *StatusIncident) {
+	v.Check(incident.Title != "", "title", "must be provided")
+	v.Check(len(incident.Title) <= 200, "title", "must not be more than 200 bytes long")
+	v.Check(len(incident.Message) <= 5000, "message", "must not be more than 5000 bytes long")
+	v.Check(validator.PermittedValue(incident.Status, IncidentInvestigating, IncidentIdentified, IncidentMonitoring, IncidentResolved), "status", "must be investigating, identified, monitoring or resolved")
+	v.Check(validator.Unique(incident.Components), "components", "must not contain duplicate values")
+	for _, component := range incident.Components {
+		v.Check(validator.PermittedValue(component, StatusComponents...), "components", "must only contain api, database or email")
+	}
+}
+
+// StatusIncidentModel wraps the connection pool for the status_incidents table.
+type StatusIncidentModel struct {
+	queryScope
+	DB *sql.DB
+}
+
+func (m StatusIncidentModel) Insert(incident *StatusIncident) error {
+	query := `
+	INSERT INTO status_incidents (title, message, status, components)
+	VALUES ($1, $2, $3, $4)
+	RETURNING id, created_at, updated_at, version`
+	args := []any{incident.Title, incident.Message, incident.Status, pq.Array(incident.Components)}
+	ctx, cancel := context.WithTimeout(m.context(), 3*time.Second)
+	defer cancel()
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(&incident.ID, &incident.CreatedAt, &incident.UpdatedAt, &incident.Version)
+}
+
+func (m StatusIncidentModel) Get(id int64) (*StatusIncident, error) {
+	query := `
+	SELECT id, created_at, updated_at, title, message, status, components, version
+	FROM status_incidents
+	WHERE id = $1`
+	var incident StatusIncident
+	ctx, cancel := context.WithTimeout(m.context(), 3*time.Second)
+	defer cancel()
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&incident.ID,
+		&incident.CreatedAt,
+		&incident.UpdatedAt,
+		&incident.Title,
+		&incident.Message,
+		&incident.Status,
+		pq.Array(&incident.Components),
+		&incident.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &incident, nil
+}
+
+// Update saves changes to an incident, returning ErrEditConflict if it was changed
+// since it was read.
+func (m StatusIncidentModel) Update(incident *StatusIncident) error {
+	query := `
+	UPDATE status_incidents
+	SET title = $1, message = $2, status = $3, components = $4, updated_at = NOW(), version = version + 1
+	WHERE id = $5 AND version = $6
+	RETURNING updated_at, version`
+	args := []any{incident.Title, incident.Message, incident.Status, pq.Array(incident.Components), incident.ID, incident.Version}
+	ctx, cancel := context.WithTimeout(m.context(), 3*time.Second)
+	defer cancel()
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&incident.UpdatedAt, &incident.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+func (m StatusIncidentModel) Delete(id int64) error {
+	ctx, cancel := context.WithTimeout(m.context(), 3*time.Second)
+	defer cancel()
+	result, err := m.DB.ExecContext(ctx, `DELETE FROM status_incidents WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// GetRecent returns every unresolved incident and those resolved after the given time,
+// most recently updated first.
+func (m StatusIncidentModel) GetRecent(resolvedSince time.Time) ([]*StatusIncident, error) {
+	query := `
+	SELECT id, created_at, updated_at, title, message, status, components, version
+	FROM status_incidents
+	WHERE status <> 'resolved' OR updated_at > $1
+	ORDER BY updated_at DESC, id DESC
+	LIMIT 50`
+	ctx, cancel := context.WithTimeout(m.context(), 3*time.Second)
+	defer cancel()
+	rows, err := m.DB.QueryContext(ctx, query, resolvedSince)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	incidents := []*StatusIncident{}
+	for rows.Next() {
+		var incident StatusIncident
+		err := rows.Scan(
+			&incident.ID,
+			&incident.CreatedAt,
+			&incident.UpdatedAt,
+			&incident.Title,
+			&incident.Message,
+			&incident.Status,
+			pq.Array(&incident.Components),
+			&incident.Version,
+		)
+		if err != nil {
+			return nil, err
+		}
+		incidents = append(incidents, &incident)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return incidents, nil
+}
@@ -0,0 +1,438 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// A MergeReport summarises what Merge did.
+type MergeReport struct {
+	DuplicateID                int64 `json:"duplicate_id"`
+	CanonicalID                int64 `json:"canonical_id"`
+	ScreeningsReassigned       int64 `json:"screenings_reassigned"`
+	WatchlistEntriesReassigned int64 `json:"watchlist_entries_reassigned"`
+	WatchlistItemsReassigned   int64 `json:"watchlist_items_reassigned"`
+	TranslationsReassigned     int64 `json:"translations_reassigned"`
+	ReviewsReassigned          int64 `json:"reviews_reassigned"`
+	CreditsReassigned          int64 `json:"credits_reassigned"`
+	RedirectsUpdated           int64 `json:"redirects_updated"`
+}
+
+// Merge merges a duplicate movie into the canonical one in a single transaction.
+// Everything which refers to the duplicate is moved over to the canonical movie, the
+// duplicate is deleted, and a redirect from its old IDs to the canonical movie is left
+// behind. Existing redirects to the duplicate are pointed at the canonical movie too,
+// so redirects never chain. If either movie doesn't exist an ErrRecordNotFound error
+// is returned.
+func (m MovieModel) Merge(duplicateID, canonicalID int64) (*MergeReport, error) {
+	ctx, cancel := context.WithTimeout(m.context(), 10*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// Lock both movies, so that neither can be changed or merged elsewhere while the
+	// merge is in progress.
+	var n int
+	query := `
+		SELECT count(*) FROM (
+			SELECT id FROM movies WHERE id = ANY($1) FOR UPDATE
+		) AS locked`
+	err = tx.QueryRowContext(ctx, query, pq.Array([]int64{duplicateID, canonicalID})).Scan(&n)
+	if err != nil {
+		return nil, err
+	}
+	if n != 2 {
+		return nil, ErrRecordNotFound
+	}
+
+	report := &MergeReport{DuplicateID: duplicateID, CanonicalID: canonicalID}
+
+	// Everything which refers to a movie has to be reassigned here, or the delete
+	// below will take it with the duplicate.
+	result, err := tx.ExecContext(ctx, `UPDATE screenings SET movie_id = $1 WHERE movie_id = $2`, canonicalID, duplicateID)
+	if err != nil {
+		return nil, err
+	}
+	report.ScreeningsReassigned, err = result.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+
+	// Organizations which have both movies on their watchlist keep the canonical
+	// movie's entry.
+	query = `
+		INSERT INTO organization_watchlist (organization_id, movie_id, note, added_by, added_at)
+		SELECT organization_id, $1, note, added_by, added_at
+		FROM organization_watchlist WHERE movie_id = $2
+		ON CONFLICT (organization_id, movie_id) DO NOTHING`
+	result, err = tx.ExecContext(ctx, query, canonicalID, duplicateID)
+	if err != nil {
+		return nil, err
+	}
+	report.WatchlistEntriesReassigned, err = result.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+
+	// Users who saved both movies keep the canonical movie's item, which counts as
+	// watched if either of them was.
+	query = `
+		INSERT INTO watchlist (user_id, movie_id, watched, added_at, watched_at)
+		SELECT user_id, $1, watched, added_at, watched_at
+		FROM watchlist WHERE movie_id = $2
+		ON CONFLICT (user_id, movie_id) DO UPDATE
+		SET watched = watchlist.watched OR EXCLUDED.watched,
+			watched_at = GREATEST(watchlist.watched_at, EXCLUDED.watched_at)`
+	result, err = tx.ExecContext(ctx, query, canonicalID, duplicateID)
+	if err != nil {
+		return nil, err
+	}
+	report.WatchlistItemsReassigned, err = result.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+
+	// Translations the canonical movie doesn't have yet are taken from the duplicate.
+	query = `
+		INSERT INTO movie_translations (movie_id, locale, title, overview, updated_at)
+		SELECT $1, locale, title, overview, updated_at
+		FROM movie_translations WHERE movie_id = $2
+		ON CONFLICT (movie_id, locale) DO NOTHING`
+	result, err = tx.ExecContext(ctx, query, canonicalID, duplicateID)
+	if err != nil {
+		return nil, err
+	}
+	report.TranslationsReassigned, err = result.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+
+	// Users who reviewed both movies keep their review of the canonical movie.
+	query = `
+		UPDATE reviews SET movie_id = $1
+		WHERE movie_id = $2
+		AND user_id NOT IN (SELECT user_id FROM reviews WHERE movie_id = $1)`
+	result, err = tx.ExecContext(ctx, query, canonicalID, duplicateID)
+	if err != nil {
+		return nil, err
+	}
+	report.ReviewsReassigned, err = result.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+
+	// Credits the canonical movie has already are dropped with the duplicate; the others
+	// go after the canonical movie's own.
+	query = `
+		UPDATE movie_credits AS credits
+		SET movie_id = $1, position = credits.position + (
+			SELECT coalesce(max(position), 0) FROM movie_credits WHERE movie_id = $1
+		)
+		WHERE credits.movie_id = $2
+		AND NOT EXISTS (
+			SELECT 1 FROM movie_credits AS canonical
+			WHERE canonical.movie_id = $1 AND canonical.person_id = credits.person_id
+			AND canonical.role = credits.role AND canonical.character = credits.character
+		)`
+	result, err = tx.ExecContext(ctx, query, canonicalID, duplicateID)
+	if err != nil {
+		return nil, err
+	}
+	report.CreditsReassigned, err = result.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+
+	// Views of both movies count as one view of the canonical movie, at the later time.
+	for _, query := range []string{`
+		INSERT INTO visitor_views (visitor_hash, movie_id, viewed_at)
+		SELECT visitor_hash, $1, viewed_at FROM visitor_views WHERE movie_id = $2
+		ON CONFLICT (visitor_hash, movie_id) DO UPDATE
+		SET viewed_at = GREATEST(visitor_views.viewed_at, EXCLUDED.viewed_at)`, `
+		INSERT INTO user_movie_views (user_id, movie_id, viewed_at)
+		SELECT user_id, $1, viewed_at FROM user_movie_views WHERE movie_id = $2
+		ON CONFLICT (user_id, movie_id) DO UPDATE
+		SET viewed_at = GREATEST(user_movie_views.viewed_at, EXCLUDED.viewed_at)`,
+	} {
+		_, err = tx.ExecContext(ctx, query, canonicalID, duplicateID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	result, err = tx.ExecContext(ctx, `UPDATE movie_redirects SET new_id = $1 WHERE new_id = $2`, canonicalID, duplicateID)
+	if err != nil {
+		return nil, err
+	}
+	report.RedirectsUpdated, err = result.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+
+	query = `
+		INSERT INTO movie_redirects (old_id, old_public_id, new_id)
+		SELECT id, public_id, $1 FROM movies WHERE id = $2`
+	_, err = tx.ExecContext(ctx, query, canonicalID, duplicateID)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM movies WHERE id = $1`, duplicateID)
+	if err != nil {
+		return nil, err
+	}
+	err = updateRatings(ctx, tx, canonicalID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// GetRedirect returns the movie that a merged duplicate movie now redirects to. If the
+// ID was never merged an ErrRecordNotFound error is returned.
+func (m MovieModel) GetRedirect(oldID int64) (*Movie, error) {
+	query := `
+		SELECT movies.id, movies.public_id, movies.created_at, movies.title, movies.year, movies.runtime, ` + movieGenres + `, movies.version, movies.average_rating, movies.review_count
+		FROM movie_redirects
+		INNER JOIN movies ON movies.id = movie_redirects.new_id
+		WHERE movie_redirects.old_id = $1`
+
+	ctx, cancel := context.WithTimeout(m.context(), 3*time.Second)
+	defer cancel()
+
+	var movie Movie
+	err := m.DB.QueryRowContext(ctx, query, oldID).Scan(
+		&movie.ID,
+		&movie.PublicID,
+		&movie.CreatedAt,
+		&movie.Title,
+		&movie.Year,
+		&movie.Runtime,
+		pq.Array(&movie.Genres),
+		&movie.Version,
+		&movie.AverageRating,
+		&movie.ReviewCount,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &movie, nil
+}
+
+// An AccountMergeReport summarises what UserModel.Merge did, or would do.
+type AccountMergeReport struct {
+	SourceID               int64 `json:"source_id"`
+	TargetID               int64 `json:"target_id"`
+	FollowsTransferred     int64 `json:"follows_transferred"`
+	FollowsSkipped         int64 `json:"follows_skipped"`
+	ScreeningsTransferred  int64 `json:"screenings_transferred"`
+	InvitesTransferred     int64 `json:"invites_transferred"`
+	MembershipsTransferred int64 `json:"memberships_transferred"`
+	ReviewsTransferred     int64 `json:"reviews_transferred"`
+	WatchlistTransferred   int64 `json:"watchlist_transferred"`
+}
+
+// Merge moves everything owned by the source user over to the target user and then
+// deletes the source user, in a single transaction. Conflicts are resolved in favour of
+// the target: follows which the target already has are skipped, so are reviews of movies
+// the target has reviewed too, and if both users were invited to the same screening the
+// target's answer is kept unless it's still pending.
+// The source's tokens are dropped with it, authentication and refresh tokens included,
+// so its sessions end rather than carrying on as the target, as are its permissions,
+// which the target doesn't gain.
+//
+// If dryRun is true the transaction is rolled back, so the report shows what a merge
+// would do without changing anything. If either user doesn't exist an
+// ErrRecordNotFound error is returned.
+func (m UserModel) Merge(sourceID, targetID int64, dryRun bool) (*AccountMergeReport, error) {
+	ctx, cancel := context.WithTimeout(m.context(), 10*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var n int
+	query := `
+		SELECT count(*) FROM (
+			SELECT id FROM users WHERE id = ANY($1) FOR UPDATE
+		) AS locked`
+	err = tx.QueryRowContext(ctx, query, pq.Array([]int64{sourceID, targetID})).Scan(&n)
+	if err != nil {
+		return nil, err
+	}
+	if n != 2 {
+		return nil, ErrRecordNotFound
+	}
+
+	// The movies the source reviewed are locked, since their ratings change if the
+	// source's reviews are dropped.
+	reviewed, err := lockReviewedMovies(ctx, tx, sourceID)
+	if err != nil {
+		return nil, err
+	}
+	// The source's votes on reviews are dropped with it, rather than transferred, as
+	// the target may have voted on the same reviews or written them.
+	voted, err := lockVotedReviews(ctx, tx, sourceID)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &AccountMergeReport{SourceID: sourceID, TargetID: targetID}
+
+	// exec runs a statement with the target and source IDs as its arguments and adds
+	// the number of affected rows to the given counter.
+	exec := func(counter *int64, query string) error {
+		result, err := tx.ExecContext(ctx, query, targetID, sourceID)
+		if err != nil {
+			return err
+		}
+		rows, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if counter != nil {
+			*counter += rows
+		}
+		return nil
+	}
+
+	var follows int64
+	err = tx.QueryRowContext(ctx, `SELECT count(*) FROM follows WHERE user_id = $1`, sourceID).Scan(&follows)
+	if err != nil {
+		return nil, err
+	}
+	err = exec(&report.FollowsTransferred, `
+		INSERT INTO follows (user_id, kind, value, created_at)
+		SELECT $1, kind, value, created_at FROM follows WHERE user_id = $2
+		ON CONFLICT DO NOTHING`)
+	if err != nil {
+		return nil, err
+	}
+	report.FollowsSkipped = follows - report.FollowsTransferred
+
+	err = exec(&report.ScreeningsTransferred, `UPDATE screenings SET host_id = $1 WHERE host_id = $2`)
+	if err != nil {
+		return nil, err
+	}
+
+	// Invites to screenings which the target now hosts are dropped, both the source's
+	// and any the target had, since a host doesn't invite themselves.
+	err = exec(&report.InvitesTransferred, `
+		INSERT INTO screening_invites (screening_id, user_id, rsvp)
+		SELECT screening_invites.screening_id, $1, screening_invites.rsvp
+		FROM screening_invites
+		INNER JOIN screenings ON screenings.id = screening_invites.screening_id
+		WHERE screening_invites.user_id = $2 AND screenings.host_id <> $1
+		ON CONFLICT (screening_id, user_id) DO UPDATE
+		SET rsvp = CASE WHEN screening_invites.rsvp = 'pending' THEN EXCLUDED.rsvp ELSE screening_invites.rsvp END`)
+	if err != nil {
+		return nil, err
+	}
+	err = exec(nil, `
+		DELETE FROM screening_invites
+		USING screenings
+		WHERE screenings.id = screening_invites.screening_id
+		AND screenings.host_id = $1
+		AND screening_invites.user_id IN ($1, $2)`)
+	if err != nil {
+		return nil, err
+	}
+
+	// Memberships are merged keeping the higher of the two roles.
+	err = exec(&report.MembershipsTransferred, `
+		INSERT INTO organization_members (organization_id, user_id, role)
+		SELECT organization_id, $1, role FROM organization_members WHERE user_id = $2
+		ON CONFLICT (organization_id, user_id) DO UPDATE
+		SET role = CASE
+			WHEN 'owner' IN (organization_members.role, EXCLUDED.role) THEN 'owner'
+			WHEN 'editor' IN (organization_members.role, EXCLUDED.role) THEN 'editor'
+			ELSE 'viewer'
+		END`)
+	if err != nil {
+		return nil, err
+	}
+	err = exec(nil, `
+		INSERT INTO user_movie_views (user_id, movie_id, viewed_at)
+		SELECT $1, movie_id, viewed_at FROM user_movie_views WHERE user_id = $2
+		ON CONFLICT (user_id, movie_id) DO UPDATE
+		SET viewed_at = GREATEST(user_movie_views.viewed_at, EXCLUDED.viewed_at)`)
+	if err != nil {
+		return nil, err
+	}
+	err = exec(nil, `UPDATE organization_watchlist SET added_by = $1 WHERE added_by = $2`)
+	if err != nil {
+		return nil, err
+	}
+	err = exec(nil, `UPDATE api_keys SET created_by = $1 WHERE created_by = $2`)
+	if err != nil {
+		return nil, err
+	}
+
+	err = exec(&report.WatchlistTransferred, `
+		INSERT INTO watchlist (user_id, movie_id, watched, added_at, watched_at)
+		SELECT $1, movie_id, watched, added_at, watched_at FROM watchlist WHERE user_id = $2
+		ON CONFLICT (user_id, movie_id) DO UPDATE
+		SET watched = watchlist.watched OR EXCLUDED.watched,
+			watched_at = GREATEST(watchlist.watched_at, EXCLUDED.watched_at)`)
+	if err != nil {
+		return nil, err
+	}
+
+	// Reviews of movies the target has reviewed too are dropped with the source.
+	err = exec(&report.ReviewsTransferred, `
+		UPDATE reviews SET user_id = $1
+		WHERE user_id = $2
+		AND movie_id NOT IN (SELECT movie_id FROM reviews WHERE user_id = $1)`)
+	if err != nil {
+		return nil, err
+	}
+
+	// Deleting the source cascades to whatever is left of its data. The target's
+	// version is bumped, since what it owns has changed.
+	_, err = tx.ExecContext(ctx, `DELETE FROM users WHERE id = $1`, sourceID)
+	if err != nil {
+		return nil, err
+	}
+	_, err = tx.ExecContext(ctx, `UPDATE users SET version = version + 1 WHERE id = $1`, targetID)
+	if err != nil {
+		return nil, err
+	}
+	err = updateRatings(ctx, tx, reviewed...)
+	if err != nil {
+		return nil, err
+	}
+	err = updateHelpfulness(ctx, tx, voted...)
+	if err != nil {
+		return nil, err
+	}
+
+	if dryRun {
+		return report, nil
+	}
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return report, nil
+}
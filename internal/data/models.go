@@ -1,8 +1,12 @@
 package data
 
 import (
+	"context"
 	"database/sql"
 	"errors"
+	"time"
+
+	"github.com/shyngys9219/greenlight/internal/publicid"
 )
 
 // Define a custom ErrRecordNotFound error. We'll return this from our Get() method when
@@ -15,16 +19,176 @@ var (
 // Create a Models struct which wraps the MovieModel
 // kind of enveloping
 type Models struct {
-	Movies MovieModel
-	Users  UserModel
-	Tokens TokenModel // used to generate activation tokens
+	Movies     MovieModel
+	Users      UserModel
+	Tokens     TokenModel // used to generate activation tokens
+	Screenings ScreeningModel
+	Follows    FollowModel
+	Datasets   DatasetModel // portable export and import of data between deployments
+	Health     HealthModel
+	// organizations, their members and watchlists, and their API keys
+	Organizations OrganizationModel
+	APIKeys       APIKeyModel
+	// plans, their entitlements and request quotas
+	Plans   PlanModel
+	Billing BillingModel
+	// incident notes for the public status page
+	StatusIncidents StatusIncidentModel
+	// search analytics
+	Searches SearchModel
+	// when listings last changed, for conditional requests
+	ListWatermarks ListWatermarkModel
+	// automated email sequences
+	Campaigns CampaignModel
+	// localized movie titles and overviews
+	MovieTranslations MovieTranslationModel
+	// what each user is allowed to do
+	Permissions PermissionModel
+	// browsing history of anonymous visitors who opted in to personalization
+	Visitors VisitorModel
+	// exemptions from and overrides of the global rate limit
+	RateLimitOverrides RateLimitOverrideModel
+	// users' reviews and ratings of movies
+	Reviews ReviewModel
+	// movies users saved to watch later
+	Watchlist WatchlistModel
+	// users' two-factor authentication secrets and recovery codes
+	TwoFactor TwoFactorModel
+	// tokens confirming destructive operations
+	Confirmations ConfirmationModel
+	// emails waiting to be sent
+	EmailQueue EmailQueueModel
+	// daily totals of the business metrics
+	BusinessMetrics BusinessMetricModel
+	// ratings, popularity and box office takings of movies, and where they came from
+	MovieFacts MovieFactModel
+	// users' consents to the optional processing of their data
+	Consents ConsentModel
+	// definitions of the custom fields of movies
+	CustomFields CustomFieldModel
+	// movies' availability and release dates in each region
+	Availability AvailabilityModel
+	// organizations' webhook subscriptions, and the deliveries queued for them
+	Webhooks          WebhookModel
+	WebhookDeliveries WebhookDeliveryModel
+	// the genres of movies
+	Genres GenreModel
+	// users' recent writes, and the accounts restricted for writing too fast
+	Abuse AbuseModel
+	// the people who make movies, and their credits on them
+	People PersonModel
+	// users' in-app notifications and which channels they get notifications over
+	Notifications NotificationModel
 }
 
-// method which returns a Models struct containing the initialized MovieModel.
-func NewModels(db *sql.DB) Models {
+// method which returns a Models struct containing the initialized MovieModel. The
+// publicIDs generator is used for the public IDs of new movies and users.
+func NewModels(db *sql.DB, publicIDs publicid.Generator) Models {
 	return Models{
-		Movies: MovieModel{DB: db},
-		Users:  UserModel{DB: db},
-		Tokens: TokenModel{DB: db}, // new TokenModel initilization
+		Movies:     MovieModel{DB: db, stmts: newStmtCache(db), publicIDs: publicIDs},
+		Users:      UserModel{DB: db, publicIDs: publicIDs},
+		Tokens:     TokenModel{DB: db}, // new TokenModel initilization
+		Screenings: ScreeningModel{DB: db},
+		Follows:    FollowModel{DB: db},
+		Datasets:   DatasetModel{DB: db, publicIDs: publicIDs},
+		Health:     HealthModel{DB: db},
+
+		Organizations: OrganizationModel{DB: db},
+		APIKeys:       APIKeyModel{DB: db},
+		Plans:         PlanModel{DB: db},
+		Billing:       BillingModel{DB: db},
+
+		StatusIncidents: StatusIncidentModel{DB: db},
+		Searches:        SearchModel{DB: db},
+		ListWatermarks:  ListWatermarkModel{DB: db},
+		Campaigns:       CampaignModel{DB: db},
+
+		MovieTranslations: MovieTranslationModel{DB: db},
+		Permissions:       PermissionModel{DB: db},
+		Visitors:          VisitorModel{DB: db},
+
+		RateLimitOverrides: RateLimitOverrideModel{DB: db},
+		Reviews:            ReviewModel{DB: db},
+		Watchlist:          WatchlistModel{DB: db},
+		TwoFactor:          TwoFactorModel{DB: db},
+		Confirmations:      ConfirmationModel{DB: db},
+		EmailQueue:         EmailQueueModel{DB: db},
+		BusinessMetrics:    BusinessMetricModel{DB: db},
+		MovieFacts:         MovieFactModel{DB: db},
+		Consents:           ConsentModel{DB: db},
+		CustomFields:       CustomFieldModel{DB: db},
+		Availability:       AvailabilityModel{DB: db},
+		Webhooks:           WebhookModel{DB: db},
+		WebhookDeliveries:  WebhookDeliveryModel{DB: db},
+		Genres:             GenreModel{DB: db},
+		Abuse:              AbuseModel{DB: db},
+		People:             PersonModel{DB: db},
+		Notifications:      NotificationModel{DB: db},
+	}
+}
+
+// WithContext returns a copy of the models whose queries carry the values of ctx, for
+// example the query statistics of the request they're used for. Only the values are
+// used: the queries keep their own timeouts, and aren't cancelled along with ctx, so
+// that work which outlives a request can still finish.
+func (m Models) WithContext(ctx context.Context) Models {
+	scope := queryScope{values: valuesOnly{ctx}}
+	m.Movies.queryScope = scope
+	m.Users.queryScope = scope
+	m.Tokens.queryScope = scope
+	m.Screenings.queryScope = scope
+	m.Follows.queryScope = scope
+	m.Datasets.queryScope = scope
+	m.Health.queryScope = scope
+	m.Organizations.queryScope = scope
+	m.APIKeys.queryScope = scope
+	m.Plans.queryScope = scope
+	m.Billing.queryScope = scope
+	m.StatusIncidents.queryScope = scope
+	m.Searches.queryScope = scope
+	m.ListWatermarks.queryScope = scope
+	m.Campaigns.queryScope = scope
+	m.MovieTranslations.queryScope = scope
+	m.Permissions.queryScope = scope
+	m.Visitors.queryScope = scope
+	m.RateLimitOverrides.queryScope = scope
+	m.Reviews.queryScope = scope
+	m.Watchlist.queryScope = scope
+	m.TwoFactor.queryScope = scope
+	m.Confirmations.queryScope = scope
+	m.EmailQueue.queryScope = scope
+	m.BusinessMetrics.queryScope = scope
+	m.MovieFacts.queryScope = scope
+	m.Consents.queryScope = scope
+	m.CustomFields.queryScope = scope
+	m.Availability.queryScope = scope
+	m.Webhooks.queryScope = scope
+	m.WebhookDeliveries.queryScope = scope
+	m.Genres.queryScope = scope
+	m.Abuse.queryScope = scope
+	m.People.queryScope = scope
+	m.Notifications.queryScope = scope
+	return m
+}
+
+// A queryScope is embedded in every model to hold the context the model's queries are
+// derived from.
+type queryScope struct {
+	values context.Context
+}
+
+func (s queryScope) context() context.Context {
+	if s.values == nil {
+		return context.Background()
 	}
-}
\ No newline at end of file
+	return s.values
+}
+
+// valuesOnly is a context which has the values of its parent, but never expires.
+type valuesOnly struct {
+	context.Context
+}
+
+func (valuesOnly) Deadline() (time.Time, bool) { return time.Time{}, false }
+func (valuesOnly) Done() <-chan struct{}       { return nil }
+func (valuesOnly) Err() error                  { return nil }
@@ -0,0 +1,63 @@
+package data
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// movieExportTimeout is the longest an export of the movies can read from the database,
+// the same as the server's WriteTimeout, which would cut the response off anyway.
+const movieExportTimeout = 30 * time.Second
+
+// Export calls fn with each of the movies matching a search, as GetAll() finds them
+// without the fuzzy fallback, in the order of the sort. The movies are read from the
+// database as fn goes, rather than all at once, so the whole catalog can be exported
+// without holding it in memory. An error from fn stops the export, and is returned.
+// The paging parameters of sort are ignored.
+func (m MovieModel) Export(title string, genres []string, genreIDs []int64, filters []Filter, custom []CustomFieldFilter, sort Filters, fn func(*Movie) error) error {
+	b := movieConditions(title, false, genres, genreIDs, filters, custom)
+	query := fmt.Sprintf(`
+		SELECT movies.id, movies.public_id, movies.created_at, movies.title, movies.year, movies.runtime, `+movieGenres+`, movies.version, movies.average_rating, movies.review_count, movies.custom_fields, movies.poster_url, movies.poster_preview
+		FROM movies
+		WHERE %s
+		ORDER BY movies.%s %s, movies.id ASC`, b.where(), sort.sortColumn(), sort.sortDirection())
+
+	ctx, cancel := context.WithTimeout(m.context(), movieExportTimeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, b.args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var movie Movie
+		err := rows.Scan(
+			&movie.ID,
+			&movie.PublicID,
+			&movie.CreatedAt,
+			&movie.Title,
+			&movie.Year,
+			&movie.Runtime,
+			pq.Array(&movie.Genres),
+			&movie.Version,
+			&movie.AverageRating,
+			&movie.ReviewCount,
+			&movie.CustomFields,
+			&movie.PosterURL,
+			&movie.PosterPreview,
+		)
+		if err != nil {
+			return err
+		}
+		err = fn(&movie)
+		if err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
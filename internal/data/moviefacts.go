@@ -0,0 +1,201 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/shyngys9219/greenlight/internal/validator"
+)
+
+// The fields of movie facts.
+const (
+	FactRating     = "rating"     // average rating, out of 10
+	FactPopularity = "popularity" // number of votes behind the rating
+	FactBoxOffice  = "box_office" // US box office takings, in dollars
+)
+
+// FactFields lists the fields of movie facts.
+var FactFields = []string{FactRating, FactPopularity, FactBoxOffice}
+
+// FactSourceManual is the source of facts set by curators. They win over the
+// provider's: the enrichment job never overwrites them.
+const FactSourceManual = "manual"
+
+// A MovieFact is one fact about a movie, with where it came from: the name of the
+// metadata provider, or FactSourceManual.
+type MovieFact struct {
+	Field     string    `json:"field"`
+	Value     float64   `json:"value"`
+	Source    string    `json:"source"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func ValidateMovieFact(v *validator.Validator, fact *MovieFact) {
+	v.Check(validator.PermittedValue(fact.Field, FactFields...), "field", "must be rating, popularity or box_office")
+	v.Check(fact.Value >= 0, "value", "must not be negative")
+	if fact.Field == FactRating {
+		v.Check(fact.Value <= 10, "value", "must not be more than 10")
+	}
+}
+
+// MovieFactModel wraps the connection pool for the movie_facts and movie_enrichment
+// tables.
+type MovieFactModel struct {
+	queryScope
+	DB *sql.DB
+}
+
+// GetForMovie returns the facts about a movie, ordered by field.
+func (m MovieFactModel) GetForMovie(movieID int64) ([]*MovieFact, error) {
+	query := `
+		SELECT field, value, source, updated_at
+		FROM movie_facts
+		WHERE movie_id = $1
+		ORDER BY field`
+
+	ctx, cancel := context.WithTimeout(m.context(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, movieID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	facts := []*MovieFact{}
+	for rows.Next() {
+		var fact MovieFact
+		err := rows.Scan(&fact.Field, &fact.Value, &fact.Source, &fact.UpdatedAt)
+		if err != nil {
+			return nil, err
+		}
+		facts = append(facts, &fact)
+	}
+	return facts, rows.Err()
+}
+
+// SetManual sets a fact about a movie by hand, whichever source it had before. If the
+// movie doesn't exist, ErrRecordNotFound is returned.
+func (m MovieFactModel) SetManual(movieID int64, fact *MovieFact) error {
+	query := `
+		INSERT INTO movie_facts (movie_id, field, value, source)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (movie_id, field) DO UPDATE
+		SET value = EXCLUDED.value, source = EXCLUDED.source, updated_at = NOW()
+		RETURNING updated_at`
+
+	ctx, cancel := context.WithTimeout(m.context(), 3*time.Second)
+	defer cancel()
+
+	fact.Source = FactSourceManual
+	err := m.DB.QueryRowContext(ctx, query, movieID, fact.Field, fact.Value, fact.Source).Scan(&fact.UpdatedAt)
+	if err != nil {
+		var pqErr *pq.Error
+		switch {
+		case errors.As(err, &pqErr) && pqErr.Code.Name() == "foreign_key_violation":
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+// Delete removes a fact about a movie, so that the next enrichment fills it in again.
+func (m MovieFactModel) Delete(movieID int64, field string) error {
+	ctx, cancel := context.WithTimeout(m.context(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, `DELETE FROM movie_facts WHERE movie_id = $1 AND field = $2`, movieID, field)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// DueForEnrichment returns up to limit movies, with only their ID, title and year set,
+// which haven't been looked up at the metadata provider since the given time, those
+// never looked up first.
+func (m MovieFactModel) DueForEnrichment(since time.Time, limit int) ([]*Movie, error) {
+	query := `
+		SELECT movies.id, movies.title, movies.year
+		FROM movies
+		LEFT JOIN movie_enrichment ON movie_enrichment.movie_id = movies.id
+		WHERE movie_enrichment.checked_at IS NULL OR movie_enrichment.checked_at < $1
+		ORDER BY movie_enrichment.checked_at NULLS FIRST, movies.id
+		LIMIT $2`
+
+	ctx, cancel := context.WithTimeout(m.context(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, since, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var movies []*Movie
+	for rows.Next() {
+		var movie Movie
+		err := rows.Scan(&movie.ID, &movie.Title, &movie.Year)
+		if err != nil {
+			return nil, err
+		}
+		movies = append(movies, &movie)
+	}
+	return movies, rows.Err()
+}
+
+// SaveEnrichment records a lookup of a movie at the metadata provider, in a single
+// transaction: the facts it returned, under its name, except for those set by hand,
+// and lookupErr, which is empty if the lookup succeeded. It returns the number of
+// facts which were stored.
+func (m MovieFactModel) SaveEnrichment(movieID int64, source string, values map[string]float64, lookupErr string) (int, error) {
+	factQuery := `
+		INSERT INTO movie_facts (movie_id, field, value, source)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (movie_id, field) DO UPDATE
+		SET value = EXCLUDED.value, source = EXCLUDED.source, updated_at = NOW()
+		WHERE movie_facts.source <> 'manual'`
+	checkQuery := `
+		INSERT INTO movie_enrichment (movie_id, error)
+		VALUES ($1, $2)
+		ON CONFLICT (movie_id) DO UPDATE SET checked_at = NOW(), error = EXCLUDED.error`
+
+	ctx, cancel := context.WithTimeout(m.context(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	stored := 0
+	for field, value := range values {
+		result, err := tx.ExecContext(ctx, factQuery, movieID, field, value, source)
+		if err != nil {
+			return 0, err
+		}
+		n, err := result.RowsAffected()
+		if err != nil {
+			return 0, err
+		}
+		stored += int(n)
+	}
+	_, err = tx.ExecContext(ctx, checkQuery, movieID, lookupErr)
+	if err != nil {
+		return 0, err
+	}
+	return stored, tx.Commit()
+}
@@ -0,0 +1,151 @@
+package data
+
+import (
+	"context"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const (
+	// MaxMovieImport is the most rows a single movie import can hold.
+	MaxMovieImport = 100_000
+	// MovieImportBatchSize is the number of movies inserted by each transaction of an
+	// import.
+	MovieImportBatchSize = 1000
+)
+
+// A MovieImportRowError is why a row of a movie import wasn't imported, keyed by field
+// like a failed validation. Row is where the row is in the file, counting from 1: its
+// line in an NDJSON file, or its record after the header in a CSV file.
+type MovieImportRowError struct {
+	Row    int               `json:"row"`
+	Errors map[string]string `json:"errors"`
+}
+
+// A MovieImportReport summarises a movie import. Skipped counts the rows of movies which
+// were already in the catalog, or earlier in the file; Failed the rows in Errors.
+type MovieImportReport struct {
+	Rows    int                    `json:"rows"`
+	Created int                    `json:"created"`
+	Skipped int                    `json:"skipped"`
+	Failed  int                    `json:"failed"`
+	Batches int                    `json:"batches"`
+	Errors  []*MovieImportRowError `json:"errors"`
+}
+
+// InsertBatch inserts the movies, along with their genres, in a single transaction. Each
+// table gets one multi-row INSERT, rather than one per movie. With skipExisting, movies
+// with the title (ignoring case) and year of one already in the catalog aren't
+// inserted, and are left with an ID of 0. It returns the number of movies inserted.
+func (m MovieModel) InsertBatch(movies []*Movie, skipExisting bool) (int, error) {
+	if len(movies) == 0 {
+		return 0, nil
+	}
+
+	byPublicID := make(map[string]*Movie, len(movies))
+	publicIDs := make([]string, len(movies))
+	titles := make([]string, len(movies))
+	years := make([]int64, len(movies))
+	runtimes := make([]int64, len(movies))
+	customFields := make([]string, len(movies))
+	for i, movie := range movies {
+		movie.PublicID = m.publicIDs()
+		byPublicID[movie.PublicID] = movie
+		publicIDs[i] = movie.PublicID
+		titles[i] = movie.Title
+		years[i] = int64(movie.Year)
+		runtimes[i] = int64(movie.Runtime)
+		value, err := movie.CustomFields.Value()
+		if err != nil {
+			return 0, err
+		}
+		customFields[i] = value.(string)
+	}
+
+	query := `
+		INSERT INTO movies (public_id, title, year, runtime, custom_fields)
+		SELECT input.public_id, input.title, input.year, input.runtime, input.custom_fields
+		FROM unnest($1::text[], $2::text[], $3::integer[], $4::integer[], $5::jsonb[])
+			AS input(public_id, title, year, runtime, custom_fields)
+		WHERE NOT $6 OR NOT EXISTS (
+			SELECT 1 FROM movies
+			WHERE lower(movies.title) = lower(input.title) AND movies.year = input.year
+		)
+		RETURNING public_id, id, created_at, version`
+
+	ctx, cancel := context.WithTimeout(m.context(), 30*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, query, pq.Array(publicIDs), pq.Array(titles), pq.Array(years),
+		pq.Array(runtimes), pq.Array(customFields), skipExisting)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	// The movies inserted are matched up by their public IDs, since RETURNING doesn't
+	// promise to keep the order of the input.
+	var inserted []*Movie
+	for rows.Next() {
+		var publicID string
+		var movie Movie
+		err := rows.Scan(&publicID, &movie.ID, &movie.CreatedAt, &movie.Version)
+		if err != nil {
+			return 0, err
+		}
+		if target := byPublicID[publicID]; target != nil {
+			target.ID, target.CreatedAt, target.Version = movie.ID, movie.CreatedAt, movie.Version
+			inserted = append(inserted, target)
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return 0, err
+	}
+	for _, movie := range movies {
+		if movie.ID == 0 {
+			movie.PublicID = ""
+		}
+	}
+
+	var movieIDs []int64
+	var genres []string
+	var positions []int64
+	for _, movie := range inserted {
+		for i, genre := range movie.Genres {
+			movieIDs = append(movieIDs, movie.ID)
+			genres = append(genres, genre)
+			positions = append(positions, int64(i+1))
+		}
+	}
+	if len(genres) > 0 {
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO genres (name)
+			SELECT DISTINCT unnest($1::text[])
+			ON CONFLICT (name) DO NOTHING`, pq.Array(genres))
+		if err != nil {
+			return 0, err
+		}
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO movies_genres (movie_id, genre_id, position)
+			SELECT g.movie_id, genres.id, g.position
+			FROM unnest($1::bigint[], $2::text[], $3::integer[]) AS g(movie_id, name, position)
+			INNER JOIN genres ON genres.name = g.name
+			ON CONFLICT (movie_id, genre_id) DO NOTHING`, pq.Array(movieIDs), pq.Array(genres), pq.Array(positions))
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, err
+	}
+	return len(inserted), nil
+}
@@ -1,17 +1,24 @@
 package data
 
 import (
+	"context"
 	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"time"
 
 	"github.com/lib/pq"
+	"github.com/shyngys9219/greenlight/internal/publicid"
+	"github.com/shyngys9219/greenlight/internal/validator"
 )
 
 // Movie By default, the keys in the JSON object are equal to the field names in the struct ( ID,
 // CreatedAt, Title and so on).
 type Movie struct {
 	ID        int64     `json:"id"`                       // Unique integer ID for the movie
+	PublicID  string    `json:"public_id"`                // Non-enumerable ID used in URLs (UUID or ULID)
 	CreatedAt time.Time `json:"-"`                        // Timestamp for when the movie is added to our database, "-" directive, hidden in response
 	Title     string    `json:"title"`                    // Movie title
 	Year      int32     `json:"year,omitempty"`           // Movie release year, "omitempty" - hide from response if empty
@@ -19,23 +26,107 @@ type Movie struct {
 	Genres    []string  `json:"genres,omitempty"`         // Slice of genres for the movie (romance, comedy, etc.)
 	Version   int32     `json:"version"`                  // The version number starts at 1 and will be incremented each
 	// time the movie information is updated
+	// AverageRating and ReviewCount are denormalized from the movie's reviews, and kept
+	// in sync by ReviewModel. AverageRating is nil until the movie has been reviewed.
+	AverageRating *float64 `json:"average_rating,omitempty"`
+	ReviewCount   int      `json:"review_count"`
+	// Overview is only set on movies localized from a translation which has one; the
+	// Translations themselves are only set once they have been loaded.
+	Overview     string              `json:"overview,omitempty"`
+	Translations []*MovieTranslation `json:"-"`
+	// CustomFields holds the values of the custom fields defined by the deployment's
+	// admins, see CustomFieldDefinition.
+	CustomFields CustomFields `json:"custom_fields,omitempty"`
+	// PosterURL is the public URL of the movie's poster, or empty if it has none.
+	PosterURL string `json:"poster_url,omitempty"`
+	// PosterPreview is what clients can show while the poster loads. It's nil if the
+	// movie has no poster, or it couldn't be decoded.
+	PosterPreview *PosterPreview `json:"poster_preview,omitempty"`
+	// Truncated is set on movies in a listing whose overview was shortened.
+	Truncated *Truncation `json:"truncated,omitempty"`
+	// Highlight is set on movies in a search which asked for highlights: the title as
+	// HTML, with the words searched for in <mark> elements.
+	Highlight string `json:"highlight,omitempty"`
+}
+
+// ValidateMovie checks the movie fields against the same rules as the check
+// constraints on the movies table, so that clients get a helpful error message instead
+// of a database error.
+func ValidateMovie(v *validator.Validator, movie *Movie) {
+	v.Check(movie.Title != "", "title", "must be provided")
+	v.Check(len(movie.Title) <= 500, "title", "must not be more than 500 bytes long")
+	v.Check(movie.Year != 0, "year", "must be provided")
+	v.Check(movie.Year >= 1888, "year", "must be greater than 1888")
+	v.Check(movie.Year <= int32(time.Now().Year()), "year", "must not be in the future")
+	v.Check(movie.Runtime != 0, "runtime", "must be provided")
+	v.Check(movie.Runtime > 0, "runtime", "must be a positive integer")
+	v.Check(movie.Genres != nil, "genres", "must be provided")
+	v.Check(len(movie.Genres) >= 1, "genres", "must contain at least 1 genre")
+	v.Check(len(movie.Genres) <= 5, "genres", "must not contain more than 5 genres")
+	v.Check(validator.Unique(movie.Genres), "genres", "must not contain duplicate values")
+}
+
+// A PosterPreview holds the placeholders of a movie's poster, worked out when it's
+// uploaded: its dominant colour and palette as CSS hex colours, most common first, and
+// its BlurHash (see blurha.sh), which decodes to a blurred version of the poster.
+type PosterPreview struct {
+	DominantColor string   `json:"dominant_color"`
+	Palette       []string `json:"palette"`
+	Blurhash      string   `json:"blurhash"`
+}
+
+// Scan implements the sql.Scanner interface, for reading the jsonb column.
+func (p *PosterPreview) Scan(src any) error {
+	b, ok := src.([]byte)
+	if !ok {
+		return fmt.Errorf("poster preview: unexpected type %T", src)
+	}
+	return json.Unmarshal(b, p)
+}
+
+// Value implements the driver.Valuer interface, for writing the jsonb column.
+func (p PosterPreview) Value() (driver.Value, error) {
+	b, err := json.Marshal(p)
+	if err != nil {
+		return nil, err
+	}
+	return string(b), nil
 }
 
 // MovieModel is a struct type which wraps a sql.DB connection pool.
 type MovieModel struct {
-	DB *sql.DB
+	queryScope
+	DB        *sql.DB
+	stmts     *stmtCache         // prepared statements for queries built from filters
+	publicIDs publicid.Generator // generates the public IDs of new movies
 }
 
-// Insert method for inserting a new record in the movies table.
+// Insert method for inserting a new record in the movies table, along with its genres.
 func (m MovieModel) Insert(movie *Movie) error {
 	query := `
-		INSERT INTO movies(title, year, runtime, genres)
-		VALUES ($1, $2, $3, $4)
+		INSERT INTO movies(public_id, title, year, runtime, custom_fields)
+		VALUES ($1, $2, $3, $4, $5)
 		RETURNING id, created_at, version`
 
-	args := []any{movie.Title, movie.Year, movie.Runtime, pq.Array(movie.Genres)}
+	movie.PublicID = m.publicIDs()
+	args := []any{movie.PublicID, movie.Title, movie.Year, movie.Runtime, movie.CustomFields}
 
-	return m.DB.QueryRow(query, args...).Scan(&movie.ID, &movie.CreatedAt, &movie.Version)
+	ctx := m.context()
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&movie.ID, &movie.CreatedAt, &movie.Version)
+	if err != nil {
+		return err
+	}
+	err = setMovieGenres(ctx, tx, movie.ID, movie.Genres)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
 func (m MovieModel) Get(id int64) (*Movie, error) {
@@ -48,7 +139,7 @@ func (m MovieModel) Get(id int64) (*Movie, error) {
 	}
 	// Define the SQL query for retrieving the movie data.
 	query := `
-		SELECT id, created_at, title, year, runtime, genres, version
+		SELECT id, public_id, created_at, title, year, runtime, ` + movieGenres + `, version, average_rating, review_count, custom_fields, poster_url, poster_preview
 		FROM movies
 		WHERE id = $1`
 	// Declare a Movie struct to hold the data returned by the query.
@@ -57,14 +148,20 @@ func (m MovieModel) Get(id int64) (*Movie, error) {
 	// as a placeholder parameter, and scan the response data into the fields of the
 	// Movie struct. Importantly, notice that we need to convert the scan target for the
 	// genres column using the pq.Array() adapter function again.
-	err := m.DB.QueryRow(query, id).Scan(
+	err := m.DB.QueryRowContext(m.context(), query, id).Scan(
 		&movie.ID,
+		&movie.PublicID,
 		&movie.CreatedAt,
 		&movie.Title,
 		&movie.Year,
 		&movie.Runtime,
 		pq.Array(&movie.Genres),
 		&movie.Version,
+		&movie.AverageRating,
+		&movie.ReviewCount,
+		&movie.CustomFields,
+		&movie.PosterURL,
+		&movie.PosterPreview,
 	)
 	// Handle any errors. If there was no matching movie found, Scan() will return
 	// a sql.ErrNoRows error. We check for this and return our custom ErrRecordNotFound
@@ -81,46 +178,507 @@ func (m MovieModel) Get(id int64) (*Movie, error) {
 	return &movie, nil
 }
 
-// Update method for updating a specific record in the movies table.
+// FindDuplicate returns the movie with the same title (ignoring case) and year as the
+// given one, other than the movie with excludeID. Only its IDs are set. If there's no
+// such movie an ErrRecordNotFound error is returned.
+func (m MovieModel) FindDuplicate(title string, year int32, excludeID int64) (*Movie, error) {
+	query := `
+		SELECT id, public_id
+		FROM movies
+		WHERE lower(title) = lower($1) AND year = $2 AND id <> $3
+		ORDER BY id
+		LIMIT 1`
+
+	ctx, cancel := context.WithTimeout(m.context(), 3*time.Second)
+	defer cancel()
+
+	var movie Movie
+	err := m.DB.QueryRowContext(ctx, query, title, year, excludeID).Scan(&movie.ID, &movie.PublicID)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &movie, nil
+}
+
+// GetIDByPublicID returns the internal ID of the movie with the given public ID. The
+// public IDs of merged duplicates resolve to the duplicate's old ID, so that callers
+// can find the redirect with GetRedirect. If there's no such movie an
+// ErrRecordNotFound error is returned.
+func (m MovieModel) GetIDByPublicID(publicID string) (int64, error) {
+	query := `
+		SELECT id FROM movies WHERE public_id = $1
+		UNION ALL
+		SELECT old_id FROM movie_redirects WHERE old_public_id = $1
+		LIMIT 1`
+
+	ctx, cancel := context.WithTimeout(m.context(), 3*time.Second)
+	defer cancel()
+
+	var id int64
+	err := m.DB.QueryRowContext(ctx, query, publicID).Scan(&id)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return 0, ErrRecordNotFound
+		default:
+			return 0, err
+		}
+	}
+	return id, nil
+}
+
+// GetByIDs returns the movies with the given IDs, in the same order as the IDs. If any
+// of the movies doesn't exist an ErrRecordNotFound error is returned.
+func (m MovieModel) GetByIDs(ids []int64) ([]*Movie, error) {
+	query := `
+		SELECT id, public_id, created_at, title, year, runtime, ` + movieGenres + `, version, average_rating, review_count, custom_fields, poster_url, poster_preview
+		FROM movies
+		WHERE id = ANY($1)`
+
+	ctx, cancel := context.WithTimeout(m.context(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	byID := make(map[int64]*Movie, len(ids))
+	for rows.Next() {
+		var movie Movie
+		err := rows.Scan(
+			&movie.ID,
+			&movie.PublicID,
+			&movie.CreatedAt,
+			&movie.Title,
+			&movie.Year,
+			&movie.Runtime,
+			pq.Array(&movie.Genres),
+			&movie.Version,
+			&movie.AverageRating,
+			&movie.ReviewCount,
+			&movie.CustomFields,
+			&movie.PosterURL,
+			&movie.PosterPreview,
+		)
+		if err != nil {
+			return nil, err
+		}
+		byID[movie.ID] = &movie
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	movies := make([]*Movie, 0, len(ids))
+	for _, id := range ids {
+		movie, ok := byID[id]
+		if !ok {
+			return nil, ErrRecordNotFound
+		}
+		movies = append(movies, movie)
+	}
+	return movies, nil
+}
+
+// movieFilterColumns maps the fields movies can be filtered on to their columns.
+var movieFilterColumns = map[string]string{
+	"year":    "movies.year",
+	"runtime": "movies.runtime",
+	"rating":  "movies.average_rating",
+}
+
+// MovieFilterFields are the fields which can be used in movie filters.
+var MovieFilterFields = []string{"year", "runtime", "rating"}
+
+// GetRandom returns a single random movie matching the given genre (if not empty) and
+// filters. Rather than ordering the whole table by random(), it picks a random point in
+// the range of matching IDs and seeks to the first matching movie at or after it, so it
+// stays cheap on large tables. The filters must have been checked with
+// ValidateFilters.
+func (m MovieModel) GetRandom(genre string, filters []Filter) (*Movie, error) {
+	var b filterBuilder
+	if genre != "" {
+		b.add("movies.id IN (SELECT movies_genres.movie_id FROM movies_genres INNER JOIN genres ON genres.id = movies_genres.genre_id WHERE genres.name = ?)", genre)
+	}
+	for _, f := range filters {
+		b.compare(movieFilterColumns[f.Field], f.Op, f.Value)
+	}
+
+	// The same conditions (and placeholders) are used twice: once to find the range of
+	// matching IDs, and once to find the movie.
+	query := fmt.Sprintf(`
+		WITH bounds AS (
+			SELECT min(id) AS lo, max(id) AS hi
+			FROM movies
+			WHERE %[1]s
+		), pick AS (
+			SELECT lo + floor(random() * (hi - lo + 1))::bigint AS id
+			FROM bounds
+		)
+		SELECT movies.id, movies.public_id, movies.created_at, movies.title, movies.year, movies.runtime, `+movieGenres+`, movies.version, movies.average_rating, movies.review_count, movies.custom_fields, movies.poster_url, movies.poster_preview
+		FROM movies, pick
+		WHERE movies.id >= pick.id
+		AND %[1]s
+		ORDER BY movies.id
+		LIMIT 1`, b.where())
+
+	ctx, cancel := context.WithTimeout(m.context(), 3*time.Second)
+	defer cancel()
+
+	stmt, release, err := m.stmts.prepare(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	var movie Movie
+	err = stmt.QueryRowContext(ctx, b.args...).Scan(
+		&movie.ID,
+		&movie.PublicID,
+		&movie.CreatedAt,
+		&movie.Title,
+		&movie.Year,
+		&movie.Runtime,
+		pq.Array(&movie.Genres),
+		&movie.Version,
+		&movie.AverageRating,
+		&movie.ReviewCount,
+		&movie.CustomFields,
+		&movie.PosterURL,
+		&movie.PosterPreview,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &movie, nil
+}
+
+// MovieSortSafelist are the sort values the movie listing accepts.
+var MovieSortSafelist = []string{"id", "title", "year", "runtime", "-id", "-title", "-year", "-runtime"}
+
+// GetAll returns a page of the movies whose title matches the given words (if not
+// empty), which have all of the given genres, by name and by ID, and which match the filters and the
+// custom field filters, together
+// with the pagination metadata. Titles are matched with full-text search, so every
+// word has to appear in the title. A fuzzy search matches titles by trigram word
+// similarity instead, which finds partial and misspelled words too, but needs the
+// pg_trgm extension. The total number of matching movies is counted by a
+// window function in the same query, so no second query is needed; keyset pages aren't
+// counted (see Filters). The search asks for highlights and facets, which are nil
+// unless it does. The filters must have been checked with ValidateFilters, and the
+// paging and sorting parameters with ValidateListFilters.
+func (m MovieModel) GetAll(title string, fuzzy bool, genres []string, genreIDs []int64, filters []Filter, custom []CustomFieldFilter, page Filters, search MovieSearch) ([]*Movie, *MovieFacets, Metadata, error) {
+	b := movieConditions(title, fuzzy, genres, genreIDs, filters, custom)
+
+	// The facets are of every movie matching the search, so they're computed before a
+	// keyset listing adds the condition of its cursor.
+	with, facetsColumn, facetsJoin := movieFacetsSQL(search, b.where())
+	highlight := "''"
+	if search.Highlight && title != "" {
+		highlight = fmt.Sprintf("ts_headline('simple', movies.title, plainto_tsquery('simple', %s), %s)", b.arg(title), b.arg(movieHeadlineOptions))
+	}
+	listing := movieListing{
+		with:    with,
+		columns: `movies.id, movies.public_id, movies.created_at, movies.title, movies.year, movies.runtime, ` + movieGenres + `, movies.version, movies.average_rating, movies.review_count, movies.custom_fields, movies.poster_url, movies.poster_preview, ` + highlight + `, ` + facetsColumn,
+		join:    facetsJoin,
+		search:  search,
+	}
+
+	if page.Keyset {
+		return m.getAllKeyset(b, page, listing)
+	}
+
+	// Movies with the same value in the sort column are ordered by ID, so the order
+	// is stable from one page to the next.
+	query := fmt.Sprintf(`%s
+		SELECT count(*) OVER(), %s
+		FROM movies %s
+		WHERE %s
+		ORDER BY movies.%s %s, movies.id ASC
+		LIMIT $%d OFFSET $%d`, listing.with, listing.columns, listing.join, b.where(), page.sortColumn(), page.sortDirection(), len(b.args)+1, len(b.args)+2)
+	args := append(b.args, page.limit(), page.offset())
+
+	ctx, cancel := context.WithTimeout(m.context(), 3*time.Second)
+	defer cancel()
+
+	stmt, release, err := m.stmts.prepare(ctx, query)
+	if err != nil {
+		return nil, nil, Metadata{}, err
+	}
+	defer release()
+	rows, err := stmt.QueryContext(ctx, args...)
+	if err != nil {
+		return nil, nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	movies := []*Movie{}
+	var doc []byte
+	for rows.Next() {
+		var movie Movie
+		var headline string
+		err := rows.Scan(
+			&totalRecords,
+			&movie.ID,
+			&movie.PublicID,
+			&movie.CreatedAt,
+			&movie.Title,
+			&movie.Year,
+			&movie.Runtime,
+			pq.Array(&movie.Genres),
+			&movie.Version,
+			&movie.AverageRating,
+			&movie.ReviewCount,
+			&movie.CustomFields,
+			&movie.PosterURL,
+			&movie.PosterPreview,
+			&headline,
+			&doc,
+		)
+		if err != nil {
+			return nil, nil, Metadata{}, err
+		}
+		movie.Highlight = highlightMarkup(headline)
+		movies = append(movies, &movie)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, nil, Metadata{}, err
+	}
+	facets, err := scanMovieFacets(listing.search, doc)
+	if err != nil {
+		return nil, nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, page.Page, page.PageSize)
+	return movies, facets, metadata, nil
+}
+
+// movieConditions builds the WHERE clause of a search of the movies, see GetAll().
+func movieConditions(title string, fuzzy bool, genres []string, genreIDs []int64, filters []Filter, custom []CustomFieldFilter) filterBuilder {
+	var b filterBuilder
+	switch {
+	case title != "" && fuzzy:
+		b.add("? <% movies.title", title)
+	case title != "":
+		b.add("to_tsvector('simple', movies.title) @@ plainto_tsquery('simple', ?)", title)
+	}
+	// A movie has all of the genres if it has as many of them as there are.
+	if genres = dedupe(genres); len(genres) > 0 {
+		b.add(`movies.id IN (
+			SELECT movies_genres.movie_id FROM movies_genres INNER JOIN genres ON genres.id = movies_genres.genre_id
+			WHERE genres.name = ANY(?) GROUP BY movies_genres.movie_id HAVING count(*) = ?)`, pq.Array(genres), len(genres))
+	}
+	if genreIDs = dedupe(genreIDs); len(genreIDs) > 0 {
+		b.add(`movies.id IN (
+			SELECT movie_id FROM movies_genres
+			WHERE genre_id = ANY(?) GROUP BY movie_id HAVING count(*) = ?)`, pq.Array(genreIDs), len(genreIDs))
+	}
+	for _, f := range filters {
+		b.compare(movieFilterColumns[f.Field], f.Op, f.Value)
+	}
+	for _, f := range custom {
+		b.customField(f)
+	}
+	return b
+}
+
+// getAllKeyset returns a keyset page of the movies matching the conditions. Rather than
+// skipping the movies of the earlier pages, it seeks straight past the cursor with a
+// row comparison on the sort column and the ID, which the (column, id) indexes serve.
+// Ties are broken by ID in the same direction as the sort, so the comparison holds.
+// One movie more than the page size is fetched to find out if there's a next page.
+func (m MovieModel) getAllKeyset(b filterBuilder, page Filters, listing movieListing) ([]*Movie, *MovieFacets, Metadata, error) {
+	column, direction := page.sortColumn(), page.sortDirection()
+	if page.Cursor != "" {
+		c, err := decodeCursor(page.Cursor)
+		if err != nil {
+			return nil, nil, Metadata{}, err
+		}
+		var value any
+		if column == "title" {
+			var s string
+			err = json.Unmarshal(c.Value, &s)
+			value = s
+		} else {
+			var i int64
+			err = json.Unmarshal(c.Value, &i)
+			value = i
+		}
+		if err != nil {
+			return nil, nil, Metadata{}, err
+		}
+		op := ">"
+		if direction == "DESC" {
+			op = "<"
+		}
+		b.add(fmt.Sprintf("(movies.%s, movies.id) %s (?, ?)", column, op), value, c.ID)
+	}
+
+	query := fmt.Sprintf(`%s
+		SELECT %s
+		FROM movies %s
+		WHERE %s
+		ORDER BY movies.%s %s, movies.id %s
+		LIMIT $%d`, listing.with, listing.columns, listing.join, b.where(), column, direction, direction, len(b.args)+1)
+	args := append(b.args, page.limit()+1)
+
+	ctx, cancel := context.WithTimeout(m.context(), 3*time.Second)
+	defer cancel()
+
+	stmt, release, err := m.stmts.prepare(ctx, query)
+	if err != nil {
+		return nil, nil, Metadata{}, err
+	}
+	defer release()
+	rows, err := stmt.QueryContext(ctx, args...)
+	if err != nil {
+		return nil, nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	movies := []*Movie{}
+	var doc []byte
+	for rows.Next() {
+		var movie Movie
+		var headline string
+		err := rows.Scan(
+			&movie.ID,
+			&movie.PublicID,
+			&movie.CreatedAt,
+			&movie.Title,
+			&movie.Year,
+			&movie.Runtime,
+			pq.Array(&movie.Genres),
+			&movie.Version,
+			&movie.AverageRating,
+			&movie.ReviewCount,
+			&movie.CustomFields,
+			&movie.PosterURL,
+			&movie.PosterPreview,
+			&headline,
+			&doc,
+		)
+		if err != nil {
+			return nil, nil, Metadata{}, err
+		}
+		movie.Highlight = highlightMarkup(headline)
+		movies = append(movies, &movie)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, nil, Metadata{}, err
+	}
+	facets, err := scanMovieFacets(listing.search, doc)
+	if err != nil {
+		return nil, nil, Metadata{}, err
+	}
+
+	metadata := Metadata{PageSize: page.PageSize}
+	if len(movies) > page.PageSize {
+		movies = movies[:page.PageSize]
+		last := movies[len(movies)-1]
+		values := map[string]any{"id": last.ID, "title": last.Title, "year": last.Year, "runtime": last.Runtime}
+		metadata.NextCursor, err = encodeCursor(page.Sort, values[column], last.ID)
+		if err != nil {
+			return nil, nil, Metadata{}, err
+		}
+	}
+	return movies, facets, metadata, nil
+}
+
+// SetPoster records the storage key, URL and preview of a movie's new poster, and bumps
+// its version, returning the key of the poster it replaces, or "" if it had none. The
+// preview is nil for a poster which couldn't be decoded. If the movie doesn't exist
+// ErrRecordNotFound is returned.
+func (m MovieModel) SetPoster(movie *Movie, key, url string, preview *PosterPreview) (string, error) {
+	query := `
+		UPDATE movies AS updated
+		SET poster_key = $1, poster_url = $2, poster_preview = $3, version = updated.version + 1, updated_at = NOW()
+		FROM (SELECT id, poster_key FROM movies WHERE id = $4 FOR UPDATE) AS previous
+		WHERE updated.id = previous.id
+		RETURNING updated.version, previous.poster_key`
+
+	ctx, cancel := context.WithTimeout(m.context(), 3*time.Second)
+	defer cancel()
+
+	var previousKey string
+	err := m.DB.QueryRowContext(ctx, query, key, url, preview, movie.ID).Scan(&movie.Version, &previousKey)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return "", ErrRecordNotFound
+		default:
+			return "", err
+		}
+	}
+	movie.PosterURL = url
+	movie.PosterPreview = preview
+	return previousKey, nil
+}
+
+// PosterInUse reports whether any movie has the poster with the given storage key.
+// Posters are keyed by their content, so movies with the same image share one.
+func (m MovieModel) PosterInUse(key string) (bool, error) {
+	query := `SELECT EXISTS(SELECT 1 FROM movies WHERE poster_key = $1 AND poster_key <> '')`
+
+	ctx, cancel := context.WithTimeout(m.context(), 3*time.Second)
+	defer cancel()
+
+	var used bool
+	err := m.DB.QueryRowContext(ctx, query, key).Scan(&used)
+	return used, err
+}
+
+// Update method for updating a specific record in the movies table. The update only
+// goes through if the movie is still at the version it was read at, otherwise
+// ErrEditConflict is returned: someone else changed (or deleted) it in the meantime.
 func (m MovieModel) Update(movie *Movie) error {
 	query := `
 		UPDATE movies
-		SET title = $1, year = $2, runtime = $3, genres = $4, version = version + 1
-		WHERE id = $5
+		SET title = $1, year = $2, runtime = $3, custom_fields = $4, version = version + 1, updated_at = NOW()
+		WHERE id = $5 AND version = $6
 		RETURNING version`
 
 	args := []any{
 		movie.Title,
 		movie.Year,
 		movie.Runtime,
-		pq.Array(movie.Genres),
+		movie.CustomFields,
 		movie.ID,
+		movie.Version,
 	}
 
-	return m.DB.QueryRow(query, args...).Scan(&movie.Version)
-}
-
-// Delete method for deleting a specific record from the movies table.
-func (m MovieModel) Delete(id int64) error {
-	query := `
-		DELETE FROM movies
-		WHERE id= $1
-		`
-	// Error handling
-	result, err := m.DB.Exec(query, id)
+	ctx := m.context()
+	tx, err := m.DB.BeginTx(ctx, nil)
 	if err != nil {
-		return nil
+		return err
 	}
+	defer tx.Rollback()
 
-	// Checking how many rows were affected
-	rowsAffected, err := result.RowsAffected()
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&movie.Version)
 	if err != nil {
-		return err
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
 	}
-
-	// Check if the row was in the database before the query
-	if rowsAffected == 0 {
-		return ErrRecordNotFound
+	err = setMovieGenres(ctx, tx, movie.ID, movie.Genres)
+	if err != nil {
+		return err
 	}
-	return nil
+	return tx.Commit()
 }
@@ -0,0 +1,132 @@
+package data
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"strings"
+)
+
+// A MovieSearch asks a listing of movies for more than the movies themselves. With
+// Highlight, movies found by the words of their title have the title with those words
+// marked up in Highlight. With Facets, the listing also counts the movies matching the
+// search by genre, decade and rating, in the same query as the page of them.
+type MovieSearch struct {
+	Highlight bool
+	Facets    bool
+}
+
+// MovieFacets are the counts of the movies matching a search, across all the pages of
+// it, for a client to offer as further filters.
+type MovieFacets struct {
+	// Genres are counted by name, most common first, as the genres filter takes them.
+	Genres []FacetCount `json:"genres"`
+	// Decades are named by their first year, such as "1990", newest first; the year
+	// filters narrow a search down to one with year[gte]=1990&year[lt]=2000.
+	Decades []FacetCount `json:"decades"`
+	// Ratings are bands of the average rating a whole point wide, such as "7-8", highest
+	// first, with the perfect 10s in "9-10", and "unrated" for movies without reviews;
+	// rating[gte]=7&rating[lt]=8 narrows a search down to one.
+	Ratings []FacetCount `json:"ratings"`
+}
+
+// A FacetCount is the number of movies with a value of a facet.
+type FacetCount struct {
+	Value string `json:"value"`
+	Count int    `json:"count"`
+}
+
+// The delimiters ts_headline() puts around the words it matched. They're control
+// characters, which titles don't contain, so that the title can be escaped before the
+// words are marked up.
+const (
+	highlightStart = "\x02"
+	highlightStop  = "\x03"
+)
+
+// movieHeadlineOptions are the options of ts_headline() for the highlight of a title:
+// every word matched is marked, and the whole title is kept.
+const movieHeadlineOptions = "StartSel=" + highlightStart + ", StopSel=" + highlightStop + ", HighlightAll=true"
+
+// highlightMarkup turns a headline from ts_headline() into HTML, with the title escaped
+// and the words matched in <mark> elements. A headline without any, such as that of a
+// title found by a fuzzy search, has no highlight.
+func highlightMarkup(headline string) string {
+	if !strings.Contains(headline, highlightStart) {
+		return ""
+	}
+	return strings.NewReplacer(highlightStart, "<mark>", highlightStop, "</mark>").Replace(html.EscapeString(headline))
+}
+
+// movieFacetsSQL returns the parts of a query on the movies table which compute the
+// facets of the movies matching the WHERE clause where: a WITH clause, a json column
+// of the facets and a join making it available to every row. Without facets, the
+// column is NULL and the other parts are empty. A page past the last one has no rows
+// to carry the facets, so they come back empty on it, just as its metadata does.
+func movieFacetsSQL(search MovieSearch, where string) (with, column, join string) {
+	if !search.Facets {
+		return "", "NULL::json", ""
+	}
+	with = fmt.Sprintf(`
+		WITH matched AS (
+			SELECT movies.id, movies.year, movies.average_rating
+			FROM movies
+			WHERE %s
+		), facets AS (
+			SELECT json_build_object(
+				'genres', (
+					SELECT coalesce(json_agg(json_build_object('value', f.name, 'count', f.count) ORDER BY f.count DESC, f.name), '[]')
+					FROM (
+						SELECT genres.name, count(*) AS count
+						FROM matched
+						INNER JOIN movies_genres ON movies_genres.movie_id = matched.id
+						INNER JOIN genres ON genres.id = movies_genres.genre_id
+						GROUP BY genres.name
+					) AS f
+				),
+				'decades', (
+					SELECT coalesce(json_agg(json_build_object('value', f.decade::text, 'count', f.count) ORDER BY f.decade DESC), '[]')
+					FROM (
+						SELECT matched.year / 10 * 10 AS decade, count(*) AS count
+						FROM matched
+						GROUP BY 1
+					) AS f
+				),
+				'ratings', (
+					SELECT coalesce(json_agg(json_build_object('value', coalesce(f.band || '-' || f.band + 1, 'unrated'), 'count', f.count) ORDER BY f.band DESC NULLS LAST), '[]')
+					FROM (
+						SELECT least(floor(matched.average_rating), 9)::integer AS band, count(*) AS count
+						FROM matched
+						GROUP BY 1
+					) AS f
+				)
+			) AS doc
+		)`, where)
+	return with, "facets.doc", "CROSS JOIN facets"
+}
+
+// A movieListing holds the parts of the query of a listing of movies which depend on
+// its search: those of movieFacetsSQL(), and the columns selected.
+type movieListing struct {
+	with    string
+	columns string
+	join    string
+	search  MovieSearch
+}
+
+// scanMovieFacets decodes the facets column of a listing. It's NULL without facets, and
+// there's none at all when there are no rows, in which case the facets are empty.
+func scanMovieFacets(search MovieSearch, doc []byte) (*MovieFacets, error) {
+	if !search.Facets {
+		return nil, nil
+	}
+	facets := MovieFacets{Genres: []FacetCount{}, Decades: []FacetCount{}, Ratings: []FacetCount{}}
+	if doc == nil {
+		return &facets, nil
+	}
+	err := json.Unmarshal(doc, &facets)
+	if err != nil {
+		return nil, err
+	}
+	return &facets, nil
+}
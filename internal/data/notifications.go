@@ -0,0 +1,211 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// NotificationsSortSafelist holds the sort values supported by the listing of a user's
+// notifications.
+var NotificationsSortSafelist = []string{"created_at", "-created_at"}
+
+// A Notification is a notification in a user's in-app inbox.
+type Notification struct {
+	ID        int64      `json:"id"`
+	UserID    int64      `json:"-"`
+	Type      string     `json:"type"`
+	Subject   string     `json:"subject"`
+	Body      string     `json:"body"`
+	CreatedAt time.Time  `json:"created_at"`
+	ReadAt    *time.Time `json:"read_at"`
+}
+
+// A NotificationPreference turns a channel on or off for a type of notification.
+type NotificationPreference struct {
+	Type    string
+	Channel string
+	Enabled bool
+}
+
+// NotificationModel wraps the connection pool for the notifications and
+// notification_preferences tables.
+type NotificationModel struct {
+	queryScope
+	DB *sql.DB
+}
+
+// Insert adds a notification to a user's inbox.
+func (m NotificationModel) Insert(n *Notification) error {
+	query := `
+		INSERT INTO notifications (user_id, type, subject, body)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, created_at`
+
+	ctx, cancel := context.WithTimeout(m.context(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, n.UserID, n.Type, n.Subject, n.Body).Scan(&n.ID, &n.CreatedAt)
+}
+
+// GetAllForUser returns a page of a user's notifications, only the unread ones if
+// unreadOnly is set, and the number of their unread notifications.
+func (m NotificationModel) GetAllForUser(userID int64, unreadOnly bool, filters Filters) ([]*Notification, int, Metadata, error) {
+	query := fmt.Sprintf(`
+		SELECT count(*) OVER(), id, type, subject, body, created_at, read_at
+		FROM notifications
+		WHERE user_id = $1 AND (read_at IS NULL OR NOT $2)
+		ORDER BY %s %s, id %[2]s
+		LIMIT $3 OFFSET $4`, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(m.context(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID, unreadOnly, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, 0, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	notifications := []*Notification{}
+	for rows.Next() {
+		n := Notification{UserID: userID}
+		err := rows.Scan(&totalRecords, &n.ID, &n.Type, &n.Subject, &n.Body, &n.CreatedAt, &n.ReadAt)
+		if err != nil {
+			return nil, 0, Metadata{}, err
+		}
+		notifications = append(notifications, &n)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, 0, Metadata{}, err
+	}
+
+	var unread int
+	err = m.DB.QueryRowContext(ctx, `SELECT count(*) FROM notifications WHERE user_id = $1 AND read_at IS NULL`, userID).Scan(&unread)
+	if err != nil {
+		return nil, 0, Metadata{}, err
+	}
+	return notifications, unread, calculateMetadata(totalRecords, filters.Page, filters.PageSize), nil
+}
+
+// MarkRead marks a user's notifications with the IDs as read, or all of them if ids is
+// empty, and returns how many were unread.
+func (m NotificationModel) MarkRead(userID int64, ids []int64) (int64, error) {
+	query := `
+		UPDATE notifications SET read_at = NOW()
+		WHERE user_id = $1 AND read_at IS NULL AND (id = ANY($2) OR cardinality($2::bigint[]) = 0)`
+
+	ctx, cancel := context.WithTimeout(m.context(), 3*time.Second)
+	defer cancel()
+
+	if ids == nil {
+		ids = []int64{}
+	}
+	result, err := m.DB.ExecContext(ctx, query, userID, pq.Array(ids))
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// DeleteBefore removes the notifications created before the given time.
+func (m NotificationModel) DeleteBefore(before time.Time) error {
+	ctx, cancel := context.WithTimeout(m.context(), 30*time.Second)
+	defer cancel()
+	_, err := m.DB.ExecContext(ctx, `DELETE FROM notifications WHERE created_at < $1`, before)
+	return err
+}
+
+// GetPreferences returns the channels a user has turned on or off, by type of
+// notification. Channels which aren't in it are on.
+func (m NotificationModel) GetPreferences(userID int64) (map[string]map[string]bool, error) {
+	query := `
+		SELECT type, channel, enabled
+		FROM notification_preferences
+		WHERE user_id = $1`
+
+	ctx, cancel := context.WithTimeout(m.context(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	preferences := make(map[string]map[string]bool)
+	for rows.Next() {
+		var p NotificationPreference
+		err := rows.Scan(&p.Type, &p.Channel, &p.Enabled)
+		if err != nil {
+			return nil, err
+		}
+		if preferences[p.Type] == nil {
+			preferences[p.Type] = make(map[string]bool)
+		}
+		preferences[p.Type][p.Channel] = p.Enabled
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return preferences, nil
+}
+
+// DisabledChannels returns the channels a user has turned off for a type of
+// notification.
+func (m NotificationModel) DisabledChannels(userID int64, notificationType string) (map[string]bool, error) {
+	query := `
+		SELECT channel
+		FROM notification_preferences
+		WHERE user_id = $1 AND type = $2 AND NOT enabled`
+
+	ctx, cancel := context.WithTimeout(m.context(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID, notificationType)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	disabled := make(map[string]bool)
+	for rows.Next() {
+		var channel string
+		err := rows.Scan(&channel)
+		if err != nil {
+			return nil, err
+		}
+		disabled[channel] = true
+	}
+	return disabled, rows.Err()
+}
+
+// SetPreferences records a user's preferences, in a single transaction.
+func (m NotificationModel) SetPreferences(userID int64, preferences []NotificationPreference) error {
+	query := `
+		INSERT INTO notification_preferences (user_id, type, channel, enabled)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (user_id, type, channel) DO UPDATE
+		SET enabled = EXCLUDED.enabled, updated_at = NOW()`
+
+	ctx, cancel := context.WithTimeout(m.context(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, p := range preferences {
+		_, err = tx.ExecContext(ctx, query, userID, p.Type, p.Channel, p.Enabled)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
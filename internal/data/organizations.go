@@ -0,0 +1,551 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/shyngys9219/greenlight/internal/validator"
+)
+
+// Define constants for the roles a member can have in an organization. Each role can
+// do everything the roles below it can.
+const (
+	RoleOwner  = "owner"  // manages members and API keys
+	RoleEditor = "editor" // curates the organization's watchlist
+	RoleViewer = "viewer" // reads the organization's data
+)
+
+var roleRanks = map[string]int{RoleViewer: 1, RoleEditor: 2, RoleOwner: 3}
+
+// RoleAtLeast reports whether role grants at least the access of min.
+func RoleAtLeast(role, min string) bool {
+	return roleRanks[role] > 0 && roleRanks[role] >= roleRanks[min]
+}
+
+var (
+	// ErrDuplicateSlug is returned when an organization's slug is already taken.
+	ErrDuplicateSlug = errors.New("duplicate slug")
+	// ErrLastOwner is returned when a change would leave an organization without
+	// an owner.
+	ErrLastOwner = errors.New("last owner")
+)
+
+var slugRX = regexp.MustCompile(`^[a-z0-9](?:[a-z0-9-]{0,48}[a-z0-9])?$`)
+
+// An Organization is a group of users, such as a film society, which curates a shared
+// catalog. It's identified in URLs by its slug.
+type Organization struct {
+	ID        int64     `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	Name      string    `json:"name"`
+	Slug      string    `json:"slug"`
+	Version   int32     `json:"version"`
+}
+
+func ValidateOrganization(v *validator.Validator, org *Organization) {
+	v.Check(org.Name != "", "name", "must be provided")
+	v.Check(len(org.Name) <= 200, "name", "must not be more than 200 bytes long")
+	v.Check(validator.Matches(org.Slug, slugRX), "slug", "must be 1-50 lowercase letters, digits or hyphens, not starting or ending with a hyphen")
+}
+
+func ValidateRole(v *validator.Validator, role string, roles ...string) {
+	v.Check(validator.PermittedValue(role, roles...), "role", "must be one of "+strings.Join(roles, ", "))
+}
+
+// A Member is a user's membership of an organization.
+type Member struct {
+	UserID    int64     `json:"user_id"`
+	Name      string    `json:"name"`
+	Role      string    `json:"role"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// A WatchlistEntry is a movie on an organization's watchlist.
+type WatchlistEntry struct {
+	MovieID int64     `json:"movie_id"`
+	Title   string    `json:"title"`
+	Year    int32     `json:"year"`
+	Note    string    `json:"note,omitempty"`
+	AddedBy *int64    `json:"added_by,omitempty"` // nil once the curator's account is gone
+	AddedAt time.Time `json:"added_at"`
+}
+
+func ValidateWatchlistEntry(v *validator.Validator, entry *WatchlistEntry) {
+	v.Check(entry.MovieID > 0, "movie_id", "must be provided")
+	v.Check(len(entry.Note) <= 1000, "note", "must not be more than 1000 bytes long")
+}
+
+// OrganizationModel wraps the connection pool for the organizations,
+// organization_members and organization_watchlist tables.
+type OrganizationModel struct {
+	queryScope
+	DB *sql.DB
+}
+
+// Insert creates an organization with the given user as its first owner.
+func (m OrganizationModel) Insert(org *Organization, ownerID int64) error {
+	ctx, cancel := context.WithTimeout(m.context(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+	INSERT INTO organizations (name, slug)
+	VALUES ($1, $2)
+	RETURNING id, created_at, version`
+	err = tx.QueryRowContext(ctx, query, org.Name, org.Slug).Scan(&org.ID, &org.CreatedAt, &org.Version)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "organizations_slug_key"`:
+			return ErrDuplicateSlug
+		default:
+			return err
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, `INSERT INTO organization_members (organization_id, user_id, role) VALUES ($1, $2, 'owner')`, org.ID, ownerID)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// GetBySlug returns the organization with the given slug.
+func (m OrganizationModel) GetBySlug(slug string) (*Organization, error) {
+	query := `
+	SELECT id, created_at, name, slug, version
+	FROM organizations
+	WHERE slug = $1`
+	var org Organization
+	ctx, cancel := context.WithTimeout(m.context(), 3*time.Second)
+	defer cancel()
+	err := m.DB.QueryRowContext(ctx, query, slug).Scan(&org.ID, &org.CreatedAt, &org.Name, &org.Slug, &org.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &org, nil
+}
+
+// A Membership is an organization together with a user's role in it.
+type Membership struct {
+	Organization *Organization `json:"organization"`
+	Role         string        `json:"role"`
+}
+
+// GetAllForUser returns the organizations a user is a member of.
+func (m OrganizationModel) GetAllForUser(userID int64) ([]*Membership, error) {
+	query := `
+	SELECT organizations.id, organizations.created_at, organizations.name, organizations.slug, organizations.version, organization_members.role
+	FROM organizations
+	INNER JOIN organization_members ON organization_members.organization_id = organizations.id
+	WHERE organization_members.user_id = $1
+	ORDER BY organizations.name`
+	ctx, cancel := context.WithTimeout(m.context(), 3*time.Second)
+	defer cancel()
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	memberships := []*Membership{}
+	for rows.Next() {
+		var org Organization
+		var role string
+		err := rows.Scan(&org.ID, &org.CreatedAt, &org.Name, &org.Slug, &org.Version, &role)
+		if err != nil {
+			return nil, err
+		}
+		memberships = append(memberships, &Membership{Organization: &org, Role: role})
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return memberships, nil
+}
+
+// GetRole returns a user's role in an organization. If the user isn't a member an
+// ErrRecordNotFound error is returned.
+func (m OrganizationModel) GetRole(orgID, userID int64) (string, error) {
+	query := `SELECT role FROM organization_members WHERE organization_id = $1 AND user_id = $2`
+	var role string
+	ctx, cancel := context.WithTimeout(m.context(), 3*time.Second)
+	defer cancel()
+	err := m.DB.QueryRowContext(ctx, query, orgID, userID).Scan(&role)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return "", ErrRecordNotFound
+		default:
+			return "", err
+		}
+	}
+	return role, nil
+}
+
+// GetMembers returns the members of an organization.
+func (m OrganizationModel) GetMembers(orgID int64) ([]*Member, error) {
+	query := `
+	SELECT users.id, users.name, organization_members.role, organization_members.created_at
+	FROM organization_members
+	INNER JOIN users ON users.id = organization_members.user_id
+	WHERE organization_members.organization_id = $1
+	ORDER BY users.id`
+	ctx, cancel := context.WithTimeout(m.context(), 3*time.Second)
+	defer cancel()
+	rows, err := m.DB.QueryContext(ctx, query, orgID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	members := []*Member{}
+	for rows.Next() {
+		var member Member
+		err := rows.Scan(&member.UserID, &member.Name, &member.Role, &member.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		members = append(members, &member)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return members, nil
+}
+
+// SetMember adds a user to an organization, or changes their role if they're already
+// a member. Demoting the last owner returns an ErrLastOwner error.
+func (m OrganizationModel) SetMember(orgID, userID int64, role string) error {
+	return m.changeMembers(orgID, func(ctx context.Context, tx *sql.Tx) error {
+		query := `
+		INSERT INTO organization_members (organization_id, user_id, role)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (organization_id, user_id) DO UPDATE SET role = EXCLUDED.role`
+		_, err := tx.ExecContext(ctx, query, orgID, userID, role)
+		return err
+	})
+}
+
+// RemoveMember removes a user from an organization. If the user isn't a member an
+// ErrRecordNotFound error is returned, and removing the last owner returns an
+// ErrLastOwner error.
+func (m OrganizationModel) RemoveMember(orgID, userID int64) error {
+	return m.changeMembers(orgID, func(ctx context.Context, tx *sql.Tx) error {
+		query := `DELETE FROM organization_members WHERE organization_id = $1 AND user_id = $2`
+		result, err := tx.ExecContext(ctx, query, orgID, userID)
+		if err != nil {
+			return err
+		}
+		rowsAffected, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if rowsAffected == 0 {
+			return ErrRecordNotFound
+		}
+		return nil
+	})
+}
+
+// changeMembers runs a change to an organization's members in a transaction, and only
+// commits it if the organization still has an owner afterwards. The organization row
+// is locked, so that concurrent changes can't remove the last two owners at once.
+func (m OrganizationModel) changeMembers(orgID int64, change func(context.Context, *sql.Tx) error) error {
+	ctx, cancel := context.WithTimeout(m.context(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var id int64
+	err = tx.QueryRowContext(ctx, `SELECT id FROM organizations WHERE id = $1 FOR UPDATE`, orgID).Scan(&id)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	err = change(ctx, tx)
+	if err != nil {
+		return err
+	}
+
+	var owners int
+	err = tx.QueryRowContext(ctx, `SELECT count(*) FROM organization_members WHERE organization_id = $1 AND role = 'owner'`, orgID).Scan(&owners)
+	if err != nil {
+		return err
+	}
+	if owners == 0 {
+		return ErrLastOwner
+	}
+	return tx.Commit()
+}
+
+// GetWatchlist returns the movies on an organization's watchlist, most recently added
+// first.
+func (m OrganizationModel) GetWatchlist(orgID int64) ([]*WatchlistEntry, error) {
+	query := `
+	SELECT movies.id, movies.title, movies.year, organization_watchlist.note, organization_watchlist.added_by, organization_watchlist.added_at
+	FROM organization_watchlist
+	INNER JOIN movies ON movies.id = organization_watchlist.movie_id
+	WHERE organization_watchlist.organization_id = $1
+	ORDER BY organization_watchlist.added_at DESC, movies.id`
+	ctx, cancel := context.WithTimeout(m.context(), 3*time.Second)
+	defer cancel()
+	rows, err := m.DB.QueryContext(ctx, query, orgID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	entries := []*WatchlistEntry{}
+	for rows.Next() {
+		var entry WatchlistEntry
+		var addedBy sql.NullInt64
+		err := rows.Scan(&entry.MovieID, &entry.Title, &entry.Year, &entry.Note, &addedBy, &entry.AddedAt)
+		if err != nil {
+			return nil, err
+		}
+		if addedBy.Valid {
+			entry.AddedBy = &addedBy.Int64
+		}
+		entries = append(entries, &entry)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// AddToWatchlist adds a movie to an organization's watchlist, or updates its note if
+// it's already there. If the movie doesn't exist an ErrRecordNotFound error is
+// returned.
+func (m OrganizationModel) AddToWatchlist(orgID int64, entry *WatchlistEntry) error {
+	query := `
+	WITH upserted AS (
+		INSERT INTO organization_watchlist (organization_id, movie_id, note, added_by)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (organization_id, movie_id) DO UPDATE SET note = EXCLUDED.note
+		RETURNING movie_id, added_at
+	)
+	SELECT movies.title, movies.year, upserted.added_at
+	FROM upserted
+	INNER JOIN movies ON movies.id = upserted.movie_id`
+	ctx, cancel := context.WithTimeout(m.context(), 3*time.Second)
+	defer cancel()
+	err := m.DB.QueryRowContext(ctx, query, orgID, entry.MovieID, entry.Note, entry.AddedBy).Scan(&entry.Title, &entry.Year, &entry.AddedAt)
+	if err != nil {
+		var pqErr *pq.Error
+		switch {
+		case errors.As(err, &pqErr) && pqErr.Code.Name() == "foreign_key_violation":
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+// RemoveFromWatchlist removes a movie from an organization's watchlist, returning
+// ErrRecordNotFound if it wasn't on it.
+func (m OrganizationModel) RemoveFromWatchlist(orgID, movieID int64) error {
+	query := `DELETE FROM organization_watchlist WHERE organization_id = $1 AND movie_id = $2`
+	ctx, cancel := context.WithTimeout(m.context(), 3*time.Second)
+	defer cancel()
+	result, err := m.DB.ExecContext(ctx, query, orgID, movieID)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// APIKeyPrefix starts every API key, which tells them apart from user tokens.
+const APIKeyPrefix = "glk_"
+
+// An APIKey lets a program act for an organization with a fixed role. The plaintext
+// key is only known when the key is created.
+type APIKey struct {
+	ID             int64      `json:"id"`
+	OrganizationID int64      `json:"-"`
+	Name           string     `json:"name"`
+	Role           string     `json:"role"`
+	Plaintext      string     `json:"key,omitempty"`
+	CreatedBy      int64      `json:"created_by"`
+	CreatedAt      time.Time  `json:"created_at"`
+	LastUsedAt     *time.Time `json:"last_used_at"`
+}
+
+func ValidateAPIKey(v *validator.Validator, key *APIKey) {
+	v.Check(key.Name != "", "name", "must be provided")
+	v.Check(len(key.Name) <= 100, "name", "must not be more than 100 bytes long")
+	ValidateRole(v, key.Role, RoleEditor, RoleViewer)
+}
+
+// APIKeyModel wraps the connection pool for the api_keys table.
+type APIKeyModel struct {
+	queryScope
+	DB *sql.DB
+}
+
+// hashAPIKey returns the hash stored for a key. Like tokens, keys are long random
+// strings, so a fast hash is enough.
+func hashAPIKey(plaintext string) []byte {
+	hash := sha256.Sum256([]byte(plaintext))
+	return hash[:]
+}
+
+// Insert generates a new key and stores it, leaving the plaintext in key.Plaintext.
+func (m APIKeyModel) Insert(key *APIKey) error {
+	randomBytes := make([]byte, 20)
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return err
+	}
+	key.Plaintext = APIKeyPrefix + strings.ToLower(base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomBytes))
+
+	query := `
+	INSERT INTO api_keys (organization_id, name, hash, role, created_by)
+	VALUES ($1, $2, $3, $4, $5)
+	RETURNING id, created_at`
+	args := []any{key.OrganizationID, key.Name, hashAPIKey(key.Plaintext), key.Role, key.CreatedBy}
+	ctx, cancel := context.WithTimeout(m.context(), 3*time.Second)
+	defer cancel()
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(&key.ID, &key.CreatedAt)
+}
+
+// GetForPlaintext returns the key with the given plaintext, recording that it has been
+// used. If there's no such key an ErrRecordNotFound error is returned.
+func (m APIKeyModel) GetForPlaintext(plaintext string) (*APIKey, error) {
+	query := `
+	UPDATE api_keys
+	SET last_used_at = NOW()
+	WHERE hash = $1
+	RETURNING id, organization_id, name, role, created_by, created_at, last_used_at`
+	var key APIKey
+	var lastUsedAt time.Time
+	ctx, cancel := context.WithTimeout(m.context(), 3*time.Second)
+	defer cancel()
+	err := m.DB.QueryRowContext(ctx, query, hashAPIKey(plaintext)).Scan(
+		&key.ID, &key.OrganizationID, &key.Name, &key.Role, &key.CreatedBy, &key.CreatedAt, &lastUsedAt,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	key.LastUsedAt = &lastUsedAt
+	return &key, nil
+}
+
+// Lookup is like GetForPlaintext, without recording that the key has been used, for
+// when the database can't be written.
+func (m APIKeyModel) Lookup(plaintext string) (*APIKey, error) {
+	query := `
+	SELECT id, organization_id, name, role, created_by, created_at, last_used_at
+	FROM api_keys
+	WHERE hash = $1`
+	var key APIKey
+	var lastUsedAt time.Time
+	ctx, cancel := context.WithTimeout(m.context(), 3*time.Second)
+	defer cancel()
+	err := m.DB.QueryRowContext(ctx, query, hashAPIKey(plaintext)).Scan(
+		&key.ID, &key.OrganizationID, &key.Name, &key.Role, &key.CreatedBy, &key.CreatedAt, &lastUsedAt,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	if lastUsedAt.Unix() > 0 {
+		key.LastUsedAt = &lastUsedAt
+	}
+	return &key, nil
+}
+
+// GetAllForOrganization returns an organization's keys, without their plaintext.
+func (m APIKeyModel) GetAllForOrganization(orgID int64) ([]*APIKey, error) {
+	query := `
+	SELECT id, organization_id, name, role, created_by, created_at, last_used_at
+	FROM api_keys
+	WHERE organization_id = $1
+	ORDER BY id`
+	ctx, cancel := context.WithTimeout(m.context(), 3*time.Second)
+	defer cancel()
+	rows, err := m.DB.QueryContext(ctx, query, orgID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	keys := []*APIKey{}
+	for rows.Next() {
+		var key APIKey
+		var lastUsedAt time.Time
+		err := rows.Scan(&key.ID, &key.OrganizationID, &key.Name, &key.Role, &key.CreatedBy, &key.CreatedAt, &lastUsedAt)
+		if err != nil {
+			return nil, err
+		}
+		// The column defaults to the Unix epoch for keys which were never used.
+		if lastUsedAt.Unix() > 0 {
+			key.LastUsedAt = &lastUsedAt
+		}
+		keys = append(keys, &key)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// Delete revokes one of an organization's keys, returning ErrRecordNotFound if the
+// organization has no such key.
+func (m APIKeyModel) Delete(orgID, id int64) error {
+	query := `DELETE FROM api_keys WHERE organization_id = $1 AND id = $2`
+	ctx, cancel := context.WithTimeout(m.context(), 3*time.Second)
+	defer cancel()
+	result, err := m.DB.ExecContext(ctx, query, orgID, id)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
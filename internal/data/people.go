@@ -0,0 +1,355 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/shyngys9219/greenlight/internal/validator"
+)
+
+// ErrDuplicateCredit is returned when a person is credited on a movie with a role and
+// character they're credited with already.
+var ErrDuplicateCredit = errors.New("duplicate credit")
+
+// CreditRoles are the roles people can be credited with on a movie.
+var CreditRoles = []string{"actor", "director", "writer", "producer", "composer"}
+
+// PeopleSortSafelist holds the sort values supported by the people listing.
+var PeopleSortSafelist = []string{"id", "name", "-id", "-name"}
+
+// FilmographySortSafelist holds the sort values supported by a person's filmography.
+var FilmographySortSafelist = []string{"year", "title", "-year", "-title"}
+
+// A Person is someone who makes movies, such as an actor or a director.
+type Person struct {
+	ID        int64     `json:"id"`
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"-"`
+}
+
+func ValidatePerson(v *validator.Validator, person *Person) {
+	v.Check(person.Name != "", "name", "must be provided")
+	v.Check(len(person.Name) <= 500, "name", "must not be more than 500 bytes long")
+}
+
+// A Credit is what a person did on a movie. Character is the character an actor plays,
+// and is empty for the other roles. Position orders the credits of a movie, lowest
+// first.
+type Credit struct {
+	ID         int64  `json:"id"`
+	MovieID    int64  `json:"-"`
+	PersonID   int64  `json:"person_id"`
+	PersonName string `json:"person_name"`
+	Role       string `json:"role"`
+	Character  string `json:"character,omitempty"`
+	Position   int    `json:"position"`
+}
+
+func ValidateCredit(v *validator.Validator, credit *Credit) {
+	v.Check(credit.PersonID > 0, "person_id", "must be provided")
+	v.Check(validator.PermittedValue(credit.Role, CreditRoles...), "role", "must be actor, director, writer, producer or composer")
+	v.Check(credit.Role == "actor" || credit.Character == "", "character", "must only be given for actors")
+	v.Check(len(credit.Character) <= 500, "character", "must not be more than 500 bytes long")
+	v.Check(credit.Position >= 0, "position", "must not be negative")
+}
+
+// A FilmographyCredit is a credit of a person's filmography, with the movie it's on.
+type FilmographyCredit struct {
+	CreditID      int64  `json:"credit_id"`
+	MovieID       int64  `json:"movie_id"`
+	MoviePublicID string `json:"movie_public_id"`
+	Title         string `json:"title"`
+	Year          int32  `json:"year,omitempty"`
+	Role          string `json:"role"`
+	Character     string `json:"character,omitempty"`
+}
+
+// PersonModel wraps the connection pool for the people and movie_credits tables.
+type PersonModel struct {
+	queryScope
+	DB *sql.DB
+}
+
+// Insert adds a person.
+func (m PersonModel) Insert(person *Person) error {
+	query := `
+		INSERT INTO people (name)
+		VALUES ($1)
+		RETURNING id, created_at`
+
+	ctx, cancel := context.WithTimeout(m.context(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, person.Name).Scan(&person.ID, &person.CreatedAt)
+}
+
+// Get returns a person. If there's no such person ErrRecordNotFound is returned.
+func (m PersonModel) Get(id int64) (*Person, error) {
+	query := `SELECT id, name, created_at FROM people WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(m.context(), 3*time.Second)
+	defer cancel()
+
+	var person Person
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(&person.ID, &person.Name, &person.CreatedAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &person, nil
+}
+
+// GetAll returns a page of the people whose name contains the given one, or of
+// everyone if it's empty, and the pagination metadata.
+func (m PersonModel) GetAll(name string, filters Filters) ([]*Person, Metadata, error) {
+	query := fmt.Sprintf(`
+		SELECT count(*) OVER(), id, name, created_at
+		FROM people
+		WHERE name ILIKE '%%' || $1 || '%%' OR $1 = ''
+		ORDER BY %s %s, id %[2]s
+		LIMIT $2 OFFSET $3`, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(m.context(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, escapeLike(name), filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	people := []*Person{}
+	for rows.Next() {
+		var person Person
+		err := rows.Scan(&totalRecords, &person.ID, &person.Name, &person.CreatedAt)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		people = append(people, &person)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+	return people, calculateMetadata(totalRecords, filters.Page, filters.PageSize), nil
+}
+
+// Delete removes a person, along with their credits. If there's no such person
+// ErrRecordNotFound is returned.
+func (m PersonModel) Delete(id int64) error {
+	ctx, cancel := context.WithTimeout(m.context(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, `DELETE FROM people WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// GetCreditsForMovie returns the credits of a movie, in billing order.
+func (m PersonModel) GetCreditsForMovie(movieID int64) ([]*Credit, error) {
+	query := `
+		SELECT movie_credits.id, movie_credits.movie_id, movie_credits.person_id, people.name,
+			movie_credits.role, movie_credits.character, movie_credits.position
+		FROM movie_credits
+		INNER JOIN people ON people.id = movie_credits.person_id
+		WHERE movie_credits.movie_id = $1
+		ORDER BY movie_credits.position, movie_credits.id`
+
+	ctx, cancel := context.WithTimeout(m.context(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, movieID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	credits := []*Credit{}
+	for rows.Next() {
+		var c Credit
+		err := rows.Scan(&c.ID, &c.MovieID, &c.PersonID, &c.PersonName, &c.Role, &c.Character, &c.Position)
+		if err != nil {
+			return nil, err
+		}
+		credits = append(credits, &c)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return credits, nil
+}
+
+// GetCreditsForMovies returns the credits of each of the given movies, in billing
+// order, keyed by the movie's ID. A movie without credits has none in the map.
+func (m PersonModel) GetCreditsForMovies(movieIDs []int64) (map[int64][]*Credit, error) {
+	query := `
+		SELECT movie_credits.id, movie_credits.movie_id, movie_credits.person_id, people.name,
+			movie_credits.role, movie_credits.character, movie_credits.position
+		FROM movie_credits
+		INNER JOIN people ON people.id = movie_credits.person_id
+		WHERE movie_credits.movie_id = ANY($1)
+		ORDER BY movie_credits.movie_id, movie_credits.position, movie_credits.id`
+
+	ctx, cancel := context.WithTimeout(m.context(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, pq.Array(movieIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	credits := make(map[int64][]*Credit)
+	for rows.Next() {
+		var c Credit
+		err := rows.Scan(&c.ID, &c.MovieID, &c.PersonID, &c.PersonName, &c.Role, &c.Character, &c.Position)
+		if err != nil {
+			return nil, err
+		}
+		credits[c.MovieID] = append(credits[c.MovieID], &c)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return credits, nil
+}
+
+// GetSharedByMovies returns the people credited on every one of the given movies, in
+// whatever role, ordered by name.
+func (m PersonModel) GetSharedByMovies(movieIDs []int64) ([]*Person, error) {
+	query := `
+		SELECT people.id, people.name
+		FROM movie_credits
+		INNER JOIN people ON people.id = movie_credits.person_id
+		WHERE movie_credits.movie_id = ANY($1)
+		GROUP BY people.id, people.name
+		HAVING count(DISTINCT movie_credits.movie_id) = $2
+		ORDER BY people.name, people.id`
+
+	ctx, cancel := context.WithTimeout(m.context(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, pq.Array(movieIDs), len(movieIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	people := []*Person{}
+	for rows.Next() {
+		var person Person
+		err := rows.Scan(&person.ID, &person.Name)
+		if err != nil {
+			return nil, err
+		}
+		people = append(people, &person)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return people, nil
+}
+
+// AddCredit credits a person on a movie. A credit without a position goes after the
+// movie's other credits. If the movie or the person doesn't exist ErrRecordNotFound is
+// returned, and if the person has the same credit already ErrDuplicateCredit.
+func (m PersonModel) AddCredit(credit *Credit) error {
+	query := `
+		INSERT INTO movie_credits (movie_id, person_id, role, character, position)
+		SELECT $1, $2, $3, $4, coalesce(NULLIF($5, 0), (
+			SELECT coalesce(max(position), 0) + 1 FROM movie_credits WHERE movie_id = $1
+		))
+		RETURNING id, position, (SELECT name FROM people WHERE id = $2)`
+	args := []any{credit.MovieID, credit.PersonID, credit.Role, credit.Character, credit.Position}
+
+	ctx, cancel := context.WithTimeout(m.context(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&credit.ID, &credit.Position, &credit.PersonName)
+	if err != nil {
+		var pqErr *pq.Error
+		switch {
+		case errors.As(err, &pqErr) && pqErr.Code.Name() == "foreign_key_violation":
+			return ErrRecordNotFound
+		case errors.As(err, &pqErr) && pqErr.Code.Name() == "unique_violation":
+			return ErrDuplicateCredit
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+// DeleteCredit removes a credit of a movie. If there's no such credit ErrRecordNotFound
+// is returned.
+func (m PersonModel) DeleteCredit(movieID, id int64) error {
+	ctx, cancel := context.WithTimeout(m.context(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, `DELETE FROM movie_credits WHERE movie_id = $1 AND id = $2`, movieID, id)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// GetFilmography returns a page of the credits of a person, with the role given or all
+// of them if it's empty, and the pagination metadata.
+func (m PersonModel) GetFilmography(personID int64, role string, filters Filters) ([]*FilmographyCredit, Metadata, error) {
+	query := fmt.Sprintf(`
+		SELECT count(*) OVER(), movie_credits.id, movies.id, movies.public_id, movies.title,
+			movies.year, movie_credits.role, movie_credits.character
+		FROM movie_credits
+		INNER JOIN movies ON movies.id = movie_credits.movie_id
+		WHERE movie_credits.person_id = $1 AND (movie_credits.role = $2 OR $2 = '')
+		ORDER BY movies.%s %s, movie_credits.id %[2]s
+		LIMIT $3 OFFSET $4`, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(m.context(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, personID, role, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	credits := []*FilmographyCredit{}
+	for rows.Next() {
+		var c FilmographyCredit
+		err := rows.Scan(&totalRecords, &c.CreditID, &c.MovieID, &c.MoviePublicID, &c.Title, &c.Year, &c.Role, &c.Character)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		credits = append(credits, &c)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+	return credits, calculateMetadata(totalRecords, filters.Page, filters.PageSize), nil
+}
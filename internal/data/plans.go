@@ -0,0 +1,205 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Define constants for the plans an account can be on.
+const (
+	PlanFree = "free"
+	PlanPro  = "pro"
+)
+
+// Define constants for who assigned an account's plan. Billing providers record their
+// own name as the source.
+const (
+	PlanSourceAdmin = "admin"
+	PlanSourceTrial = "trial"
+)
+
+// Entitlements are what a plan allows. A zero limit means unlimited.
+type Entitlements struct {
+	DailyRequests int `json:"daily_requests"` // API requests per UTC day
+	WatchlistSize int `json:"watchlist_size"` // movies on an organization's watchlist
+	ExportMovies  int `json:"export_movies"`  // movies in a dataset export
+}
+
+// Plans holds the entitlements of each plan.
+var Plans = map[string]Entitlements{
+	PlanFree: {DailyRequests: 1000, WatchlistSize: 50, ExportMovies: 500},
+	PlanPro:  {DailyRequests: 50000},
+}
+
+// Define constants for the kinds of account which can be on a plan.
+const (
+	AccountUser         = "user"
+	AccountOrganization = "organization"
+)
+
+// planTables maps each kind of account to the table which holds its plan.
+var planTables = map[string]string{
+	AccountUser:         "users",
+	AccountOrganization: "organizations",
+}
+
+// An Account is a user or an organization, as far as plans are concerned.
+type Account struct {
+	Kind string
+	ID   int64
+}
+
+// String returns the account as "<kind>:<id>", the form ParseAccount reads.
+func (a Account) String() string {
+	return fmt.Sprintf("%s:%d", a.Kind, a.ID)
+}
+
+// ParseAccount parses an account written by Account.String.
+func ParseAccount(s string) (Account, error) {
+	kind, id, _ := strings.Cut(s, ":")
+	n, err := strconv.ParseInt(id, 10, 64)
+	if _, ok := planTables[kind]; !ok || err != nil || n < 1 {
+		return Account{}, fmt.Errorf("invalid account %q", s)
+	}
+	return Account{Kind: kind, ID: n}, nil
+}
+
+// A Subscription is an account's plan. Plans with an expiry fall back to free once it
+// has passed, see Effective().
+type Subscription struct {
+	Plan      string     `json:"plan"`
+	Source    string     `json:"source"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+// Effective returns the plan which is in effect at the given time.
+func (s *Subscription) Effective(now time.Time) string {
+	if s.ExpiresAt != nil && !s.ExpiresAt.After(now) {
+		return PlanFree
+	}
+	if _, ok := Plans[s.Plan]; !ok {
+		return PlanFree
+	}
+	return s.Plan
+}
+
+// Entitlements returns the entitlements which are in effect at the given time.
+func (s *Subscription) Entitlements(now time.Time) Entitlements {
+	return Plans[s.Effective(now)]
+}
+
+// PlanModel reads and assigns the plans of accounts, and counts their requests. Assign
+// is the one place plans are changed, by admins, trials and billing providers alike.
+type PlanModel struct {
+	queryScope
+	DB *sql.DB
+}
+
+// Get returns an account's subscription. If the account doesn't exist an
+// ErrRecordNotFound error is returned.
+func (m PlanModel) Get(account Account) (*Subscription, error) {
+	query := fmt.Sprintf(`SELECT plan, plan_source, plan_expires_at FROM %s WHERE id = $1`, planTables[account.Kind])
+	var sub Subscription
+	ctx, cancel := context.WithTimeout(m.context(), 3*time.Second)
+	defer cancel()
+	err := m.DB.QueryRowContext(ctx, query, account.ID).Scan(&sub.Plan, &sub.Source, &sub.ExpiresAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &sub, nil
+}
+
+// Assign puts an account on a plan. If the account doesn't exist an ErrRecordNotFound
+// error is returned.
+func (m PlanModel) Assign(account Account, sub *Subscription) error {
+	ctx, cancel := context.WithTimeout(m.context(), 3*time.Second)
+	defer cancel()
+	return assignPlan(ctx, m.DB, account, sub)
+}
+
+// execer is satisfied by both *sql.DB and *sql.Tx.
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+func assignPlan(ctx context.Context, db execer, account Account, sub *Subscription) error {
+	query := fmt.Sprintf(`
+	UPDATE %s
+	SET plan = $1, plan_source = $2, plan_expires_at = $3, version = version + 1
+	WHERE id = $4`, planTables[account.Kind])
+	result, err := db.ExecContext(ctx, query, sub.Plan, sub.Source, sub.ExpiresAt, account.ID)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// CountRequest counts a request against the account's quota for the current UTC day,
+// returning the number of requests made today including this one along with the
+// account's subscription, in one round trip.
+func (m PlanModel) CountRequest(account Account) (int, *Subscription, error) {
+	query := fmt.Sprintf(`
+	WITH usage AS (
+		INSERT INTO plan_usage (account_kind, account_id, day, requests)
+		VALUES ($1, $2, (NOW() AT TIME ZONE 'UTC')::date, 1)
+		ON CONFLICT (account_kind, account_id, day) DO UPDATE SET requests = plan_usage.requests + 1
+		RETURNING requests
+	)
+	SELECT usage.requests, plan, plan_source, plan_expires_at
+	FROM usage, %s
+	WHERE id = $2`, planTables[account.Kind])
+	var requests int
+	var sub Subscription
+	ctx, cancel := context.WithTimeout(m.context(), 3*time.Second)
+	defer cancel()
+	err := m.DB.QueryRowContext(ctx, query, account.Kind, account.ID).Scan(&requests, &sub.Plan, &sub.Source, &sub.ExpiresAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return 0, nil, ErrRecordNotFound
+		default:
+			return 0, nil, err
+		}
+	}
+	return requests, &sub, nil
+}
+
+// GetUsage returns the number of requests an account has made today.
+func (m PlanModel) GetUsage(account Account) (int, error) {
+	query := `
+	SELECT coalesce(sum(requests), 0) FROM plan_usage
+	WHERE account_kind = $1 AND account_id = $2 AND day = (NOW() AT TIME ZONE 'UTC')::date`
+	var requests int
+	ctx, cancel := context.WithTimeout(m.context(), 3*time.Second)
+	defer cancel()
+	err := m.DB.QueryRowContext(ctx, query, account.Kind, account.ID).Scan(&requests)
+	return requests, err
+}
+
+// DeleteUsageBefore deletes the request counts of days before the given one.
+func (m PlanModel) DeleteUsageBefore(day time.Time) (int64, error) {
+	ctx, cancel := context.WithTimeout(m.context(), 30*time.Second)
+	defer cancel()
+	result, err := m.DB.ExecContext(ctx, `DELETE FROM plan_usage WHERE day < $1::date`, day.UTC().Format("2006-01-02"))
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
@@ -0,0 +1,189 @@
+package data
+
+import (
+	"container/list"
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/shyngys9219/greenlight/internal/validator"
+)
+
+// Define the comparison operators which can be used in filters, mapped to their SQL.
+// Only operators in this map are ever written into a query.
+var filterOperators = map[string]string{
+	"eq":  "=",
+	"neq": "<>",
+	"gt":  ">",
+	"gte": ">=",
+	"lt":  "<",
+	"lte": "<=",
+}
+
+// A Filter is a comparison filter read from the query string, such as year[gte]=2000.
+type Filter struct {
+	Field string
+	Op    string
+	Value int
+}
+
+// MaxFilters is the most comparison filters, or filters on custom fields, a listing
+// accepts. Each one is a condition of the query, so they're limited like its other
+// parameters.
+const MaxFilters = 10
+
+// ValidateFilters checks that every filter uses one of the given fields and a
+// supported operator, and that there aren't more than MaxFilters of them.
+func ValidateFilters(v *validator.Validator, filters []Filter, fields ...string) {
+	v.Check(len(filters) <= MaxFilters, "filters", fmt.Sprintf("must not contain more than %d filters", MaxFilters))
+	for _, f := range filters {
+		key := fmt.Sprintf("%s[%s]", f.Field, f.Op)
+		v.Check(validator.PermittedValue(f.Field, fields...), key, "is not a filterable field")
+		_, ok := filterOperators[f.Op]
+		v.Check(ok, key, "must use one of the eq, neq, gt, gte, lt or lte operators")
+	}
+}
+
+// A filterBuilder builds the WHERE clause for a query out of optional filters. Values
+// are never written into the SQL itself: every condition refers to them through
+// numbered placeholders, and the values are collected in args in the same order.
+type filterBuilder struct {
+	conditions []string
+	args       []any
+}
+
+// add appends a condition to the clause. The condition uses ? for each of its values,
+// which are replaced by the next numbered placeholders, for example
+// add("year >= ?", 2000) becomes "year >= $1".
+func (b *filterBuilder) add(condition string, values ...any) {
+	for _, value := range values {
+		b.args = append(b.args, value)
+		condition = strings.Replace(condition, "?", fmt.Sprintf("$%d", len(b.args)), 1)
+	}
+	b.conditions = append(b.conditions, condition)
+}
+
+// arg adds a value which the query uses outside the WHERE clause, such as in its
+// SELECT list, and returns its placeholder.
+func (b *filterBuilder) arg(value any) string {
+	b.args = append(b.args, value)
+	return fmt.Sprintf("$%d", len(b.args))
+}
+
+// compare appends a "column <op> value" condition. The column must come from a fixed
+// list in the calling code, never from user input; the operator is looked up in
+// filterOperators, and an unknown operator panics because it means the caller skipped
+// validation.
+func (b *filterBuilder) compare(column, op string, value any) {
+	sqlOp, ok := filterOperators[op]
+	if !ok {
+		panic(fmt.Sprintf("unknown filter operator %q", op))
+	}
+	b.add(column+" "+sqlOp+" ?", value)
+}
+
+// where returns the conditions joined with AND, or "TRUE" if there are none, so that
+// it can always be dropped into a WHERE clause.
+func (b *filterBuilder) where() string {
+	if len(b.conditions) == 0 {
+		return "TRUE"
+	}
+	return "(" + strings.Join(b.conditions, ") AND (") + ")"
+}
+
+// stmtCacheSize is the most prepared statements a stmtCache keeps.
+const stmtCacheSize = 256
+
+// stmtCache keeps prepared statements for queries which are built at runtime. The
+// database/sql package prepares a statement on each pool connection the first time it
+// runs there and re-uses it afterwards, so caching the *sql.Stmt by its query text
+// means every distinct filter combination is only parsed and planned once per
+// connection. Clients choose the filters, so the number of distinct queries has no
+// bound of its own: the cache keeps the stmtCacheSize most recently used statements,
+// and closes the others once they're no longer in use.
+type stmtCache struct {
+	db      *sql.DB
+	size    int
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	lru     *list.List // of *cachedStmt, the most recently used first
+}
+
+// A cachedStmt is a statement in a stmtCache, with the number of queries using it.
+type cachedStmt struct {
+	query   string
+	stmt    *sql.Stmt
+	refs    int
+	evicted bool
+}
+
+func newStmtCache(db *sql.DB) *stmtCache {
+	return &stmtCache{db: db, size: stmtCacheSize, entries: make(map[string]*list.Element), lru: list.New()}
+}
+
+// prepare returns the prepared statement for query, preparing it first if needed, and
+// a function to call once the statement is no longer used. The statement isn't
+// prepared under the lock, so a slow prepare doesn't hold up queries which are already
+// cached; if two requests prepare the same query at once, the first one's statement
+// is kept.
+func (c *stmtCache) prepare(ctx context.Context, query string) (*sql.Stmt, func(), error) {
+	c.mu.Lock()
+	if el, ok := c.entries[query]; ok {
+		stmt, release := c.use(el)
+		c.mu.Unlock()
+		return stmt, release, nil
+	}
+	c.mu.Unlock()
+
+	stmt, err := c.db.PrepareContext(ctx, query)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	c.mu.Lock()
+	if el, ok := c.entries[query]; ok {
+		cached, release := c.use(el)
+		c.mu.Unlock()
+		stmt.Close()
+		return cached, release, nil
+	}
+	c.entries[query] = c.lru.PushFront(&cachedStmt{query: query, stmt: stmt})
+	var unused []*sql.Stmt
+	for c.lru.Len() > c.size {
+		e := c.lru.Remove(c.lru.Back()).(*cachedStmt)
+		delete(c.entries, e.query)
+		e.evicted = true
+		if e.refs == 0 {
+			unused = append(unused, e.stmt)
+		}
+	}
+	stmt, release := c.use(c.entries[query])
+	c.mu.Unlock()
+
+	// Closing a statement closes it on the connections it was prepared on, so it's
+	// done without holding the lock.
+	for _, s := range unused {
+		s.Close()
+	}
+	return stmt, release, nil
+}
+
+// use marks a cached statement as used, and returns it with the function which
+// releases it. The lock must be held.
+func (c *stmtCache) use(el *list.Element) (*sql.Stmt, func()) {
+	c.lru.MoveToFront(el)
+	e := el.Value.(*cachedStmt)
+	e.refs++
+	release := func() {
+		c.mu.Lock()
+		e.refs--
+		closing := e.evicted && e.refs == 0
+		c.mu.Unlock()
+		if closing {
+			e.stmt.Close()
+		}
+	}
+	return e.stmt, release
+}
@@ -0,0 +1,231 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/shyngys9219/greenlight/internal/validator"
+)
+
+func TestFilterBuilder(t *testing.T) {
+	tests := []struct {
+		name  string
+		build func(b *filterBuilder)
+		where string
+		args  []any
+	}{
+		{
+			name:  "no conditions",
+			build: func(b *filterBuilder) {},
+			where: "TRUE",
+		},
+		{
+			name: "numbered placeholders",
+			build: func(b *filterBuilder) {
+				b.add("movies.title = ?", "Heat")
+				b.add("movies.year BETWEEN ? AND ?", 1990, 1999)
+			},
+			where: "(movies.title = $1) AND (movies.year BETWEEN $2 AND $3)",
+			args:  []any{"Heat", 1990, 1999},
+		},
+		{
+			name: "operator filters",
+			build: func(b *filterBuilder) {
+				b.compare("movies.year", "gte", 2000)
+				b.compare("movies.runtime", "neq", 120)
+			},
+			where: "(movies.year >= $1) AND (movies.runtime <> $2)",
+			args:  []any{2000, 120},
+		},
+		{
+			name: "values outside the clause",
+			build: func(b *filterBuilder) {
+				b.add("movies.year = ?", 2000)
+				if got := b.arg("Heat"); got != "$2" {
+					t.Errorf("arg() = %q; want $2", got)
+				}
+				b.add("movies.runtime = ?", 120)
+			},
+			where: "(movies.year = $1) AND (movies.runtime = $3)",
+			args:  []any{2000, "Heat", 120},
+		},
+		{
+			// Values are never written into the SQL, whatever they hold.
+			name: "values stay out of the SQL",
+			build: func(b *filterBuilder) {
+				b.add("movies.title = ?", "'; DROP TABLE movies; --")
+			},
+			where: "(movies.title = $1)",
+			args:  []any{"'; DROP TABLE movies; --"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var b filterBuilder
+			tt.build(&b)
+			if got := b.where(); got != tt.where {
+				t.Errorf("where() = %q; want %q", got, tt.where)
+			}
+			if !reflect.DeepEqual(b.args, tt.args) {
+				t.Errorf("args = %v; want %v", b.args, tt.args)
+			}
+		})
+	}
+}
+
+func TestFilterBuilderUnknownOperator(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("compare() with an unknown operator didn't panic")
+		}
+	}()
+	var b filterBuilder
+	b.compare("movies.year", "like", 2000)
+}
+
+func TestValidateFilters(t *testing.T) {
+	tooMany := make([]Filter, MaxFilters+1)
+	for i := range tooMany {
+		tooMany[i] = Filter{Field: "year", Op: "eq", Value: i}
+	}
+
+	tests := []struct {
+		name    string
+		filters []Filter
+		errors  []string
+	}{
+		{
+			name:    "valid",
+			filters: []Filter{{Field: "year", Op: "gte", Value: 2000}, {Field: "runtime", Op: "lt", Value: 120}},
+		},
+		{
+			name:    "unknown field",
+			filters: []Filter{{Field: "budget", Op: "gt", Value: 1}},
+			errors:  []string{"budget[gt]"},
+		},
+		{
+			name:    "unknown operator",
+			filters: []Filter{{Field: "year", Op: "like", Value: 1}},
+			errors:  []string{"year[like]"},
+		},
+		{
+			name:    "too many filters",
+			filters: tooMany,
+			errors:  []string{"filters"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := validator.New()
+			ValidateFilters(v, tt.filters, MovieFilterFields...)
+			if len(v.Errors) != len(tt.errors) {
+				t.Fatalf("errors = %v; want %v", v.Errors, tt.errors)
+			}
+			for _, key := range tt.errors {
+				if _, ok := v.Errors[key]; !ok {
+					t.Errorf("errors = %v; want one for %s", v.Errors, key)
+				}
+			}
+		})
+	}
+}
+
+// fakeDriver is a database driver whose statements do nothing, which counts the
+// statements prepared and closed.
+type fakeDriver struct {
+	mu       sync.Mutex
+	prepared int
+	closed   int
+}
+
+func (d *fakeDriver) Open(name string) (driver.Conn, error) { return fakeConn{d}, nil }
+
+type fakeConn struct{ d *fakeDriver }
+
+func (c fakeConn) Prepare(query string) (driver.Stmt, error) {
+	c.d.mu.Lock()
+	defer c.d.mu.Unlock()
+	c.d.prepared++
+	return fakeStmt{c.d}, nil
+}
+func (c fakeConn) Close() error              { return nil }
+func (c fakeConn) Begin() (driver.Tx, error) { return nil, errors.New("not supported") }
+
+type fakeStmt struct{ d *fakeDriver }
+
+func (s fakeStmt) Close() error {
+	s.d.mu.Lock()
+	defer s.d.mu.Unlock()
+	s.d.closed++
+	return nil
+}
+func (s fakeStmt) NumInput() int                                   { return -1 }
+func (s fakeStmt) Exec(args []driver.Value) (driver.Result, error) { return driver.ResultNoRows, nil }
+func (s fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	return nil, errors.New("not supported")
+}
+
+func (d *fakeDriver) counts() (int, int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.prepared, d.closed
+}
+
+func TestStmtCache(t *testing.T) {
+	d := &fakeDriver{}
+	sql.Register("fake", d)
+	db, err := sql.Open("fake", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	c := newStmtCache(db)
+	c.size = 2
+	ctx := context.Background()
+
+	prepare := func(query string) func() {
+		t.Helper()
+		_, release, err := c.prepare(ctx, query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return release
+	}
+
+	prepare("SELECT 1")()
+	prepare("SELECT 2")()
+	prepare("SELECT 1")()
+	if prepared, closed := d.counts(); prepared != 2 || closed != 0 {
+		t.Fatalf("after re-using a query: prepared %d, closed %d; want 2, 0", prepared, closed)
+	}
+
+	// SELECT 2 is the least recently used, so a third query evicts it.
+	prepare("SELECT 3")()
+	if _, ok := c.entries["SELECT 2"]; ok || len(c.entries) != 2 {
+		t.Fatalf("entries = %v; want SELECT 1 and SELECT 3", c.entries)
+	}
+	if _, closed := d.counts(); closed != 1 {
+		t.Fatalf("closed %d statements; want the evicted one", closed)
+	}
+
+	// A statement in use when it's evicted is only closed once it's released.
+	release := prepare("SELECT 1")
+	prepare("SELECT 4")()
+	prepare("SELECT 5")()
+	if _, closed := d.counts(); closed != 2 {
+		t.Fatalf("closed %d statements; want 2, with SELECT 1 still in use", closed)
+	}
+	release()
+	if _, closed := d.counts(); closed != 3 {
+		t.Fatalf("closed %d statements; want 3 once SELECT 1 was released", closed)
+	}
+}
@@ -0,0 +1,182 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/shyngys9219/greenlight/internal/validator"
+)
+
+// Define constants for the kinds of principals a rate limit override can be for.
+const (
+	PrincipalIP     = "ip"
+	PrincipalUser   = "user"
+	PrincipalAPIKey = "api_key"
+)
+
+// Define constants for what an override does to the principal's requests.
+const (
+	OverrideUnlimited = "unlimited" // never rate limited, for example internal monitors
+	OverrideLimit     = "limit"     // limited to RPS and Burst instead of the global limit
+	OverrideBlock     = "block"     // every request is rejected, for abusive clients
+)
+
+// A RateLimitOverride replaces the global rate limit for a single principal: a client
+// IP address, or the ID of a user or of an organization API key.
+type RateLimitOverride struct {
+	ID        int64      `json:"id"`
+	Kind      string     `json:"kind"`
+	Principal string     `json:"principal"`
+	Mode      string     `json:"mode"`
+	RPS       float64    `json:"rps,omitempty"`
+	Burst     int        `json:"burst,omitempty"`
+	Reason    string     `json:"reason"`
+	CreatedBy *int64     `json:"created_by"`
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+// Key returns the key the override is looked up by, such as "ip:203.0.113.7".
+func (o *RateLimitOverride) Key() string {
+	return RateLimitOverrideKey(o.Kind, o.Principal)
+}
+
+// RateLimitOverrideKey returns the key of the override for a principal.
+func RateLimitOverrideKey(kind, principal string) string {
+	return kind + ":" + principal
+}
+
+func ValidateRateLimitOverride(v *validator.Validator, o *RateLimitOverride) {
+	v.Check(validator.PermittedValue(o.Kind, PrincipalIP, PrincipalUser, PrincipalAPIKey), "kind", "must be ip, user or api_key")
+	switch o.Kind {
+	case PrincipalIP:
+		v.Check(net.ParseIP(o.Principal) != nil, "principal", "must be an IP address")
+	case PrincipalUser, PrincipalAPIKey:
+		id, err := strconv.ParseInt(o.Principal, 10, 64)
+		v.Check(err == nil && id > 0, "principal", "must be an ID")
+	}
+	v.Check(validator.PermittedValue(o.Mode, OverrideUnlimited, OverrideLimit, OverrideBlock), "mode", "must be unlimited, limit or block")
+	if o.Mode == OverrideLimit {
+		v.Check(o.RPS > 0, "rps", "must be greater than zero")
+		v.Check(o.Burst > 0, "burst", "must be greater than zero")
+	}
+	v.Check(len(o.Reason) <= 500, "reason", "must not be more than 500 bytes long")
+	if o.ExpiresAt != nil {
+		v.Check(o.ExpiresAt.After(time.Now()), "expires_at", "must be in the future")
+	}
+}
+
+// RateLimitOverrideModel wraps the connection pool for the rate_limit_overrides table.
+type RateLimitOverrideModel struct {
+	queryScope
+	DB *sql.DB
+}
+
+// GetAll returns the overrides which haven't expired, ordered by kind and principal.
+func (m RateLimitOverrideModel) GetAll() ([]*RateLimitOverride, error) {
+	query := `
+	SELECT id, kind, principal, mode, rps, burst, reason, created_by, created_at, expires_at
+	FROM rate_limit_overrides
+	WHERE expires_at IS NULL OR expires_at > NOW()
+	ORDER BY kind, principal`
+	ctx, cancel := context.WithTimeout(m.context(), 3*time.Second)
+	defer cancel()
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	overrides := []*RateLimitOverride{}
+	for rows.Next() {
+		var o RateLimitOverride
+		err := rows.Scan(&o.ID, &o.Kind, &o.Principal, &o.Mode, &o.RPS, &o.Burst, &o.Reason, &o.CreatedBy, &o.CreatedAt, &o.ExpiresAt)
+		if err != nil {
+			return nil, err
+		}
+		overrides = append(overrides, &o)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return overrides, nil
+}
+
+// Save creates the override for a principal, or replaces the one it has already. The
+// override's previous state is returned, or nil if there was none.
+func (m RateLimitOverrideModel) Save(o *RateLimitOverride) (*RateLimitOverride, error) {
+	ctx, cancel := context.WithTimeout(m.context(), 3*time.Second)
+	defer cancel()
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var previous RateLimitOverride
+	err = tx.QueryRowContext(ctx, `
+	SELECT id, kind, principal, mode, rps, burst, reason, created_by, created_at, expires_at
+	FROM rate_limit_overrides
+	WHERE kind = $1 AND principal = $2
+	FOR UPDATE`, o.Kind, o.Principal).Scan(&previous.ID, &previous.Kind, &previous.Principal, &previous.Mode, &previous.RPS, &previous.Burst, &previous.Reason, &previous.CreatedBy, &previous.CreatedAt, &previous.ExpiresAt)
+	found := true
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			return nil, err
+		}
+		found = false
+	}
+
+	query := `
+	INSERT INTO rate_limit_overrides (kind, principal, mode, rps, burst, reason, created_by, expires_at)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+	ON CONFLICT (kind, principal) DO UPDATE
+	SET mode = EXCLUDED.mode, rps = EXCLUDED.rps, burst = EXCLUDED.burst, reason = EXCLUDED.reason,
+		created_by = EXCLUDED.created_by, created_at = NOW(), expires_at = EXCLUDED.expires_at
+	RETURNING id, created_at`
+	args := []any{o.Kind, o.Principal, o.Mode, o.RPS, o.Burst, o.Reason, o.CreatedBy, o.ExpiresAt}
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&o.ID, &o.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, nil
+	}
+	return &previous, nil
+}
+
+// Delete removes an override and returns it. If there's no such override,
+// ErrRecordNotFound is returned.
+func (m RateLimitOverrideModel) Delete(id int64) (*RateLimitOverride, error) {
+	query := `
+	DELETE FROM rate_limit_overrides
+	WHERE id = $1
+	RETURNING id, kind, principal, mode, rps, burst, reason, created_by, created_at, expires_at`
+	ctx, cancel := context.WithTimeout(m.context(), 3*time.Second)
+	defer cancel()
+	var o RateLimitOverride
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(&o.ID, &o.Kind, &o.Principal, &o.Mode, &o.RPS, &o.Burst, &o.Reason, &o.CreatedBy, &o.CreatedAt, &o.ExpiresAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &o, nil
+}
+
+// DeleteExpired removes the overrides which have expired.
+func (m RateLimitOverrideModel) DeleteExpired() error {
+	ctx, cancel := context.WithTimeout(m.context(), 3*time.Second)
+	defer cancel()
+	_, err := m.DB.ExecContext(ctx, `DELETE FROM rate_limit_overrides WHERE expires_at <= NOW()`)
+	return err
+}
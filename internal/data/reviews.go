@@ -0,0 +1,414 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/shyngys9219/greenlight/internal/validator"
+)
+
+// ErrDuplicateReview is returned when a user reviews a movie they have already reviewed.
+var ErrDuplicateReview = errors.New("duplicate review")
+
+// ErrOwnReview is returned when a user votes on their own review.
+var ErrOwnReview = errors.New("own review")
+
+// ReviewsSortSafelist holds the sort values supported by the movie reviews listing.
+var ReviewsSortSafelist = []string{"id", "created_at", "rating", "helpfulness", "-id", "-created_at", "-rating", "-helpfulness"}
+
+// A Review is a user's rating of a movie from 1 to 10, with an optional text. The
+// author is identified by their public ID and name, never their internal ID.
+type Review struct {
+	ID           int64     `json:"id"`
+	MovieID      int64     `json:"-"`
+	UserID       int64     `json:"-"`
+	UserPublicID string    `json:"user_public_id"`
+	UserName     string    `json:"user_name"`
+	Rating       int       `json:"rating"`
+	Body         string    `json:"body,omitempty"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+	// The numbers of users who voted the review helpful and unhelpful. The reviews are
+	// sorted by helpfulness on a score computed from them, see updateHelpfulness().
+	HelpfulCount   int `json:"helpful_count"`
+	UnhelpfulCount int `json:"unhelpful_count"`
+	// Truncated is set on reviews in a listing whose body was shortened.
+	Truncated *Truncation `json:"truncated,omitempty"`
+	// Hidden is set on the reviews of shadow-limited accounts, which only their authors
+	// see, and which don't count towards the movie's ratings. It isn't shown, so that
+	// the authors can't tell.
+	Hidden bool `json:"-"`
+}
+
+func ValidateReview(v *validator.Validator, review *Review) {
+	v.Check(review.Rating >= 1 && review.Rating <= 10, "rating", "must be between 1 and 10")
+	v.Check(len(review.Body) <= 10_000, "body", "must not be more than 10000 bytes long")
+}
+
+// ReviewModel wraps the connection pool for the reviews table. Every change to the
+// reviews of a movie also updates the movie's average_rating and review_count, in the
+// same transaction.
+type ReviewModel struct {
+	queryScope
+	DB *sql.DB
+}
+
+// Insert adds a review of a movie. If the movie doesn't exist ErrRecordNotFound is
+// returned, and if the user has already reviewed it ErrDuplicateReview.
+func (m ReviewModel) Insert(review *Review) error {
+	ctx, cancel := context.WithTimeout(m.context(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = lockMovie(ctx, tx, review.MovieID)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO reviews (user_id, movie_id, rating, body, hidden)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, created_at, updated_at`
+	args := []any{review.UserID, review.MovieID, review.Rating, review.Body, review.Hidden}
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&review.ID, &review.CreatedAt, &review.UpdatedAt)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "reviews_user_id_movie_id_key"`:
+			return ErrDuplicateReview
+		default:
+			return err
+		}
+	}
+
+	err = tx.QueryRowContext(ctx, `SELECT public_id, name FROM users WHERE id = $1`, review.UserID).Scan(&review.UserPublicID, &review.UserName)
+	if err != nil {
+		return err
+	}
+
+	err = updateRatings(ctx, tx, review.MovieID)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Get returns a review of a movie. If there's no such review ErrRecordNotFound is
+// returned.
+func (m ReviewModel) Get(movieID, id int64) (*Review, error) {
+	query := `
+		SELECT reviews.id, reviews.movie_id, reviews.user_id, users.public_id, users.name,
+			reviews.rating, reviews.body, reviews.created_at, reviews.updated_at,
+			reviews.helpful_count, reviews.unhelpful_count, reviews.hidden
+		FROM reviews
+		INNER JOIN users ON users.id = reviews.user_id
+		WHERE reviews.movie_id = $1 AND reviews.id = $2`
+
+	ctx, cancel := context.WithTimeout(m.context(), 3*time.Second)
+	defer cancel()
+
+	var r Review
+	err := m.DB.QueryRowContext(ctx, query, movieID, id).Scan(
+		&r.ID, &r.MovieID, &r.UserID, &r.UserPublicID, &r.UserName,
+		&r.Rating, &r.Body, &r.CreatedAt, &r.UpdatedAt,
+		&r.HelpfulCount, &r.UnhelpfulCount, &r.Hidden,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &r, nil
+}
+
+// GetAllForMovie returns a page of the reviews of a movie, and the pagination metadata.
+// Hidden reviews are left out, but for the viewer's own.
+func (m ReviewModel) GetAllForMovie(movieID, viewerID int64, filters Filters) ([]*Review, Metadata, error) {
+	query := fmt.Sprintf(`
+		SELECT count(*) OVER(), reviews.id, reviews.movie_id, reviews.user_id, users.public_id, users.name,
+			reviews.rating, reviews.body, reviews.created_at, reviews.updated_at,
+			reviews.helpful_count, reviews.unhelpful_count, reviews.hidden
+		FROM reviews
+		INNER JOIN users ON users.id = reviews.user_id
+		WHERE reviews.movie_id = $1 AND (NOT reviews.hidden OR reviews.user_id = $2)
+		ORDER BY reviews.%s %s, reviews.id %[2]s
+		LIMIT $3 OFFSET $4`, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(m.context(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, movieID, viewerID, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	reviews := []*Review{}
+	for rows.Next() {
+		var r Review
+		err := rows.Scan(
+			&totalRecords, &r.ID, &r.MovieID, &r.UserID, &r.UserPublicID, &r.UserName,
+			&r.Rating, &r.Body, &r.CreatedAt, &r.UpdatedAt,
+			&r.HelpfulCount, &r.UnhelpfulCount, &r.Hidden,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		reviews = append(reviews, &r)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+	return reviews, calculateMetadata(totalRecords, filters.Page, filters.PageSize), nil
+}
+
+// Delete removes a review of a movie. If there's no such review ErrRecordNotFound is
+// returned.
+func (m ReviewModel) Delete(movieID, id int64) error {
+	ctx, cancel := context.WithTimeout(m.context(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = lockMovie(ctx, tx, movieID)
+	if err != nil {
+		return err
+	}
+
+	result, err := tx.ExecContext(ctx, `DELETE FROM reviews WHERE movie_id = $1 AND id = $2`, movieID, id)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	err = updateRatings(ctx, tx, movieID)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Vote records a user's vote on whether a review of a movie is helpful, replacing their
+// earlier vote on it if they had one, and returns the review with its new counts. If
+// there's no such review ErrRecordNotFound is returned, and if it's the user's own
+// review ErrOwnReview.
+func (m ReviewModel) Vote(movieID, id, userID int64, helpful bool) (*Review, error) {
+	query := `
+		INSERT INTO review_votes (review_id, user_id, helpful)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (review_id, user_id) DO UPDATE
+		SET helpful = EXCLUDED.helpful, created_at = NOW()`
+
+	err := m.changeVote(movieID, id, userID, func(ctx context.Context, tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, query, id, userID, helpful)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return m.Get(movieID, id)
+}
+
+// DeleteVote withdraws a user's vote on a review of a movie, and returns the review
+// with its new counts. If there's no such review, or the user hasn't voted on it,
+// ErrRecordNotFound is returned.
+func (m ReviewModel) DeleteVote(movieID, id, userID int64) (*Review, error) {
+	err := m.changeVote(movieID, id, userID, func(ctx context.Context, tx *sql.Tx) error {
+		result, err := tx.ExecContext(ctx, `DELETE FROM review_votes WHERE review_id = $1 AND user_id = $2`, id, userID)
+		if err != nil {
+			return err
+		}
+		rowsAffected, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if rowsAffected == 0 {
+			return ErrRecordNotFound
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return m.Get(movieID, id)
+}
+
+// The changeVote() helper runs change, which adds or removes a user's vote on a review,
+// in a transaction which first locks the review, so that concurrent votes can't both
+// count from the votes as they were before either of them, and then updates the
+// review's counts.
+func (m ReviewModel) changeVote(movieID, id, userID int64, change func(context.Context, *sql.Tx) error) error {
+	ctx, cancel := context.WithTimeout(m.context(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var authorID int64
+	query := `SELECT user_id FROM reviews WHERE movie_id = $1 AND id = $2 FOR UPDATE`
+	err = tx.QueryRowContext(ctx, query, movieID, id).Scan(&authorID)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+	// Authors can't vote their own reviews up, nor anyone else's down on their behalf.
+	if authorID == userID {
+		return ErrOwnReview
+	}
+
+	err = change(ctx, tx)
+	if err != nil {
+		return err
+	}
+	err = updateHelpfulness(ctx, tx, id)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// The lockMovie() helper locks a movie's row until the end of the transaction, or
+// returns ErrRecordNotFound if there's no such movie. Changes to a movie's reviews take
+// the lock first, so that concurrent changes can't both compute the movie's ratings
+// from the reviews as they were before either of them.
+func lockMovie(ctx context.Context, tx *sql.Tx, movieID int64) error {
+	var id int64
+	err := tx.QueryRowContext(ctx, `SELECT id FROM movies WHERE id = $1 FOR UPDATE`, movieID).Scan(&id)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+// The lockReviewedMovies() helper locks the movies a user has reviewed, and returns
+// their IDs, so that their ratings can be updated once the user's reviews are gone.
+func lockReviewedMovies(ctx context.Context, tx *sql.Tx, userID int64) ([]int64, error) {
+	query := `
+		SELECT id FROM movies
+		WHERE id IN (SELECT movie_id FROM reviews WHERE user_id = $1)
+		ORDER BY id
+		FOR UPDATE`
+	rows, err := tx.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// The updateRatings() helper recomputes the average rating and review count of the
+// given movies from their reviews, leaving the hidden ones out. The movies should have been locked by the
+// transaction beforehand.
+func updateRatings(ctx context.Context, tx *sql.Tx, movieIDs ...int64) error {
+	if len(movieIDs) == 0 {
+		return nil
+	}
+	query := `
+		UPDATE movies
+		SET average_rating = stats.average_rating, review_count = stats.review_count
+		FROM (
+			SELECT ids.id, round(avg(reviews.rating), 2) AS average_rating, count(reviews.id) AS review_count
+			FROM unnest($1::bigint[]) AS ids(id)
+			LEFT JOIN reviews ON reviews.movie_id = ids.id AND NOT reviews.hidden
+			GROUP BY ids.id
+		) AS stats
+		WHERE movies.id = stats.id`
+	_, err := tx.ExecContext(ctx, query, pq.Array(movieIDs))
+	return err
+}
+
+// The lockVotedReviews() helper locks the reviews a user has voted on, and returns
+// their IDs, so that their counts can be updated once the user's votes are gone.
+func lockVotedReviews(ctx context.Context, tx *sql.Tx, userID int64) ([]int64, error) {
+	query := `
+		SELECT id FROM reviews
+		WHERE id IN (SELECT review_id FROM review_votes WHERE user_id = $1)
+		ORDER BY id
+		FOR UPDATE`
+	rows, err := tx.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// The updateHelpfulness() helper recomputes the vote counts of the given reviews from
+// their votes, and their helpfulness score, which is the lower bound of the 95%
+// confidence interval of the share of helpful votes (the Wilson score). Ranking on it
+// rather than on the share itself keeps a review with a single helpful vote from
+// outranking one with a hundred helpful votes and a few unhelpful ones, and makes a
+// handful of accounts voting together count for little. The reviews should have been
+// locked by the transaction beforehand.
+func updateHelpfulness(ctx context.Context, tx *sql.Tx, reviewIDs ...int64) error {
+	if len(reviewIDs) == 0 {
+		return nil
+	}
+	query := `
+		UPDATE reviews
+		SET helpful_count = stats.helpful, unhelpful_count = stats.total - stats.helpful,
+			helpfulness = CASE WHEN stats.total = 0 THEN 0 ELSE (
+				stats.helpful::float8 / stats.total + 1.9208 / stats.total
+				- 1.96 * sqrt(stats.helpful::float8 * (stats.total - stats.helpful) / stats.total + 0.9604) / stats.total
+			) / (1 + 3.8416 / stats.total) END
+		FROM (
+			SELECT ids.id, count(review_votes.user_id) FILTER (WHERE review_votes.helpful) AS helpful,
+				count(review_votes.user_id) AS total
+			FROM unnest($1::bigint[]) AS ids(id)
+			LEFT JOIN review_votes ON review_votes.review_id = ids.id
+			GROUP BY ids.id
+		) AS stats
+		WHERE reviews.id = stats.id`
+	_, err := tx.ExecContext(ctx, query, pq.Array(reviewIDs))
+	return err
+}
@@ -0,0 +1,238 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/shyngys9219/greenlight/internal/validator"
+)
+
+// Define constants for the RSVP states of a screening invite.
+const (
+	RSVPPending = "pending"
+	RSVPYes     = "yes"
+	RSVPNo      = "no"
+	RSVPMaybe   = "maybe"
+)
+
+// A Screening is a watch party: a user (the host) schedules a time to watch a movie and
+// invites other users to join.
+type Screening struct {
+	ID           int64     `json:"id"`
+	CreatedAt    time.Time `json:"-"`
+	MovieID      int64     `json:"movie_id"`
+	HostID       int64     `json:"host_id"`
+	StartsAt     time.Time `json:"starts_at"`
+	Note         string    `json:"note,omitempty"`
+	ReminderSent bool      `json:"-"`
+	Version      int32     `json:"version"`
+}
+
+// An Attendee is a user invited to a screening, together with their RSVP. The email
+// address is only used for notifications and never appears in responses.
+type Attendee struct {
+	UserID int64  `json:"user_id"`
+	Name   string `json:"name"`
+	Email  string `json:"-"`
+	RSVP   string `json:"rsvp"`
+}
+
+func ValidateScreening(v *validator.Validator, screening *Screening) {
+	v.Check(screening.MovieID > 0, "movie_id", "must be provided")
+	v.Check(!screening.StartsAt.IsZero(), "starts_at", "must be provided")
+	v.Check(screening.StartsAt.After(time.Now()), "starts_at", "must be in the future")
+	v.Check(len(screening.Note) <= 1000, "note", "must not be more than 1000 bytes long")
+}
+
+func ValidateRSVP(v *validator.Validator, rsvp string) {
+	v.Check(validator.PermittedValue(rsvp, RSVPYes, RSVPNo, RSVPMaybe), "rsvp", "must be yes, no or maybe")
+}
+
+// ScreeningModel wraps the connection pool for the screenings and screening_invites
+// tables.
+type ScreeningModel struct {
+	queryScope
+	DB *sql.DB
+}
+
+// Insert adds a new screening. If the movie doesn't exist an ErrRecordNotFound error is
+// returned.
+func (m ScreeningModel) Insert(screening *Screening) error {
+	query := `
+	INSERT INTO screenings (movie_id, host_id, starts_at, note)
+	VALUES ($1, $2, $3, $4)
+	RETURNING id, created_at, version`
+	args := []any{screening.MovieID, screening.HostID, screening.StartsAt, screening.Note}
+	ctx, cancel := context.WithTimeout(m.context(), 3*time.Second)
+	defer cancel()
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&screening.ID, &screening.CreatedAt, &screening.Version)
+	if err != nil {
+		var pqErr *pq.Error
+		switch {
+		case errors.As(err, &pqErr) && pqErr.Code.Name() == "foreign_key_violation":
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+// Get returns a specific screening.
+func (m ScreeningModel) Get(id int64) (*Screening, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+	query := `
+	SELECT id, created_at, movie_id, host_id, starts_at, note, reminder_sent, version
+	FROM screenings
+	WHERE id = $1`
+	var screening Screening
+	ctx, cancel := context.WithTimeout(m.context(), 3*time.Second)
+	defer cancel()
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&screening.ID,
+		&screening.CreatedAt,
+		&screening.MovieID,
+		&screening.HostID,
+		&screening.StartsAt,
+		&screening.Note,
+		&screening.ReminderSent,
+		&screening.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &screening, nil
+}
+
+// Invite adds the given users to a screening and returns the ones who were newly
+// invited, so that only they get notified. Unknown user IDs and users who are already
+// invited are skipped.
+func (m ScreeningModel) Invite(screeningID int64, userIDs []int64) ([]*Attendee, error) {
+	query := `
+	WITH inserted AS (
+		INSERT INTO screening_invites (screening_id, user_id)
+		SELECT $1, id FROM users WHERE id = ANY($2)
+		ON CONFLICT DO NOTHING
+		RETURNING user_id, rsvp
+	)
+	SELECT users.id, users.name, users.email, inserted.rsvp
+	FROM users
+	INNER JOIN inserted ON users.id = inserted.user_id`
+	ctx, cancel := context.WithTimeout(m.context(), 3*time.Second)
+	defer cancel()
+	rows, err := m.DB.QueryContext(ctx, query, screeningID, pq.Array(userIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scanAttendees(rows)
+}
+
+// GetAttendees returns everyone invited to a screening.
+func (m ScreeningModel) GetAttendees(screeningID int64) ([]*Attendee, error) {
+	query := `
+	SELECT users.id, users.name, users.email, screening_invites.rsvp
+	FROM users
+	INNER JOIN screening_invites ON users.id = screening_invites.user_id
+	WHERE screening_invites.screening_id = $1
+	ORDER BY users.id`
+	ctx, cancel := context.WithTimeout(m.context(), 3*time.Second)
+	defer cancel()
+	rows, err := m.DB.QueryContext(ctx, query, screeningID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scanAttendees(rows)
+}
+
+func scanAttendees(rows *sql.Rows) ([]*Attendee, error) {
+	attendees := []*Attendee{}
+	for rows.Next() {
+		var attendee Attendee
+		err := rows.Scan(&attendee.UserID, &attendee.Name, &attendee.Email, &attendee.RSVP)
+		if err != nil {
+			return nil, err
+		}
+		attendees = append(attendees, &attendee)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return attendees, nil
+}
+
+// SetRSVP records a user's response to a screening invite. If the user wasn't invited
+// an ErrRecordNotFound error is returned.
+func (m ScreeningModel) SetRSVP(screeningID, userID int64, rsvp string) error {
+	query := `
+	UPDATE screening_invites
+	SET rsvp = $1
+	WHERE screening_id = $2 AND user_id = $3`
+	ctx, cancel := context.WithTimeout(m.context(), 3*time.Second)
+	defer cancel()
+	result, err := m.DB.ExecContext(ctx, query, rsvp, screeningID, userID)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// ClaimDueReminders marks every screening starting within the given window whose
+// reminder hasn't been sent yet as reminded, and returns them. Claiming and returning
+// in one statement means that a reminder is never sent twice.
+func (m ScreeningModel) ClaimDueReminders(within time.Duration) ([]*Screening, error) {
+	query := `
+	UPDATE screenings
+	SET reminder_sent = true
+	WHERE NOT reminder_sent
+	AND starts_at > NOW()
+	AND starts_at <= $1
+	RETURNING id, created_at, movie_id, host_id, starts_at, note, reminder_sent, version`
+	ctx, cancel := context.WithTimeout(m.context(), 3*time.Second)
+	defer cancel()
+	rows, err := m.DB.QueryContext(ctx, query, time.Now().Add(within))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	screenings := []*Screening{}
+	for rows.Next() {
+		var screening Screening
+		err := rows.Scan(
+			&screening.ID,
+			&screening.CreatedAt,
+			&screening.MovieID,
+			&screening.HostID,
+			&screening.StartsAt,
+			&screening.Note,
+			&screening.ReminderSent,
+			&screening.Version,
+		)
+		if err != nil {
+			return nil, err
+		}
+		screenings = append(screenings, &screening)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return screenings, nil
+}
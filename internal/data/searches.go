@@ -0,0 +1,115 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// maxQueryLength is the length normalized queries are cut to, in runes.
+const maxQueryLength = 200
+
+// NormalizeQuery returns the form of a search query which is recorded, so that
+// searches for the same thing are counted together: lowercased, with surrounding
+// whitespace trimmed and runs of whitespace collapsed into single spaces.
+func NormalizeQuery(query string) string {
+	query = strings.Join(strings.Fields(strings.ToLower(query)), " ")
+	if utf8.RuneCountInString(query) > maxQueryLength {
+		query = string([]rune(query)[:maxQueryLength])
+	}
+	return query
+}
+
+// A SearchQuery is one search. UserHash identifies the user without revealing who they
+// are; it's empty for anonymous searches.
+type SearchQuery struct {
+	UserHash string
+	Query    string
+	Results  int
+}
+
+// A SearchQueryStat summarises the searches for one normalized query.
+type SearchQueryStat struct {
+	Query        string    `json:"query"`
+	Searches     int       `json:"searches"`
+	Users        int       `json:"users"`
+	ZeroResults  int       `json:"zero_results"`
+	LastSearched time.Time `json:"last_searched"`
+}
+
+// SearchModel wraps the connection pool for the search_queries table.
+type SearchModel struct {
+	queryScope
+	DB *sql.DB
+}
+
+func (m SearchModel) Insert(q *SearchQuery) error {
+	query := `
+	INSERT INTO search_queries (user_hash, query, results)
+	VALUES ($1, $2, $3)`
+	ctx, cancel := context.WithTimeout(m.context(), 3*time.Second)
+	defer cancel()
+	_, err := m.DB.ExecContext(ctx, query, q.UserHash, q.Query, q.Results)
+	return err
+}
+
+// TopQueries returns the most searched queries since the given time, most searched
+// first. If zeroResults is true only queries whose latest search found nothing are
+// returned, which are the titles the catalog is missing.
+func (m SearchModel) TopQueries(since time.Time, limit int, zeroResults bool) ([]*SearchQueryStat, error) {
+	query := `
+	SELECT query, searches, users, zero_results, last_searched FROM (
+		SELECT
+			query,
+			count(*) AS searches,
+			count(DISTINCT nullif(user_hash, '')) AS users,
+			count(*) FILTER (WHERE results = 0) AS zero_results,
+			max(searched_at) AS last_searched,
+			(array_agg(results ORDER BY searched_at DESC, id DESC))[1] AS latest_results
+		FROM search_queries
+		WHERE searched_at > $1
+		GROUP BY query
+	) AS stats
+	WHERE NOT $3 OR latest_results = 0
+	ORDER BY searches DESC, query
+	LIMIT $2`
+	ctx, cancel := context.WithTimeout(m.context(), 10*time.Second)
+	defer cancel()
+	rows, err := m.DB.QueryContext(ctx, query, since, limit, zeroResults)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	stats := []*SearchQueryStat{}
+	for rows.Next() {
+		var stat SearchQueryStat
+		err := rows.Scan(&stat.Query, &stat.Searches, &stat.Users, &stat.ZeroResults, &stat.LastSearched)
+		if err != nil {
+			return nil, err
+		}
+		stats = append(stats, &stat)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+// DeleteBefore removes searches older than the given time.
+func (m SearchModel) DeleteBefore(before time.Time) error {
+	ctx, cancel := context.WithTimeout(m.context(), 30*time.Second)
+	defer cancel()
+	_, err := m.DB.ExecContext(ctx, `DELETE FROM search_queries WHERE searched_at < $1`, before)
+	return err
+}
+
+// DeleteForUser removes the searches recorded under a user's hash, for users who
+// withdraw their consent to analytics.
+func (m SearchModel) DeleteForUser(userHash string) error {
+	ctx, cancel := context.WithTimeout(m.context(), 30*time.Second)
+	defer cancel()
+	_, err := m.DB.ExecContext(ctx, `DELETE FROM search_queries WHERE user_hash = $1`, userHash)
+	return err
+}
@@ -0,0 +1,50 @@
+package data
+
+import (
+	"context"
+	"time"
+)
+
+// MaxSitemapURLs is the most URLs a single sitemap file may list, by the sitemaps
+// protocol.
+const MaxSitemapURLs = 50_000
+
+// A SitemapEntry is a movie listed in the sitemaps. LastModified is when the movie or
+// any of its translations last changed.
+type SitemapEntry struct {
+	ID           int64
+	PublicID     string
+	LastModified time.Time
+}
+
+// GetSitemapEntries returns up to limit movies in ID order, starting after the given
+// ID, so that the whole catalog can be paged through without OFFSET.
+func (m MovieModel) GetSitemapEntries(afterID int64, limit int) ([]*SitemapEntry, error) {
+	query := `
+	SELECT movies.id, movies.public_id,
+		GREATEST(movies.updated_at, (SELECT max(updated_at) FROM movie_translations WHERE movie_id = movies.id))
+	FROM movies
+	WHERE movies.id > $1
+	ORDER BY movies.id
+	LIMIT $2`
+	ctx, cancel := context.WithTimeout(m.context(), 30*time.Second)
+	defer cancel()
+	rows, err := m.DB.QueryContext(ctx, query, afterID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	entries := []*SitemapEntry{}
+	for rows.Next() {
+		var entry SitemapEntry
+		err := rows.Scan(&entry.ID, &entry.PublicID, &entry.LastModified)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, &entry)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
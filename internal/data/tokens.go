@@ -6,8 +6,10 @@ import (
 	"crypto/sha256"
 	"database/sql"
 	"encoding/base32"
+	"errors"
 	"time"
 
+	"github.com/lib/pq"
 	"github.com/shyngys9219/greenlight/internal/validator"
 )
 
@@ -16,8 +18,16 @@ import (
 const (
 	ScopeActivation     = "activation"
 	ScopeAuthentication = "authentication"
+	ScopeAccountMerge   = "account-merge" // confirms merging the token's user into another account
+	ScopePasswordReset  = "password-reset"
+	ScopeRefresh        = "refresh"     // single-use, exchanged for a new access token
+	ScopeUnsubscribe    = "unsubscribe" // opts the token's user out of campaign emails
 )
 
+// ErrTokenReused is returned by Rotate when a refresh token is presented which has been
+// exchanged already.
+var ErrTokenReused = errors.New("refresh token reused")
+
 // Define a Token struct to hold the data for an individual token. This includes the
 // plaintext and hashed versions of the token, associated user ID, expiry time and
 // scope.
@@ -26,7 +36,10 @@ type Token struct {
 	Hash      []byte    `json:"-"`
 	UserID    int64     `json:"-"`
 	Expiry    time.Time `json:"expiry"`
-	Scope     string    `json:"-"'`
+	Scope     string    `json:"-"`
+	// Family is shared by the tokens issued from the same login, if they're tracked
+	// together; see Rotate.
+	Family []byte `json:"-"`
 }
 
 func generateToken(userID int64, ttl time.Duration, scope string) (*Token, error) {
@@ -74,9 +87,16 @@ func ValidateTokenPlaintext(v *validator.Validator, tokenPlaintext string) {
 
 // Define the TokenModel type.
 type TokenModel struct {
+	queryScope
 	DB *sql.DB
 }
 
+// NewWithExpiry is like New, but the token expires at the given time rather than after
+// a time-to-live.
+func (m TokenModel) NewWithExpiry(userID int64, expiry time.Time, scope string) (*Token, error) {
+	return m.New(userID, time.Until(expiry), scope)
+}
+
 // The New() method is a shortcut which creates a new Token struct and then inserts the
 // data in the tokens table.
 func (m TokenModel) New(userID int64, ttl time.Duration, scope string) (*Token, error) {
@@ -88,25 +108,194 @@ func (m TokenModel) New(userID int64, ttl time.Duration, scope string) (*Token,
 	return token, err
 }
 
+// NewInFamily is like New, but the token belongs to the given family.
+func (m TokenModel) NewInFamily(userID int64, ttl time.Duration, scope string, family []byte) (*Token, error) {
+	token, err := generateToken(userID, ttl, scope)
+	if err != nil {
+		return nil, err
+	}
+	token.Family = family
+	err = m.Insert(token)
+	return token, err
+}
+
+// NewFamily returns a new, random token family.
+func NewFamily() ([]byte, error) {
+	family := make([]byte, 16)
+	_, err := rand.Read(family)
+	if err != nil {
+		return nil, err
+	}
+	return family, nil
+}
+
 // Insert() adds the data for a specific token to the tokens table.
 func (m TokenModel) Insert(token *Token) error {
 	query := `
-	INSERT INTO tokens (hash, user_id, expiry, scope)
-	VALUES ($1, $2, $3, $4)`
-	args := []any{token.Hash, token.UserID, token.Expiry, token.Scope}
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	INSERT INTO tokens (hash, user_id, expiry, scope, family)
+	VALUES ($1, $2, $3, $4, $5)`
+	args := []any{token.Hash, token.UserID, token.Expiry, token.Scope, token.Family}
+	ctx, cancel := context.WithTimeout(m.context(), 3*time.Second)
 	defer cancel()
 	_, err := m.DB.ExecContext(ctx, query, args...)
 	return err
 }
 
+// Rotate exchanges a refresh token for a new one in the same family, valid for ttl.
+// Each refresh token can only be exchanged once: if one is presented again, someone
+// else may have a copy of it, so the whole family is deleted and ErrTokenReused is
+// returned, together with the presented token so the caller knows whose it was. If
+// there's no such refresh token, or it has expired, ErrRecordNotFound is returned.
+func (m TokenModel) Rotate(plaintext string, ttl time.Duration) (*Token, error) {
+	hash := sha256.Sum256([]byte(plaintext))
+
+	ctx, cancel := context.WithTimeout(m.context(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	query := `
+	SELECT user_id, expiry, family, used_at
+	FROM tokens
+	WHERE hash = $1 AND scope = $2 AND expiry > NOW()
+	FOR UPDATE`
+	presented := &Token{Hash: hash[:], Scope: ScopeRefresh}
+	var usedAt sql.NullTime
+	err = tx.QueryRowContext(ctx, query, hash[:], ScopeRefresh).Scan(&presented.UserID, &presented.Expiry, &presented.Family, &usedAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	if usedAt.Valid {
+		_, err = tx.ExecContext(ctx, `DELETE FROM tokens WHERE family = $1`, presented.Family)
+		if err != nil {
+			return nil, err
+		}
+		err = tx.Commit()
+		if err != nil {
+			return nil, err
+		}
+		return presented, ErrTokenReused
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE tokens SET used_at = NOW() WHERE hash = $1`, hash[:])
+	if err != nil {
+		return nil, err
+	}
+
+	next, err := generateToken(presented.UserID, ttl, ScopeRefresh)
+	if err != nil {
+		return nil, err
+	}
+	next.Family = presented.Family
+	query = `
+	INSERT INTO tokens (hash, user_id, expiry, scope, family)
+	VALUES ($1, $2, $3, $4, $5)`
+	_, err = tx.ExecContext(ctx, query, next.Hash, next.UserID, next.Expiry, next.Scope, next.Family)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return next, nil
+}
+
+// DeleteFamily deletes every token of a family.
+func (m TokenModel) DeleteFamily(family []byte) error {
+	ctx, cancel := context.WithTimeout(m.context(), 3*time.Second)
+	defer cancel()
+	_, err := m.DB.ExecContext(ctx, `DELETE FROM tokens WHERE family = $1`, family)
+	return err
+}
+
+// DeleteOtherSessions deletes a user's authentication and refresh tokens, except for
+// those of the session the token with the given plaintext belongs to: the token itself
+// and the rest of its family. With an empty plaintext every session is ended.
+func (m TokenModel) DeleteOtherSessions(userID int64, currentPlaintext string) error {
+	hash := sha256.Sum256([]byte(currentPlaintext))
+	query := `
+	DELETE FROM tokens
+	WHERE user_id = $1 AND scope IN ($2, $3)
+	AND hash <> $4
+	AND (family IS NULL OR family IS DISTINCT FROM (SELECT family FROM tokens WHERE hash = $4))`
+	ctx, cancel := context.WithTimeout(m.context(), 3*time.Second)
+	defer cancel()
+	_, err := m.DB.ExecContext(ctx, query, userID, ScopeAuthentication, ScopeRefresh, hash[:])
+	return err
+}
+
+// DeleteExpired deletes the expired tokens with the given scope.
+func (m TokenModel) DeleteExpired(scope string) error {
+	ctx, cancel := context.WithTimeout(m.context(), 30*time.Second)
+	defer cancel()
+	_, err := m.DB.ExecContext(ctx, `DELETE FROM tokens WHERE scope = $1 AND expiry <= NOW()`, scope)
+	return err
+}
+
 // DeleteAllForUser() deletes all tokens for a specific user and scope.
 func (m TokenModel) DeleteAllForUser(scope string, userID int64) error {
 	query := `
 	DELETE FROM tokens
 	WHERE scope = $1 AND user_id = $2`
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(m.context(), 3*time.Second)
 	defer cancel()
 	_, err := m.DB.ExecContext(ctx, query, scope, userID)
 	return err
 }
+
+// DeleteAll deletes every token, across all users, with the given scope (or with any
+// scope if scope is empty) which was issued before the given time. It returns how many
+// tokens were deleted.
+func (m TokenModel) DeleteAll(scope string, issuedBefore time.Time) (int64, error) {
+	query := `
+	DELETE FROM tokens
+	WHERE (scope = $1 OR $1 = '')
+	AND created_at < $2`
+	ctx, cancel := context.WithTimeout(m.context(), 10*time.Second)
+	defer cancel()
+	result, err := m.DB.ExecContext(ctx, query, scope, issuedBefore)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// DeleteAllForUsers deletes all tokens with the given scope for a set of users.
+func (m TokenModel) DeleteAllForUsers(scope string, userIDs []int64) error {
+	query := `
+	DELETE FROM tokens
+	WHERE scope = $1 AND user_id = ANY($2)`
+	ctx, cancel := context.WithTimeout(m.context(), 3*time.Second)
+	defer cancel()
+	_, err := m.DB.ExecContext(ctx, query, scope, pq.Array(userIDs))
+	return err
+}
+
+// LastIssuedAt returns when the most recent token with the given scope was issued to a
+// user. If the user has no such token, the zero time is returned.
+func (m TokenModel) LastIssuedAt(scope string, userID int64) (time.Time, error) {
+	query := `
+	SELECT max(created_at)
+	FROM tokens
+	WHERE scope = $1 AND user_id = $2`
+	ctx, cancel := context.WithTimeout(m.context(), 3*time.Second)
+	defer cancel()
+	var issued sql.NullTime
+	err := m.DB.QueryRowContext(ctx, query, scope, userID).Scan(&issued)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return issued.Time, nil
+}
@@ -0,0 +1,150 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/shyngys9219/greenlight/internal/validator"
+)
+
+// Locales are the languages movie metadata can be translated into, and DefaultLocale
+// is the one served to clients which don't ask for any of them.
+var Locales = []string{"en", "kk", "ru"}
+
+const DefaultLocale = "en"
+
+// A MovieTranslation is a movie's title and overview in one locale.
+type MovieTranslation struct {
+	MovieID   int64     `json:"-"`
+	Locale    string    `json:"locale"`
+	Title     string    `json:"title"`
+	Overview  string    `json:"overview"`
+	UpdatedAt time.Time `json:"updated_at"`
+	Version   int32     `json:"version"`
+}
+
+func ValidateMovieTranslation(v *validator.Validator, t *MovieTranslation) {
+	v.Check(validator.PermittedValue(t.Locale, Locales...), "locale", "must be en, kk or ru")
+	v.Check(t.Title != "", "title", "must be provided")
+	v.Check(len(t.Title) <= 500, "title", "must not be more than 500 bytes long")
+	v.Check(len(t.Overview) <= 5000, "overview", "must not be more than 5000 bytes long")
+}
+
+// The Localize() method returns a copy of the movie with the title and overview of the
+// first of the given locales it has a translation for, and that locale. If it has none
+// of them the movie itself is returned, with an empty locale. The movie's translations
+// must have been loaded.
+func (movie *Movie) Localize(locales []string) (*Movie, string) {
+	for _, locale := range locales {
+		for _, t := range movie.Translations {
+			if t.Locale == locale {
+				localized := *movie
+				localized.Title = t.Title
+				localized.Overview = t.Overview
+				// A highlight is of the title which was searched, not of its translation.
+				localized.Highlight = ""
+				return &localized, locale
+			}
+		}
+	}
+	return movie, ""
+}
+
+// MovieTranslationModel wraps the connection pool for the movie_translations table.
+type MovieTranslationModel struct {
+	queryScope
+	DB *sql.DB
+}
+
+// GetAllForMovies returns the translations of the given movies, by movie ID.
+func (m MovieTranslationModel) GetAllForMovies(movieIDs []int64) (map[int64][]*MovieTranslation, error) {
+	query := `
+	SELECT movie_id, locale, title, overview, updated_at, version
+	FROM movie_translations
+	WHERE movie_id = ANY($1)
+	ORDER BY movie_id, locale`
+	ctx, cancel := context.WithTimeout(m.context(), 3*time.Second)
+	defer cancel()
+	rows, err := m.DB.QueryContext(ctx, query, pq.Array(movieIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	translations := make(map[int64][]*MovieTranslation)
+	for rows.Next() {
+		var t MovieTranslation
+		err := rows.Scan(&t.MovieID, &t.Locale, &t.Title, &t.Overview, &t.UpdatedAt, &t.Version)
+		if err != nil {
+			return nil, err
+		}
+		translations[t.MovieID] = append(translations[t.MovieID], &t)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return translations, nil
+}
+
+// Load sets the Translations field of each of the movies.
+func (m MovieTranslationModel) Load(movies ...*Movie) error {
+	ids := make([]int64, len(movies))
+	for i, movie := range movies {
+		ids[i] = movie.ID
+	}
+	translations, err := m.GetAllForMovies(ids)
+	if err != nil {
+		return err
+	}
+	for _, movie := range movies {
+		movie.Translations = translations[movie.ID]
+		if movie.Translations == nil {
+			movie.Translations = []*MovieTranslation{}
+		}
+	}
+	return nil
+}
+
+// Save creates the translation of a movie into a locale, or replaces it if there's one
+// already. If the movie doesn't exist, ErrRecordNotFound is returned.
+func (m MovieTranslationModel) Save(t *MovieTranslation) error {
+	query := `
+	INSERT INTO movie_translations (movie_id, locale, title, overview)
+	VALUES ($1, $2, $3, $4)
+	ON CONFLICT (movie_id, locale) DO UPDATE
+	SET title = EXCLUDED.title, overview = EXCLUDED.overview, updated_at = NOW(), version = movie_translations.version + 1
+	RETURNING updated_at, version`
+	args := []any{t.MovieID, t.Locale, t.Title, t.Overview}
+	ctx, cancel := context.WithTimeout(m.context(), 3*time.Second)
+	defer cancel()
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&t.UpdatedAt, &t.Version)
+	if err != nil {
+		var pqErr *pq.Error
+		switch {
+		case errors.As(err, &pqErr) && pqErr.Code.Name() == "foreign_key_violation":
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+func (m MovieTranslationModel) Delete(movieID int64, locale string) error {
+	ctx, cancel := context.WithTimeout(m.context(), 3*time.Second)
+	defer cancel()
+	result, err := m.DB.ExecContext(ctx, `DELETE FROM movie_translations WHERE movie_id = $1 AND locale = $2`, movieID, locale)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
@@ -0,0 +1,192 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"strings"
+	"time"
+)
+
+// ErrTwoFactorEnabled is returned when two-factor authentication is set up again for a
+// user who already confirmed it.
+var ErrTwoFactorEnabled = errors.New("two-factor authentication is already enabled")
+
+// RecoveryCodeCount is how many recovery codes users get when they confirm two-factor
+// authentication.
+const RecoveryCodeCount = 10
+
+// TwoFactor holds a user's two-factor authentication. Secret is the TOTP secret as
+// encrypted by the API, which the database never sees in the clear. Until Confirmed the
+// user hasn't proven their authenticator works, and logins don't ask for codes.
+type TwoFactor struct {
+	UserID    int64
+	Secret    []byte
+	Confirmed bool
+	LastStep  int64
+}
+
+// NewRecoveryCodes returns n random recovery codes, formatted like "ABCD-EFGH" to be
+// easier to copy down, and their hashes to be stored.
+func NewRecoveryCodes(n int) ([]string, [][]byte, error) {
+	codes := make([]string, n)
+	hashes := make([][]byte, n)
+	for i := range codes {
+		randomBytes := make([]byte, 5)
+		_, err := rand.Read(randomBytes)
+		if err != nil {
+			return nil, nil, err
+		}
+		code := base32.StdEncoding.EncodeToString(randomBytes)
+		codes[i] = code[:4] + "-" + code[4:]
+		hashes[i] = hashRecoveryCode(codes[i])
+	}
+	return codes, hashes, nil
+}
+
+// hashRecoveryCode hashes a recovery code the way it was typed in, ignoring case,
+// dashes and spaces.
+func hashRecoveryCode(code string) []byte {
+	code = strings.ToUpper(strings.NewReplacer("-", "", " ", "").Replace(code))
+	hash := sha256.Sum256([]byte(code))
+	return hash[:]
+}
+
+// TwoFactorModel wraps the connection pool for the two_factor and recovery_codes
+// tables.
+type TwoFactorModel struct {
+	queryScope
+	DB *sql.DB
+}
+
+// Get returns a user's two-factor authentication, or ErrRecordNotFound if they never
+// set it up.
+func (m TwoFactorModel) Get(userID int64) (*TwoFactor, error) {
+	query := `
+	SELECT user_id, secret, confirmed, last_step
+	FROM two_factor
+	WHERE user_id = $1`
+	ctx, cancel := context.WithTimeout(m.context(), 3*time.Second)
+	defer cancel()
+	var tf TwoFactor
+	err := m.DB.QueryRowContext(ctx, query, userID).Scan(&tf.UserID, &tf.Secret, &tf.Confirmed, &tf.LastStep)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &tf, nil
+}
+
+// Start stores a new, unconfirmed, secret for a user, replacing one which was never
+// confirmed. If the user has already confirmed two-factor authentication
+// ErrTwoFactorEnabled is returned.
+func (m TwoFactorModel) Start(userID int64, secret []byte) error {
+	query := `
+	INSERT INTO two_factor (user_id, secret)
+	VALUES ($1, $2)
+	ON CONFLICT (user_id) DO UPDATE
+	SET secret = EXCLUDED.secret, last_step = 0, created_at = NOW()
+	WHERE NOT two_factor.confirmed`
+	ctx, cancel := context.WithTimeout(m.context(), 3*time.Second)
+	defer cancel()
+	result, err := m.DB.ExecContext(ctx, query, userID, secret)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrTwoFactorEnabled
+	}
+	return nil
+}
+
+// Confirm turns two-factor authentication on for a user after they entered the code
+// of the given step, and replaces their recovery codes with the given hashes. If it was
+// already confirmed ErrTwoFactorEnabled is returned, and ErrRecordNotFound if it was
+// never set up.
+func (m TwoFactorModel) Confirm(userID, step int64, recoveryHashes [][]byte) error {
+	ctx, cancel := context.WithTimeout(m.context(), 3*time.Second)
+	defer cancel()
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var confirmed bool
+	err = tx.QueryRowContext(ctx, `SELECT confirmed FROM two_factor WHERE user_id = $1 FOR UPDATE`, userID).Scan(&confirmed)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+	if confirmed {
+		return ErrTwoFactorEnabled
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE two_factor SET confirmed = true, last_step = $2 WHERE user_id = $1`, userID, step)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `DELETE FROM recovery_codes WHERE user_id = $1`, userID)
+	if err != nil {
+		return err
+	}
+	for _, hash := range recoveryHashes {
+		_, err = tx.ExecContext(ctx, `INSERT INTO recovery_codes (hash, user_id) VALUES ($1, $2)`, hash, userID)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// UseStep records that the code of the given step was used to log in. It reports false
+// if that code, or a later one, was used already, so an intercepted code can't be
+// replayed while it's still valid.
+func (m TwoFactorModel) UseStep(userID, step int64) (bool, error) {
+	query := `UPDATE two_factor SET last_step = $2 WHERE user_id = $1 AND last_step < $2`
+	ctx, cancel := context.WithTimeout(m.context(), 3*time.Second)
+	defer cancel()
+	result, err := m.DB.ExecContext(ctx, query, userID, step)
+	if err != nil {
+		return false, err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rowsAffected == 1, nil
+}
+
+// UseRecoveryCode uses up one of a user's recovery codes, reporting false if it isn't
+// one of theirs or was used before.
+func (m TwoFactorModel) UseRecoveryCode(userID int64, code string) (bool, error) {
+	query := `
+	UPDATE recovery_codes SET used_at = NOW()
+	WHERE hash = $1 AND user_id = $2 AND used_at IS NULL`
+	ctx, cancel := context.WithTimeout(m.context(), 3*time.Second)
+	defer cancel()
+	result, err := m.DB.ExecContext(ctx, query, hashRecoveryCode(code), userID)
+	if err != nil {
+		return false, err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rowsAffected == 1, nil
+}
@@ -0,0 +1,183 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/shyngys9219/greenlight/internal/validator"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// MaxUserImport is the most users a single import can hold.
+const MaxUserImport = 10_000
+
+// An ImportedUser is one user in a bulk import, as read from another system's dump.
+// PasswordHash must be a bcrypt hash if it's given; users imported without one have
+// to choose a password before they can log in.
+type ImportedUser struct {
+	Name         string `json:"name"`
+	Email        string `json:"email"`
+	PasswordHash string `json:"password_hash"`
+}
+
+// A UserImportReport summarises what ImportUsers did. Skipped counts the users who
+// already had an account, which are left untouched.
+type UserImportReport struct {
+	Created int      `json:"created"`
+	Skipped int      `json:"skipped"`
+	Queued  int      `json:"activation_emails_queued"`
+	Errors  []string `json:"errors"`
+}
+
+// The SetHash() method stores a password hash made elsewhere, for example by the system
+// a user is imported from. Only bcrypt hashes are accepted, since they're the only ones
+// Matches() can check.
+func (p *password) SetHash(hash string) error {
+	_, err := bcrypt.Cost([]byte(hash))
+	if err != nil {
+		return err
+	}
+	p.plaintext = nil
+	p.hash = []byte(hash)
+	return nil
+}
+
+// The setUnusable() method stores the hash of a random password nobody knows. The
+// password can never be guessed, so the cheapest bcrypt cost is enough, which keeps
+// large imports fast.
+func (p *password) setUnusable() error {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return err
+	}
+	hash, err := bcrypt.GenerateFromPassword(b, bcrypt.MinCost)
+	if err != nil {
+		return err
+	}
+	p.plaintext = nil
+	p.hash = hash
+	return nil
+}
+
+// ImportUsers creates accounts for the given users in a single transaction. Users who
+// fail validation are reported and skipped, as are those whose email address already
+// has an account; anything else going wrong rolls the whole import back. Imported users
+// are either activated straight away or queued for an activation email.
+func (m UserModel) ImportUsers(users []*ImportedUser, activated bool) (*UserImportReport, error) {
+	ctx, cancel := context.WithTimeout(m.context(), 60*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	report := &UserImportReport{Errors: []string{}}
+
+	for i, iu := range users {
+		user := &User{Name: iu.Name, Email: iu.Email, Activated: activated, Active: true}
+
+		v := validator.New()
+		if iu.PasswordHash != "" {
+			if user.Password.SetHash(iu.PasswordHash) != nil {
+				v.AddError("password_hash", "must be a bcrypt hash")
+			}
+		} else {
+			err := user.Password.setUnusable()
+			if err != nil {
+				return nil, err
+			}
+			user.PasswordResetRequired = true
+		}
+		if user.Password.hash != nil {
+			ValidateUser(v, user)
+		}
+		if !v.Valid() {
+			for field, message := range v.Errors {
+				report.Errors = append(report.Errors, fmt.Sprintf("users[%d]: %s %s", i, field, message))
+			}
+			continue
+		}
+
+		query := `
+		INSERT INTO users (public_id, name, email, password_hash, activated, active, password_reset_required, activation_queued_at)
+		VALUES ($1, $2, $3, $4, $5, true, $6, CASE WHEN $5 THEN NULL ELSE NOW() END)
+		ON CONFLICT (email) DO NOTHING
+		RETURNING id`
+		args := []any{m.publicIDs(), user.Name, user.Email, user.Password.hash, user.Activated, user.PasswordResetRequired}
+		err := tx.QueryRowContext(ctx, query, args...).Scan(&user.ID)
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			report.Skipped++
+		case err != nil:
+			return nil, err
+		default:
+			report.Created++
+			if !activated {
+				report.Queued++
+			}
+			query := `
+			INSERT INTO users_permissions (user_id, permission_id)
+			SELECT $1, permissions.id FROM permissions WHERE permissions.code = ANY($2)`
+			_, err = tx.ExecContext(ctx, query, user.ID, pq.Array([]string(DefaultPermissions)))
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// ClaimQueuedActivations takes up to limit users off the activation email queue, oldest
+// first, and returns them. Users who were activated some other way in the meantime are
+// dropped from the queue without being returned. Claimed rows are skipped by concurrent
+// claims, so each email is only sent once.
+func (m UserModel) ClaimQueuedActivations(limit int) ([]*User, error) {
+	query := `
+	WITH claimed AS (
+		SELECT id FROM users
+		WHERE activation_queued_at IS NOT NULL
+		ORDER BY activation_queued_at, id
+		LIMIT $1
+		FOR UPDATE SKIP LOCKED
+	)
+	UPDATE users
+	SET activation_queued_at = NULL
+	FROM claimed
+	WHERE users.id = claimed.id
+	RETURNING users.id, users.created_at, users.name, users.email, users.activated`
+	ctx, cancel := context.WithTimeout(m.context(), 10*time.Second)
+	defer cancel()
+	rows, err := m.DB.QueryContext(ctx, query, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	users := []*User{}
+	for rows.Next() {
+		var user User
+		err := rows.Scan(&user.ID, &user.CreatedAt, &user.Name, &user.Email, &user.Activated)
+		if err != nil {
+			return nil, err
+		}
+		if !user.Activated {
+			users = append(users, &user)
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return users, nil
+}
@@ -5,15 +5,20 @@ import (
 	"crypto/sha256"
 	"database/sql"
 	"errors"
+	"fmt"
+	"strings"
 	"time"
 
+	"github.com/lib/pq"
+	"github.com/shyngys9219/greenlight/internal/publicid"
 	"github.com/shyngys9219/greenlight/internal/validator"
 	"golang.org/x/crypto/bcrypt"
 )
 
 // Define a custom ErrDuplicateEmail error
 var (
-	ErrDuplicateEmail = errors.New("duplicate email")
+	ErrDuplicateEmail      = errors.New("duplicate email")
+	ErrDuplicateExternalID = errors.New("duplicate external id")
 )
 
 var AnonymousUser = &User{}
@@ -23,23 +28,42 @@ func (u *User) IsAnonymous() bool {
 	return u == AnonymousUser
 }
 
+// IsLocked reports whether the user's account is locked after too many failed logins.
+func (u *User) IsLocked() bool {
+	return u.LockedUntil != nil && u.LockedUntil.After(time.Now())
+}
+
 // Define a User struct to represent an individual user. Importantly, notice how we are
 // using the json:"-" struct tag to prevent the Password and Version fields appearing in
 // any output when we encode it to JSON. Also notice that the Password field uses the
 // custom password type defined below.
 type User struct {
 	ID        int64     `json:"id"`
+	PublicID  string    `json:"public_id"`
 	CreatedAt time.Time `json:"created_at"`
 	Name      string    `json:"name"`
 	Email     string    `json:"email"`
 	Password  password  `json:"-"`
 	Activated bool      `json:"activated"`
 	Version   int       `json:"-"`
+	// PasswordResetRequired is set on flagged accounts (for example after a credential
+	// leak), which must choose a new password before they can log in again.
+	PasswordResetRequired bool `json:"-"`
+	// Active is cleared when the user is deactivated through SCIM provisioning, and
+	// ExternalID is the identity provider's ID for the user, if any.
+	Active     bool   `json:"-"`
+	ExternalID string `json:"-"`
+	// LockedUntil is set while the account is locked after too many failed logins.
+	LockedUntil *time.Time `json:"-"`
+	// Locale is the language the user prefers responses in, if they have chosen one.
+	Locale string `json:"locale"`
 }
 
 // Create a UserModel struct which wraps the connection pool.
 type UserModel struct {
-	DB *sql.DB
+	queryScope
+	DB        *sql.DB
+	publicIDs publicid.Generator // generates the public IDs of new users
 }
 
 // Create a custom password type which is a struct containing the plaintext and hashed
@@ -63,6 +87,12 @@ func (p *password) Set(plaintextPassword string) error {
 	return nil
 }
 
+// The Changed() method reports whether a new password has been set since the user was
+// read from the database.
+func (p *password) Changed() bool {
+	return p.plaintext != nil
+}
+
 // The Matches() method checks whether the provided plaintext password matches the
 // hashed password stored in the struct, returning true if it matches and false
 // otherwise.
@@ -93,6 +123,9 @@ func ValidateUser(v *validator.Validator, user *User) {
 	v.Check(len(user.Name) <= 500, "name", "must not be more than 500 bytes long")
 	// Call the standalone ValidateEmail() helper.
 	ValidateEmail(v, user.Email)
+	if user.Locale != "" {
+		v.Check(validator.PermittedValue(user.Locale, Locales...), "locale", "must be en, kk or ru")
+	}
 	// If the plaintext password is not nil, call the standalone
 	// ValidatePasswordPlaintext() helper.
 	if user.Password.plaintext != nil {
@@ -114,11 +147,12 @@ func ValidateUser(v *validator.Validator, user *User) {
 // that we did when creating a movie.
 func (m UserModel) Insert(user *User) error {
 	query := `
-	INSERT INTO users (name, email, password_hash, activated)
-	VALUES ($1, $2, $3, $4)
+	INSERT INTO users (public_id, name, email, password_hash, activated, active, external_id)
+	VALUES ($1, $2, $3, $4, $5, $6, $7)
 	RETURNING id, created_at, version`
-	args := []any{user.Name, user.Email, user.Password.hash, user.Activated}
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	user.PublicID = m.publicIDs()
+	args := []any{user.PublicID, user.Name, user.Email, user.Password.hash, user.Activated, user.Active, user.ExternalID}
+	ctx, cancel := context.WithTimeout(m.context(), 3*time.Second)
 	defer cancel()
 	// If the table already contains a record with this email address, then when we try
 	// to perform the insert there will be a violation of the UNIQUE "users_email_key"
@@ -129,6 +163,8 @@ func (m UserModel) Insert(user *User) error {
 		switch {
 		case err.Error() == `pq: duplicate key value violates unique constraint "users_email_key"`:
 			return ErrDuplicateEmail
+		case err.Error() == `pq: duplicate key value violates unique constraint "users_external_id_idx"`:
+			return ErrDuplicateExternalID
 		default:
 			return err
 		}
@@ -136,25 +172,104 @@ func (m UserModel) Insert(user *User) error {
 	return nil
 }
 
+// Retrieve the User details from the database based on the user's ID.
+func (m UserModel) Get(id int64) (*User, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+	query := `
+	SELECT id, public_id, created_at, name, email, password_hash, activated, version, password_reset_required, active, external_id, locked_until, locale
+	FROM users
+	WHERE id = $1`
+	var user User
+	ctx, cancel := context.WithTimeout(m.context(), 3*time.Second)
+	defer cancel()
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&user.ID,
+		&user.PublicID,
+		&user.CreatedAt,
+		&user.Name,
+		&user.Email,
+		&user.Password.hash,
+		&user.Activated,
+		&user.Version,
+		&user.PasswordResetRequired,
+		&user.Active,
+		&user.ExternalID,
+		&user.LockedUntil,
+		&user.Locale,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &user, nil
+}
+
+// Retrieve the User details from the database based on the user's public ID.
+func (m UserModel) GetByPublicID(publicID string) (*User, error) {
+	query := `
+	SELECT id, public_id, created_at, name, email, password_hash, activated, version, password_reset_required, active, external_id, locked_until, locale
+	FROM users
+	WHERE public_id = $1`
+	var user User
+	ctx, cancel := context.WithTimeout(m.context(), 3*time.Second)
+	defer cancel()
+	err := m.DB.QueryRowContext(ctx, query, publicID).Scan(
+		&user.ID,
+		&user.PublicID,
+		&user.CreatedAt,
+		&user.Name,
+		&user.Email,
+		&user.Password.hash,
+		&user.Activated,
+		&user.Version,
+		&user.PasswordResetRequired,
+		&user.Active,
+		&user.ExternalID,
+		&user.LockedUntil,
+		&user.Locale,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &user, nil
+}
+
 // Retrieve the User details from the database based on the user's email address.
 // Because we have a UNIQUE constraint on the email column, this SQL query will only
 // return one record (or none at all, in which case we return a ErrRecordNotFound error).
 func (m UserModel) GetByEmail(email string) (*User, error) {
 	query := `
-	SELECT id, created_at, name, email, password_hash, activated, version
+	SELECT id, public_id, created_at, name, email, password_hash, activated, version, password_reset_required, active, external_id, locked_until, locale
 	FROM users
 	WHERE email = $1`
 	var user User
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(m.context(), 3*time.Second)
 	defer cancel()
 	err := m.DB.QueryRowContext(ctx, query, email).Scan(
 		&user.ID,
+		&user.PublicID,
 		&user.CreatedAt,
 		&user.Name,
 		&user.Email,
 		&user.Password.hash,
 		&user.Activated,
 		&user.Version,
+		&user.PasswordResetRequired,
+		&user.Active,
+		&user.ExternalID,
+		&user.LockedUntil,
+		&user.Locale,
 	)
 	if err != nil {
 		switch {
@@ -175,24 +290,30 @@ func (m UserModel) GetByEmail(email string) (*User, error) {
 func (m UserModel) Update(user *User) error {
 	query := `
 	UPDATE users
-	SET name = $1, email = $2, password_hash = $3, activated = $4, version = version + 1
-	WHERE id = $5 AND version = $6
+	SET name = $1, email = $2, password_hash = $3, activated = $4, password_reset_required = $5, active = $6, external_id = $7, locale = $8, version = version + 1
+	WHERE id = $9 AND version = $10
 	RETURNING version`
 	args := []any{
 		user.Name,
 		user.Email,
 		user.Password.hash,
 		user.Activated,
+		user.PasswordResetRequired,
+		user.Active,
+		user.ExternalID,
+		user.Locale,
 		user.ID,
 		user.Version,
 	}
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(m.context(), 3*time.Second)
 	defer cancel()
 	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&user.Version)
 	if err != nil {
 		switch {
 		case err.Error() == `pq: duplicate key value violates unique constraint "users_email_key"`:
 			return ErrDuplicateEmail
+		case err.Error() == `pq: duplicate key value violates unique constraint "users_external_id_idx"`:
+			return ErrDuplicateExternalID
 
 		case errors.Is(err, sql.ErrNoRows):
 			return ErrEditConflict
@@ -203,37 +324,46 @@ func (m UserModel) Update(user *User) error {
 	return nil
 }
 
+// GetForToken returns the user a token was issued to. The tokens of deactivated users
+// are never valid.
 func (m UserModel) GetForToken(tokenScope, tokenPlaintext string) (*User, error) {
 	// Calculate the SHA-256 hash of the plaintext token provided by the client.
 	// Remember that this returns a byte *array* with length 32, not a slice.
 	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
 	// Set up the SQL query.
 	query := `
-	SELECT users.id, users.created_at, users.name, users.email, users.password_hash, users.activated, users.version
+	SELECT users.id, users.public_id, users.created_at, users.name, users.email, users.password_hash, users.activated, users.version, users.password_reset_required, users.active, users.external_id, users.locked_until, users.locale
 	FROM users
 	INNER JOIN tokens
 	ON users.id = tokens.user_id
 	WHERE tokens.hash = $1
 	AND tokens.scope = $2
-	AND tokens.expiry > $3`
+	AND tokens.expiry > $3
+	AND users.active`
 	// Create a slice containing the query arguments. Notice how we use the [:] operator
 	// to get a slice containing the token hash, rather than passing in the array (which
 	// is not supported by the pq driver), and that we pass the current time as the
 	// value to check against the token expiry.
 	args := []any{tokenHash[:], tokenScope, time.Now()}
 	var user User
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(m.context(), 3*time.Second)
 	defer cancel()
 	// Execute the query, scanning the return values into a User struct. If no matching
 	// record is found we return an ErrRecordNotFound error.
 	err := m.DB.QueryRowContext(ctx, query, args...).Scan(
 		&user.ID,
+		&user.PublicID,
 		&user.CreatedAt,
 		&user.Name,
 		&user.Email,
 		&user.Password.hash,
 		&user.Activated,
 		&user.Version,
+		&user.PasswordResetRequired,
+		&user.Active,
+		&user.ExternalID,
+		&user.LockedUntil,
+		&user.Locale,
 	)
 	if err != nil {
 		switch {
@@ -246,3 +376,341 @@ func (m UserModel) GetForToken(tokenScope, tokenPlaintext string) (*User, error)
 	// Return the matching user.
 	return &user, nil
 }
+
+// FlagPasswordReset marks the given users as having to reset their password, and
+// returns how many users were flagged.
+func (m UserModel) FlagPasswordReset(ids []int64) (int64, error) {
+	query := `
+	UPDATE users
+	SET password_reset_required = true, version = version + 1
+	WHERE id = ANY($1)`
+	ctx, cancel := context.WithTimeout(m.context(), 3*time.Second)
+	defer cancel()
+	result, err := m.DB.ExecContext(ctx, query, pq.Array(ids))
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// SetLastActive records that the user has just logged in, which also clears their
+// failed logins.
+func (m UserModel) SetLastActive(id int64) error {
+	query := `
+	UPDATE users
+	SET last_active_at = NOW(), failed_logins = 0, first_failed_login_at = NULL
+	WHERE id = $1`
+	ctx, cancel := context.WithTimeout(m.context(), 3*time.Second)
+	defer cancel()
+	_, err := m.DB.ExecContext(ctx, query, id)
+	return err
+}
+
+// RecordFailedLogin counts a failed login to a user's account. Failures are counted
+// within a window from the first of them, and the one which reaches maxFailures locks
+// the account until lockedUntil and starts the count over. It reports whether this
+// failure locked the account.
+func (m UserModel) RecordFailedLogin(id int64, maxFailures int, window time.Duration, lockedUntil time.Time) (bool, error) {
+	// The row is locked before it's counted, so concurrent failures are each counted
+	// once, and only one of them can lock the account.
+	query := `
+	WITH counted AS (
+		SELECT CASE WHEN first_failed_login_at > $2 THEN failed_logins + 1 ELSE 1 END AS failures
+		FROM users
+		WHERE id = $1
+		FOR UPDATE
+	)
+	UPDATE users
+	SET failed_logins = CASE WHEN counted.failures >= $3 THEN 0 ELSE counted.failures END,
+		first_failed_login_at = CASE
+			WHEN counted.failures >= $3 THEN NULL
+			WHEN counted.failures = 1 THEN NOW()
+			ELSE users.first_failed_login_at
+		END,
+		locked_until = CASE WHEN counted.failures >= $3 THEN $4 ELSE users.locked_until END
+	FROM counted
+	WHERE users.id = $1
+	RETURNING counted.failures >= $3`
+	ctx, cancel := context.WithTimeout(m.context(), 3*time.Second)
+	defer cancel()
+	var locked bool
+	err := m.DB.QueryRowContext(ctx, query, id, time.Now().Add(-window), maxFailures, lockedUntil).Scan(&locked)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return false, ErrRecordNotFound
+		default:
+			return false, err
+		}
+	}
+	return locked, nil
+}
+
+// Unlock lifts the lock on a user's account and clears their failed logins.
+func (m UserModel) Unlock(id int64) error {
+	query := `
+	UPDATE users
+	SET locked_until = NULL, failed_logins = 0, first_failed_login_at = NULL
+	WHERE id = $1`
+	ctx, cancel := context.WithTimeout(m.context(), 3*time.Second)
+	defer cancel()
+	_, err := m.DB.ExecContext(ctx, query, id)
+	return err
+}
+
+// A PendingActivation is an unactivated user whose activation window is about to
+// close.
+type PendingActivation struct {
+	User   *User
+	Expiry time.Time
+}
+
+// ClaimActivationReminders finds the unactivated users whose newest activation token
+// expires before the given time and who haven't been reminded yet, marks them as
+// reminded, and returns them with the expiry of that token.
+func (m UserModel) ClaimActivationReminders(expiresBefore time.Time) ([]*PendingActivation, error) {
+	query := `
+	WITH due AS (
+		SELECT user_id, max(expiry) AS expiry
+		FROM tokens
+		WHERE scope = $1
+		GROUP BY user_id
+		HAVING max(expiry) > NOW() AND max(expiry) <= $2
+	)
+	UPDATE users
+	SET activation_reminder_sent = true
+	FROM due
+	WHERE users.id = due.user_id
+	AND NOT users.activated
+	AND NOT users.activation_reminder_sent
+	RETURNING users.id, users.created_at, users.name, users.email, users.activated, due.expiry`
+	ctx, cancel := context.WithTimeout(m.context(), 10*time.Second)
+	defer cancel()
+	rows, err := m.DB.QueryContext(ctx, query, ScopeActivation, expiresBefore)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	pending := []*PendingActivation{}
+	for rows.Next() {
+		var (
+			user   User
+			expiry time.Time
+		)
+		err := rows.Scan(&user.ID, &user.CreatedAt, &user.Name, &user.Email, &user.Activated, &expiry)
+		if err != nil {
+			return nil, err
+		}
+		pending = append(pending, &PendingActivation{User: &user, Expiry: expiry})
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return pending, nil
+}
+
+// DeleteLapsedUnactivated deletes the accounts which were never activated, were created
+// before the given time, and no longer have a valid activation token. Imported accounts
+// still waiting for their activation email are kept. It returns how many accounts were
+// deleted.
+func (m UserModel) DeleteLapsedUnactivated(createdBefore time.Time) (int64, error) {
+	query := `
+	DELETE FROM users
+	WHERE NOT activated
+	AND created_at < $1
+	AND activation_queued_at IS NULL
+	AND NOT EXISTS (
+		SELECT 1 FROM tokens
+		WHERE tokens.user_id = users.id
+		AND tokens.scope = $2
+		AND tokens.expiry > NOW()
+	)`
+	ctx, cancel := context.WithTimeout(m.context(), 10*time.Second)
+	defer cancel()
+	result, err := m.DB.ExecContext(ctx, query, createdBefore, ScopeActivation)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// Define constants for the operators of a UserCondition.
+const (
+	MatchEqual    = "eq"
+	MatchNotEqual = "neq"
+	MatchContains = "contains"
+	MatchPrefix   = "prefix"
+	MatchSuffix   = "suffix"
+	MatchPresent  = "present"
+)
+
+// userSearchColumns maps the fields users can be searched on to their columns, and
+// whether they are compared case-insensitively.
+var userSearchColumns = map[string]struct {
+	column     string
+	ignoreCase bool
+}{
+	"public_id":   {"users.public_id", false},
+	"external_id": {"users.external_id", false},
+	"email":       {"users.email", true},
+	"name":        {"users.name", true},
+	"active":      {"users.active", false},
+}
+
+// A UserCondition is a single condition of a user search, such as email eq
+// "alice@example.com". Value is a string for every field except active, which takes a
+// bool, and is ignored by the present operator.
+type UserCondition struct {
+	Field string
+	Op    string
+	Value any
+}
+
+// ValidateUserConditions checks that every condition uses a searchable field and a
+// supported operator.
+func ValidateUserConditions(v *validator.Validator, conditions []UserCondition) {
+	for _, c := range conditions {
+		_, ok := userSearchColumns[c.Field]
+		v.Check(ok, "filter", fmt.Sprintf("%s is not a searchable field", c.Field))
+		v.Check(validator.PermittedValue(c.Op, MatchEqual, MatchNotEqual, MatchContains, MatchPrefix, MatchSuffix, MatchPresent), "filter", fmt.Sprintf("%s is not a supported operator", c.Op))
+		if c.Field == "active" {
+			_, isBool := c.Value.(bool)
+			v.Check(isBool || c.Op == MatchPresent, "filter", "active must be compared with true or false")
+			v.Check(validator.PermittedValue(c.Op, MatchEqual, MatchNotEqual, MatchPresent), "filter", "active only supports the eq, ne and pr operators")
+		} else {
+			_, isString := c.Value.(string)
+			v.Check(isString || c.Op == MatchPresent, "filter", fmt.Sprintf("%s must be compared with a string", c.Field))
+		}
+	}
+}
+
+// Search returns the users matching all of the given conditions, ordered by ID, along
+// with the total number of matches ignoring offset and limit. The conditions must have
+// been checked with ValidateUserConditions.
+func (m UserModel) Search(conditions []UserCondition, offset, limit int) ([]*User, int, error) {
+	var b filterBuilder
+	for _, c := range conditions {
+		col := userSearchColumns[c.Field]
+		column, placeholder := col.column, "?"
+		if col.ignoreCase {
+			column, placeholder = "lower("+column+")", "lower(?)"
+		}
+		switch c.Op {
+		case MatchEqual:
+			b.add(column+" = "+placeholder, c.Value)
+		case MatchNotEqual:
+			b.add(column+" <> "+placeholder, c.Value)
+		case MatchContains:
+			b.add(column+" LIKE '%' || "+placeholder+" || '%'", escapeLike(c.Value))
+		case MatchPrefix:
+			b.add(column+" LIKE "+placeholder+" || '%'", escapeLike(c.Value))
+		case MatchSuffix:
+			b.add(column+" LIKE '%' || "+placeholder, escapeLike(c.Value))
+		case MatchPresent:
+			if c.Field != "active" {
+				b.add(col.column + " <> ''")
+			}
+		}
+	}
+
+	query := fmt.Sprintf(`
+	SELECT count(*) OVER(), id, public_id, created_at, name, email, password_hash, activated, version, password_reset_required, active, external_id, locked_until, locale
+	FROM users
+	WHERE %s
+	ORDER BY id
+	OFFSET %d LIMIT %d`, b.where(), offset, limit)
+
+	ctx, cancel := context.WithTimeout(m.context(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, b.args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	users := []*User{}
+	for rows.Next() {
+		var user User
+		err := rows.Scan(
+			&totalRecords,
+			&user.ID,
+			&user.PublicID,
+			&user.CreatedAt,
+			&user.Name,
+			&user.Email,
+			&user.Password.hash,
+			&user.Activated,
+			&user.Version,
+			&user.PasswordResetRequired,
+			&user.Active,
+			&user.ExternalID,
+			&user.LockedUntil,
+			&user.Locale,
+		)
+		if err != nil {
+			return nil, 0, err
+		}
+		users = append(users, &user)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, 0, err
+	}
+	return users, totalRecords, nil
+}
+
+// escapeLike escapes the LIKE wildcards in a search value, so that they match
+// literally.
+func escapeLike(value any) any {
+	s, ok := value.(string)
+	if !ok {
+		return value
+	}
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+// Delete removes a user and, through the foreign keys, everything they own. The ratings
+// of the movies they reviewed, and the vote counts of the reviews they voted on, are
+// updated to leave theirs out.
+func (m UserModel) Delete(id int64) error {
+	ctx, cancel := context.WithTimeout(m.context(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	reviewed, err := lockReviewedMovies(ctx, tx, id)
+	if err != nil {
+		return err
+	}
+	voted, err := lockVotedReviews(ctx, tx, id)
+	if err != nil {
+		return err
+	}
+
+	result, err := tx.ExecContext(ctx, `DELETE FROM users WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	err = updateRatings(ctx, tx, reviewed...)
+	if err != nil {
+		return err
+	}
+	err = updateHelpfulness(ctx, tx, voted...)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
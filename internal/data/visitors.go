@@ -0,0 +1,161 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base32"
+	"time"
+)
+
+// MaxVisitorHistory is the most viewed movies of a visitor which are kept when their
+// history is merged into a new account: the most recently viewed ones.
+const MaxVisitorHistory = 100
+
+// A VisitorView is a movie an anonymous visitor viewed.
+type VisitorView struct {
+	MovieID  int64     `json:"movie_id"`
+	PublicID string    `json:"public_id"`
+	Title    string    `json:"title"`
+	ViewedAt time.Time `json:"viewed_at"`
+}
+
+// NewVisitorID returns a random ID for an anonymous visitor, in the same form as the
+// plaintext of a token, and its hash. Only the hash is stored.
+func NewVisitorID() (string, []byte, error) {
+	randomBytes := make([]byte, 16)
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return "", nil, err
+	}
+	id := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomBytes)
+	return id, HashVisitorID(id), nil
+}
+
+// HashVisitorID returns the hash of a visitor ID, which their views are stored under.
+func HashVisitorID(id string) []byte {
+	hash := sha256.Sum256([]byte(id))
+	return hash[:]
+}
+
+// VisitorModel wraps the connection pool for the visitor_views and user_movie_views
+// tables.
+type VisitorModel struct {
+	queryScope
+	DB *sql.DB
+}
+
+// RecordView records that the visitor viewed a movie, now.
+func (m VisitorModel) RecordView(visitorHash []byte, movieID int64) error {
+	query := `
+	INSERT INTO visitor_views (visitor_hash, movie_id)
+	VALUES ($1, $2)
+	ON CONFLICT (visitor_hash, movie_id) DO UPDATE SET viewed_at = NOW()`
+	ctx, cancel := context.WithTimeout(m.context(), 3*time.Second)
+	defer cancel()
+	_, err := m.DB.ExecContext(ctx, query, visitorHash, movieID)
+	return err
+}
+
+// GetHistory returns the movies the visitor viewed, most recent first.
+func (m VisitorModel) GetHistory(visitorHash []byte) ([]*VisitorView, error) {
+	query := `
+	SELECT movies.id, movies.public_id, movies.title, visitor_views.viewed_at
+	FROM visitor_views
+	INNER JOIN movies ON movies.id = visitor_views.movie_id
+	WHERE visitor_views.visitor_hash = $1
+	ORDER BY visitor_views.viewed_at DESC, movies.id`
+	ctx, cancel := context.WithTimeout(m.context(), 3*time.Second)
+	defer cancel()
+	rows, err := m.DB.QueryContext(ctx, query, visitorHash)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	views := []*VisitorView{}
+	for rows.Next() {
+		var view VisitorView
+		err := rows.Scan(&view.MovieID, &view.PublicID, &view.Title, &view.ViewedAt)
+		if err != nil {
+			return nil, err
+		}
+		views = append(views, &view)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return views, nil
+}
+
+// DeleteHistory deletes everything recorded about the visitor.
+func (m VisitorModel) DeleteHistory(visitorHash []byte) error {
+	ctx, cancel := context.WithTimeout(m.context(), 3*time.Second)
+	defer cancel()
+	_, err := m.DB.ExecContext(ctx, `DELETE FROM visitor_views WHERE visitor_hash = $1`, visitorHash)
+	return err
+}
+
+// MergeIntoUser moves the MaxVisitorHistory most recent views of the visitor over to a
+// user, and deletes the rest of the visitor's history, in a single transaction. It
+// returns the number of views the user got.
+func (m VisitorModel) MergeIntoUser(visitorHash []byte, userID int64) (int64, error) {
+	ctx, cancel := context.WithTimeout(m.context(), 5*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	query := `
+	INSERT INTO user_movie_views (user_id, movie_id, viewed_at)
+	SELECT $1, movie_id, viewed_at
+	FROM visitor_views
+	WHERE visitor_hash = $2
+	ORDER BY viewed_at DESC
+	LIMIT $3
+	ON CONFLICT (user_id, movie_id) DO UPDATE
+	SET viewed_at = GREATEST(user_movie_views.viewed_at, EXCLUDED.viewed_at)`
+	result, err := tx.ExecContext(ctx, query, userID, visitorHash, MaxVisitorHistory)
+	if err != nil {
+		return 0, err
+	}
+	merged, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM visitor_views WHERE visitor_hash = $1`, visitorHash)
+	if err != nil {
+		return 0, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, err
+	}
+	return merged, nil
+}
+
+// DeleteViewsBefore deletes the visitor views recorded before the given time, and
+// returns how many there were.
+func (m VisitorModel) DeleteViewsBefore(t time.Time) (int64, error) {
+	ctx, cancel := context.WithTimeout(m.context(), 30*time.Second)
+	defer cancel()
+	result, err := m.DB.ExecContext(ctx, `DELETE FROM visitor_views WHERE viewed_at < $1`, t)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// DeleteUserViews deletes a user's viewing history, for users who withdraw their
+// consent to personalization.
+func (m VisitorModel) DeleteUserViews(userID int64) error {
+	ctx, cancel := context.WithTimeout(m.context(), 5*time.Second)
+	defer cancel()
+	_, err := m.DB.ExecContext(ctx, `DELETE FROM user_movie_views WHERE user_id = $1`, userID)
+	return err
+}
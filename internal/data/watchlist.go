@@ -0,0 +1,154 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/shyngys9219/greenlight/internal/validator"
+)
+
+// WatchlistSortSafelist holds the sort values supported by the user watchlist listing.
+var WatchlistSortSafelist = []string{"added_at", "title", "year", "-added_at", "-title", "-year"}
+
+// A WatchlistItem is a movie a user saved to watch later. WatchedAt is set when the
+// item was last marked as watched.
+type WatchlistItem struct {
+	MovieID   int64      `json:"movie_id"`
+	Title     string     `json:"title"`
+	Year      int32      `json:"year"`
+	Watched   bool       `json:"watched"`
+	AddedAt   time.Time  `json:"added_at"`
+	WatchedAt *time.Time `json:"watched_at,omitempty"`
+}
+
+func ValidateWatchlistItem(v *validator.Validator, item *WatchlistItem) {
+	v.Check(item.MovieID > 0, "movie_id", "must be provided")
+}
+
+// WatchlistModel wraps the connection pool for the watchlist table, which holds the
+// users' own watchlists.
+type WatchlistModel struct {
+	queryScope
+	DB *sql.DB
+}
+
+// Add saves a movie to a user's watchlist. Saving a movie which is already on it leaves
+// it as it was. If the movie doesn't exist an ErrRecordNotFound error is returned.
+func (m WatchlistModel) Add(userID int64, item *WatchlistItem) error {
+	query := `
+	WITH upserted AS (
+		INSERT INTO watchlist (user_id, movie_id)
+		VALUES ($1, $2)
+		ON CONFLICT (user_id, movie_id) DO UPDATE SET user_id = EXCLUDED.user_id
+		RETURNING movie_id, watched, added_at, watched_at
+	)
+	SELECT movies.title, movies.year, upserted.watched, upserted.added_at, upserted.watched_at
+	FROM upserted
+	INNER JOIN movies ON movies.id = upserted.movie_id`
+	ctx, cancel := context.WithTimeout(m.context(), 3*time.Second)
+	defer cancel()
+	err := m.DB.QueryRowContext(ctx, query, userID, item.MovieID).Scan(&item.Title, &item.Year, &item.Watched, &item.AddedAt, &item.WatchedAt)
+	if err != nil {
+		var pqErr *pq.Error
+		switch {
+		case errors.As(err, &pqErr) && pqErr.Code.Name() == "foreign_key_violation":
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+// GetAllForUser returns a page of a user's watchlist, and the pagination metadata. If
+// watched isn't nil, only the items which have or haven't been watched are returned.
+func (m WatchlistModel) GetAllForUser(userID int64, watched *bool, filters Filters) ([]*WatchlistItem, Metadata, error) {
+	// added_at is a column of the watchlist, the other sort columns are the movie's.
+	table := "movies"
+	if filters.sortColumn() == "added_at" {
+		table = "watchlist"
+	}
+	query := fmt.Sprintf(`
+	SELECT count(*) OVER(), movies.id, movies.title, movies.year, watchlist.watched, watchlist.added_at, watchlist.watched_at
+	FROM watchlist
+	INNER JOIN movies ON movies.id = watchlist.movie_id
+	WHERE watchlist.user_id = $1
+	AND ($2::boolean IS NULL OR watchlist.watched = $2)
+	ORDER BY %s.%s %s, movies.id %[3]s
+	LIMIT $3 OFFSET $4`, table, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(m.context(), 3*time.Second)
+	defer cancel()
+	rows, err := m.DB.QueryContext(ctx, query, userID, watched, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	items := []*WatchlistItem{}
+	for rows.Next() {
+		var item WatchlistItem
+		err := rows.Scan(&totalRecords, &item.MovieID, &item.Title, &item.Year, &item.Watched, &item.AddedAt, &item.WatchedAt)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		items = append(items, &item)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+	return items, calculateMetadata(totalRecords, filters.Page, filters.PageSize), nil
+}
+
+// SetWatched marks a movie on a user's watchlist as watched or not, and returns the
+// updated item. If the movie isn't on the watchlist ErrRecordNotFound is returned.
+func (m WatchlistModel) SetWatched(userID, movieID int64, watched bool) (*WatchlistItem, error) {
+	query := `
+	WITH updated AS (
+		UPDATE watchlist
+		SET watched = $3, watched_at = CASE WHEN $3 THEN NOW() END
+		WHERE user_id = $1 AND movie_id = $2
+		RETURNING movie_id, watched, added_at, watched_at
+	)
+	SELECT movies.id, movies.title, movies.year, updated.watched, updated.added_at, updated.watched_at
+	FROM updated
+	INNER JOIN movies ON movies.id = updated.movie_id`
+	ctx, cancel := context.WithTimeout(m.context(), 3*time.Second)
+	defer cancel()
+	var item WatchlistItem
+	err := m.DB.QueryRowContext(ctx, query, userID, movieID, watched).Scan(&item.MovieID, &item.Title, &item.Year, &item.Watched, &item.AddedAt, &item.WatchedAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &item, nil
+}
+
+// Remove takes a movie off a user's watchlist, returning ErrRecordNotFound if it wasn't
+// on it.
+func (m WatchlistModel) Remove(userID, movieID int64) error {
+	query := `DELETE FROM watchlist WHERE user_id = $1 AND movie_id = $2`
+	ctx, cancel := context.WithTimeout(m.context(), 3*time.Second)
+	defer cancel()
+	result, err := m.DB.ExecContext(ctx, query, userID, movieID)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
@@ -0,0 +1,43 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strconv"
+	"time"
+)
+
+// FollowsList and WatchlistList return the keys of the listings whose watermarks are
+// kept in the list_watermarks table.
+func FollowsList(userID int64) string {
+	return "follows:" + strconv.FormatInt(userID, 10)
+}
+
+func WatchlistList(organizationID int64) string {
+	return "watchlist:" + strconv.FormatInt(organizationID, 10)
+}
+
+// ListWatermarkModel reads when listings last changed. The watermarks are written by
+// triggers in the database, whenever a row of a listing is added, changed or removed.
+type ListWatermarkModel struct {
+	queryScope
+	DB *sql.DB
+}
+
+// Get returns when the listing last changed, or the zero time if it has no watermark
+// (it has never had any rows).
+func (m ListWatermarkModel) Get(list string) (time.Time, error) {
+	query := `SELECT changed_at FROM list_watermarks WHERE list = $1`
+	ctx, cancel := context.WithTimeout(m.context(), 3*time.Second)
+	defer cancel()
+	var changedAt time.Time
+	err := m.DB.QueryRowContext(ctx, query, list).Scan(&changedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return time.Time{}, nil
+		}
+		return time.Time{}, err
+	}
+	return changedAt, nil
+}
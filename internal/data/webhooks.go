@@ -0,0 +1,383 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/shyngys9219/greenlight/internal/validator"
+	"github.com/shyngys9219/greenlight/internal/webhook"
+)
+
+// Define constants for the events organizations can subscribe to with a webhook.
+const (
+	// WebhookAvailabilityChanged is delivered when a movie becomes available, or stops
+	// being available, in a region.
+	WebhookAvailabilityChanged = "movie.availability_changed"
+	// WebhookReleaseDateChanged is delivered when a movie's release date in a region is
+	// set, changed or removed.
+	WebhookReleaseDateChanged = "movie.release_date_changed"
+)
+
+// WebhookEvents lists the events which can be subscribed to.
+var WebhookEvents = []string{WebhookAvailabilityChanged, WebhookReleaseDateChanged}
+
+// The statuses of a webhook delivery.
+const (
+	WebhookPending   = "pending"
+	WebhookDelivered = "delivered"
+	WebhookDead      = "dead"
+)
+
+// A WebhookSubscription delivers the events of the movies matching its genres and
+// regions to an organization's URL. Deliveries are signed with the Secret, which is
+// only known when the subscription is created. APIKeyID is the key the subscription
+// was made with, if it was made with one.
+type WebhookSubscription struct {
+	ID             int64     `json:"id"`
+	OrganizationID int64     `json:"-"`
+	APIKeyID       *int64    `json:"api_key_id,omitempty"`
+	URL            string    `json:"url"`
+	Secret         string    `json:"secret,omitempty"`
+	Events         []string  `json:"events"`
+	Genres         []string  `json:"genres"`
+	Regions        []string  `json:"regions"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+func ValidateWebhookSubscription(v *validator.Validator, sub *WebhookSubscription) {
+	u, err := url.Parse(sub.URL)
+	v.Check(sub.URL != "", "url", "must be provided")
+	v.Check(len(sub.URL) <= 2000, "url", "must not be more than 2000 bytes long")
+	v.Check(err == nil && u.Scheme == "https" && u.Host != "" && u.User == nil, "url", "must be an https URL, without credentials")
+	if v.Valid() {
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		defer cancel()
+		err = webhook.CheckHost(ctx, u.Hostname())
+		switch {
+		case errors.Is(err, webhook.ErrNonPublicAddress):
+			v.AddError("url", "must not be a loopback, private or link-local address")
+		case err != nil:
+			v.AddError("url", "must have a host which can be resolved")
+		}
+	}
+
+	v.Check(len(sub.Events) >= 1, "events", "must contain at least 1 event")
+	v.Check(validator.Unique(sub.Events), "events", "must not contain duplicate values")
+	for _, event := range sub.Events {
+		v.Check(validator.PermittedValue(event, WebhookEvents...), "events", "must only contain known events")
+	}
+
+	v.Check(len(sub.Genres) <= 20, "genres", "must not contain more than 20 genres")
+	v.Check(validator.Unique(sub.Genres), "genres", "must not contain duplicate values")
+
+	v.Check(len(sub.Regions) <= 250, "regions", "must not contain more than 250 regions")
+	v.Check(validator.Unique(sub.Regions), "regions", "must not contain duplicate values")
+	for _, region := range sub.Regions {
+		ValidateRegion(v, "regions", region)
+	}
+}
+
+// WebhookModel wraps the connection pool for the webhook_subscriptions table.
+type WebhookModel struct {
+	queryScope
+	DB *sql.DB
+}
+
+// Insert generates the subscription's secret and stores it.
+func (m WebhookModel) Insert(sub *WebhookSubscription) error {
+	randomBytes := make([]byte, 24)
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return err
+	}
+	sub.Secret = "whsec_" + hex.EncodeToString(randomBytes)
+
+	query := `
+		INSERT INTO webhook_subscriptions (organization_id, api_key_id, url, secret, events, genres, regions)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING id, created_at`
+	args := []any{sub.OrganizationID, sub.APIKeyID, sub.URL, sub.Secret,
+		pq.Array(sub.Events), pq.Array(sub.Genres), pq.Array(sub.Regions)}
+
+	ctx, cancel := context.WithTimeout(m.context(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(&sub.ID, &sub.CreatedAt)
+}
+
+// Get returns one of an organization's subscriptions, without its secret. If the
+// organization has no such subscription an ErrRecordNotFound error is returned.
+func (m WebhookModel) Get(orgID, id int64) (*WebhookSubscription, error) {
+	query := `
+		SELECT id, organization_id, api_key_id, url, events, genres, regions, created_at
+		FROM webhook_subscriptions
+		WHERE organization_id = $1 AND id = $2`
+
+	ctx, cancel := context.WithTimeout(m.context(), 3*time.Second)
+	defer cancel()
+
+	var sub WebhookSubscription
+	err := m.DB.QueryRowContext(ctx, query, orgID, id).Scan(
+		&sub.ID, &sub.OrganizationID, &sub.APIKeyID, &sub.URL,
+		pq.Array(&sub.Events), pq.Array(&sub.Genres), pq.Array(&sub.Regions), &sub.CreatedAt,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &sub, nil
+}
+
+// GetAllForOrganization returns an organization's subscriptions, without their secrets.
+func (m WebhookModel) GetAllForOrganization(orgID int64) ([]*WebhookSubscription, error) {
+	query := `
+		SELECT id, organization_id, api_key_id, url, events, genres, regions, created_at
+		FROM webhook_subscriptions
+		WHERE organization_id = $1
+		ORDER BY id`
+
+	ctx, cancel := context.WithTimeout(m.context(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, orgID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanWebhookSubscriptions(rows)
+}
+
+// GetMatching returns the subscriptions to the event which match a movie with the
+// given genres in the region.
+func (m WebhookModel) GetMatching(event string, genres []string, region string) ([]*WebhookSubscription, error) {
+	query := `
+		SELECT id, organization_id, api_key_id, url, events, genres, regions, created_at
+		FROM webhook_subscriptions
+		WHERE $1 = ANY(events)
+		AND (cardinality(genres) = 0 OR genres && $2)
+		AND (cardinality(regions) = 0 OR $3 = ANY(regions))
+		ORDER BY id`
+
+	ctx, cancel := context.WithTimeout(m.context(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, event, pq.Array(genres), region)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanWebhookSubscriptions(rows)
+}
+
+func scanWebhookSubscriptions(rows *sql.Rows) ([]*WebhookSubscription, error) {
+	subs := []*WebhookSubscription{}
+	for rows.Next() {
+		var sub WebhookSubscription
+		err := rows.Scan(&sub.ID, &sub.OrganizationID, &sub.APIKeyID, &sub.URL,
+			pq.Array(&sub.Events), pq.Array(&sub.Genres), pq.Array(&sub.Regions), &sub.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		subs = append(subs, &sub)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return subs, nil
+}
+
+// Delete removes one of an organization's subscriptions, along with its deliveries,
+// returning ErrRecordNotFound if the organization has no such subscription.
+func (m WebhookModel) Delete(orgID, id int64) error {
+	query := `DELETE FROM webhook_subscriptions WHERE organization_id = $1 AND id = $2`
+
+	ctx, cancel := context.WithTimeout(m.context(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, orgID, id)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// A WebhookDelivery is an event waiting to be delivered to a subscription, or which has
+// been. The URL and Secret are those of the subscription, and are only set on claimed
+// deliveries.
+type WebhookDelivery struct {
+	ID             int64           `json:"id"`
+	SubscriptionID int64           `json:"subscription_id"`
+	Event          string          `json:"event"`
+	Payload        json.RawMessage `json:"payload"`
+	CreatedAt      time.Time       `json:"created_at"`
+	Status         string          `json:"status"`
+	Attempts       int             `json:"attempts"`
+	NextAttemptAt  time.Time       `json:"next_attempt_at"`
+	LastError      string          `json:"last_error,omitempty"`
+	DeliveredAt    *time.Time      `json:"delivered_at,omitempty"`
+	URL            string          `json:"-"`
+	Secret         string          `json:"-"`
+}
+
+// WebhookDeliveryModel wraps the connection pool for the webhook_deliveries table.
+type WebhookDeliveryModel struct {
+	queryScope
+	DB *sql.DB
+}
+
+// Insert queues a delivery to be made straight away.
+func (m WebhookDeliveryModel) Insert(d *WebhookDelivery) error {
+	query := `
+		INSERT INTO webhook_deliveries (subscription_id, event, payload)
+		VALUES ($1, $2, $3)
+		RETURNING id, created_at, status, next_attempt_at`
+
+	ctx, cancel := context.WithTimeout(m.context(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, d.SubscriptionID, d.Event, []byte(d.Payload)).
+		Scan(&d.ID, &d.CreatedAt, &d.Status, &d.NextAttemptAt)
+}
+
+// Claim takes up to limit deliveries which are due, counting an attempt for each of
+// them, in the same way as EmailQueueModel.Claim.
+func (m WebhookDeliveryModel) Claim(limit int, lease time.Duration) ([]*WebhookDelivery, error) {
+	query := `
+		UPDATE webhook_deliveries d
+		SET attempts = attempts + 1, next_attempt_at = NOW() + make_interval(secs => $2)
+		FROM webhook_subscriptions s
+		WHERE s.id = d.subscription_id AND d.id IN (
+			SELECT id FROM webhook_deliveries
+			WHERE status = 'pending' AND next_attempt_at <= NOW()
+			ORDER BY next_attempt_at
+			LIMIT $1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING d.id, d.subscription_id, d.event, d.payload, d.created_at, d.status, d.attempts,
+			d.next_attempt_at, d.last_error, s.url, s.secret`
+
+	ctx, cancel := context.WithTimeout(m.context(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, limit, lease.Seconds())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var deliveries []*WebhookDelivery
+	for rows.Next() {
+		var d WebhookDelivery
+		err := rows.Scan(&d.ID, &d.SubscriptionID, &d.Event, &d.Payload, &d.CreatedAt, &d.Status, &d.Attempts,
+			&d.NextAttemptAt, &d.LastError, &d.URL, &d.Secret)
+		if err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, &d)
+	}
+	return deliveries, rows.Err()
+}
+
+// GetRecent returns the most recent deliveries of one of an organization's
+// subscriptions, the newest first.
+func (m WebhookDeliveryModel) GetRecent(orgID, subscriptionID int64, limit int) ([]*WebhookDelivery, error) {
+	query := `
+		SELECT d.id, d.subscription_id, d.event, d.payload, d.created_at, d.status, d.attempts,
+			d.next_attempt_at, d.last_error, d.delivered_at
+		FROM webhook_deliveries d
+		INNER JOIN webhook_subscriptions s ON s.id = d.subscription_id
+		WHERE s.organization_id = $1 AND d.subscription_id = $2
+		ORDER BY d.id DESC
+		LIMIT $3`
+
+	ctx, cancel := context.WithTimeout(m.context(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, orgID, subscriptionID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deliveries := []*WebhookDelivery{}
+	for rows.Next() {
+		var d WebhookDelivery
+		err := rows.Scan(&d.ID, &d.SubscriptionID, &d.Event, &d.Payload, &d.CreatedAt, &d.Status, &d.Attempts,
+			&d.NextAttemptAt, &d.LastError, &d.DeliveredAt)
+		if err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, &d)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return deliveries, nil
+}
+
+// MarkDelivered records that a delivery was accepted by the subscriber.
+func (m WebhookDeliveryModel) MarkDelivered(id int64) error {
+	query := `UPDATE webhook_deliveries SET status = 'delivered', delivered_at = NOW(), last_error = '' WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(m.context(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, id)
+	return err
+}
+
+// Retry records a failed attempt to make a delivery, which is tried again at the given
+// time.
+func (m WebhookDeliveryModel) Retry(id int64, at time.Time, lastError string) error {
+	query := `UPDATE webhook_deliveries SET next_attempt_at = $2, last_error = $3 WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(m.context(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, id, at, lastError)
+	return err
+}
+
+// DeadLetter records that a delivery can't be made, and gives up on it.
+func (m WebhookDeliveryModel) DeadLetter(id int64, lastError string) error {
+	query := `UPDATE webhook_deliveries SET status = 'dead', last_error = $2 WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(m.context(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, id, lastError)
+	return err
+}
+
+// DeleteBefore deletes the deliveries made, or dead-lettered, before the given times.
+func (m WebhookDeliveryModel) DeleteBefore(delivered, dead time.Time) error {
+	query := `
+		DELETE FROM webhook_deliveries
+		WHERE (status = 'delivered' AND delivered_at < $1) OR (status = 'dead' AND created_at < $2)`
+
+	ctx, cancel := context.WithTimeout(m.context(), 10*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, delivered, dead)
+	return err
+}
@@ -0,0 +1,146 @@
+// Package dbstats provides a database/sql driver wrapper which counts the queries a
+// request executes, the rows they return and the time spent in the database. It's
+// meant for development, to catch accidental N+1 query patterns.
+package dbstats
+
+import (
+	"context"
+	"database/sql/driver"
+	"sync/atomic"
+	"time"
+)
+
+// Stats holds the statistics collected for a single request. It's safe for concurrent
+// use.
+type Stats struct {
+	queries atomic.Int64
+	rows    atomic.Int64
+	nanos   atomic.Int64
+}
+
+// Queries returns the number of queries and statements executed.
+func (s *Stats) Queries() int64 {
+	return s.queries.Load()
+}
+
+// Rows returns the number of rows scanned.
+func (s *Stats) Rows() int64 {
+	return s.rows.Load()
+}
+
+// Duration returns the total time spent waiting on the database.
+func (s *Stats) Duration() time.Duration {
+	return time.Duration(s.nanos.Load())
+}
+
+func (s *Stats) record(start time.Time, queries int64) {
+	if s == nil {
+		return
+	}
+	s.queries.Add(queries)
+	s.nanos.Add(int64(time.Since(start)))
+}
+
+type contextKey struct{}
+
+// NewContext returns a copy of ctx which carries the given statistics. Queries executed
+// with the returned context (or a context derived from it) are recorded in them.
+func NewContext(ctx context.Context, s *Stats) context.Context {
+	return context.WithValue(ctx, contextKey{}, s)
+}
+
+// FromContext returns the statistics carried by ctx, or nil if there are none.
+func FromContext(ctx context.Context) *Stats {
+	s, _ := ctx.Value(contextKey{}).(*Stats)
+	return s
+}
+
+// Wrap returns a driver which records statistics for every query executed through d,
+// using the Stats carried by the query's context. The wrapped driver must support the
+// context-aware driver interfaces, as lib/pq does.
+func Wrap(d driver.Driver) driver.Driver {
+	return &wrappedDriver{d}
+}
+
+type wrappedDriver struct {
+	driver.Driver
+}
+
+func (d *wrappedDriver) Open(name string) (driver.Conn, error) {
+	c, err := d.Driver.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return &conn{c}, nil
+}
+
+type conn struct {
+	driver.Conn
+}
+
+func (c *conn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	defer FromContext(ctx).record(time.Now(), 0)
+	s, err := c.Conn.(driver.ConnPrepareContext).PrepareContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	return &stmt{s}, nil
+}
+
+func (c *conn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	return c.Conn.(driver.ConnBeginTx).BeginTx(ctx, opts)
+}
+
+func (c *conn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	s := FromContext(ctx)
+	defer s.record(time.Now(), 1)
+	r, err := c.Conn.(driver.QueryerContext).QueryContext(ctx, query, args)
+	if err != nil {
+		return nil, err
+	}
+	return &rows{r, s}, nil
+}
+
+func (c *conn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	defer FromContext(ctx).record(time.Now(), 1)
+	return c.Conn.(driver.ExecerContext).ExecContext(ctx, query, args)
+}
+
+func (c *conn) Ping(ctx context.Context) error {
+	return c.Conn.(driver.Pinger).Ping(ctx)
+}
+
+type stmt struct {
+	driver.Stmt
+}
+
+func (s *stmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	st := FromContext(ctx)
+	defer st.record(time.Now(), 1)
+	r, err := s.Stmt.(driver.StmtQueryContext).QueryContext(ctx, args)
+	if err != nil {
+		return nil, err
+	}
+	return &rows{r, st}, nil
+}
+
+func (s *stmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	defer FromContext(ctx).record(time.Now(), 1)
+	return s.Stmt.(driver.StmtExecContext).ExecContext(ctx, args)
+}
+
+type rows struct {
+	driver.Rows
+	stats *Stats
+}
+
+// Next counts the rows as they're read. Rows are streamed from the server, so the time
+// spent here is database time too.
+func (r *rows) Next(dest []driver.Value) error {
+	defer r.stats.record(time.Now(), 0)
+	err := r.Rows.Next(dest)
+	if err == nil && r.stats != nil {
+		r.stats.rows.Add(1)
+	}
+	return err
+}
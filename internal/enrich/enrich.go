@@ -0,0 +1,29 @@
+// Package enrich looks movies up at an external metadata provider, for the facts the
+// catalog doesn't keep itself: ratings, popularity and box office takings. Like the
+// billing package, it has no SDK dependency; providers are called over plain HTTP.
+package enrich
+
+import "errors"
+
+// Provider names, for the -enrich-provider flag.
+const (
+	ProviderOMDb = "omdb"
+)
+
+// ErrNotFound is returned by Lookup when the provider doesn't know the movie.
+var ErrNotFound = errors.New("movie not found at the provider")
+
+// Facts are what a provider knows about a movie. Fields are nil if it doesn't know
+// them.
+type Facts struct {
+	Rating     *float64 // average rating, out of 10
+	Popularity *float64 // number of votes behind the rating
+	BoxOffice  *float64 // US box office takings, in dollars
+}
+
+// A Provider looks movies up by title and year.
+type Provider interface {
+	// Name returns the name recorded as the source of the facts from the provider.
+	Name() string
+	Lookup(title string, year int32) (*Facts, error)
+}
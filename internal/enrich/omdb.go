@@ -0,0 +1,86 @@
+package enrich
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// OMDb is a client of the Open Movie Database API, https://www.omdbapi.com. Its
+// ratings and votes are those of IMDb.
+type OMDb struct {
+	apiKey  string
+	client  *http.Client
+	baseURL string
+}
+
+func NewOMDb(apiKey string) *OMDb {
+	return &OMDb{
+		apiKey:  apiKey,
+		client:  &http.Client{Timeout: 10 * time.Second},
+		baseURL: "https://www.omdbapi.com/",
+	}
+}
+
+func (o *OMDb) Name() string {
+	return ProviderOMDb
+}
+
+// Lookup finds the movie with the exact title released in the year.
+func (o *OMDb) Lookup(title string, year int32) (*Facts, error) {
+	params := url.Values{}
+	params.Set("apikey", o.apiKey)
+	params.Set("t", title)
+	params.Set("y", strconv.Itoa(int(year)))
+	params.Set("type", "movie")
+
+	resp, err := o.client.Get(o.baseURL + "?" + params.Encode())
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	// OMDb answers 200 OK even when the movie isn't found, with Response set to
+	// "False"; fields it doesn't know are "N/A".
+	var body struct {
+		Response   string `json:"Response"`
+		Error      string `json:"Error"`
+		IMDbRating string `json:"imdbRating"`
+		IMDbVotes  string `json:"imdbVotes"`
+		BoxOffice  string `json:"BoxOffice"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&body)
+	if err != nil && resp.StatusCode == http.StatusOK {
+		return nil, fmt.Errorf("omdb: decoding response: %w", err)
+	}
+	switch {
+	case body.Response == "False" && strings.Contains(body.Error, "not found"):
+		return nil, ErrNotFound
+	case body.Response == "False":
+		return nil, fmt.Errorf("omdb: %s (status %d)", body.Error, resp.StatusCode)
+	case resp.StatusCode != http.StatusOK:
+		return nil, fmt.Errorf("omdb: unexpected status %d", resp.StatusCode)
+	}
+
+	return &Facts{
+		Rating:     parseNumber(body.IMDbRating, ""),
+		Popularity: parseNumber(body.IMDbVotes, ""),
+		BoxOffice:  parseNumber(body.BoxOffice, "$"),
+	}, nil
+}
+
+// parseNumber() parses numbers in the form OMDb sends them, such as "1,234,567" or
+// "$9,876", returning nil for "N/A" and anything else it can't parse.
+func parseNumber(s, prefix string) *float64 {
+	s = strings.ReplaceAll(strings.TrimPrefix(s, prefix), ",", "")
+	n, err := strconv.ParseFloat(s, 64)
+	if err != nil || n < 0 || math.IsNaN(n) || math.IsInf(n, 0) {
+		return nil
+	}
+	return &n
+}
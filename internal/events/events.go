@@ -0,0 +1,75 @@
+package events
+
+import (
+	"sync"
+	"time"
+)
+
+// Define constants for the types of domain events the application publishes.
+const (
+	MovieCreated = "movie.created"
+	// UserRegistered and UserActivated are published with the *data.User, when someone
+	// signs up and when they activate their account.
+	UserRegistered = "user.registered"
+	UserActivated  = "user.activated"
+	// ReviewPosted is published with the *data.Review when a user reviews a movie.
+	ReviewPosted = "review.posted"
+	// CreditAdded is published with the *data.Credit when a person is credited on a
+	// movie.
+	CreditAdded = "credit.added"
+	// PermissionsChanged is published with the ID of a user, as an int64, when
+	// permissions are granted to or revoked from them.
+	PermissionsChanged = "permissions.changed"
+	// AvailabilityChanged is published when a movie's availability in a region is set
+	// or removed.
+	AvailabilityChanged = "availability.changed"
+	// NotificationCreated is published with the *data.Notification when a notification
+	// is added to a user's in-app inbox.
+	NotificationCreated = "notification.created"
+)
+
+// An Event records something which happened in the domain, such as a movie being added
+// to the catalog. The Payload holds the data for the event, usually a pointer to the
+// affected record.
+type Event struct {
+	Type    string
+	Time    time.Time
+	Payload any
+}
+
+// A Handler is a function which reacts to an event.
+type Handler func(Event)
+
+// Bus is a simple in-process publish/subscribe event bus. It's safe for concurrent
+// use.
+type Bus struct {
+	mu       sync.RWMutex
+	handlers map[string][]Handler
+}
+
+// New returns an empty Bus.
+func New() *Bus {
+	return &Bus{handlers: make(map[string][]Handler)}
+}
+
+// Subscribe registers a handler to be called for every event of the given type.
+func (b *Bus) Subscribe(eventType string, h Handler) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.handlers[eventType] = append(b.handlers[eventType], h)
+}
+
+// Publish calls every handler subscribed to the event's type, in the order they were
+// registered. Handlers are called synchronously, so anything slow should hand the work
+// off to another goroutine.
+func (b *Bus) Publish(e Event) {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	b.mu.RLock()
+	handlers := b.handlers[e.Type]
+	b.mu.RUnlock()
+	for _, h := range handlers {
+		h(e)
+	}
+}
@@ -0,0 +1,141 @@
+package events
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrInvalidCursor is returned by Hub.Read for a cursor it didn't hand out.
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// A Delivery is an event as it's delivered to clients. UserID is the only user it's
+// delivered to, or 0 if it's delivered to everyone.
+type Delivery struct {
+	Seq    int64     `json:"-"`
+	UserID int64     `json:"-"`
+	Type   string    `json:"type"`
+	Time   time.Time `json:"time"`
+	Data   any       `json:"data"`
+}
+
+// Hub buffers the most recent events to be delivered to clients, and lets them read the
+// events after a cursor, waiting for new ones if need be. Transports such as long
+// polling are built on it. It only holds the events published by this process, and
+// forgets them on restart; cursors from an earlier process are detected, so clients
+// know they may have missed events. It's safe for concurrent use.
+type Hub struct {
+	mu      sync.Mutex
+	epoch   string // distinguishes the cursors of this process from earlier ones
+	seq     int64  // sequence number of the last event added
+	buf     []Delivery
+	size    int
+	changed chan struct{} // closed, and replaced, whenever an event is added
+	closed  bool
+}
+
+// NewHub returns a hub which keeps the last size events.
+func NewHub(size int) *Hub {
+	return &Hub{
+		epoch:   strconv.FormatInt(time.Now().UnixNano(), 36),
+		size:    size,
+		changed: make(chan struct{}),
+	}
+}
+
+// Add adds an event for the given user, or for everyone if userID is 0, and wakes up
+// the readers waiting for new events.
+func (h *Hub) Add(userID int64, eventType string, t time.Time, data any) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.seq++
+	h.buf = append(h.buf, Delivery{Seq: h.seq, UserID: userID, Type: eventType, Time: t, Data: data})
+	if len(h.buf) > h.size {
+		h.buf = h.buf[len(h.buf)-h.size:]
+	}
+	if !h.closed {
+		close(h.changed)
+		h.changed = make(chan struct{})
+	}
+}
+
+// Cursor returns the cursor of the latest event, to read the events after it.
+func (h *Hub) Cursor() string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.cursor(h.seq)
+}
+
+func (h *Hub) cursor(seq int64) string {
+	return fmt.Sprintf("%s.%d", h.epoch, seq)
+}
+
+// Read returns up to limit of the events after the cursor which are for the user, and
+// the cursor to read the next ones from. An empty cursor reads from the latest event,
+// so it returns nothing. If events after the cursor may have been dropped, because they
+// fell out of the buffer or the cursor is from an earlier process, missed is true and
+// the events are read from the oldest one still held.
+func (h *Hub) Read(cursor string, userID int64, limit int) (deliveries []Delivery, next string, missed bool, err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	after := h.seq
+	if cursor != "" {
+		epoch, s, ok := strings.Cut(cursor, ".")
+		seq, err := strconv.ParseInt(s, 10, 64)
+		if !ok || err != nil || seq < 0 {
+			return nil, "", false, ErrInvalidCursor
+		}
+		switch {
+		case epoch != h.epoch:
+			after, missed = 0, true
+		case seq > h.seq:
+			return nil, "", false, ErrInvalidCursor
+		default:
+			after = seq
+		}
+	}
+	if len(h.buf) > 0 && after < h.buf[0].Seq-1 {
+		missed = true
+	}
+
+	deliveries = []Delivery{}
+	next = h.cursor(after)
+	for _, d := range h.buf {
+		if d.Seq <= after {
+			continue
+		}
+		if len(deliveries) == limit {
+			break
+		}
+		if d.UserID == 0 || d.UserID == userID {
+			deliveries = append(deliveries, d)
+		}
+		// Events for other users are skipped over, so they aren't looked at again.
+		next = h.cursor(d.Seq)
+	}
+	return deliveries, next, missed, nil
+}
+
+// Changed returns a channel which is closed when the next event is added, or the hub
+// is closed. Readers take it before calling Read(), so that an event added in between
+// isn't missed.
+func (h *Hub) Changed() <-chan struct{} {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.changed
+}
+
+// Close wakes up every waiting reader, and leaves the channel of Changed() closed, so
+// that the requests waiting for events end when the server shuts down.
+func (h *Hub) Close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if !h.closed {
+		h.closed = true
+		close(h.changed)
+	}
+}
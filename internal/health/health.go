@@ -0,0 +1,174 @@
+package health
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Define constants for the status of a single check and of a whole probe.
+const (
+	StatusUp   = "up"
+	StatusDown = "down"
+)
+
+// A Check is the outcome of probing a single dependency, such as the database.
+type Check struct {
+	Status    string  `json:"status"`
+	LatencyMS float64 `json:"latency_ms"`
+	Error     string  `json:"error,omitempty"`
+}
+
+// A Result is the outcome of one probe run across every dependency. The overall status
+// is down if any of the checks is down.
+type Result struct {
+	Time   time.Time        `json:"time"`
+	Status string           `json:"status"`
+	Checks map[string]Check `json:"checks"`
+}
+
+// A Probe checks that a dependency is reachable, returning an error if it isn't.
+type Probe func(ctx context.Context) error
+
+// Run calls every probe concurrently, giving each one the given timeout, and collects
+// the results.
+func Run(probes map[string]Probe, timeout time.Duration) Result {
+	res := Result{
+		Time:   time.Now().UTC(),
+		Status: StatusUp,
+		Checks: make(map[string]Check, len(probes)),
+	}
+
+	var (
+		mu sync.Mutex
+		wg sync.WaitGroup
+	)
+	for name, probe := range probes {
+		name, probe := name, probe
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), timeout)
+			defer cancel()
+
+			start := time.Now()
+			err := probe(ctx)
+			check := Check{
+				Status:    StatusUp,
+				LatencyMS: float64(time.Since(start).Microseconds()) / 1000,
+			}
+			if err != nil {
+				check.Status = StatusDown
+				check.Error = err.Error()
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			res.Checks[name] = check
+			if check.Status == StatusDown {
+				res.Status = StatusDown
+			}
+		}()
+	}
+	wg.Wait()
+	return res
+}
+
+// History is a fixed-size ring buffer of the most recent probe results. It's safe for
+// concurrent use.
+type History struct {
+	mu      sync.Mutex
+	entries []Result
+	next    int
+	full    bool
+}
+
+// NewHistory returns a History which keeps the last size results.
+func NewHistory(size int) *History {
+	if size < 1 {
+		size = 1
+	}
+	return &History{entries: make([]Result, size)}
+}
+
+// Add records a result, overwriting the oldest one when the buffer is full.
+func (h *History) Add(res Result) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.entries[h.next] = res
+	h.next = (h.next + 1) % len(h.entries)
+	if h.next == 0 {
+		h.full = true
+	}
+}
+
+// All returns the recorded results, oldest first.
+func (h *History) All() []Result {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if !h.full {
+		return append([]Result{}, h.entries[:h.next]...)
+	}
+	return append(append([]Result{}, h.entries[h.next:]...), h.entries[:h.next]...)
+}
+
+// Since returns the results recorded after the given time, oldest first.
+func (h *History) Since(t time.Time) []Result {
+	results := h.All()
+	i := sort.Search(len(results), func(i int) bool { return results[i].Time.After(t) })
+	return results[i:]
+}
+
+// Latest returns the most recent result, and false if nothing has been recorded yet.
+func (h *History) Latest() (Result, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if !h.full && h.next == 0 {
+		return Result{}, false
+	}
+	i := (h.next - 1 + len(h.entries)) % len(h.entries)
+	return h.entries[i], true
+}
+
+// A Tally counts the probe results of one dependency over a period, for working out
+// its uptime.
+type Tally struct {
+	Up    int
+	Total int
+}
+
+// Uptime returns the percentage of probes in which the dependency was up, and false if
+// there were none.
+func (t Tally) Uptime() (float64, bool) {
+	if t.Total == 0 {
+		return 0, false
+	}
+	return 100 * float64(t.Up) / float64(t.Total), true
+}
+
+// TallySince counts the results recorded after the given time, per dependency. It also
+// returns the number of those results, and the time of the first one.
+func TallySince(results []Result, since time.Time) (map[string]Tally, int, time.Time) {
+	tallies := make(map[string]Tally)
+	var probes int
+	var first time.Time
+	for _, res := range results {
+		if !res.Time.After(since) {
+			continue
+		}
+		if probes == 0 {
+			first = res.Time
+		}
+		probes++
+		for name, check := range res.Checks {
+			t := tallies[name]
+			t.Total++
+			if check.Status == StatusUp {
+				t.Up++
+			}
+			tallies[name] = t
+		}
+	}
+	return tallies, probes, first
+}
@@ -0,0 +1,99 @@
+package imagepreview
+
+import (
+	"math"
+	"strings"
+)
+
+// base83 holds the digits of the base 83 encoding BlurHash uses.
+const base83 = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz#$%*+,-.:;=?@[]^_{|}~"
+
+// The encodeBlurhash() function encodes the pixels as a BlurHash with nx components
+// across and ny down, each 1 to 9, following the reference implementation at
+// github.com/woltapp/blurhash: the hash holds the size, the average colour, and the
+// quantised weights of the cosine components of the image in linear RGB.
+func encodeBlurhash(g grid, nx, ny int) string {
+	factors := make([][3]float64, 0, nx*ny)
+	for j := 0; j < ny; j++ {
+		for i := 0; i < nx; i++ {
+			factors = append(factors, blurhashFactor(g, i, j))
+		}
+	}
+	dc, ac := factors[0], factors[1:]
+
+	var sb strings.Builder
+	sb.WriteString(encode83((nx-1)+(ny-1)*9, 1))
+
+	maximum := 1.0
+	if len(ac) > 0 {
+		actual := 0.0
+		for _, f := range ac {
+			actual = math.Max(actual, math.Max(math.Abs(f[0]), math.Max(math.Abs(f[1]), math.Abs(f[2]))))
+		}
+		quantised := int(math.Max(0, math.Min(82, math.Floor(actual*166-0.5))))
+		maximum = float64(quantised+1) / 166
+		sb.WriteString(encode83(quantised, 1))
+	} else {
+		sb.WriteString(encode83(0, 1))
+	}
+
+	sb.WriteString(encode83(linearToSRGB(dc[0])<<16|linearToSRGB(dc[1])<<8|linearToSRGB(dc[2]), 4))
+	for _, f := range ac {
+		quant := func(v float64) int {
+			return int(math.Max(0, math.Min(18, math.Floor(signPow(v/maximum, 0.5)*9+9.5))))
+		}
+		sb.WriteString(encode83(quant(f[0])*19*19+quant(f[1])*19+quant(f[2]), 2))
+	}
+	return sb.String()
+}
+
+// The blurhashFactor() function returns the weight of the cosine component (i, j) of
+// the pixels, for each channel.
+func blurhashFactor(g grid, i, j int) [3]float64 {
+	var f [3]float64
+	for y := 0; y < g.h; y++ {
+		for x := 0; x < g.w; x++ {
+			basis := math.Cos(math.Pi*float64(i*x)/float64(g.w)) * math.Cos(math.Pi*float64(j*y)/float64(g.h))
+			p := g.at(x, y)
+			f[0] += basis * sRGBToLinear(p[0])
+			f[1] += basis * sRGBToLinear(p[1])
+			f[2] += basis * sRGBToLinear(p[2])
+		}
+	}
+	normalisation := 2.0
+	if i == 0 && j == 0 {
+		normalisation = 1
+	}
+	scale := normalisation / float64(g.w*g.h)
+	return [3]float64{f[0] * scale, f[1] * scale, f[2] * scale}
+}
+
+func sRGBToLinear(c uint8) float64 {
+	v := float64(c) / 255
+	if v <= 0.04045 {
+		return v / 12.92
+	}
+	return math.Pow((v+0.055)/1.055, 2.4)
+}
+
+func linearToSRGB(v float64) int {
+	v = math.Max(0, math.Min(1, v))
+	if v <= 0.0031308 {
+		return int(v*12.92*255 + 0.5)
+	}
+	return int((1.055*math.Pow(v, 1/2.4)-0.055)*255 + 0.5)
+}
+
+func signPow(v, exp float64) float64 {
+	return math.Copysign(math.Pow(math.Abs(v), exp), v)
+}
+
+// The encode83() function encodes n as length digits of base 83.
+func encode83(n, length int) string {
+	b := make([]byte, length)
+	for i := length - 1; i >= 0; i-- {
+		b[i] = base83[n%83]
+		n /= 83
+	}
+	return string(b)
+}
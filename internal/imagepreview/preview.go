@@ -0,0 +1,153 @@
+// Package imagepreview works out what a client needs to show an image before it has
+// loaded: its dominant colour, a small palette, and a BlurHash (see blurha.sh), a short
+// string which decodes to a blurred version of the image.
+package imagepreview
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/jpeg" // register the JPEG and PNG decoders with image.Decode()
+	_ "image/png"
+	"sort"
+)
+
+// ErrTooLarge is returned for an image with more pixels than MaxPixels, which isn't
+// decoded at all, so that a small file can't make the API allocate gigabytes.
+var ErrTooLarge = errors.New("imagepreview: image has too many pixels")
+
+// MaxPixels is the largest image, in pixels, a preview is made of.
+const MaxPixels = 50_000_000
+
+// paletteSize is the most colours in a palette.
+const paletteSize = 5
+
+// sampleSize is the width and height of the grid of pixels the preview is made from.
+// Every pixel of a poster isn't needed for a blur or a handful of colours.
+const sampleSize = 64
+
+// The number of BlurHash components across and down the image. A poster is taller
+// than it's wide.
+const (
+	blurhashX = 3
+	blurhashY = 4
+)
+
+// A Preview holds the placeholders of an image. The colours are CSS hex colours such
+// as "#1a2b3c", and the palette is ordered by how much of the image has each colour,
+// starting with the dominant one.
+type Preview struct {
+	DominantColor string
+	Palette       []string
+	Blurhash      string
+}
+
+// Extract decodes an image, and works out its preview. Images in a format without a
+// decoder, such as WebP, return image.ErrFormat.
+func Extract(content []byte) (*Preview, error) {
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(content))
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Width*cfg.Height > MaxPixels {
+		return nil, ErrTooLarge
+	}
+	img, _, err := image.Decode(bytes.NewReader(content))
+	if err != nil {
+		return nil, err
+	}
+
+	pixels := sample(img)
+	palette := extractPalette(pixels)
+	return &Preview{
+		DominantColor: palette[0],
+		Palette:       palette,
+		Blurhash:      encodeBlurhash(pixels, blurhashX, blurhashY),
+	}, nil
+}
+
+// A grid holds the pixels of an image sampled at evenly spaced points, as 8-bit sRGB.
+type grid struct {
+	w, h int
+	rgb  [][3]uint8
+}
+
+func (g grid) at(x, y int) [3]uint8 {
+	return g.rgb[y*g.w+x]
+}
+
+// The sample() function samples an image on a grid at most sampleSize pixels wide and
+// high, keeping its aspect ratio.
+func sample(img image.Image) grid {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	g := grid{w: w, h: h}
+	if w > sampleSize || h > sampleSize {
+		if w >= h {
+			g.w, g.h = sampleSize, h*sampleSize/w
+		} else {
+			g.w, g.h = w*sampleSize/h, sampleSize
+		}
+		if g.w < 1 {
+			g.w = 1
+		}
+		if g.h < 1 {
+			g.h = 1
+		}
+	}
+
+	g.rgb = make([][3]uint8, 0, g.w*g.h)
+	for y := 0; y < g.h; y++ {
+		for x := 0; x < g.w; x++ {
+			r, gr, bl, _ := img.At(b.Min.X+x*w/g.w, b.Min.Y+y*h/g.h).RGBA()
+			g.rgb = append(g.rgb, [3]uint8{uint8(r >> 8), uint8(gr >> 8), uint8(bl >> 8)})
+		}
+	}
+	return g
+}
+
+// The extractPalette() function returns the most common colours of the pixels, most
+// common first. Pixels are counted in buckets of similar colours, 16 levels a channel,
+// and each colour of the palette is the average of its bucket.
+func extractPalette(g grid) []string {
+	type bucket struct {
+		count   int
+		r, g, b int
+	}
+	buckets := make(map[int]*bucket)
+	for _, p := range g.rgb {
+		key := int(p[0]>>4)<<8 | int(p[1]>>4)<<4 | int(p[2]>>4)
+		bk := buckets[key]
+		if bk == nil {
+			bk = &bucket{}
+			buckets[key] = bk
+		}
+		bk.count++
+		bk.r += int(p[0])
+		bk.g += int(p[1])
+		bk.b += int(p[2])
+	}
+
+	keys := make([]int, 0, len(buckets))
+	for key := range buckets {
+		keys = append(keys, key)
+	}
+	// Ties are broken by the bucket's key, so the same image always has the same palette.
+	sort.Slice(keys, func(i, j int) bool {
+		if buckets[keys[i]].count != buckets[keys[j]].count {
+			return buckets[keys[i]].count > buckets[keys[j]].count
+		}
+		return keys[i] < keys[j]
+	})
+	if len(keys) > paletteSize {
+		keys = keys[:paletteSize]
+	}
+
+	palette := make([]string, len(keys))
+	for i, key := range keys {
+		bk := buckets[key]
+		palette[i] = fmt.Sprintf("#%02x%02x%02x", bk.r/bk.count, bk.g/bk.count, bk.b/bk.count)
+	}
+	return palette
+}
@@ -0,0 +1,65 @@
+package imagepreview
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+)
+
+func TestExtract(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 200, 300))
+	for y := 0; y < 300; y++ {
+		for x := 0; x < 200; x++ {
+			c := color.RGBA{R: 0x20, G: 0x40, B: 0x80, A: 0xff}
+			if y >= 200 {
+				c = color.RGBA{R: 0xf0, G: 0xe0, B: 0x10, A: 0xff}
+			}
+			img.Set(x, y, c)
+		}
+	}
+	var buf bytes.Buffer
+	err := png.Encode(&buf, img)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	preview, err := Extract(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if preview.DominantColor != "#204080" {
+		t.Errorf("dominant color = %q, want %q", preview.DominantColor, "#204080")
+	}
+	if len(preview.Palette) != 2 || preview.Palette[1] != "#f0e010" {
+		t.Errorf("palette = %q, want [#204080 #f0e010]", preview.Palette)
+	}
+	// 1 character for the size, 1 for the maximum, 4 for the average and 2 for each of
+	// the other 11 components.
+	if len(preview.Blurhash) != 28 {
+		t.Errorf("blurhash %q is %d characters long, want 28", preview.Blurhash, len(preview.Blurhash))
+	}
+	if preview.Blurhash[0] != base83[(blurhashX-1)+(blurhashY-1)*9] {
+		t.Errorf("blurhash %q doesn't start with its size", preview.Blurhash)
+	}
+}
+
+// A solid image has no detail, so its hash is its colour and nothing else.
+func TestEncodeBlurhashSolid(t *testing.T) {
+	g := grid{w: 4, h: 4, rgb: make([][3]uint8, 16)}
+	for i := range g.rgb {
+		g.rgb[i] = [3]uint8{0xff, 0x00, 0x00}
+	}
+	got := encodeBlurhash(g, 1, 1)
+	if want := "00" + encode83(0xff0000, 4); got != want {
+		t.Errorf("blurhash = %q, want %q", got, want)
+	}
+}
+
+func TestExtractUnknownFormat(t *testing.T) {
+	_, err := Extract([]byte("RIFF\x00\x00\x00\x00WEBPVP8 "))
+	if err != image.ErrFormat {
+		t.Errorf("err = %v, want image.ErrFormat", err)
+	}
+}
@@ -2,9 +2,11 @@ package jsonlog
 
 import (
 	"encoding/json"
+	"fmt"
 	"io"
 	"os"
 	"runtime/debug"
+	"strings"
 	"sync"
 	"time"
 )
@@ -15,15 +17,18 @@ type Level int8
 // Initialize constants which represent a specific severity level. We use the iota
 // keyword as a shortcut to assign successive integer values to the constants.
 const (
-	LevelInfo  Level = iota // Has the value 0.
-	LevelError              // Has the value 1.
-	LevelFatal              // Has the value 2.
-	LevelOff                // Has the value 3.
+	LevelDebug Level = iota // Has the value 0.
+	LevelInfo               // Has the value 1.
+	LevelError              // Has the value 2.
+	LevelFatal              // Has the value 3.
+	LevelOff                // Has the value 4.
 )
 
 // Return a human-friendly string for the severity level.
 func (l Level) String() string {
 	switch l {
+	case LevelDebug:
+		return "DEBUG"
 	case LevelInfo:
 		return "INFO"
 	case LevelError:
@@ -35,13 +40,33 @@ func (l Level) String() string {
 	}
 }
 
+// ParseLevel returns the level with the given name, such as "info", in any case. Only
+// the levels entries can be limited to are accepted: debug, info, error and off.
+func ParseLevel(name string) (Level, error) {
+	switch strings.ToLower(name) {
+	case "debug":
+		return LevelDebug, nil
+	case "info":
+		return LevelInfo, nil
+	case "error":
+		return LevelError, nil
+	case "off":
+		return LevelOff, nil
+	default:
+		return 0, fmt.Errorf("unknown log level %q", name)
+	}
+}
+
 // Define a custom Logger type. This holds the output destination that the log entries
 // will be written to, the minimum severity level that log entries will be written for,
-// plus a mutex for coordinating the writes.
+// plus a mutex for coordinating the writes. A logger returned by With() shares the
+// mutex of its parent, since they write to the same destination, and adds its fields
+// to every entry.
 type Logger struct {
 	out      io.Writer
 	minLevel Level
-	mu       sync.Mutex
+	mu       *sync.Mutex
+	fields   map[string]string
 }
 
 // Return a new Logger instance which writes log entries at or above a minimum severity
@@ -50,12 +75,30 @@ func New(out io.Writer, minLevel Level) *Logger {
 	return &Logger{
 		out:      out,
 		minLevel: minLevel,
+		mu:       new(sync.Mutex),
+	}
+}
+
+// With returns a logger which writes to the same destination as l, adding the fields
+// to the properties of every entry, such as the ID of the request the entries are
+// logged for. The properties of an entry take precedence over the fields.
+func (l *Logger) With(fields map[string]string) *Logger {
+	merged := make(map[string]string, len(l.fields)+len(fields))
+	for key, value := range l.fields {
+		merged[key] = value
+	}
+	for key, value := range fields {
+		merged[key] = value
 	}
+	return &Logger{out: l.out, minLevel: l.minLevel, mu: l.mu, fields: merged}
 }
 
 // Declare some helper methods for writing log entries at the different levels. Notice
 // that these all accept a map as the second parameter which can contain any arbitrary
 // 'properties' that you want to appear in the log entry.
+func (l *Logger) PrintDebug(message string, properties map[string]string) {
+	l.print(LevelDebug, message, properties)
+}
 func (l *Logger) PrintInfo(message string, properties map[string]string) {
 	l.print(LevelInfo, message, properties)
 }
@@ -74,6 +117,16 @@ func (l *Logger) print(level Level, message string, properties map[string]string
 	if level < l.minLevel {
 		return 0, nil
 	}
+	if len(l.fields) > 0 {
+		merged := make(map[string]string, len(l.fields)+len(properties))
+		for key, value := range l.fields {
+			merged[key] = value
+		}
+		for key, value := range properties {
+			merged[key] = value
+		}
+		properties = merged
+	}
 	// Declare an anonymous struct holding the data for the log entry.
 	aux := struct {
 		Level      string            `json:"level"`
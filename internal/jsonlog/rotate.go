@@ -0,0 +1,142 @@
+package jsonlog
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// backupTimeFormat is the format of the time added to the names of rotated files. It
+// sorts in time order.
+const backupTimeFormat = "20060102T150405.000Z"
+
+// RotatingFile is a log file which is rotated when it grows past a size, or gets older
+// than an age: it's renamed with the time of the rotation added to its name, as in
+// api.log.20060102T150405.000Z, and a new file is started. Only the latest rotated files
+// are kept. It's safe for concurrent use.
+type RotatingFile struct {
+	path       string
+	maxSize    int64         // 0 for no limit
+	maxAge     time.Duration // 0 for no limit
+	maxBackups int           // 0 to keep every rotated file
+
+	mu      sync.Mutex
+	file    *os.File
+	size    int64
+	created time.Time
+}
+
+// OpenRotatingFile opens the log file at path for appending, creating it if need be.
+// It's rotated once writing to it would take it past maxSize bytes, or when it's older
+// than maxAge, and the maxBackups most recent rotated files are kept.
+func OpenRotatingFile(path string, maxSize int64, maxAge time.Duration, maxBackups int) (*RotatingFile, error) {
+	f := &RotatingFile{path: path, maxSize: maxSize, maxAge: maxAge, maxBackups: maxBackups}
+	err := f.open()
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *RotatingFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	f.file = file
+	f.size = info.Size()
+	// The file's age is counted from when this process opened it, since the time a
+	// file was created isn't portably available.
+	f.created = time.Now()
+	return nil
+}
+
+// Write writes a log entry to the file, rotating it first if it's due.
+func (f *RotatingFile) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.file == nil {
+		return 0, os.ErrClosed
+	}
+	tooBig := f.maxSize > 0 && f.size > 0 && f.size+int64(len(p)) > f.maxSize
+	tooOld := f.maxAge > 0 && time.Since(f.created) >= f.maxAge
+	if tooBig || tooOld {
+		err := f.rotate()
+		if err != nil {
+			// Rather than losing the entry, keep writing to the current file.
+			fmt.Fprintf(os.Stderr, "jsonlog: rotating %s: %v\n", f.path, err)
+		}
+	}
+
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+// rotate renames the current file and starts a new one. The mutex must be held. If the
+// file can't be renamed, writing carries on to it, even though it's due.
+func (f *RotatingFile) rotate() error {
+	rotated := f.path + "." + time.Now().UTC().Format(backupTimeFormat)
+	f.file.Close()
+	renameErr := os.Rename(f.path, rotated)
+	err := f.open()
+	if err != nil {
+		return err
+	}
+	if renameErr != nil {
+		return renameErr
+	}
+	return f.removeOldBackups()
+}
+
+// removeOldBackups deletes all but the maxBackups most recent rotated files.
+func (f *RotatingFile) removeOldBackups() error {
+	if f.maxBackups <= 0 {
+		return nil
+	}
+	matches, err := filepath.Glob(f.path + ".*")
+	if err != nil {
+		return err
+	}
+	var backups []string
+	for _, m := range matches {
+		// Only the files named by rotate(), not others which share the prefix.
+		if _, err := time.Parse(backupTimeFormat, strings.TrimPrefix(m, f.path+".")); err == nil {
+			backups = append(backups, m)
+		}
+	}
+	if len(backups) <= f.maxBackups {
+		return nil
+	}
+	// The timestamps sort in the order the files were rotated.
+	sort.Strings(backups)
+	for _, old := range backups[:len(backups)-f.maxBackups] {
+		err = os.Remove(old)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close closes the file. Entries written after it are dropped with os.ErrClosed.
+func (f *RotatingFile) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.file == nil {
+		return nil
+	}
+	err := f.file.Close()
+	f.file = nil
+	return err
+}
@@ -0,0 +1,191 @@
+package jwt
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Define constants for the supported signing algorithms.
+const (
+	HS256 = "HS256" // HMAC with SHA-256, using a shared secret
+	RS256 = "RS256" // RSA PKCS #1 v1.5 with SHA-256, using a key pair
+)
+
+var (
+	ErrInvalidToken = errors.New("invalid token")
+	ErrExpiredToken = errors.New("token has expired")
+)
+
+// Claims are the registered claims of the tokens, as Unix times where they're times,
+// together with the user's name and email address.
+type Claims struct {
+	Issuer    string `json:"iss"`
+	Subject   string `json:"sub"`
+	IssuedAt  int64  `json:"iat"`
+	NotBefore int64  `json:"nbf"`
+	Expiry    int64  `json:"exp"`
+	ID        string `json:"jti"`
+	Name      string `json:"name,omitempty"`
+	Email     string `json:"email,omitempty"`
+}
+
+type header struct {
+	Algorithm string `json:"alg"`
+	Type      string `json:"typ"`
+}
+
+// A Signer signs and verifies the tokens of one issuer with one algorithm. Tokens
+// signed with any other algorithm are rejected, including "none", so a token can't
+// choose how it's checked.
+type Signer struct {
+	alg     string
+	issuer  string
+	secret  []byte
+	private *rsa.PrivateKey
+}
+
+// NewHS256 returns a Signer for HS256 tokens. The secret must be at least 32 bytes
+// long, the size of the hash.
+func NewHS256(issuer string, secret []byte) (*Signer, error) {
+	if len(secret) < sha256.Size {
+		return nil, fmt.Errorf("HS256 secret must be at least %d bytes long", sha256.Size)
+	}
+	return &Signer{alg: HS256, issuer: issuer, secret: secret}, nil
+}
+
+// NewRS256 returns a Signer for RS256 tokens, from a PEM encoded RSA private key in
+// either PKCS #1 or PKCS #8 form. Other services only need the public half of the key
+// to verify the tokens.
+func NewRS256(issuer string, keyPEM []byte) (*Signer, error) {
+	block, _ := pem.Decode(keyPEM)
+	if block == nil {
+		return nil, errors.New("RS256 key must be PEM encoded")
+	}
+	key, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+	if err != nil {
+		parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		var ok bool
+		key, ok = parsed.(*rsa.PrivateKey)
+		if !ok {
+			return nil, errors.New("RS256 key must be an RSA private key")
+		}
+	}
+	if key.N.BitLen() < 2048 {
+		return nil, errors.New("RS256 key must be at least 2048 bits long")
+	}
+	return &Signer{alg: RS256, issuer: issuer, private: key}, nil
+}
+
+// Sign returns a token with the given claims, valid for ttl from now. The issuer and the
+// times of the claims are filled in by Sign, and a random token ID is generated.
+func (s *Signer) Sign(claims *Claims, ttl time.Duration) (string, error) {
+	id := make([]byte, 16)
+	_, err := rand.Read(id)
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
+	claims.Issuer = s.issuer
+	claims.IssuedAt = now.Unix()
+	claims.NotBefore = now.Unix()
+	claims.Expiry = now.Add(ttl).Unix()
+	claims.ID = base64.RawURLEncoding.EncodeToString(id)
+
+	h, err := json.Marshal(header{Algorithm: s.alg, Type: "JWT"})
+	if err != nil {
+		return "", err
+	}
+	c, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	signingInput := base64.RawURLEncoding.EncodeToString(h) + "." + base64.RawURLEncoding.EncodeToString(c)
+
+	signature, err := s.sign([]byte(signingInput))
+	if err != nil {
+		return "", err
+	}
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+// Verify checks the token's signature, issuer and validity period, and returns its
+// claims. ErrExpiredToken is returned for tokens which are otherwise valid but have
+// expired, and ErrInvalidToken for any other problem.
+func (s *Signer) Verify(token string) (*Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrInvalidToken
+	}
+
+	var h header
+	err := decodeSegment(parts[0], &h)
+	if err != nil || h.Algorithm != s.alg {
+		return nil, ErrInvalidToken
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	if !s.verify([]byte(parts[0]+"."+parts[1]), signature) {
+		return nil, ErrInvalidToken
+	}
+
+	var claims Claims
+	err = decodeSegment(parts[1], &claims)
+	if err != nil || claims.Issuer != s.issuer || claims.Subject == "" {
+		return nil, ErrInvalidToken
+	}
+	now := time.Now().Unix()
+	if now < claims.NotBefore {
+		return nil, ErrInvalidToken
+	}
+	if now >= claims.Expiry {
+		return nil, ErrExpiredToken
+	}
+	return &claims, nil
+}
+
+func (s *Signer) sign(signingInput []byte) ([]byte, error) {
+	switch s.alg {
+	case HS256:
+		mac := hmac.New(sha256.New, s.secret)
+		mac.Write(signingInput)
+		return mac.Sum(nil), nil
+	default:
+		digest := sha256.Sum256(signingInput)
+		return rsa.SignPKCS1v15(rand.Reader, s.private, crypto.SHA256, digest[:])
+	}
+}
+
+func (s *Signer) verify(signingInput, signature []byte) bool {
+	switch s.alg {
+	case HS256:
+		expected, _ := s.sign(signingInput)
+		return hmac.Equal(signature, expected)
+	default:
+		digest := sha256.Sum256(signingInput)
+		return rsa.VerifyPKCS1v15(&s.private.PublicKey, crypto.SHA256, digest[:], signature) == nil
+	}
+}
+
+func decodeSegment(segment string, dst any) error {
+	b, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, dst)
+}
@@ -0,0 +1,181 @@
+// Package leader elects a single active worker among the replicas of the application,
+// for work which must not run on more than one of them at a time. It's built on
+// PostgreSQL session-level advisory locks: the replica whose connection holds a lock is
+// its leader, and if that connection is lost the database releases the lock, so
+// another replica can take over.
+package leader
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"hash/fnv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// A Lock is a named advisory lock which the replica tries to acquire, and then keeps
+// checking it still holds, every interval. While the lock is held one connection of the
+// pool is set aside for it.
+type Lock struct {
+	db       *sql.DB
+	name     string
+	key      int64
+	interval time.Duration
+
+	held         atomic.Bool
+	acquisitions atomic.Int64
+	losses       atomic.Int64
+
+	mu        sync.Mutex // guards the fields below
+	conn      *sql.Conn  // the connection holding the lock, nil if it isn't held
+	lastCheck time.Time
+	lastError string
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// Stats describes the state of a Lock, for the metrics.
+type Stats struct {
+	Name         string    `json:"name"`
+	Leader       bool      `json:"leader"`
+	Acquisitions int64     `json:"acquisitions"`
+	Losses       int64     `json:"losses"`
+	LastCheck    time.Time `json:"last_check"`
+	LastError    string    `json:"last_error,omitempty"`
+}
+
+// New returns the lock with the given name. Replicas using the same name compete for
+// the same lock.
+func New(db *sql.DB, name string, interval time.Duration) *Lock {
+	h := fnv.New64a()
+	h.Write([]byte("greenlight:" + name))
+	return &Lock{
+		db:       db,
+		name:     name,
+		key:      int64(h.Sum64()),
+		interval: interval,
+	}
+}
+
+// Start tries to acquire the lock straight away, and then keeps trying, or checking
+// that it's still held, in the background until Stop is called.
+func (l *Lock) Start() {
+	l.stop = make(chan struct{})
+	l.done = make(chan struct{})
+	l.check()
+	go func() {
+		defer close(l.done)
+		ticker := time.NewTicker(l.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				l.check()
+			case <-l.stop:
+				l.release()
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops the background checks and releases the lock if it's held, so that another
+// replica can acquire it without waiting for this one's connection to time out.
+func (l *Lock) Stop() {
+	if l.stop == nil {
+		return
+	}
+	close(l.stop)
+	<-l.done
+}
+
+// Held reports whether this replica holds the lock, as of the last check.
+func (l *Lock) Held() bool {
+	return l.held.Load()
+}
+
+// Stats returns the current state of the lock.
+func (l *Lock) Stats() Stats {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return Stats{
+		Name:         l.name,
+		Leader:       l.held.Load(),
+		Acquisitions: l.acquisitions.Load(),
+		Losses:       l.losses.Load(),
+		LastCheck:    l.lastCheck,
+		LastError:    l.lastError,
+	}
+}
+
+// check acquires the lock if it isn't held, or makes sure the connection holding it is
+// still alive if it is. A session-level advisory lock lasts as long as the session, so
+// a live connection means the lock is still held.
+func (l *Lock) check() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), l.interval)
+	defer cancel()
+
+	l.lastCheck = time.Now()
+	l.lastError = ""
+
+	if l.conn != nil {
+		var one int
+		err := l.conn.QueryRowContext(ctx, `SELECT 1`).Scan(&one)
+		if err != nil {
+			l.lastError = err.Error()
+			l.losses.Add(1)
+			l.held.Store(false)
+			discard(l.conn)
+			l.conn = nil
+		}
+		return
+	}
+
+	conn, err := l.db.Conn(ctx)
+	if err != nil {
+		l.lastError = err.Error()
+		return
+	}
+	var acquired bool
+	err = conn.QueryRowContext(ctx, `SELECT pg_try_advisory_lock($1)`, l.key).Scan(&acquired)
+	if err != nil {
+		l.lastError = err.Error()
+		discard(conn)
+		return
+	}
+	if !acquired {
+		conn.Close()
+		return
+	}
+	l.conn = conn
+	l.acquisitions.Add(1)
+	l.held.Store(true)
+}
+
+// release gives the lock up, if it's held.
+func (l *Lock) release() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.conn == nil {
+		return
+	}
+	l.held.Store(false)
+	discard(l.conn)
+	l.conn = nil
+}
+
+// discard closes a connection rather than returning it to the pool. Ending the session
+// releases any advisory lock it holds, even one whose unlock query would have failed,
+// so a lock can never be left behind on an idle connection of the pool.
+func discard(conn *sql.Conn) {
+	conn.Raw(func(any) error {
+		return driver.ErrBadConn
+	})
+	conn.Close()
+}
@@ -0,0 +1,164 @@
+// Package lifecycle starts and stops the components of the application, such as its
+// database connection pools, its background workers and its HTTP servers, in order.
+// Components are started in the order they're added, and stopped in the reverse order,
+// so that nothing is stopped while something started after it may still be using it:
+// the HTTP server stops taking requests before the workers are drained, and they are
+// drained before the database is closed.
+package lifecycle
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+	"time"
+
+	"github.com/shyngys9219/greenlight/internal/health"
+)
+
+// A Component is a part of the application which is started and stopped with it. All
+// the functions are optional.
+type Component struct {
+	Name string
+	// Start brings the component up, giving up when ctx ends. It must return once the
+	// component is up: work which goes on for the lifetime of the application, such as
+	// serving requests, runs in goroutines of its own, which report a failure with
+	// Lifecycle.Fail().
+	Start func(ctx context.Context) error
+	// Stop shuts the component down, giving up when ctx ends. It's only called if Start
+	// succeeded.
+	Stop func(ctx context.Context) error
+	// Health probes the component for the readiness check.
+	Health health.Probe
+}
+
+// Logger is the part of jsonlog.Logger the lifecycle logs through.
+type Logger interface {
+	PrintInfo(message string, properties map[string]string)
+	PrintError(err error, properties map[string]string)
+}
+
+// A Lifecycle holds the components of the application. Components are added before
+// it's started, and not after.
+type Lifecycle struct {
+	logger     Logger
+	components []Component
+	// number of components started, which are the ones Stop() stops
+	started int
+	// receives the first failure reported by Fail(), which ends Run()
+	failed chan error
+	once   sync.Once
+}
+
+// New returns an empty Lifecycle which logs to logger.
+func New(logger Logger) *Lifecycle {
+	return &Lifecycle{
+		logger: logger,
+		failed: make(chan error, 1),
+	}
+}
+
+// Add adds a component, which is started after those added before it and stopped
+// before them.
+func (l *Lifecycle) Add(c Component) {
+	l.components = append(l.components, c)
+}
+
+// Start starts the components in order. If one of them fails to start, those already
+// started are stopped again, each given up to stopTimeout, and the error is returned.
+func (l *Lifecycle) Start(ctx context.Context, stopTimeout time.Duration) error {
+	for _, c := range l.components {
+		if c.Start != nil {
+			begin := time.Now()
+			err := c.Start(ctx)
+			if err != nil {
+				l.Stop(stopTimeout)
+				return fmt.Errorf("starting %s: %w", c.Name, err)
+			}
+			l.logger.PrintInfo("component started", map[string]string{
+				"component": c.Name,
+				"took":      time.Since(begin).Round(time.Millisecond).String(),
+			})
+		}
+		l.started++
+	}
+	return nil
+}
+
+// Stop stops the components which were started, in the reverse order, giving each one
+// up to timeout. A component which fails to stop, or doesn't in time, doesn't hold up
+// the others: the failure is logged and the next one is stopped. The first error is
+// returned.
+func (l *Lifecycle) Stop(timeout time.Duration) error {
+	var first error
+	for ; l.started > 0; l.started-- {
+		c := l.components[l.started-1]
+		if c.Stop == nil {
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		begin := time.Now()
+		err := c.Stop(ctx)
+		cancel()
+		if err != nil {
+			err = fmt.Errorf("stopping %s: %w", c.Name, err)
+			l.logger.PrintError(err, map[string]string{"component": c.Name})
+			if first == nil {
+				first = err
+			}
+			continue
+		}
+		l.logger.PrintInfo("component stopped", map[string]string{
+			"component": c.Name,
+			"took":      time.Since(begin).Round(time.Millisecond).String(),
+		})
+	}
+	return first
+}
+
+// Fail reports that a component failed after it started, such as a server which
+// stopped accepting connections. Run() then stops the application and returns the
+// error. Only the first failure is kept.
+func (l *Lifecycle) Fail(name string, err error) {
+	l.once.Do(func() {
+		l.failed <- fmt.Errorf("%s failed: %w", name, err)
+	})
+}
+
+// Run starts the components, waits for one of the signals or a failure reported with
+// Fail(), and stops them. It returns the error which stopped the application, if it
+// wasn't a signal, or else the first error stopping it.
+func (l *Lifecycle) Run(stopTimeout time.Duration, signals ...os.Signal) error {
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, signals...)
+	defer signal.Stop(quit)
+
+	err := l.Start(context.Background(), stopTimeout)
+	if err != nil {
+		return err
+	}
+
+	select {
+	case s := <-quit:
+		l.logger.PrintInfo("caught signal", map[string]string{"signal": s.String()})
+	case err = <-l.failed:
+	}
+
+	stopErr := l.Stop(stopTimeout)
+	if err != nil {
+		return err
+	}
+	return stopErr
+}
+
+// Probes returns the health probes of the components which have one, by name.
+func (l *Lifecycle) Probes() map[string]health.Probe {
+	probes := make(map[string]health.Probe)
+	for _, c := range l.components {
+		if c.Health != nil {
+			probes[c.Name] = c.Health
+		}
+	}
+	return probes
+}
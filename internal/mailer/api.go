@@ -0,0 +1,52 @@
+package mailer
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// apiTimeout is how long a call to a provider's HTTP API may take.
+const apiTimeout = 10 * time.Second
+
+// An APIError is the response of a provider's HTTP API to a request which failed.
+type APIError struct {
+	Provider   string
+	StatusCode int
+	Body       string // the start of the response body, which describes the error
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("%s: %d %s: %s", e.Provider, e.StatusCode, http.StatusText(e.StatusCode), e.Body)
+}
+
+// Permanent reports whether the provider rejected the email itself, so sending it again
+// would fail the same way. Rate limiting, server errors and bad credentials aren't
+// permanent: the email goes out once they're over or fixed.
+func (e *APIError) Permanent() bool {
+	switch e.StatusCode {
+	case http.StatusBadRequest, http.StatusRequestEntityTooLarge, http.StatusUnprocessableEntity:
+		return true
+	}
+	return false
+}
+
+// The doAPI() helper sends a request to a provider's HTTP API, and returns an APIError
+// if the response status isn't 2xx.
+func doAPI(client *http.Client, provider string, req *http.Request) error {
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		body, _ := io.ReadAll(io.LimitReader(res.Body, 512))
+		return &APIError{Provider: provider, StatusCode: res.StatusCode, Body: strings.TrimSpace(string(body))}
+	}
+	// Read the rest of the body so the connection can be reused.
+	_, _ = io.Copy(io.Discard, res.Body)
+	return nil
+}
@@ -1,89 +1,85 @@
 package mailer
 
 import (
-	"bytes"
-	"embed"
-	"html/template"
+	"context"
+	"errors"
+	"io/fs"
+	"net/textproto"
 	"time"
 
 	"github.com/go-mail/mail/v2"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
-// Below we declare a new variable with the type embed.FS (embedded file system) to hold
-// our email templates. This has a comment directive in the format `//go:embed <path>`
-// IMMEDIATELY ABOVE it, which indicates to Go that we want to store the contents of the
-// ./templates directory in the templateFS embedded file system variable.
+// tracerName names the tracer of the spans of the emails sent.
+const tracerName = "github.com/shyngys9219/greenlight/internal/mailer"
 
-//go:embed templates
-var templateFS embed.FS
+// The names of the senders which can deliver emails, chosen by the -mail-provider flag.
+// SMTP is the default; the others send through the provider's HTTP API, for hosts which
+// block outbound SMTP ports.
+const (
+	SenderSMTP     = "smtp"
+	SenderSendGrid = "sendgrid"
+	SenderSES      = "ses"
+	SenderMailgun  = "mailgun"
+)
+
+// SenderNames lists the senders, for flag help and error messages.
+const SenderNames = SenderSMTP + "|" + SenderSendGrid + "|" + SenderSES + "|" + SenderMailgun
 
-// Define a Mailer struct which contains a mail.Dialer instance (used to connect to a
-// SMTP server) and the sender information for your emails (the name and address you
-// want the email to be from, such as "Alice Smith <alice@example.com>").
+// A Sender delivers rendered emails, over SMTP or through a provider's HTTP API.
+type Sender interface {
+	// Send makes a single attempt to send the email from the sender address to the
+	// recipient.
+	Send(from, to string, msg *Message) error
+	// Ping checks that the provider is reachable and accepts our credentials, without
+	// sending anything.
+	Ping() error
+}
+
+// Define a Mailer struct which contains the Sender which delivers the emails and the
+// sender information for your emails (the name and address you want the email to be
+// from, such as "Alice Smith <alice@example.com>").
 type Mailer struct {
-	dialer *mail.Dialer
-	sender string
+	sender    Sender
+	from      string
+	throttle  *throttle // paces sends to the provider's limits, nil if they aren't
+	templates fs.FS     // the templates directory, see WithTemplateDir()
 }
 
+// New returns a mailer which sends emails through the given SMTP server.
 func New(host string, port int, username, password, sender string) Mailer {
-	// Initialize a new mail.Dialer instance with the given SMTP server settings. We
-	// also configure this to use a 5-second timeout whenever we send an email.
-	dialer := mail.NewDialer(host, port, username, password)
-	dialer.Timeout = 5 * time.Second
-	// Return a Mailer instance containing the dialer and sender information.
-	return Mailer{
-		dialer: dialer,
-		sender: sender,
-	}
+	return NewWithSender(NewSMTP(host, port, username, password), sender)
+}
+
+// NewWithSender returns a mailer which sends emails from the sender address with the
+// given Sender.
+func NewWithSender(s Sender, sender string) Mailer {
+	return Mailer{sender: s, from: sender}
+}
+
+// A Message is an email rendered from one of the templates, ready to be sent. It can
+// be stored and sent later, as the email queue does.
+type Message struct {
+	Subject   string
+	PlainBody string
+	HTMLBody  string
 }
 
 // Define a Send() method on the Mailer type. This takes the recipient email address
 // as the first parameter, the name of the file containing the templates, and any
-// dynamic data for the templates as an any parameter.
+// dynamic data for the templates as an any parameter. It makes up to three attempts
+// to send the email, a short time apart.
 func (m Mailer) Send(recipient, templateFile string, data any) error {
-	// Use the ParseFS() method to parse the required template file from the embedded
-	// file system.
-	tmpl, err := template.New("email").ParseFS(templateFS, "templates/"+templateFile)
-	if err != nil {
-		return err
-	}
-	// Execute the named template "subject", passing in the dynamic data and storing the
-	// result in a bytes.Buffer variable.
-	subject := new(bytes.Buffer)
-	err = tmpl.ExecuteTemplate(subject, "subject", data)
-	if err != nil {
-		return err
-	}
-	// Follow the same pattern to execute the "plainBody" template and store the result
-	// in the plainBody variable.
-	plainBody := new(bytes.Buffer)
-	err = tmpl.ExecuteTemplate(plainBody, "plainBody", data)
-	if err != nil {
-		return err
-	}
-	// And likewise with the "htmlBody" template.
-	htmlBody := new(bytes.Buffer)
-	err = tmpl.ExecuteTemplate(htmlBody, "htmlBody", data)
+	msg, err := m.Render(templateFile, data)
 	if err != nil {
 		return err
 	}
-	// Use the mail.NewMessage() function to initialize a new mail.Message instance.
-	// Then we use the SetHeader() method to set the email recipient, sender and subject
-	// headers, the SetBody() method to set the plain-text body, and the AddAlternative()
-	// method to set the HTML body. It's important to note that AddAlternative() should
-	// always be called *after* SetBody().
-	msg := mail.NewMessage()
-	msg.SetHeader("To", recipient)
-	msg.SetHeader("From", m.sender)
-	msg.SetHeader("Subject", subject.String())
-	msg.SetBody("text/plain", plainBody.String())
-	msg.AddAlternative("text/html", htmlBody.String())
-	// Call the DialAndSend() method on the dialer, passing in the message to send. This
-	// opens a connection to the SMTP server, sends the message, then closes the
-	// connection. If there is a timeout, it will return a "dial tcp: i/o timeout"
-	// error.
 	for i := 1; i <= 3; i++ {
-		err = m.dialer.DialAndSend(msg)
+		err = m.SendMessage(recipient, msg)
 		// If everything worked, return nil.
 		if nil == err {
 			return nil
@@ -93,3 +89,56 @@ func (m Mailer) Send(recipient, templateFile string, data any) error {
 	}
 	return err
 }
+
+// SendMessage makes a single attempt to send a rendered email. The attempt is recorded
+// as an OpenTelemetry span, which covers the wait for the throttle too, so that slow
+// sends can be told apart from held back ones.
+func (m Mailer) SendMessage(recipient string, message *Message) error {
+	_, span := otel.Tracer(tracerName).Start(context.Background(), "mail.send",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.String("mail.subject", message.Subject)),
+	)
+	defer span.End()
+
+	err := m.send(recipient, message)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	return err
+}
+
+func (m Mailer) send(recipient string, message *Message) error {
+	// Every attempt waits for its turn with the throttle first, since the provider
+	// counts failed attempts against the limit too.
+	if m.throttle != nil {
+		err := m.throttle.wait()
+		if err != nil {
+			return err
+		}
+	}
+	return m.sender.Send(m.from, recipient, message)
+}
+
+// IsPermanent reports whether an error from SendMessage() is permanent, so sending the
+// email again would fail the same way: the SMTP server rejected it with a 5xx reply,
+// such as for a mailbox which doesn't exist, or the provider's API rejected it as
+// invalid.
+func IsPermanent(err error) bool {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.Permanent()
+	}
+	// The mail package wraps the SMTP error in a SendError, which can't be unwrapped.
+	var sendErr *mail.SendError
+	if errors.As(err, &sendErr) {
+		err = sendErr.Cause
+	}
+	var tpErr *textproto.Error
+	return errors.As(err, &tpErr) && tpErr.Code >= 500 && tpErr.Code < 600
+}
+
+// Ping checks that the provider is reachable and accepts our credentials.
+func (m Mailer) Ping() error {
+	return m.sender.Ping()
+}
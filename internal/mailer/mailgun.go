@@ -0,0 +1,57 @@
+package mailer
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// MailgunURL is the base URL of the Mailgun API for domains in the US region. Domains
+// in the EU region use https://api.eu.mailgun.net instead.
+const MailgunURL = "https://api.mailgun.net"
+
+// Mailgun is a Sender which delivers emails through the Mailgun messages API, from one
+// of the account's sending domains.
+type Mailgun struct {
+	domain  string
+	apiKey  string
+	client  *http.Client
+	baseURL string
+}
+
+// NewMailgun returns a Sender for the sending domain, with the API at baseURL.
+func NewMailgun(baseURL, domain, apiKey string) *Mailgun {
+	return &Mailgun{
+		domain:  domain,
+		apiKey:  apiKey,
+		client:  &http.Client{Timeout: apiTimeout},
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+	}
+}
+
+func (m *Mailgun) Send(from, to string, msg *Message) error {
+	form := url.Values{}
+	form.Set("from", from)
+	form.Set("to", to)
+	form.Set("subject", msg.Subject)
+	form.Set("text", msg.PlainBody)
+	form.Set("html", msg.HTMLBody)
+
+	req, err := http.NewRequest(http.MethodPost, m.baseURL+"/v3/"+url.PathEscape(m.domain)+"/messages", strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth("api", m.apiKey)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return doAPI(m.client, SenderMailgun, req)
+}
+
+// Ping reads the sending domain, which fails if the API key or the domain is wrong.
+func (m *Mailgun) Ping() error {
+	req, err := http.NewRequest(http.MethodGet, m.baseURL+"/v3/domains/"+url.PathEscape(m.domain), nil)
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth("api", m.apiKey)
+	return doAPI(m.client, SenderMailgun, req)
+}
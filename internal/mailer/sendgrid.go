@@ -0,0 +1,71 @@
+package mailer
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/mail"
+)
+
+// SendGrid is a Sender which delivers emails through the SendGrid v3 mail send API.
+type SendGrid struct {
+	apiKey  string
+	client  *http.Client
+	baseURL string
+}
+
+// NewSendGrid returns a Sender for the SendGrid account of the API key.
+func NewSendGrid(apiKey string) *SendGrid {
+	return &SendGrid{
+		apiKey:  apiKey,
+		client:  &http.Client{Timeout: apiTimeout},
+		baseURL: "https://api.sendgrid.com/v3",
+	}
+}
+
+type sendGridAddress struct {
+	Email string `json:"email"`
+	Name  string `json:"name,omitempty"`
+}
+
+func (s *SendGrid) Send(from, to string, msg *Message) error {
+	// SendGrid takes the name and the address of the sender apart.
+	sender, err := mail.ParseAddress(from)
+	if err != nil {
+		return err
+	}
+
+	type content struct {
+		Type  string `json:"type"`
+		Value string `json:"value"`
+	}
+	body := map[string]any{
+		"personalizations": []map[string]any{{"to": []sendGridAddress{{Email: to}}}},
+		"from":             sendGridAddress{Email: sender.Address, Name: sender.Name},
+		"subject":          msg.Subject,
+		// The plain text must come first.
+		"content": []content{{"text/plain", msg.PlainBody}, {"text/html", msg.HTMLBody}},
+	}
+	js, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, s.baseURL+"/mail/send", bytes.NewReader(js))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+s.apiKey)
+	req.Header.Set("Content-Type", "application/json")
+	return doAPI(s.client, SenderSendGrid, req)
+}
+
+// Ping reads the permissions of the API key, which fails if the key is invalid.
+func (s *SendGrid) Ping() error {
+	req, err := http.NewRequest(http.MethodGet, s.baseURL+"/scopes", nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+s.apiKey)
+	return doAPI(s.client, SenderSendGrid, req)
+}
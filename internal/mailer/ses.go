@@ -0,0 +1,143 @@
+package mailer
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// SES is a Sender which delivers emails through the Amazon SES v2 API. There is no AWS
+// SDK dependency; requests are signed with Signature Version 4 here.
+type SES struct {
+	region       string
+	accessKeyID  string
+	secretKey    string
+	sessionToken string // for temporary credentials, empty otherwise
+	client       *http.Client
+	baseURL      string
+}
+
+// NewSES returns a Sender for SES in the given region, such as "eu-west-1", with the
+// credentials of an IAM user or role allowed to call ses:SendEmail.
+func NewSES(region, accessKeyID, secretKey, sessionToken string) *SES {
+	return &SES{
+		region:       region,
+		accessKeyID:  accessKeyID,
+		secretKey:    secretKey,
+		sessionToken: sessionToken,
+		client:       &http.Client{Timeout: apiTimeout},
+		baseURL:      "https://email." + region + ".amazonaws.com",
+	}
+}
+
+func (s *SES) Send(from, to string, msg *Message) error {
+	type content struct {
+		Data    string `json:"Data"`
+		Charset string `json:"Charset"`
+	}
+	body := map[string]any{
+		"FromEmailAddress": from,
+		"Destination":      map[string]any{"ToAddresses": []string{to}},
+		"Content": map[string]any{
+			"Simple": map[string]any{
+				"Subject": content{msg.Subject, "UTF-8"},
+				"Body": map[string]content{
+					"Text": {msg.PlainBody, "UTF-8"},
+					"Html": {msg.HTMLBody, "UTF-8"},
+				},
+			},
+		},
+	}
+	js, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	return s.do(http.MethodPost, "/v2/email/outbound-emails", js)
+}
+
+// Ping reads the SES account, which fails if the credentials or the region are wrong.
+func (s *SES) Ping() error {
+	return s.do(http.MethodGet, "/v2/email/account", nil)
+}
+
+// The do() method signs and sends a request to the SES API.
+func (s *SES) do(method, path string, body []byte) error {
+	req, err := http.NewRequest(method, s.baseURL+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	s.sign(req, body, time.Now())
+	return doAPI(s.client, SenderSES, req)
+}
+
+// The sign() method adds the Signature Version 4 authorization to a request. The SES
+// requests have no query string, so the canonical query string is always empty.
+func (s *SES) sign(req *http.Request, body []byte, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+	payloadHash := sha256Hex(body)
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if s.sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", s.sessionToken)
+	}
+
+	// The canonical headers are the host and every header set above, with lowercase
+	// names, sorted.
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		"",
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + s.region + "/ses/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex([]byte(canonicalRequest))
+
+	key := hmacSHA256([]byte("AWS4"+s.secretKey), date)
+	key = hmacSHA256(key, s.region)
+	key = hmacSHA256(key, "ses")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+s.accessKeyID+"/"+scope+
+		", SignedHeaders="+signedHeaders+", Signature="+signature)
+}
+
+func sha256Hex(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,50 @@
+package mailer
+
+import (
+	"time"
+
+	"github.com/go-mail/mail/v2"
+)
+
+// SMTP is a Sender which delivers emails to an SMTP server.
+type SMTP struct {
+	dialer *mail.Dialer
+}
+
+// NewSMTP returns a Sender for the SMTP server with the given settings.
+func NewSMTP(host string, port int, username, password string) *SMTP {
+	// Initialize a new mail.Dialer instance with the given SMTP server settings. We
+	// also configure this to use a 5-second timeout whenever we send an email.
+	dialer := mail.NewDialer(host, port, username, password)
+	dialer.Timeout = 5 * time.Second
+	return &SMTP{dialer: dialer}
+}
+
+func (s *SMTP) Send(from, to string, message *Message) error {
+	// Use the mail.NewMessage() function to initialize a new mail.Message instance.
+	// Then we use the SetHeader() method to set the email recipient, sender and subject
+	// headers, the SetBody() method to set the plain-text body, and the AddAlternative()
+	// method to set the HTML body. It's important to note that AddAlternative() should
+	// always be called *after* SetBody().
+	msg := mail.NewMessage()
+	msg.SetHeader("To", to)
+	msg.SetHeader("From", from)
+	msg.SetHeader("Subject", message.Subject)
+	msg.SetBody("text/plain", message.PlainBody)
+	msg.AddAlternative("text/html", message.HTMLBody)
+	// Call the DialAndSend() method on the dialer, passing in the message to send. This
+	// opens a connection to the SMTP server, sends the message, then closes the
+	// connection. If there is a timeout, it will return a "dial tcp: i/o timeout"
+	// error.
+	return s.dialer.DialAndSend(msg)
+}
+
+// Ping opens a connection to the SMTP server, which checks our credentials, and closes
+// it again without sending anything.
+func (s *SMTP) Ping() error {
+	conn, err := s.dialer.Dial()
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
@@ -0,0 +1,158 @@
+package mailer
+
+import (
+	"bytes"
+	"embed"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+	texttemplate "text/template"
+)
+
+// Below we declare a new variable with the type embed.FS (embedded file system) to hold
+// our email templates. This has a comment directive in the format `//go:embed <path>`
+// IMMEDIATELY ABOVE it, which indicates to Go that we want to store the contents of the
+// ./templates directory in the templateFS embedded file system variable.
+
+//go:embed templates
+var templateFS embed.FS
+
+// layoutFile is the base layout every email is rendered in. It defines the "plain" and
+// "html" templates, which wrap the "plainBody" and "htmlBody" of the email's template
+// file.
+const layoutFile = "layouts/base.tmpl"
+
+// WithTemplateDir returns a copy of the mailer whose templates are read from dir, a
+// directory laid out like the embedded templates directory. Each file in dir, including
+// layouts/base.tmpl, replaces the embedded file of the same name, and the others are
+// kept; so operators can change the branding of the emails, or the wording of one of
+// them, without recompiling. The files are read whenever an email is rendered, so
+// changes apply straight away. An empty dir uses the embedded templates only.
+func (m Mailer) WithTemplateDir(dir string) (Mailer, error) {
+	m.templates = nil
+	if dir == "" {
+		return m, nil
+	}
+	info, err := os.Stat(dir)
+	if err != nil {
+		return m, err
+	}
+	if !info.IsDir() {
+		return m, fmt.Errorf("email templates: %s is not a directory", dir)
+	}
+	m.templates = overlayFS{override: os.DirFS(dir), base: m.templateFS()}
+	return m, nil
+}
+
+// The templateFS() method returns the templates directory of the mailer.
+func (m Mailer) templateFS() fs.FS {
+	if m.templates != nil {
+		return m.templates
+	}
+	sub, _ := fs.Sub(templateFS, "templates")
+	return sub
+}
+
+// An overlayFS reads files from override, and from base when override doesn't have
+// them.
+type overlayFS struct {
+	override, base fs.FS
+}
+
+func (o overlayFS) Open(name string) (fs.File, error) {
+	f, err := o.override.Open(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return o.base.Open(name)
+	}
+	return f, err
+}
+
+// Render executes the templates in the given template file with the dynamic data, and
+// returns the email they make up. The subject and the plain-text body are rendered with
+// text/template, so that nothing in them is HTML-escaped, and the HTML body with
+// html/template; both bodies are wrapped in the base layout.
+func (m Mailer) Render(templateFile string, data any) (*Message, error) {
+	fsys := m.templateFS()
+
+	// Use the ParseFS() method to parse the layout and the required template file from
+	// the templates directory, once for each kind of template.
+	text, err := texttemplate.New("email").ParseFS(fsys, layoutFile, templateFile)
+	if err != nil {
+		return nil, err
+	}
+	html, err := htmltemplate.New("email").ParseFS(fsys, layoutFile, templateFile)
+	if err != nil {
+		return nil, err
+	}
+
+	// Execute the named template "subject", passing in the dynamic data and storing the
+	// result in a bytes.Buffer variable.
+	subject := new(bytes.Buffer)
+	err = text.ExecuteTemplate(subject, "subject", data)
+	if err != nil {
+		return nil, err
+	}
+	// Follow the same pattern to execute the "plain" layout, which includes the
+	// "plainBody" template, and store the result in the plainBody variable.
+	plainBody := new(bytes.Buffer)
+	err = text.ExecuteTemplate(plainBody, "plain", data)
+	if err != nil {
+		return nil, err
+	}
+	// And likewise with the "html" layout and the "htmlBody" template.
+	htmlBody := new(bytes.Buffer)
+	err = html.ExecuteTemplate(htmlBody, "html", data)
+	if err != nil {
+		return nil, err
+	}
+	return &Message{
+		Subject:   strings.TrimSpace(subject.String()),
+		PlainBody: plainBody.String(),
+		HTMLBody:  htmlBody.String(),
+	}, nil
+}
+
+// CheckTemplates parses every email template with the layout and checks that it
+// defines the subject, plainBody and htmlBody templates which Render() executes, so a
+// broken template is caught before the first email using it is sent.
+func (m Mailer) CheckTemplates() error {
+	fsys := m.templateFS()
+
+	layout, err := htmltemplate.ParseFS(fsys, layoutFile)
+	if err != nil {
+		return err
+	}
+	for _, name := range []string{"plain", "html"} {
+		if layout.Lookup(name) == nil {
+			return fmt.Errorf("template %s does not define %q", layoutFile, name)
+		}
+	}
+
+	// The template files are those of the embedded directory, since a template
+	// directory only needs the files it replaces.
+	files, err := fs.Glob(templateFS, "templates/*.tmpl")
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		file = path.Base(file)
+		_, err := texttemplate.New("email").ParseFS(fsys, layoutFile, file)
+		if err != nil {
+			return err
+		}
+		tmpl, err := htmltemplate.New("email").ParseFS(fsys, layoutFile, file)
+		if err != nil {
+			return err
+		}
+		for _, name := range []string{"subject", "plainBody", "htmlBody"} {
+			if tmpl.Lookup(name) == nil {
+				return fmt.Errorf("template %s does not define %q", file, name)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,124 @@
+package mailer
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// Limits are the sending rate an email provider accepts: Rate emails per second on
+// average, with bursts of up to Burst emails. A zero Rate means no limit.
+type Limits struct {
+	Rate  float64
+	Burst int
+}
+
+// ProviderLimits holds the default limits of the providers we know about. They're on
+// the safe side of what each provider allows, and can be overridden by the -smtp-rate
+// and -smtp-burst flags when an account has a different quota.
+var ProviderLimits = map[string]Limits{
+	// The Mailtrap sandbox has a low send rate on its smaller plans, and rejects
+	// emails sent faster.
+	"mailtrap": {Rate: 0.5, Burst: 1},
+	// Amazon SES accounts start with a maximum send rate of 14 emails a second once
+	// they're out of the sandbox; the sandbox itself allows 1.
+	"ses":         {Rate: 14, Burst: 14},
+	"ses-sandbox": {Rate: 1, Burst: 1},
+	// Any other SMTP server, which we don't throttle.
+	"smtp": {},
+}
+
+// ProviderNames returns the names of the providers in ProviderLimits, for flag help
+// and error messages.
+func ProviderNames() string {
+	names := make([]string, 0, len(ProviderLimits))
+	for name := range ProviderLimits {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, "|")
+}
+
+// LimitsFor returns the default limits of a provider.
+func LimitsFor(provider string) (Limits, error) {
+	limits, ok := ProviderLimits[provider]
+	if !ok {
+		return Limits{}, fmt.Errorf("unknown email provider %q (want %s)", provider, ProviderNames())
+	}
+	return limits, nil
+}
+
+// ThrottleStats describes how much sending has been held back by the throttle, for the
+// metrics.
+type ThrottleStats struct {
+	Rate        float64 `json:"rate"`
+	Burst       int     `json:"burst"`
+	Waiting     int64   `json:"waiting"`      // emails waiting for their turn now
+	Delayed     int64   `json:"delayed"`      // emails which had to wait
+	WaitSeconds float64 `json:"wait_seconds"` // total time emails spent waiting
+}
+
+// A throttle paces sends with a token bucket. Emails over the limit wait their turn
+// rather than being dropped, so a burst of sends, such as a campaign run, queues up
+// behind the limiter and goes out at the rate the provider accepts.
+type throttle struct {
+	limiter *rate.Limiter
+	limits  Limits
+	waiting atomic.Int64
+	delayed atomic.Int64
+	waited  atomic.Int64 // nanoseconds
+}
+
+func newThrottle(limits Limits) *throttle {
+	burst := limits.Burst
+	if burst < 1 {
+		burst = 1
+	}
+	return &throttle{limiter: rate.NewLimiter(rate.Limit(limits.Rate), burst), limits: limits}
+}
+
+// wait blocks until the email may be sent.
+func (t *throttle) wait() error {
+	t.waiting.Add(1)
+	defer t.waiting.Add(-1)
+	start := time.Now()
+	err := t.limiter.Wait(context.Background())
+	if err != nil {
+		return err
+	}
+	if waited := time.Since(start); waited > time.Millisecond {
+		t.delayed.Add(1)
+		t.waited.Add(int64(waited))
+	}
+	return nil
+}
+
+// WithLimits returns a copy of the mailer which sends at most at the given rate. Copies
+// of the returned mailer share its limit. A zero Rate turns throttling off.
+func (m Mailer) WithLimits(limits Limits) Mailer {
+	m.throttle = nil
+	if limits.Rate > 0 {
+		m.throttle = newThrottle(limits)
+	}
+	return m
+}
+
+// ThrottleStats returns the current state of the throttle; all zero if there's none.
+func (m Mailer) ThrottleStats() ThrottleStats {
+	t := m.throttle
+	if t == nil {
+		return ThrottleStats{}
+	}
+	return ThrottleStats{
+		Rate:        t.limits.Rate,
+		Burst:       t.limits.Burst,
+		Waiting:     t.waiting.Load(),
+		Delayed:     t.delayed.Load(),
+		WaitSeconds: time.Duration(t.waited.Load()).Seconds(),
+	}
+}
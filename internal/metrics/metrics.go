@@ -0,0 +1,159 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+)
+
+// A Counter counts events, such as users signing up. It only goes up. Besides its total
+// since the process started, it keeps the count not yet taken by Registry.Take(), so
+// the counts can also be rolled up elsewhere. It's safe for concurrent use.
+type Counter struct {
+	name, help string
+	total      atomic.Int64
+	untaken    atomic.Int64
+}
+
+// Inc adds one to the counter.
+func (c *Counter) Inc() {
+	c.Add(1)
+}
+
+// Add adds n, which must not be negative, to the counter.
+func (c *Counter) Add(n int64) {
+	c.total.Add(n)
+	c.untaken.Add(n)
+}
+
+// Name returns the name the counter was registered with.
+func (c *Counter) Name() string {
+	return c.name
+}
+
+// Value returns the total of the counter since the process started.
+func (c *Counter) Value() int64 {
+	return c.total.Load()
+}
+
+// A Gauge holds a value which goes up and down, such as the number of movies in the
+// catalog. It's safe for concurrent use.
+type Gauge struct {
+	name, help string
+	value      atomic.Int64
+}
+
+// Set sets the gauge to v.
+func (g *Gauge) Set(v int64) {
+	g.value.Store(v)
+}
+
+// Add adds n, which may be negative, to the gauge.
+func (g *Gauge) Add(n int64) {
+	g.value.Add(n)
+}
+
+// Value returns the value of the gauge.
+func (g *Gauge) Value() int64 {
+	return g.value.Load()
+}
+
+// A Registry holds a set of counters and gauges, and writes them out in the Prometheus
+// text exposition format. It's safe for concurrent use.
+type Registry struct {
+	mu       sync.Mutex
+	counters map[string]*Counter
+	gauges   map[string]*Gauge
+}
+
+// NewRegistry returns an empty Registry.
+func NewRegistry() *Registry {
+	return &Registry{
+		counters: make(map[string]*Counter),
+		gauges:   make(map[string]*Gauge),
+	}
+}
+
+// Counter returns the counter with the given name, registering it with the help text
+// if it doesn't exist yet.
+func (r *Registry) Counter(name, help string) *Counter {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	c, ok := r.counters[name]
+	if !ok {
+		c = &Counter{name: name, help: help}
+		r.counters[name] = c
+	}
+	return c
+}
+
+// Gauge returns the gauge with the given name, registering it with the help text if it
+// doesn't exist yet.
+func (r *Registry) Gauge(name, help string) *Gauge {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	g, ok := r.gauges[name]
+	if !ok {
+		g = &Gauge{name: name, help: help}
+		r.gauges[name] = g
+	}
+	return g
+}
+
+// Take returns, for each counter which has gone up since the last call, how much it
+// has gone up by, and starts counting afresh. Counts which couldn't be stored should be
+// handed back with Restore(), so that they're taken again next time.
+func (r *Registry) Take() map[string]int64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	taken := make(map[string]int64)
+	for name, c := range r.counters {
+		if n := c.untaken.Swap(0); n != 0 {
+			taken[name] = n
+		}
+	}
+	return taken
+}
+
+// Restore hands back counts returned by Take().
+func (r *Registry) Restore(taken map[string]int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for name, n := range taken {
+		if c, ok := r.counters[name]; ok {
+			c.untaken.Add(n)
+		}
+	}
+}
+
+// WritePrometheus writes every counter and gauge to w in the Prometheus text
+// exposition format, sorted by name.
+func (r *Registry) WritePrometheus(w io.Writer) error {
+	type sample struct {
+		name, help, kind string
+		value            int64
+	}
+
+	r.mu.Lock()
+	samples := make([]sample, 0, len(r.counters)+len(r.gauges))
+	for _, c := range r.counters {
+		samples = append(samples, sample{c.name, c.help, "counter", c.Value()})
+	}
+	for _, g := range r.gauges {
+		samples = append(samples, sample{g.name, g.help, "gauge", g.Value()})
+	}
+	r.mu.Unlock()
+
+	sort.Slice(samples, func(i, j int) bool { return samples[i].name < samples[j].name })
+	for _, s := range samples {
+		_, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %s\n",
+			s.name, s.help, s.name, s.kind, s.name, strconv.FormatInt(s.value, 10))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,240 @@
+// Package migrate applies the SQL migrations of the database schema. It keeps track of
+// the schema version in the same schema_migrations table as the migrate tool, so
+// databases migrated with either can be migrated with the other.
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ErrDirty is returned when an earlier migration failed half way, which the migrate tool
+// records as a dirty schema version. It has to be repaired by hand.
+var ErrDirty = errors.New("migrate: the database schema is dirty, an earlier migration failed and must be fixed by hand")
+
+// lockKey is the key of the advisory lock held while migrating, so replicas started
+// together don't run the same migrations at the same time.
+const lockKey = 7_219_440_317
+
+// A Migration is one numbered change of the schema, with the SQL which makes it and
+// the SQL which undoes it.
+type Migration struct {
+	Version int64
+	Name    string
+	Up      string
+	Down    string
+}
+
+// A Migrator applies migrations to a database.
+type Migrator struct {
+	db         *sql.DB
+	migrations []Migration // in order of version
+}
+
+// New returns a Migrator of the migrations in fsys, which are files named like
+// 000001_create_movies_table.up.sql and 000001_create_movies_table.down.sql.
+func New(db *sql.DB, fsys fs.FS) (*Migrator, error) {
+	files, err := fs.Glob(fsys, "*.sql")
+	if err != nil {
+		return nil, err
+	}
+	byVersion := make(map[int64]*Migration)
+	for _, file := range files {
+		base := strings.TrimSuffix(file, ".sql")
+		direction := base[strings.LastIndex(base, ".")+1:]
+		base = strings.TrimSuffix(base, "."+direction)
+		number, name, ok := strings.Cut(base, "_")
+		version, err := strconv.ParseInt(number, 10, 64)
+		if !ok || err != nil || (direction != "up" && direction != "down") {
+			return nil, fmt.Errorf("migrate: unexpected file name %q", file)
+		}
+		b, err := fs.ReadFile(fsys, file)
+		if err != nil {
+			return nil, err
+		}
+		m := byVersion[version]
+		if m == nil {
+			m = &Migration{Version: version, Name: name}
+			byVersion[version] = m
+		}
+		if direction == "up" {
+			m.Up = string(b)
+		} else {
+			m.Down = string(b)
+		}
+	}
+
+	mg := &Migrator{db: db}
+	for _, m := range byVersion {
+		if m.Up == "" {
+			return nil, fmt.Errorf("migrate: migration %d has no up file", m.Version)
+		}
+		mg.migrations = append(mg.migrations, *m)
+	}
+	sort.Slice(mg.migrations, func(i, j int) bool {
+		return mg.migrations[i].Version < mg.migrations[j].Version
+	})
+	return mg, nil
+}
+
+// Latest returns the version of the newest migration, which the code expects the
+// schema to be at.
+func (mg *Migrator) Latest() int64 {
+	if len(mg.migrations) == 0 {
+		return 0
+	}
+	return mg.migrations[len(mg.migrations)-1].Version
+}
+
+// Version returns the current version of the schema, 0 if it was never migrated, and
+// whether it's dirty.
+func (mg *Migrator) Version(ctx context.Context) (version int64, dirty bool, err error) {
+	err = ensureTable(ctx, mg.db)
+	if err != nil {
+		return 0, false, err
+	}
+	return version, dirty, readVersion(ctx, mg.db, &version, &dirty)
+}
+
+// Up applies the migrations which haven't been yet, and returns the versions applied.
+// Each migration runs in a transaction with the update of the schema version, so a
+// failed migration leaves the schema as it was.
+func (mg *Migrator) Up(ctx context.Context) ([]int64, error) {
+	var applied []int64
+	err := mg.locked(ctx, func(conn *sql.Conn, current int64) error {
+		for _, m := range mg.migrations {
+			if m.Version <= current {
+				continue
+			}
+			err := migrateTx(ctx, conn, m.Up, m.Version)
+			if err != nil {
+				return fmt.Errorf("migrate: migration %d_%s: %w", m.Version, m.Name, err)
+			}
+			applied = append(applied, m.Version)
+		}
+		return nil
+	})
+	return applied, err
+}
+
+// Down undoes the latest applied migration, and returns the version the schema is at
+// afterwards. Only one migration is undone at a time, since each can drop data.
+func (mg *Migrator) Down(ctx context.Context) (int64, error) {
+	var version int64
+	err := mg.locked(ctx, func(conn *sql.Conn, current int64) error {
+		version = current
+		for i := len(mg.migrations) - 1; i >= 0; i-- {
+			m := mg.migrations[i]
+			if m.Version != current {
+				continue
+			}
+			if m.Down == "" {
+				return fmt.Errorf("migrate: migration %d has no down file", m.Version)
+			}
+			var previous int64
+			if i > 0 {
+				previous = mg.migrations[i-1].Version
+			}
+			err := migrateTx(ctx, conn, m.Down, previous)
+			if err != nil {
+				return fmt.Errorf("migrate: undoing migration %d_%s: %w", m.Version, m.Name, err)
+			}
+			version = previous
+			return nil
+		}
+		if current == 0 {
+			return nil
+		}
+		return fmt.Errorf("migrate: the schema is at version %d, which isn't one of the migrations", current)
+	})
+	return version, err
+}
+
+// locked runs fn on a connection holding the migration lock, with the current version
+// of the schema, refusing to go on if it's dirty.
+func (mg *Migrator) locked(ctx context.Context, fn func(conn *sql.Conn, current int64) error) error {
+	conn, err := mg.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	_, err = conn.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, lockKey)
+	if err != nil {
+		return err
+	}
+	defer conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1)`, lockKey)
+
+	err = ensureTable(ctx, conn)
+	if err != nil {
+		return err
+	}
+	var current int64
+	var dirty bool
+	err = readVersion(ctx, conn, &current, &dirty)
+	if err != nil {
+		return err
+	}
+	if dirty {
+		return ErrDirty
+	}
+	return fn(conn, current)
+}
+
+// execer is what's common to *sql.DB and *sql.Conn.
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// ensureTable creates the schema_migrations table if it doesn't exist, in the same form
+// as the migrate tool: one row with the current version.
+func ensureTable(ctx context.Context, db execer) error {
+	_, err := db.ExecContext(ctx, `
+	CREATE TABLE IF NOT EXISTS schema_migrations (
+		version bigint NOT NULL PRIMARY KEY,
+		dirty boolean NOT NULL
+	)`)
+	return err
+}
+
+func readVersion(ctx context.Context, db execer, version *int64, dirty *bool) error {
+	err := db.QueryRowContext(ctx, `SELECT version, dirty FROM schema_migrations LIMIT 1`).Scan(version, dirty)
+	if errors.Is(err, sql.ErrNoRows) {
+		*version, *dirty = 0, false
+		return nil
+	}
+	return err
+}
+
+// migrateTx runs the SQL of a migration and records the new version of the schema in
+// one transaction. Version 0 means the schema has no migrations applied.
+func migrateTx(ctx context.Context, conn *sql.Conn, query string, version int64) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, query)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `DELETE FROM schema_migrations`)
+	if err != nil {
+		return err
+	}
+	if version > 0 {
+		_, err = tx.ExecContext(ctx, `INSERT INTO schema_migrations (version, dirty) VALUES ($1, false)`, version)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
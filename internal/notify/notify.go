@@ -0,0 +1,233 @@
+// Package notify sends notifications, such as "a movie you follow was added", to users
+// over channels such as email or the in-app inbox. The code which triggers a
+// notification only names its type and passes the template data; the Notifier renders
+// it once, and delivers it over the channels its type is routed to, leaving out the
+// ones the user has turned off. Adding a channel, say a Telegram bot, means writing a
+// Channel and routing types to it, without touching the trigger sites.
+package notify
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/shyngys9219/greenlight/internal/mailer"
+)
+
+// The names of the channels the API delivers notifications over.
+const (
+	ChannelEmail = "email"
+	ChannelInApp = "in_app"
+)
+
+// ErrUnknownType is returned when sending a notification of a type which wasn't added
+// to the Notifier.
+var ErrUnknownType = errors.New("unknown notification type")
+
+// A Recipient is the user a notification is for.
+type Recipient struct {
+	UserID int64
+	Name   string
+	Email  string
+}
+
+// A Message is a notification rendered from its template, ready to be delivered. Each
+// channel uses the parts it can: an email all of them, a push notification or text
+// message the subject, the in-app inbox the subject and plain body.
+type Message struct {
+	Type      string
+	Template  string
+	Subject   string
+	PlainBody string
+	HTMLBody  string
+}
+
+// A Channel delivers notifications to users, such as by email.
+type Channel interface {
+	// Name names the channel in routes and user preferences, such as "email".
+	Name() string
+	// Deliver makes the notification reach the recipient, or queues it to. A channel
+	// which can't reach the recipient, such as one who has no phone number for text
+	// messages, skips them and returns nil.
+	Deliver(to Recipient, msg *Message) error
+}
+
+// A Type is a kind of notification, such as "password_reset".
+type Type struct {
+	Name string
+	// Template is the mailer template file the notification is rendered from.
+	Template string
+	// Required notifications, such as password resets, are always delivered over every
+	// channel they're routed to, whatever the user's preferences.
+	Required bool
+	// Channels are the channels the notification is routed to, unless overridden by
+	// Route().
+	Channels []string
+}
+
+// A Renderer renders a template file with the data, as mailer.Mailer does.
+type Renderer interface {
+	Render(templateFile string, data any) (*mailer.Message, error)
+}
+
+// DisabledFunc returns the channels the user has turned off for a type of notification.
+type DisabledFunc func(userID int64, notificationType string) (map[string]bool, error)
+
+// A Notifier routes notifications to channels. Types, channels and routes are set up
+// before it's used, after which it's safe for concurrent use.
+type Notifier struct {
+	renderer Renderer
+	disabled DisabledFunc
+	types    map[string]Type
+	order    []string
+	channels map[string]Channel
+}
+
+// New returns a Notifier rendering notifications with the renderer, which looks the
+// preferences of users up with disabled.
+func New(renderer Renderer, disabled DisabledFunc) *Notifier {
+	return &Notifier{
+		renderer: renderer,
+		disabled: disabled,
+		types:    make(map[string]Type),
+		channels: make(map[string]Channel),
+	}
+}
+
+// AddChannel adds a channel, which types can then be routed to.
+func (n *Notifier) AddChannel(c Channel) {
+	n.channels[c.Name()] = c
+}
+
+// AddType adds a type of notification, routed to its channels.
+func (n *Notifier) AddType(t Type) error {
+	for _, name := range t.Channels {
+		if _, ok := n.channels[name]; !ok {
+			return fmt.Errorf("notification type %s: unknown channel %q", t.Name, name)
+		}
+	}
+	if _, ok := n.types[t.Name]; !ok {
+		n.order = append(n.order, t.Name)
+	}
+	n.types[t.Name] = t
+	return nil
+}
+
+// Route routes a type of notification to the channels, instead of those it was added
+// with. A required type must be routed to at least one channel.
+func (n *Notifier) Route(typeName string, channels []string) error {
+	t, ok := n.types[typeName]
+	if !ok {
+		return fmt.Errorf("%w %q", ErrUnknownType, typeName)
+	}
+	for _, name := range channels {
+		if _, ok := n.channels[name]; !ok {
+			return fmt.Errorf("notification type %s: unknown channel %q", typeName, name)
+		}
+	}
+	if t.Required && len(channels) == 0 {
+		return fmt.Errorf("notification type %s is required, and must be routed to a channel", typeName)
+	}
+	t.Channels = channels
+	n.types[typeName] = t
+	return nil
+}
+
+// Types returns the types of notification, in the order they were added.
+func (n *Notifier) Types() []Type {
+	types := make([]Type, 0, len(n.order))
+	for _, name := range n.order {
+		types = append(types, n.types[name])
+	}
+	return types
+}
+
+// Type returns the type of notification with the name.
+func (n *Notifier) Type(name string) (Type, bool) {
+	t, ok := n.types[name]
+	return t, ok
+}
+
+// Send renders a notification of the type with the data, and delivers it to the
+// recipient over the channels the type is routed to and the recipient hasn't turned
+// off. A channel failing doesn't stop the others being tried; the error returned names
+// each one which failed.
+func (n *Notifier) Send(to Recipient, typeName string, data any) error {
+	t, ok := n.types[typeName]
+	if !ok {
+		return fmt.Errorf("%w %q", ErrUnknownType, typeName)
+	}
+	if len(t.Channels) == 0 {
+		return nil
+	}
+
+	var disabled map[string]bool
+	if !t.Required && n.disabled != nil {
+		var err error
+		disabled, err = n.disabled(to.UserID, t.Name)
+		if err != nil {
+			return err
+		}
+	}
+	channels := make([]Channel, 0, len(t.Channels))
+	for _, name := range t.Channels {
+		if !disabled[name] {
+			channels = append(channels, n.channels[name])
+		}
+	}
+	if len(channels) == 0 {
+		return nil
+	}
+
+	rendered, err := n.renderer.Render(t.Template, data)
+	if err != nil {
+		return err
+	}
+	msg := &Message{
+		Type:      t.Name,
+		Template:  t.Template,
+		Subject:   rendered.Subject,
+		PlainBody: rendered.PlainBody,
+		HTMLBody:  rendered.HTMLBody,
+	}
+
+	var failed []string
+	for _, c := range channels {
+		err := c.Deliver(to, msg)
+		if err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %s", c.Name(), err))
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("notification %s to user %d: %s", t.Name, to.UserID, strings.Join(failed, "; "))
+	}
+	return nil
+}
+
+// ParseRoutes parses a list of routes such as "new_movie=email+in_app,campaign=", which
+// routes new_movie notifications to email and the in-app inbox, and campaign ones
+// nowhere, into channels by type.
+func ParseRoutes(list string) (map[string][]string, error) {
+	routes := make(map[string][]string)
+	for _, route := range strings.Split(list, ",") {
+		route = strings.TrimSpace(route)
+		if route == "" {
+			continue
+		}
+		typeName, channels, ok := strings.Cut(route, "=")
+		typeName = strings.TrimSpace(typeName)
+		if !ok || typeName == "" {
+			return nil, fmt.Errorf("invalid notification route %q, must be type=channel+channel", route)
+		}
+		if _, dup := routes[typeName]; dup {
+			return nil, fmt.Errorf("notification type %s is routed twice", typeName)
+		}
+		routes[typeName] = []string{}
+		for _, name := range strings.Split(channels, "+") {
+			if name = strings.TrimSpace(name); name != "" {
+				routes[typeName] = append(routes[typeName], name)
+			}
+		}
+	}
+	return routes, nil
+}
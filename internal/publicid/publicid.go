@@ -0,0 +1,83 @@
+package publicid
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"time"
+)
+
+// Define constants for the supported ID strategies.
+const (
+	UUID = "uuid" // random (version 4) UUIDs, e.g. 0b4f6c5e-1f0a-4c4e-9d3e-6d1c2b7a8f90
+	ULID = "ulid" // time-ordered ULIDs, e.g. 01HF8Z3Y5QK6S4V7W9X2C1B0NM
+)
+
+var (
+	uuidRX = regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}$`)
+	ulidRX = regexp.MustCompile(`^[0-7][0-9A-HJKMNP-TV-Z]{25}$`)
+)
+
+// A Generator returns a new, unique public identifier each time it's called.
+type Generator func() string
+
+// New returns the Generator for the given strategy.
+func New(strategy string) (Generator, error) {
+	switch strategy {
+	case UUID:
+		return NewUUID, nil
+	case ULID:
+		return NewULID, nil
+	default:
+		return nil, fmt.Errorf("unknown public id strategy %q", strategy)
+	}
+}
+
+// Valid reports whether s looks like a public identifier of either strategy. Both are
+// accepted so that switching strategies doesn't break existing identifiers.
+func Valid(s string) bool {
+	return uuidRX.MatchString(s) || ulidRX.MatchString(s)
+}
+
+// NewUUID returns a random version 4 UUID.
+func NewUUID() string {
+	var b [16]byte
+	mustRead(b[:])
+	b[6] = (b[6] & 0x0f) | 0x40 // version 4
+	b[8] = (b[8] & 0x3f) | 0x80 // RFC 4122 variant
+	h := hex.EncodeToString(b[:])
+	return h[0:8] + "-" + h[8:12] + "-" + h[12:16] + "-" + h[16:20] + "-" + h[20:32]
+}
+
+// crockford is the Crockford base32 alphabet used by ULIDs.
+const crockford = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// NewULID returns a ULID: a 48-bit millisecond timestamp followed by 80 random bits,
+// encoded as 26 Crockford base32 characters. ULIDs sort by creation time.
+func NewULID() string {
+	var b [16]byte
+	binary.BigEndian.PutUint64(b[0:8], uint64(time.Now().UnixMilli())<<16)
+	mustRead(b[6:])
+
+	// Encode the 128 bits five at a time, starting with the 3 most significant bits
+	// on their own (26*5 = 130, so the first character only carries 3 bits).
+	var out [26]byte
+	hi := binary.BigEndian.Uint64(b[0:8])
+	lo := binary.BigEndian.Uint64(b[8:16])
+	for i := 25; i >= 0; i-- {
+		out[i] = crockford[lo&0x1f]
+		lo = lo>>5 | hi<<59
+		hi >>= 5
+	}
+	return string(out[:])
+}
+
+func mustRead(b []byte) {
+	// crypto/rand only fails if the operating system's CSPRNG is broken, in which
+	// case there's nothing sensible we can do.
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+}
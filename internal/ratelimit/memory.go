@@ -0,0 +1,70 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// Memory is a Store which keeps the buckets in the memory of the process. Each replica
+// of the application counts its own requests, so behind a load balancer a client gets
+// the limit from every replica.
+type Memory struct {
+	mu      sync.Mutex
+	clients map[string]*client
+}
+
+type client struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// NewMemory returns an empty Memory store. Buckets which haven't been used for idle
+// are removed, once a minute.
+func NewMemory(idle time.Duration) *Memory {
+	m := &Memory{clients: make(map[string]*client)}
+	go func() {
+		for {
+			time.Sleep(time.Minute)
+			// Lock the mutex to prevent any rate limiter checks from happening while
+			// the cleanup is taking place.
+			m.mu.Lock()
+			for key, c := range m.clients {
+				if time.Since(c.lastSeen) > idle {
+					delete(m.clients, key)
+				}
+			}
+			m.mu.Unlock()
+		}
+	}()
+	return m
+}
+
+func (m *Memory) Allow(ctx context.Context, key string, rps float64, burst int) (Result, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	c, found := m.clients[key]
+	if !found {
+		c = &client{limiter: rate.NewLimiter(rate.Limit(rps), burst)}
+		m.clients[key] = c
+	}
+	c.lastSeen = time.Now()
+	// The limiter is adjusted in place, so a change of the limits applies to clients
+	// we already know about.
+	if c.limiter.Limit() != rate.Limit(rps) || c.limiter.Burst() != burst {
+		c.limiter.SetLimit(rate.Limit(rps))
+		c.limiter.SetBurst(burst)
+	}
+
+	allowed := c.limiter.Allow()
+	tokens := c.limiter.Tokens()
+	return Result{
+		Allowed:   allowed,
+		Burst:     burst,
+		Remaining: tokens,
+		Reset:     reset(tokens, rps, burst),
+	}, nil
+}
@@ -0,0 +1,50 @@
+// Package ratelimit keeps the token buckets of the API's rate limiter. A bucket holds up
+// to burst tokens and refills at rps tokens a second; every request takes a token, and
+// is rejected if there's none left. The buckets live in a Store: in the memory of the
+// process, which is enough for a single replica, or in Redis, so that replicas behind a
+// load balancer share them.
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// A Result is the outcome of taking a token from a bucket.
+type Result struct {
+	Allowed   bool
+	Burst     int           // size of the bucket
+	Remaining float64       // tokens left in the bucket
+	Reset     time.Duration // time until the bucket is full again
+}
+
+// A Store keeps the buckets, by key. Allow takes a token from the bucket of the key,
+// which refills at rps tokens a second up to burst. The rps and burst of a key may
+// change between calls, and apply from then on.
+type Store interface {
+	Allow(ctx context.Context, key string, rps float64, burst int) (Result, error)
+}
+
+// Names of the stores, for the -limiter-store flag.
+const (
+	StoreMemory = "memory"
+	StoreRedis  = "redis"
+)
+
+// ValidStore checks the name of a store.
+func ValidStore(name string) error {
+	switch name {
+	case StoreMemory, StoreRedis:
+		return nil
+	}
+	return fmt.Errorf("unknown rate limiter store %q (want %s|%s)", name, StoreMemory, StoreRedis)
+}
+
+// reset returns how long a bucket with the given number of tokens takes to fill up.
+func reset(tokens, rps float64, burst int) time.Duration {
+	if rps <= 0 || tokens >= float64(burst) {
+		return 0
+	}
+	return time.Duration((float64(burst) - tokens) / rps * float64(time.Second))
+}
@@ -0,0 +1,79 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Redis is a Store which keeps the buckets in Redis, shared by every replica of the
+// application.
+//
+// The buckets are kept with the generic cell rate algorithm (GCRA), which stores a
+// single timestamp per key: the theoretical arrival time (TAT) at which the bucket
+// would be full again. A request is allowed if taking a token wouldn't push the TAT more
+// than burst emission intervals into the future. The check and the update are made by a
+// Lua script, which Redis runs atomically, with the clock of the Redis server, so the
+// clocks of the replicas don't matter. Keys expire as soon as their bucket is full.
+type Redis struct {
+	client *redis.Client
+	prefix string
+}
+
+// NewRedis returns a store which keeps the buckets in Redis, under keys beginning with
+// prefix.
+func NewRedis(client *redis.Client, prefix string) *Redis {
+	return &Redis{client: client, prefix: prefix}
+}
+
+// gcraScript takes a token from a bucket. KEYS[1] is the key of the bucket, ARGV[1]
+// the emission interval in seconds (1/rps) and ARGV[2] the burst. It returns whether the
+// request is allowed, and the tokens left and the seconds until the bucket is full,
+// as strings since Redis truncates Lua numbers to integers.
+var gcraScript = redis.NewScript(`
+redis.replicate_commands()
+local interval = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local time = redis.call('TIME')
+local now = tonumber(time[1]) + tonumber(time[2]) / 1000000
+
+local tat = tonumber(redis.call('GET', KEYS[1]) or now)
+if tat < now then
+  tat = now
+end
+local capacity = interval * burst
+
+local allowed = 0
+if tat + interval - capacity <= now then
+  allowed = 1
+  tat = tat + interval
+  redis.call('SET', KEYS[1], tostring(tat), 'PX', math.ceil((tat - now) * 1000))
+end
+return {allowed, tostring((now + capacity - tat) / interval), tostring(tat - now)}
+`)
+
+func (s *Redis) Allow(ctx context.Context, key string, rps float64, burst int) (Result, error) {
+	// A bucket which never refills can't be kept with GCRA; there's nothing for it but
+	// to reject the request.
+	if rps <= 0 {
+		return Result{Burst: burst}, nil
+	}
+
+	res, err := gcraScript.Run(ctx, s.client, []string{s.prefix + key}, 1/rps, burst).Slice()
+	if err != nil {
+		return Result{}, err
+	}
+	allowed, _ := res[0].(int64)
+	remaining, _ := strconv.ParseFloat(fmt.Sprint(res[1]), 64)
+	resetSeconds, _ := strconv.ParseFloat(fmt.Sprint(res[2]), 64)
+	return Result{
+		Allowed:   allowed == 1,
+		Burst:     burst,
+		Remaining: math.Max(remaining, 0),
+		Reset:     time.Duration(resetSeconds * float64(time.Second)),
+	}, nil
+}
@@ -0,0 +1,50 @@
+// Package storage keeps the files users upload, such as movie posters, in an
+// S3-compatible bucket, or on local disk in development. Like the billing and enrich
+// packages, it has no SDK dependency; the few S3 calls are made over plain HTTP.
+package storage
+
+import (
+	"context"
+	"errors"
+	"strings"
+)
+
+// Backend names, for the -storage flag.
+const (
+	BackendLocal = "local"
+	BackendS3    = "s3"
+)
+
+// ErrInvalidKey is returned for keys which could escape the store, such as ones with
+// ".." in them.
+var ErrInvalidKey = errors.New("invalid storage key")
+
+// A Store keeps files by key, such as "posters/3f9a.jpg", and serves them from a
+// public URL.
+type Store interface {
+	// Put stores the content under the key, replacing the file there if there is one,
+	// and returns the URL it's served from.
+	Put(ctx context.Context, key, contentType string, content []byte) (string, error)
+	// Delete removes the file under the key. Deleting a file which doesn't exist isn't
+	// an error.
+	Delete(ctx context.Context, key string) error
+}
+
+// The validKey() function reports whether a key is a relative, slash separated path
+// which stays inside the store.
+func validKey(key string) bool {
+	if key == "" || strings.HasPrefix(key, "/") || strings.Contains(key, "\\") {
+		return false
+	}
+	for _, part := range strings.Split(key, "/") {
+		if part == "" || part == "." || part == ".." {
+			return false
+		}
+	}
+	return true
+}
+
+// The publicURL() function returns the URL of a key under a base URL.
+func publicURL(baseURL, key string) string {
+	return strings.TrimSuffix(baseURL, "/") + "/" + key
+}
@@ -0,0 +1,117 @@
+package tracing
+
+import (
+	"context"
+	"database/sql/driver"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracerName names the tracer of the database spans.
+const tracerName = "github.com/shyngys9219/greenlight/internal/tracing"
+
+// WrapDriver returns a driver which records a span for every query and statement
+// executed through d, as a child of the span carried by the query's context. Queries
+// whose context doesn't carry a span, such as those of the background jobs, aren't
+// traced, so that they don't each start a trace of their own. The wrapped driver must
+// support the context-aware driver interfaces, as lib/pq does.
+func WrapDriver(d driver.Driver) driver.Driver {
+	return &wrappedDriver{d}
+}
+
+type wrappedDriver struct {
+	driver.Driver
+}
+
+func (d *wrappedDriver) Open(name string) (driver.Conn, error) {
+	c, err := d.Driver.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return &conn{c}, nil
+}
+
+// startSpan starts the span of a query, or returns nil if ctx doesn't carry a span.
+// The queries are all written with placeholders, so their text is safe to record: the
+// values never are.
+func startSpan(ctx context.Context, query string) (context.Context, trace.Span) {
+	if !trace.SpanContextFromContext(ctx).IsValid() {
+		return ctx, nil
+	}
+	return otel.Tracer(tracerName).Start(ctx, "db.query",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("db.system", "postgresql"),
+			attribute.String("db.statement", query),
+		),
+	)
+}
+
+// endSpan ends the span of a query, if there is one, marking it failed if err is set.
+// driver.ErrSkip isn't a failure: database/sql retries the query another way.
+func endSpan(span trace.Span, err error) {
+	if span == nil {
+		return
+	}
+	if err != nil && err != driver.ErrSkip {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+type conn struct {
+	driver.Conn
+}
+
+func (c *conn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	s, err := c.Conn.(driver.ConnPrepareContext).PrepareContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	return &stmt{s, query}, nil
+}
+
+func (c *conn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	return c.Conn.(driver.ConnBeginTx).BeginTx(ctx, opts)
+}
+
+func (c *conn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	ctx, span := startSpan(ctx, query)
+	r, err := c.Conn.(driver.QueryerContext).QueryContext(ctx, query, args)
+	endSpan(span, err)
+	return r, err
+}
+
+func (c *conn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	ctx, span := startSpan(ctx, query)
+	r, err := c.Conn.(driver.ExecerContext).ExecContext(ctx, query, args)
+	endSpan(span, err)
+	return r, err
+}
+
+func (c *conn) Ping(ctx context.Context) error {
+	return c.Conn.(driver.Pinger).Ping(ctx)
+}
+
+type stmt struct {
+	driver.Stmt
+	query string
+}
+
+func (s *stmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	ctx, span := startSpan(ctx, s.query)
+	r, err := s.Stmt.(driver.StmtQueryContext).QueryContext(ctx, args)
+	endSpan(span, err)
+	return r, err
+}
+
+func (s *stmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	ctx, span := startSpan(ctx, s.query)
+	r, err := s.Stmt.(driver.StmtExecContext).ExecContext(ctx, args)
+	endSpan(span, err)
+	return r, err
+}
@@ -0,0 +1,68 @@
+// Package tracing sets up OpenTelemetry tracing, with the spans exported to an OTLP
+// collector over HTTP, and provides a database/sql driver wrapper which records a span
+// for every query.
+//
+// The rest of the application starts its spans with otel.Tracer(). Until Start() is
+// called that returns a tracer which doesn't record anything, so the spans cost next
+// to nothing when tracing is off.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+)
+
+// Config holds the settings of the tracing.
+type Config struct {
+	// Endpoint is the URL of the collector's OTLP/HTTP receiver, such as
+	// http://localhost:4318. The spans are sent to its /v1/traces path, unless the
+	// URL has a path of its own.
+	Endpoint       string
+	ServiceName    string
+	ServiceVersion string
+	// SampleRatio is the fraction of traces recorded, from 0 to 1. A request which is
+	// part of a trace started upstream follows the upstream's decision instead.
+	SampleRatio float64
+}
+
+// Start makes the exporter and the tracer provider of the config, and installs them as
+// the global ones, with the W3C Trace Context propagator. It doesn't connect to the
+// collector. The returned function flushes the spans which haven't been exported yet
+// and stops the provider; it should be called before the program exits.
+func Start(cfg Config) (func(context.Context) error, error) {
+	u, err := url.Parse(cfg.Endpoint)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid OTLP endpoint %q: must be an http or https URL", cfg.Endpoint)
+	}
+	opts := []otlptracehttp.Option{otlptracehttp.WithEndpoint(u.Host)}
+	if u.Scheme == "http" {
+		opts = append(opts, otlptracehttp.WithInsecure())
+	}
+	if u.Path != "" && u.Path != "/" {
+		opts = append(opts, otlptracehttp.WithURLPath(u.Path))
+	}
+	exporter, err := otlptracehttp.New(context.Background(), opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL,
+			semconv.ServiceName(cfg.ServiceName),
+			semconv.ServiceVersion(cfg.ServiceVersion),
+		)),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.SampleRatio))),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	return provider.Shutdown, nil
+}
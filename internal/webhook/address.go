@@ -0,0 +1,82 @@
+package webhook
+
+import (
+	"context"
+	"errors"
+	"net"
+	"syscall"
+)
+
+// ErrNonPublicAddress is returned for a host which is, or resolves to, an address off
+// the public internet, such as a loopback, private or link-local one. Deliveries are
+// never made to those, so that a subscription can't be used to reach the API's own
+// network.
+var ErrNonPublicAddress = errors.New("webhook: host is not a public address")
+
+// nonPublicNets are the ranges which are neither private, loopback nor link-local but
+// still aren't reachable on the public internet.
+var nonPublicNets = func() []*net.IPNet {
+	var nets []*net.IPNet
+	for _, cidr := range []string{
+		"0.0.0.0/8",     // "this network"
+		"100.64.0.0/10", // carrier-grade NAT
+		"192.0.0.0/24",  // IETF protocol assignments
+		"198.18.0.0/15", // benchmarking
+		"240.0.0.0/4",   // reserved
+		"64:ff9b::/96",  // NAT64, which would reach any IPv4 address
+	} {
+		_, n, _ := net.ParseCIDR(cidr)
+		nets = append(nets, n)
+	}
+	return nets
+}()
+
+// PublicIP reports whether ip is an address on the public internet.
+func PublicIP(ip net.IP) bool {
+	if !ip.IsGlobalUnicast() || ip.IsPrivate() {
+		return false
+	}
+	for _, n := range nonPublicNets {
+		if n.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// CheckHost resolves the host of a URL and returns ErrNonPublicAddress if any of its
+// addresses isn't public. Deliveries check each address again as they connect, since
+// the host can resolve to something else by then.
+func CheckHost(ctx context.Context, host string) error {
+	if ip := net.ParseIP(host); ip != nil {
+		if !PublicIP(ip) {
+			return ErrNonPublicAddress
+		}
+		return nil
+	}
+
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return err
+	}
+	for _, addr := range addrs {
+		if !PublicIP(addr.IP) {
+			return ErrNonPublicAddress
+		}
+	}
+	return nil
+}
+
+// The dialControl() function is the Control of the Sender's dialer. It's called with
+// the address being connected to once the host has been resolved, so it refuses the
+// connection whatever the host resolved to at validation.
+func dialControl(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); ip == nil || !PublicIP(ip) {
+		return ErrNonPublicAddress
+	}
+	return nil
+}
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS screening_invites;
+DROP TABLE IF EXISTS screenings;
@@ -0,0 +1,20 @@
+CREATE TABLE IF NOT EXISTS screenings (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    movie_id bigint NOT NULL REFERENCES movies ON DELETE CASCADE,
+    host_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    starts_at timestamp(0) with time zone NOT NULL,
+    note text NOT NULL DEFAULT '',
+    -- set once the reminder emails have gone out, so they are only sent once
+    reminder_sent bool NOT NULL DEFAULT false,
+    version integer NOT NULL DEFAULT 1
+);
+
+CREATE INDEX IF NOT EXISTS screenings_starts_at_idx ON screenings (starts_at) WHERE NOT reminder_sent;
+
+CREATE TABLE IF NOT EXISTS screening_invites (
+    screening_id bigint NOT NULL REFERENCES screenings ON DELETE CASCADE,
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    rsvp text NOT NULL DEFAULT 'pending' CHECK (rsvp IN ('pending', 'yes', 'no', 'maybe')),
+    PRIMARY KEY (screening_id, user_id)
+);
@@ -0,0 +1,11 @@
+-- A follow is either a genre (value holds the genre name) or a person from the actors
+-- table (value holds the actor's id).
+CREATE TABLE IF NOT EXISTS follows (
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    kind text NOT NULL CHECK (kind IN ('genre', 'person')),
+    value text NOT NULL,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (user_id, kind, value)
+);
+
+CREATE INDEX IF NOT EXISTS follows_kind_value_idx ON follows (kind, value);
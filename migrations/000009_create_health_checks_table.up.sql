@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS health_checks (
+    id bigserial PRIMARY KEY,
+    checked_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    status text NOT NULL,
+    -- per-dependency status, latency and error, keyed by dependency name
+    checks jsonb NOT NULL DEFAULT '{}'
+);
+
+CREATE INDEX IF NOT EXISTS health_checks_checked_at_idx ON health_checks (checked_at);
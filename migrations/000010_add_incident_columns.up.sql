@@ -0,0 +1,5 @@
+-- when a token was issued, so tokens can be revoked by issue time
+ALTER TABLE tokens ADD COLUMN IF NOT EXISTS created_at timestamp(0) with time zone NOT NULL DEFAULT NOW();
+
+-- set on accounts which must choose a new password before they can log in again
+ALTER TABLE users ADD COLUMN IF NOT EXISTS password_reset_required bool NOT NULL DEFAULT false;
@@ -0,0 +1,2 @@
+-- set once the "your activation link is about to expire" reminder has been sent
+ALTER TABLE users ADD COLUMN IF NOT EXISTS activation_reminder_sent bool NOT NULL DEFAULT false;
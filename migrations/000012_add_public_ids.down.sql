@@ -0,0 +1,2 @@
+ALTER TABLE users DROP COLUMN IF EXISTS public_id;
+ALTER TABLE movies DROP COLUMN IF EXISTS public_id;
@@ -0,0 +1,9 @@
+-- Public identifiers exposed in URLs instead of the sequential ids, so records can't be
+-- enumerated. New rows get an id generated by the application (UUID or ULID); the
+-- default is only there to backfill existing rows. gen_random_uuid() is built in
+-- from PostgreSQL 13.
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS public_id text NOT NULL DEFAULT gen_random_uuid()::text;
+ALTER TABLE movies ADD CONSTRAINT movies_public_id_key UNIQUE (public_id);
+
+ALTER TABLE users ADD COLUMN IF NOT EXISTS public_id text NOT NULL DEFAULT gen_random_uuid()::text;
+ALTER TABLE users ADD CONSTRAINT users_public_id_key UNIQUE (public_id);
@@ -0,0 +1,10 @@
+-- When a duplicate movie is merged into another one, its old ids are kept here so that
+-- links to the duplicate keep working. old_id no longer exists in the movies table.
+CREATE TABLE IF NOT EXISTS movie_redirects (
+    old_id bigint PRIMARY KEY,
+    old_public_id text NOT NULL UNIQUE,
+    new_id bigint NOT NULL REFERENCES movies ON DELETE CASCADE,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS movie_redirects_new_id_idx ON movie_redirects (new_id);
@@ -0,0 +1,7 @@
+-- Columns used by SCIM provisioning. A deactivated (not active) user can't log in or
+-- use their tokens, but unlike an unactivated one is never cleaned up automatically.
+-- external_id is the identity provider's own id for the user, if it has sent one.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS active bool NOT NULL DEFAULT true;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS external_id text NOT NULL DEFAULT '';
+
+CREATE UNIQUE INDEX IF NOT EXISTS users_external_id_idx ON users (external_id) WHERE external_id <> '';
@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS organization_watchlist;
+DROP TABLE IF EXISTS api_keys;
+DROP TABLE IF EXISTS organization_members;
+DROP TABLE IF EXISTS organizations;
@@ -0,0 +1,41 @@
+-- Organizations (for example film societies) share a catalog between several
+-- curators. Members have one of three roles; API keys belong to the organization
+-- rather than to any one member.
+CREATE TABLE IF NOT EXISTS organizations (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    name text NOT NULL,
+    slug text NOT NULL UNIQUE,
+    version integer NOT NULL DEFAULT 1
+);
+
+CREATE TABLE IF NOT EXISTS organization_members (
+    organization_id bigint NOT NULL REFERENCES organizations ON DELETE CASCADE,
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    role text NOT NULL CHECK (role IN ('owner', 'editor', 'viewer')),
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (organization_id, user_id)
+);
+
+CREATE INDEX IF NOT EXISTS organization_members_user_id_idx ON organization_members (user_id);
+
+CREATE TABLE IF NOT EXISTS api_keys (
+    id bigserial PRIMARY KEY,
+    organization_id bigint NOT NULL REFERENCES organizations ON DELETE CASCADE,
+    name text NOT NULL,
+    hash bytea NOT NULL UNIQUE,
+    role text NOT NULL CHECK (role IN ('editor', 'viewer')),
+    created_by bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    -- the zero time means the key has never been used
+    last_used_at timestamp(0) with time zone NOT NULL DEFAULT 'epoch'
+);
+
+CREATE TABLE IF NOT EXISTS organization_watchlist (
+    organization_id bigint NOT NULL REFERENCES organizations ON DELETE CASCADE,
+    movie_id bigint NOT NULL REFERENCES movies ON DELETE CASCADE,
+    note text NOT NULL DEFAULT '',
+    added_by bigint REFERENCES users ON DELETE SET NULL,
+    added_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (organization_id, movie_id)
+);
@@ -0,0 +1,9 @@
+DROP TABLE IF EXISTS plan_usage;
+
+ALTER TABLE organizations DROP COLUMN IF EXISTS plan_expires_at;
+ALTER TABLE organizations DROP COLUMN IF EXISTS plan_source;
+ALTER TABLE organizations DROP COLUMN IF EXISTS plan;
+
+ALTER TABLE users DROP COLUMN IF EXISTS plan_expires_at;
+ALTER TABLE users DROP COLUMN IF EXISTS plan_source;
+ALTER TABLE users DROP COLUMN IF EXISTS plan;
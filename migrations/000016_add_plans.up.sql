@@ -0,0 +1,20 @@
+-- Users and organizations are each on a plan, which decides their entitlements. A
+-- plan with an expiry (a trial, or a lapsed subscription) falls back to free once it
+-- has passed; plan_source records who assigned it (admin, trial or a billing
+-- provider).
+ALTER TABLE users ADD COLUMN IF NOT EXISTS plan text NOT NULL DEFAULT 'free';
+ALTER TABLE users ADD COLUMN IF NOT EXISTS plan_source text NOT NULL DEFAULT '';
+ALTER TABLE users ADD COLUMN IF NOT EXISTS plan_expires_at timestamp(0) with time zone;
+
+ALTER TABLE organizations ADD COLUMN IF NOT EXISTS plan text NOT NULL DEFAULT 'free';
+ALTER TABLE organizations ADD COLUMN IF NOT EXISTS plan_source text NOT NULL DEFAULT '';
+ALTER TABLE organizations ADD COLUMN IF NOT EXISTS plan_expires_at timestamp(0) with time zone;
+
+-- Requests counted against each account's daily quota.
+CREATE TABLE IF NOT EXISTS plan_usage (
+    account_kind text NOT NULL,
+    account_id bigint NOT NULL,
+    day date NOT NULL,
+    requests integer NOT NULL DEFAULT 0,
+    PRIMARY KEY (account_kind, account_id, day)
+);
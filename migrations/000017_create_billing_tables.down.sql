@@ -0,0 +1,7 @@
+DROP TABLE IF EXISTS billing_events;
+
+DROP INDEX IF EXISTS organizations_billing_customer_id_idx;
+DROP INDEX IF EXISTS users_billing_customer_id_idx;
+
+ALTER TABLE organizations DROP COLUMN IF EXISTS billing_customer_id;
+ALTER TABLE users DROP COLUMN IF EXISTS billing_customer_id;
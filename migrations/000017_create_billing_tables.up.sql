@@ -0,0 +1,14 @@
+-- The billing provider's customer id of each account which has ever subscribed.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS billing_customer_id text NOT NULL DEFAULT '';
+ALTER TABLE organizations ADD COLUMN IF NOT EXISTS billing_customer_id text NOT NULL DEFAULT '';
+
+CREATE INDEX IF NOT EXISTS users_billing_customer_id_idx ON users (billing_customer_id) WHERE billing_customer_id <> '';
+CREATE INDEX IF NOT EXISTS organizations_billing_customer_id_idx ON organizations (billing_customer_id) WHERE billing_customer_id <> '';
+
+-- Webhook events which have been processed, so that redelivered events are only
+-- applied once.
+CREATE TABLE IF NOT EXISTS billing_events (
+    id text PRIMARY KEY,
+    type text NOT NULL,
+    received_at timestamp(0) with time zone NOT NULL DEFAULT NOW()
+);
@@ -0,0 +1,14 @@
+-- Incident notes shown on the public status page. components lists the affected
+-- components (api, database, email).
+CREATE TABLE IF NOT EXISTS status_incidents (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    updated_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    title text NOT NULL,
+    message text NOT NULL DEFAULT '',
+    status text NOT NULL DEFAULT 'investigating',
+    components text[] NOT NULL DEFAULT '{}',
+    version integer NOT NULL DEFAULT 1
+);
+
+CREATE INDEX IF NOT EXISTS status_incidents_updated_at_idx ON status_incidents (updated_at);
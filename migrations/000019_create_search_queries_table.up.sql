@@ -0,0 +1,11 @@
+-- One row per search, for the search analytics report. Users are only stored as a
+-- keyed hash, and queries are normalized (lowercased, with whitespace collapsed).
+CREATE TABLE IF NOT EXISTS search_queries (
+    id bigserial PRIMARY KEY,
+    searched_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    user_hash text NOT NULL DEFAULT '',
+    query text NOT NULL,
+    results integer NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS search_queries_searched_at_idx ON search_queries (searched_at);
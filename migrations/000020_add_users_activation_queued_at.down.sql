@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS users_activation_queued_at_idx;
+ALTER TABLE users DROP COLUMN IF EXISTS activation_queued_at;
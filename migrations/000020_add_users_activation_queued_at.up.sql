@@ -0,0 +1,6 @@
+-- activation_queued_at is set on imported users whose activation email hasn't been
+-- sent yet. The emails go out in batches, oldest first, and the column is cleared as
+-- each one is sent.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS activation_queued_at timestamp(0) with time zone;
+
+CREATE INDEX IF NOT EXISTS users_activation_queued_at_idx ON users (activation_queued_at) WHERE activation_queued_at IS NOT NULL;
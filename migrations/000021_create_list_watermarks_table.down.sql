@@ -0,0 +1,8 @@
+DROP TRIGGER IF EXISTS movies_watchlists_watermark ON movies;
+DROP TRIGGER IF EXISTS organization_watchlist_watermark ON organization_watchlist;
+DROP TRIGGER IF EXISTS follows_watermark ON follows;
+DROP FUNCTION IF EXISTS touch_movie_watchlists_watermark();
+DROP FUNCTION IF EXISTS touch_watchlist_watermark();
+DROP FUNCTION IF EXISTS touch_follows_watermark();
+DROP FUNCTION IF EXISTS touch_list_watermark(text);
+DROP TABLE IF EXISTS list_watermarks;
@@ -0,0 +1,75 @@
+-- list_watermarks holds, for each listing clients poll, when its contents last
+-- changed. It backs the Last-Modified header of the listing: a listing's own rows
+-- can't tell when one of them was removed, so the watermark is kept up to date by
+-- triggers, which also catch cascading deletes and changes made by merges. Listings are
+-- keyed by kind and owner, for example 'follows:42' or 'watchlist:7'.
+CREATE TABLE IF NOT EXISTS list_watermarks (
+    list text PRIMARY KEY,
+    changed_at timestamp with time zone NOT NULL
+);
+
+CREATE OR REPLACE FUNCTION touch_list_watermark(list_key text) RETURNS void AS $$
+    INSERT INTO list_watermarks (list, changed_at)
+    VALUES (list_key, clock_timestamp())
+    ON CONFLICT (list) DO UPDATE SET changed_at = EXCLUDED.changed_at;
+$$ LANGUAGE sql;
+
+CREATE OR REPLACE FUNCTION touch_follows_watermark() RETURNS trigger AS $$
+BEGIN
+    IF TG_OP <> 'INSERT' THEN
+        PERFORM touch_list_watermark('follows:' || OLD.user_id);
+    END IF;
+    IF TG_OP <> 'DELETE' THEN
+        PERFORM touch_list_watermark('follows:' || NEW.user_id);
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS follows_watermark ON follows;
+CREATE TRIGGER follows_watermark
+AFTER INSERT OR UPDATE OR DELETE ON follows
+FOR EACH ROW EXECUTE FUNCTION touch_follows_watermark();
+
+CREATE OR REPLACE FUNCTION touch_watchlist_watermark() RETURNS trigger AS $$
+BEGIN
+    IF TG_OP <> 'INSERT' THEN
+        PERFORM touch_list_watermark('watchlist:' || OLD.organization_id);
+    END IF;
+    IF TG_OP <> 'DELETE' THEN
+        PERFORM touch_list_watermark('watchlist:' || NEW.organization_id);
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS organization_watchlist_watermark ON organization_watchlist;
+CREATE TRIGGER organization_watchlist_watermark
+AFTER INSERT OR UPDATE OR DELETE ON organization_watchlist
+FOR EACH ROW EXECUTE FUNCTION touch_watchlist_watermark();
+
+-- Watchlists show the title and year of their movies, so editing a movie changes every
+-- watchlist it's on.
+CREATE OR REPLACE FUNCTION touch_movie_watchlists_watermark() RETURNS trigger AS $$
+BEGIN
+    PERFORM touch_list_watermark('watchlist:' || organization_id)
+    FROM organization_watchlist
+    WHERE movie_id = NEW.id;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS movies_watchlists_watermark ON movies;
+CREATE TRIGGER movies_watchlists_watermark
+AFTER UPDATE OF title, year ON movies
+FOR EACH ROW EXECUTE FUNCTION touch_movie_watchlists_watermark();
+
+-- Start the existing listings off with a watermark, so they get a Last-Modified header
+-- before they next change.
+INSERT INTO list_watermarks (list, changed_at)
+SELECT DISTINCT 'follows:' || user_id, NOW() FROM follows
+ON CONFLICT DO NOTHING;
+
+INSERT INTO list_watermarks (list, changed_at)
+SELECT DISTINCT 'watchlist:' || organization_id, NOW() FROM organization_watchlist
+ON CONFLICT DO NOTHING;
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS campaign_suppressions;
+DROP TABLE IF EXISTS campaign_sends;
+ALTER TABLE users DROP COLUMN IF EXISTS last_active_at;
@@ -0,0 +1,27 @@
+-- last_active_at is when the user last logged in. The re-engagement campaign emails
+-- users who haven't logged in for a while.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS last_active_at timestamp(0) with time zone NOT NULL DEFAULT NOW();
+
+-- One row per campaign email sent, recording the step of the campaign's sequence it
+-- was. The user is cleared rather than the row deleted when the user is deleted, so
+-- the emails are still counted in the campaign analytics.
+CREATE TABLE IF NOT EXISTS campaign_sends (
+    id bigserial PRIMARY KEY,
+    campaign text NOT NULL,
+    step integer NOT NULL,
+    user_id bigint REFERENCES users ON DELETE SET NULL,
+    sent_at timestamp(0) with time zone NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS campaign_sends_user_id_idx ON campaign_sends (user_id, campaign, step);
+CREATE INDEX IF NOT EXISTS campaign_sends_sent_at_idx ON campaign_sends (sent_at);
+
+-- Users who mustn't be sent a campaign's emails, for example because they
+-- unsubscribed. The campaign 'all' suppresses every campaign.
+CREATE TABLE IF NOT EXISTS campaign_suppressions (
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    campaign text NOT NULL,
+    reason text NOT NULL,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (user_id, campaign)
+);
@@ -0,0 +1,12 @@
+-- Localized titles and overviews of movies. The title in the movies table is the
+-- original title, which is served when there's no translation for any of the client's
+-- languages.
+CREATE TABLE IF NOT EXISTS movie_translations (
+    movie_id bigint NOT NULL REFERENCES movies ON DELETE CASCADE,
+    locale text NOT NULL,
+    title text NOT NULL,
+    overview text NOT NULL DEFAULT '',
+    updated_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    version integer NOT NULL DEFAULT 1,
+    PRIMARY KEY (movie_id, locale)
+);
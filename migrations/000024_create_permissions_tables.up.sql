@@ -0,0 +1,30 @@
+-- Permissions are codes such as 'movies:write' which routes can require. Users are
+-- granted them through users_permissions.
+CREATE TABLE IF NOT EXISTS permissions (
+    id bigserial PRIMARY KEY,
+    code text NOT NULL UNIQUE
+);
+
+CREATE TABLE IF NOT EXISTS users_permissions (
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    permission_id bigint NOT NULL REFERENCES permissions ON DELETE CASCADE,
+    PRIMARY KEY (user_id, permission_id)
+);
+
+INSERT INTO permissions (code)
+VALUES
+    ('movies:read'),
+    ('movies:write'),
+    ('admin:billing'),
+    ('admin:data'),
+    ('admin:health'),
+    ('admin:security'),
+    ('admin:users'),
+    ('scim:users')
+ON CONFLICT (code) DO NOTHING;
+
+-- Existing users keep being able to read the catalog.
+INSERT INTO users_permissions (user_id, permission_id)
+SELECT users.id, permissions.id FROM users, permissions
+WHERE permissions.code = 'movies:read'
+ON CONFLICT DO NOTHING;
@@ -0,0 +1,6 @@
+-- updated_at is when the movie was last changed, used as the lastmod of its page in
+-- the sitemaps. Existing movies count as unchanged since they were created.
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS updated_at timestamp(0) with time zone;
+UPDATE movies SET updated_at = created_at WHERE updated_at IS NULL;
+ALTER TABLE movies ALTER COLUMN updated_at SET DEFAULT NOW();
+ALTER TABLE movies ALTER COLUMN updated_at SET NOT NULL;
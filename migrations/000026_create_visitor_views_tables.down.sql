@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS user_movie_views;
+DROP TABLE IF EXISTS visitor_views;
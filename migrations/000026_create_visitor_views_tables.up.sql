@@ -0,0 +1,20 @@
+-- Movies viewed by anonymous visitors who opted in to personalization, keyed by the
+-- SHA-256 hash of the visitor ID in their cookie. Views are deleted once they're older
+-- than the retention period.
+CREATE TABLE IF NOT EXISTS visitor_views (
+    visitor_hash bytea NOT NULL,
+    movie_id bigint NOT NULL REFERENCES movies ON DELETE CASCADE,
+    viewed_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (visitor_hash, movie_id)
+);
+
+CREATE INDEX IF NOT EXISTS visitor_views_viewed_at_idx ON visitor_views (viewed_at);
+
+-- The browsing history visitors brought with them when they signed up, which seeds
+-- their recommendations.
+CREATE TABLE IF NOT EXISTS user_movie_views (
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    movie_id bigint NOT NULL REFERENCES movies ON DELETE CASCADE,
+    viewed_at timestamp(0) with time zone NOT NULL,
+    PRIMARY KEY (user_id, movie_id)
+);
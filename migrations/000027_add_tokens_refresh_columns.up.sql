@@ -0,0 +1,8 @@
+-- Tokens issued from the same login share a family: its access tokens and the chain of
+-- refresh tokens which replaced each other. used_at is set when a refresh token is
+-- exchanged; a used refresh token is kept until it expires, so that reusing it can be
+-- detected.
+ALTER TABLE tokens ADD COLUMN IF NOT EXISTS family bytea;
+ALTER TABLE tokens ADD COLUMN IF NOT EXISTS used_at timestamp(0) with time zone;
+
+CREATE INDEX IF NOT EXISTS tokens_family_idx ON tokens (family) WHERE family IS NOT NULL;
@@ -0,0 +1,4 @@
+-- Indexes for the filters of GET /v1/movies: full-text matching on the title, and
+-- containment of the requested genres.
+CREATE INDEX IF NOT EXISTS movies_title_idx ON movies USING GIN (to_tsvector('simple', title));
+CREATE INDEX IF NOT EXISTS movies_genres_idx ON movies USING GIN (genres);
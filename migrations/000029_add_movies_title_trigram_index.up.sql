@@ -0,0 +1,4 @@
+-- The trigram index backs the fuzzy title search of GET /v1/movies, which is only used
+-- when the API runs with -search-trigram.
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+CREATE INDEX IF NOT EXISTS movies_title_trgm_idx ON movies USING GIN (title gin_trgm_ops);
@@ -0,0 +1,16 @@
+-- Overrides of the global rate limit for single principals: a client IP address, a user
+-- or an organization API key. Principals are stored as text (the address, or the ID of
+-- the user or key), so the table can hold all three.
+CREATE TABLE IF NOT EXISTS rate_limit_overrides (
+    id bigserial PRIMARY KEY,
+    kind text NOT NULL CHECK (kind IN ('ip', 'user', 'api_key')),
+    principal text NOT NULL,
+    mode text NOT NULL CHECK (mode IN ('unlimited', 'limit', 'block')),
+    rps double precision NOT NULL DEFAULT 0,
+    burst integer NOT NULL DEFAULT 0,
+    reason text NOT NULL DEFAULT '',
+    created_by bigint REFERENCES users ON DELETE SET NULL,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    expires_at timestamp(0) with time zone,
+    UNIQUE (kind, principal)
+);
@@ -0,0 +1,5 @@
+-- Indexes for keyset pagination of GET /v1/movies, which seeks on the sort column
+-- together with the id. Sorting by id alone uses the primary key.
+CREATE INDEX IF NOT EXISTS movies_title_id_idx ON movies (title, id);
+CREATE INDEX IF NOT EXISTS movies_year_id_idx ON movies (year, id);
+CREATE INDEX IF NOT EXISTS movies_runtime_id_idx ON movies (runtime, id);
@@ -0,0 +1,18 @@
+-- Users' reviews of movies, at most one per user per movie. The average rating and
+-- review count of each movie are kept on the movies table, so listings don't have to
+-- aggregate the reviews; they're updated in the same transaction as the reviews.
+CREATE TABLE IF NOT EXISTS reviews (
+    id bigserial PRIMARY KEY,
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    movie_id bigint NOT NULL REFERENCES movies ON DELETE CASCADE,
+    rating smallint NOT NULL CHECK (rating BETWEEN 1 AND 10),
+    body text NOT NULL DEFAULT '',
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    updated_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    UNIQUE (user_id, movie_id)
+);
+
+CREATE INDEX IF NOT EXISTS reviews_movie_id_idx ON reviews (movie_id, created_at);
+
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS average_rating numeric(4, 2);
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS review_count integer NOT NULL DEFAULT 0;
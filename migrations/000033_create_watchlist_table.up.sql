@@ -0,0 +1,12 @@
+-- Users' own watchlists: movies they saved to watch later, and whether they have
+-- watched them since. Organizations' shared watchlists are in organization_watchlist.
+CREATE TABLE IF NOT EXISTS watchlist (
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    movie_id bigint NOT NULL REFERENCES movies ON DELETE CASCADE,
+    watched boolean NOT NULL DEFAULT false,
+    added_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    watched_at timestamp(0) with time zone,
+    PRIMARY KEY (user_id, movie_id)
+);
+
+CREATE INDEX IF NOT EXISTS watchlist_movie_id_idx ON watchlist (movie_id);
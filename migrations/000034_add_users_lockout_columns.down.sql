@@ -0,0 +1,3 @@
+ALTER TABLE users DROP COLUMN IF EXISTS locked_until;
+ALTER TABLE users DROP COLUMN IF EXISTS first_failed_login_at;
+ALTER TABLE users DROP COLUMN IF EXISTS failed_logins;
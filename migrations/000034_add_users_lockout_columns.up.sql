@@ -0,0 +1,5 @@
+-- Failed logins are counted on the user, within a window starting at the first of
+-- them, and lock the account until locked_until once there are too many.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS failed_logins integer NOT NULL DEFAULT 0;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS first_failed_login_at timestamp with time zone;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS locked_until timestamp with time zone;
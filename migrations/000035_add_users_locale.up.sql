@@ -0,0 +1,2 @@
+-- The language a user prefers responses in; empty until they choose one.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS locale text NOT NULL DEFAULT '';
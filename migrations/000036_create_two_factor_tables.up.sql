@@ -0,0 +1,20 @@
+-- The TOTP secret of a user's two-factor authentication, encrypted by the API. The row
+-- is created when 2FA is enabled and only enforced once confirmed with a code;
+-- last_step is the period of the last accepted code, so a code can't be used twice.
+CREATE TABLE IF NOT EXISTS two_factor (
+    user_id bigint PRIMARY KEY REFERENCES users ON DELETE CASCADE,
+    secret bytea NOT NULL,
+    confirmed boolean NOT NULL DEFAULT false,
+    last_step bigint NOT NULL DEFAULT 0,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW()
+);
+
+-- One-off recovery codes for users who lose their authenticator, stored as SHA-256
+-- hashes like tokens.
+CREATE TABLE IF NOT EXISTS recovery_codes (
+    hash bytea PRIMARY KEY,
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    used_at timestamp(0) with time zone
+);
+
+CREATE INDEX IF NOT EXISTS recovery_codes_user_id_idx ON recovery_codes (user_id);
@@ -0,0 +1,10 @@
+-- Confirmation tokens of two-phase destructive operations. A token is issued by the
+-- first call of an operation and is only good for the same user repeating the same
+-- request, as identified by the action and the fingerprint of its parameters, once.
+CREATE TABLE IF NOT EXISTS confirmations (
+    hash bytea PRIMARY KEY,
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    action text NOT NULL,
+    fingerprint bytea NOT NULL,
+    expiry timestamp(0) with time zone NOT NULL
+);
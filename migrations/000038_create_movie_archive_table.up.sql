@@ -0,0 +1,11 @@
+-- Movies deleted under the archive policy are kept here, as a snapshot of the movie
+-- with its reviews and translations taken just before it was deleted. There's no
+-- foreign key, since the movie no longer exists.
+CREATE TABLE IF NOT EXISTS movie_archive (
+    movie_id bigint PRIMARY KEY,
+    public_id text NOT NULL,
+    title text NOT NULL,
+    snapshot jsonb NOT NULL,
+    archived_by bigint REFERENCES users ON DELETE SET NULL,
+    archived_at timestamp(0) with time zone NOT NULL DEFAULT NOW()
+);
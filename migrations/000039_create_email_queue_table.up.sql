@@ -0,0 +1,20 @@
+-- Emails waiting to be sent, rendered when they were queued. The workers claim an email
+-- by moving its next_attempt_at forward, so an email claimed by a replica which then
+-- stopped is picked up again once that lease runs out. Emails which can't be sent are
+-- kept as dead letters.
+CREATE TABLE IF NOT EXISTS email_queue (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    recipient text NOT NULL,
+    template text NOT NULL,
+    subject text NOT NULL,
+    plain_body text NOT NULL,
+    html_body text NOT NULL,
+    status text NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'sent', 'dead')),
+    attempts integer NOT NULL DEFAULT 0,
+    next_attempt_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    last_error text NOT NULL DEFAULT '',
+    sent_at timestamp(0) with time zone
+);
+
+CREATE INDEX IF NOT EXISTS email_queue_pending_idx ON email_queue (next_attempt_at) WHERE status = 'pending';
@@ -0,0 +1,9 @@
+-- Daily totals of the business metrics, such as signups, summed across every replica.
+-- Each replica adds what it counted since its last rollup to the row of the current
+-- (UTC) day.
+CREATE TABLE IF NOT EXISTS business_metrics_daily (
+    day date NOT NULL,
+    metric text NOT NULL,
+    value bigint NOT NULL DEFAULT 0,
+    PRIMARY KEY (day, metric)
+);
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS movie_enrichment;
+DROP TABLE IF EXISTS movie_facts;
@@ -0,0 +1,22 @@
+-- Facts about movies which come from an external metadata provider, such as ratings
+-- and box office takings, or which curators set by hand. source says where each value
+-- came from: the name of the provider, or 'manual'. Manual values are never
+-- overwritten by the provider.
+CREATE TABLE IF NOT EXISTS movie_facts (
+    movie_id bigint NOT NULL REFERENCES movies ON DELETE CASCADE,
+    field text NOT NULL,
+    value numeric NOT NULL,
+    source text NOT NULL,
+    updated_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (movie_id, field)
+);
+
+-- When each movie was last looked up at the provider, and why the lookup failed if it
+-- did, so that the enrichment job knows which movies are due.
+CREATE TABLE IF NOT EXISTS movie_enrichment (
+    movie_id bigint PRIMARY KEY REFERENCES movies ON DELETE CASCADE,
+    checked_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    error text NOT NULL DEFAULT ''
+);
+
+CREATE INDEX IF NOT EXISTS movie_enrichment_checked_at_idx ON movie_enrichment (checked_at);
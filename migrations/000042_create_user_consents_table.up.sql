@@ -0,0 +1,13 @@
+-- Users' consents to the optional processing of their data, one row per change, so
+-- that there's a record of what each user agreed to and when. The current consent for
+-- a purpose is the row with the highest version; without one the server's default
+-- applies. policy_version is the version of the privacy policy in force at the time.
+CREATE TABLE IF NOT EXISTS user_consents (
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    purpose text NOT NULL,
+    version integer NOT NULL,
+    granted boolean NOT NULL,
+    policy_version text NOT NULL,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (user_id, purpose, version)
+);
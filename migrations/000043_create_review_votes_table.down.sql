@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS reviews_movie_id_helpfulness_idx;
+ALTER TABLE reviews DROP COLUMN IF EXISTS helpfulness;
+ALTER TABLE reviews DROP COLUMN IF EXISTS unhelpful_count;
+ALTER TABLE reviews DROP COLUMN IF EXISTS helpful_count;
+DROP TABLE IF EXISTS review_votes;
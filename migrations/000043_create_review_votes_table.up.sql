@@ -0,0 +1,17 @@
+-- Users' votes on whether reviews are helpful, at most one per user per review; a user
+-- who votes again changes their vote. The counts of each kind of vote, and the
+-- helpfulness score the reviews are sorted by, are kept on the reviews table and
+-- updated in the same transaction as the votes.
+CREATE TABLE IF NOT EXISTS review_votes (
+    review_id bigint NOT NULL REFERENCES reviews ON DELETE CASCADE,
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    helpful boolean NOT NULL,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (review_id, user_id)
+);
+
+ALTER TABLE reviews ADD COLUMN IF NOT EXISTS helpful_count integer NOT NULL DEFAULT 0;
+ALTER TABLE reviews ADD COLUMN IF NOT EXISTS unhelpful_count integer NOT NULL DEFAULT 0;
+ALTER TABLE reviews ADD COLUMN IF NOT EXISTS helpfulness double precision NOT NULL DEFAULT 0;
+
+CREATE INDEX IF NOT EXISTS reviews_movie_id_helpfulness_idx ON reviews (movie_id, helpfulness);
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS movies_custom_fields_idx;
+ALTER TABLE movies DROP COLUMN IF EXISTS custom_fields;
+DROP TABLE IF EXISTS movie_field_definitions;
@@ -0,0 +1,21 @@
+-- Custom fields of movies, such as the catalog numbers and notes of a film archive.
+-- The fields a deployment uses are defined by its admins in movie_field_definitions,
+-- and each movie keeps its values in the custom_fields object, keyed by field name.
+-- The values are checked against the definitions when movies are written.
+CREATE TABLE IF NOT EXISTS movie_field_definitions (
+    name text PRIMARY KEY,
+    type text NOT NULL CHECK (type IN ('string', 'number', 'boolean', 'date')),
+    description text NOT NULL DEFAULT '',
+    required boolean NOT NULL DEFAULT false,
+    max_length integer,
+    pattern text NOT NULL DEFAULT '',
+    minimum double precision,
+    maximum double precision,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    updated_at timestamp(0) with time zone NOT NULL DEFAULT NOW()
+);
+
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS custom_fields jsonb NOT NULL DEFAULT '{}';
+
+-- Serves the equality filters on custom fields, which are written as containment.
+CREATE INDEX IF NOT EXISTS movies_custom_fields_idx ON movies USING GIN (custom_fields jsonb_path_ops);
@@ -0,0 +1,46 @@
+-- When, and whether, each movie is available in a region, identified by its ISO 3166-1
+-- alpha-2 code. A movie without a row for a region isn't available there.
+CREATE TABLE IF NOT EXISTS movie_availability (
+    movie_id bigint NOT NULL REFERENCES movies ON DELETE CASCADE,
+    region text NOT NULL,
+    available boolean NOT NULL DEFAULT false,
+    release_date date,
+    updated_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (movie_id, region)
+);
+
+-- Organizations' subscriptions to the events of movies, delivered to their URL. Empty
+-- genres or regions match every movie. A subscription made with an API key is removed
+-- along with the key.
+CREATE TABLE IF NOT EXISTS webhook_subscriptions (
+    id bigserial PRIMARY KEY,
+    organization_id bigint NOT NULL REFERENCES organizations ON DELETE CASCADE,
+    api_key_id bigint REFERENCES api_keys ON DELETE CASCADE,
+    url text NOT NULL,
+    secret text NOT NULL,
+    events text[] NOT NULL,
+    genres text[] NOT NULL DEFAULT '{}',
+    regions text[] NOT NULL DEFAULT '{}',
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS webhook_subscriptions_organization_id_idx ON webhook_subscriptions (organization_id);
+
+-- Events waiting to be delivered to a subscription, with the payload rendered for it.
+-- Like the email_queue, deliveries are claimed by moving next_attempt_at forward, and
+-- the ones which can't be delivered are kept as dead letters.
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+    id bigserial PRIMARY KEY,
+    subscription_id bigint NOT NULL REFERENCES webhook_subscriptions ON DELETE CASCADE,
+    event text NOT NULL,
+    payload jsonb NOT NULL,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    status text NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'delivered', 'dead')),
+    attempts integer NOT NULL DEFAULT 0,
+    next_attempt_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    last_error text NOT NULL DEFAULT '',
+    delivered_at timestamp(0) with time zone
+);
+
+CREATE INDEX IF NOT EXISTS webhook_deliveries_pending_idx ON webhook_deliveries (next_attempt_at) WHERE status = 'pending';
+CREATE INDEX IF NOT EXISTS webhook_deliveries_subscription_id_idx ON webhook_deliveries (subscription_id, id);
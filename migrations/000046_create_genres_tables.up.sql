@@ -0,0 +1,32 @@
+-- Genres are rows of their own, linked to their movies through movies_genres, rather
+-- than an array on each movie. The position keeps a movie's genres in the order they
+-- were given.
+CREATE TABLE IF NOT EXISTS genres (
+    id bigserial PRIMARY KEY,
+    name text NOT NULL UNIQUE
+);
+
+CREATE TABLE IF NOT EXISTS movies_genres (
+    movie_id bigint NOT NULL REFERENCES movies ON DELETE CASCADE,
+    genre_id bigint NOT NULL REFERENCES genres ON DELETE CASCADE,
+    position smallint NOT NULL,
+    PRIMARY KEY (movie_id, genre_id)
+);
+
+CREATE INDEX IF NOT EXISTS movies_genres_genre_id_idx ON movies_genres (genre_id);
+
+-- Backfill both tables from the genres arrays, then drop the arrays.
+INSERT INTO genres (name)
+SELECT DISTINCT unnest(genres) FROM movies
+ON CONFLICT (name) DO NOTHING;
+
+INSERT INTO movies_genres (movie_id, genre_id, position)
+SELECT movies.id, genres.id, g.position
+FROM movies
+CROSS JOIN LATERAL unnest(movies.genres) WITH ORDINALITY AS g(name, position)
+INNER JOIN genres ON genres.name = g.name
+ON CONFLICT (movie_id, genre_id) DO NOTHING;
+
+DROP INDEX IF EXISTS movies_genres_idx;
+ALTER TABLE movies DROP CONSTRAINT IF EXISTS genres_length_check;
+ALTER TABLE movies DROP COLUMN IF EXISTS genres;
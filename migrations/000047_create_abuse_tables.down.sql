@@ -0,0 +1,3 @@
+ALTER TABLE reviews DROP COLUMN IF EXISTS hidden;
+DROP TABLE IF EXISTS account_restrictions;
+DROP TABLE IF EXISTS write_events;
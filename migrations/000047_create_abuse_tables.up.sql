@@ -0,0 +1,24 @@
+-- The writes of each user, such as reviews and movie submissions, counted to catch
+-- accounts writing faster than a person would. Kept for a day, see abuse.go.
+CREATE TABLE IF NOT EXISTS write_events (
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    kind text NOT NULL,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS write_events_user_id_kind_created_at_idx ON write_events (user_id, kind, created_at);
+
+-- Accounts restricted for writing too fast, or by an admin: held accounts can't write,
+-- and the reviews of shadow-limited ones are only shown to themselves. Exempt accounts
+-- are never restricted automatically.
+CREATE TABLE IF NOT EXISTS account_restrictions (
+    user_id bigint PRIMARY KEY REFERENCES users ON DELETE CASCADE,
+    status text NOT NULL CHECK (status IN ('held', 'shadow_limited', 'exempt')),
+    reason text NOT NULL DEFAULT '',
+    automatic boolean NOT NULL DEFAULT false,
+    created_by bigint REFERENCES users ON DELETE SET NULL,
+    updated_at timestamp(0) with time zone NOT NULL DEFAULT NOW()
+);
+
+-- Reviews of shadow-limited accounts, which don't count towards the movie's ratings.
+ALTER TABLE reviews ADD COLUMN IF NOT EXISTS hidden boolean NOT NULL DEFAULT false;
@@ -0,0 +1,21 @@
+-- The people who make movies, such as actors and directors.
+CREATE TABLE IF NOT EXISTS people (
+    id bigserial PRIMARY KEY,
+    name text NOT NULL,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW()
+);
+
+-- Who did what on each movie. Actors can play several characters in a movie, and
+-- people can have several roles, so a person's credit is unique by role and character.
+-- The credits of a movie are listed in billing order, by position.
+CREATE TABLE IF NOT EXISTS movie_credits (
+    id bigserial PRIMARY KEY,
+    movie_id bigint NOT NULL REFERENCES movies ON DELETE CASCADE,
+    person_id bigint NOT NULL REFERENCES people ON DELETE CASCADE,
+    role text NOT NULL CHECK (role IN ('actor', 'director', 'writer', 'producer', 'composer')),
+    character text NOT NULL DEFAULT '',
+    position integer NOT NULL DEFAULT 0,
+    UNIQUE (movie_id, person_id, role, character)
+);
+
+CREATE INDEX IF NOT EXISTS movie_credits_person_id_idx ON movie_credits (person_id);
@@ -0,0 +1,4 @@
+-- The movie's poster: its key in the storage, and the URL it's served from. Both are
+-- empty for movies without a poster.
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS poster_key text NOT NULL DEFAULT '';
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS poster_url text NOT NULL DEFAULT '';
@@ -0,0 +1,26 @@
+-- The in-app inbox of each user: the notifications delivered over the in_app channel,
+-- unread until the user marks them read. Kept for notificationRetention, see
+-- notifications.go.
+CREATE TABLE IF NOT EXISTS notifications (
+    id bigserial PRIMARY KEY,
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    type text NOT NULL,
+    subject text NOT NULL,
+    body text NOT NULL,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    read_at timestamp(0) with time zone
+);
+
+CREATE INDEX IF NOT EXISTS notifications_user_id_created_at_idx ON notifications (user_id, created_at);
+CREATE INDEX IF NOT EXISTS notifications_created_at_idx ON notifications (created_at);
+
+-- The channels users turned on or off for each type of notification. Channels without
+-- a row are on.
+CREATE TABLE IF NOT EXISTS notification_preferences (
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    type text NOT NULL,
+    channel text NOT NULL,
+    enabled boolean NOT NULL,
+    updated_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (user_id, type, channel)
+);
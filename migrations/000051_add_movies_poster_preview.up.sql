@@ -0,0 +1,3 @@
+-- What clients show while a movie's poster loads: its dominant colour, palette and
+-- blurhash. NULL for movies without a poster, or whose poster couldn't be decoded.
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS poster_preview jsonb;
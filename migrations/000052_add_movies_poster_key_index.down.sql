@@ -0,0 +1 @@
+DROP INDEX IF EXISTS movies_poster_key_idx;
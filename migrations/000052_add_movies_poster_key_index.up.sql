@@ -0,0 +1,3 @@
+-- Posters are keyed by their content, so movies can share one; a poster is only
+-- removed from the storage once no movie has its key.
+CREATE INDEX IF NOT EXISTS movies_poster_key_idx ON movies (poster_key) WHERE poster_key <> '';
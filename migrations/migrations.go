@@ -0,0 +1,11 @@
+// Package migrations embeds the SQL migrations of the database schema, so the API can
+// apply them itself; see internal/migrate.
+package migrations
+
+import "embed"
+
+// FS holds the migrations, named like 000001_create_movies_table.up.sql and
+// 000001_create_movies_table.down.sql as the migrate tool expects.
+//
+//go:embed *.sql
+var FS embed.FS